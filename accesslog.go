@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+)
+
+var accessLog = flag.Bool("web.access-log", false, "Log every request to the exporter, with its client, path, status, size and duration.")
+
+// statusRecorder records the status and size of the response written
+// through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// logRequests logs a line for each request served by h. Query values are
+// left out except for the target of /probe, sanitized, as they may carry
+// credentials.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		target := ""
+		if r.URL.Path == "/probe" {
+			target = sanitizeURI(r.URL.Query().Get("target"))
+		}
+		logger.Info("Request", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "target", target,
+			"status", lw.status, "bytes", lw.bytes, "duration", time.Since(start))
+	})
+}
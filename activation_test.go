@@ -0,0 +1,82 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// passListener hands l over the way systemd passes on sockets, returning
+// the file descriptor it is on.
+func passListener(t *testing.T, l net.Listener) int {
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	return fd
+}
+
+func TestActivationListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fd := passListener(t, l)
+
+	listeners, err := activationListeners(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("expected 1 listener, got %d", len(listeners))
+	}
+	if got, want := listeners[0].Addr().String(), l.Addr().String(); got != want {
+		t.Errorf("expected the passed on socket %s, got %s", want, got)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("expected the activation environment to be cleared")
+	}
+
+	server := &http.Server{Handler: healthzHandler()}
+	defer server.Close()
+	go server.Serve(listeners[0])
+	resp, err := http.Get("http://" + l.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 over the passed on socket, got %d", resp.StatusCode)
+	}
+}
+
+func TestActivationListenersOtherProcess(t *testing.T) {
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_PID")
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err := activationListeners(listenFDsStart)
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("expected sockets passed on to another process to be ignored, got %v, %v", listeners, err)
+	}
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "many")
+	if _, err := activationListeners(listenFDsStart); err == nil {
+		t.Error("expected an error for an invalid LISTEN_FDS")
+	}
+}
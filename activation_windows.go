@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "net"
+
+const listenFDsStart = 3
+
+// activationListeners returns no sockets, there being no systemd socket
+// activation on Windows.
+func activationListeners(first int) ([]net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+)
+
+var webAdminAddress = flag.String("web.admin-address", "", "Address to serve /healthz, /healthz/apache, /-/ready, /-/reload, /-/scrape, /-/config, /-/log-level, /-/maintenance, /api/v1/targets, /api/v1/status, /status, /raw, /debug/scrapes and /debug/pprof/ on, instead of -web.listen-address. May be unix:// followed by a socket path.")
+
+// isAdminPath reports whether path is one of the endpoints moved to
+// -web.admin-address.
+func isAdminPath(path string) bool {
+	switch path {
+	case "/healthz", "/healthz/apache", "/-/ready", "/-/reload", "/-/scrape", "/-/config", "/-/log-level", "/-/maintenance", "/api/v1/targets", "/api/v1/status", "/status", "/raw", "/debug/scrapes":
+		return true
+	}
+	return strings.HasPrefix(path, "/debug/pprof/")
+}
+
+// servePaths serves the requests to h for the admin endpoints if admin,
+// or for the others if not, and 404s the rest.
+func servePaths(h http.Handler, admin bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) != admin {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
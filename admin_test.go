@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestServePaths(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, path := range []string{"/metrics", "/probe", "/", "/healthz", "/healthz/apache", "/-/ready", "/-/reload", "/api/v1/targets", "/api/v1/status", "/status", "/raw", "/debug/scrapes", "/debug/pprof/"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	main, admin := servePaths(mux, false), servePaths(mux, true)
+	for _, c := range []struct {
+		path        string
+		main, admin int
+	}{
+		{"/metrics", 200, 404},
+		{"/probe", 200, 404},
+		{"/", 200, 404},
+		{"/healthz", 404, 200},
+		{"/healthz/apache", 404, 200},
+		{"/-/ready", 404, 200},
+		{"/-/reload", 404, 200},
+		{"/api/v1/targets", 404, 200},
+		{"/api/v1/status", 404, 200},
+		{"/status", 404, 200},
+		{"/raw", 404, 200},
+		{"/debug/scrapes", 404, 200},
+		{"/debug/pprof/heap", 404, 200},
+	} {
+		for _, s := range []struct {
+			name string
+			h    http.Handler
+			code int
+		}{{"main", main, c.main}, {"admin", admin, c.admin}, {"single", mux, 200}} {
+			rr := httptest.NewRecorder()
+			s.h.ServeHTTP(rr, httptest.NewRequest("GET", c.path, nil))
+			if rr.Code != s.code {
+				t.Errorf("%s on the %s server: expected %d, got %d", c.path, s.name, s.code, rr.Code)
+			}
+		}
+	}
+}
+
+func TestShutdownServers(t *testing.T) {
+	var servers []*http.Server
+	var urls []string
+	for i := 0; i < 2; i++ {
+		server, url := serveMetrics(t, newTargetSet(nil, nil))
+		servers = append(servers, server)
+		urls = append(urls, url)
+	}
+	if err := shutdown(context.Background(), servers, &readiness{}, nil, make(chan struct{}), &sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range urls {
+		if _, err := http.Get(url); err == nil {
+			t.Errorf("expected %s to be closed on shutdown", url)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// flagAliases maps the names flags had before they took the names other
+// exporters use to those names. The old names still work, with a warning.
+var flagAliases = map[string]string{
+	"scrape_uri":                 "scrape.uri",
+	"telemetry.address":          "web.listen-address",
+	"telemetry.endpoint":         "web.telemetry-path",
+	"telemetry.collectors":       "web.telemetry-collectors",
+	"telemetry.light-endpoint":   "web.telemetry-light-path",
+	"telemetry.light-collectors": "web.telemetry-light-collectors",
+}
+
+// aliasedFlag is a flag with a deprecated alias, recording the values it
+// is given to check them against the alias's.
+type aliasedFlag struct {
+	flag.Value
+	given []string
+}
+
+// String is that of the flag, empty for the zero value the help checks
+// defaults against.
+func (f *aliasedFlag) String() string {
+	if f.Value == nil {
+		return ""
+	}
+	return f.Value.String()
+}
+
+func (f *aliasedFlag) Set(value string) error {
+	f.given = append(f.given, value)
+	return f.Value.Set(value)
+}
+
+// aliasFlag is the deprecated name of a flag, setting the flag's value.
+type aliasFlag struct {
+	name, current string
+	flag          *aliasedFlag
+	given         []string
+}
+
+// String is empty, for the help to show the default of the flag only.
+func (f *aliasFlag) String() string {
+	return ""
+}
+
+func (f *aliasFlag) Set(value string) error {
+	f.given = append(f.given, value)
+	return f.flag.Value.Set(value)
+}
+
+// registerAliases adds the aliases of the flags of fs to it, returning them
+// by name.
+func registerAliases(fs *flag.FlagSet) map[string]*aliasFlag {
+	aliases := map[string]*aliasFlag{}
+	for name, current := range flagAliases {
+		f := fs.Lookup(current)
+		if f == nil {
+			continue
+		}
+		aliased := &aliasedFlag{Value: f.Value}
+		f.Value = aliased
+		a := &aliasFlag{name: name, current: current, flag: aliased}
+		fs.Var(a, name, "Deprecated, use -"+current+".")
+		aliases[name] = a
+	}
+	return aliases
+}
+
+// currentName returns the name the flag called name goes by now.
+func currentName(fs *flag.FlagSet, name string) string {
+	if f := fs.Lookup(name); f != nil {
+		if a, ok := f.Value.(*aliasFlag); ok {
+			return a.current
+		}
+	}
+	return name
+}
+
+// checkAliases fails if an alias and its flag were given different values,
+// and otherwise returns a warning for each alias used, sorted.
+func checkAliases(aliases map[string]*aliasFlag) ([]string, error) {
+	var warnings []string
+	for name, a := range aliases {
+		if len(a.given) == 0 {
+			continue
+		}
+		if len(a.flag.given) > 0 && strings.Join(a.given, ",") != strings.Join(a.flag.given, ",") {
+			return nil, fmt.Errorf("-%s and -%s, which replaces it, were given different values: %s and %s",
+				name, a.current, sanitizeURI(strings.Join(a.given, ",")), sanitizeURI(strings.Join(a.flag.given, ",")))
+		}
+		warnings = append(warnings, fmt.Sprintf("-%s is deprecated, use -%s instead", name, a.current))
+	}
+	sort.Strings(warnings)
+	return warnings, nil
+}
+
+// parseFlags parses args into fs, along with the deprecated aliases of its
+// flags and, for the flags args don't give, the environment variables in
+// environ. It returns the warnings to log about them once logging is set
+// up.
+func parseFlags(fs *flag.FlagSet, args, environ []string) ([]string, error) {
+	aliases := registerAliases(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	unknown, err := setFlagsFromEnv(fs, environ)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := checkAliases(aliases)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range unknown {
+		if isCollectorEnv(name) {
+			return nil, fmt.Errorf("%s switches an unknown collector, valid collectors are %s", name, strings.Join(config.Collectors, ", "))
+		}
+		warnings = append(warnings, "Ignoring "+name+", which sets no flag")
+	}
+	return warnings, nil
+}
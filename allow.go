@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	allowedCIDRs       = &targetsFlag{}
+	allowedCIDRsExempt = &targetsFlag{values: []string{"/healthz"}}
+	trustProxyHeaders  = flag.Bool("web.trust-proxy-headers", false, "Take the client address checked against -web.allowed-cidrs from the X-Forwarded-For header set by a proxy in front of the exporter.")
+)
+
+func init() {
+	flag.Var(allowedCIDRs, "web.allowed-cidrs", "Network, such as 10.0.0.0/8, that clients must be in, or they get a 403. May be repeated or comma separated. All are allowed if none is given.")
+	flag.Var(allowedCIDRsExempt, "web.allowed-cidrs.exempt", "Path served to clients outside -web.allowed-cidrs. May be repeated or comma separated.")
+}
+
+// allowNetworks serves requests to h only from clients in one of cidrs,
+// except for those to the exempt paths. With no cidrs, it returns h.
+func allowNetworks(cidrs []string, trustProxy bool, exempt []string, h http.Handler) (http.Handler, error) {
+	if len(cidrs) == 0 {
+		return h, nil
+	}
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			// A single address.
+			if ip := net.ParseIP(cidr); ip != nil {
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -web.allowed-cidrs %q: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	exemptPaths := map[string]bool{}
+	for _, path := range exempt {
+		exemptPaths[path] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exemptPaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r, trustProxy)
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		logger.Debug("Refusing request from outside -web.allowed-cidrs", "path", r.URL.Path, "remote", ip)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}), nil
+}
+
+// clientIP returns the address of the client making r. Trusting the proxy
+// in front, that is the last address in X-Forwarded-For, the one the proxy
+// added; addresses before it are whatever the client sent.
+func clientIP(r *http.Request, trustProxy bool) net.IP {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			return net.ParseIP(strings.TrimSpace(hops[len(hops)-1]))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowNetworks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", healthzHandler())
+	mux.Handle("/healthz", healthzHandler())
+	cidrs := []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"}
+	direct, err := allowNetworks(cidrs, false, []string{"/healthz"}, mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxied, err := allowNetworks(cidrs, true, []string{"/healthz"}, mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		h         http.Handler
+		path      string
+		remote    string
+		forwarded string
+		code      int
+	}{
+		{direct, "/metrics", "10.1.2.3:40000", "", http.StatusOK},
+		{direct, "/metrics", "[2001:db8::1]:40000", "", http.StatusOK},
+		{direct, "/metrics", "192.0.2.7:40000", "", http.StatusOK},
+		{direct, "/metrics", "192.0.2.8:40000", "", http.StatusForbidden},
+		{direct, "/metrics", "[2001:db9::1]:40000", "", http.StatusForbidden},
+		{direct, "/healthz", "192.0.2.8:40000", "", http.StatusOK},
+		// X-Forwarded-For is ignored unless the proxy is trusted.
+		{direct, "/metrics", "192.0.2.8:40000", "10.1.2.3", http.StatusForbidden},
+		{proxied, "/metrics", "192.0.2.8:40000", "10.1.2.3", http.StatusOK},
+		{proxied, "/metrics", "10.1.2.3:40000", "192.0.2.8", http.StatusForbidden},
+		// Only the address the proxy added counts, not what the client sent.
+		{proxied, "/metrics", "192.0.2.8:40000", "10.1.2.3, 192.0.2.8", http.StatusForbidden},
+		{proxied, "/metrics", "192.0.2.8:40000", "192.0.2.8, 10.1.2.3", http.StatusOK},
+		{proxied, "/metrics", "10.1.2.3:40000", "", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		rr := httptest.NewRecorder()
+		tc.h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s from %s forwarded for %q: expected %d, got %d", tc.path, tc.remote, tc.forwarded, tc.code, rr.Code)
+		}
+	}
+}
+
+func TestAllowNetworksErrors(t *testing.T) {
+	if _, err := allowNetworks([]string{"10.0.0.0/33"}, false, nil, healthzHandler()); err == nil {
+		t.Error("expected an error for an invalid network")
+	}
+	if _, err := allowNetworks([]string{"web01"}, false, nil, healthzHandler()); err == nil {
+		t.Error("expected an error for a host name")
+	}
+	h := healthzHandler()
+	if got, err := allowNetworks(nil, false, nil, h); err != nil || got == nil {
+		t.Errorf("expected the handler back without networks, got %v", err)
+	}
+}
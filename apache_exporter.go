@@ -1,86 +1,354 @@
 package main
 
 import (
-	"crypto/tls"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/log"
+	"github.com/yosefy/apache_exporter/config"
+	"github.com/yosefy/apache_exporter/version"
 )
 
-const (
-	namespace = "apache" // For Prometheus metrics.
+var (
+	metricsEndpoint = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	insecure        = flag.Bool("insecure", false, "Ignore server certificate if using https.")
+	maxBodySize     = flag.Int64("scrape.max-body-size", 16<<20, "Maximum size in bytes of the status page body read per scrape.")
+	failOnStartup   = flag.Bool("scrape.fail-on-startup", false, "Scrape once before serving metrics and exit if that scrape fails.")
+	workersLimit    = flag.Int("collector.workers.limit", 0, "Most workers apache runs, its MaxRequestWorkers, for exporting apache_workers_saturation. Unknown if 0.")
+	strictParse     = flag.Bool("scrape.strict-parse", false, "Fail the whole scrape if a field of the status page fails to parse, instead of leaving the field out.")
+	configFile      = flag.String("config.file", "", "YAML file listing the targets to scrape and their settings, instead of -scrape.uri.")
+	enableLifecycle = flag.Bool("web.enable-lifecycle", false, "Enable reloading the configuration by POSTing to /-/reload, forcing a background scrape with /-/scrape, showing the configuration, secrets redacted, on /-/config, changing the log level with PUT /-/log-level, and maintenance windows with POST and DELETE /-/maintenance.")
 )
 
-var (
-	listeningAddress = flag.String("telemetry.address", ":9117", "Address on which to expose metrics.")
-	metricsEndpoint  = flag.String("telemetry.endpoint", "/metrics", "Path under which to expose metrics.")
-	scrapeURI        = flag.String("scrape_uri", "http://localhost/server-status/?auto", "URI to apache stub status page.")
-	insecure         = flag.Bool("insecure", false, "Ignore server certificate if using https.")
+// Values of the reason label on the scrape failures counter.
+const (
+	reasonRequest               = "request"
+	reasonDialTimeout           = "dial_timeout"
+	reasonTLSHandshakeTimeout   = "tls_handshake_timeout"
+	reasonQUICHandshake         = "quic_handshake"
+	reasonResponseHeaderTimeout = "response_header_timeout"
+	reasonTimeout               = "timeout"
+	reasonStatus                = "status"
+	reasonStatusUnavailable     = "status_unavailable" // A 404 or 403.
+	reasonLogin                 = "login"              // Of form_auth.
+	reasonRead                  = "read"
+	reasonDecode                = "decode"
+	reasonBodyTooLarge          = "body_too_large"
+	reasonParse                 = "parse"
+	reasonPanic                 = "panic"
 )
 
+var failureReasons = []string{
+	reasonRequest,
+	reasonDialTimeout,
+	reasonTLSHandshakeTimeout,
+	reasonQUICHandshake,
+	reasonResponseHeaderTimeout,
+	reasonTimeout,
+	reasonStatus,
+	reasonStatusUnavailable,
+	reasonLogin,
+	reasonRead,
+	reasonDecode,
+	reasonBodyTooLarge,
+	reasonParse,
+	reasonPanic,
+}
+
+// scrapeError annotates a failed scrape with the reason it is counted under.
+type scrapeError struct {
+	reason string
+	err    error
+}
+
+func (e *scrapeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *scrapeError) Unwrap() error { return e.err }
+
+// Is makes the timeout reasons ErrTimeout.
+func (e *scrapeError) Is(target error) bool {
+	if target != ErrTimeout {
+		return false
+	}
+	switch e.reason {
+	case reasonDialTimeout, reasonTLSHandshakeTimeout, reasonResponseHeaderTimeout, reasonTimeout:
+		return true
+	}
+	return false
+}
+
+// failureReason returns the reason label for an error returned by collect.
+func failureReason(err error) string {
+	var se *scrapeError
+	if errors.As(err, &se) {
+		return se.reason
+	}
+	return reasonRequest
+}
+
 type Exporter struct {
-	URI    string
-	mutex  sync.RWMutex
-	client *http.Client
+	URI         string
+	name        string // Of the target, for scheduling background scrapes.
+	user        *url.Userinfo
+	conf        config.Target
+	labels      prometheus.Labels
+	client      *http.Client
+	policy      *targetPolicy // Of /probe targets, for clients of their own.
+	logger      *slog.Logger  // Carrying the target.
+	maxBodySize int64
+	collectors  map[string]bool // Groups of apache metrics exported, all if nil.
+	last        *lastScrape
+	failures    *failureLog
+	history     *scrapeHistory
+	breaker     *breaker
+	maintenance *maintenance
+	flightMutex sync.Mutex
+	flight      *flight // The latest scrape, for sharing its results.
+
+	// The counters of the exporter itself are kept across scrapes; every
+	// other metric is made afresh from the scrape it is collected with,
+	// so nothing of a previous scrape is ever exposed as current.
+	scrapeFailures *prometheus.CounterVec
+	targetFailures *prometheus.CounterVec // scrapeFailures, under the name asked for per target.
+	parseErrors    *prometheus.CounterVec
+	connections    *prometheus.CounterVec
+	formLogins     *prometheus.CounterVec
+	coalesced      prometheus.Counter
+	cacheHits      prometheus.Counter
+	restarts       prometheus.Counter
+	timeouts       *prometheus.CounterVec // Of the group collectors.
+	seen           *seenCounters
+	// Total Accesses kept going up across restarts, for the cluster sums.
+	clusterAccesses *monotonicCounter
+	fallbackURIs    []string
+	activeURI       *activeURI
+	formLogin       *formLogin // Of form_auth, nil without.
+	capabilities    *capabilities
+	degraded        *degradedMode
 
-	scrapeFailures prometheus.Counter
-	accessesTotal  prometheus.Counter
-	kBytesTotal    prometheus.Counter
-	uptime         prometheus.Counter
-	workers        *prometheus.GaugeVec
+	upDesc                *prometheus.Desc
+	durationDesc          *prometheus.Desc
+	phaseDesc             *prometheus.Desc
+	accessesDesc          *prometheus.Desc
+	kBytesDesc            *prometheus.Desc
+	totalDurationDesc     *prometheus.Desc
+	uptimeDesc            *prometheus.Desc
+	workersDesc           *prometheus.Desc
+	utilizationDesc       *prometheus.Desc
+	saturationDesc        *prometheus.Desc
+	collectorSuccessDesc  *prometheus.Desc
+	collectorDurationDesc *prometheus.Desc
+	fieldPresentDesc      *prometheus.Desc
+	dataAgeDesc           *prometheus.Desc
+	backoffDesc           *prometheus.Desc
+	maintenanceDesc       *prometheus.Desc
+	statusFieldDesc       *prometheus.Desc
+	processesDesc         *prometheus.Desc
+	configValueDesc       *prometheus.Desc
+	infoDesc              *prometheus.Desc
+	uriIndexDesc          *prometheus.Desc
+	statusEndpointDesc    *prometheus.Desc
+	degradedDesc          *prometheus.Desc
+	renamedDescs          []*prometheus.Desc // Of the corrected metrics, as in renamedMetrics.
+
+	accessRates      *rateWindow
+	durationRates    *rateWindow
+	requestRateDescs []*prometheus.Desc // By rateWindows.
+	durationDescs    []*prometheus.Desc // Of the average request durations, by rateWindows.
+	availability     *availability      // For targetWebhook, nil without one.
+	heartbeat        heartbeatDescs
+	resources        resourceDescs
 }
 
 func NewExporter(uri string) *Exporter {
-	return &Exporter{
-		URI: uri,
-		scrapeFailures: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "exporter_scrape_failures_total",
-			Help:      "Number of errors while scraping apache.",
-		}),
-		accessesTotal: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "accesses_total",
-			Help:      "Current total apache accesses",
+	return newExporter(uri, nil)
+}
+
+// newExporter creates an exporter for uri whose metrics all carry labels.
+func newExporter(uri string, labels prometheus.Labels) *Exporter {
+	uri, user := splitUserinfo(uri)
+	metricLabels := withConstLabels(labels)
+	e := &Exporter{
+		URI:              uri,
+		user:             user,
+		labels:           labels,
+		logger:           logger.With("target", sanitizeURI(uri)),
+		maxBodySize:      *maxBodySize,
+		phaseDesc:        newPhaseDurationDesc(metricLabels),
+		renamedDescs:     newRenamedDescs(metricLabels),
+		statusFieldDesc:  newStatusFieldDesc(metricLabels),
+		fieldPresentDesc: newFieldPresentDesc(metricLabels),
+		processesDesc:    newProcessConnectionsDesc(metricLabels),
+		configValueDesc:  newConfigValueDesc(metricLabels),
+		infoDesc:         newInfoDesc(metricLabels),
+		uriIndexDesc:     newURIIndexDesc(metricLabels),
+		accessRates:      &rateWindow{},
+		durationRates:    &rateWindow{},
+		requestRateDescs: newRequestRateDescs(metricLabels),
+		durationDescs:    newRequestDurationDescs(metricLabels),
+		heartbeat:        newHeartbeatDescs(metricLabels),
+		resources:        newResourceDescs(metricLabels),
+		last:             &lastScrape{},
+		failures:         &failureLog{interval: *failureSummaryInterval},
+		history:          newScrapeHistory(*scrapeHistorySize),
+		breaker:          newBreaker(*backoffAfter, *backoffFirst, *backoffMax),
+		maintenance:      &maintenance{},
+		scrapeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_scrape_failures_total",
+			Help:        "Number of errors while scraping apache.",
+			ConstLabels: metricLabels,
+		},
+			[]string{"reason"},
+		),
+		targetFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_target_scrape_failures_total",
+			Help:        "Number of errors while scraping the target.",
+			ConstLabels: metricLabels,
+		},
+			[]string{"reason"},
+		),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_parse_errors_total",
+			Help:        "Number of fields of the status page left out because they failed to parse.",
+			ConstLabels: metricLabels,
+		},
+			[]string{"field"},
+		),
+		connections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_scrape_connections_total",
+			Help:        "Number of connections used to scrape apache, by whether they were reused.",
+			ConstLabels: metricLabels,
+		},
+			[]string{"reused"},
+		),
+		formLogins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_form_logins_total",
+			Help:        "Number of logins with form_auth, by whether they were accepted.",
+			ConstLabels: metricLabels,
+		},
+			[]string{"result"},
+		),
+		coalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_coalesced_scrapes_total",
+			Help:        "Number of requests served the results of a scrape of apache done for another request.",
+			ConstLabels: metricLabels,
 		}),
-		kBytesTotal: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "sent_kilobytes_total",
-			Help:      "Current total kbytes sent",
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_cache_hits_total",
+			Help:        "Number of requests served the results of a scrape of apache that had already finished.",
+			ConstLabels: metricLabels,
 		}),
-		uptime: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "uptime_seconds_total",
-			Help:      "Current uptime in seconds",
+		restarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_apache_restarts_total",
+			Help:        "Number of times apache was seen to restart between scrapes, by its uptime or counters going down.",
+			ConstLabels: metricLabels,
 		}),
-		workers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "workers",
-			Help:      "Apache worker statuses",
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_collector_timeout_total",
+			Help:        "Number of times the group of apache metrics was cut off by the deadline of the scrape.",
+			ConstLabels: metricLabels,
 		},
-			[]string{"state"},
+			[]string{"collector"},
 		),
-		client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
-			},
-		},
+		seen:                  &seenCounters{},
+		clusterAccesses:       &monotonicCounter{},
+		activeURI:             &activeURI{},
+		capabilities:          &capabilities{},
+		degraded:              &degradedMode{},
+		statusEndpointDesc:    newStatusEndpointDesc(metricLabels),
+		degradedDesc:          newDegradedModeDesc(metricLabels),
+		collectorDurationDesc: newDesc("exporter_collector_duration_seconds", "Duration of the last run of the group of apache metrics that finished in time.", []string{"collector"}, metricLabels),
+		dataAgeDesc:           newDataAgeDesc(labels),
+		collectorSuccessDesc:  newDesc("exporter_collector_success", "Whether the group of apache metrics was collected in time and without a panic.", []string{"collector"}, metricLabels),
+		backoffDesc:           newDesc("exporter_target_backoff_seconds", "Seconds until a target that keeps failing is scraped again.", nil, metricLabels),
+		maintenanceDesc:       newDesc("maintenance", "Whether the target is in maintenance, its scrapes suspended.", nil, metricLabels),
+		upDesc:                newDesc("up", "Whether the last scrape of apache was successful.", nil, metricLabels),
+		durationDesc:          newDesc("exporter_target_scrape_duration_seconds", "Duration of the last scrape of apache.", nil, metricLabels),
+		accessesDesc:          newDesc("accesses_total", "Current total apache accesses", nil, metricLabels),
+		kBytesDesc:            newDesc("sent_kilobytes_total", "Current total kbytes sent", nil, metricLabels),
+		totalDurationDesc:     newDesc("duration_seconds_total", "Current total duration of apache requests in seconds", nil, metricLabels),
+		uptimeDesc:            newDesc("uptime_seconds_total", "Current uptime in seconds", nil, metricLabels),
+		workersDesc:           newDesc("workers", "Apache worker statuses", []string{"state"}, metricLabels),
+		utilizationDesc:       newDesc("workers_utilization", "Share of the busy and idle apache workers that are busy.", nil, metricLabels),
+		saturationDesc:        newDesc("workers_saturation", "Share of -collector.workers.limit apache workers that are busy.", nil, metricLabels),
+		client:                newHTTPClient(clientConfigFromFlags()),
 	}
+	if targetWebhook != nil {
+		e.availability = newAvailability(targetWebhook.after)
+	}
+	// Export every reason from the start, so targets that never failed
+	// have their failure series too.
+	for _, reason := range failureReasons {
+		e.scrapeFailures.WithLabelValues(reason)
+		e.targetFailures.WithLabelValues(reason)
+	}
+	return e
+}
+
+// newDesc describes the metric name, in the namespace.
+func newDesc(name, help string, variableLabels []string, labels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, variableLabels, labels)
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.scrapeFailures.Describe(ch)
-	e.accessesTotal.Describe(ch)
-	e.kBytesTotal.Describe(ch)
-	e.uptime.Describe(ch)
-	e.workers.Describe(ch)
+	e.targetFailures.Describe(ch)
+	e.parseErrors.Describe(ch)
+	e.connections.Describe(ch)
+	e.formLogins.Describe(ch)
+	e.coalesced.Describe(ch)
+	e.cacheHits.Describe(ch)
+	e.restarts.Describe(ch)
+	e.timeouts.Describe(ch)
+	for _, desc := range []*prometheus.Desc{e.upDesc, e.durationDesc, e.phaseDesc, e.accessesDesc, e.kBytesDesc, e.totalDurationDesc, e.uptimeDesc, e.workersDesc, e.utilizationDesc, e.saturationDesc, e.collectorSuccessDesc, e.collectorDurationDesc, e.dataAgeDesc, e.backoffDesc, e.maintenanceDesc, e.statusFieldDesc, e.fieldPresentDesc, e.processesDesc, e.configValueDesc, e.infoDesc, e.uriIndexDesc, e.statusEndpointDesc, e.degradedDesc} {
+		ch <- desc
+	}
+	for _, desc := range e.renamedDescs {
+		ch <- desc
+	}
+	for _, desc := range e.requestRateDescs {
+		ch <- desc
+	}
+	for _, desc := range e.durationDescs {
+		ch <- desc
+	}
+	for _, desc := range []*prometheus.Desc{e.heartbeat.ready, e.heartbeat.busy, e.heartbeat.lastSeen} {
+		ch <- desc
+	}
+	for _, desc := range []*prometheus.Desc{e.resources.rss, e.resources.fds, e.resources.cpu, e.resources.totalRSS, e.resources.totalFDs, e.resources.totalCPU} {
+		ch <- desc
+	}
 }
 
 // Split colon separated string into two fields
@@ -90,100 +358,1154 @@ func splitkv(s string) (string, string) {
 		return s, s
 	}
 
-	slice := strings.SplitN(s, ":", 2)
+	i := strings.IndexByte(s, ':')
 
-	if len(slice) == 1 {
-		return slice[0], ""
+	if i < 0 {
+		return s, ""
 	}
 
-	return strings.TrimSpace(slice[0]), strings.TrimSpace(slice[1])
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
 }
 
-func (e *Exporter) collect(ch chan<- prometheus.Metric) error {
-	resp, err := e.client.Get(e.URI)
+// statusBuffers hold the status pages being read, for scrapes to reuse
+// rather than grow a buffer for every page.
+var statusBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// readBody reads at most limit bytes from r into buf, failing if the body
+// is larger. The data is buf's, valid until it is next used.
+func readBody(buf *bytes.Buffer, r io.Reader, limit int64) ([]byte, error) {
+	buf.Reset()
+	_, err := buf.ReadFrom(io.LimitReader(r, limit+1))
+	data := buf.Bytes()
 	if err != nil {
-		return fmt.Errorf("Error scraping apache: %v", err)
+		if isTimeout(err) {
+			return nil, &scrapeError{reasonTimeout, err}
+		}
+		return nil, &scrapeError{reasonRead, err}
+	}
+	if int64(len(data)) > limit {
+		return nil, &scrapeError{reasonBodyTooLarge, fmt.Errorf("body too large: exceeds %d bytes", limit)}
+	}
+	return data, nil
+}
+
+// decodeBody wraps the response body according to its Content-Encoding.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	switch enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// "deflate" is meant to be zlib wrapped, but some servers send
+		// raw deflate data, so look at the header before deciding.
+		br := bufio.NewReader(resp.Body)
+		if hdr, err := br.Peek(2); err == nil && hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", enc)
+	}
+}
+
+// readResponse decodes and reads the response body. The size limit applies
+// to the decoded data so a small compressed body can't expand without bound.
+func readResponse(resp *http.Response, buf *bytes.Buffer, limit int64) ([]byte, error) {
+	body, err := decodeBody(resp)
+	if err != nil {
+		return nil, &scrapeError{reasonDecode, err}
+	}
+	data, err := readBody(buf, body, limit)
+	var se *scrapeError
+	if errors.As(err, &se) && se.reason == reasonRead && body != resp.Body {
+		se.reason = reasonDecode
+	}
+	return data, err
+}
+
+// setHeaders sets the headers the target is configured with on req.
+func (e *Exporter) setHeaders(req *http.Request) {
+	for name, value := range e.conf.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
 	}
+}
 
-	data, err := ioutil.ReadAll(resp.Body)
+// fetchPage gets u with the credentials and headers of e, for the group
+// collectors fetching a page of their own, within ctx and traced as a
+// child of its span. The response is nil, and so is the error, if there is
+// no such page.
+func (e *Exporter) fetchPage(ctx context.Context, u *url.URL) (resp *http.Response, data []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, nil, sanitizeError(err)
+	}
+	if err := e.authorize(req); err != nil {
+		return nil, nil, markError(fmt.Errorf("Error reading credentials: %w", err), ErrAuth)
+	}
+	e.setHeaders(req)
+	phases := &phaseTimer{}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, phases.trace()))
+	_, request := startSpan(ctx, "GET")
+	if request != nil {
+		request.kind = spanKindClient
+		request.setAttribute("http.request.method", "GET")
+		request.setAttribute("url.full", sanitizeURI(u.String()))
+	}
+	defer func() {
+		phases.annotate(request)
+		request.end(err)
+	}()
+	resp, err = e.client.Do(req)
+	if err != nil {
+		return nil, nil, markError(sanitizeError(err), ErrUnreachable)
+	}
+	request.setAttribute("http.response.status_code", strconv.Itoa(resp.StatusCode))
+	data, err = readResponse(resp, new(bytes.Buffer), e.maxBodySize)
 	resp.Body.Close()
+	phases.readDone()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, nil, &HTTPStatusError{Code: resp.StatusCode, Status: resp.Status, Path: u.Path}
+	case err != nil:
+		return nil, nil, err
+	}
+	return resp, data, nil
+}
+
+// newStatusRequest returns the request for the status page at uri, with
+// the credentials and headers of e.
+func (e *Exporter) newStatusRequest(ctx context.Context, uri string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, &scrapeError{reasonRequest, fmt.Errorf("Error scraping apache: %w", sanitizeError(err))}
+	}
+	if err := e.authorize(req); err != nil {
+		return nil, &scrapeError{reasonRequest, markError(fmt.Errorf("Error reading credentials: %w", err), ErrAuth)}
+	}
+	e.setHeaders(req)
+	// Setting Accept-Encoding ourselves turns off the transport's
+	// transparent gzip support, readResponse does the decoding instead.
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	return req, nil
+}
+
+// requestStatus gets the status page from uri, timing the phases of the
+// request with phases, logging in with form_auth when asked to, logins
+// counting the logins of the scrape. The span of the request is left to
+// end once its body is read.
+func (e *Exporter) requestStatus(ctx context.Context, uri string, phases *phaseTimer, logins *int) (*http.Response, *span, error) {
+	req, err := e.newStatusRequest(ctx, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	trace := phases.trace()
+	trace.GotConn = func(info httptrace.GotConnInfo) {
+		e.logger.Debug("Scraping apache", "remote", info.Conn.RemoteAddr(), "local", info.Conn.LocalAddr(), "reused", info.Reused)
+		e.connections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		phases.gotConn(info.Conn.LocalAddr())
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	_, request := startSpan(ctx, "GET")
+	if request != nil {
+		request.kind = spanKindClient
+		request.setAttribute("http.request.method", "GET")
+		request.setAttribute("url.full", sanitizeURI(uri))
+	}
+	// The cookie jar of form_auth adds its cookies to the request sent;
+	// req is kept without them, to be sent again after logging in.
+	resp, err := e.client.Do(req.Clone(req.Context()))
+	if err == nil && e.formLogin != nil {
+		resp, err = e.relogin(ctx, req, resp, logins)
+	}
+	var loginErr *scrapeError
+	if err != nil && !errors.As(err, &loginErr) {
+		err = &scrapeError{requestFailureReason(err, phases.snapshot()), markError(fmt.Errorf("Error scraping apache: %w", sanitizeError(err)), ErrUnreachable)}
+	}
+	if err != nil {
+		phases.annotate(request)
+		request.end(err)
+		return nil, nil, err
+	}
+	return resp, request, nil
+}
+
+// collect scrapes apache, timing the phases of the request with phases,
+// and sends the apache metrics to ch.
+func (e *Exporter) collect(ctx context.Context, phases *phaseTimer, ch chan<- prometheus.Metric) error {
+	start := time.Now()
+	grouped := false
+	// Panics included, the groups fail along with the status page.
+	defer func() {
+		if !grouped {
+			e.failGroups(ch)
+		}
+	}()
+	// Connection failures fall back on the next URI, while the scrape
+	// timeout lasts; an HTTP error is apache's answer and isn't retried.
+	fetchCtx, cancel := e.fallbackContext(ctx, start)
+	defer cancel()
+	var resp *http.Response
+	var request *span
+	var used, logins int
+	uris := e.scrapeURIs()
+	for i, uri := range uris {
+		// Each attempt has a timer of its own, the dials of the previous
+		// ones possibly still going; phases gets the one of the attempt
+		// answered, or of the last one.
+		attempt := &phaseTimer{}
+		var err error
+		resp, request, err = e.requestStatus(fetchCtx, uri, attempt, &logins)
+		if err == nil {
+			used = i
+			phases.take(attempt)
+			break
+		}
+		if i == len(uris)-1 || fetchCtx.Err() != nil {
+			phases.take(attempt)
+			return err
+		}
+		e.logger.Debug("Error scraping apache, trying the next uri", "uri", sanitizeURI(uri), "err", err)
+	}
+
+	buf := statusBuffers.Get().(*bytes.Buffer)
+	defer statusBuffers.Put(buf)
+	data, err := readResponse(resp, buf, e.maxBodySize)
+	resp.Body.Close()
+	phases.readDone()
+	phases.annotate(request)
+	request.setAttribute("http.response.status_code", strconv.Itoa(resp.StatusCode))
+	available := 1.0
+	if statusUnavailable(resp.StatusCode) {
+		available = 0
+	}
+	ch <- prometheus.MustNewConstMetric(e.statusEndpointDesc, prometheus.GaugeValue, available)
+	if resp.StatusCode == http.StatusServiceUnavailable && *degradedEnterRatio > 0 {
+		e.degraded.overloaded(e.logger)
+	}
 	if resp.StatusCode != 200 {
 		if err != nil {
 			data = []byte(err.Error())
 		}
-		return fmt.Errorf("Status %s (%d): %s", resp.Status, resp.StatusCode, data)
+		statusErr := &HTTPStatusError{Code: resp.StatusCode, Status: resp.Status, Body: string(data)}
+		reason := reasonStatus
+		if available == 0 {
+			statusErr.Hint = unavailableHint(resp, data, phases.snapshot().local)
+			reason = reasonStatusUnavailable
+		}
+		err := &scrapeError{reason, statusErr}
+		request.end(err)
+		return err
 	}
+	request.end(err)
+	if err != nil {
+		return err
+	}
+	e.usedURI(used, ch)
 
-	lines := strings.Split(string(data), "\n")
+	// Parse the whole page before exporting anything.
+	status, err := e.parseStatus(resp, data)
+	if err != nil {
+		return err
+	}
+	values := status.values
+	e.last.setStatus(newServerStatus(values, status.scoreboard))
+	if v, ok := values["Total Accesses"]; ok {
+		e.clusterAccesses.observe(v)
+	}
+	if ratio, ok := busyRatio(values); ok && *degradedEnterRatio > 0 {
+		e.degraded.observe(e.logger, ratio)
+	}
+	if e.seen.observe(values) {
+		e.restarts.Inc()
+		e.capabilities.reset()
+		e.logger.Info("Apache restarted since the previous scrape", "uptime", values["Uptime"])
+	}
+	// Missing fields are logged when found missing, not on every scrape.
+	if e.capabilities.detectFields(status.present, time.Now()) {
+		logMissingFields(e.logger, *flavorHint, values, status.scoreboard != "")
+	}
+	e.logger.Debug("Parsed the status page", "fields", len(values), "bytes", len(data))
+	if *exportUnknownFields {
+		for name, value := range status.unknown {
+			ch <- prometheus.MustNewConstMetric(e.statusFieldDesc, prometheus.GaugeValue, value, name)
+		}
+	}
+	e.collectDerived(status, ch)
+	if *fieldPresence {
+		status.present.collect(ch, e.fieldPresentDesc)
+	}
+	if version, mpm := serverInfo(status.version, status.mpm); version != "" || mpm != "" {
+		ch <- prometheus.MustNewConstMetric(e.infoDesc, prometheus.GaugeValue, 1, version, mpm)
+	}
 
-	for _, l := range lines {
+	grouped = true
+	return e.collectGroups(ctx, e.groupDeadline(ctx, start), values, ch)
+}
+
+// parsedStatus is what parseStatus took off a status page.
+type parsedStatus struct {
+	values     map[string]float64 // Of the fields the collectors take.
+	scoreboard string
+	unknown    map[string]float64 // With -status.export-unknown-fields or derived metrics.
+	present    fieldSet           // Of presenceFields.
+	version    string             // ServerVersion.
+	mpm        string             // ServerMPM.
+}
+
+// parseStatus parses the ?auto status page data of resp into the values
+// of the fields the collectors take, the scoreboard and, with
+// -status.export-unknown-fields or derived metrics, the other numeric
+// fields. A field that fails to parse is left out, unless
+// -scrape.strict-parse fails the scrape; a page without BusyWorkers or
+// IdleWorkers fails it regardless.
+func (e *Exporter) parseStatus(resp *http.Response, data []byte) (parsedStatus, error) {
+	status := parsedStatus{values: make(map[string]float64)}
+	values := status.values
+	// One copy of the page for its fields to be substrings of, scanned
+	// line by line in place.
+	page := string(data)
+	var parseErr, coreErr error
+	if *exportUnknownFields || len(currentDerivedMetrics()) > 0 {
+		status.unknown = map[string]float64{}
+	}
+
+	for line := 1; len(page) > 0; line++ {
+		l := page
+		if i := strings.IndexByte(page, '\n'); i >= 0 {
+			l, page = page[:i], page[i+1:]
+		} else {
+			page = ""
+		}
 		key, v := splitkv(l)
+		status.present.add(key)
 
-		switch {
-		case key == "Total Accesses":
+		switch key {
+		case "Total Accesses", "Total kBytes", "Total Duration", "Uptime", "BusyWorkers", "IdleWorkers":
 			val, err := strconv.ParseFloat(v, 64)
 			if err != nil {
-				return err
+				if parseErr == nil && *dumpDir != "" {
+					if derr := dumpBody(*dumpDir, e.name, resp, data, err, *dumpMaxFiles, *dumpMaxBytes); derr != nil {
+						e.logger.Error("Error dumping the status page", "err", derr)
+					}
+				}
+				perr := &ParseError{Line: line, Field: key, Err: err}
+				if *strictParse {
+					return parsedStatus{}, &scrapeError{reasonParse, perr}
+				}
+				if parseErr == nil {
+					parseErr = perr
+				}
+				if coreErr == nil && (key == "BusyWorkers" || key == "IdleWorkers") {
+					coreErr = perr
+				}
+				e.parseErrors.WithLabelValues(key).Inc()
+				e.logger.Warn("Leaving out a field of the status page that failed to parse", "field", key, "err", err)
+				continue
 			}
 
-			e.accessesTotal.Set(val)
-			e.accessesTotal.Collect(ch)
-		case key == "Total kBytes":
-			val, err := strconv.ParseFloat(v, 64)
-			if err != nil {
-				return err
+			values[key] = val
+		case "Scoreboard":
+			status.scoreboard = v
+		case "ServerVersion":
+			status.version = v
+		case "ServerMPM":
+			status.mpm = v
+		default:
+			if status.unknown != nil {
+				parseUnknownField(status.unknown, key, v)
 			}
+		}
+	}
+	// Without the workers, what is left of the page is of no use; the
+	// failure is theirs, else the first of the page.
+	for _, key := range []string{"BusyWorkers", "IdleWorkers"} {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if coreErr == nil {
+			coreErr = parseErr
+		}
+		if coreErr == nil {
+			coreErr = fmt.Errorf("no %s field on the status page", key)
+		}
+		return parsedStatus{}, &scrapeError{reasonParse, coreErr}
+	}
+	return status, nil
+}
 
-			e.kBytesTotal.Set(val)
-			e.kBytesTotal.Collect(ch)
-		case key == "Uptime":
-			val, err := strconv.ParseFloat(v, 64)
-			if err != nil {
-				return err
-			}
+// groupDeadline returns when the group collectors of a scrape started at
+// start are given up on: at the end of ctx or the scrape timeout, whichever
+// comes first. It is zero if neither is set.
+func (e *Exporter) groupDeadline(ctx context.Context, start time.Time) time.Time {
+	deadline, _ := ctx.Deadline()
+	if t := e.client.Timeout; t > 0 && (deadline.IsZero() || start.Add(t).Before(deadline)) {
+		deadline = start.Add(t)
+	}
+	return deadline
+}
 
-			e.uptime.Set(val)
-			e.uptime.Collect(ch)
-		case key == "BusyWorkers":
-			val, err := strconv.ParseFloat(v, 64)
-			if err != nil {
-				return err
+// groupResult is what a group collector delivered.
+type groupResult struct {
+	metrics  []prometheus.Metric
+	err      error // A panic.
+	fetchErr error
+	duration time.Duration
+}
+
+// collectGroups runs the group collectors of e, each on its own, and sends
+// the metrics of those done by deadline to ch, in the order of
+// groupCollectors. Whether each was is exported as
+// apache_exporter_collector_success, and how long it took as
+// apache_exporter_collector_duration_seconds; the ones cut off are left
+// running until their fetches are cancelled, their metrics dropped and
+// counted in apache_exporter_collector_timeout_total. The first panic of
+// a collector is returned.
+func (e *Exporter) collectGroups(ctx context.Context, deadline time.Time, values map[string]float64, ch chan<- prometheus.Metric) error {
+	// Fetches of the groups cut off, or still running when the scrape
+	// ends, are cancelled.
+	var cancel context.CancelFunc
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	defer cancel()
+	results := map[string]chan groupResult{}
+	degraded := e.degraded.isActive()
+	for _, c := range groupCollectors {
+		if e.collects(c.name) && !(degraded && c.requests) {
+			result := make(chan groupResult, 1)
+			results[c.name] = result
+			e.timeouts.WithLabelValues(c.name)
+			runCtx, run := startSpan(ctx, "collector")
+			run.setAttribute("apache.collector", c.name)
+			go func(ctx context.Context, c groupCollector, run *span) { result <- e.runGroup(ctx, c, values, run) }(runCtx, c, run)
+		}
+	}
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var failed error
+	cutOff := false
+	for _, c := range groupCollectors {
+		result, ok := results[c.name]
+		if !ok {
+			continue
+		}
+		var r groupResult
+		done := false
+		if !cutOff {
+			select {
+			case r = <-result:
+				done = true
+			case <-expired:
+				cutOff = true
+			}
+		} else {
+			// Past the deadline, only the ones already done count.
+			select {
+			case r = <-result:
+				done = true
+			default:
+			}
+		}
+		if !done {
+			e.logger.Warn("Collector cut off by the deadline", "collector", c.name, "deadline", deadline)
+			e.timeouts.WithLabelValues(c.name).Inc()
+			ch <- prometheus.MustNewConstMetric(e.collectorSuccessDesc, prometheus.GaugeValue, 0, c.name)
+			continue
+		}
+		for _, m := range r.metrics {
+			ch <- m
+		}
+		success := 1.0
+		if r.err != nil {
+			success = 0
+			if failed == nil {
+				failed = r.err
 			}
+		}
+		if r.fetchErr != nil {
+			success = 0
+			e.logger.Warn("Error collecting apache metrics", "collector", c.name, "err", r.fetchErr)
+		}
+		ch <- prometheus.MustNewConstMetric(e.collectorSuccessDesc, prometheus.GaugeValue, success, c.name)
+		ch <- prometheus.MustNewConstMetric(e.collectorDurationDesc, prometheus.GaugeValue, r.duration.Seconds(), c.name)
+	}
+	return failed
+}
 
-			e.workers.WithLabelValues("busy").Set(val)
-		case key == "IdleWorkers":
-			val, err := strconv.ParseFloat(v, 64)
-			if err != nil {
-				return err
+// failGroups exports the group collectors of e as failed, for a scrape
+// that didn't get to run them.
+func (e *Exporter) failGroups(ch chan<- prometheus.Metric) {
+	for _, c := range groupCollectors {
+		if e.collects(c.name) {
+			ch <- prometheus.MustNewConstMetric(e.collectorSuccessDesc, prometheus.GaugeValue, 0, c.name)
+		}
+	}
+}
+
+// runGroup runs the group collector c, gathering what it sends, and ends
+// the span of the run. The pages c fetches are fetched within ctx.
+func (e *Exporter) runGroup(ctx context.Context, c groupCollector, values map[string]float64, run *span) groupResult {
+	start := time.Now()
+	ch := make(chan prometheus.Metric)
+	gathered := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		gathered <- metrics
+	}()
+	var fetchErr error
+	err := recoverPanic(c.name, func() {
+		if c.fetch != nil {
+			fetchErr = c.fetch(e, ctx, ch)
+		} else {
+			c.collect(e, values, ch)
+		}
+		e.collectRenamed(c.name, values, ch)
+	})
+	close(ch)
+	r := groupResult{<-gathered, err, fetchErr, time.Since(start)}
+	if err == nil {
+		err = fetchErr
+	}
+	run.end(err)
+	e.logger.Debug("Collected apache metrics", "collector", c.name, "duration", r.duration)
+	return r
+}
+
+// groupCollector exports a group of apache metrics from the values parsed
+// off the status page, or with fetch from a page of its own. A failing
+// fetch fails the group but not the scrape.
+type groupCollector struct {
+	name    string
+	off     bool // Not exported unless -collector.<name> is given, for costly groups.
+	collect func(e *Exporter, values map[string]float64, ch chan<- prometheus.Metric)
+	fetch   func(e *Exporter, ctx context.Context, ch chan<- prometheus.Metric) error
+
+	// requests tells that fetch requests more pages of apache, which it
+	// doesn't while the target is degraded, per -scrape.degraded.enter-ratio.
+	requests bool
+}
+
+var groupCollectors = []groupCollector{
+	{name: "accesses", collect: func(e *Exporter, values map[string]float64, ch chan<- prometheus.Metric) {
+		if val, ok := values["Total Accesses"]; ok {
+			ch <- prometheus.MustNewConstMetric(e.accessesDesc, prometheus.CounterValue, val)
+			if *requestRates {
+				e.collectRequestRates(values, ch)
 			}
+		}
+		if val, ok := values["Total Duration"]; ok {
+			// Total Duration is in milliseconds.
+			ch <- prometheus.MustNewConstMetric(e.totalDurationDesc, prometheus.CounterValue, val/1000)
+		}
+	}},
+	{name: "traffic", collect: func(e *Exporter, values map[string]float64, ch chan<- prometheus.Metric) {
+		if val, ok := values["Total kBytes"]; ok && compatMode.legacy() {
+			ch <- prometheus.MustNewConstMetric(e.kBytesDesc, prometheus.CounterValue, val)
+		}
+	}},
+	{name: "uptime", collect: func(e *Exporter, values map[string]float64, ch chan<- prometheus.Metric) {
+		if val, ok := values["Uptime"]; ok && compatMode.legacy() {
+			ch <- prometheus.MustNewConstMetric(e.uptimeDesc, prometheus.CounterValue, val)
+		}
+	}},
+	{name: "workers", collect: func(e *Exporter, values map[string]float64, ch chan<- prometheus.Metric) {
+		if val, ok := values["BusyWorkers"]; ok {
+			ch <- prometheus.MustNewConstMetric(e.workersDesc, prometheus.GaugeValue, val, "busy")
+		}
+		if val, ok := values["IdleWorkers"]; ok {
+			ch <- prometheus.MustNewConstMetric(e.workersDesc, prometheus.GaugeValue, val, "idle")
+		}
+		busy, hasBusy := values["BusyWorkers"]
+		idle, hasIdle := values["IdleWorkers"]
+		// Left out rather than NaN without any workers to divide by.
+		if hasBusy && hasIdle && busy+idle > 0 {
+			ch <- prometheus.MustNewConstMetric(e.utilizationDesc, prometheus.GaugeValue, busy/(busy+idle))
+		}
+		if hasBusy && *workersLimit > 0 {
+			ch <- prometheus.MustNewConstMetric(e.saturationDesc, prometheus.GaugeValue, busy/float64(*workersLimit))
+		}
+	}},
+	{name: "heartbeat", off: true, requests: true, fetch: (*Exporter).collectHeartbeat},
+	{name: "processes", off: true, requests: true, fetch: (*Exporter).collectProcesses},
+	{name: "config", off: true, fetch: (*Exporter).collectConfigValues},
+	{name: "resources", off: true, fetch: (*Exporter).collectResources},
+}
 
-			e.workers.WithLabelValues("idle").Set(val)
+// collects tells whether the group of apache metrics named name is
+// exported, which it isn't anywhere if switched off by its flag.
+func (e *Exporter) collects(name string) bool {
+	return collectorEnabled[name] && (e.collectors == nil || e.collectors[name])
+}
+
+// scrape runs one collection, sending the apache metrics to ch and
+// recording the outcome in the failure counters and the last scrape. It
+// returns how long the scrape took.
+func (e *Exporter) scrape(ctx context.Context, phases *phaseTimer, ch chan<- prometheus.Metric) (time.Duration, error) {
+	start := time.Now()
+	var err error
+	if perr := recoverPanic("status", func() { err = e.collect(ctx, phases, ch) }); perr != nil {
+		err = perr
+	}
+	duration := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		// The client asking for metrics went away, which says nothing
+		// about apache.
+		e.logger.Debug("Scrape of apache cancelled", "err", err)
+		return duration, err
+	}
+	e.last.set(start, duration, err)
+	if e.history != nil {
+		var status *serverStatus
+		if err == nil {
+			status = e.last.serverStatus()
 		}
+		e.history.add(start, duration, err, status)
+	}
+	if e.availability != nil {
+		e.notifyAvailability(start, err)
 	}
+	if backoff := e.breaker.record(start, err); backoff > 0 {
+		e.logger.Warn("Backing off from a failing target", "backoff", backoff)
+	}
+	if err != nil {
+		e.failures.failed(e.logger, start, err, "reason", failureReason(err), "duration", duration)
+		e.scrapeFailures.WithLabelValues(failureReason(err)).Inc()
+		e.targetFailures.WithLabelValues(failureReason(err)).Inc()
+	} else {
+		e.failures.succeeded(e.logger, start)
+	}
+	return duration, err
+}
 
-	e.workers.Collect(ch)
+// Warmup scrapes apache once, discarding the metrics but keeping the
+// outcome in the failure counters and the last scrape.
+func (e *Exporter) Warmup() error {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for range ch {
+		}
+	}()
+	defer close(ch)
 
-	return nil
+	_, err := e.scrape(context.Background(), &phaseTimer{}, ch)
+	return err
 }
 
+// Collect scrapes apache every time, unlike collectContext.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.mutex.Lock() // To protect metrics from concurrent collects.
-	defer e.mutex.Unlock()
-	if err := e.collect(ch); err != nil {
-		log.Printf("Error scraping apache: %s", err)
-		e.scrapeFailures.Inc()
+	e.collectTarget(context.Background(), ch)
+}
+
+// collectContext is Collect with the scrape of apache bound to ctx, which
+// is shared with concurrent collects.
+func (e *Exporter) collectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.sharedCollect(ctx, ch)
+}
+
+// collectTarget is collectContext returning the scrape's error. Concurrent
+// calls scrape apache each, unless the target is being backed off from.
+func (e *Exporter) collectTarget(ctx context.Context, ch chan<- prometheus.Metric) error {
+	ctx, span := startSpan(ctx, "scrape")
+	span.setAttribute("apache.target", e.name)
+	if !e.maintenanceUntil(time.Now()).IsZero() {
+		e.collectMaintenance(ch)
+		span.setAttribute("apache.maintenance", "true")
+		span.end(nil)
+		return errMaintenance
+	}
+	if ok, wait := e.breaker.allow(time.Now()); !ok {
+		span.end(errBackingOff)
 		e.scrapeFailures.Collect(ch)
+		e.targetFailures.Collect(ch)
+		e.parseErrors.Collect(ch)
+		e.restarts.Collect(ch)
+		e.timeouts.Collect(ch)
+		e.failGroups(ch)
+		ch <- prometheus.MustNewConstMetric(e.upDesc, prometheus.GaugeValue, 0)
+		ch <- prometheus.MustNewConstMetric(e.backoffDesc, prometheus.GaugeValue, wait.Seconds())
+		ch <- prometheus.MustNewConstMetric(e.maintenanceDesc, prometheus.GaugeValue, 0)
+		e.degraded.collect(ch, e.degradedDesc)
+		e.connections.Collect(ch)
+		e.formLogins.Collect(ch)
+		return errBackingOff
 	}
-	return
+	phases := &phaseTimer{}
+	duration, err := e.scrape(ctx, phases, ch)
+	up := 0.0
+	if err == nil {
+		up = 1
+	}
+	e.scrapeFailures.Collect(ch)
+	e.targetFailures.Collect(ch)
+	e.parseErrors.Collect(ch)
+	e.restarts.Collect(ch)
+	e.timeouts.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(e.upDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(e.durationDesc, prometheus.GaugeValue, duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(e.maintenanceDesc, prometheus.GaugeValue, 0)
+	e.degraded.collect(ch, e.degradedDesc)
+	e.connections.Collect(ch)
+	e.formLogins.Collect(ch)
+	phases.collect(ch, e.phaseDesc)
+	span.end(err)
+	return err
 }
 
 func main() {
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "mock-server" {
+		if err := runMockServer(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Mock server failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runNagiosCheck(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		if err := runGenerate(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Generating failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	warnings, err := parseFlags(flag.CommandLine, os.Args[1:], os.Environ())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	for _, w := range warnings {
+		logger.Warn(w)
+	}
+	if err := setNamespace(namespace); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *showVersion {
+		fmt.Print(version.Print("apache_exporter"))
+		return
+	}
+	if *healthcheck {
+		address := listeningAddresses.values[0]
+		if *webAdminAddress != "" {
+			address = *webAdminAddress
+		}
+		_, prefix, err := routing(*webExternalURL, *webRoutePrefix)
+		if err == nil {
+			err = checkHealth(address, prefix, *webTLSCertFile != "", *healthcheckTimeout)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unhealthy: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Healthy")
+		return
+	}
+	if *selfTestOnly {
+		if err := selfTest(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Self-test failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *scrapeOnceOnly {
+		err := validateMaxConcurrency(*maxConcurrency)
+		var es Exporters
+		if err == nil {
+			es, err = exportersFromFlags(false)
+		}
+		if err == nil {
+			err = scrapeOnce(os.Stdout, es, *onceAllowPartial)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Scrape failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if runService() {
+		return
+	}
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	run(term)
+}
 
-	exporter := NewExporter(*scrapeURI)
-	prometheus.MustRegister(exporter)
+// run sets up the exporter as the flags say and serves until term receives
+// a signal.
+func run(term <-chan os.Signal) {
+	if *skipHostnameVerify && *insecure {
+		logger.Warn("-scrape.tls.insecure-skip-hostname-verify has no effect with -insecure, which skips all verification of certificates")
+	}
+	if err := validateHTTPVersion(*httpVersion); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateIPProtocol(*ipProtocol); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateDegradedRatios(*degradedEnterRatio, *degradedExitRatio); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if *sourceAddress != "" {
+		if _, err := parseSourceAddress(*sourceAddress); err != nil {
+			fatal("Error starting the exporter", fmt.Errorf("-scrape.source-address: %v", err))
+		}
+	}
+	if err := validateMaxConcurrency(*maxConcurrency); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateExportTimestamps(); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateRequestRates(); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateScrapeHistory(*scrapeHistorySize); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateState(*stateFile, *stateInterval); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateHealthThresholds(healthThresholdsFromFlags()); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateSharding(*shardsTotal, *shardIndex); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateFlavorHint(*flavorHint); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateConfigValues(); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateApacheBinary(*apacheBinaryPath, *apacheBinaryInterval); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateResourcesTop(*resourcesTop); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	hook, err := webhookFromFlags()
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	targetWebhook = hook
+	var apacheLogTail *apacheLog
+	if *apacheLogPath != "" {
+		if apacheLogTail, err = newApacheLog(*apacheLogPath, *apacheLogFormat); err != nil {
+			fatal("Error starting the exporter", err)
+		}
+	}
+	probePolicy, err := newTargetPolicy(probeAllowedTargets.values, *probeDenyPrivate, resolveOverrides)
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	var errorLogTail *apacheErrorLog
+	if *errorLogPath != "" {
+		if errorLogTail, err = newApacheErrorLog(*errorLogPath, *errorLogModules); err != nil {
+			fatal("Error starting the exporter", err)
+		}
+	}
+	if *stateFile != "" {
+		restoredState = loadState(*stateFile, *stateMaxAge, time.Now())
+	}
+	if err := validateMaxRequests(*maxRequests); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateErrorHandling(*errorHandling); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if err := validateTextfile(*textfileDirectory, *textfileOnFailure, *textfileOnly); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	var runOutputs []func(s *targetSet, done <-chan struct{})
+	for _, o := range outputs {
+		run, err := o.setup()
+		if err != nil {
+			fatal("Error starting the exporter", err)
+		}
+		if run != nil {
+			runOutputs = append(runOutputs, run)
+		}
+	}
+	webTLS, err := webTLSConfig(webTLSOptionsFromFlags())
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	external, prefix, err := routing(*webExternalURL, *webRoutePrefix)
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	// Not the default mux, which net/http/pprof registers its handlers on.
+	mux := http.NewServeMux()
+	handler, err := newBasicAuth(*webAuthUsername, *webAuthPasswordFile, webAuthExempt.values, mux)
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	// Limited before checking credentials, which may take a bcrypt hash.
+	handler = limitRequests(*maxRequests, handler)
+	registry.MustRegister(rejectedRequests)
+	registry.MustRegister(deniedProbes)
+	if handler, err = allowNetworks(allowedCIDRs.values, *trustProxyHeaders, allowedCIDRsExempt.values, handler); err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	if *accessLog {
+		handler = logRequests(handler)
+	}
+	if *checkConfigOnly {
+		if err := checkConfig(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Configuration check failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	log.Printf("Starting Server: %s", *listeningAddress)
-	http.Handle(*metricsEndpoint, prometheus.Handler())
-	log.Fatal(http.ListenAndServe(*listeningAddress, nil))
+	ready := &readiness{}
+	mux.Handle("/healthz", healthzHandler())
+	mux.Handle("/-/ready", readyHandler(ready))
+
+	if *apacheBinaryPath != "" {
+		localApache = &apacheBinary{path: *apacheBinaryPath}
+		localApache.refresh()
+	}
+	done := make(chan struct{})
+	watchers, err := setupDiscovery(done)
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	exporters, err := exportersFromFlags(*failOnStartup)
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	exporters.restore(restoredState)
+	targets := newTargetSet(exporters, func() (Exporters, error) {
+		return exportersFromFlags(false)
+	})
+	if *printConfig {
+		if err := writeConfig(os.Stdout, targets); err != nil {
+			fatal("Error printing the configuration", err)
+		}
+	}
+	var watching sync.WaitGroup
+	for _, watch := range watchers {
+		watching.Add(1)
+		go func(watch watcher) {
+			defer watching.Done()
+			watch(func() { targets.reload() })
+		}(watch)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if logFile != nil {
+				if err := logFile.reopen(); err != nil {
+					// Logged to stderr, which the file falls back to.
+					logger.Error("Error reopening -log.file", "err", err)
+				}
+			}
+			targets.reload()
+		}
+	}()
+	toggleDebugOnSignal()
+
+	if *textfileDirectory != "" {
+		watching.Add(1)
+		go func() {
+			defer watching.Done()
+			runTextfile(*textfileDirectory, targets, *textfileInterval, *textfileOnFailure == "remove", done)
+		}()
+	}
+	if *shardsTotal > 1 {
+		registry.MustRegister(shardTargetsGauge)
+	}
+	if targetWebhook != nil {
+		registry.MustRegister(webhookFailures)
+		watching.Add(1)
+		go func() {
+			defer watching.Done()
+			targetWebhook.run(done)
+		}()
+	}
+	if *apacheLogPath != "" {
+		registry.MustRegister(apacheLogTail)
+		watching.Add(1)
+		go func() {
+			defer watching.Done()
+			apacheLogTail.run(done)
+		}()
+	}
+	if *errorLogPath != "" {
+		registry.MustRegister(errorLogTail)
+		watching.Add(1)
+		go func() {
+			defer watching.Done()
+			errorLogTail.run(done)
+		}()
+	}
+	if localApache != nil {
+		watching.Add(1)
+		go func() {
+			defer watching.Done()
+			localApache.run(*apacheBinaryInterval, done)
+		}()
+	}
+	if *stateFile != "" {
+		watching.Add(1)
+		go func() {
+			defer watching.Done()
+			runState(*stateFile, targets, *stateInterval, done)
+		}()
+	}
+	for _, run := range runOutputs {
+		watching.Add(1)
+		go func(run func(s *targetSet, done <-chan struct{})) {
+			defer watching.Done()
+			run(targets, done)
+		}(run)
+	}
+	if *textfileOnly {
+		<-term
+		logger.Info("Shutting down")
+		close(done)
+		watching.Wait()
+		return
+	}
+
+	var s targetScraper = targets
+	var background *backgroundScraper
+	if *scrapeInterval > 0 {
+		background = newBackgroundScraper(targets, *scrapeInterval, *scrapeJitter)
+		background.start()
+		background.forceOnSignal()
+		s = background
+	}
+
+	listeners, err := listen()
+	if err != nil {
+		fatal("Error starting the exporter", err)
+	}
+	full, err := collectorSet(metricsCollectors.values)
+	if err != nil {
+		fatal("Invalid -web.telemetry-collectors", err)
+	}
+	telemetry := endpoint{collectors: full}
+	if *selfMetricsPath != "" {
+		telemetry.self = &selfSnapshot{}
+		mux.Handle(*selfMetricsPath, instrumentHandler("self_metrics", selfMetricsHandler(telemetry.self)))
+	}
+	mux.Handle(*metricsEndpoint, instrumentHandler("metrics", endpointHandler(s, telemetry)))
+	if *perTargetPaths {
+		prefix := strings.TrimSuffix(*metricsEndpoint, "/") + "/"
+		if prefix == *metricsEndpoint {
+			fatal("Invalid -web.per-target-paths", fmt.Errorf("-web.telemetry-path %s must not end in /", prefix))
+		}
+		mux.Handle(prefix, instrumentHandler("metrics_target", perTargetHandler(s, targets, prefix, endpoint{collectors: full})))
+	}
+	if *lightEndpoint != "" {
+		light, err := collectorSet(lightCollectors.values)
+		if err != nil {
+			fatal("Invalid -web.telemetry-light-collectors", err)
+		}
+		mux.Handle(*lightEndpoint, instrumentHandler("metrics_light", endpointHandler(s, endpoint{collectors: light, light: true})))
+	}
+	if *enableLifecycle {
+		mux.Handle("/-/reload", reloadHandler(targets))
+		mux.Handle("/-/config", configHandler(targets))
+		if background != nil {
+			mux.Handle("/-/scrape", scrapeHandler(background))
+		}
+	}
+	if *enablePprof {
+		handlePprof(mux)
+	}
+	if *enableRaw {
+		mux.Handle("/raw", rawHandler(targets))
+	}
+	mux.Handle("/-/log-level", logLevelHandler(*enableLifecycle))
+	mux.Handle("/-/maintenance", maintenanceHandler(targets, *enableLifecycle))
+	mux.Handle("/api/v1/targets", targetsAPIHandler(targets))
+	mux.Handle("/api/v1/status", statusAPIHandler(targets, background == nil))
+	mux.Handle("/healthz/apache", apacheHealthHandler(targets, healthThresholdsFromFlags()))
+	if *scrapeHistorySize > 0 {
+		mux.Handle("/debug/scrapes", scrapeHistoryHandler(targets))
+	}
+	mux.Handle("/status", statusPageHandler(targets, *metricsEndpoint, external, *statusRefresh))
+	mux.Handle("/", landingHandler(targets, *metricsEndpoint, external))
+	probeClient := clientConfigFromFlags()
+	probeClient.policy = probePolicy
+	mux.Handle("/probe", instrumentHandler("probe", probeHandler(newHTTPClient(probeClient), probePolicy, uriDefaultsFromFlags(), currentModules)))
+	server := newServer(withPrefix(prefix, external, handler), webTLS, serverErrorLog)
+	servers := []*http.Server{server}
+	serving := map[net.Listener]*http.Server{}
+	for _, l := range listeners {
+		serving[l] = server
+	}
+	if *webAdminAddress != "" {
+		l, err := listenAddress(*webAdminAddress, *socketMode)
+		if err != nil {
+			fatal("Error listening on -web.admin-address", err)
+		}
+		logger.Info("Serving admin endpoints", "address", l.Addr())
+		server.Handler = withPrefix(prefix, external, servePaths(handler, false))
+		admin := newServer(withPrefix(prefix, external, servePaths(handler, true)), webTLS, serverErrorLog)
+		servers = append(servers, admin)
+		serving[l] = admin
+	}
+	notify := newNotifier()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-term
+		notify.notify("STOPPING=1")
+		logger.Info("Shutting down, waiting for requests and scrapes in flight", "timeout", *shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := shutdown(ctx, servers, ready, background, done, &watching); err != nil {
+			logger.Error("Cut short requests or scrapes still running", "err", err)
+		}
+	}()
+	ready.set(stateReady)
+	notify.notify("READY=1")
+	go notify.watchdog(watchdogInterval(), func(period time.Duration) bool {
+		return background == nil || background.healthy(period)
+	}, done)
+	served := make(chan error, len(serving))
+	for l, server := range serving {
+		go func(l net.Listener, server *http.Server) {
+			if webTLS != nil {
+				served <- server.ServeTLS(l, "", "")
+			} else {
+				served <- server.Serve(l)
+			}
+		}(l, server)
+	}
+	for range serving {
+		if err := <-served; err != http.ErrServerClosed {
+			fatal("Error starting the exporter", err)
+		}
+	}
+	<-stopped
+	logger.Info("Shut down")
 }
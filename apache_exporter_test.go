@@ -1,59 +1,54 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files in testdata.")
+
+var (
+	apache24Status = mustStatusFixture("event-2.4")
+	apache22Status = mustStatusFixture("prefork-2.2")
+)
+
+// mustStatusFixture returns the status page fixture of profile, shared with
+// the mock server.
+func mustStatusFixture(profile string) string {
+	s, err := statusFixture(profile)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
 const (
-	apache24Status = `localhost
-ServerVersion: Apache/2.4.16 (Unix)
-ServerMPM: prefork
-Server Built: Jul 22 2015 21:03:09
-CurrentTime: Monday, 16-May-2016 18:37:02 JST
-RestartTime: Monday, 16-May-2016 16:36:41 JST
-ParentServerConfigGeneration: 1
-ParentServerMPMGeneration: 0
-ServerUptimeSeconds: 7220
-ServerUptime: 2 hours 20 seconds
-Load1: 3.23
-Load5: 3.29
-Load15: 2.89
-Total Accesses: 1
-Total kBytes: 2
-CPUUser: 0
-CPUSystem: .03
-CPUChildrenUser: 0
-CPUChildrenSystem: 0
-CPULoad: .000415512
-Uptime: 15664
-ReqPerSec: 6.38407e-5
-BytesPerSec: .130746
-BytesPerReq: 2048
-BusyWorkers: 1
-IdleWorkers: 4
-Scoreboard: _W___
-`
-
-	apache22Status = `Total Accesses: 302311
-Total kBytes: 1677830
-CPULoad: 27.4052
-Uptime: 45683
-ReqPerSec: 6.61758
-BytesPerSec: 37609.1
-BytesPerReq: 5683.21
-BusyWorkers: 2
-IdleWorkers: 8
-Scoreboard: _W_______K......................................................................................................................................................................................................................................................
-`
-
-	metricCount = 5
+	// Includes the connect, first_byte and body phase durations, the
+	// scrape duration, two failure counters per reason, the restarts and
+	// the success, duration and timeouts of each collector, the presence
+	// of the notable fields, the version and whether the status page was
+	// there.
+	metricCount = 69
 )
 
-func checkApacheStatus(t *testing.T, status string) {
+func checkApacheStatus(t *testing.T, status string, count int) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(status))
 	})
@@ -67,7 +62,7 @@ func checkApacheStatus(t *testing.T, status string) {
 		e.Collect(ch)
 	}()
 
-	for i := 1; i <= metricCount; i++ {
+	for i := 1; i <= count; i++ {
 		m := <-ch
 		if m == nil {
 			t.Error("expected metric but got nil")
@@ -80,10 +75,620 @@ func checkApacheStatus(t *testing.T, status string) {
 	}
 }
 
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodedServer(t *testing.T, encoding string, body []byte) *httptest.Server {
+	encoded := compress(t, encoding, body)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), encoding) {
+			t.Errorf("request Accept-Encoding %q does not offer %s", r.Header.Get("Accept-Encoding"), encoding)
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		w.Write(encoded)
+	}))
+}
+
+func TestEncodedStatus(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		server := encodedServer(t, encoding, []byte(apache24Status))
+
+		e := NewExporter(server.URL)
+		ch := make(chan prometheus.Metric)
+		go func() {
+			defer close(ch)
+			e.Collect(ch)
+		}()
+
+		if n := drain(ch); n != metricCount {
+			t.Errorf("%s: expected %d metrics, got %d", encoding, metricCount, n)
+		}
+		server.Close()
+	}
+}
+
+func TestCompressionBomb(t *testing.T) {
+	// 64MiB of zeros compresses to a few dozen kilobytes.
+	server := encodedServer(t, "gzip", make([]byte, 64<<20))
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	e.maxBodySize = 1 << 20
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		e.Collect(ch)
+	}()
+	drain(ch)
+
+	if v := counterValue(t, e.scrapeFailures.WithLabelValues(reasonBodyTooLarge)); v != 1 {
+		t.Errorf("expected 1 %s failure, got %v", reasonBodyTooLarge, v)
+	}
+}
+
 func TestApache22Status(t *testing.T) {
-	checkApacheStatus(t, apache22Status)
+	// Without the version, which 2.2 doesn't show.
+	checkApacheStatus(t, apache22Status, metricCount-1)
 }
 
 func TestApache24Status(t *testing.T) {
-	checkApacheStatus(t, apache24Status)
+	checkApacheStatus(t, apache24Status, metricCount)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func drain(ch <-chan prometheus.Metric) int {
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache22Status))
+		line := []byte(strings.Repeat("x", 1023) + "\n")
+		for i := 0; i < 64; i++ {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	e.maxBodySize = 4096
+	ch := make(chan prometheus.Metric)
+
+	go func() {
+		defer close(ch)
+		e.Collect(ch)
+	}()
+
+	// Only up, the scrape duration, the two failure, restart and
+	// connection counters, the three phase durations, the four groups failing,
+	// maintenance and the status page being there are exported, none of
+	// the parsed prefix.
+	if n := drain(ch); n != 41 {
+		t.Errorf("expected 41 metrics, got %d", n)
+	}
+	if v := counterValue(t, e.scrapeFailures.WithLabelValues(reasonBodyTooLarge)); v != 1 {
+		t.Errorf("expected 1 %s failure, got %v", reasonBodyTooLarge, v)
+	}
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+// TestDescribe pins the descriptions of a target's metrics, so that
+// changes to how they are collected don't change what dashboards see.
+func TestDescribe(t *testing.T) {
+	e := newExporter("http://web01/server-status?auto", prometheus.Labels{"target": "web01"})
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		defer close(ch)
+		e.Describe(ch)
+	}()
+	var descs []string
+	for desc := range ch {
+		descs = append(descs, desc.String())
+	}
+	sort.Strings(descs)
+	got := []byte(strings.Join(descs, "\n") + "\n")
+	golden := filepath.Join("testdata", "describe.txt")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compareExpositions(got, want); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNoStaleMetrics(t *testing.T) {
+	var fail int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(NewExporter(server.URL))
+	families := func() map[string]float64 {
+		mfs, err := r.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]float64{}
+		for _, mf := range mfs {
+			m := mf.GetMetric()[0]
+			got[mf.GetName()] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+		}
+		return got
+	}
+	if got := families(); got["apache_up"] != 1 || got["apache_accesses_total"] == 0 {
+		t.Fatalf("expected a good scrape to export accesses, got %v", got)
+	}
+	atomic.StoreInt32(&fail, 1)
+	got := families()
+	if got["apache_up"] != 0 {
+		t.Errorf("expected apache_up 0 after a failed scrape, got %v", got["apache_up"])
+	}
+	for _, name := range []string{"apache_accesses_total", "apache_workers", "apache_uptime_seconds_total"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("expected no %s from a failed scrape", name)
+		}
+	}
+}
+
+func TestApacheRestart(t *testing.T) {
+	var restarted int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&restarted) == 1 {
+			w.Write([]byte("Total Accesses: 3\nTotal kBytes: 2\nUptime: 5\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+			return
+		}
+		w.Write([]byte("Total Accesses: 9000\nTotal kBytes: 7000\nUptime: 86400\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+	}))
+	defer server.Close()
+
+	type metric struct {
+		typ   dto.MetricType
+		value float64
+	}
+	defer func(mode compatModeFlag) { compatMode = mode }(compatMode)
+	for mode, want := range map[string]map[string]metric{
+		// Legacy keeps today's types, the corrected uptime is a gauge.
+		"legacy": {
+			"apache_accesses_total":       {dto.MetricType_COUNTER, 3},
+			"apache_sent_kilobytes_total": {dto.MetricType_COUNTER, 2},
+			"apache_uptime_seconds_total": {dto.MetricType_COUNTER, 5},
+		},
+		"new": {
+			"apache_accesses_total":   {dto.MetricType_COUNTER, 3},
+			"apache_sent_bytes_total": {dto.MetricType_COUNTER, 2048},
+			"apache_uptime_seconds":   {dto.MetricType_GAUGE, 5},
+		},
+	} {
+		if err := compatMode.Set(mode); err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&restarted, 0)
+		r := prometheus.NewRegistry()
+		r.MustRegister(NewExporter(server.URL))
+		r.Gather()
+		atomic.StoreInt32(&restarted, 1)
+		mfs, err := r.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range mfs {
+			w, ok := want[mf.GetName()]
+			if !ok {
+				continue
+			}
+			delete(want, mf.GetName())
+			if mf.GetType() != w.typ {
+				t.Errorf("%s: %s: expected type %s, got %s", mode, mf.GetName(), w.typ, mf.GetType())
+			}
+			m := mf.GetMetric()[0]
+			if v := m.GetCounter().GetValue() + m.GetGauge().GetValue(); v != w.value {
+				t.Errorf("%s: %s: expected %v after the restart, got %v", mode, mf.GetName(), w.value, v)
+			}
+		}
+		for name := range want {
+			t.Errorf("%s: expected %s after the restart", mode, name)
+		}
+	}
+}
+
+func TestPartialParse(t *testing.T) {
+	status := strings.Replace(apache24Status, "Total kBytes: 2\n", "Total kBytes: lots\n", 1)
+	if status == apache24Status {
+		t.Fatal("no Total kBytes line to corrupt in the fixture")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(status))
+	}))
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	r := prometheus.NewRegistry()
+	r.MustRegister(e)
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keyed by name, and state for the workers.
+	got := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName()
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "state" || l.GetName() == "field" {
+					key += "/" + l.GetValue()
+				}
+			}
+			got[key] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+		}
+	}
+	if got["apache_up"] != 1 {
+		t.Errorf("expected the scrape to succeed with one bad field")
+	}
+	for _, key := range []string{"apache_accesses_total", "apache_uptime_seconds_total", "apache_workers/busy", "apache_workers/idle"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("expected %s, which parsed, to be exported", key)
+		}
+	}
+	if _, ok := got["apache_sent_kilobytes_total"]; ok {
+		t.Errorf("expected no sent kilobytes from a bad Total kBytes field")
+	}
+	if v := got["apache_exporter_parse_errors_total/Total kBytes"]; v != 1 {
+		t.Errorf("expected one Total kBytes parse error, got %v", v)
+	}
+
+	// A bad core field fails the scrape, with its parse error.
+	status = strings.Replace(apache24Status, "BusyWorkers: 1\n", "BusyWorkers: lots\n", 1)
+	var pe *ParseError
+	if err := e.Warmup(); failureReason(err) != reasonParse || !errors.As(err, &pe) || pe.Field != "BusyWorkers" {
+		t.Errorf("expected a bad BusyWorkers field to fail the scrape, got %v", err)
+	}
+
+	defer func(strict bool) { *strictParse = strict }(*strictParse)
+	*strictParse = true
+	if err := e.Warmup(); failureReason(err) != reasonParse {
+		t.Errorf("expected -scrape.strict-parse to fail the scrape, got %v", err)
+	}
+}
+
+func TestMissingCoreFields(t *testing.T) {
+	body := "Total Accesses: 10\nTotal kBytes: 5\nUptime: 60\nIdleWorkers: 4\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	err := e.Warmup()
+	if failureReason(err) != reasonParse || !strings.Contains(err.Error(), "no BusyWorkers field") {
+		t.Errorf("expected a page without BusyWorkers to fail with reason %s, got %v", reasonParse, err)
+	}
+	body = "Total Accesses: 10\nUptime: 60\n"
+	if err := e.Warmup(); failureReason(err) != reasonParse {
+		t.Errorf("expected a page with neither worker field to fail with reason %s, got %v", reasonParse, err)
+	}
+
+	rr := httptest.NewRecorder()
+	metricsHandler(newTargetSet(Exporters{e}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{"apache_up 0", `apache_exporter_scrape_failures_total{reason="parse"`} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected %s in\n%s", want, rr.Body.String())
+		}
+	}
+	if strings.Contains(rr.Body.String(), "apache_accesses_total") {
+		t.Errorf("expected none of the fields of the failed scrape in\n%s", rr.Body.String())
+	}
+}
+
+func TestCollectorDeadline(t *testing.T) {
+	defer func(old []groupCollector) { groupCollectors = old }(groupCollectors)
+	defer delete(collectorEnabled, "test_fast")
+	defer delete(collectorEnabled, "test_hang")
+	collectorEnabled["test_fast"] = true
+	collectorEnabled["test_hang"] = true
+	fastDesc := prometheus.NewDesc("apache_test_fast", "Fast.", nil, nil)
+	release := make(chan struct{})
+	defer close(release)
+	groupCollectors = []groupCollector{
+		{name: "test_hang", collect: func(e *Exporter, values map[string]float64, ch chan<- prometheus.Metric) {
+			<-release
+		}},
+		{name: "test_fast", collect: func(e *Exporter, values map[string]float64, ch chan<- prometheus.Metric) {
+			ch <- prometheus.MustNewConstMetric(fastDesc, prometheus.GaugeValue, 1)
+		}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	buf := captureLogs(t, "json")
+	e := NewExporter(server.URL)
+	e.client.Timeout = 200 * time.Millisecond
+	ch := make(chan prometheus.Metric)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		errc <- e.collectTarget(context.Background(), ch)
+	}()
+	got := map[string]float64{}
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				done = true
+				break
+			}
+			var pb dto.Metric
+			m.Write(&pb)
+			key := m.Desc().String()
+			for _, l := range pb.GetLabel() {
+				if l.GetName() == "collector" && m.Desc() == e.collectorSuccessDesc {
+					key = l.GetValue()
+				}
+			}
+			got[key] = pb.GetGauge().GetValue()
+		case <-timeout:
+			t.Fatal("collect waited on the hanging collector")
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("expected the scrape to succeed with a collector cut off, got %v", err)
+	}
+	if _, ok := got[fastDesc.String()]; !ok {
+		t.Error("expected the metrics of the fast collector")
+	}
+	if got["test_fast"] != 1 || got["test_hang"] != 0 {
+		t.Errorf("expected test_fast to succeed and test_hang to be cut off, got %v", got)
+	}
+	var cutOff []interface{}
+	for _, r := range logRecords(t, buf) {
+		if r["msg"] == "Collector cut off by the deadline" {
+			cutOff = append(cutOff, r["collector"])
+		}
+	}
+	if len(cutOff) != 1 || cutOff[0] != "test_hang" {
+		t.Errorf("expected test_hang to be logged as cut off, got %v", cutOff)
+	}
+}
+
+func TestCollectorDurations(t *testing.T) {
+	defer func(old []groupCollector) { groupCollectors = old }(groupCollectors)
+	release := make(chan struct{})
+	defer close(release)
+	delays := map[string]time.Duration{"test_quick": 10 * time.Millisecond, "test_slow": 100 * time.Millisecond}
+	groupCollectors = []groupCollector{{name: "test_hang", collect: func(*Exporter, map[string]float64, chan<- prometheus.Metric) {
+		<-release
+	}}}
+	collectorEnabled["test_hang"] = true
+	defer delete(collectorEnabled, "test_hang")
+	for name, delay := range delays {
+		delay := delay
+		groupCollectors = append(groupCollectors, groupCollector{name: name, collect: func(*Exporter, map[string]float64, chan<- prometheus.Metric) {
+			time.Sleep(delay)
+		}})
+		collectorEnabled[name] = true
+		defer delete(collectorEnabled, name)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	buf := captureLogs(t, "json")
+	e := NewExporter(server.URL)
+	e.client.Timeout = 300 * time.Millisecond
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		e.collectTarget(context.Background(), ch)
+	}()
+	durations := map[string]float64{}
+	timeouts := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		m.Write(&pb)
+		var collector string
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "collector" {
+				collector = l.GetValue()
+			}
+		}
+		switch m.Desc() {
+		case e.collectorDurationDesc:
+			durations[collector] = pb.GetGauge().GetValue()
+		case descOf(e.timeouts):
+			timeouts[collector] = pb.GetCounter().GetValue()
+		}
+	}
+	for name, delay := range delays {
+		if d := durations[name]; d < delay.Seconds() || d >= e.client.Timeout.Seconds() {
+			t.Errorf("%s: expected a duration of at least %s, got %gs", name, delay, d)
+		}
+		if timeouts[name] != 0 {
+			t.Errorf("%s: expected no timeouts, got %g", name, timeouts[name])
+		}
+	}
+	if durations["test_quick"] >= durations["test_slow"] {
+		t.Errorf("expected test_quick to be quicker than test_slow, got %v", durations)
+	}
+	if _, ok := durations["test_hang"]; ok || timeouts["test_hang"] != 1 {
+		t.Errorf("expected test_hang to time out without a duration, got %v and %v", durations, timeouts)
+	}
+	logged := map[interface{}]float64{}
+	for _, r := range logRecords(t, buf) {
+		if r["msg"] == "Collected apache metrics" {
+			logged[r["collector"]] = r["duration"].(float64)
+		}
+	}
+	for name, delay := range delays {
+		if logged[name] < float64(delay) {
+			t.Errorf("%s: expected a logged duration of at least %s, got %v", name, delay, logged)
+		}
+	}
+}
+
+// descOf returns the description of the metrics of c, which describes one.
+func descOf(c prometheus.Collector) *prometheus.Desc {
+	ch := make(chan *prometheus.Desc, 1)
+	c.Describe(ch)
+	return <-ch
+}
+
+func TestWorkersUtilization(t *testing.T) {
+	defer func(old int) { *workersLimit = old }(*workersLimit)
+	var workers groupCollector
+	for _, c := range groupCollectors {
+		if c.name == "workers" {
+			workers = c
+		}
+	}
+	e := NewExporter("http://web01/server-status?auto")
+	// The utilization and saturation collected from values, -1 if left out.
+	ratios := func(values map[string]float64) (float64, float64) {
+		ch := make(chan prometheus.Metric, 10)
+		workers.collect(e, values, ch)
+		close(ch)
+		utilization, saturation := -1.0, -1.0
+		for m := range ch {
+			var pb dto.Metric
+			m.Write(&pb)
+			switch m.Desc() {
+			case e.utilizationDesc:
+				utilization = pb.GetGauge().GetValue()
+			case e.saturationDesc:
+				saturation = pb.GetGauge().GetValue()
+			}
+		}
+		return utilization, saturation
+	}
+	for _, c := range []struct {
+		name                    string
+		values                  map[string]float64
+		limit                   int
+		utilization, saturation float64
+	}{
+		{"normal", map[string]float64{"BusyWorkers": 30, "IdleWorkers": 70}, 400, 0.3, 0.075},
+		{"zero idle", map[string]float64{"BusyWorkers": 25, "IdleWorkers": 0}, 100, 1, 0.25},
+		{"missing limit", map[string]float64{"BusyWorkers": 30, "IdleWorkers": 70}, 0, 0.3, -1},
+		{"no workers", map[string]float64{"BusyWorkers": 0, "IdleWorkers": 0}, 0, -1, -1},
+		{"no idle workers reported", map[string]float64{"BusyWorkers": 5}, 10, -1, 0.5},
+		{"no busy workers reported", map[string]float64{"IdleWorkers": 5}, 10, -1, -1},
+	} {
+		*workersLimit = c.limit
+		if utilization, saturation := ratios(c.values); utilization != c.utilization || saturation != c.saturation {
+			t.Errorf("%s: expected utilization %v and saturation %v, got %v and %v", c.name, c.utilization, c.saturation, utilization, saturation)
+		}
+	}
+}
+
+// largeAutoStatus is the huge-vhosts status page of a server with a
+// million worker slots, the worst case of a ?auto page to parse.
+func largeAutoStatus() []byte {
+	var b bytes.Buffer
+	for _, l := range strings.Split(mustStatusFixture("huge-vhosts"), "\n") {
+		if strings.HasPrefix(l, "Scoreboard: ") {
+			l = "Scoreboard: " + strings.Repeat("WRKC_.", 1<<20/6)
+		}
+		b.WriteString(l + "\n")
+	}
+	return b.Bytes()
+}
+
+// readAndParseStatus reads and parses page the way a scrape does.
+func readAndParseStatus(e *Exporter, page []byte) error {
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(page))}
+	buf := statusBuffers.Get().(*bytes.Buffer)
+	defer statusBuffers.Put(buf)
+	data, err := readResponse(resp, buf, e.maxBodySize)
+	if err != nil {
+		return err
+	}
+	_, err = e.parseStatus(resp, data)
+	return err
+}
+
+func benchmarkParseStatus(b *testing.B, page []byte) {
+	e := NewExporter("http://localhost/server-status?auto")
+	if err := readAndParseStatus(e, page); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(page)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readAndParseStatus(e, page)
+	}
+}
+
+func BenchmarkParseStatus(b *testing.B) {
+	b.Run("small", func(b *testing.B) { benchmarkParseStatus(b, []byte(apache24Status)) })
+	b.Run("large", func(b *testing.B) { benchmarkParseStatus(b, largeAutoStatus()) })
+}
+
+// TestParseStatusAllocs holds the large page to the allocations it took
+// once scanned in place and read into pooled buffers, 7 rather than the 67
+// of splitting it, with room for the pool to be emptied by a GC.
+func TestParseStatusAllocs(t *testing.T) {
+	e := NewExporter("http://localhost/server-status?auto")
+	page := largeAutoStatus()
+	allocs := testing.AllocsPerRun(20, func() {
+		if err := readAndParseStatus(e, page); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 16 {
+		t.Errorf("expected at most 16 allocations to read and parse the page, got %v", allocs)
+	}
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apacheBinaryPath     = flag.String("apache.binary", "", "Binary of the apache running on the same host, such as /usr/sbin/httpd, run with -v and -V at startup and every -apache.binary.interval for the version and MPM of apache_info where the status page doesn't show them, as with older versions or ServerTokens Prod.")
+	apacheBinaryInterval = flag.Duration("apache.binary.interval", time.Hour, "How often to run -apache.binary again, for upgrades of apache.")
+)
+
+func validateApacheBinary(path string, interval time.Duration) error {
+	if path != "" && interval <= 0 {
+		return fmt.Errorf("-apache.binary.interval must be positive, got %s", interval)
+	}
+	return nil
+}
+
+func newInfoDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("info", "Version and MPM of apache, as the status page shows them or, where it doesn't, as -apache.binary gives them. Always 1.", []string{"version", "mpm"}, labels)
+}
+
+// runApacheBinary runs the apache binary at path with arg, returning what
+// it printed.
+var runApacheBinary = func(path, arg string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return exec.CommandContext(ctx, path, arg).CombinedOutput()
+}
+
+// parseApacheBinary returns the version and MPM in what apache printed
+// for -v or -V, as in
+//
+//	Server version: Apache/2.4.57 (Debian)
+//	Server MPM:     event
+//
+// each empty if not there. Windows prints the same, along with the vendor
+// of the build and carriage returns.
+func parseApacheBinary(out string) (version, mpm string) {
+	for _, l := range strings.Split(out, "\n") {
+		key, value := splitkv(strings.TrimRight(l, "\r"))
+		switch key {
+		case "Server version":
+			version = value
+		case "Server MPM":
+			mpm = value
+		}
+	}
+	return version, mpm
+}
+
+// apacheBinary keeps what -apache.binary gave for the version and MPM.
+type apacheBinary struct {
+	path string
+
+	mutex        sync.Mutex
+	version, mpm string
+	failing      bool // Since the failure logged.
+}
+
+// localApache is -apache.binary, nil without one.
+var localApache *apacheBinary
+
+// refresh runs the binary again, keeping what it gave before if it fails.
+// Failures are logged once until it runs again.
+func (b *apacheBinary) refresh() {
+	var version, mpm string
+	var err error
+	for _, arg := range []string{"-v", "-V"} {
+		out, rerr := runApacheBinary(b.path, arg)
+		if rerr != nil {
+			err = fmt.Errorf("running %s %s: %w", b.path, arg, rerr)
+			break
+		}
+		v, m := parseApacheBinary(string(out))
+		if version == "" {
+			version = v
+		}
+		if mpm == "" {
+			mpm = m
+		}
+	}
+	if err == nil && version == "" && mpm == "" {
+		err = fmt.Errorf("no version or MPM in the output of %s", b.path)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err != nil {
+		if !b.failing {
+			logger.Warn("Error getting the version of apache from its binary", "err", err)
+		}
+		b.failing = true
+		return
+	}
+	if b.failing || version != b.version || mpm != b.mpm {
+		logger.Info("Got the version of apache from its binary", "binary", b.path, "version", version, "mpm", mpm)
+	}
+	b.version, b.mpm, b.failing = version, mpm, false
+}
+
+func (b *apacheBinary) get() (version, mpm string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.version, b.mpm
+}
+
+// run refreshes b every interval until done is closed.
+func (b *apacheBinary) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.refresh()
+		case <-done:
+			return
+		}
+	}
+}
+
+// serverInfo returns the version and MPM of apache, from what the status
+// page shows or localApache. A version without a number, as with
+// ServerTokens Prod or Oracle-HTTP-Server, is taken as not shown.
+func serverInfo(version, mpm string) (string, string) {
+	if localApache == nil || strings.Contains(version, "/") && mpm != "" {
+		return version, mpm
+	}
+	v, m := localApache.get()
+	if !strings.Contains(version, "/") && v != "" {
+		version = v
+	}
+	if mpm == "" {
+		mpm = m
+	}
+	return version, mpm
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseApacheBinary(t *testing.T) {
+	for _, tc := range []struct {
+		file, version, mpm string
+	}{
+		{"debian-v.txt", "Apache/2.4.57 (Debian)", ""},
+		{"debian-V.txt", "Apache/2.4.57 (Debian)", "event"},
+		{"windows-v.txt", "Apache/2.4.58 (Win64)", ""},
+		{"windows-V.txt", "Apache/2.4.58 (Win64)", "WinNT"},
+		{"centos-2.2-V.txt", "Apache/2.2.15 (Unix)", "Prefork"},
+	} {
+		out, err := ioutil.ReadFile(filepath.Join("testdata", "binary", tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		version, mpm := parseApacheBinary(string(out))
+		if version != tc.version || mpm != tc.mpm {
+			t.Errorf("%s: expected %q and %q, got %q and %q", tc.file, tc.version, tc.mpm, version, mpm)
+		}
+	}
+	if version, mpm := parseApacheBinary("httpd: Syntax error on line 1\n"); version != "" || mpm != "" {
+		t.Errorf("expected nothing, got %q and %q", version, mpm)
+	}
+}
+
+// fakeApacheBinary makes runApacheBinary print the captured outputs of
+// layout, or fail with err, until the returned function restores it.
+func fakeApacheBinary(layout *string, err *error) func() {
+	old := runApacheBinary
+	runApacheBinary = func(path, arg string) ([]byte, error) {
+		if *err != nil {
+			return nil, *err
+		}
+		return ioutil.ReadFile(filepath.Join("testdata", "binary", *layout+arg+".txt"))
+	}
+	return func() { runApacheBinary = old }
+}
+
+func TestApacheBinaryRefresh(t *testing.T) {
+	layout, err := "debian", error(nil)
+	defer fakeApacheBinary(&layout, &err)()
+	logs := captureLogs(t, "json")
+	b := &apacheBinary{path: "/usr/sbin/apache2"}
+	b.refresh()
+	if version, mpm := b.get(); version != "Apache/2.4.57 (Debian)" || mpm != "event" {
+		t.Errorf("unexpected %q and %q", version, mpm)
+	}
+
+	// Failures keep what the binary gave before, logged once.
+	err = errors.New("exec: permission denied")
+	b.refresh()
+	b.refresh()
+	if version, _ := b.get(); version != "Apache/2.4.57 (Debian)" {
+		t.Errorf("expected the previous version, got %q", version)
+	}
+	if n := strings.Count(logs.String(), "Error getting the version of apache from its binary"); n != 1 {
+		t.Errorf("expected the failure logged once, got %d times:\n%s", n, logs)
+	}
+	layout, err = "windows", nil
+	b.refresh()
+	if version, mpm := b.get(); version != "Apache/2.4.58 (Win64)" || mpm != "WinNT" {
+		t.Errorf("unexpected %q and %q", version, mpm)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	defer func(old *apacheBinary) { localApache = old }(localApache)
+	localApache = nil
+	if version, mpm := serverInfo("Apache", ""); version != "Apache" || mpm != "" {
+		t.Errorf("expected the status page alone without -apache.binary, got %q and %q", version, mpm)
+	}
+	localApache = &apacheBinary{version: "Apache/2.4.57 (Debian)", mpm: "event"}
+	for _, tc := range []struct {
+		version, mpm   string
+		wantV, wantMPM string
+	}{
+		{"Apache/2.4.58 (Unix)", "worker", "Apache/2.4.58 (Unix)", "worker"},
+		{"", "", "Apache/2.4.57 (Debian)", "event"},
+		{"Apache", "prefork", "Apache/2.4.57 (Debian)", "prefork"},
+		{"Oracle-HTTP-Server", "", "Apache/2.4.57 (Debian)", "event"},
+		{"Apache/2.2.15 (Unix)", "", "Apache/2.2.15 (Unix)", "event"},
+	} {
+		if version, mpm := serverInfo(tc.version, tc.mpm); version != tc.wantV || mpm != tc.wantMPM {
+			t.Errorf("%q, %q: expected %q and %q, got %q and %q", tc.version, tc.mpm, tc.wantV, tc.wantMPM, version, mpm)
+		}
+	}
+}
+
+func TestApacheInfoFallback(t *testing.T) {
+	defer func(old *apacheBinary) { localApache = old }(localApache)
+	layout, err := "centos-2.2", error(nil)
+	defer fakeApacheBinary(&layout, &err)()
+	localApache = &apacheBinary{path: "/usr/sbin/httpd"}
+	localApache.refresh()
+
+	// The 2.2 status page shows neither.
+	exposition, err := fixtureExposition("prefork-2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `apache_info{mpm="Prefork",version="Apache/2.2.15 (Unix)"} 1`; !strings.Contains(string(exposition), want) {
+		t.Errorf("expected %s in\n%s", want, exposition)
+	}
+}
@@ -0,0 +1,116 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var apacheConfigFile = flag.String("discovery.apache-config", "", "Main configuration file of the apache running on the same host, such as /etc/apache2/apache2.conf or /etc/httpd/conf/httpd.conf, to scrape the status page it serves, found from its Listen directives and the Location with SetHandler server-status. Followed through Include and IncludeOptional, and parsed again every -discovery.refresh-interval. Ignored if -scrape.uri or -config.file is given.")
+
+func init() {
+	registerDiscovery(discoveryMechanism{
+		name:    "apache-config",
+		enabled: func() bool { return *apacheConfigFile != "" },
+		setup: func(newContext func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error) {
+			d := newApacheConfigDiscovery(*apacheConfigFile)
+			if scrapeURIs.set || *configFile != "" {
+				logger.Info("Not discovering the status page from the apache configuration, as the targets are given", "file", *apacheConfigFile)
+				return d, func(func()) {}, nil
+			}
+			if _, err := d.refresh(); err != nil {
+				return nil, nil, err
+			}
+			registry.MustRegister(d)
+			return d, func(changed func()) {
+				d.run(*refreshInterval, changed, done)
+			}, nil
+		},
+	})
+}
+
+// apacheConfigDiscovery finds the status page of the local apache from its
+// configuration.
+type apacheConfigDiscovery struct {
+	file string
+
+	mutex sync.Mutex
+	uri   string
+
+	lastRefresh prometheus.Gauge
+}
+
+func newApacheConfigDiscovery(file string) *apacheConfigDiscovery {
+	return &apacheConfigDiscovery{
+		file: file,
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_apache_config_last_refresh_success_timestamp_seconds",
+			Help:        "Timestamp of the last parse of -discovery.apache-config that found the status page.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+}
+
+// refresh parses the configuration again, reporting whether the status
+// page moved. A configuration that fails to parse keeps the previous one.
+func (d *apacheConfigDiscovery) refresh() (bool, error) {
+	status, err := (&apacheConfigParser{}).statusPage(d.file)
+	if err != nil {
+		return false, fmt.Errorf("discovering the status page from %s: %w", d.file, err)
+	}
+	d.lastRefresh.Set(float64(time.Now().Unix()))
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if status.uri == d.uri {
+		return false, nil
+	}
+	d.uri = status.uri
+	logger.Info("Discovered the status page from the apache configuration", "uri", status.uri, "listen", status.listen, "location", status.location, "file", status.file)
+	return true, nil
+}
+
+func (d *apacheConfigDiscovery) targets() []target {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.uri == "" {
+		return nil
+	}
+	return []target{{uri: d.uri}}
+}
+
+// run refreshes d every interval until done is closed, calling changed
+// when the status page moved.
+func (d *apacheConfigDiscovery) run(interval time.Duration, changed func(), done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			moved, err := d.refresh()
+			if err != nil {
+				logger.Error("Error parsing the apache configuration, keeping the previous status page", "err", err)
+			}
+			if moved {
+				changed()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (d *apacheConfigDiscovery) Describe(ch chan<- *prometheus.Desc) {
+	d.lastRefresh.Describe(ch)
+}
+
+func (d *apacheConfigDiscovery) Collect(ch chan<- prometheus.Metric) {
+	d.lastRefresh.Collect(ch)
+}
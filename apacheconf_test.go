@@ -0,0 +1,79 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApacheConfigDiscovery(t *testing.T) {
+	dir := writeApacheConfig(t, map[string]string{
+		"httpd.conf": "Listen 8000\n<Location /server-status>\nSetHandler server-status\n</Location>\n",
+	})
+	file := filepath.Join(dir, "httpd.conf")
+	d := newApacheConfigDiscovery(file)
+	if moved, err := d.refresh(); err != nil || !moved {
+		t.Fatalf("expected the status page to be found, got %v, %v", moved, err)
+	}
+	if got := d.targets(); len(got) != 1 || got[0].uri != "http://localhost:8000/server-status?auto" {
+		t.Errorf("unexpected targets %v", got)
+	}
+	if moved, _ := d.refresh(); moved {
+		t.Error("expected the status page not to move")
+	}
+
+	// A broken configuration keeps the previous status page.
+	if err := os.WriteFile(file, []byte("Listen 8000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.refresh(); err == nil || !strings.Contains(err.Error(), file) {
+		t.Errorf("expected an error naming the file, got %v", err)
+	}
+	if got := d.targets(); len(got) != 1 {
+		t.Errorf("expected the previous target, got %v", got)
+	}
+	if err := os.WriteFile(file, []byte("Listen 9000\n<Location /status>\nSetHandler server-status\n</Location>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := d.refresh(); err != nil || !moved {
+		t.Errorf("expected the status page to move, got %v, %v", moved, err)
+	}
+}
+
+// TestApacheConfigExplicitURI checks that -scrape.uri wins over the
+// discovered status page.
+func TestApacheConfigExplicitURI(t *testing.T) {
+	dir := writeApacheConfig(t, map[string]string{
+		"httpd.conf": "Listen 8000\n<Location /server-status>\nSetHandler server-status\n</Location>\n",
+	})
+	defer func(file string) { *apacheConfigFile = file }(*apacheConfigFile)
+	defer func(old []discoverer) { discoverers = old }(discoverers)
+	defer func(values []string, set bool) { scrapeURIs.values, scrapeURIs.set = values, set }(scrapeURIs.values, scrapeURIs.set)
+	*apacheConfigFile = filepath.Join(dir, "httpd.conf")
+	var apacheConfig discoveryMechanism
+	for _, m := range discoveryMechanisms {
+		if m.name == "apache-config" {
+			apacheConfig = m
+		}
+	}
+	newContext := func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }
+
+	scrapeURIs.values, scrapeURIs.set = []string{"http://other/server-status?auto"}, true
+	d, _, err := apacheConfig.setup(newContext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discoverers = []discoverer{d}
+	es, err := exportersFromFlags(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || es[0].URI != "http://other/server-status?auto" {
+		t.Errorf("expected -scrape.uri to win, got %v", es)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apacheListen is a Listen directive.
+type apacheListen struct {
+	addr     string // Host, empty for every address, and port.
+	protocol string // Given after the address, such as https.
+}
+
+// apacheStatusPage is where a configuration serves the status page.
+type apacheStatusPage struct {
+	uri      string
+	listen   string // The address it was found on.
+	location string
+	file     string // Setting the status handler.
+}
+
+// apacheConfigParser reads an apache configuration and what it includes.
+type apacheConfigParser struct {
+	// root is prepended to absolute paths, for configurations mounted
+	// elsewhere, such as in tests.
+	root string
+
+	serverRoot string
+	listens    []apacheListen
+	// The Location serving the status page, and the VirtualHost it is in,
+	// if any.
+	location, vhost, file string
+	vhostSSL              bool
+	depth                 int // Of includes, against loops.
+
+	// mpm is the MPM loaded so far, such as event, resolving the
+	// <IfModule> sections of MPMs.
+	mpm string
+	// values are the arguments of the directives of the main server, by
+	// their name in lower case, as of the last time each is given.
+	values map[string]string
+	// mtimes are when the files and the directories of includes read were
+	// modified, zero for those missing.
+	mtimes map[string]time.Time
+}
+
+// apacheBlock is a section of the configuration being parsed.
+type apacheBlock struct {
+	kind, arg string
+	ssl       bool // SSLEngine on, in a VirtualHost.
+	status    bool // SetHandler server-status, in a Location.
+	skip      bool // Not holding, as an <IfModule> of another MPM.
+}
+
+// apacheMPMs are the MPMs <IfModule> sections are resolved for.
+var apacheMPMs = map[string]bool{"event": true, "prefork": true, "worker": true, "winnt": true}
+
+// apacheMPM returns the MPM the module named name is, as in LoadModule
+// mpm_event_module or <IfModule event.c>, or "" for other modules.
+func apacheMPM(name string) string {
+	name = strings.ToLower(name)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".c"), "_module")
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "mod_"), "mpm_")
+	if apacheMPMs[name] {
+		return name
+	}
+	return ""
+}
+
+// holds tells whether the directives of the section b apply. Only the
+// <IfModule> sections of MPMs are resolved, against the MPM loaded, while
+// the others are all taken to hold.
+func (p *apacheConfigParser) holds(b apacheBlock) bool {
+	if b.kind != "ifmodule" {
+		return true
+	}
+	name := strings.TrimPrefix(b.arg, "!")
+	mpm := apacheMPM(name)
+	if mpm == "" {
+		return true
+	}
+	return (mpm == p.mpm) != (name != b.arg)
+}
+
+// parse parses the configuration file and what it includes.
+func (p *apacheConfigParser) parse(file string) error {
+	p.serverRoot = filepath.Dir(file)
+	p.values = map[string]string{}
+	p.mtimes = map[string]time.Time{}
+	return p.parseFile(p.path(file))
+}
+
+// statusPage parses the configuration file and returns where it serves
+// the status page.
+func (p *apacheConfigParser) statusPage(file string) (apacheStatusPage, error) {
+	if err := p.parse(file); err != nil {
+		return apacheStatusPage{}, err
+	}
+	if p.location == "" {
+		return apacheStatusPage{}, errors.New("no Location with SetHandler server-status")
+	}
+	listen, err := p.listen()
+	if err != nil {
+		return apacheStatusPage{}, err
+	}
+	host, port, err := net.SplitHostPort(listen.addr)
+	if err != nil {
+		return apacheStatusPage{}, fmt.Errorf("invalid Listen %s: %w", listen.addr, err)
+	}
+	scheme := "http"
+	if p.vhostSSL || strings.EqualFold(listen.protocol, "https") || listen.protocol == "" && port == "443" {
+		scheme = "https"
+	}
+	switch host {
+	case "", "*", "0.0.0.0", "::", "_default_":
+		host = "localhost"
+	}
+	if scheme == "http" && port == "80" || scheme == "https" && port == "443" {
+		port = ""
+	}
+	hostport := host
+	if port != "" {
+		hostport = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		hostport = "[" + host + "]"
+	}
+	return apacheStatusPage{
+		uri:      scheme + "://" + hostport + p.location + "?auto",
+		listen:   listen.addr,
+		location: p.location,
+		file:     p.file,
+	}, nil
+}
+
+// listen returns the address the status page is served on: that of its
+// VirtualHost, or for one outside any the first plain HTTP Listen.
+func (p *apacheConfigParser) listen() (apacheListen, error) {
+	if len(p.listens) == 0 {
+		return apacheListen{}, errors.New("no Listen directive")
+	}
+	if p.vhost != "" {
+		host, port, err := net.SplitHostPort(p.vhost)
+		if err != nil {
+			// No port: whichever port the server listens on.
+			host, port = p.vhost, ""
+		}
+		for _, l := range p.listens {
+			lhost, lport, _ := net.SplitHostPort(l.addr)
+			if port == "" || port == "*" || port == lport {
+				if host == "*" || host == "_default_" {
+					host = lhost
+				}
+				return apacheListen{net.JoinHostPort(host, lport), l.protocol}, nil
+			}
+		}
+		return apacheListen{}, fmt.Errorf("no Listen directive for the VirtualHost %s", p.vhost)
+	}
+	for _, l := range p.listens {
+		_, port, _ := net.SplitHostPort(l.addr)
+		if !strings.EqualFold(l.protocol, "https") && port != "443" {
+			return l, nil
+		}
+	}
+	return p.listens[0], nil
+}
+
+// path returns where the file of the configuration named name is.
+func (p *apacheConfigParser) path(name string) string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(p.serverRoot, name)
+	}
+	return filepath.Join(p.root, name)
+}
+
+// parseFile parses the configuration file at path, a path of the
+// filesystem rather than of the configuration.
+func (p *apacheConfigParser) parseFile(path string) error {
+	if p.depth > 16 {
+		return fmt.Errorf("includes nested too deep at %s", path)
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p.modified(path)
+	var blocks []apacheBlock
+	scanner := bufio.NewScanner(f)
+	var line string
+	for n := 1; scanner.Scan(); n++ {
+		line += scanner.Text()
+		// Directives may go on over several lines.
+		if strings.HasSuffix(line, "\\") {
+			line = strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		fields := apacheFields(line)
+		line = ""
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		directive := strings.ToLower(fields[0])
+		args := fields[1:]
+		if len(blocks) > 0 && blocks[len(blocks)-1].skip && !strings.HasPrefix(directive, "<") {
+			continue
+		}
+		if !strings.HasPrefix(directive, "<") && mainServer(blocks) {
+			p.values[directive] = strings.Join(args, " ")
+		}
+		switch {
+		case strings.HasPrefix(directive, "</"):
+			if len(blocks) == 0 {
+				return fmt.Errorf("%s:%d: %s without a section to close", path, n, fields[0])
+			}
+			b := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			if b.kind == "location" && b.status && p.location == "" {
+				p.location = b.arg
+				p.file = path
+				for _, outer := range blocks {
+					if outer.kind == "virtualhost" {
+						p.vhost, p.vhostSSL = outer.arg, outer.ssl
+					}
+				}
+			}
+		case strings.HasPrefix(directive, "<"):
+			b := apacheBlock{kind: strings.TrimSuffix(directive[1:], ">")}
+			if len(args) > 0 {
+				b.arg = strings.TrimSuffix(args[0], ">")
+			} else if i := strings.IndexByte(b.kind, '>'); i >= 0 {
+				b.kind = b.kind[:i]
+			}
+			b.skip = len(blocks) > 0 && blocks[len(blocks)-1].skip || !p.holds(b)
+			blocks = append(blocks, b)
+		case directive == "loadmodule" && len(args) > 0:
+			if mpm := apacheMPM(args[0]); mpm != "" {
+				p.mpm = mpm
+			}
+		case directive == "serverroot" && len(args) > 0 && len(blocks) == 0:
+			p.serverRoot = args[0]
+		case directive == "listen" && len(args) > 0:
+			l := apacheListen{addr: args[0]}
+			if _, err := strconv.Atoi(l.addr); err == nil {
+				l.addr = ":" + l.addr
+			}
+			if len(args) > 1 {
+				l.protocol = args[1]
+			}
+			p.listens = append(p.listens, l)
+		case directive == "sethandler" && len(args) > 0 && len(blocks) > 0:
+			if b := &blocks[len(blocks)-1]; b.kind == "location" && strings.EqualFold(args[0], "server-status") {
+				b.status = true
+			}
+		case directive == "sslengine" && len(args) > 0 && strings.EqualFold(args[0], "on"):
+			for i := len(blocks) - 1; i >= 0; i-- {
+				if blocks[i].kind == "virtualhost" {
+					blocks[i].ssl = true
+					break
+				}
+			}
+		case directive == "include" || directive == "includeoptional":
+			if len(args) == 0 {
+				continue
+			}
+			if err := p.include(args[0], directive == "includeoptional"); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses the files pattern names, a file, a directory or a glob,
+// in the order apache does. Unless optional, a file or directory must
+// exist, while a glob may match nothing as with wildcards in directories.
+func (p *apacheConfigParser) include(pattern string, optional bool) error {
+	path := p.path(pattern)
+	if !strings.ContainsAny(pattern, "*?[") {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Watched for the file to appear.
+			p.modified(filepath.Dir(path))
+			if optional {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return p.parseFile(path)
+		}
+		path = filepath.Join(path, "*")
+	}
+	// Watched for files added and removed.
+	p.modified(filepath.Dir(path))
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	for _, m := range matches {
+		if info, err := os.Stat(m); err != nil || info.IsDir() {
+			continue
+		}
+		if err := p.parseFile(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modified records when the file or directory at path was modified.
+func (p *apacheConfigParser) modified(path string) {
+	p.mtimes[path] = modTime(path)
+}
+
+// modTime returns when the file at path was modified, zero if it is
+// missing.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// mainServer tells whether directives in blocks apply to the main server,
+// rather than to a VirtualHost, a Directory or the like.
+func mainServer(blocks []apacheBlock) bool {
+	for _, b := range blocks {
+		if !strings.HasPrefix(b.kind, "if") && b.kind != "else" && b.kind != "elseif" {
+			return false
+		}
+	}
+	return true
+}
+
+// apacheFields splits a line of the configuration into its directive and
+// arguments, taking quoted ones whole.
+func apacheFields(line string) []string {
+	var fields []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if q := line[0]; q == '"' || q == '\'' {
+			if i := strings.IndexByte(line[1:], q); i >= 0 {
+				fields = append(fields, line[1:i+1])
+				line = line[i+2:]
+				continue
+			}
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			i = len(line)
+		}
+		fields = append(fields, line[:i])
+		line = line[i:]
+	}
+	return fields
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApacheConfigLayouts(t *testing.T) {
+	for _, tc := range []struct {
+		layout, file string
+		want         apacheStatusPage
+	}{
+		{"debian", "/etc/apache2/apache2.conf", apacheStatusPage{
+			uri:      "http://localhost/server-status?auto",
+			listen:   ":80",
+			location: "/server-status",
+			file:     "etc/apache2/mods-enabled/status.conf",
+		}},
+		{"rhel", "/etc/httpd/conf/httpd.conf", apacheStatusPage{
+			uri:      "http://127.0.0.1:8080/server-status?auto",
+			listen:   "127.0.0.1:8080",
+			location: "/server-status",
+			file:     "etc/httpd/conf.d/status.conf",
+		}},
+	} {
+		root := filepath.Join("testdata", "apacheconf", tc.layout)
+		got, err := (&apacheConfigParser{root: root}).statusPage(tc.file)
+		if err != nil {
+			t.Errorf("%s: %v", tc.layout, err)
+			continue
+		}
+		tc.want.file = filepath.Join(root, tc.want.file)
+		if got != tc.want {
+			t.Errorf("%s: expected %+v, got %+v", tc.layout, tc.want, got)
+		}
+	}
+}
+
+// writeApacheConfig writes files, by their path below a new directory, and
+// returns the directory.
+func writeApacheConfig(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestApacheConfigStatusPage(t *testing.T) {
+	const status = "<Location /status>\n  SetHandler server-status\n</Location>\n"
+	for _, tc := range []struct {
+		name, config, want string
+	}{
+		{"plain", "Listen 8080\n" + status, "http://localhost:8080/status?auto"},
+		{"https first", "Listen 443 https\nListen 0.0.0.0:80\n" + status, "http://localhost/status?auto"},
+		{"https only", "Listen 8443 https\n" + status, "https://localhost:8443/status?auto"},
+		{"ipv6", "Listen [::1]:81\n" + status, "http://[::1]:81/status?auto"},
+		{"quoted", "Listen 80\n<Location \"/server status\">\n SetHandler Server-Status\n</Location>\n", "http://localhost/server status?auto"},
+		{"ssl vhost", "Listen 80\nListen 8443\n<VirtualHost *:8443>\n SSLEngine on\n" + status + "</VirtualHost>\n", "https://localhost:8443/status?auto"},
+		{"vhost without port", "Listen 10.0.0.1:8000\n<VirtualHost *>\n" + status + "</VirtualHost>\n", "http://10.0.0.1:8000/status?auto"},
+		{"other handlers", "Listen 80\n<Location /info>\n SetHandler server-info\n</Location>\n" + status, "http://localhost/status?auto"},
+		{"continued", "Listen \\\n  8081\n" + status, "http://localhost:8081/status?auto"},
+		{"comments", "# Listen 1\nListen 82\n#<Location /old>\n#SetHandler server-status\n#</Location>\n" + status, "http://localhost:82/status?auto"},
+	} {
+		dir := writeApacheConfig(t, map[string]string{"httpd.conf": tc.config})
+		got, err := (&apacheConfigParser{}).statusPage(filepath.Join(dir, "httpd.conf"))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got.uri != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.want, got.uri)
+		}
+	}
+}
+
+func TestApacheConfigIncludes(t *testing.T) {
+	dir := writeApacheConfig(t, map[string]string{
+		"httpd.conf":          "Include ports.conf\nIncludeOptional conf.d/*.conf\nIncludeOptional missing.conf\nIncludeOptional nothing/*.conf\nInclude sites\n",
+		"ports.conf":          "Listen 8000\n",
+		"conf.d/a.conf":       "<Location /first>\nSetHandler server-status\n</Location>\n",
+		"conf.d/b.conf":       "<Location /second>\nSetHandler server-status\n</Location>\n",
+		"conf.d/skipped.load": "Listen 1\n",
+		"sites/default":       "<VirtualHost *:8000>\n</VirtualHost>\n",
+	})
+	got, err := (&apacheConfigParser{}).statusPage(filepath.Join(dir, "httpd.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first Location in the order of the includes.
+	if want := "http://localhost:8000/first?auto"; got.uri != want {
+		t.Errorf("expected %s, got %s", want, got.uri)
+	}
+	if want := filepath.Join(dir, "conf.d", "a.conf"); got.file != want {
+		t.Errorf("expected the status page to be found in %s, got %s", want, got.file)
+	}
+
+	for name, files := range map[string]map[string]string{
+		"no status page":        {"httpd.conf": "Listen 80\n"},
+		"no listen":             {"httpd.conf": "<Location /s>\nSetHandler server-status\n</Location>\n"},
+		"missing include":       {"httpd.conf": "Include missing.conf\n"},
+		"include loop":          {"httpd.conf": "Include httpd.conf\n"},
+		"unbalanced":            {"httpd.conf": "</Location>\n"},
+		"vhost not listened on": {"httpd.conf": "Listen 80\n<VirtualHost *:81>\n<Location /s>\nSetHandler server-status\n</Location>\n</VirtualHost>\n"},
+	} {
+		dir := writeApacheConfig(t, files)
+		if _, err := (&apacheConfigParser{}).statusPage(filepath.Join(dir, "httpd.conf")); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestApacheConfigMPM(t *testing.T) {
+	const sections = "<IfModule mpm_prefork_module>\nServerLimit 1\n</IfModule>\n<IfModule event.c>\nServerLimit 2\n</IfModule>\n<IfModule !mpm_worker_module>\nTimeout 3\n</IfModule>\n<IfModule ssl_module>\n<IfModule mpm_event_module>\nKeepAliveTimeout 4\n</IfModule>\n</IfModule>\n"
+	for _, tc := range []struct {
+		name, config string
+		mpm          string
+		want         map[string]string
+	}{
+		{"prefork", "LoadModule mpm_prefork_module modules/mod_mpm_prefork.so\n" + sections, "prefork", map[string]string{"serverlimit": "1", "timeout": "3"}},
+		{"event", "LoadModule mpm_event_module modules/mod_mpm_event.so\n" + sections, "event", map[string]string{"serverlimit": "2", "timeout": "3", "keepalivetimeout": "4"}},
+		{"worker", "LoadModule mpm_worker_module modules/mod_mpm_worker.so\n" + sections, "worker", map[string]string{}},
+		// Sections before the MPM is loaded don't hold, as with apache.
+		{"loaded after", sections + "LoadModule mpm_event_module modules/mod_mpm_event.so\n", "event", map[string]string{"timeout": "3"}},
+		{"vhost", "Timeout 10\n<VirtualHost *:80>\nTimeout 20\n</VirtualHost>\n<Directory />\nTimeout 30\n</Directory>\n", "", map[string]string{"timeout": "10"}},
+		{"last wins", "Timeout 10\nTimeout 20\n", "", map[string]string{"timeout": "20"}},
+	} {
+		dir := writeApacheConfig(t, map[string]string{"httpd.conf": tc.config})
+		p := &apacheConfigParser{}
+		if err := p.parse(filepath.Join(dir, "httpd.conf")); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if p.mpm != tc.mpm {
+			t.Errorf("%s: expected the %q MPM, got %q", tc.name, tc.mpm, p.mpm)
+		}
+		for _, d := range []string{"serverlimit", "timeout", "keepalivetimeout"} {
+			if got, want := p.values[d], tc.want[d]; got != want {
+				t.Errorf("%s: expected %s %q, got %q", tc.name, d, want, got)
+			}
+		}
+	}
+}
+
+func TestApacheMPM(t *testing.T) {
+	for name, want := range map[string]string{
+		"mpm_event_module":    "event",
+		"mpm_prefork.c":       "prefork",
+		"worker.c":            "worker",
+		"mod_mpm_event.c":     "event",
+		"MPM_WinNT_module":    "winnt",
+		"mpm_itk_module":      "",
+		"status_module":       "",
+		"mod_status.c":        "",
+		"event_notify_module": "",
+	} {
+		if got := apacheMPM(name); got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	healthMaxBusyRatio = flag.Float64("health.max-busy-ratio", 0, "Share of the workers of a target that may be busy before /healthz/apache fails, such as 0.95. 0 doesn't check.")
+	healthRequireIdle  = flag.Int("health.require-idle-workers", 0, "Idle workers a target must have for /healthz/apache to pass.")
+	healthMaxScrapeAge = flag.Duration("health.max-scrape-age", 0, "Age of the latest scrape past which /healthz/apache fails, so that a load balancer isn't told a target is fine on old news. 0 doesn't check.")
+)
+
+// healthThresholds are what /healthz/apache checks besides the latest
+// scrape succeeding.
+type healthThresholds struct {
+	maxBusyRatio float64
+	requireIdle  int
+	maxAge       time.Duration
+}
+
+func healthThresholdsFromFlags() healthThresholds {
+	return healthThresholds{*healthMaxBusyRatio, *healthRequireIdle, *healthMaxScrapeAge}
+}
+
+func validateHealthThresholds(h healthThresholds) error {
+	if h.maxBusyRatio < 0 || h.maxBusyRatio > 1 {
+		return fmt.Errorf("-health.max-busy-ratio must be between 0 and 1, got %v", h.maxBusyRatio)
+	}
+	if h.requireIdle < 0 {
+		return fmt.Errorf("-health.require-idle-workers can't be negative, got %d", h.requireIdle)
+	}
+	return nil
+}
+
+// healthCheck is a condition of /healthz/apache that failed.
+type healthCheck struct {
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// targetHealth is a target in /healthz/apache.
+type targetHealth struct {
+	Name    string        `json:"name"`
+	Healthy bool          `json:"healthy"`
+	Failed  []healthCheck `json:"failed"`
+}
+
+// health checks the latest scrape of e against h at now, without
+// scraping.
+func (e *Exporter) health(h healthThresholds, now time.Time) targetHealth {
+	th := targetHealth{Name: e.name, Failed: []healthCheck{}}
+	e.last.mutex.Lock()
+	at, err, status := e.last.at, e.last.err, e.last.status
+	e.last.mutex.Unlock()
+	switch {
+	case at.IsZero():
+		th.Failed = append(th.Failed, healthCheck{"up", "not scraped yet"})
+	case err != nil:
+		th.Failed = append(th.Failed, healthCheck{"up", err.Error()})
+	}
+	if !at.IsZero() && h.maxAge > 0 {
+		if age := now.Sub(at); age > h.maxAge {
+			th.Failed = append(th.Failed, healthCheck{"max_scrape_age", fmt.Sprintf("latest scrape is %s old, more than %s", age.Round(time.Second), h.maxAge)})
+		}
+	}
+	if err == nil && status != nil {
+		busy, idle := status.BusyWorkers, status.IdleWorkers
+		if h.maxBusyRatio > 0 && busy+idle > 0 && busy/(busy+idle) > h.maxBusyRatio {
+			th.Failed = append(th.Failed, healthCheck{"max_busy_ratio", fmt.Sprintf("%g of %g workers busy, more than %g", busy, busy+idle, h.maxBusyRatio)})
+		}
+		if idle < float64(h.requireIdle) {
+			th.Failed = append(th.Failed, healthCheck{"require_idle_workers", fmt.Sprintf("%g idle workers, fewer than %d", idle, h.requireIdle)})
+		}
+	}
+	th.Healthy = len(th.Failed) == 0
+	return th
+}
+
+// apacheHealthHandler is 200 if the latest scrapes of the targets of s,
+// or of the one named by the target parameter, passed h, and 503 if not,
+// with the conditions that failed as JSON. It never scrapes, as load
+// balancers may ask several times a second: the scrapes are those of
+// -scrape.interval or of Prometheus.
+func apacheHealthHandler(s *targetSet, h healthThresholds) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("target")
+		now := time.Now()
+		targets := []targetHealth{}
+		healthy := true
+		found := false
+		for _, e := range s.current() {
+			if name != "" && e.name != name {
+				continue
+			}
+			found = true
+			th := e.health(h, now)
+			healthy = healthy && th.Healthy
+			targets = append(targets, th)
+		}
+		if name != "" && !found {
+			http.Error(w, fmt.Sprintf("Unknown target %q", name), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"healthy": healthy, "targets": targets})
+	})
+}
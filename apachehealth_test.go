@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthThresholds(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name       string
+		at         time.Time
+		err        error
+		busy, idle float64
+		thresholds healthThresholds
+		failed     []string
+	}{
+		{"not scraped", time.Time{}, nil, 0, 0, healthThresholds{}, []string{"up"}},
+		{"down", now, errors.New("connection refused"), 0, 0, healthThresholds{maxBusyRatio: 0.5}, []string{"up"}},
+		{"no thresholds", now, nil, 10, 0, healthThresholds{}, nil},
+		{"busy", now, nil, 96, 4, healthThresholds{maxBusyRatio: 0.95}, []string{"max_busy_ratio"}},
+		{"busy enough", now, nil, 95, 5, healthThresholds{maxBusyRatio: 0.95}, nil},
+		{"no idle", now, nil, 10, 0, healthThresholds{requireIdle: 1}, []string{"require_idle_workers"}},
+		{"idle", now, nil, 10, 1, healthThresholds{requireIdle: 1}, nil},
+		{"saturated", now, nil, 10, 0, healthThresholds{maxBusyRatio: 0.95, requireIdle: 1}, []string{"max_busy_ratio", "require_idle_workers"}},
+		{"stale", now.Add(-time.Minute), nil, 1, 9, healthThresholds{maxAge: 30 * time.Second}, []string{"max_scrape_age"}},
+		{"recent", now.Add(-time.Minute), nil, 1, 9, healthThresholds{maxAge: 2 * time.Minute}, nil},
+	} {
+		e := NewExporter("http://localhost/server-status?auto")
+		if !c.at.IsZero() {
+			e.last.set(c.at, time.Millisecond, c.err)
+			e.last.setStatus(&serverStatus{BusyWorkers: c.busy, IdleWorkers: c.idle})
+		}
+		th := e.health(c.thresholds, now)
+		var failed []string
+		for _, f := range th.Failed {
+			failed = append(failed, f.Check)
+		}
+		if th.Healthy != (len(c.failed) == 0) || len(failed) != len(c.failed) {
+			t.Errorf("%s: expected %v to fail, got %+v", c.name, c.failed, th)
+			continue
+		}
+		for i := range failed {
+			if failed[i] != c.failed[i] {
+				t.Errorf("%s: expected %v to fail, got %v", c.name, c.failed, failed)
+			}
+		}
+	}
+}
+
+func TestApacheHealthHandler(t *testing.T) {
+	var requests, saturated int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&saturated) == 1 {
+			w.Write([]byte("BusyWorkers: 10\nIdleWorkers: 0\n"))
+			return
+		}
+		w.Write([]byte("BusyWorkers: 2\nIdleWorkers: 8\n"))
+	}))
+	defer ts.Close()
+	e := NewExporter(ts.URL)
+	e.name = "web01"
+	h := apacheHealthHandler(newTargetSet(Exporters{e}, nil), healthThresholds{maxBusyRatio: 0.95, requireIdle: 1})
+	get := func(target string) (int, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz/apache?target="+target, nil))
+		var body map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr.Code, body
+	}
+
+	if code, _ := get(""); code != http.StatusServiceUnavailable {
+		t.Errorf("expected a 503 before the first scrape, got %d", code)
+	}
+	e.Warmup()
+	for i := 0; i < 5; i++ {
+		if code, body := get(""); code != http.StatusOK || body["healthy"] != true {
+			t.Errorf("expected a 200, got %d: %v", code, body)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected the health checks to use the latest scrape, got %d scrapes", n)
+	}
+
+	atomic.StoreInt32(&saturated, 1)
+	if code, _ := get("web01"); code != http.StatusOK {
+		t.Errorf("expected a 200 until the next scrape, got %d", code)
+	}
+	e.Warmup()
+	code, body := get("web01")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 once apache is saturated, got %d", code)
+	}
+	failed := body["targets"].([]interface{})[0].(map[string]interface{})["failed"].([]interface{})
+	if len(failed) != 2 || failed[0].(map[string]interface{})["check"] != "max_busy_ratio" {
+		t.Errorf("expected the busy ratio and idle workers to fail, got %v", failed)
+	}
+	if code, _ := get("web02"); code != http.StatusNotFound {
+		t.Errorf("expected a 404 for an unknown target, got %d", code)
+	}
+}
+
+func TestValidateHealthThresholds(t *testing.T) {
+	for _, h := range []healthThresholds{{maxBusyRatio: 1.5}, {maxBusyRatio: -0.1}, {requireIdle: -1}} {
+		if err := validateHealthThresholds(h); err == nil {
+			t.Errorf("%+v: expected an error", h)
+		}
+	}
+	if err := validateHealthThresholds(healthThresholds{maxBusyRatio: 0.95, requireIdle: 1}); err != nil {
+		t.Error(err)
+	}
+}
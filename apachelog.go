@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apacheLogPath   = flag.String("accesslog.path", "", "Access log of the apache running on the same host, such as /var/log/apache2/access.log, tailed for apache_http_responses_total, apache_http_response_bytes_total and, with %D or %T in -accesslog.format, apache_http_response_duration_seconds. Rotation and truncation are followed. Empty to not tail any.")
+	apacheLogFormat = flag.String("accesslog.format", "combined", "Format of -accesslog.path: common, combined, vhost_combined or a LogFormat string, such as '%h %l %u %t \"%r\" %>s %b %D'. Formats with %v are labeled by vhost.")
+)
+
+// accessLogFormats are the formats of the default apache configuration, by
+// their nicknames.
+var accessLogFormats = map[string]string{
+	"common":         `%h %l %u %t "%r" %>s %b`,
+	"combined":       `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`,
+	"vhost_combined": `%v:%p %h %l %u %t "%r" %>s %O "%{Referer}i" "%{User-Agent}i"`,
+}
+
+// accessLogField is a directive of a LogFormat the exporter takes, or one it
+// skips, followed by the text up to the next directive.
+type accessLogField struct {
+	field   byte    // Such as 's' for the status, 0 for one skipped.
+	scale   float64 // Of durations, to seconds.
+	literal string
+}
+
+// accessLogFormat is a compiled LogFormat.
+type accessLogFormat struct {
+	prefix string // Before the first directive.
+	fields []accessLogField
+	// What the format has.
+	duration, vhost bool
+}
+
+// parseAccessLogFormat compiles the LogFormat format, or the one nicknamed so.
+func parseAccessLogFormat(format string) (*accessLogFormat, error) {
+	if f, ok := accessLogFormats[format]; ok {
+		format = f
+	}
+	f := &accessLogFormat{}
+	var status, size byte
+	literal := &f.prefix
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			*literal += string(c)
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			*literal += "%"
+			i++
+			continue
+		}
+		// Conditions on statuses and < or > for the original or final
+		// request, then an argument in braces.
+		j := i + 1
+		for j < len(format) && strings.IndexByte("<>!,0123456789", format[j]) >= 0 {
+			j++
+		}
+		final := strings.IndexByte(format[i+1:j], '>') >= 0
+		var arg string
+		if j < len(format) && format[j] == '{' {
+			end := strings.IndexByte(format[j:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated argument in %q", format[i:])
+			}
+			arg, j = format[j+1:j+end], j+end+1
+		}
+		if j >= len(format) {
+			return nil, fmt.Errorf("directive missing at the end of %q", format)
+		}
+		field := accessLogField{}
+		switch d := format[j]; d {
+		case 's':
+			// The final status wins over the original.
+			if status == 0 || final {
+				f.clear('s')
+				field.field, status = 's', d
+			}
+		case 'b', 'B', 'O':
+			// The size of the body wins over those with the headers.
+			if size == 0 || size == 'O' && d != 'O' {
+				f.clear('b')
+				field.field, size = 'b', d
+			}
+		case 'D':
+			field.field, field.scale = 'D', 1e-6
+		case 'T':
+			field.field = 'D'
+			switch arg {
+			case "", "s":
+				field.scale = 1
+			case "ms":
+				field.scale = 1e-3
+			case "us":
+				field.scale = 1e-6
+			default:
+				return nil, fmt.Errorf("unknown unit %q of %%T", arg)
+			}
+		case 'v', 'V':
+			field.field = 'v'
+		case 't':
+			field.field = 't'
+		}
+		// The first duration and vhost win.
+		switch {
+		case field.field == 'D' && f.duration, field.field == 'v' && f.vhost:
+			field.field = 0
+		case field.field == 'D':
+			f.duration = true
+		case field.field == 'v':
+			f.vhost = true
+		}
+		f.fields = append(f.fields, field)
+		literal = &f.fields[len(f.fields)-1].literal
+		i = j
+	}
+	if status == 0 {
+		return nil, errors.New("the log format has no status, %s or %>s")
+	}
+	return f, nil
+}
+
+// clear skips the fields of f taken as field, superseded by another.
+func (f *accessLogFormat) clear(field byte) {
+	for i := range f.fields {
+		if f.fields[i].field == field {
+			f.fields[i].field = 0
+		}
+	}
+}
+
+// accessLogLine is what the exporter takes of a line of the access log.
+type accessLogLine struct {
+	status   int
+	bytes    float64
+	duration float64 // In seconds.
+	vhost    string
+}
+
+// parse parses line as formatted by f. Values run up to the text after
+// their directive, skipping backslash escapes as in quoted requests, with
+// times in brackets taken whole.
+func (f *accessLogFormat) parse(line string) (accessLogLine, bool) {
+	if !strings.HasPrefix(line, f.prefix) {
+		return accessLogLine{}, false
+	}
+	line = line[len(f.prefix):]
+	var l accessLogLine
+	status := false
+	for _, field := range f.fields {
+		end := len(line)
+		if field.field == 't' && strings.HasPrefix(line, "[") {
+			if end = strings.IndexByte(line, ']') + 1; end == 0 {
+				return accessLogLine{}, false
+			}
+		} else if field.literal != "" {
+			end = -1
+			for i := 0; i < len(line); i++ {
+				if line[i] == '\\' {
+					i++
+				} else if strings.HasPrefix(line[i:], field.literal) {
+					end = i
+					break
+				}
+			}
+			if end < 0 {
+				return accessLogLine{}, false
+			}
+		}
+		value := line[:end]
+		if !strings.HasPrefix(line[end:], field.literal) {
+			return accessLogLine{}, false
+		}
+		line = line[end+len(field.literal):]
+
+		switch field.field {
+		case 's':
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 100 || v > 999 {
+				return accessLogLine{}, false
+			}
+			l.status, status = v, true
+		case 'b':
+			if value != "-" {
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return accessLogLine{}, false
+				}
+				l.bytes = v
+			}
+		case 'D':
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return accessLogLine{}, false
+			}
+			l.duration = v * field.scale
+		case 'v':
+			l.vhost = value
+		}
+	}
+	return l, status
+}
+
+// apacheLog tails the access log of apache into metrics of the responses.
+type apacheLog struct {
+	logTail
+	format *accessLogFormat
+	vhosts *vhostNormalizer
+
+	responses *prometheus.CounterVec
+	bytes     *prometheus.CounterVec
+	durations *prometheus.HistogramVec // Nil without durations in the format.
+	unparsed  prometheus.Counter
+}
+
+func newApacheLog(path, format string) (*apacheLog, error) {
+	f, err := parseAccessLogFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("-accesslog.format: %w", err)
+	}
+	var labels []string
+	if f.vhost {
+		labels = []string{"vhost"}
+	}
+	l := &apacheLog{
+		logTail: newLogTail(path, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_accesslog_lines_dropped_total",
+			Help:        "Lines of the access log of apache left out as they came faster than they could be parsed.",
+			ConstLabels: withConstLabels(nil),
+		})),
+		format: f,
+		vhosts: vhostNormalizerFromFlags(),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "http_responses_total",
+			Help:        "Responses in the access log of apache, by the class of their status such as 5xx.",
+			ConstLabels: withConstLabels(nil),
+		}, append([]string{"code_class"}, labels...)),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "http_response_bytes_total",
+			Help:        "Bytes of the responses in the access log of apache.",
+			ConstLabels: withConstLabels(nil),
+		}, labels),
+		unparsed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_accesslog_lines_unparsed_total",
+			Help:        "Lines of the access log of apache left out as they didn't match -accesslog.format.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+	if f.duration {
+		l.durations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "http_response_duration_seconds",
+			Help:        "How long apache took to serve the responses in its access log.",
+			ConstLabels: withConstLabels(nil),
+			Buckets:     prometheus.DefBuckets,
+		}, labels)
+	}
+	return l, nil
+}
+
+func (l *apacheLog) Describe(ch chan<- *prometheus.Desc) {
+	l.responses.Describe(ch)
+	l.bytes.Describe(ch)
+	if l.durations != nil {
+		l.durations.Describe(ch)
+	}
+	l.dropped.Describe(ch)
+	l.unparsed.Describe(ch)
+}
+
+func (l *apacheLog) Collect(ch chan<- prometheus.Metric) {
+	l.responses.Collect(ch)
+	l.bytes.Collect(ch)
+	if l.durations != nil {
+		l.durations.Collect(ch)
+	}
+	l.dropped.Collect(ch)
+	l.unparsed.Collect(ch)
+}
+
+// observe takes a line of the log into the metrics.
+func (l *apacheLog) observe(line string) {
+	parsed, ok := l.format.parse(line)
+	if !ok {
+		l.unparsed.Inc()
+		return
+	}
+	var labels []string
+	if l.format.vhost {
+		labels = []string{l.vhosts.normalize(parsed.vhost)}
+	}
+	l.responses.WithLabelValues(append([]string{strconv.Itoa(parsed.status/100) + "xx"}, labels...)...).Inc()
+	l.bytes.WithLabelValues(labels...).Add(parsed.bytes)
+	if l.durations != nil {
+		l.durations.WithLabelValues(labels...).Observe(parsed.duration)
+	}
+}
+
+// run tails the log until done is closed.
+func (l *apacheLog) run(done <-chan struct{}) {
+	l.tail(done, l.observe)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// accessLogExposition returns the metrics of l in the text format.
+func accessLogExposition(t *testing.T, l *apacheLog) []byte {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(l)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeText(&buf, mfs); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAccessLogFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		name, format string
+	}{
+		{"common", "common"},
+		{"combined", "combined"},
+		{"vhost_combined", "vhost_combined"},
+		{"duration", `%h %l %u %t "%r" %>s %b %D`},
+		{"milliseconds", `%V %>s %O %{ms}T %t "%r"`},
+	} {
+		l, err := newApacheLog("access.log", tc.format)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		f, err := os.Open(filepath.Join("testdata", "accesslog", tc.name+".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			l.observe(scanner.Text())
+		}
+		f.Close()
+
+		got := accessLogExposition(t, l)
+		golden := filepath.Join("testdata", "accesslog", tc.name+".prom")
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := compareExpositions(got, want); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+	}
+}
+
+func TestParseAccessLogFormat(t *testing.T) {
+	for _, tc := range []struct {
+		format, line string
+		want         accessLogLine
+	}{
+		// The final status and the size of the body win, wherever they are.
+		{`%s %>s %O %b`, "302 200 1200 900", accessLogLine{status: 200, bytes: 900}},
+		{`%>s %s %b %O`, "200 302 900 1200", accessLogLine{status: 200, bytes: 900}},
+		{`%400,501{User-agent}i %>s %B`, "- 200 0", accessLogLine{status: 200}},
+		{`%>s %T %D`, "200 2 1843", accessLogLine{status: 200, duration: 2}},
+		{`%>s %{us}T`, "200 1843", accessLogLine{status: 200, duration: 0.001843}},
+		{`%v %V %>s`, "www.example.com example.com 200", accessLogLine{status: 200, vhost: "www.example.com"}},
+		{`[%{%d/%b/%Y %T}t] %>s 100%%`, "[14/Oct/2026 09:12:01] 200 100%", accessLogLine{status: 200}},
+		{`%t %>s`, "[14/Oct/2026:09:12:01 +0000] 200", accessLogLine{status: 200}},
+	} {
+		f, err := parseAccessLogFormat(tc.format)
+		if err != nil {
+			t.Errorf("%s: %s", tc.format, err)
+			continue
+		}
+		if got, ok := f.parse(tc.line); !ok || got != tc.want {
+			t.Errorf("%s: expected %+v from %q, got %+v, %v", tc.format, tc.want, tc.line, got, ok)
+		}
+	}
+
+	for _, format := range []string{`%h %b`, `%>s %{Referer`, `%>s %{m}T`, `%>s %`} {
+		if _, err := parseAccessLogFormat(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+	if _, err := newApacheLog("access.log", "%h"); err == nil || !strings.Contains(err.Error(), "-accesslog.format") {
+		t.Errorf("expected an error naming the flag, got %v", err)
+	}
+}
+
+// responses returns how many responses of class l counted.
+func responses(t *testing.T, l *apacheLog, class string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := l.responses.WithLabelValues(class).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// waitResponses waits for l to count want responses of class.
+func waitResponses(t *testing.T, l *apacheLog, class string, want float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for responses(t, l, class) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v responses of %s, got %v", want, class, responses(t, l, class))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAccessLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	line := func(status string) string {
+		return `192.0.2.10 - - [14/Oct/2026:09:12:01 +0000] "GET / HTTP/1.1" ` + status + " 100\n"
+	}
+	// Already there, so left out.
+	appendFile(t, path, line("500"))
+
+	l, err := newApacheLog(path, "common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.poll = 10 * time.Millisecond
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		l.run(done)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	// Until it has opened the log at its end.
+	time.Sleep(50 * time.Millisecond)
+
+	appendFile(t, path, line("200"), line("200"))
+	waitResponses(t, l, "2xx", 2)
+
+	// A line written in two goes is counted once whole.
+	appendFile(t, path, line("404")[:20])
+	time.Sleep(30 * time.Millisecond)
+	appendFile(t, path, line("404")[20:])
+	waitResponses(t, l, "4xx", 1)
+
+	// Rotated as by logrotate, with a line written to the old log after
+	// the rename.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", line("301"))
+	appendFile(t, path, line("200"))
+	waitResponses(t, l, "3xx", 1)
+	waitResponses(t, l, "2xx", 3)
+
+	// Truncated as by copytruncate.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	appendFile(t, path, line("503"))
+	waitResponses(t, l, "5xx", 1)
+	waitResponses(t, l, "2xx", 3)
+
+	if responses(t, l, "5xx") != 1 {
+		t.Error("expected the line there at startup left out")
+	}
+}
+
+func BenchmarkAccessLogObserve(b *testing.B) {
+	l, err := newApacheLog("access.log", "vhost_combined")
+	if err != nil {
+		b.Fatal(err)
+	}
+	line := `www.example.com:443 192.0.2.10 - - [14/Oct/2026:09:12:01 +0000] "GET /app.js HTTP/1.1" 200 52310 "https://www.example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`
+	for i := 0; i < b.N; i++ {
+		l.observe(line)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// lastScrape is the outcome of an exporter's latest scrape.
+type lastScrape struct {
+	mutex    sync.Mutex
+	at       time.Time
+	duration time.Duration
+	err      error
+	status   *serverStatus // Of the latest scrape that got this far.
+}
+
+func (l *lastScrape) set(at time.Time, duration time.Duration, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.at, l.duration, l.err = at, duration, err
+}
+
+// up tells whether the last scrape succeeded, false before the first.
+func (l *lastScrape) up() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return !l.at.IsZero() && l.err == nil
+}
+
+func (l *lastScrape) setStatus(s *serverStatus) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.status = s
+}
+
+func (l *lastScrape) serverStatus() *serverStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.status
+}
+
+// serverStatus is what a target's status page said, in /api/v1/status.
+// Values missing from the page are 0.
+type serverStatus struct {
+	TotalAccesses float64 `json:"totalAccesses"`
+	TotalKBytes   float64 `json:"totalKBytes"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	BusyWorkers   float64 `json:"busyWorkers"`
+	IdleWorkers   float64 `json:"idleWorkers"`
+	// Scoreboard counts the worker slots in each state, named as in
+	// scoreboardStates.
+	Scoreboard map[string]int `json:"scoreboard"`
+}
+
+// scoreboardStates names the states of the scoreboard characters apache
+// documents; others are counted as unknown.
+var scoreboardStates = map[rune]string{
+	'_': "waiting",
+	'S': "starting",
+	'R': "reading",
+	'W': "sending",
+	'K': "keepalive",
+	'D': "dns",
+	'C': "closing",
+	'L': "logging",
+	'G': "finishing",
+	'I': "idle_cleanup",
+	'.': "open",
+}
+
+func newServerStatus(values map[string]float64, scoreboard string) *serverStatus {
+	s := &serverStatus{
+		TotalAccesses: values["Total Accesses"],
+		TotalKBytes:   values["Total kBytes"],
+		UptimeSeconds: values["Uptime"],
+		BusyWorkers:   values["BusyWorkers"],
+		IdleWorkers:   values["IdleWorkers"],
+		Scoreboard:    map[string]int{},
+	}
+	for _, c := range scoreboard {
+		state, ok := scoreboardStates[c]
+		if !ok {
+			state = "unknown"
+		}
+		s.Scoreboard[state]++
+	}
+	return s
+}
+
+// targetStatus describes a target in /api/v1/targets, with field names
+// following the targets API of Prometheus.
+type targetStatus struct {
+	Name               string            `json:"name"`
+	URI                string            `json:"uri"`
+	Labels             map[string]string `json:"labels"`
+	Collectors         []string          `json:"collectors"`
+	Health             string            `json:"health"`
+	LastScrape         *time.Time        `json:"lastScrape"`
+	LastScrapeDuration float64           `json:"lastScrapeDuration"`
+	LastError          string            `json:"lastError"`
+	// MaintenanceUntil is null unless the target is in maintenance.
+	MaintenanceUntil *time.Time `json:"maintenanceUntil"`
+	// Capabilities tells whether the target has each capability, of those
+	// checked within -capabilities.recheck-interval.
+	Capabilities map[string]bool `json:"capabilities"`
+}
+
+func (e *Exporter) status() targetStatus {
+	s := targetStatus{
+		Name:         e.name,
+		URI:          sanitizeURI(e.URI),
+		Labels:       map[string]string{},
+		Collectors:   e.collectorNames(),
+		Health:       "unknown",
+		Capabilities: e.capabilities.snapshot(time.Now()),
+	}
+	for name, value := range e.labels {
+		s.Labels[name] = value
+	}
+	if until := e.maintenanceUntil(time.Now()); !until.IsZero() {
+		s.MaintenanceUntil = &until
+	}
+
+	e.last.mutex.Lock()
+	defer e.last.mutex.Unlock()
+	if e.last.at.IsZero() {
+		return s
+	}
+	at := e.last.at
+	s.LastScrape = &at
+	s.LastScrapeDuration = e.last.duration.Seconds()
+	s.Health = "up"
+	if e.last.err != nil {
+		s.Health = "down"
+		s.LastError = e.last.err.Error()
+	}
+	return s
+}
+
+// targetServerStatus describes a target in /api/v1/status.
+type targetServerStatus struct {
+	targetStatus
+	// Status is null until a scrape parsed the status page.
+	Status *serverStatus `json:"status"`
+}
+
+func (e *Exporter) serverStatus() targetServerStatus {
+	s := targetServerStatus{targetStatus: e.status()}
+	e.last.mutex.Lock()
+	defer e.last.mutex.Unlock()
+	s.Status = e.last.status
+	return s
+}
+
+// collectorNames returns the groups of apache metrics e exports.
+func (e *Exporter) collectorNames() []string {
+	var names []string
+	if e.collectors == nil {
+		// Those switched on, leaving out the opt-in ones by default.
+		for _, name := range config.Collectors {
+			if collectorEnabled[name] {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	for name := range e.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targetsAPIHandler lists the targets of s and the outcome of their last
+// scrapes as JSON.
+func targetsAPIHandler(s *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets := []targetStatus{}
+		for _, e := range s.current() {
+			targets = append(targets, e.status())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"activeTargets": targets},
+		})
+	})
+}
+
+// statusAPIHandler serves the values the status pages of the targets of s
+// last had as JSON, next to the outcome of their last scrapes. With scrape
+// set, the targets are scraped first, the way /metrics would.
+func statusAPIHandler(s *targetSet, scrape bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scrape {
+			ctx, cancel := scrapeContext(r)
+			defer cancel()
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(contextCollector{s, ctx})
+			reg.Gather()
+		}
+		targets := []targetServerStatus{}
+		for _, e := range s.current() {
+			targets = append(targets, e.serverStatus())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"targets": targets},
+		})
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTargetsAPI(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	failingURI := strings.TrimPrefix(failing.URL, "http://") + "/server-status?auto&token=s3cret"
+	es, err := setupExporters([]string{"healthy=" + healthy.URL, "failing=http://monitor:s3cret@" + failingURI}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, nil)
+	get := func() (string, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		targetsAPIHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/targets", nil))
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected JSON, got %s", ct)
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.String(), resp
+	}
+
+	_, resp := get()
+	for _, target := range resp["data"].(map[string]interface{})["activeTargets"].([]interface{}) {
+		if target := target.(map[string]interface{}); target["health"] != "unknown" || target["lastScrape"] != nil {
+			t.Errorf("expected target not scraped yet, got %v", target)
+		}
+	}
+
+	targetValues(t, es, "apache_up")
+	body, resp := get()
+	if strings.Contains(body, "s3cret") {
+		t.Errorf("credentials in targets API: %s", body)
+	}
+	for _, target := range resp["data"].(map[string]interface{})["activeTargets"].([]interface{}) {
+		target := target.(map[string]interface{})
+		if _, ok := target["lastScrape"].(string); !ok {
+			t.Errorf("expected a last scrape time, got %v", target)
+		}
+		if d, ok := target["lastScrapeDuration"].(float64); !ok || d <= 0 {
+			t.Errorf("expected a last scrape duration, got %v", target)
+		}
+		target["lastScrape"], target["lastScrapeDuration"] = "<time>", "<duration>"
+	}
+	var want map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"status": "success",
+		"data": {"activeTargets": [
+			{
+				"name": "healthy",
+				"uri": "`+healthy.URL+`/server-status?auto",
+				"labels": {"target": "healthy"},
+				"collectors": ["accesses", "traffic", "uptime", "workers"],
+				"health": "up",
+				"lastScrape": "<time>",
+				"lastScrapeDuration": "<duration>",
+				"lastError": "",
+				"maintenanceUntil": null,
+				"capabilities": {"extended_status": true, "async_connections": false, "duration": false}
+			},
+			{
+				"name": "failing",
+				"uri": "http://`+strings.Replace(failingURI, "s3cret", redacted, 1)+`",
+				"labels": {"target": "failing"},
+				"collectors": ["accesses", "traffic", "uptime", "workers"],
+				"health": "down",
+				"lastScrape": "<time>",
+				"lastScrapeDuration": "<duration>",
+				"lastError": "Status 503 Service Unavailable (503): down\n",
+				"maintenanceUntil": null,
+				"capabilities": {}
+			}
+		]}
+	}`), &want)
+	if !reflect.DeepEqual(resp, want) {
+		got, _ := json.MarshalIndent(resp, "", "  ")
+		t.Errorf("unexpected targets API response:\n%s", got)
+	}
+}
+
+func TestStatusAPI(t *testing.T) {
+	var requests int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	es, err := setupExporters([]string{"healthy=http://monitor:s3cret@" + strings.TrimPrefix(healthy.URL, "http://"), "failing=" + failing.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, nil)
+	get := func(scrape bool) string {
+		rr := httptest.NewRecorder()
+		statusAPIHandler(s, scrape).ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/status", nil))
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected JSON, got %s", ct)
+		}
+		return rr.Body.String()
+	}
+
+	// Serving the cache leaves the targets alone.
+	if body := get(false); !strings.Contains(body, `"status":null`) || atomic.LoadInt32(&requests) != 0 {
+		t.Errorf("expected no status before a scrape, got %s", body)
+	}
+	get(true)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected the targets to be scraped on demand, got %d requests", n)
+	}
+
+	// Pin the scrape times for the golden file.
+	for _, e := range es {
+		e.last.at, e.last.duration = time.Date(2016, 5, 16, 9, 37, 2, 0, time.UTC), 5*time.Millisecond
+	}
+	body := get(false)
+	if strings.Contains(body, "s3cret") {
+		t.Errorf("credentials in status API: %s", body)
+	}
+	body = strings.NewReplacer(healthy.URL, "http://healthy", failing.URL, "http://failing").Replace(body)
+	var got bytes.Buffer
+	if err := json.Indent(&got, []byte(body), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "api", "status.json")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("expected\n%s\ngot\n%s", want, got.String())
+	}
+}
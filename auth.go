@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/yosefy/apache_exporter/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	webAuthUsername     = flag.String("web.auth.username", "", "Username required to access the exporter, together with -web.auth.password-file.")
+	webAuthPasswordFile = flag.String("web.auth.password-file", "", "File containing the password required with -web.auth.username, in plain or as a bcrypt hash.")
+	webAuthExempt       = &targetsFlag{values: []string{"/healthz", "/healthz/apache", "/-/ready"}}
+)
+
+func init() {
+	flag.Var(webAuthExempt, "web.auth.exempt", "Path served without authentication. May be repeated or comma separated.")
+}
+
+// basicAuth serves requests to handler that carry the right credentials,
+// and to the exempt paths.
+type basicAuth struct {
+	username string
+	password []byte // In plain, or a bcrypt hash.
+	hashed   bool
+	exempt   map[string]bool
+	handler  http.Handler
+
+	mutex sync.Mutex
+	// Passwords found to match the hash, as bcrypt is slow on purpose.
+	verified map[[sha256.Size]byte]bool
+}
+
+// newBasicAuth puts h behind basic auth with username and the password in
+// passwordFile, or returns h unchanged if neither is set.
+func newBasicAuth(username, passwordFile string, exempt []string, h http.Handler) (http.Handler, error) {
+	if username == "" && passwordFile == "" {
+		return h, nil
+	}
+	if username == "" || passwordFile == "" {
+		return nil, fmt.Errorf("-web.auth.username and -web.auth.password-file must be given together")
+	}
+	data, err := config.ReadFile(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading -web.auth.password-file: %v", err)
+	}
+	password := bytes.TrimSpace(data)
+	if len(password) == 0 {
+		return nil, fmt.Errorf("-web.auth.password-file %s is empty", passwordFile)
+	}
+	_, err = bcrypt.Cost(password)
+	a := &basicAuth{
+		username: username,
+		password: password,
+		hashed:   err == nil,
+		exempt:   map[string]bool{},
+		handler:  h,
+		verified: map[[sha256.Size]byte]bool{},
+	}
+	for _, path := range exempt {
+		a.exempt[path] = true
+	}
+	return a, nil
+}
+
+func (a *basicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.exempt[r.URL.Path] || a.authorized(r) {
+		a.handler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="apache_exporter"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+func (a *basicAuth) authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	// Check both, so the time taken doesn't tell which one was wrong.
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) == 1
+	passwordOK := a.checkPassword([]byte(password))
+	return usernameOK && passwordOK
+}
+
+func (a *basicAuth) checkPassword(password []byte) bool {
+	if !a.hashed {
+		return subtle.ConstantTimeCompare(password, a.password) == 1
+	}
+	sum := sha256.Sum256(password)
+	a.mutex.Lock()
+	verified := a.verified[sum]
+	a.mutex.Unlock()
+	if verified {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(a.password, password) != nil {
+		return false
+	}
+	a.mutex.Lock()
+	a.verified[sum] = true
+	a.mutex.Unlock()
+	return true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"plain": "hunter2\n", "bcrypt": string(hash) + "\n"}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	for kind, contents := range files {
+		path := filepath.Join(dir, kind)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		h, err := newBasicAuth("prometheus", path, []string{"/healthz"}, ok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct {
+			path, username, password string
+			code                     int
+		}{
+			{"/metrics", "", "", http.StatusUnauthorized},
+			{"/metrics", "prometheus", "wrong", http.StatusUnauthorized},
+			{"/metrics", "someone", "hunter2", http.StatusUnauthorized},
+			{"/metrics", "prometheus", "hunter2", http.StatusOK},
+			{"/metrics", "prometheus", "hunter2", http.StatusOK},
+			{"/-/reload", "", "", http.StatusUnauthorized},
+			{"/healthz", "", "", http.StatusOK},
+		} {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.username != "" {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Errorf("%s: %s as %q/%q: expected %d, got %d", kind, tc.path, tc.username, tc.password, tc.code, rr.Code)
+			}
+			if challenge := rr.Header().Get("WWW-Authenticate"); (rr.Code == http.StatusUnauthorized) != strings.HasPrefix(challenge, "Basic ") {
+				t.Errorf("%s: %s: unexpected challenge %q with %d", kind, tc.path, challenge, rr.Code)
+			}
+		}
+	}
+}
+
+func TestBasicAuthSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("CREDENTIALS_DIRECTORY", dir)
+	defer os.Unsetenv("CREDENTIALS_DIRECTORY")
+
+	h := http.NotFoundHandler()
+	if got, err := newBasicAuth("", "", nil, h); err != nil || got == nil {
+		t.Errorf("expected the handler unchanged without the flags, got %v, %v", got, err)
+	}
+	for _, tc := range []struct {
+		username, file, err string
+	}{
+		{"prometheus", "", "must be given together"},
+		{"", empty, "must be given together"},
+		{"prometheus", filepath.Join(dir, "missing"), "no such file"},
+		{"prometheus", empty, "is empty"},
+		{"prometheus", "credential:empty", "is empty"},
+		{"prometheus", "credential:missing", "credential:missing: no such credential"},
+	} {
+		if _, err := newBasicAuth(tc.username, tc.file, nil, h); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("newBasicAuth(%q, %q): expected an error containing %q, got %v", tc.username, tc.file, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	scrapeInterval   = flag.Duration("scrape.interval", 0, "Scrape targets in the background this often and serve the latest results instead of scraping on each request. 0 scrapes on request.")
+	exportTimestamps = flag.Bool("scrape.export-timestamps", false, "Serve background scrape results with the time they were scraped at. Requires -scrape.interval.")
+	timestampMaxAge  = flag.Duration("scrape.export-timestamps.max-age", 5*time.Minute, "Results older than this are served without a timestamp, as Prometheus rejects samples that are too old.")
+)
+
+// maxIdle bounds how long the scrape loop sleeps, so targets coming in
+// with a reload get scheduled without waiting for the next due scrape.
+const maxIdle = time.Second
+
+func newDataAgeDesc(labels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "data_age_seconds"),
+		"Seconds since the served metrics of the target were scraped.",
+		nil, withConstLabels(labels),
+	)
+}
+
+// cachedScrape is the outcome of a target's latest background scrape.
+type cachedScrape struct {
+	labels  prometheus.Labels
+	metrics []prometheus.Metric
+	at      time.Time
+}
+
+// Reasons for skipping the scheduled scrape of a target.
+const (
+	skipStillRunning = "previous_still_running"
+	skipDeadline     = "deadline"
+)
+
+// scrapeCycle is an interval of the background scrapes, from its start
+// until the scrapes started during it are done.
+type scrapeCycle struct {
+	start    time.Time
+	pending  int       // Scrapes started and not done.
+	over     bool      // The interval ended.
+	finished time.Time // When its last scrape was done.
+}
+
+// backgroundScraper scrapes the targets of a set on a schedule, each at its
+// own offset into the interval, and serves the latest results so that
+// requests never wait on apache.
+type backgroundScraper struct {
+	targets  *targetSet
+	interval time.Duration
+	jitter   time.Duration
+
+	mutex   sync.Mutex
+	results map[string]*cachedScrape // By target name.
+	running map[string]bool
+	waiting int       // Scrapes started waiting for -scrape.max-concurrency.
+	looped  time.Time // When the scrape loop last went round.
+	cycle   *scrapeCycle
+
+	forced        chan struct{}
+	forcedScrapes prometheus.Counter
+
+	cycles         prometheus.Counter
+	cycleDuration  prometheus.Gauge
+	targetsPending prometheus.Gauge
+	skippedScrapes *prometheus.CounterVec
+
+	stopping chan struct{} // Closed to end the scrape loop.
+	stopOnce sync.Once
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newBackgroundScraper(targets *targetSet, interval, jitter time.Duration) *backgroundScraper {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &backgroundScraper{
+		targets:  targets,
+		interval: interval,
+		jitter:   jitter,
+		results:  map[string]*cachedScrape{},
+		running:  map[string]bool{},
+		looped:   time.Now(),
+		forced:   make(chan struct{}, 1),
+		forcedScrapes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_forced_scrapes_total",
+			Help:        "Number of out of schedule scrapes of all targets that were asked for.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		cycles: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_scrape_cycles_total",
+			Help:        "Number of -scrape.interval cycles of background scrapes done, the scrapes started during them all finished.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		cycleDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_scrape_cycle_duration_seconds",
+			Help:        "Time from the start of the last cycle of background scrapes done until the last scrape started during it finished. Above -scrape.interval, the exporter isn't keeping up.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		targetsPending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_targets_pending",
+			Help:        "Number of background scrapes left waiting for a turn of -scrape.max-concurrency at the start of the current cycle.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		skippedScrapes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_skipped_scrapes_total",
+			Help:        "Number of scheduled background scrapes of targets skipped, as the previous one was still running or as none of -scrape.max-concurrency was free before the next was due.",
+			ConstLabels: withConstLabels(nil),
+		}, []string{"reason"}),
+		stopping: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	b.cycle = &scrapeCycle{start: b.looped}
+	b.skippedScrapes.WithLabelValues(skipStillRunning)
+	b.skippedScrapes.WithLabelValues(skipDeadline)
+	return b
+}
+
+// force asks for all targets to be scraped right away. Asking again before
+// that happened has no further effect.
+func (b *backgroundScraper) force() {
+	select {
+	case b.forced <- struct{}{}:
+	default:
+	}
+}
+
+// forceOnSignal calls force on every one of forceSignals until stopped.
+func (b *backgroundScraper) forceOnSignal() {
+	if len(forceSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forceSignals...)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				b.force()
+			case <-b.stopping:
+				return
+			}
+		}
+	}()
+}
+
+// start runs the scrape loop until stop is called.
+func (b *backgroundScraper) start() {
+	b.wg.Add(1)
+	go b.run()
+}
+
+// stop ends the scrape loop, cancelling scrapes still running, and waits
+// for it to finish.
+func (b *backgroundScraper) stop() {
+	b.stopOnce.Do(func() { close(b.stopping) })
+	b.cancel()
+	b.wg.Wait()
+}
+
+// shutdown ends the scrape loop and waits for the scrapes still running to
+// finish, cancelling them once ctx is done.
+func (b *backgroundScraper) shutdown(ctx context.Context) error {
+	b.stopOnce.Do(func() { close(b.stopping) })
+	finished := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.stop()
+		return ctx.Err()
+	}
+}
+
+// healthy reports whether the scrape loop went round within the last
+// period, as it does at least every maxIdle unless it is stuck. It is
+// healthy once stopped.
+func (b *backgroundScraper) healthy(period time.Duration) bool {
+	select {
+	case <-b.stopping:
+		return true
+	default:
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return time.Since(b.looped) <= period+maxIdle
+}
+
+// intervalOf returns how often e is scraped.
+func (b *backgroundScraper) intervalOf(e *Exporter) time.Duration {
+	if e.conf.ScrapeInterval > 0 {
+		return e.conf.ScrapeInterval
+	}
+	return b.interval
+}
+
+func (b *backgroundScraper) next(e *Exporter, now time.Time) time.Time {
+	interval := b.intervalOf(e)
+	return nextScrape(now, interval, scrapeOffset(e.name, interval), b.jitter)
+}
+
+func (b *backgroundScraper) run() {
+	defer b.wg.Done()
+	limit := make(chan struct{}, *maxConcurrency)
+	// Keyed by name, so reloads keep the schedule of unchanged targets.
+	due := map[string]time.Time{}
+	forced := false
+	for {
+		now := time.Now()
+		b.mutex.Lock()
+		b.looped = now
+		if !now.Before(b.cycle.start.Add(b.interval)) {
+			b.startCycle(now)
+		}
+		cycleEnd := b.cycle.start.Add(b.interval)
+		b.mutex.Unlock()
+		wake := now.Add(maxIdle)
+		if cycleEnd.Before(wake) {
+			wake = cycleEnd
+		}
+		live := map[string]bool{}
+		for _, e := range b.targets.current() {
+			live[e.name] = true
+			next, ok := due[e.name]
+			if !ok {
+				next = b.next(e, now)
+			}
+			if forced || !next.After(now) {
+				b.startScrape(e, limit)
+				next = b.next(e, now)
+			}
+			due[e.name] = next
+			if next.Before(wake) {
+				wake = next
+			}
+		}
+		for name := range due {
+			if !live[name] {
+				delete(due, name)
+			}
+		}
+		b.forget(live)
+
+		timer := time.NewTimer(wake.Sub(now))
+		forced = false
+		select {
+		case <-timer.C:
+		case <-b.forced:
+			timer.Stop()
+			logger.Info("Scraping all targets out of schedule")
+			b.forcedScrapes.Inc()
+			forced = true
+		case <-b.stopping:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// startCycle ends the current cycle, done once its scrapes are, and starts
+// the next at now. It is called with the mutex held.
+func (b *backgroundScraper) startCycle(now time.Time) {
+	if c := b.cycle; c != nil {
+		c.over = true
+		if c.pending == 0 {
+			b.endCycle(c)
+		}
+	}
+	b.cycle = &scrapeCycle{start: now}
+	b.targetsPending.Set(float64(b.waiting))
+}
+
+// endCycle records the cycle c done. Cycles starting no scrape, all those
+// due still running, leave the duration as it was. It is called with the
+// mutex held.
+func (b *backgroundScraper) endCycle(c *scrapeCycle) {
+	b.cycles.Inc()
+	if !c.finished.IsZero() {
+		b.cycleDuration.Set(c.finished.Sub(c.start).Seconds())
+	}
+}
+
+// startScrape scrapes e in the background, unless it is still being
+// scraped.
+func (b *backgroundScraper) startScrape(e *Exporter, limit chan struct{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.running[e.name] {
+		b.skippedScrapes.WithLabelValues(skipStillRunning).Inc()
+		e.logger.Debug("Skipping a background scrape, the previous one is still running")
+		return
+	}
+	b.running[e.name] = true
+	b.cycle.pending++
+	b.waiting++
+	b.wg.Add(1)
+	go b.scrapeTarget(e, limit, b.cycle)
+}
+
+// scrapeTarget scrapes e and caches a copy of its metrics. A scrape not
+// getting a turn out of limit before the next one is due is skipped. c is
+// the cycle of a scheduled scrape, which startScrape counted as waiting,
+// nil for others.
+func (b *backgroundScraper) scrapeTarget(e *Exporter, limit chan struct{}, c *scrapeCycle) {
+	defer b.wg.Done()
+	waiting := c != nil
+	defer func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.running, e.name)
+		if waiting {
+			b.waiting--
+		}
+		if c != nil {
+			c.pending--
+			c.finished = time.Now()
+			if c.over && c.pending == 0 {
+				b.endCycle(c)
+			}
+		}
+	}()
+	deadline := time.NewTimer(b.intervalOf(e))
+	select {
+	case limit <- struct{}{}:
+		deadline.Stop()
+		defer func() { <-limit }()
+	case <-deadline.C:
+		b.skippedScrapes.WithLabelValues(skipDeadline).Inc()
+		e.logger.Warn("Skipping a background scrape, no scrape of -scrape.max-concurrency finished before the next was due")
+		return
+	case <-b.stopping:
+		deadline.Stop()
+		return
+	}
+	if waiting {
+		b.mutex.Lock()
+		b.waiting--
+		waiting = false
+		b.mutex.Unlock()
+	}
+
+	// A scrape running into the next one is cut short.
+	ctx, cancel := context.WithTimeout(b.ctx, b.intervalOf(e))
+	defer cancel()
+	ch := make(chan prometheus.Metric)
+	var err error
+	go func() {
+		defer close(ch)
+		defer func() {
+			if r := recover(); r != nil {
+				e.logger.Error("Panic scraping apache", "panic", r)
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		err = e.collectTarget(ctx, ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, freeze(m))
+	}
+	if b.ctx.Err() != nil {
+		return // Shutting down, the scrape was cut short.
+	}
+	if err == nil {
+		e.maintenance.keep(metrics)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.results[e.name] = &cachedScrape{labels: e.labels, metrics: metrics, at: time.Now()}
+}
+
+// forget drops the results of targets no longer in live.
+func (b *backgroundScraper) forget(live map[string]bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for name := range b.results {
+		if !live[name] {
+			delete(b.results, name)
+		}
+	}
+}
+
+func (b *backgroundScraper) Describe(ch chan<- *prometheus.Desc) {
+	b.targets.Describe(ch)
+	b.forcedScrapes.Describe(ch)
+	b.cycles.Describe(ch)
+	b.cycleDuration.Describe(ch)
+	b.targetsPending.Describe(ch)
+	b.skippedScrapes.Describe(ch)
+	for _, e := range b.targets.current() {
+		ch <- newDataAgeDesc(e.labels)
+	}
+}
+
+func (b *backgroundScraper) Collect(ch chan<- prometheus.Metric) {
+	b.collectContext(context.Background(), ch)
+}
+
+// collectContext serves the cached results, so it doesn't need ctx. Targets
+// not scraped since they were (re)loaded are left out.
+func (b *backgroundScraper) collectContext(_ context.Context, ch chan<- prometheus.Metric) {
+	es := b.targets.current()
+	results := make([]*cachedScrape, 0, len(es))
+	b.mutex.Lock()
+	for _, e := range es {
+		if r := b.results[e.name]; r != nil && reflect.DeepEqual(r.labels, e.labels) {
+			results = append(results, r)
+		}
+	}
+	b.mutex.Unlock()
+
+	now := time.Now()
+	for _, r := range results {
+		r.send(now, ch)
+	}
+	es.collectGroups(ch)
+	b.targets.cluster.collect(es, ch)
+	b.forcedScrapes.Collect(ch)
+	b.cycles.Collect(ch)
+	b.cycleDuration.Collect(ch)
+	b.targetsPending.Collect(ch)
+	b.skippedScrapes.Collect(ch)
+	b.targets.collectReloads(ch)
+}
+
+// send sends the metrics of r, and how old they are at now, to ch.
+func (r *cachedScrape) send(now time.Time, ch chan<- prometheus.Metric) {
+	stamp := *exportTimestamps && now.Sub(r.at) <= *timestampMaxAge
+	for _, m := range r.metrics {
+		if stamp {
+			m = timestampedMetric{m, r.at}
+		}
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(newDataAgeDesc(r.labels), prometheus.GaugeValue, now.Sub(r.at).Seconds())
+}
+
+// frozenMetric is a copy of a metric's value at one point in time, safe to
+// serve while the metric it was taken from gets updated.
+type frozenMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func freeze(m prometheus.Metric) prometheus.Metric {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return prometheus.NewInvalidMetric(m.Desc(), err)
+	}
+	return frozenMetric{m.Desc(), pb}
+}
+
+func (m frozenMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m frozenMetric) Write(out *dto.Metric) error {
+	proto.Merge(out, m.metric)
+	return nil
+}
+
+// timestampedMetric is served with the time it was scraped at.
+type timestampedMetric struct {
+	prometheus.Metric
+	at time.Time
+}
+
+func (m timestampedMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	out.TimestampMs = proto.Int64(m.at.UnixNano() / int64(time.Millisecond))
+	return nil
+}
+
+func validateExportTimestamps() error {
+	if *exportTimestamps && *scrapeInterval <= 0 {
+		return fmt.Errorf("-scrape.export-timestamps requires -scrape.interval")
+	}
+	return nil
+}
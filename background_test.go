@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func cachedTargets(b *backgroundScraper) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.results)
+}
+
+func TestBackgroundScraper(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+
+	es, err := setupExporters([]string{"a=" + ts.URL, "b=" + ts.URL + "/b"}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBackgroundScraper(newTargetSet(es, nil), 100*time.Millisecond, 0)
+	b.start()
+	waitFor(t, "both targets to be scraped", func() bool { return cachedTargets(b) == 2 })
+	b.stop()
+
+	before := atomic.LoadInt32(&requests)
+	h := metricsHandler(b)
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		body, _ := ioutil.ReadAll(rr.Body)
+		for _, want := range []string{`apache_up{target="a"} 1`, `apache_up{target="b"} 1`, `apache_exporter_data_age_seconds{target="a"}`} {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("expected %s in\n%s", want, body)
+			}
+		}
+	}
+	if after := atomic.LoadInt32(&requests); after != before {
+		t.Errorf("expected serving /metrics not to scrape apache, got %d requests", after-before)
+	}
+
+	ages := func() map[string]float64 {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(b)
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := map[string]float64{}
+		for _, mf := range mfs {
+			if mf.GetName() == "apache_exporter_data_age_seconds" {
+				for _, m := range mf.GetMetric() {
+					values[labelValue(m, "target")] = metricValue(m)
+				}
+			}
+		}
+		return values
+	}
+	first := ages()
+	time.Sleep(50 * time.Millisecond)
+	second := ages()
+	for _, name := range []string{"a", "b"} {
+		if second[name] < first[name]+0.04 {
+			t.Errorf("expected the age of %s to advance, got %v then %v", name, first[name], second[name])
+		}
+	}
+}
+
+func TestBackgroundScraperStop(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+
+	b := newBackgroundScraper(newTargetSet(Exporters{NewExporter(ts.URL)}, nil), time.Hour, 0)
+	b.start()
+	// Scrape without waiting for the target's offset into the hour.
+	b.wg.Add(1)
+	go b.scrapeTarget(b.targets.current()[0], make(chan struct{}, 1), nil)
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		b.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop didn't cancel the running scrape")
+	}
+	if n := cachedTargets(b); n != 0 {
+		t.Errorf("expected the cut short scrape not to be cached, got %d results", n)
+	}
+}
+
+func TestBackgroundTimestamps(t *testing.T) {
+	defer func(old bool, maxAge time.Duration) { *exportTimestamps, *timestampMaxAge = old, maxAge }(*exportTimestamps, *timestampMaxAge)
+	*exportTimestamps = true
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	b := newBackgroundScraper(newTargetSet(Exporters{NewExporter(ts.URL)}, nil), time.Hour, 0)
+	b.wg.Add(1)
+	b.scrapeTarget(b.targets.current()[0], make(chan struct{}, 1), nil)
+	at := b.results[""].at.UnixNano() / int64(time.Millisecond)
+
+	exposition := func() string {
+		rr := httptest.NewRecorder()
+		metricsHandler(b).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	body := exposition()
+	if want := fmt.Sprintf("apache_up 1 %d\n", at); !strings.Contains(body, want) {
+		t.Errorf("expected %q in\n%s", want, body)
+	}
+	if !regexp.MustCompile(`(?m)^apache_exporter_data_age_seconds \S+$`).MatchString(body) {
+		t.Errorf("expected the data age without a timestamp in\n%s", body)
+	}
+
+	*timestampMaxAge = 0
+	if body := exposition(); !strings.Contains(body, "apache_up 1\n") {
+		t.Errorf("expected results older than the max age without a timestamp in\n%s", body)
+	}
+}
+
+func TestValidateExportTimestamps(t *testing.T) {
+	defer func(old bool, interval time.Duration) { *exportTimestamps, *scrapeInterval = old, interval }(*exportTimestamps, *scrapeInterval)
+	*exportTimestamps = true
+	if err := validateExportTimestamps(); err == nil {
+		t.Error("expected an error without -scrape.interval")
+	}
+	*scrapeInterval = time.Minute
+	if err := validateExportTimestamps(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackgroundTargetIntervals(t *testing.T) {
+	var fast, slow int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			atomic.AddInt32(&slow, 1)
+		} else {
+			atomic.AddInt32(&fast, 1)
+		}
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+
+	cfg, err := config.LoadTargets([]byte(fmt.Sprintf(`
+- {name: fast, uri: "%s/fast"}
+- {name: slow, uri: "%s/slow", scrape_interval: 400ms}
+`, ts.URL, ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := newExporters(targetsFromConfig(cfg), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBackgroundScraper(newTargetSet(es, nil), 40*time.Millisecond, 0)
+	b.start()
+	time.Sleep(time.Second)
+	b.stop()
+
+	if f, s := atomic.LoadInt32(&fast), atomic.LoadInt32(&slow); f < 15 || s < 1 || s > 3 {
+		t.Errorf("expected about 25 scrapes of fast and 2 of slow, got %d and %d", f, s)
+	}
+}
+
+func TestBackgroundForcedScrape(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+
+	es, err := setupExporters([]string{"a=" + ts.URL, "b=" + ts.URL + "/b"}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBackgroundScraper(newTargetSet(es, nil), time.Hour, 0)
+	b.start()
+	b.forceOnSignal()
+	defer b.stop()
+
+	if len(forceSignals) > 0 {
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(forceSignals[0])
+	} else {
+		b.force()
+	}
+	waitFor(t, "both targets to be scraped", func() bool { return atomic.LoadInt32(&requests) == 2 })
+	// Asking again while the targets are still being scraped doesn't
+	// scrape them twice.
+	b.force()
+	time.Sleep(20 * time.Millisecond)
+	b.force()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	waitFor(t, "the results to be cached", func() bool { return cachedTargets(b) == 2 })
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected forced scrapes to coalesce, got %d requests", n)
+	}
+	if v := counterValue(t, b.forcedScrapes); v != 3 {
+		t.Errorf("expected 3 forced scrapes counted, got %v", v)
+	}
+
+	h := scrapeHandler(b)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/-/scrape", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be refused, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/-/scrape", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("expected POST to be accepted, got %d", rr.Code)
+	}
+	waitFor(t, "the targets to be scraped again", func() bool { return atomic.LoadInt32(&requests) == 4 })
+}
+
+func TestBackgroundBackpressure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	defer func(old int) { *maxConcurrency = old }(*maxConcurrency)
+	*maxConcurrency = 1
+	skipped := func(b *backgroundScraper, reason string) float64 {
+		return counterValue(t, b.skippedScrapes.WithLabelValues(reason))
+	}
+
+	// Keeping up.
+	es, err := setupExporters([]string{"a=" + ts.URL, "b=" + ts.URL + "/b"}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBackgroundScraper(newTargetSet(es, nil), 100*time.Millisecond, 0)
+	b.start()
+	waitFor(t, "a cycle to be done", func() bool { return counterValue(t, b.cycles) >= 2 })
+	b.stop()
+	if v := gaugeValue(t, b.cycleDuration); v <= 0 || v >= 0.1 {
+		t.Errorf("expected the cycle done within the interval, got %vs", v)
+	}
+	if v := gaugeValue(t, b.targetsPending); v != 0 {
+		t.Errorf("expected no target pending, got %v", v)
+	}
+	if running, deadline := skipped(b, skipStillRunning), skipped(b, skipDeadline); running != 0 || deadline != 0 {
+		t.Errorf("expected no scrape skipped, got %v and %v", running, deadline)
+	}
+
+	// Three targets each taking until cut off at the interval, one at a
+	// time: the scrapes due pile up.
+	es, err = setupExporters([]string{"a=" + ts.URL + "/slow", "b=" + ts.URL + "/slow", "c=" + ts.URL + "/slow"}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = newBackgroundScraper(newTargetSet(es, nil), 100*time.Millisecond, 0)
+	b.start()
+	defer b.stop()
+	waitFor(t, "targets to be left pending", func() bool { return gaugeValue(t, b.targetsPending) > 0 })
+	waitFor(t, "scrapes to be skipped", func() bool {
+		return skipped(b, skipStillRunning) > 0 && skipped(b, skipDeadline) > 0
+	})
+	waitFor(t, "a cycle to overrun the interval", func() bool { return gaugeValue(t, b.cycleDuration) > 0.1 })
+}
+
+func TestBackgroundHealthy(t *testing.T) {
+	b := newBackgroundScraper(newTargetSet(nil, nil), time.Hour, 0)
+	b.start()
+	time.Sleep(50 * time.Millisecond)
+	if !b.healthy(10 * time.Millisecond) {
+		t.Error("expected the running scrape loop to be healthy")
+	}
+
+	// Pretend the loop got stuck a minute ago.
+	b.mutex.Lock()
+	b.looped = time.Now().Add(-time.Minute)
+	b.mutex.Unlock()
+	if b.healthy(time.Second) {
+		t.Error("expected a loop that didn't go round for a minute to be unhealthy")
+	}
+	b.stop()
+	if !b.healthy(0) {
+		t.Error("expected a stopped loop to be healthy")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"sync"
+	"time"
+)
+
+var (
+	backoffAfter = flag.Int("scrape.backoff-after", 0, "Consecutive failed scrapes of a target after which it isn't scraped for a while, serving apache_up 0 instead. 0 always scrapes.")
+	backoffFirst = flag.Duration("scrape.backoff", 10*time.Second, "How long a target isn't scraped for once -scrape.backoff-after scrapes in a row failed, doubled every time the scrape after it fails too.")
+	backoffMax   = flag.Duration("scrape.backoff-max", 5*time.Minute, "Longest a failing target isn't scraped for.")
+)
+
+// errBackingOff is returned for scrapes skipped while backing off.
+var errBackingOff = errors.New("backing off from a failing target")
+
+// breaker keeps a target failing over and over from being scraped for an
+// exponentially growing while. Once the while is over, one scrape probes
+// the target: if it succeeds, the target is scraped as usual again.
+type breaker struct {
+	after      int // Failures in a row that open the breaker, never if 0.
+	first, max time.Duration
+	mutex      sync.Mutex
+	failures   int
+	backoff    time.Duration // Of the latest opening.
+	open       time.Time     // Scrapes are skipped until then.
+}
+
+func newBreaker(after int, first, max time.Duration) *breaker {
+	return &breaker{after: after, first: first, max: max}
+}
+
+// allow tells whether the target may be scraped at now, or else how long
+// until it may. A probe scrape once the backoff is over keeps the others
+// out until its outcome is recorded.
+func (b *breaker) allow(now time.Time) (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.after == 0 || b.failures < b.after {
+		return true, 0
+	}
+	if now.Before(b.open) {
+		return false, b.open.Sub(now)
+	}
+	b.open = now.Add(b.backoff)
+	return true, 0
+}
+
+// record takes in the outcome of a scrape at now, returning the backoff if
+// the failure opened the breaker.
+func (b *breaker) record(now time.Time, err error) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err == nil {
+		b.failures, b.backoff, b.open = 0, 0, time.Time{}
+		return 0
+	}
+	b.failures++
+	if b.after == 0 || b.failures < b.after {
+		return 0
+	}
+	if b.backoff == 0 {
+		b.backoff = b.first
+	} else if b.backoff *= 2; b.backoff > b.max {
+		b.backoff = b.max
+	}
+	b.open = now.Add(b.backoff)
+	return b.backoff
+}
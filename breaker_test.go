@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(3, 10*time.Second, 25*time.Second)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	failed := errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow(now); !ok {
+			t.Fatalf("expected scrape %d to be allowed below the threshold", i+1)
+		}
+		if backoff := b.record(now, failed); backoff != 0 {
+			t.Fatalf("expected no backoff after %d failures, got %s", i+1, backoff)
+		}
+	}
+	if backoff := b.record(now, failed); backoff != 10*time.Second {
+		t.Fatalf("expected the third failure to back off 10s, got %s", backoff)
+	}
+
+	for _, c := range []struct {
+		after   time.Duration // Since now.
+		ok      bool
+		wait    time.Duration
+		failure bool // Recorded if ok.
+		backoff time.Duration
+	}{
+		{time.Second, false, 9 * time.Second, false, 0},
+		// The probe after the backoff fails, doubling it.
+		{10 * time.Second, true, 0, true, 20 * time.Second},
+		{29 * time.Second, false, time.Second, false, 0},
+		// Capped at the maximum.
+		{30 * time.Second, true, 0, true, 25 * time.Second},
+		{54 * time.Second, false, time.Second, false, 0},
+		// The probe succeeds, closing the breaker.
+		{55 * time.Second, true, 0, false, 0},
+		{55 * time.Second, true, 0, false, 0},
+	} {
+		at := now.Add(c.after)
+		ok, wait := b.allow(at)
+		if ok != c.ok || wait != c.wait {
+			t.Fatalf("at %s: expected %v, %s, got %v, %s", c.after, c.ok, c.wait, ok, wait)
+		}
+		if !ok {
+			continue
+		}
+		var err error
+		if c.failure {
+			err = failed
+		}
+		if backoff := b.record(at, err); backoff != c.backoff {
+			t.Fatalf("at %s: expected backoff %s, got %s", c.after, c.backoff, backoff)
+		}
+	}
+
+	// A single failure after recovering doesn't open it again.
+	b.record(now, failed)
+	if ok, _ := b.allow(now); !ok {
+		t.Error("expected the failures to start over after a success")
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	b := newBreaker(1, time.Minute, time.Hour)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	b.record(now, errors.New("connection refused"))
+	if ok, _ := b.allow(now.Add(time.Minute)); !ok {
+		t.Fatal("expected a probe after the backoff")
+	}
+	if ok, _ := b.allow(now.Add(time.Minute)); ok {
+		t.Error("expected no second scrape while the probe runs")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Minute, time.Hour)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		b.record(now, errors.New("connection refused"))
+	}
+	if ok, _ := b.allow(now); !ok {
+		t.Error("expected scrapes to always be allowed with -scrape.backoff-after 0")
+	}
+}
+
+func TestTargetBackoff(t *testing.T) {
+	var requests, healthy int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+
+	e := NewExporter(ts.URL)
+	e.breaker = newBreaker(2, 50*time.Millisecond, time.Second)
+	h := metricsHandler(newTargetSet(Exporters{e}, nil))
+	get := func() string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	get()
+	get()
+	body := get()
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected the target to be left alone after 2 failures, got %d requests", n)
+	}
+	if !strings.Contains(body, "apache_up 0") || !strings.Contains(body, "apache_exporter_target_backoff_seconds ") {
+		t.Errorf("expected apache_up 0 and the backoff while backing off in\n%s", body)
+	}
+	if !strings.Contains(body, `apache_exporter_scrape_failures_total{reason="status"} 2`) {
+		t.Errorf("expected skipped scrapes not to count as failures in\n%s", body)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(50 * time.Millisecond)
+	body = get()
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected a probe once the backoff is over, got %d requests", n)
+	}
+	if !strings.Contains(body, "apache_up 1") || strings.Contains(body, "apache_exporter_target_backoff_seconds") {
+		t.Errorf("expected the target to recover in\n%s", body)
+	}
+	get()
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("expected scrapes as usual after recovering, got %d requests", n)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+var capabilitiesRecheck = flag.Duration("capabilities.recheck-interval", time.Hour, "How long what a target was found to have, such as ExtendedStatus or a heartbeat file, is trusted before checking again. Pages a target was found without aren't fetched in the meantime, and missing fields aren't logged again. Checked again on an apache restart too. 0 checks on every scrape.")
+
+// The capabilities of targets, in /api/v1/targets.
+const (
+	capExtendedStatus   = "extended_status"   // Total Accesses and kBytes.
+	capAsyncConnections = "async_connections" // ConnsTotal and the like, of the event MPM.
+	capDuration         = "duration"          // Total Duration, of 2.4.
+	capHeartbeat        = "heartbeat"         // The file of -collector.heartbeat.path.
+	capProcessTable     = "process_table"     // Of the HTML status page.
+)
+
+// capabilityCheck is whether a target was found to have a capability, and
+// when.
+type capabilityCheck struct {
+	present bool
+	at      time.Time
+}
+
+// capabilities caches what a target was found to have, for
+// -capabilities.recheck-interval. They are kept across reloads like its
+// counters, as long as its URI is the same.
+type capabilities struct {
+	mutex  sync.Mutex
+	checks map[string]capabilityCheck
+}
+
+// known returns whether the target has the capability name, and whether
+// that is known as of now.
+func (c *capabilities) known(name string, now time.Time) (present, known bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	check, ok := c.checks[name]
+	if !ok || *capabilitiesRecheck <= 0 || now.Sub(check.at) >= *capabilitiesRecheck {
+		return false, false
+	}
+	return check.present, true
+}
+
+// absent tells whether the target is known not to have the capability name
+// as of now, so that what needs it can be skipped.
+func (c *capabilities) absent(name string, now time.Time) bool {
+	present, known := c.known(name, now)
+	return known && !present
+}
+
+func (c *capabilities) record(name string, present bool, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.checks == nil {
+		c.checks = map[string]capabilityCheck{}
+	}
+	c.checks[name] = capabilityCheck{present, now}
+}
+
+// reset forgets the capabilities, for apache restarts, which may come with
+// another configuration.
+func (c *capabilities) reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks = nil
+}
+
+// detectFields records the capabilities the fields of a status page show,
+// unless known as of now. It tells whether they were recorded.
+func (c *capabilities) detectFields(present fieldSet, now time.Time) bool {
+	if _, known := c.known(capExtendedStatus, now); known {
+		return false
+	}
+	c.record(capExtendedStatus, present.has("Total Accesses"), now)
+	c.record(capAsyncConnections, present.has("ConnsTotal"), now)
+	c.record(capDuration, present.has("Total Duration"), now)
+	return true
+}
+
+// snapshot returns whether the target has each capability known as of now.
+func (c *capabilities) snapshot(now time.Time) map[string]bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	known := map[string]bool{}
+	for name, check := range c.checks {
+		if *capabilitiesRecheck > 0 && now.Sub(check.at) < *capabilitiesRecheck {
+			known[name] = check.present
+		}
+	}
+	return known
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCapabilitiesRecheck(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"heartbeat": true}
+	defer func(old time.Duration) { *capabilitiesRecheck = old }(*capabilitiesRecheck)
+	*capabilitiesRecheck = time.Hour
+
+	var heartbeats, restarted int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == *heartbeatPath {
+			atomic.AddInt64(&heartbeats, 1)
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.LoadInt64(&restarted) == 1 {
+			w.Write([]byte("Total Accesses: 3\nTotal kBytes: 2\nUptime: 5\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+			return
+		}
+		w.Write([]byte("Total Accesses: 9000\nTotal kBytes: 7000\nUptime: 86400\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+	}))
+	defer server.Close()
+
+	e := NewExporter(server.URL + "/server-status?auto")
+	r := prometheus.NewRegistry()
+	r.MustRegister(e)
+	gather := func(want int64) {
+		t.Helper()
+		if _, err := r.Gather(); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt64(&heartbeats); got != want {
+			t.Errorf("expected the heartbeat file asked for %d times, got %d", want, got)
+		}
+	}
+	gather(1)
+	// Known to be missing.
+	gather(1)
+	want := map[string]bool{capHeartbeat: false, capExtendedStatus: true, capAsyncConnections: false, capDuration: false}
+	got := e.capabilities.snapshot(time.Now())
+	for name, present := range want {
+		if p, ok := got[name]; !ok || p != present {
+			t.Errorf("expected %s %v, got %v %v", name, present, p, ok)
+		}
+	}
+
+	// Checked again on a restart.
+	atomic.StoreInt64(&restarted, 1)
+	gather(2)
+	gather(2)
+
+	// And once the interval is over.
+	*capabilitiesRecheck = 50 * time.Millisecond
+	time.Sleep(100 * time.Millisecond)
+	gather(3)
+	*capabilitiesRecheck = 0
+	gather(4)
+	gather(5)
+	if got := e.capabilities.snapshot(time.Now()); len(got) != 0 {
+		t.Errorf("expected nothing known with a recheck on every scrape, got %v", got)
+	}
+}
+
+func TestCapabilitiesMissingFieldsLogged(t *testing.T) {
+	defer func(old time.Duration) { *capabilitiesRecheck = old }(*capabilitiesRecheck)
+	*capabilitiesRecheck = time.Hour
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Uptime: 86400\nBusyWorkers: 1\nIdleWorkers: 2\n"))
+	}))
+	defer server.Close()
+
+	logs := captureLogs(t, "logfmt")
+	r := prometheus.NewRegistry()
+	r.MustRegister(NewExporter(server.URL))
+	r.Gather()
+	first := strings.Count(logs.String(), "Field missing")
+	r.Gather()
+	if first == 0 || strings.Count(logs.String(), "Field missing") != first {
+		t.Errorf("expected the missing fields logged on the first scrape only, got\n%s", logs)
+	}
+}
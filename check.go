@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var checkConfigOnly = flag.Bool("check-config", false, "Validate the configuration given by the flags, print a summary and exit, without scraping anything.")
+
+// checkConfig loads the targets the flags set up, reading their credential
+// and TLS files but without contacting them or any discovery mechanism,
+// and writes a summary to w.
+func checkConfig(w io.Writer) error {
+	es, err := exportersFromFlags(false)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Configuration OK, %d static targets:\n", len(es))
+	for _, e := range es {
+		if uri := sanitizeURI(e.URI); uri != e.name {
+			fmt.Fprintf(w, "  %s\t%s\n", e.name, uri)
+		} else {
+			fmt.Fprintf(w, "  %s\n", uri)
+		}
+	}
+	var collectors []string
+	for _, c := range groupCollectors {
+		if collectorEnabled[c.name] {
+			collectors = append(collectors, c.name)
+		}
+	}
+	if len(collectors) > 0 {
+		fmt.Fprintf(w, "Collectors: %s\n", strings.Join(collectors, ", "))
+	} else {
+		fmt.Fprintln(w, "Collectors: none, the status page alone is scraped")
+	}
+	if modules := currentModules(); len(modules) > 0 {
+		fmt.Fprintf(w, "Probe modules: %s\n", moduleNames(modules))
+	}
+	var discovery []string
+	for _, m := range discoveryMechanisms {
+		if m.enabled() {
+			discovery = append(discovery, m.name)
+		}
+	}
+	if len(discovery) > 0 {
+		fmt.Fprintf(w, "Discovery: %s (discovered targets aren't checked)\n", strings.Join(discovery, ", "))
+	}
+	if *scrapeInterval > 0 {
+		fmt.Fprintf(w, "Scraping in the background every %s\n", *scrapeInterval)
+	} else {
+		fmt.Fprintln(w, "Scraping on request")
+	}
+	return nil
+}
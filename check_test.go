@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	defer func(old string) { *configFile = old }(*configFile)
+
+	*configFile = "testdata/check-config/valid.yml"
+	var out bytes.Buffer
+	if err := checkConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"2 static targets", "web01\thttps://web01/server-status?auto", "  http://web02:8080/server-status?auto\n", "Collectors: accesses, traffic, uptime, workers\n", "Probe modules: status-basic", "Scraping on request"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in the summary:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("password printed in the summary:\n%s", out.String())
+	}
+
+	// The collectors as the -collector.<name> flags leave them.
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"accesses": true, "workers": true, "heartbeat": true}
+	out.Reset()
+	if err := checkConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Collectors: accesses, workers, heartbeat\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected %q in the summary:\n%s", want, out.String())
+	}
+	collectorEnabled = map[string]bool{}
+	out.Reset()
+	if err := checkConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Collectors: none"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected %q in the summary:\n%s", want, out.String())
+	}
+
+	for file, want := range map[string]string{
+		"invalid-yaml.yml":          "line 3",
+		"invalid-target.yml":        `duplicate target name "web01"`,
+		"missing-password-file.yml": "does-not-exist",
+		"nonexistent.yml":           "no such file",
+	} {
+		*configFile = "testdata/check-config/" + file
+		err := checkConfig(&bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, got %v", file, want, err)
+		}
+	}
+}
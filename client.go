@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	scrapeTimeout      = flag.Duration("scrape.timeout", 10*time.Second, "Timeout for a single scrape of apache, including reading the body.")
+	dialTimeout        = flag.Duration("scrape.dial-timeout", 5*time.Second, "Timeout for resolving and connecting to apache.")
+	handshakeTimeout   = flag.Duration("scrape.tls-handshake-timeout", 5*time.Second, "Timeout for the TLS handshake with apache.")
+	skipHostnameVerify = flag.Bool("scrape.tls.insecure-skip-hostname-verify", false, "Verify the certificate of apache against the trusted CAs, but not that it names the scraped host, as when scraping by an IP the certificate has no SAN for.")
+	headerTimeout      = flag.Duration("scrape.response-header-timeout", 0, "Timeout for apache to send response headers once the request is written (0 leaves it to -scrape.timeout).")
+	disableKeepAlive   = flag.Bool("scrape.disable-keepalive", false, "Open a new connection for every scrape instead of reusing idle ones.")
+	forceHTTP1         = flag.Bool("scrape.force-http1", false, "Never negotiate HTTP/2 with the scraped server.")
+	ipProtocol         = flag.String("scrape.ip-protocol", "any", "Address family to connect over when the scrape host resolves to both: ip4, ip6 or any.")
+	ipFallback         = flag.Bool("scrape.ip-protocol-fallback", true, "Fall back to the other address family if the host has no address in the preferred one.")
+	resolveOverrides   = resolveFlag{}
+	sourceAddress      = flag.String("scrape.source-address", "", "Local IP address to connect to apache from, such as that of the management interface of a multi-homed host. Chosen by the routing table if empty.")
+	userAgent          = flag.String("scrape.user-agent", "", "User-Agent header sent with scrape requests (default \"apache_exporter/<version>\").")
+)
+
+func init() {
+	flag.Var(resolveOverrides, "scrape.resolve", "Connect to the given IP instead of resolving host:port, as host:port=ip. May be repeated.")
+}
+
+// resolveFlag maps "host:port" dial addresses to the IP to connect to.
+type resolveFlag map[string]string
+
+func (f resolveFlag) String() string {
+	var s []string
+	for addr, ip := range f {
+		s = append(s, addr+"="+ip)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (f resolveFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected host:port=ip, got %q", value)
+	}
+	host, port, err := net.SplitHostPort(kv[0])
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", kv[0], err)
+	}
+	ip := net.ParseIP(strings.Trim(kv[1], "[]"))
+	if ip == nil {
+		return fmt.Errorf("invalid IP %q", kv[1])
+	}
+	f[strings.ToLower(net.JoinHostPort(host, port))] = ip.String()
+	return nil
+}
+
+// clientConfig holds the settings the scrape HTTP client is built from.
+type clientConfig struct {
+	timeout          time.Duration
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+	headerTimeout    time.Duration
+	insecure         bool
+	tls              *tls.Config // Used instead of insecure if set.
+	skipHostname     bool
+	disableKeepAlive bool
+	forceHTTP1       bool
+	ipProtocol       string
+	ipFallback       bool
+	resolve          map[string]string
+	userAgent        string
+	source           net.IP        // Address connected from, any if nil.
+	policy           *targetPolicy // Of /probe targets, nil for any.
+	httpVersion      string
+	http3Fallback    bool
+	// The proxy to scrape through, the environment's if nil.
+	proxy func(*http.Request) (*url.URL, error)
+}
+
+func clientConfigFromFlags() clientConfig {
+	return clientConfig{
+		timeout:          *scrapeTimeout,
+		dialTimeout:      *dialTimeout,
+		handshakeTimeout: *handshakeTimeout,
+		headerTimeout:    *headerTimeout,
+		insecure:         *insecure,
+		skipHostname:     *skipHostnameVerify,
+		disableKeepAlive: *disableKeepAlive,
+		forceHTTP1:       *forceHTTP1,
+		ipProtocol:       *ipProtocol,
+		ipFallback:       *ipFallback,
+		resolve:          resolveOverrides,
+		userAgent:        *userAgent,
+		source:           net.ParseIP(*sourceAddress),
+		httpVersion:      *httpVersion,
+		http3Fallback:    *http3Fallback,
+	}
+}
+
+// parseSourceAddress parses the source address s, which has to be one of
+// the host's.
+func parseSourceAddress(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source address %q, must be an IP address", s)
+	}
+	// Binding to it is the one way to tell it is local.
+	l, err := net.ListenPacket("udp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		return nil, fmt.Errorf("source address %s is not an address of this host: %v", ip, err)
+	}
+	l.Close()
+	return ip, nil
+}
+
+func validateIPProtocol(p string) error {
+	switch p {
+	case "", "any", "ip4", "ip6":
+		return nil
+	}
+	return fmt.Errorf("invalid IP protocol %q, must be one of ip4, ip6 or any", p)
+}
+
+// dialer connects to scrape targets, preferring ipProtocol's address
+// family when a host resolves to both. Addresses found in overrides are
+// dialed at the given IP without consulting DNS. The timeout bounds the
+// lookup and all connection attempts together. Connections are made from
+// source, if set. With a policy, hosts are
+// refused if any address they resolve to is, so that a name can't resolve
+// elsewhere between the check and the connection.
+type dialer struct {
+	ipProtocol string
+	fallback   bool
+	overrides  map[string]string
+	timeout    time.Duration
+	source     net.IP
+	policy     *targetPolicy
+
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	addrs, err := d.addresses(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		var conn net.Conn
+		conn, err = d.dial(ctx, network, a)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// addresses returns what to connect to for addr, in the order to try
+// them. Without an IP protocol or policy, that is addr, resolved by dial.
+func (d *dialer) addresses(ctx context.Context, network, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	name := host
+	if ip, ok := d.overrides[strings.ToLower(addr)]; ok {
+		host = ip
+		addr = net.JoinHostPort(ip, port)
+	}
+
+	anyProtocol := d.ipProtocol == "" || d.ipProtocol == "any"
+	if anyProtocol && d.policy == nil {
+		return []string{addr}, nil
+	}
+
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		// Report lookup failures like the ones net.Dialer returns, so
+		// they are classified as part of the dial phase.
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	if d.policy != nil {
+		if err := d.policy.checkAddrs(name, ips); err != nil {
+			denyProbe(ctx, err)
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+	}
+
+	var preferred, other []net.IPAddr
+	for _, ip := range ips {
+		if anyProtocol || (ip.IP.To4() != nil) == (d.ipProtocol == "ip4") {
+			preferred = append(preferred, ip)
+		} else {
+			other = append(other, ip)
+		}
+	}
+	if len(preferred) == 0 && d.fallback {
+		preferred = other
+	}
+	if len(preferred) == 0 {
+		return nil, fmt.Errorf("no %s address found for %s", d.ipProtocol, host)
+	}
+	var addrs []string
+	for _, ip := range preferred {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return addrs, nil
+}
+
+// userAgentTransport sets the User-Agent header on every outgoing request
+// that doesn't carry one from the target's configured headers.
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.next.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+func newHTTPClient(cfg clientConfig) *http.Client {
+	tlsConfig := cfg.tls
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{InsecureSkipVerify: cfg.insecure}
+	}
+	if cfg.skipHostname && !tlsConfig.InsecureSkipVerify {
+		tlsConfig = skipHostnameVerification(tlsConfig)
+	}
+	d := &dialer{
+		ipProtocol: cfg.ipProtocol,
+		fallback:   cfg.ipFallback,
+		overrides:  cfg.resolve,
+		timeout:    cfg.dialTimeout,
+		source:     cfg.source,
+		policy:     cfg.policy,
+		lookup:     net.DefaultResolver.LookupIPAddr,
+	}
+	netDialer := &net.Dialer{
+		Timeout:   cfg.dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if cfg.source != nil {
+		netDialer.LocalAddr = &net.TCPAddr{IP: cfg.source}
+	}
+	d.dial = netDialer.DialContext
+	forceHTTP1 := cfg.forceHTTP1 || cfg.httpVersion == "1.1"
+	proxy := cfg.proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	if cfg.policy != nil {
+		// Through a proxy, the address dialed and checked would be the
+		// proxy's, never the target's.
+		proxy = nil
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           d.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.handshakeTimeout,
+		ResponseHeaderTimeout: cfg.headerTimeout,
+		// A scrape only ever needs one connection per target, keep a
+		// spare around for overlapping scrapes.
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+		DisableKeepAlives:   cfg.disableKeepAlive,
+		// A custom TLSClientConfig disables HTTP/2 unless asked for.
+		ForceAttemptHTTP2: !forceHTTP1,
+	}
+	if forceHTTP1 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	var next http.RoundTripper = transport
+	if newQUICTransport != nil {
+		h3 := &http3Transport{
+			quic:     newQUICTransport(tlsConfig, d, cfg),
+			tcp:      transport,
+			all:      cfg.httpVersion == "3",
+			fallback: cfg.http3Fallback,
+		}
+		transport.RegisterProtocol("h3", h3)
+		if h3.all {
+			next = h3
+		}
+	}
+	ua := cfg.userAgent
+	if ua == "" {
+		ua = defaultUserAgent()
+	}
+	client := &http.Client{
+		Timeout:   cfg.timeout,
+		Transport: &userAgentTransport{userAgent: ua, next: next},
+	}
+	if cfg.policy != nil {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if err := cfg.policy.checkHost(req.URL.Hostname()); err != nil {
+				denyProbe(req.Context(), err)
+				return err
+			}
+			// As without CheckRedirect.
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		}
+	}
+	return client
+}
+
+// skipHostnameVerification returns tc verifying the certificate chain of
+// servers against its roots, the system ones if it has none, but not the
+// name of the server.
+func skipHostnameVerification(tc *tls.Config) *tls.Config {
+	tc = tc.Clone()
+	roots := tc.RootCAs
+	// Done by VerifyPeerCertificate instead.
+	tc.InsecureSkipVerify = true
+	tc.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("tls: server sent no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("tls: failed to parse certificate from server: %v", err)
+			}
+			certs[i] = cert
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+	return tc
+}
+
+// requestFailureReason classifies an error from sending a scrape request
+// by the phase that failed. A timeout is that of the phase the times of
+// the request show started and never completed.
+func requestFailureReason(err error, t phaseTimes) string {
+	var opErr *net.OpError
+	var quicErr *quicDialError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.As(err, &quicErr):
+		return reasonQUICHandshake
+	case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout():
+		return reasonDialTimeout
+	case errors.As(err, &recordErr):
+		// The handshake failed rather than timing out, the other end
+		// doesn't speak TLS.
+		return reasonRequest
+	case !isTimeout(err):
+		return reasonRequest
+	case !t.tlsStart.IsZero() && (t.tlsDone.IsZero() || t.tlsErr != nil):
+		return reasonTLSHandshakeTimeout
+	case !t.wroteRequest.IsZero() && t.firstByte.IsZero():
+		return reasonResponseHeaderTimeout
+	// The transport's own timeout errors aren't exported, their text is
+	// all there is to go by for requests not traced.
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return reasonTLSHandshakeTimeout
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return reasonResponseHeaderTimeout
+	}
+	return reasonTimeout
+}
+
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// backlogListener returns the address of a socket that is listening with
+// a full accept queue, so further connection attempts hang in SYN_SENT.
+func backlogListener(t *testing.T) string {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sa.(*syscall.SockaddrInet4).Port))
+
+	// Fill the queue; nothing ever accepts.
+	c, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return addr
+}
+
+func TestDialTimeout(t *testing.T) {
+	addr := backlogListener(t)
+	cfg := clientConfig{timeout: time.Second, dialTimeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	if got := scrapeFailureReason(t, "http://"+addr+"/server-status?auto", cfg); got != reasonDialTimeout {
+		t.Errorf("expected failure reason %q, got %q", reasonDialTimeout, got)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("dial timeout took %s", d)
+	}
+}
+
+// Linux has all of 127.0.0.0/8 on the loopback interface.
+func TestSourceAddress(t *testing.T) {
+	peers := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers <- r.RemoteAddr
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	for _, source := range []string{"127.0.0.2", "127.0.0.1"} {
+		ip, err := parseSourceAddress(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := newHTTPClient(clientConfig{timeout: time.Second, source: ip}).Get(server.URL + "/server-status?auto")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if peer := <-peers; !strings.HasPrefix(peer, source+":") {
+			t.Errorf("expected a connection from %s, got %s", source, peer)
+		}
+	}
+
+	// As the source_address of a target.
+	client, err := tlsClient(config.HTTPConfig{SourceAddress: "127.0.0.2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL + "/server-status?auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if peer := <-peers; !strings.HasPrefix(peer, "127.0.0.2:") {
+		t.Errorf("expected a connection from the source_address, got %s", peer)
+	}
+	if _, err := tlsClient(config.HTTPConfig{SourceAddress: "192.0.2.1"}, nil); err == nil {
+		t.Error("expected a source_address not of this host to fail")
+	}
+}
+
+func TestParseSourceAddress(t *testing.T) {
+	for _, source := range []string{"web01", "192.0.2.1", "127.0.0.1:8080"} {
+		if _, err := parseSourceAddress(source); err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
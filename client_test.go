@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/version"
+)
+
+// countingServer serves the apache 2.4 status page and counts the
+// connections it accepts.
+func countingServer(conns *int32) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	server.Start()
+	return server
+}
+
+func scrape(e *Exporter) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		e.Collect(ch)
+	}()
+	drain(ch)
+}
+
+func TestConnectionReuse(t *testing.T) {
+	var conns int32
+	server := countingServer(&conns)
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	e.client = newHTTPClient(clientConfig{})
+	for i := 0; i < 3; i++ {
+		scrape(e)
+	}
+
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("expected 1 connection for 3 scrapes, got %d", n)
+	}
+	if v := counterValue(t, e.connections.WithLabelValues("true")); v != 2 {
+		t.Errorf("expected 2 reused connections, got %v", v)
+	}
+}
+
+func TestDisableKeepAlive(t *testing.T) {
+	var conns int32
+	server := countingServer(&conns)
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	e.client = newHTTPClient(clientConfig{disableKeepAlive: true})
+	for i := 0; i < 3; i++ {
+		scrape(e)
+	}
+
+	if n := atomic.LoadInt32(&conns); n != 3 {
+		t.Errorf("expected 3 connections for 3 scrapes, got %d", n)
+	}
+	if v := counterValue(t, e.connections.WithLabelValues("false")); v != 3 {
+		t.Errorf("expected 3 fresh connections, got %v", v)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	transport := newHTTPClient(clientConfig{}).Transport.(*userAgentTransport).next.(*http.Transport)
+	if transport.Proxy == nil || reflect.ValueOf(transport.Proxy).Pointer() != reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+		t.Error("expected scrapes to go through the proxy of HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+	}
+}
+
+func TestHTTP2Negotiation(t *testing.T) {
+	var proto int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&proto, int32(r.ProtoMajor))
+		w.Write([]byte(apache24Status))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	for _, tc := range []struct {
+		forceHTTP1 bool
+		want       int32
+	}{
+		{false, 2},
+		{true, 1},
+	} {
+		e := NewExporter(server.URL)
+		e.client = newHTTPClient(clientConfig{insecure: true, forceHTTP1: tc.forceHTTP1})
+		scrape(e)
+		if got := atomic.LoadInt32(&proto); got != tc.want {
+			t.Errorf("forceHTTP1=%t: expected HTTP/%d, got HTTP/%d", tc.forceHTTP1, tc.want, got)
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var got atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.UserAgent())
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		flag, want string
+	}{
+		{"", "apache_exporter/" + version.Version},
+		{"status-checker/1.0", "status-checker/1.0"},
+	} {
+		e := NewExporter(server.URL)
+		e.client = newHTTPClient(clientConfig{userAgent: tc.flag})
+		scrape(e)
+		if ua, _ := got.Load().(string); ua != tc.want {
+			t.Errorf("expected User-Agent %q, got %q", tc.want, ua)
+		}
+	}
+}
+
+func TestDialerAddressFamily(t *testing.T) {
+	dual := []net.IPAddr{{IP: net.ParseIP("192.0.2.10")}, {IP: net.ParseIP("2001:db8::10")}}
+	v4only := []net.IPAddr{{IP: net.ParseIP("192.0.2.10")}}
+
+	for _, tc := range []struct {
+		protocol string
+		fallback bool
+		ips      []net.IPAddr
+		want     []string
+		wantErr  bool
+	}{
+		{"any", false, dual, []string{"dual.example:80"}, false},
+		{"ip4", false, dual, []string{"192.0.2.10:80"}, false},
+		{"ip6", false, dual, []string{"[2001:db8::10]:80"}, false},
+		{"ip6", true, v4only, []string{"192.0.2.10:80"}, false},
+		{"ip6", false, v4only, nil, true},
+	} {
+		var dialed []string
+		d := &dialer{
+			ipProtocol: tc.protocol,
+			fallback:   tc.fallback,
+			lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+				return tc.ips, nil
+			},
+			dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialed = append(dialed, addr)
+				return nil, errors.New("not dialing in tests")
+			},
+		}
+		_, err := d.DialContext(context.Background(), "tcp", "dual.example:80")
+		if tc.wantErr && err == nil {
+			t.Errorf("%s (fallback %t): expected error", tc.protocol, tc.fallback)
+		}
+		if !reflect.DeepEqual(dialed, tc.want) {
+			t.Errorf("%s (fallback %t): expected dials to %v, got %v", tc.protocol, tc.fallback, tc.want, dialed)
+		}
+	}
+}
+
+func TestIPv6Literal(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %s", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	for _, tc := range []struct {
+		protocol string
+		fallback bool
+		ok       bool
+	}{
+		{"any", false, true},
+		{"ip6", false, true},
+		{"ip4", true, true},
+		{"ip4", false, false},
+	} {
+		e := NewExporter(server.URL + "/server-status?auto")
+		e.client = newHTTPClient(clientConfig{ipProtocol: tc.protocol, ipFallback: tc.fallback})
+		scrape(e)
+		failed := counterValue(t, e.scrapeFailures.WithLabelValues(reasonRequest)) > 0
+		if failed == tc.ok {
+			t.Errorf("%s (fallback %t): expected success %t", tc.protocol, tc.fallback, tc.ok)
+		}
+	}
+}
+
+func TestResolveFlag(t *testing.T) {
+	f := resolveFlag{}
+	for _, v := range []string{"Status.Example:80=127.0.0.1", "v6.example:443=[::1]", "other.example:8080=::1"} {
+		if err := f.Set(v); err != nil {
+			t.Errorf("Set(%q): %s", v, err)
+		}
+	}
+	want := resolveFlag{"status.example:80": "127.0.0.1", "v6.example:443": "::1", "other.example:8080": "::1"}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("expected %v, got %v", want, f)
+	}
+	for _, v := range []string{"status.example=127.0.0.1", "status.example:80", "status.example:80=localhost"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("Set(%q): expected error", v)
+		}
+	}
+}
+
+func TestResolveOverride(t *testing.T) {
+	type seen struct{ host, serverName string }
+	servers := map[string]*httptest.Server{}
+	got := map[string]*atomic.Value{}
+	for _, name := range []string{"a.status.example", "b.status.example"} {
+		v := &atomic.Value{}
+		got[name] = v
+		servers[name] = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v.Store(seen{r.Host, r.TLS.ServerName})
+			w.Write([]byte(apache24Status))
+		}))
+		defer servers[name].Close()
+	}
+
+	resolve := map[string]string{}
+	uris := map[string]string{}
+	for name, server := range servers {
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		resolve[name+":"+port] = "127.0.0.1"
+		uris[name] = "https://" + name + ":" + port + "/server-status?auto"
+	}
+
+	for name, uri := range uris {
+		e := NewExporter(uri)
+		e.client = newHTTPClient(clientConfig{insecure: true, resolve: resolve})
+		scrape(e)
+
+		s, _ := got[name].Load().(seen)
+		host, _, _ := net.SplitHostPort(s.host)
+		if host != name || s.serverName != name {
+			t.Errorf("%s: expected Host and SNI %s, got %+v", name, name, s)
+		}
+	}
+
+	// Hosts without an override still go through DNS.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	e := NewExporter("http://localhost:" + port + "/server-status?auto")
+	e.client = newHTTPClient(clientConfig{resolve: resolve})
+	scrape(e)
+	if !e.last.up() {
+		t.Errorf("expected scrape of localhost to succeed")
+	}
+}
+
+// scrapeFailureReason scrapes uri once with cfg and returns the reason the
+// scrape failed with, or "" if it succeeded.
+func scrapeFailureReason(t *testing.T, uri string, cfg clientConfig) string {
+	e := NewExporter(uri)
+	e.client = newHTTPClient(cfg)
+	err := e.collect(context.Background(), &phaseTimer{}, make(chan prometheus.Metric, 100))
+	if err == nil {
+		return ""
+	}
+	return failureReason(err)
+}
+
+func TestPhaseTimeouts(t *testing.T) {
+	// Accepts connections but never speaks TLS.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := silent.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	defer func() {
+		silent.Close()
+		for c := range accepted {
+			c.Close()
+		}
+	}()
+
+	// Completes the handshake but never answers.
+	mute := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer mute.Close()
+	// Sends headers but stalls the body.
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Total Accesses: 1\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer stalled.Close()
+
+	cfg := clientConfig{
+		timeout:          time.Second,
+		handshakeTimeout: 100 * time.Millisecond,
+		headerTimeout:    100 * time.Millisecond,
+		insecure:         true,
+	}
+	for _, tc := range []struct {
+		name, uri, want string
+	}{
+		{"handshake", "https://" + silent.Addr().String() + "/server-status?auto", reasonTLSHandshakeTimeout},
+		{"headers", mute.URL, reasonResponseHeaderTimeout},
+		{"body", stalled.URL, reasonTimeout},
+	} {
+		start := time.Now()
+		if got := scrapeFailureReason(t, tc.uri, cfg); got != tc.want {
+			t.Errorf("%s: expected failure reason %q, got %q", tc.name, tc.want, got)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: scrape took %s", tc.name, d)
+		}
+	}
+}
+
+// timeoutError stands in for the transport's unexported timeout errors.
+type timeoutError string
+
+func (e timeoutError) Error() string { return string(e) }
+func (e timeoutError) Timeout() bool { return true }
+
+func TestRequestFailureReason(t *testing.T) {
+	start := time.Now()
+	// A timeout whose text names no phase, as the scrape deadline's.
+	timeout := &url.Error{Op: "Get", URL: "https://web01/", Err: context.DeadlineExceeded}
+	for _, tc := range []struct {
+		name  string
+		err   error
+		times phaseTimes
+		want  string
+	}{
+		{"dial", &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}, phaseTimes{connectStart: start}, reasonDialTimeout},
+		{"handshake", timeout, phaseTimes{connectStart: start, connected: start, tlsStart: start}, reasonTLSHandshakeTimeout},
+		{"handshake failed", timeout, phaseTimes{tlsStart: start, tlsDone: start, tlsErr: context.DeadlineExceeded}, reasonTLSHandshakeTimeout},
+		{"headers", timeout, phaseTimes{tlsStart: start, tlsDone: start, wroteRequest: start}, reasonResponseHeaderTimeout},
+		{"writing", timeout, phaseTimes{connectStart: start, connected: start}, reasonTimeout},
+		{"not tls", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, phaseTimes{tlsStart: start, tlsDone: start}, reasonRequest},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, phaseTimes{connectStart: start, connected: start}, reasonRequest},
+		{"untraced handshake", &url.Error{Op: "Get", URL: "https://web01/", Err: timeoutError("net/http: TLS handshake timeout")}, phaseTimes{}, reasonTLSHandshakeTimeout},
+	} {
+		if got := requestFailureReason(tc.err, tc.times); got != tc.want {
+			t.Errorf("%s: expected failure reason %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestDialTimeoutCoversLookup(t *testing.T) {
+	var dials int32
+	d := &dialer{
+		ipProtocol: "ip4",
+		timeout:    100 * time.Millisecond,
+		lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return nil, errors.New("not dialing in tests")
+		},
+	}
+
+	start := time.Now()
+	_, err := d.DialContext(context.Background(), "tcp", "stalling.example:80")
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("lookup was not bounded by the dial timeout, took %s", d)
+	}
+	if got := requestFailureReason(err, phaseTimes{}); got != reasonDialTimeout {
+		t.Errorf("expected failure reason %q, got %q (%v)", reasonDialTimeout, got, err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 0 {
+		t.Errorf("expected no connection attempts, got %d", n)
+	}
+}
+
+func TestSkipHostnameVerify(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	intermediate := newTestCert(t, "intermediate", ca, true)
+	other := newTestCert(t, "other-ca", nil, true)
+	serve := func(chain ...*testCert) string {
+		cert := tls.Certificate{PrivateKey: chain[0].key}
+		for _, c := range chain {
+			cert.Certificate = append(cert.Certificate, c.cert.Raw)
+		}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(apache24Status))
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		// The certificates are only for 127.0.0.1.
+		return strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	}
+	signed := serve(newTestCert(t, "apache", ca, false))
+	chained := serve(newTestCert(t, "apache", intermediate, false), intermediate)
+	untrusted := serve(newTestCert(t, "apache", other, false))
+
+	for _, tc := range []struct {
+		name string
+		uri  string
+		cfg  clientConfig
+		ok   bool
+	}{
+		{"name checked", signed, clientConfig{tls: trusting(ca)}, false},
+		{"name skipped", signed, clientConfig{tls: trusting(ca), skipHostname: true}, true},
+		{"through an intermediate", chained, clientConfig{tls: trusting(ca), skipHostname: true}, true},
+		{"signed by another CA", untrusted, clientConfig{tls: trusting(ca), skipHostname: true}, false},
+		{"system roots", signed, clientConfig{skipHostname: true}, false},
+		{"with -insecure", untrusted, clientConfig{insecure: true, skipHostname: true}, true},
+	} {
+		tc.cfg.timeout = 5 * time.Second
+		resp, err := newHTTPClient(tc.cfg).Get(tc.uri + "/server-status?auto")
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok %v, got %v", tc.name, tc.ok, err)
+		}
+		var hostErr x509.HostnameError
+		if errors.As(err, &hostErr) != (tc.name == "name checked") {
+			t.Errorf("%s: expected only the name check to fail on the name, got %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var clusterAggregate = flag.Bool("cluster.aggregate", false, "Also export apache_cluster_* metrics summing up all the targets, by group if the targets are grouped.")
+
+// monotonicCounter is a counter of apache kept going up across its resets,
+// as when apache restarts, by adding back the value it had before each.
+type monotonicCounter struct {
+	mutex      sync.Mutex
+	seen       bool
+	last, base float64
+}
+
+func (c *monotonicCounter) observe(v float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.seen && v < c.last {
+		c.base += c.last
+	}
+	c.last, c.seen = v, true
+}
+
+func (c *monotonicCounter) value() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.base + c.last
+}
+
+// cluster sums up the targets of a set. The accesses of targets removed
+// from it stay in the sum, so that it never goes down.
+type cluster struct {
+	mutex   sync.Mutex
+	retired map[string]float64 // Accesses of removed targets, by group.
+}
+
+// retire keeps the accesses of the targets of old not in es.
+func (c *cluster) retire(old, es Exporters) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, o := range old {
+		kept := false
+		for _, e := range es {
+			if reflect.DeepEqual(e.labels, o.labels) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			if c.retired == nil {
+				c.retired = map[string]float64{}
+			}
+			c.retired[o.labels["group"]] += o.clusterAccesses.value()
+		}
+	}
+}
+
+// clusterTotals are the sums of a group of targets.
+type clusterTotals struct {
+	busy, idle, accesses float64
+	up, total, excluded  float64
+}
+
+// clusterMetrics are the metrics of the sums of the targets.
+var clusterMetrics = []struct {
+	name, help string
+	valueType  prometheus.ValueType
+	value      func(t *clusterTotals) float64
+}{
+	{"busy_workers", "Number of busy workers of the targets up and out of maintenance.", prometheus.GaugeValue, func(t *clusterTotals) float64 { return t.busy }},
+	{"idle_workers", "Number of idle workers of the targets up and out of maintenance.", prometheus.GaugeValue, func(t *clusterTotals) float64 { return t.idle }},
+	{"accesses_total", "Number of accesses of all the targets, those removed since included, going on across their restarts.", prometheus.CounterValue, func(t *clusterTotals) float64 { return t.accesses }},
+	{"targets_up", "Number of targets whose last scrape was successful.", prometheus.GaugeValue, func(t *clusterTotals) float64 { return t.up }},
+	{"targets_total", "Number of targets.", prometheus.GaugeValue, func(t *clusterTotals) float64 { return t.total }},
+	{"targets_excluded", "Number of targets left out of the worker sums as their last scrape failed, they weren't scraped yet or are in maintenance.", prometheus.GaugeValue, func(t *clusterTotals) float64 { return t.excluded }},
+}
+
+// clusterDescs returns the descriptions of clusterMetrics, with the group
+// label if grouped.
+func clusterDescs(grouped bool) []*prometheus.Desc {
+	var labels []string
+	if grouped {
+		labels = []string{"group"}
+	}
+	var descs []*prometheus.Desc
+	for _, m := range clusterMetrics {
+		descs = append(descs, prometheus.NewDesc(prometheus.BuildFQName(namespace, "cluster", m.name), m.help, labels, withConstLabels(nil)))
+	}
+	return descs
+}
+
+// grouped tells whether every target of es is in a group.
+func (es Exporters) grouped() bool {
+	for _, e := range es {
+		if _, ok := e.labels["group"]; !ok {
+			return false
+		}
+	}
+	return len(es) > 0
+}
+
+func (c *cluster) describe(es Exporters, ch chan<- *prometheus.Desc) {
+	if !*clusterAggregate {
+		return
+	}
+	for _, desc := range clusterDescs(es.grouped()) {
+		ch <- desc
+	}
+}
+
+// collect sends the sums of the targets es, as last scraped, by group if
+// they are grouped.
+func (c *cluster) collect(es Exporters, ch chan<- prometheus.Metric) {
+	if !*clusterAggregate {
+		return
+	}
+	grouped := es.grouped()
+	totals := map[string]*clusterTotals{}
+	get := func(group string) *clusterTotals {
+		if !grouped {
+			group = ""
+		}
+		t := totals[group]
+		if t == nil {
+			t = &clusterTotals{}
+			totals[group] = t
+		}
+		return t
+	}
+	c.mutex.Lock()
+	for group, accesses := range c.retired {
+		if grouped && group == "" {
+			continue // Of targets removed before the targets were grouped.
+		}
+		get(group).accesses += accesses
+	}
+	c.mutex.Unlock()
+	if !grouped {
+		get("") // Exported without targets too.
+	}
+	now := time.Now()
+	for _, e := range es {
+		t := get(e.labels["group"])
+		t.total++
+		t.accesses += e.clusterAccesses.value()
+		up := e.last.up()
+		if up {
+			t.up++
+		}
+		status := e.last.serverStatus()
+		if !up || status == nil || !e.maintenanceUntil(now).IsZero() {
+			t.excluded++
+			continue
+		}
+		t.busy += status.BusyWorkers
+		t.idle += status.IdleWorkers
+	}
+
+	descs := clusterDescs(grouped)
+	for group, t := range totals {
+		var labels []string
+		if grouped {
+			labels = []string{group}
+		}
+		for i, m := range clusterMetrics {
+			ch <- prometheus.MustNewConstMetric(descs[i], m.valueType, m.value(t), labels...)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestMonotonicCounter(t *testing.T) {
+	c := &monotonicCounter{}
+	for _, tc := range []struct {
+		observed, want float64
+	}{
+		{100, 100},
+		{150, 150},
+		{150, 150},
+		// Restarted, 20 accesses since.
+		{20, 170},
+		{50, 200},
+		// Restarted again, not accessed since.
+		{0, 200},
+		{0, 200},
+		{10, 210},
+	} {
+		c.observe(tc.observed)
+		if v := c.value(); v != tc.want {
+			t.Errorf("after %v: expected %v, got %v", tc.observed, tc.want, v)
+		}
+	}
+}
+
+// fleet serves the status pages of targets, by path, with the accesses
+// and workers set, or failing if down.
+type fleet struct {
+	mutex    sync.Mutex
+	accesses map[string]int
+	down     map[string]bool
+}
+
+func (f *fleet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.down[r.URL.Path] {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "Total Accesses: %d\nTotal kBytes: 1\nUptime: 60\nBusyWorkers: 3\nIdleWorkers: 7\n", f.accesses[r.URL.Path])
+}
+
+func (f *fleet) set(path string, accesses int, down bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.accesses[path] = accesses
+	f.down[path] = down
+}
+
+// clusterValues collects s, returning the apache_cluster_ values by name
+// and group.
+func clusterValues(t *testing.T, s *targetSet) map[string]float64 {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			if group := labelValue(m, "group"); group != "" {
+				name += "/" + group
+			}
+			values[name] = metricValue(m)
+		}
+	}
+	return values
+}
+
+func TestClusterAccesses(t *testing.T) {
+	defer func(old bool) { *clusterAggregate = old }(*clusterAggregate)
+	*clusterAggregate = true
+	f := &fleet{accesses: map[string]int{"/a": 100, "/b": 1000, "/c": 10}, down: map[string]bool{}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	targets := []string{"a=" + ts.URL + "/a", "b=" + ts.URL + "/b", "c=" + ts.URL + "/c"}
+	load := func() (Exporters, error) { return setupExporters(targets, testDefaults, false) }
+	es, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, load)
+
+	var previous float64
+	for _, step := range []struct {
+		name string
+		do   func()
+		want float64
+	}{
+		{"first scrape", func() {}, 1110},
+		{"accessed", func() { f.set("/a", 150, false) }, 1160},
+		// b restarted with 5 accesses since: b counts 1005, not 5.
+		{"b restarted", func() { f.set("/b", 5, false) }, 1165},
+		// Still counted as last scraped.
+		{"c down", func() { f.set("/c", 0, true) }, 1165},
+		// Back after restarting, with 2 accesses.
+		{"c up again", func() { f.set("/c", 2, false) }, 1167},
+		// c's accesses stay in the sum once removed.
+		{"c removed", func() {
+			targets = targets[:2]
+			if err := s.reload(); err != nil {
+				t.Fatal(err)
+			}
+		}, 1167},
+		{"accessed after the reload", func() { f.set("/a", 160, false) }, 1177},
+		// Restarted again, below what it was before its first restart.
+		{"b restarted again", func() { f.set("/b", 1, false) }, 1178},
+	} {
+		step.do()
+		got := clusterValues(t, s)["apache_cluster_accesses_total"]
+		if got != step.want {
+			t.Errorf("%s: expected %v accesses, got %v", step.name, step.want, got)
+		}
+		if got < previous {
+			t.Errorf("%s: the accesses went down from %v to %v", step.name, previous, got)
+		}
+		previous = got
+	}
+}
+
+func TestClusterGauges(t *testing.T) {
+	defer func(old bool) { *clusterAggregate = old }(*clusterAggregate)
+	*clusterAggregate = true
+	f := &fleet{accesses: map[string]int{}, down: map[string]bool{"/web03": true}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	var conf []config.Target
+	for _, target := range []struct{ name, group string }{
+		{"web01", "frontend"}, {"web02", "frontend"}, {"web03", "frontend"}, {"db01", "backend"},
+	} {
+		conf = append(conf, config.Target{Name: target.name, URI: ts.URL + "/" + target.name, Group: target.group})
+	}
+	es, err := newExporters(targetsFromConfig(conf), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, nil)
+	// Scraped once before going in maintenance.
+	es[1].Collect(make(chan prometheus.Metric, 100))
+	es[1].maintenance.set(time.Now().Add(time.Hour))
+	got := clusterValues(t, s)
+	for name, want := range map[string]float64{
+		// web03 down, web02 in maintenance.
+		"apache_cluster_busy_workers/frontend":     3,
+		"apache_cluster_idle_workers/frontend":     7,
+		"apache_cluster_targets_up/frontend":       2,
+		"apache_cluster_targets_total/frontend":    3,
+		"apache_cluster_targets_excluded/frontend": 2,
+		"apache_cluster_busy_workers/backend":      3,
+		"apache_cluster_idle_workers/backend":      7,
+		"apache_cluster_targets_up/backend":        1,
+		"apache_cluster_targets_total/backend":     1,
+		"apache_cluster_targets_excluded/backend":  0,
+	} {
+		if v, ok := got[name]; !ok || v != want {
+			t.Errorf("expected %s %v, got %v", name, want, got[name])
+		}
+	}
+	if _, ok := got["apache_cluster_busy_workers"]; ok {
+		t.Error("expected the grouped targets to be summed up by group only")
+	}
+
+	// Ungrouped, all the targets together.
+	es, err = setupExporters([]string{"a=" + ts.URL + "/a", "b=" + ts.URL + "/web03"}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = clusterValues(t, newTargetSet(es, nil))
+	for name, want := range map[string]float64{
+		"apache_cluster_busy_workers":     3,
+		"apache_cluster_targets_up":       1,
+		"apache_cluster_targets_total":    2,
+		"apache_cluster_targets_excluded": 1,
+	} {
+		if v := got[name]; v != want {
+			t.Errorf("expected %s %v, got %v", name, want, v)
+		}
+	}
+
+	*clusterAggregate = false
+	if got := clusterValues(t, newTargetSet(es, nil)); got["apache_cluster_targets_total"] != 0 {
+		t.Errorf("expected no cluster metrics without -cluster.aggregate, got %v", got)
+	}
+}
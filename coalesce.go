@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shareWindow       = flag.Duration("scrape.share-window", 0, "Serve requests coming in up to this long after a scrape of the same target finished the results of that scrape, instead of scraping apache again. Concurrent requests always share a scrape.")
+	minScrapeInterval = flag.Duration("scrape.min-interval", 0, "Scrape each target at most once this often, serving the results of the latest scrape to requests coming in sooner. 0 scrapes on every request, unless shared as -scrape.share-window says.")
+)
+
+// flight is a scrape of a target shared by the collects asking for it
+// while it runs, and shortly after.
+type flight struct {
+	done      chan struct{} // Closed once the scrape finished.
+	metrics   []prometheus.Metric
+	err       error
+	started   time.Time
+	at        time.Time // When the scrape finished, zero while it runs.
+	cancelled bool      // The scrape was cut short.
+}
+
+// servable tells whether the finished scrape f may still be served at now,
+// as -scrape.share-window and -scrape.min-interval say.
+func (f *flight) servable(now time.Time) bool {
+	return now.Sub(f.at) < *shareWindow || now.Sub(f.started) < *minScrapeInterval
+}
+
+// sharedCollect is collectTarget, except that it waits for a scrape of e
+// already running, or takes a finished one still servable, and serves its
+// results.
+func (e *Exporter) sharedCollect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	for {
+		e.flightMutex.Lock()
+		f := e.flight
+		if f == nil || f.cancelled || (!f.at.IsZero() && !f.servable(time.Now())) {
+			break // With the lock held.
+		}
+		cached := !f.at.IsZero()
+		e.flightMutex.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if f.cancelled {
+			continue // Scrape again, on behalf of this collect.
+		}
+		e.coalesced.Inc()
+		if cached {
+			e.cacheHits.Inc()
+		}
+		e.serve(f, ch)
+		return f.err
+	}
+	f := &flight{done: make(chan struct{}), started: time.Now()}
+	e.flight = f
+	e.flightMutex.Unlock()
+
+	e.lead(ctx, f)
+	e.serve(f, ch)
+	return f.err
+}
+
+// serve sends the results of the finished scrape f to ch, along with how
+// old they are with -scrape.min-interval.
+func (e *Exporter) serve(f *flight, ch chan<- prometheus.Metric) {
+	for _, m := range f.metrics {
+		ch <- m
+	}
+	e.coalesced.Collect(ch)
+	if *minScrapeInterval > 0 {
+		e.cacheHits.Collect(ch)
+		ch <- prometheus.MustNewConstMetric(e.dataAgeDesc, prometheus.GaugeValue, time.Since(f.at).Seconds())
+	}
+}
+
+// lead runs the scrape of f. Should it not finish, such as when the scrape
+// panics, the collects waiting on f scrape again.
+func (e *Exporter) lead(ctx context.Context, f *flight) {
+	metrics := make(chan prometheus.Metric)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for m := range metrics {
+			f.metrics = append(f.metrics, freeze(m))
+		}
+	}()
+	finished := false
+	defer func() {
+		close(metrics)
+		<-collected
+		if finished && f.err == nil {
+			e.maintenance.keep(f.metrics)
+		}
+		e.flightMutex.Lock()
+		f.at = time.Now()
+		f.cancelled = !finished || ctx.Err() != nil
+		e.flightMutex.Unlock()
+		close(f.done)
+	}()
+	f.err = e.collectTarget(ctx, metrics)
+	finished = true
+}
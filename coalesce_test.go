@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescedScrapes(t *testing.T) {
+	defer func(old time.Duration) { *shareWindow = old }(*shareWindow)
+	*shareWindow = time.Hour
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+
+	e := NewExporter(ts.URL)
+	h := metricsHandler(newTargetSet(Exporters{e}, nil))
+	get := func() string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = get()
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected concurrent requests to share 1 scrape, got %d", n)
+	}
+	for _, body := range bodies {
+		if !strings.Contains(body, "apache_up 1") || !strings.Contains(body, `apache_workers{state="busy"}`) {
+			t.Errorf("expected the shared results in\n%s", body)
+		}
+	}
+	if v := counterValue(t, e.coalesced); v != 4 {
+		t.Errorf("expected 4 coalesced requests, got %v", v)
+	}
+
+	// Within the window, the results are shared after the scrape too.
+	if body := get(); !strings.Contains(body, "apache_exporter_coalesced_scrapes_total 5") {
+		t.Errorf("expected 5 coalesced requests in\n%s", body)
+	}
+	*shareWindow = 0
+	get()
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected a new scrape past the window, got %d requests", n)
+	}
+}
+
+func TestCoalescedScrapeCancelled(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		<-release
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+
+	e := NewExporter(ts.URL)
+	h := metricsHandler(newTargetSet(Exporters{e}, nil))
+	leader := httptest.NewRequest("GET", "/metrics", nil)
+	leader.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.3")
+	go h.ServeHTTP(httptest.NewRecorder(), leader)
+	waitFor(t, "the first scrape to start", func() bool { return atomic.LoadInt32(&requests) == 1 })
+
+	// A request waiting on a scrape that gets cut short scrapes again.
+	done := make(chan string)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		done <- rr.Body.String()
+	}()
+	waitFor(t, "the second scrape to start", func() bool { return atomic.LoadInt32(&requests) == 2 })
+	close(release)
+	if body := <-done; !strings.Contains(body, "apache_up 1") {
+		t.Errorf("expected a successful scrape in\n%s", body)
+	}
+}
+
+func TestMinScrapeInterval(t *testing.T) {
+	defer func(old time.Duration) { *minScrapeInterval = old }(*minScrapeInterval)
+	*minScrapeInterval = time.Hour
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+
+	e := NewExporter(ts.URL)
+	h := metricsHandler(newTargetSet(Exporters{e}, nil))
+	get := func() string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	if body := get(); !strings.Contains(body, "apache_exporter_cache_hits_total 0") {
+		t.Errorf("expected no cache hits on the first scrape in\n%s", body)
+	}
+
+	// Requests within the interval, sequential or not, are served the
+	// first scrape.
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = get()
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected 1 scrape within the interval, got %d", n)
+	}
+	for _, body := range bodies {
+		if !strings.Contains(body, "apache_up 1") || !strings.Contains(body, "apache_exporter_data_age_seconds ") {
+			t.Errorf("expected the cached results with their age in\n%s", body)
+		}
+	}
+	if v := counterValue(t, e.cacheHits); v != 10 {
+		t.Errorf("expected 10 cache hits, got %v", v)
+	}
+
+	*minScrapeInterval = time.Nanosecond
+	get()
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected a new scrape past the interval, got %d requests", n)
+	}
+	*minScrapeInterval = 0
+	if body := get(); strings.Contains(body, "apache_exporter_cache_hits_total") {
+		t.Errorf("expected no cache metrics without -scrape.min-interval in\n%s", body)
+	}
+}
+
+func TestFlightServable(t *testing.T) {
+	defer func(window, interval time.Duration) {
+		*shareWindow, *minScrapeInterval = window, interval
+	}(*shareWindow, *minScrapeInterval)
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	f := &flight{started: started, at: started.Add(2 * time.Second)}
+	for _, c := range []struct {
+		window, interval time.Duration
+		now              time.Time
+		want             bool
+	}{
+		{0, 0, f.at, false},
+		{0, 10 * time.Second, started.Add(10*time.Second - 1), true},
+		{0, 10 * time.Second, started.Add(10 * time.Second), false},
+		// The share window counts from the end of the scrape.
+		{10 * time.Second, 0, started.Add(11 * time.Second), true},
+		{10 * time.Second, 0, started.Add(12 * time.Second), false},
+		{10 * time.Second, 30 * time.Second, started.Add(20 * time.Second), true},
+	} {
+		*shareWindow, *minScrapeInterval = c.window, c.interval
+		if got := f.servable(c.now); got != c.want {
+			t.Errorf("window %s, interval %s, at %s: expected %v, got %v", c.window, c.interval, c.now.Sub(started), c.want, got)
+		}
+	}
+}
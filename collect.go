@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	metricsCollectors = &targetsFlag{}
+	lightEndpoint     = flag.String("web.telemetry-light-path", "/metrics/light", "Path under which to expose only apache_up and the -web.telemetry-light-collectors groups of apache metrics, for cheap scrapes. Empty to not serve it.")
+	lightCollectors   = &targetsFlag{}
+)
+
+func init() {
+	flag.Var(metricsCollectors, "web.telemetry-collectors", "Group of apache metrics served on -web.telemetry-path without collect[] parameters: accesses, traffic, uptime, workers, heartbeat, processes, config or resources. May be repeated or comma separated. All if none is given.")
+	flag.Var(lightCollectors, "web.telemetry-light-collectors", "Group of apache metrics served on -web.telemetry-light-path without collect[] parameters. May be repeated or comma separated. All if none is given.")
+}
+
+// collectorMetrics are the metrics each group of apache metrics consists
+// of, without the namespace, besides the corrected ones in renamedMetrics.
+var collectorMetrics = map[string][]string{
+	"accesses":  {"accesses_total", "duration_seconds_total", "request_rate_1m", "request_rate_5m", "request_duration_seconds_1m", "request_duration_seconds_5m"},
+	"traffic":   {"sent_kilobytes_total"},
+	"uptime":    {"uptime_seconds_total"},
+	"workers":   {"workers", "workers_utilization", "workers_saturation"},
+	"heartbeat": {"heartbeat_ready", "heartbeat_busy", "heartbeat_last_seen_timestamp_seconds"},
+	"processes": {"process_connections"},
+	"config":    {"config_value"},
+	"resources": {"process_resident_memory_bytes", "process_open_fds", "process_cpu_seconds_total", "processes_resident_memory_bytes", "processes_open_fds", "processes_cpu_seconds_total"},
+}
+
+// requestedCollectors returns the groups of apache metrics asked for with
+// collect[] parameters in r, nil if none are.
+func requestedCollectors(r *http.Request) (map[string]bool, error) {
+	return collectorSet(r.URL.Query()["collect[]"])
+}
+
+// collectorSet returns the groups of apache metrics named, nil for none.
+func collectorSet(names []string) (map[string]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	collectors := map[string]bool{}
+	for _, name := range names {
+		if _, ok := collectorMetrics[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q, valid collectors are %s", name, strings.Join(config.Collectors, ", "))
+		}
+		collectors[name] = true
+	}
+	return collectors, nil
+}
+
+// endpoint is what a metrics endpoint serves when not asked for groups of
+// apache metrics with collect[].
+type endpoint struct {
+	collectors map[string]bool // Groups of apache metrics, all if nil.
+	light      bool            // Only apache_up besides those groups.
+	// Keeps the self metrics of the scrapes with -web.self-metrics-path.
+	self *selfSnapshot
+}
+
+// collectorFilter leaves the metrics e doesn't serve out of what g gathers.
+type collectorFilter struct {
+	g prometheus.Gatherer
+	e endpoint
+}
+
+func (f collectorFilter) Gather() ([]*dto.MetricFamily, error) {
+	groups := map[string]string{}
+	for name, metrics := range collectorMetrics {
+		for _, metric := range metrics {
+			groups[namespace+"_"+metric] = name
+		}
+	}
+	for _, r := range renamedMetrics {
+		groups[namespace+"_"+r.name] = r.collector
+	}
+	mfs, err := f.g.Gather()
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if group, ok := groups[mf.GetName()]; ok {
+			if f.e.collectors != nil && !f.e.collectors[group] {
+				continue
+			}
+		} else if f.e.light && mf.GetName() != namespace+"_up" {
+			continue
+		}
+		kept = append(kept, mf)
+	}
+	return kept, err
+}
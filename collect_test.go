@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestCollectorMetrics(t *testing.T) {
+	if len(collectorMetrics) != len(config.Collectors) {
+		t.Errorf("expected metrics for the collectors %v, got %v", config.Collectors, collectorMetrics)
+	}
+	for _, name := range config.Collectors {
+		if _, ok := collectorMetrics[name]; !ok {
+			t.Errorf("no metrics for collector %q", name)
+		}
+	}
+}
+
+func TestCollectParams(t *testing.T) {
+	var requests []string
+	var mutex sync.Mutex
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, r.URL.RequestURI())
+		mutex.Unlock()
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	scraped := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		r := requests
+		requests = nil
+		return r
+	}
+
+	cfg, err := config.Load([]byte("modules: {status-basic: {collectors: [accesses, traffic]}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler(newTargetSet(Exporters{NewExporter(backend.URL + "/server-status?auto")}, nil)))
+	mux.Handle("/probe", probeHandler(newHTTPClient(clientConfig{timeout: time.Second}), nil, testDefaults, func() map[string]config.Module { return cfg.Modules }))
+	target := strings.TrimPrefix(backend.URL, "http://")
+
+	for _, tc := range []struct {
+		query          string
+		want, unwanted []string
+	}{
+		{"/metrics", []string{"apache_up 1", "apache_accesses_total", "apache_workers", "apache_uptime_seconds_total"}, nil},
+		{"/metrics?collect[]=workers", []string{"apache_up 1", `apache_workers{state="busy"}`}, []string{"apache_accesses_total", "apache_sent_kilobytes_total", "apache_uptime_seconds_total"}},
+		{"/metrics?collect[]=uptime&collect[]=traffic", []string{"apache_uptime_seconds_total", "apache_sent_kilobytes_total"}, []string{"apache_accesses_total", "apache_workers"}},
+		{"/probe?target=" + target + "&collect[]=workers", []string{"apache_up 1", "apache_workers"}, []string{"apache_accesses_total", "apache_uptime_seconds_total"}},
+		{"/probe?target=" + target + "&module=status-basic&collect[]=traffic&collect[]=workers", []string{"apache_sent_kilobytes_total"}, []string{"apache_accesses_total", "apache_workers"}},
+	} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", tc.query, nil))
+		body := rr.Body.String()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tc.query, rr.Code, body)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: expected %q in exposition", tc.query, want)
+			}
+		}
+		for _, unwanted := range tc.unwanted {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%s: unexpected %q in exposition", tc.query, unwanted)
+			}
+		}
+		// All groups come from the one status page.
+		if got := scraped(); len(got) != 1 || got[0] != "/server-status?auto" {
+			t.Errorf("%s: expected one request for the status page, got %v", tc.query, got)
+		}
+	}
+
+	for _, query := range []string{"/metrics?collect[]=balancer", "/probe?target=" + target + "&collect[]=workers&collect[]=vhosts"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", query, nil))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "valid collectors are accesses, traffic, uptime, workers") {
+			t.Errorf("%s: expected 400 listing the collectors, got %d: %s", query, rr.Code, rr.Body)
+		}
+		if got := scraped(); len(got) != 0 {
+			t.Errorf("%s: expected apache not to be scraped, got %v", query, got)
+		}
+	}
+}
+
+// familyNames returns the names of the metric families in an exposition.
+func familyNames(body string) []string {
+	var names []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			names = append(names, strings.Fields(line)[2])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLightEndpoint(t *testing.T) {
+	defer func(old time.Duration) { *shareWindow = old }(*shareWindow)
+	*shareWindow = time.Hour
+	var requests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+
+	s := newTargetSet(Exporters{NewExporter(backend.URL)}, nil)
+	get := func(h http.Handler) string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	full := familyNames(get(endpointHandler(s, endpoint{})))
+	light := familyNames(get(endpointHandler(s, endpoint{collectors: map[string]bool{"workers": true, "uptime": true}, light: true})))
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected both endpoints to share 1 scrape within the window, got %d", n)
+	}
+	if want := []string{"apache_up", "apache_uptime_seconds_total", "apache_workers", "apache_workers_utilization"}; !reflect.DeepEqual(light, want) {
+		t.Errorf("expected the light families %v, got %v", want, light)
+	}
+	for _, want := range []string{"apache_accesses_total", "apache_exporter_scrape_failures_total", "apache_up", "apache_workers", "go_goroutines"} {
+		if i := sort.SearchStrings(full, want); i == len(full) || full[i] != want {
+			t.Errorf("expected %s among the full families %v", want, full)
+		}
+	}
+}
+
+func TestCollectorSuccess(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"accesses": true, "traffic": true, "uptime": true, "workers": true, "heartbeat": true}
+	statusCode := http.StatusOK
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == *heartbeatPath:
+			http.Error(w, "nope", http.StatusForbidden)
+		case statusCode != http.StatusOK:
+			http.Error(w, "nope", statusCode)
+		default:
+			w.Write([]byte(apache24Status))
+		}
+	}))
+	defer backend.Close()
+	gather := func() string {
+		rr := httptest.NewRecorder()
+		metricsHandler(newTargetSet(Exporters{NewExporter(backend.URL + "/server-status?auto")}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	successes := func(body string) []string {
+		var lines []string
+		for _, line := range strings.Split(body, "\n") {
+			if strings.HasPrefix(line, "apache_up ") || strings.HasPrefix(line, "apache_exporter_collector_success{") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	// The heartbeat group failing alone.
+	want := []string{
+		`apache_exporter_collector_success{collector="accesses"} 1`,
+		`apache_exporter_collector_success{collector="heartbeat"} 0`,
+		`apache_exporter_collector_success{collector="traffic"} 1`,
+		`apache_exporter_collector_success{collector="uptime"} 1`,
+		`apache_exporter_collector_success{collector="workers"} 1`,
+		"apache_up 1",
+	}
+	if got := successes(gather()); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// Every group along with the status page.
+	statusCode = http.StatusServiceUnavailable
+	want = []string{
+		`apache_exporter_collector_success{collector="accesses"} 0`,
+		`apache_exporter_collector_success{collector="heartbeat"} 0`,
+		`apache_exporter_collector_success{collector="traffic"} 0`,
+		`apache_exporter_collector_success{collector="uptime"} 0`,
+		`apache_exporter_collector_success{collector="workers"} 0`,
+		"apache_up 0",
+	}
+	if got := successes(gather()); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
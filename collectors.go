@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectorEnabled tells whether each group of apache metrics is
+// exported at all, as -collector.<name> and -no-collector.<name> say.
+var collectorEnabled = map[string]bool{}
+
+var collectorEnabledGauge *prometheus.GaugeVec
+
+func init() {
+	for _, c := range groupCollectors {
+		collectorEnabled[c.name] = !c.off
+		flag.Var(collectorFlag{c.name, false}, "collector."+c.name, "Export the "+c.name+" group of apache metrics.")
+		flag.Var(collectorFlag{c.name, true}, "no-collector."+c.name, "Don't export the "+c.name+" group of apache metrics.")
+	}
+	selfMetric(func() {
+		collectorEnabledGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_collector_enabled",
+			Help:        "Whether a group of apache metrics is exported, as -collector.<name> and -no-collector.<name> say.",
+			ConstLabels: withConstLabels(nil),
+		}, []string{"collector"})
+		for _, c := range groupCollectors {
+			v := 0.0
+			if collectorEnabled[c.name] {
+				v = 1
+			}
+			collectorEnabledGauge.WithLabelValues(c.name).Set(v)
+		}
+		registry.MustRegister(collectorEnabledGauge)
+	})
+}
+
+// collectorFlag switches the group of apache metrics name on, or off if
+// negated.
+type collectorFlag struct {
+	name    string
+	negated bool
+}
+
+func (f collectorFlag) String() string {
+	return strconv.FormatBool(collectorEnabled[f.name] != f.negated)
+}
+
+func (f collectorFlag) Set(value string) error {
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	collectorEnabled[f.name] = on != f.negated
+	return nil
+}
+
+func (f collectorFlag) IsBoolFlag() bool { return true }
+
+// isCollectorEnv tells whether the environment variable key would switch
+// a group of apache metrics, known or not.
+func isCollectorEnv(key string) bool {
+	return strings.HasPrefix(key, envName("collector.")) || strings.HasPrefix(key, envName("no-collector."))
+}
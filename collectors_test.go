@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestCollectorFlags(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	defer setNamespace("apache")
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"apache_accesses_total", "apache_info", "apache_maintenance", "apache_sent_kilobytes_total", "apache_status_endpoint_available", "apache_up", "apache_uptime_seconds_total", "apache_workers", "apache_workers_utilization"}},
+		{[]string{"-no-collector.workers", "-no-collector.traffic"}, []string{"apache_accesses_total", "apache_info", "apache_maintenance", "apache_status_endpoint_available", "apache_up", "apache_uptime_seconds_total"}},
+		{[]string{"-no-collector.accesses", "-collector.uptime=false", "-collector.accesses"}, []string{"apache_accesses_total", "apache_info", "apache_maintenance", "apache_sent_kilobytes_total", "apache_status_endpoint_available", "apache_up", "apache_workers", "apache_workers_utilization"}},
+	} {
+		collectorEnabled = map[string]bool{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, c := range groupCollectors {
+			collectorEnabled[c.name] = !c.off
+			fs.Var(collectorFlag{c.name, false}, "collector."+c.name, "")
+			fs.Var(collectorFlag{c.name, true}, "no-collector."+c.name, "")
+		}
+		if _, err := parseFlags(fs, tc.args, nil); err != nil {
+			t.Fatalf("%v: %v", tc.args, err)
+		}
+		setNamespace("apache")
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(NewExporter(backend.URL))
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, mf := range mfs {
+			if !strings.HasPrefix(mf.GetName(), "apache_exporter_") {
+				got = append(got, mf.GetName())
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("%v: got %v, want %v", tc.args, got, tc.want)
+		}
+
+		mfs, err = registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		enabled := map[string]float64{}
+		for _, mf := range mfs {
+			if mf.GetName() == "apache_exporter_collector_enabled" {
+				for _, m := range mf.GetMetric() {
+					enabled[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+		for _, name := range config.Collectors {
+			want := 0.0
+			if collectorEnabled[name] {
+				want = 1
+			}
+			if enabled[name] != want {
+				t.Errorf("%v: apache_exporter_collector_enabled{collector=%q} is %v, want %v", tc.args, name, enabled[name], want)
+			}
+		}
+	}
+}
+
+func TestUnknownCollector(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	if _, err := parseFlags(fs, []string{"-collector.vhosts"}, nil); err == nil {
+		t.Error("no error for an unknown collector flag")
+	}
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := parseFlags(fs, nil, []string{"APACHE_EXPORTER_NO_COLLECTOR_VHOSTS=true"})
+	if err == nil || !strings.Contains(err.Error(), "APACHE_EXPORTER_NO_COLLECTOR_VHOSTS switches an unknown collector") {
+		t.Errorf("got %v for an unknown collector variable", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// compatMode is the set of names and types of the corrected apache metrics
+// exported, as set by -metrics.compat-mode.
+var compatMode = compatModeFlag("legacy")
+
+func init() {
+	flag.Var(&compatMode, "metrics.compat-mode", "Names and types of the apache metrics that were corrected to export: legacy for the old ones, new for the corrected ones, or both, for moving dashboards over.")
+}
+
+// renamedMetric is an apache metric whose name or type was corrected,
+// along with the legacy metric it replaces.
+type renamedMetric struct {
+	collector string // Group both belong to.
+	field     string // Of the status page both come from.
+	legacy    string // Name of the legacy metric, without the namespace.
+	name      string // Corrected name, without the namespace.
+	help      string
+	valueType prometheus.ValueType
+	scale     float64 // The field is multiplied by.
+}
+
+// renamedMetrics are all the corrected apache metrics. Every other metric
+// is exported the same in all modes.
+var renamedMetrics = []renamedMetric{
+	{"traffic", "Total kBytes", "sent_kilobytes_total", "sent_bytes_total", "Current total bytes sent", prometheus.CounterValue, 1024},
+	{"uptime", "Uptime", "uptime_seconds_total", "uptime_seconds", "Current uptime in seconds", prometheus.GaugeValue, 1},
+}
+
+type compatModeFlag string
+
+func (f *compatModeFlag) String() string { return string(*f) }
+
+func (f *compatModeFlag) Set(value string) error {
+	switch value {
+	case "legacy", "both", "new":
+		*f = compatModeFlag(value)
+		return nil
+	}
+	return fmt.Errorf("unknown compatibility mode %q, valid are legacy, both and new", value)
+}
+
+// legacy tells whether the legacy metrics are exported.
+func (f compatModeFlag) legacy() bool { return f != "new" }
+
+// corrected tells whether the corrected metrics are exported.
+func (f compatModeFlag) corrected() bool { return f != "legacy" }
+
+// newRenamedDescs returns the descriptions of the corrected metrics, in the
+// order of renamedMetrics, with labels.
+func newRenamedDescs(labels prometheus.Labels) []*prometheus.Desc {
+	descs := make([]*prometheus.Desc, len(renamedMetrics))
+	for i, r := range renamedMetrics {
+		descs[i] = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", r.name), r.help, nil, labels)
+	}
+	return descs
+}
+
+// collectRenamed sends the corrected metrics of the group collector to ch
+// if they are exported.
+func (e *Exporter) collectRenamed(collector string, values map[string]float64, ch chan<- prometheus.Metric) {
+	if !compatMode.corrected() {
+		return
+	}
+	for i, r := range renamedMetrics {
+		if val, ok := values[r.field]; ok && r.collector == collector {
+			ch <- prometheus.MustNewConstMetric(e.renamedDescs[i], r.valueType, val*r.scale)
+		}
+	}
+}
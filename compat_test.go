@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompatModes(t *testing.T) {
+	defer func(mode compatModeFlag) { compatMode = mode }(compatMode)
+	for _, mode := range []string{"legacy", "both", "new"} {
+		if err := compatMode.Set(mode); err != nil {
+			t.Fatal(err)
+		}
+		got, err := fixtureExposition("event-2.4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join("testdata", "compat", mode+".prom")
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := compareExpositions(got, want); err != nil {
+			t.Errorf("%s: %s", mode, err)
+		}
+	}
+	if err := compatMode.Set("old"); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
+
+func TestCompatLegacyUnchanged(t *testing.T) {
+	defer func(mode compatModeFlag) { compatMode = mode }(compatMode)
+	compatMode = "legacy"
+	if err := checkFixture("event-2.4"); err != nil {
+		t.Errorf("legacy mode changed the exposition: %s", err)
+	}
+}
@@ -0,0 +1,479 @@
+// Package config loads the exporter's YAML configuration file.
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Secret is a string that is hidden when printed or marshalled, so
+// configs can be logged without leaking credentials.
+//
+// Fields that may hold credentials, Secrets or not, are also tagged
+// secret, for renderings of the configuration to redact: "true" for the
+// whole value or the values of a map, "uri" and "query" for the
+// credentials in a URI or query string.
+type Secret string
+
+const secretToken = "<secret>"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return secretToken
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// Config is the top level of the configuration file.
+type Config struct {
+	Targets []Target          `yaml:"targets,omitempty"`
+	Modules map[string]Module `yaml:"modules,omitempty"`
+	// Push is how to connect to the -push.gateway-url Pushgateway.
+	Push *HTTPConfig `yaml:"push,omitempty"`
+	// MetricRules are applied to the apache metrics before they are
+	// exposed.
+	MetricRules []MetricRule `yaml:"metric_rules,omitempty"`
+	// DerivedMetrics are gauges of every target, by their names, computed
+	// from the fields of its status page on every scrape.
+	DerivedMetrics map[string]*Expr `yaml:"derived_metrics,omitempty"`
+}
+
+// Target is a single apache to scrape. Anything left unset falls back to
+// the command line flags.
+type Target struct {
+	// Name is used as the target label, the sanitized URI if empty.
+	Name string `yaml:"name,omitempty"`
+	URI  string `yaml:"uri" secret:"uri"`
+	// FallbackURIs are scraped in order when connecting to URI, or to the
+	// fallback before, fails.
+	FallbackURIs []string `yaml:"fallback_uris,omitempty" secret:"uri"`
+
+	HTTPConfig `yaml:",inline"`
+
+	// Labels are added to every metric of the target.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Group is the target's group label, default if unset.
+	Group string `yaml:"group,omitempty"`
+	// ScrapeInterval overrides -scrape.interval for the target, when
+	// scraping in the background.
+	ScrapeInterval time.Duration `yaml:"scrape_interval,omitempty"`
+	// MaintenanceUntil puts the target in maintenance, not scraped, until
+	// then, as POST /-/maintenance does.
+	MaintenanceUntil time.Time `yaml:"maintenance_until,omitempty"`
+}
+
+// HTTPConfig is how to connect to and authenticate with apache.
+type HTTPConfig struct {
+	BasicAuth       *BasicAuth `yaml:"basic_auth,omitempty"`
+	BearerToken     Secret     `yaml:"bearer_token,omitempty" secret:"true"`
+	BearerTokenFile string     `yaml:"bearer_token_file,omitempty"`
+	FormAuth        *FormAuth  `yaml:"form_auth,omitempty"`
+	TLSConfig       *TLSConfig `yaml:"tls_config,omitempty"`
+	// SourceAddress overrides -scrape.source-address.
+	SourceAddress string `yaml:"source_address,omitempty"`
+
+	// Headers are added to every scrape request.
+	Headers map[string]string `yaml:"headers,omitempty" secret:"true"`
+}
+
+// Module is a named set of settings for targets probed through /probe.
+type Module struct {
+	// Path and Query complete probed targets given without them, instead
+	// of the flag defaults.
+	Path  string `yaml:"path,omitempty"`
+	Query string `yaml:"query,omitempty" secret:"query"`
+	// Collectors are the groups of apache metrics exported, all if empty.
+	Collectors []string `yaml:"collectors,omitempty"`
+
+	HTTPConfig `yaml:",inline"`
+}
+
+// Collectors are the groups of apache metrics modules can choose from.
+var Collectors = []string{"accesses", "traffic", "uptime", "workers", "heartbeat", "processes", "config", "resources"}
+
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     Secret `yaml:"password,omitempty" secret:"true"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+// FormAuth logs in with a login form, as of mod_auth_form, for a session
+// cookie to scrape with.
+type FormAuth struct {
+	// LoginURL is where the form is posted to, relative to the URI of the
+	// target if not absolute.
+	LoginURL string `yaml:"login_url" secret:"uri"`
+	// LoginPage is where apache redirects to log in, its
+	// AuthFormLoginRequiredLocation, login_url if unset.
+	LoginPage    string `yaml:"login_page,omitempty" secret:"uri"`
+	Username     string `yaml:"username"`
+	Password     Secret `yaml:"password,omitempty" secret:"true"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+	// UsernameField and PasswordField name the fields of the form,
+	// httpd_username and httpd_password, those of mod_auth_form, if unset.
+	UsernameField string `yaml:"username_field,omitempty"`
+	PasswordField string `yaml:"password_field,omitempty"`
+	// MaxLogins is the most logins in a scrape, 1 if unset, so that
+	// rejected credentials don't get the account locked.
+	MaxLogins int `yaml:"max_logins,omitempty"`
+}
+
+type TLSConfig struct {
+	CAFile     string `yaml:"ca_file,omitempty"`
+	CertFile   string `yaml:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	ServerName string `yaml:"server_name,omitempty"`
+	// InsecureSkipVerify overrides the -insecure flag when set.
+	InsecureSkipVerify *bool `yaml:"insecure_skip_verify,omitempty"`
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by the exporter itself.
+var reservedLabels = map[string]bool{
+	"target": true,
+	"group":  true,
+	"reason": true,
+	"reused": true,
+	"phase":  true,
+	"state":  true,
+}
+
+// reservedHeaders are set by the exporter itself; credentials go in
+// basic_auth or bearer_token instead.
+var reservedHeaders = map[string]bool{
+	"Authorization":   true,
+	"Accept-Encoding": true,
+}
+
+// Load parses and validates a YAML configuration. Unknown fields are
+// errors.
+func Load(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile loads the configuration at path. Relative file names in it are
+// taken relative to the directory of path.
+func LoadFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	cfg.resolvePaths(filepath.Dir(path))
+	return cfg, nil
+}
+
+// LoadTargets parses and validates a YAML or JSON list of targets, in the
+// format of the configuration file's targets. The list may be empty.
+func LoadTargets(data []byte) ([]Target, error) {
+	var targets []Target
+	if err := yaml.UnmarshalStrict(data, &targets); err != nil {
+		return nil, err
+	}
+	if err := validateTargets(targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// LoadTargetsFile loads the list of targets at path, taking relative file
+// names relative to the directory of path.
+func LoadTargetsFile(path string) ([]Target, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := LoadTargets(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	resolveTargetPaths(targets, filepath.Dir(path))
+	return targets, nil
+}
+
+// String returns the configuration as YAML with secrets hidden.
+func (c *Config) String() string {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("<error marshalling config: %v>", err)
+	}
+	return string(b)
+}
+
+// Validate checks the configuration for mistakes that would otherwise
+// only show up when scraping.
+func (c *Config) Validate() error {
+	if len(c.Targets) == 0 && len(c.Modules) == 0 {
+		return fmt.Errorf("no targets or modules configured")
+	}
+	for name, m := range c.Modules {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("module %q: %v", name, err)
+		}
+	}
+	if c.Push != nil {
+		if err := c.Push.validate(); err != nil {
+			return fmt.Errorf("push: %v", err)
+		}
+	}
+	for i := range c.MetricRules {
+		if err := c.MetricRules[i].validate(); err != nil {
+			return fmt.Errorf("metric rule %d: %v", i+1, err)
+		}
+	}
+	if err := validateDerivedMetrics(c.DerivedMetrics); err != nil {
+		return err
+	}
+	return validateTargets(c.Targets)
+}
+
+func validateTargets(targets []Target) error {
+	names := map[string]bool{}
+	for i, t := range targets {
+		if err := t.validate(); err != nil {
+			if t.Name != "" {
+				return fmt.Errorf("target %q: %v", t.Name, err)
+			}
+			return fmt.Errorf("target %d: %v", i+1, err)
+		}
+		if t.Name == "" {
+			continue
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return nil
+}
+
+func (t *Target) validate() error {
+	if strings.TrimSpace(t.URI) == "" {
+		return fmt.Errorf("uri is required")
+	}
+	for _, uri := range t.FallbackURIs {
+		if strings.TrimSpace(uri) == "" {
+			return fmt.Errorf("empty uri in fallback_uris")
+		}
+	}
+	if err := t.HTTPConfig.validate(); err != nil {
+		return err
+	}
+	if t.ScrapeInterval < 0 {
+		return fmt.Errorf("negative scrape_interval %s", t.ScrapeInterval)
+	}
+	for name := range t.Labels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if reservedLabels[name] {
+			return fmt.Errorf("label %q is set by the exporter", name)
+		}
+	}
+	return nil
+}
+
+func (m *Module) validate() error {
+	if m.Path != "" && !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("path %q doesn't start with /", m.Path)
+	}
+	for _, name := range m.Collectors {
+		known := false
+		for _, c := range Collectors {
+			known = known || name == c
+		}
+		if !known {
+			return fmt.Errorf("unknown collector %q, valid collectors are %s", name, strings.Join(Collectors, ", "))
+		}
+	}
+	return m.HTTPConfig.validate()
+}
+
+func (t *HTTPConfig) validate() error {
+	if t.BearerToken != "" && t.BearerTokenFile != "" {
+		return fmt.Errorf("at most one of bearer_token and bearer_token_file may be set")
+	}
+	if t.BasicAuth != nil {
+		if t.BearerToken != "" || t.BearerTokenFile != "" {
+			return fmt.Errorf("at most one of basic_auth and bearer_token may be set")
+		}
+		if t.BasicAuth.Username == "" {
+			return fmt.Errorf("basic_auth requires a username")
+		}
+		if t.BasicAuth.Password != "" && t.BasicAuth.PasswordFile != "" {
+			return fmt.Errorf("at most one of basic_auth password and password_file may be set")
+		}
+	}
+	if f := t.FormAuth; f != nil {
+		if t.BasicAuth != nil || t.BearerToken != "" || t.BearerTokenFile != "" {
+			return fmt.Errorf("at most one of form_auth, basic_auth and bearer_token may be set")
+		}
+		if u, err := url.Parse(f.LoginURL); err != nil || f.LoginURL == "" || u.IsAbs() && u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("form_auth requires a login_url, an http or https URL or a path")
+		}
+		if _, err := url.Parse(f.LoginPage); err != nil {
+			return fmt.Errorf("invalid form_auth login_page: %v", err)
+		}
+		if f.Username == "" {
+			return fmt.Errorf("form_auth requires a username")
+		}
+		if f.Password != "" && f.PasswordFile != "" {
+			return fmt.Errorf("at most one of form_auth password and password_file may be set")
+		}
+		if f.MaxLogins < 0 {
+			return fmt.Errorf("invalid form_auth max_logins %d", f.MaxLogins)
+		}
+	}
+	if tc := t.TLSConfig; tc != nil && (tc.CertFile == "") != (tc.KeyFile == "") {
+		return fmt.Errorf("tls_config cert_file and key_file must be set together")
+	}
+	if t.SourceAddress != "" && net.ParseIP(t.SourceAddress) == nil {
+		return fmt.Errorf("invalid source_address %q, must be an IP address", t.SourceAddress)
+	}
+	for name := range t.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if reservedHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("header %q is set by the exporter", name)
+		}
+	}
+	return nil
+}
+
+func (c *Config) resolvePaths(dir string) {
+	resolveTargetPaths(c.Targets, dir)
+	for name, m := range c.Modules {
+		m.resolvePaths(dir)
+		c.Modules[name] = m
+	}
+	if c.Push != nil {
+		c.Push.resolvePaths(dir)
+	}
+}
+
+func resolveTargetPaths(targets []Target, dir string) {
+	for i := range targets {
+		targets[i].resolvePaths(dir)
+	}
+}
+
+func (t *HTTPConfig) resolvePaths(dir string) {
+	join := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) && !strings.HasPrefix(*path, CredentialPrefix) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	join(&t.BearerTokenFile)
+	if t.BasicAuth != nil {
+		join(&t.BasicAuth.PasswordFile)
+	}
+	if t.FormAuth != nil {
+		join(&t.FormAuth.PasswordFile)
+	}
+	if t.TLSConfig != nil {
+		join(&t.TLSConfig.CAFile)
+		join(&t.TLSConfig.CertFile)
+		join(&t.TLSConfig.KeyFile)
+	}
+}
+
+// ReadPassword returns the basic auth password, reading it from
+// PasswordFile if set so rotated passwords are picked up.
+func (b *BasicAuth) ReadPassword() (string, error) {
+	if b.PasswordFile == "" {
+		return string(b.Password), nil
+	}
+	return readSecretFile(b.PasswordFile)
+}
+
+// ReadPassword returns the form auth password, reading it from
+// PasswordFile if set.
+func (f *FormAuth) ReadPassword() (string, error) {
+	if f.PasswordFile == "" {
+		return string(f.Password), nil
+	}
+	return readSecretFile(f.PasswordFile)
+}
+
+// ReadBearerToken returns the bearer token, if any, reading it from
+// BearerTokenFile if set.
+func (t *HTTPConfig) ReadBearerToken() (string, error) {
+	if t.BearerTokenFile == "" {
+		return string(t.BearerToken), nil
+	}
+	return readSecretFile(t.BearerTokenFile)
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// ClientConfig builds the TLS client configuration for c, using insecure
+// unless c sets InsecureSkipVerify. A nil c gives the flag defaults.
+func (c *TLSConfig) ClientConfig(insecure bool) (*tls.Config, error) {
+	tc := &tls.Config{InsecureSkipVerify: insecure}
+	if c == nil {
+		return tc, nil
+	}
+	if c.InsecureSkipVerify != nil {
+		tc.InsecureSkipVerify = *c.InsecureSkipVerify
+	}
+	tc.ServerName = c.ServerName
+	if c.CAFile != "" {
+		pem, err := ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		certPEM, err := ReadFile(c.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
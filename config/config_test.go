@@ -0,0 +1,264 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fullConfig = `
+targets:
+  - name: web01
+    uri: https://web01/server-status?auto
+    basic_auth:
+      username: monitor
+      password: hunter2
+    tls_config:
+      ca_file: /etc/ssl/ca.pem
+      cert_file: client.pem
+      key_file: client.key
+      server_name: web01.example.com
+      insecure_skip_verify: false
+    headers:
+      X-Scrape: "yes"
+    labels:
+      env: prod
+  - uri: web02:8080
+    bearer_token_file: token
+    scrape_interval: 1m
+    maintenance_until: 2026-10-14T22:00:00Z
+`
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load([]byte(fullConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(cfg.Targets))
+	}
+	web01 := cfg.Targets[0]
+	if web01.Name != "web01" || web01.BasicAuth.Username != "monitor" || web01.BasicAuth.Password != "hunter2" {
+		t.Errorf("unexpected target %+v", web01)
+	}
+	if tc := web01.TLSConfig; tc.ServerName != "web01.example.com" || tc.InsecureSkipVerify == nil || *tc.InsecureSkipVerify {
+		t.Errorf("unexpected tls_config %+v", tc)
+	}
+	if web01.Headers["X-Scrape"] != "yes" || web01.Labels["env"] != "prod" {
+		t.Errorf("unexpected headers %v or labels %v", web01.Headers, web01.Labels)
+	}
+	if cfg.Targets[1].ScrapeInterval != time.Minute {
+		t.Errorf("expected a scrape_interval of 1m, got %s", cfg.Targets[1].ScrapeInterval)
+	}
+	if want := time.Date(2026, 10, 14, 22, 0, 0, 0, time.UTC); !cfg.Targets[1].MaintenanceUntil.Equal(want) {
+		t.Errorf("expected maintenance until %s, got %s", want, cfg.Targets[1].MaintenanceUntil)
+	}
+	if cfg.Targets[1].TLSConfig != nil {
+		t.Errorf("expected no tls_config, got %+v", cfg.Targets[1].TLSConfig)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config, err string
+	}{
+		{"", "no targets"},
+		{"targets: [{uri: web01, timeout: 5s}]", "field timeout not found"},
+		{"scrape_uri: web01", "field scrape_uri not found"},
+		{"targets: [{uri: web01, tls_config: {ca: ca.pem}}]", "field ca not found"},
+		{"targets: [{name: web01}]", "uri is required"},
+		{"targets: [{uri: web01, fallback_uris: ['']}]", "empty uri in fallback_uris"},
+		{"targets: [{uri: web01, bearer_token: a, bearer_token_file: b}]", "bearer_token and bearer_token_file"},
+		{"targets: [{uri: web01, bearer_token: a, basic_auth: {username: u}}]", "basic_auth and bearer_token"},
+		{"targets: [{uri: web01, basic_auth: {password: p}}]", "requires a username"},
+		{"targets: [{uri: web01, basic_auth: {username: u, password: p, password_file: f}}]", "password and password_file"},
+		{"targets: [{uri: web01, form_auth: {login_url: /login, username: u}, basic_auth: {username: u}}]", "form_auth, basic_auth and bearer_token"},
+		{"targets: [{uri: web01, form_auth: {username: u}}]", "requires a login_url"},
+		{"targets: [{uri: web01, form_auth: {login_url: 'ftp://web01/login', username: u}}]", "requires a login_url"},
+		{"targets: [{uri: web01, form_auth: {login_url: /login}}]", "form_auth requires a username"},
+		{"targets: [{uri: web01, form_auth: {login_url: /login, username: u, password: p, password_file: f}}]", "form_auth password and password_file"},
+		{"targets: [{uri: web01, form_auth: {login_url: /login, username: u, max_logins: -1}}]", "invalid form_auth max_logins"},
+		{"targets: [{uri: web01, tls_config: {cert_file: c}}]", "cert_file and key_file"},
+		{"targets: [{uri: web01, headers: {Authorization: x}}]", "set by the exporter"},
+		{"targets: [{uri: web01, headers: {'bad header': x}}]", "invalid header name"},
+		{"targets: [{uri: web01, labels: {0env: x}}]", "invalid label name"},
+		{"targets: [{uri: web01, labels: {__name__: x}}]", "invalid label name"},
+		{"targets: [{uri: web01, labels: {target: x}}]", "set by the exporter"},
+		{"targets: [{uri: web01, labels: {group: x}}]", "set by the exporter"},
+		{"targets: [{name: a, uri: web01}, {name: a, uri: web02}]", "duplicate target name"},
+		{"targets: [{uri: web01, scrape_interval: -1m}]", "negative scrape_interval"},
+		{"targets: [{uri: web01, scrape_interval: often}]", "cannot unmarshal"},
+		{"targets: [{uri: web01, maintenance_until: tonight}]", "parsing time"},
+		{"targets: [{uri: web01, source_address: mgmt0}]", "invalid source_address"},
+		{"modules: {m: {collectors: [balancer]}}", "unknown collector"},
+		{"modules: {m: {path: status}}", "doesn't start with /"},
+		{"modules: {m: {tls_config: {cert_file: c}}}", `module "m": tls_config cert_file`},
+		{"modules: {m: {labels: {env: prod}}}", "field labels not found"},
+		{"targets: [{uri: web01}]\npush: {bearer_token: a, bearer_token_file: b}", "push: at most one"},
+		{"targets: [{uri: web01}]\npush: {uri: gateway}", "field uri not found"},
+		{"targets: [{uri: web01", "yaml"},
+	} {
+		_, err := Load([]byte(tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Load(%q): expected error containing %q, got %v", tc.config, tc.err, err)
+		}
+	}
+}
+
+func TestLoadModules(t *testing.T) {
+	cfg, err := Load([]byte(`
+modules:
+  behind-sso:
+    path: /status
+    collectors: [accesses, workers]
+    bearer_token_file: token
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cfg.Modules["behind-sso"]
+	if m.Path != "/status" || len(m.Collectors) != 2 || m.BearerTokenFile != "token" {
+		t.Errorf("unexpected module %+v", m)
+	}
+	if len(cfg.Targets) != 0 {
+		t.Errorf("expected no targets, got %+v", cfg.Targets)
+	}
+}
+
+func TestLoadPush(t *testing.T) {
+	cfg, err := Load([]byte(`
+targets: [{uri: web01}]
+push:
+  bearer_token_file: push-token
+  tls_config: {ca_file: gateway.pem}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Push == nil || cfg.Push.BearerTokenFile != "push-token" || cfg.Push.TLSConfig.CAFile != "gateway.pem" {
+		t.Errorf("unexpected push settings %+v", cfg.Push)
+	}
+	dir := filepath.Join("etc", "apache_exporter")
+	cfg.resolvePaths(dir)
+	if got := cfg.Push.BearerTokenFile; got != filepath.Join(dir, "push-token") {
+		t.Errorf("expected bearer_token_file relative to the config, got %s", got)
+	}
+}
+
+func TestLoadFormAuth(t *testing.T) {
+	cfg, err := Load([]byte(`
+targets:
+  - uri: https://web01/server-status?auto
+    form_auth:
+      login_url: /dologin.html
+      login_page: /login.html
+      username: monitor
+      password_file: form-password
+      username_field: user
+  - uri: https://web02/server-status?auto
+    form_auth:
+      login_url: https://sso.example.com/login
+      username: monitor
+      password: hunter2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := cfg.Targets[0].FormAuth
+	if f == nil || f.LoginURL != "/dologin.html" || f.LoginPage != "/login.html" || f.Username != "monitor" || f.UsernameField != "user" || f.PasswordField != "" {
+		t.Fatalf("unexpected form_auth %+v", f)
+	}
+	dir := filepath.Join("etc", "apache_exporter")
+	cfg.resolvePaths(dir)
+	if got := f.PasswordFile; got != filepath.Join(dir, "form-password") {
+		t.Errorf("expected password_file relative to the config, got %s", got)
+	}
+	if password, err := cfg.Targets[1].FormAuth.ReadPassword(); err != nil || password != "hunter2" {
+		t.Errorf("expected the password, got %q, %v", password, err)
+	}
+	if strings.Contains(cfg.String(), "hunter2") {
+		t.Errorf("secret printed in %s", cfg)
+	}
+}
+
+func TestSecretsNotPrinted(t *testing.T) {
+	cfg, err := Load([]byte(strings.Replace(fullConfig, "bearer_token_file: token", "bearer_token: t0ken", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{cfg.String(), fmt.Sprintf("%+v", cfg.Targets), fmt.Sprintf("%v", *cfg.Targets[0].BasicAuth)} {
+		if strings.Contains(s, "hunter2") || strings.Contains(s, "t0ken") {
+			t.Errorf("secret printed in %s", s)
+		}
+	}
+	if !strings.Contains(cfg.String(), "password: <secret>") {
+		t.Errorf("expected hidden password in %s", cfg)
+	}
+	if _, err := Load([]byte(cfg.String())); err != nil {
+		t.Errorf("printed config doesn't load: %v", err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apache.yml")
+	if err := ioutil.WriteFile(path, []byte(fullConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("t0ken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Targets[0].TLSConfig.CertFile; got != filepath.Join(dir, "client.pem") {
+		t.Errorf("expected cert_file relative to the config, got %s", got)
+	}
+	if got := cfg.Targets[0].TLSConfig.CAFile; got != "/etc/ssl/ca.pem" {
+		t.Errorf("expected absolute ca_file to be kept, got %s", got)
+	}
+	if token, err := cfg.Targets[1].ReadBearerToken(); err != nil || token != "t0ken" {
+		t.Errorf("expected token t0ken, got %q, %v", token, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("targets: [{uri: web01, bogus: 1}]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected error naming %s, got %v", path, err)
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	targets, err := LoadTargets([]byte(`[{"name": "web01", "uri": "web01", "labels": {"env": "prod"}}, {"uri": "web02"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0].Labels["env"] != "prod" || targets[1].URI != "web02" {
+		t.Errorf("unexpected targets %+v", targets)
+	}
+
+	if targets, err := LoadTargets([]byte("")); err != nil || len(targets) != 0 {
+		t.Errorf("expected an empty file to give no targets, got %v, %v", targets, err)
+	}
+	for _, bad := range []string{
+		"targets: [{uri: web01}]",
+		"- {uri: web01, bogus: 1}",
+		"- {name: a, uri: web01}\n- {name: a, uri: web02}",
+		`[{"uri": "web01"}`,
+	} {
+		if _, err := LoadTargets([]byte(bad)); err == nil {
+			t.Errorf("LoadTargets(%q): expected an error", bad)
+		}
+	}
+}
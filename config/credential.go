@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CredentialPrefix starts file paths naming a credential systemd passed
+// with LoadCredential, such as credential:apache-status-pass, found in
+// $CREDENTIALS_DIRECTORY.
+const CredentialPrefix = "credential:"
+
+// CredentialPath returns the file path means: the file of the credential
+// named, or path itself if it doesn't name one.
+func CredentialPath(path string) (string, error) {
+	if !strings.HasPrefix(path, CredentialPrefix) {
+		return path, nil
+	}
+	name := strings.TrimPrefix(path, CredentialPrefix)
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid credential name %q", name)
+	}
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("%s needs $CREDENTIALS_DIRECTORY, which isn't set; is LoadCredential=%s set in the unit?", path, name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// ReadFile reads the file at path, which may name a credential. Files of
+// secrets are read through it every time they are used, so that rotated
+// ones are picked up.
+func ReadFile(path string) ([]byte, error) {
+	resolved, err := CredentialPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(resolved)
+	if os.IsNotExist(err) && resolved != path {
+		return nil, fmt.Errorf("%s: no such credential in $CREDENTIALS_DIRECTORY %s", path, filepath.Dir(resolved))
+	}
+	return data, err
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFileCredential(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "apache-status-pass"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	data, err := ReadFile("credential:apache-status-pass")
+	if err != nil || string(data) != "hunter2\n" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	for _, c := range []struct{ path, want string }{
+		{"credential:missing", "credential:missing: no such credential in $CREDENTIALS_DIRECTORY " + dir},
+		{"credential:../apache-status-pass", `invalid credential name "../apache-status-pass"`},
+		{"credential:", `invalid credential name ""`},
+	} {
+		if _, err := ReadFile(c.path); err == nil || err.Error() != c.want {
+			t.Errorf("%s: expected %q, got %v", c.path, c.want, err)
+		}
+	}
+
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, err := ReadFile("credential:apache-status-pass"); err == nil || !strings.Contains(err.Error(), "$CREDENTIALS_DIRECTORY, which isn't set") {
+		t.Errorf("expected an error about $CREDENTIALS_DIRECTORY, got %v", err)
+	}
+	if path, err := CredentialPath("/etc/apache_exporter/pass"); err != nil || path != "/etc/apache_exporter/pass" {
+		t.Errorf("expected a plain path unchanged, got %q, %v", path, err)
+	}
+}
+
+func TestLoadFileCredentials(t *testing.T) {
+	creds := t.TempDir()
+	for name, value := range map[string]string{"status-pass": "hunter2", "status-token": "t0ken"} {
+		if err := ioutil.WriteFile(filepath.Join(creds, name), []byte(value+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", creds)
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(path, []byte(`
+targets:
+  - uri: https://web01/server-status?auto
+    basic_auth: {username: monitor, password_file: "credential:status-pass"}
+  - uri: https://web02/server-status?auto
+    bearer_token_file: "credential:status-token"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, err := cfg.Targets[0].BasicAuth.ReadPassword(); err != nil || password != "hunter2" {
+		t.Errorf("got password %q, %v", password, err)
+	}
+	if token, err := cfg.Targets[1].ReadBearerToken(); err != nil || token != "t0ken" {
+		t.Errorf("got token %q, %v", token, err)
+	}
+
+	// Rotated credentials are picked up on the next read.
+	if err := ioutil.WriteFile(filepath.Join(creds, "status-pass"), []byte("hunter3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if password, _ := cfg.Targets[0].BasicAuth.ReadPassword(); password != "hunter3" {
+		t.Errorf("expected the rotated password, got %q", password)
+	}
+}
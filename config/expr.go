@@ -0,0 +1,277 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Expr is an arithmetic expression over the fields of the status page, as
+// in BusyWorkers - Scoreboard.K: numbers and fields combined with + - * /,
+// unary minus and parentheses. Fields are named as on the status page
+// without their spaces, as TotalKBytes for Total kBytes, and Scoreboard.K
+// is the number of workers in state K of the scoreboard.
+type Expr struct {
+	root exprNode
+	expr string
+}
+
+// ErrDivisionByZero is returned by Eval for a division by zero.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// MissingFieldError is returned by Eval for a field the page doesn't show.
+type MissingFieldError struct {
+	Field string
+}
+
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("field %s missing", e.Field)
+}
+
+// scoreboardStates are the states of workers on the scoreboard.
+const scoreboardStates = "_SRWKDCLGI."
+
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+type exprNode interface {
+	eval(field func(name string) (float64, bool)) (float64, error)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(func(string) (float64, bool)) (float64, error) {
+	return float64(n), nil
+}
+
+type fieldNode string
+
+func (n fieldNode) eval(field func(string) (float64, bool)) (float64, error) {
+	v, ok := field(string(n))
+	if !ok {
+		return 0, &MissingFieldError{string(n)}
+	}
+	return v, nil
+}
+
+type negNode struct {
+	x exprNode
+}
+
+func (n negNode) eval(field func(string) (float64, bool)) (float64, error) {
+	v, err := n.x.eval(field)
+	return -v, err
+}
+
+type binaryNode struct {
+	op   byte
+	x, y exprNode
+}
+
+func (n binaryNode) eval(field func(string) (float64, bool)) (float64, error) {
+	x, err := n.x.eval(field)
+	if err != nil {
+		return 0, err
+	}
+	y, err := n.y.eval(field)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return 0, ErrDivisionByZero
+	}
+	return x / y, nil
+}
+
+// ParseExpr parses expr.
+func ParseExpr(expr string) (*Expr, error) {
+	p := &exprParser{s: expr}
+	p.next()
+	root, err := p.parseSum()
+	if err == nil && p.tok != "" {
+		err = p.errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{root, expr}, nil
+}
+
+// Eval evaluates e, taking the values of its fields from field, which
+// tells whether the page shows the field. It returns a
+// *MissingFieldError for the first field field doesn't have, and
+// ErrDivisionByZero for a division by zero.
+func (e *Expr) Eval(field func(name string) (float64, bool)) (float64, error) {
+	return e.root.eval(field)
+}
+
+func (e *Expr) String() string {
+	return e.expr
+}
+
+func (e *Expr) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var expr string
+	if err := unmarshal(&expr); err != nil {
+		return err
+	}
+	parsed, err := ParseExpr(expr)
+	if err != nil {
+		return err
+	}
+	*e = *parsed
+	return nil
+}
+
+func (e *Expr) MarshalYAML() (interface{}, error) {
+	return e.expr, nil
+}
+
+// exprParser is a recursive descent parser of expressions, tok being the
+// token at pos.
+type exprParser struct {
+	s   string
+	pos int
+	tok string
+	end int // Of tok.
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid expression %q at %d: %s", p.s, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next moves on to the next token: a number, a field or an operator, or
+// "" at the end.
+func (p *exprParser) next() {
+	p.pos = p.end
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+	p.end = p.pos
+	if p.end == len(p.s) {
+		p.tok = ""
+		return
+	}
+	switch c := p.s[p.end]; {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.end < len(p.s) && (p.s[p.end] >= '0' && p.s[p.end] <= '9' || p.s[p.end] == '.') {
+			p.end++
+		}
+	case isIdentChar(c) && !(c >= '0' && c <= '9'):
+		for p.end < len(p.s) && isIdentChar(p.s[p.end]) {
+			p.end++
+		}
+		// The state of Scoreboard.K, which may be any character.
+		if p.end+1 < len(p.s) && p.s[p.end] == '.' {
+			p.end += 2
+		}
+	default:
+		p.end++
+	}
+	p.tok = p.s[p.pos:p.end]
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// parseSum parses terms added or subtracted, left to right.
+func (p *exprParser) parseSum() (exprNode, error) {
+	x, err := p.parseProduct()
+	for err == nil && (p.tok == "+" || p.tok == "-") {
+		op := p.tok[0]
+		p.next()
+		var y exprNode
+		if y, err = p.parseProduct(); err == nil {
+			x = binaryNode{op, x, y}
+		}
+	}
+	return x, err
+}
+
+// parseProduct parses factors multiplied or divided, left to right.
+func (p *exprParser) parseProduct() (exprNode, error) {
+	x, err := p.parseFactor()
+	for err == nil && (p.tok == "*" || p.tok == "/") {
+		op := p.tok[0]
+		p.next()
+		var y exprNode
+		if y, err = p.parseFactor(); err == nil {
+			x = binaryNode{op, x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseFactor() (exprNode, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, p.errorf("unexpected end")
+	case tok == "-":
+		p.next()
+		x, err := p.parseFactor()
+		return negNode{x}, err
+	case tok == "(":
+		p.next()
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return x, nil
+	case tok[0] >= '0' && tok[0] <= '9' || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok)
+		}
+		p.next()
+		return numberNode(v), nil
+	case isIdentChar(tok[0]):
+		if tok == "Scoreboard" {
+			return nil, p.errorf("expected a state of Scoreboard, as Scoreboard.K")
+		}
+		if i := strings.IndexByte(tok, '.'); i >= 0 {
+			if tok[:i] != "Scoreboard" {
+				return nil, p.errorf("only Scoreboard has states, got %q", tok)
+			}
+			if !strings.Contains(scoreboardStates, tok[i+1:]) {
+				return nil, p.errorf("unknown scoreboard state %q, valid are %s", tok[i+1:], scoreboardStates)
+			}
+		}
+		p.next()
+		return fieldNode(tok), nil
+	}
+	return nil, p.errorf("unexpected %q", tok)
+}
+
+// validateDerivedMetrics checks the names and expressions of derived
+// metrics, in order of their names.
+func validateDerivedMetrics(metrics map[string]*Expr) error {
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !metricNameRE.MatchString(name) {
+			return fmt.Errorf("derived metric %q: invalid metric name", name)
+		}
+		if metrics[name] == nil {
+			return fmt.Errorf("derived metric %q: no expression", name)
+		}
+	}
+	return nil
+}
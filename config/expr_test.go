@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	fields := map[string]float64{
+		"BusyWorkers":   10,
+		"IdleWorkers":   30,
+		"TotalAccesses": 200,
+		"TotalKBytes":   100,
+		"Scoreboard.K":  4,
+		"Scoreboard._":  30,
+		"Scoreboard..":  60,
+		"Zero":          0,
+	}
+	field := func(name string) (float64, bool) {
+		v, ok := fields[name]
+		return v, ok
+	}
+	for _, c := range []struct {
+		expr string
+		want float64
+	}{
+		{"42", 42},
+		{"1.5", 1.5},
+		{".5", 0.5},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"2 * 3 + 1", 7},
+		{"8 / 4 / 2", 1},
+		{"2 - 3 - 4", -5},
+		{"2 - (3 - 4)", 3},
+		{"1 + 6 / 3 * 2 - 1", 4},
+		{"-2 * 3", -6},
+		{"--2", 2},
+		{"-(1 + 2)", -3},
+		{"2 * -3", -6},
+		{"((((1))))", 1},
+		{"BusyWorkers - Scoreboard.K", 6},
+		{"BusyWorkers-Scoreboard.K", 6},
+		{"TotalKBytes*1024/TotalAccesses", 512},
+		{"IdleWorkers / (BusyWorkers + IdleWorkers) * 100", 75},
+		{"Scoreboard._ + Scoreboard..", 90},
+		{"Zero * 5", 0},
+	} {
+		e, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if got, err := e.Eval(field); err != nil || got != c.want {
+			t.Errorf("%s: expected %v, got %v, %v", c.expr, c.want, got, err)
+		}
+		if e.String() != c.expr {
+			t.Errorf("expected %q printed as given, got %q", c.expr, e)
+		}
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	field := func(name string) (float64, bool) {
+		if name == "BusyWorkers" {
+			return 5, true
+		}
+		return 0, false
+	}
+	for _, c := range []struct {
+		expr    string
+		missing string
+	}{
+		{"ConnsTotal", "ConnsTotal"},
+		{"BusyWorkers + ConnsTotal", "ConnsTotal"},
+		{"BusyWorkers * (1 + Scoreboard.K)", "Scoreboard.K"},
+		{"-Uptime", "Uptime"},
+		// The first missing field, even when divided by zero.
+		{"Uptime / 0", "Uptime"},
+	} {
+		e, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var missing *MissingFieldError
+		if _, err := e.Eval(field); !errors.As(err, &missing) || missing.Field != c.missing {
+			t.Errorf("%s: expected %s missing, got %v", c.expr, c.missing, err)
+		}
+	}
+	for _, expr := range []string{"1 / 0", "BusyWorkers / (BusyWorkers - 5)", "0 / 0"} {
+		e, err := ParseExpr(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Eval(field); err != ErrDivisionByZero {
+			t.Errorf("%s: expected a division by zero, got %v", expr, err)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, c := range []struct{ expr, want string }{
+		{"", "unexpected end"},
+		{"1 +", "unexpected end"},
+		{"(1 + 2", "expected )"},
+		{"1 + 2)", `unexpected ")"`},
+		{"1 2", `unexpected "2"`},
+		{"1 % 2", `unexpected "%"`},
+		{"* 2", `unexpected "*"`},
+		{"1..2", `invalid number "1..2"`},
+		{"Scoreboard", "expected a state of Scoreboard"},
+		{"Scoreboard.Z", `unknown scoreboard state "Z"`},
+		{"Uptime.K", `only Scoreboard has states, got "Uptime.K"`},
+		{"BusyWorkers - ", "at 15: unexpected end"},
+	} {
+		if _, err := ParseExpr(c.expr); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: expected an error containing %q, got %v", c.expr, c.want, err)
+		}
+	}
+}
+
+func TestDerivedMetrics(t *testing.T) {
+	cfg, err := Load([]byte(`
+targets:
+  - uri: http://web01/server-status?auto
+derived_metrics:
+  apache_effective_busy: BusyWorkers - Scoreboard.K
+  apache_bytes_per_access: TotalKBytes*1024/TotalAccesses
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.DerivedMetrics) != 2 || cfg.DerivedMetrics["apache_effective_busy"].String() != "BusyWorkers - Scoreboard.K" {
+		t.Fatalf("unexpected derived metrics %v", cfg.DerivedMetrics)
+	}
+	if s := cfg.String(); !strings.Contains(s, "apache_bytes_per_access: TotalKBytes*1024/TotalAccesses") {
+		t.Errorf("expected the expression printed as given, got:\n%s", s)
+	}
+
+	for _, c := range []struct{ metrics, want string }{
+		{`{apache_busy: "BusyWorkers +"}`, "unexpected end"},
+		{`{"apache-busy": BusyWorkers}`, `derived metric "apache-busy": invalid metric name`},
+		{`{apache_busy: }`, `derived metric "apache_busy": no expression`},
+		{`{apache_busy: [BusyWorkers]}`, "cannot unmarshal"},
+	} {
+		_, err := Load([]byte("targets: [{uri: http://web01/}]\nderived_metrics: " + c.metrics))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", c.metrics, c.want, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// httpSDGroup is a group of targets in the Prometheus http_sd format.
+type httpSDGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// LoadHTTPSD parses and validates a JSON list of target groups in the
+// Prometheus http_sd format. Of the labels starting with __, __scheme__
+// and __metrics_path__ complete the URIs of the group's targets, and the
+// others are dropped as Prometheus drops them. A URI listed again is
+// left out.
+func LoadHTTPSD(data []byte) ([]Target, error) {
+	var groups []httpSDGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, err
+	}
+	targets := []Target{}
+	listed := map[string]bool{}
+	for i, g := range groups {
+		labels := map[string]string{}
+		for name, value := range g.Labels {
+			if !strings.HasPrefix(name, "__") {
+				labels[name] = value
+			}
+		}
+		for _, host := range g.Targets {
+			if strings.TrimSpace(host) == "" {
+				return nil, fmt.Errorf("group %d: empty target", i+1)
+			}
+			uri := host
+			if scheme := g.Labels["__scheme__"]; scheme != "" {
+				uri = scheme + "://" + uri
+			}
+			if path := g.Labels["__metrics_path__"]; path != "" {
+				uri += "/" + strings.TrimPrefix(path, "/")
+			}
+			if listed[uri] {
+				continue
+			}
+			listed[uri] = true
+			targets = append(targets, Target{URI: uri, Labels: labels})
+		}
+	}
+	if err := validateTargets(targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
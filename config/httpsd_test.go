@@ -0,0 +1,40 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadHTTPSD(t *testing.T) {
+	targets, err := LoadHTTPSD([]byte(`[
+		{"targets": ["web01:80", "web02:80"], "labels": {"env": "prod", "__meta_cmdb_id": "42"}},
+		{"targets": ["web03:8443", "web01:80"], "labels": {"__scheme__": "https", "__metrics_path__": "status"}},
+		{"targets": ["web01:80"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{
+		{URI: "web01:80", Labels: map[string]string{"env": "prod"}},
+		{URI: "web02:80", Labels: map[string]string{"env": "prod"}},
+		{URI: "https://web03:8443/status", Labels: map[string]string{}},
+		{URI: "https://web01:80/status", Labels: map[string]string{}},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("expected %v, got %v", want, targets)
+	}
+
+	if targets, err := LoadHTTPSD([]byte(`[]`)); err != nil || len(targets) != 0 {
+		t.Errorf("expected an empty list to be fine, got %v, %v", targets, err)
+	}
+	for _, doc := range []string{
+		`{"targets": ["web01:80"]}`,
+		`[{"targets": [""]}]`,
+		`[{"targets": ["web01:80"], "labels": {"target": "x"}}]`,
+		`[{"targets": ["web01:80"], "labels": {"bad-name": "x"}}]`,
+	} {
+		if _, err := LoadHTTPSD([]byte(doc)); err == nil {
+			t.Errorf("expected an error for %s", doc)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MetricRule drops, keeps or relabels series of the apache metrics before
+// they are exposed, for those who can't change Prometheus's
+// metric_relabel_configs. Rules apply in order, each to the families whose
+// names match Name.
+type MetricRule struct {
+	// Action is drop, to drop the series matching Labels, keep, to drop
+	// those that don't, or rename_label, to rename the From label of the
+	// series matching Labels to To.
+	Action string `yaml:"action"`
+	// Name matches the names of the families the rule applies to, all if
+	// unset.
+	Name *Regexp `yaml:"name,omitempty"`
+	// Labels match the values of labels of series, all of them if unset.
+	// A label a series doesn't have has the empty value.
+	Labels map[string]*Regexp `yaml:"labels,omitempty"`
+	From   string             `yaml:"from,omitempty"`
+	To     string             `yaml:"to,omitempty"`
+}
+
+// Regexp is a regular expression matching whole strings.
+type Regexp struct {
+	*regexp.Regexp
+	expr string
+}
+
+// NewRegexp compiles expr, anchored at both ends.
+func NewRegexp(expr string) (*Regexp, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &Regexp{re, expr}, nil
+}
+
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var expr string
+	if err := unmarshal(&expr); err != nil {
+		return err
+	}
+	compiled, err := NewRegexp(expr)
+	if err != nil {
+		return err
+	}
+	*re = *compiled
+	return nil
+}
+
+func (re *Regexp) MarshalYAML() (interface{}, error) {
+	return re.expr, nil
+}
+
+// AppliesTo tells whether the rule applies to the family name.
+func (r *MetricRule) AppliesTo(name string) bool {
+	return r.Name == nil || r.Name.MatchString(name)
+}
+
+// MatchesLabels tells whether a series with labels matches the rule's.
+func (r *MetricRule) MatchesLabels(labels map[string]string) bool {
+	for label, re := range r.Labels {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *MetricRule) validate() error {
+	for label := range r.Labels {
+		if !labelNameRE.MatchString(label) {
+			return fmt.Errorf("invalid label name %q", label)
+		}
+	}
+	switch r.Action {
+	case "drop", "keep":
+		if r.Action == "drop" && r.Name == nil && len(r.Labels) == 0 {
+			return fmt.Errorf("drop needs name or labels")
+		}
+		if r.Action == "keep" && len(r.Labels) == 0 {
+			return fmt.Errorf("keep needs labels")
+		}
+		if r.From != "" || r.To != "" {
+			return fmt.Errorf("from and to only go with rename_label")
+		}
+	case "rename_label":
+		for _, label := range []string{r.From, r.To} {
+			if !labelNameRE.MatchString(label) || strings.HasPrefix(label, "__") {
+				return fmt.Errorf("rename_label needs from and to, valid label names, got %q", label)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown action %q, valid are drop, keep and rename_label", r.Action)
+	}
+	return nil
+}
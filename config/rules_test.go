@@ -0,0 +1,52 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetricRules(t *testing.T) {
+	cfg, err := Load([]byte(`
+targets:
+  - uri: http://web01/server-status?auto
+metric_rules:
+  - action: drop
+    name: apache_exporter_scrape_.*
+  - action: keep
+    labels: {vhost: "www\\..*|"}
+  - action: rename_label
+    from: vhost
+    to: site
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.MetricRules) != 3 {
+		t.Fatalf("expected 3 rules, got %v", cfg.MetricRules)
+	}
+	drop, keep := cfg.MetricRules[0], cfg.MetricRules[1]
+	if !drop.AppliesTo("apache_exporter_scrape_failures_total") || drop.AppliesTo("apache_up") || drop.AppliesTo("x_apache_exporter_scrape_") || !keep.AppliesTo("apache_up") {
+		t.Error("expected the name regex to match whole names, and all without one")
+	}
+	if !keep.MatchesLabels(map[string]string{"vhost": "www.example.com"}) || !keep.MatchesLabels(nil) || keep.MatchesLabels(map[string]string{"vhost": "test.example.com"}) || !drop.MatchesLabels(nil) {
+		t.Error("expected the label regex to match whole values, the missing label as empty")
+	}
+	if s := cfg.String(); !strings.Contains(s, "name: apache_exporter_scrape_.*") {
+		t.Errorf("expected the regex printed as given, got:\n%s", s)
+	}
+
+	for _, c := range []struct{ rules, want string }{
+		{`[{action: replace, name: x}]`, `metric rule 1: unknown action "replace"`},
+		{`[{action: drop}]`, "metric rule 1: drop needs name or labels"},
+		{`[{action: keep, name: x}]`, "metric rule 1: keep needs labels"},
+		{`[{action: drop, name: x, from: a}]`, "metric rule 1: from and to only go with rename_label"},
+		{`[{action: keep, labels: {"1x": y}}]`, `metric rule 1: invalid label name "1x"`},
+		{`[{action: drop, name: x}, {action: rename_label, from: vhost}]`, `metric rule 2: rename_label needs from and to, valid label names, got ""`},
+		{`[{action: drop, name: "a("}]`, "error parsing regexp"},
+	} {
+		_, err := Load([]byte("targets: [{uri: http://web01/}]\nmetric_rules: " + c.rules))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", c.rules, c.want, err)
+		}
+	}
+}
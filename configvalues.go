@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configValuesFile = flag.String("collector.config.file", "", "Main configuration file of the apache running on the same host, such as /etc/apache2/apache2.conf, the config group of apache metrics is read from. Followed through Include and IncludeOptional, and parsed again when any of the files changes.")
+	configDirectives = &targetsFlag{values: []string{"MaxRequestWorkers", "ServerLimit", "ThreadsPerChild", "KeepAliveTimeout", "Timeout"}}
+)
+
+func init() {
+	flag.Var(configDirectives, "collector.config.directives", "Directive of the main server exported by the config group of apache metrics, if set to a number. May be repeated or comma separated.")
+}
+
+func validateConfigValues() error {
+	if collectorEnabled["config"] && *configValuesFile == "" {
+		return errors.New("-collector.config needs -collector.config.file")
+	}
+	return nil
+}
+
+func newConfigValueDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("config_value", "Value of the directive in the apache configuration, as the main server has it. Durations are in seconds.", []string{"directive"}, labels)
+}
+
+// apacheDirectiveAliases are the names directives went by in apache 2.2,
+// by their lower case names since.
+var apacheDirectiveAliases = map[string]string{
+	"maxrequestworkers":      "maxclients",
+	"maxconnectionsperchild": "maxrequestsperchild",
+}
+
+// apacheConfigValues keeps the directives parsed from a configuration,
+// parsing it again only once its files change.
+type apacheConfigValues struct {
+	root   string // As with apacheConfigParser.
+	mutex  sync.Mutex
+	file   string
+	mtimes map[string]time.Time
+	values map[string]string
+}
+
+// configValues are those of -collector.config.file, shared by the targets.
+var configValues = &apacheConfigValues{}
+
+// get returns the directives of the main server of the configuration file,
+// by their lower case names.
+func (c *apacheConfigValues) get(file string) (map[string]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if file == c.file && !c.changed() {
+		return c.values, nil
+	}
+	p := &apacheConfigParser{root: c.root}
+	if err := p.parse(file); err != nil {
+		return nil, err
+	}
+	c.file, c.mtimes, c.values = file, p.mtimes, p.values
+	logger.Debug("Parsed the apache configuration", "file", file, "files", len(p.mtimes), "mpm", p.mpm)
+	return c.values, nil
+}
+
+// changed tells whether any file or directory parsed was modified since.
+func (c *apacheConfigValues) changed() bool {
+	if c.mtimes == nil {
+		return true
+	}
+	for path, t := range c.mtimes {
+		if !modTime(path).Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDirectiveValue returns the number the arguments of a directive are,
+// with durations given in milliseconds, as KeepAliveTimeout allows, in
+// seconds and On and Off as 1 and 0.
+func parseDirectiveValue(args string) (float64, bool) {
+	switch strings.ToLower(args) {
+	case "on":
+		return 1, true
+	case "off":
+		return 0, true
+	}
+	scale := 1.0
+	if strings.HasSuffix(args, "ms") {
+		args, scale = strings.TrimSuffix(args, "ms"), 1e-3
+	}
+	v, err := strconv.ParseFloat(args, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v * scale, true
+}
+
+func (e *Exporter) collectConfigValues(_ context.Context, ch chan<- prometheus.Metric) error {
+	values, err := configValues.get(*configValuesFile)
+	if err != nil {
+		return err
+	}
+	for _, directive := range configDirectives.values {
+		name := strings.ToLower(directive)
+		args, ok := values[name]
+		if !ok {
+			args, ok = values[apacheDirectiveAliases[name]]
+		}
+		if !ok {
+			continue
+		}
+		v, ok := parseDirectiveValue(args)
+		if !ok {
+			e.logger.Debug("Not exporting a directive of the apache configuration that isn't a number", "directive", directive, "value", args)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(e.configValueDesc, prometheus.GaugeValue, v, directive)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValuesLayouts(t *testing.T) {
+	for _, tc := range []struct {
+		layout, file string
+		want         map[string]string
+	}{
+		// The event MPM, with its settings in mods-enabled/mpm_event.conf.
+		{"debian", "/etc/apache2/apache2.conf", map[string]string{
+			"maxrequestworkers": "150",
+			"threadsperchild":   "25",
+			"keepalivetimeout":  "5",
+			"timeout":           "300",
+		}},
+		// The prefork MPM, with the sections of both in conf.d/mpm.conf.
+		{"rhel", "/etc/httpd/conf/httpd.conf", map[string]string{
+			"maxrequestworkers": "256",
+			"serverlimit":       "256",
+			"keepalivetimeout":  "1500ms",
+		}},
+	} {
+		c := &apacheConfigValues{root: filepath.Join("testdata", "apacheconf", tc.layout)}
+		values, err := c.get(tc.file)
+		if err != nil {
+			t.Errorf("%s: %v", tc.layout, err)
+			continue
+		}
+		for _, d := range []string{"maxrequestworkers", "serverlimit", "threadsperchild", "keepalivetimeout", "timeout"} {
+			if got, want := values[d], tc.want[d]; got != want {
+				t.Errorf("%s: expected %s %q, got %q", tc.layout, d, want, got)
+			}
+		}
+	}
+}
+
+func TestParseDirectiveValue(t *testing.T) {
+	for args, want := range map[string]float64{
+		"150":    150,
+		"1500ms": 1.5,
+		"On":     1,
+		"off":    0,
+	} {
+		if got, ok := parseDirectiveValue(args); !ok || got != want {
+			t.Errorf("%s: expected %v, got %v, %v", args, want, got, ok)
+		}
+	}
+	for _, args := range []string{"", "localhost", "5 ms", "ms"} {
+		if got, ok := parseDirectiveValue(args); ok {
+			t.Errorf("%s: expected no number, got %v", args, got)
+		}
+	}
+}
+
+// TestConfigValuesChanges checks that the configuration is only parsed
+// again once a file it consists of changes.
+func TestConfigValuesChanges(t *testing.T) {
+	dir := writeApacheConfig(t, map[string]string{
+		"httpd.conf":    "Timeout 60\nIncludeOptional conf.d/*.conf\n",
+		"conf.d/a.conf": "ServerLimit 16\n",
+	})
+	file := filepath.Join(dir, "httpd.conf")
+	c := &apacheConfigValues{}
+	values, err := c.get(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["timeout"] != "60" || values["serverlimit"] != "16" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	values["timeout"] = "parsed"
+	if again, _ := c.get(file); again["timeout"] != "parsed" {
+		t.Errorf("expected the configuration not to be parsed again, got %v", again)
+	}
+
+	// Modification times don't always move on between writes.
+	later := time.Now().Add(time.Minute)
+	for _, tc := range []struct {
+		name, path, content string
+		want                map[string]string
+	}{
+		{"included file", "conf.d/a.conf", "ServerLimit 32\n", map[string]string{"timeout": "60", "serverlimit": "32"}},
+		{"file added", "conf.d/b.conf", "Timeout 90\n", map[string]string{"timeout": "90", "serverlimit": "32"}},
+		{"main file", "httpd.conf", "Timeout 30\n", map[string]string{"timeout": "30"}},
+	} {
+		path := filepath.Join(dir, tc.path)
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{path, filepath.Dir(path)} {
+			if err := os.Chtimes(p, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+		later = later.Add(time.Minute)
+		values, err := c.get(file)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		for _, d := range []string{"timeout", "serverlimit"} {
+			if got, want := values[d], tc.want[d]; got != want {
+				t.Errorf("%s: expected %s %q, got %q", tc.name, d, want, got)
+			}
+		}
+	}
+}
+
+// configValuesScrape returns what /metrics serves of a target with the
+// config group of apache metrics on.
+func configValuesScrape(t *testing.T) string {
+	t.Helper()
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"config": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+	rr := httptest.NewRecorder()
+	metricsHandler(newTargetSet(Exporters{NewExporter(server.URL)}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	return rr.Body.String()
+}
+
+func TestCollectConfigValues(t *testing.T) {
+	defer func(file string) { *configValuesFile = file }(*configValuesFile)
+	defer func(old []string) { configDirectives.values = old }(configDirectives.values)
+	defer func(old *apacheConfigValues) { configValues = old }(configValues)
+	configValues = &apacheConfigValues{root: filepath.Join("testdata", "apacheconf", "rhel")}
+	*configValuesFile = "/etc/httpd/conf/httpd.conf"
+	configDirectives.values = append(configDirectives.values, "MaxConnectionsPerChild", "ServerAdmin", "EnableSendfile")
+
+	body := configValuesScrape(t)
+	for _, want := range []string{
+		`apache_config_value{directive="MaxRequestWorkers"} 256`,
+		`apache_config_value{directive="ServerLimit"} 256`,
+		`apache_config_value{directive="KeepAliveTimeout"} 1.5`,
+		`apache_config_value{directive="MaxConnectionsPerChild"} 4000`,
+		`apache_config_value{directive="EnableSendfile"} 1`,
+		`apache_exporter_collector_success{collector="config"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in\n%s", want, body)
+		}
+	}
+	// Unset or not a number.
+	for _, directive := range []string{"ThreadsPerChild", "Timeout", "ServerAdmin"} {
+		if strings.Contains(body, `directive="`+directive+`"`) {
+			t.Errorf("expected no %s in\n%s", directive, body)
+		}
+	}
+
+	*configValuesFile = "/etc/missing.conf"
+	body = configValuesScrape(t)
+	if !strings.Contains(body, `apache_exporter_collector_success{collector="config"} 0`) || !strings.Contains(body, "apache_up 1") {
+		t.Errorf("expected the config group to fail without failing the scrape, got\n%s", body)
+	}
+}
+
+func TestApacheDirectiveAliases(t *testing.T) {
+	defer func(file string) { *configValuesFile = file }(*configValuesFile)
+	defer func(old *apacheConfigValues) { configValues = old }(configValues)
+	for config, want := range map[string]string{
+		"MaxClients 100\n":                        "100",
+		"MaxClients 100\nMaxRequestWorkers 200\n": "200",
+	} {
+		dir := writeApacheConfig(t, map[string]string{"httpd.conf": config})
+		configValues = &apacheConfigValues{}
+		*configValuesFile = filepath.Join(dir, "httpd.conf")
+		if body := configValuesScrape(t); !strings.Contains(body, `apache_config_value{directive="MaxRequestWorkers"} `+want+"\n") {
+			t.Errorf("%q: expected MaxRequestWorkers %s in\n%s", config, want, body)
+		}
+	}
+}
+
+func TestValidateConfigValues(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	defer func(file string) { *configValuesFile = file }(*configValuesFile)
+	collectorEnabled = map[string]bool{"config": true}
+	*configValuesFile = ""
+	if err := validateConfigValues(); err == nil {
+		t.Error("expected -collector.config to need -collector.config.file")
+	}
+	*configValuesFile = "/etc/apache2/apache2.conf"
+	if err := validateConfigValues(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsConstLabels are the labels every metric of the exporter carries,
+// as given by -metrics.const-label.
+var metricsConstLabels = constLabelsFlag{}
+
+func init() {
+	flag.Var(metricsConstLabels, "metrics.const-label", "Label to put on every metric, the exporter's own included, as name=value, such as region=eu-west. May be repeated.")
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// dynamicLabels are the labels metrics of the exporter get besides
+// those of targets, which constant labels would clash with.
+var dynamicLabels = map[string]bool{
+	"target":    true,
+	"group":     true,
+	"state":     true,
+	"reason":    true,
+	"reused":    true,
+	"phase":     true,
+	"collector": true,
+	"handler":   true,
+	"code":      true,
+	"method":    true,
+	"version":   true,
+	"revision":  true,
+	"builddate": true,
+	"goversion": true,
+}
+
+// constLabelsFlag maps the names of constant labels to their values.
+type constLabelsFlag map[string]string
+
+func (f constLabelsFlag) String() string {
+	var s []string
+	for name, value := range f {
+		s = append(s, name+"="+value)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (f constLabelsFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	name := kv[0]
+	_, given := f[name]
+	switch {
+	case !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__"):
+		return fmt.Errorf("invalid label name %q", name)
+	case dynamicLabels[name]:
+		return fmt.Errorf("label %s is set by the exporter", name)
+	case given:
+		return fmt.Errorf("label %s given twice", name)
+	}
+	f[name] = kv[1]
+	return nil
+}
+
+// withConstLabels returns labels along with the constant labels, nil if
+// there are neither.
+func withConstLabels(labels prometheus.Labels) prometheus.Labels {
+	if len(metricsConstLabels) == 0 {
+		return labels
+	}
+	merged := prometheus.Labels{}
+	for name, value := range metricsConstLabels {
+		merged[name] = value
+	}
+	for name, value := range labels {
+		merged[name] = value
+	}
+	return merged
+}
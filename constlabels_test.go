@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestConstLabels(t *testing.T) {
+	metricsConstLabels["region"] = "eu-west"
+	setNamespace("apache")
+	defer func() {
+		delete(metricsConstLabels, "region")
+		setNamespace("apache")
+	}()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	es, err := newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: ts.URL, Labels: map[string]string{"dc": "ams"}},
+		{Name: "web02", URI: ts.URL, Labels: map[string]string{"dc": "fra"}},
+	}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newTargetSet(es, nil))
+	panics.WithLabelValues("status")
+	mfs, err := prometheus.Gatherers{registry, reg}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), "go_") || strings.HasPrefix(mf.GetName(), "process_") {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			found := false
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "region" && lp.GetValue() == "eu-west" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s: no region label in %v", mf.GetName(), m.GetLabel())
+			}
+		}
+	}
+
+	_, err = newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: ts.URL, Labels: map[string]string{"region": "us-east"}},
+	}), testDefaults, false)
+	if err == nil || !strings.Contains(err.Error(), "label region is set by -metrics.const-label too") {
+		t.Errorf("got %v for a target label colliding with a constant one", err)
+	}
+}
+
+func TestConstLabelsFlag(t *testing.T) {
+	f := constLabelsFlag{}
+	for _, v := range []string{"region=eu-west", "env=prod", "empty="} {
+		if err := f.Set(v); err != nil {
+			t.Errorf("%s: %v", v, err)
+		}
+	}
+	if got, want := f.String(), "empty=,env=prod,region=eu-west"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, v := range []string{"region", "1st=x", "__name=x", "a-b=x", "target=x", "group=x", "region=us-east", "empty=x"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("%s: no error", v)
+		}
+	}
+}
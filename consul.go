@@ -0,0 +1,281 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	consulServer     = flag.String("discovery.consul.server", "", "Address of the Consul agent to discover targets from, such as localhost:8500. Disabled if empty.")
+	consulTokenFile  = flag.String("discovery.consul.token-file", "", "File containing the Consul ACL token.")
+	consulDatacenter = flag.String("discovery.consul.datacenter", "", "Consul datacenter to query (default the agent's).")
+	consulService    = flag.String("discovery.consul.service", "apache", "Consul service whose healthy instances are scraped.")
+	consulTag        = flag.String("discovery.consul.tag", "", "Only scrape instances of the Consul service with this tag.")
+)
+
+func init() {
+	registerDiscovery(discoveryMechanism{
+		name:    "consul",
+		enabled: func() bool { return *consulServer != "" },
+		setup: func(newContext func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error) {
+			consul := newConsulDiscovery(*consulServer, *consulService, *consulTag, *consulDatacenter, *consulTokenFile)
+			ctx, cancel := newContext()
+			if _, err := consul.refresh(ctx, 0); err != nil {
+				logger.Error("Error querying Consul", "err", err)
+			}
+			cancel()
+			registry.MustRegister(consul)
+			return consul, func(changed func()) {
+				consul.run(*refreshInterval, changed, done)
+			}, nil
+		},
+	})
+}
+
+// statusPathTag is the tag prefix instances use to announce where their
+// status page is, as in "status-path=/server-status".
+const statusPathTag = "status-path="
+
+// consulEntry is an element of the response of Consul's health endpoint.
+type consulEntry struct {
+	Node struct {
+		Node       string
+		Address    string
+		Datacenter string
+	}
+	Service struct {
+		ID      string
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+	}
+}
+
+// consulDiscovery finds targets among the healthy instances of a Consul
+// service, keeping the last known instances whenever Consul can't be
+// reached.
+type consulDiscovery struct {
+	server     string
+	service    string
+	tag        string
+	datacenter string
+	tokenFile  string
+	client     *http.Client
+
+	mutex   sync.Mutex
+	index   uint64
+	current []target
+
+	discovered  prometheus.Gauge
+	lastRefresh prometheus.Gauge
+	failures    prometheus.Counter
+}
+
+func newConsulDiscovery(server, service, tag, datacenter, tokenFile string) *consulDiscovery {
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	return &consulDiscovery{
+		server:     strings.TrimSuffix(server, "/"),
+		service:    service,
+		tag:        tag,
+		datacenter: datacenter,
+		tokenFile:  tokenFile,
+		client:     &http.Client{},
+		discovered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_consul_targets",
+			Help:        "Number of targets discovered through Consul.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_consul_last_refresh_success_timestamp_seconds",
+			Help:        "Timestamp of the last successful query of Consul.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_consul_failures_total",
+			Help:        "Number of failed queries of Consul.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+}
+
+// refresh queries Consul for the service's healthy instances, reporting
+// whether the discovered targets changed. A non-zero wait makes it a
+// blocking query returning once the instances change or wait is over.
+func (d *consulDiscovery) refresh(ctx context.Context, wait time.Duration) (bool, error) {
+	d.mutex.Lock()
+	index := d.index
+	d.mutex.Unlock()
+
+	entries, index, err := d.query(ctx, index, wait)
+	if err != nil {
+		d.failures.Inc()
+		return false, err
+	}
+	targets := consulTargets(entries)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.index = index
+	d.lastRefresh.Set(float64(time.Now().Unix()))
+	d.discovered.Set(float64(len(targets)))
+	if reflect.DeepEqual(targets, d.current) {
+		return false, nil
+	}
+	d.current = targets
+	return true, nil
+}
+
+func (d *consulDiscovery) query(ctx context.Context, index uint64, wait time.Duration) ([]consulEntry, uint64, error) {
+	params := url.Values{"passing": {"1"}}
+	if d.tag != "" {
+		params.Set("tag", d.tag)
+	}
+	if d.datacenter != "" {
+		params.Set("dc", d.datacenter)
+	}
+	if wait > 0 && index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", fmt.Sprintf("%ds", int(wait.Seconds())))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", d.server+"/v1/health/service/"+url.PathEscape(d.service)+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.tokenFile != "" {
+		token, err := config.ReadFile(d.tokenFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("X-Consul-Token", strings.TrimSpace(string(token)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, 0, fmt.Errorf("Consul returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("decoding Consul response: %v", err)
+	}
+
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	// A lower index means Consul's state was reset, start over.
+	if newIndex < index {
+		newIndex = 0
+	}
+	return entries, newIndex, nil
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// consulTargets turns the instances into targets, labeled with their node,
+// datacenter and service metadata.
+func consulTargets(entries []consulEntry) []target {
+	var targets []target
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		uri := net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+		for _, tag := range e.Service.Tags {
+			if strings.HasPrefix(tag, statusPathTag) {
+				uri += "/" + strings.TrimPrefix(strings.TrimPrefix(tag, statusPathTag), "/")
+			}
+		}
+
+		labels := map[string]string{
+			"consul_node":       e.Node.Node,
+			"consul_datacenter": e.Node.Datacenter,
+			"consul_service_id": e.Service.ID,
+		}
+		for k, v := range e.Service.Meta {
+			labels["consul_service_meta_"+invalidLabelChars.ReplaceAllString(k, "_")] = v
+		}
+		targets = append(targets, target{uri: uri, conf: config.Target{Labels: labels}})
+	}
+	return targets
+}
+
+func (d *consulDiscovery) targets() []target {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.current
+}
+
+// run keeps d up to date with blocking queries until done is closed,
+// calling changed when the targets changed. Failed queries are retried
+// every interval.
+func (d *consulDiscovery) run(interval time.Duration, changed func(), done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+	for ctx.Err() == nil {
+		// Consul adds up to wait/16 of jitter to blocking queries.
+		qctx, qcancel := context.WithTimeout(ctx, interval+interval/16+10*time.Second)
+		ok, err := d.refresh(qctx, interval)
+		qcancel()
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Error querying Consul, keeping the previous targets", "err", err)
+		}
+		if ok {
+			changed()
+		}
+
+		d.mutex.Lock()
+		index := d.index
+		d.mutex.Unlock()
+		// Without an index to block on, fall back to polling.
+		if err != nil || index == 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+func (d *consulDiscovery) Describe(ch chan<- *prometheus.Desc) {
+	d.discovered.Describe(ch)
+	d.lastRefresh.Describe(ch)
+	d.failures.Describe(ch)
+}
+
+func (d *consulDiscovery) Collect(ch chan<- prometheus.Metric) {
+	d.discovered.Collect(ch)
+	d.lastRefresh.Collect(ch)
+	d.failures.Collect(ch)
+}
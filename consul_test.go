@@ -0,0 +1,170 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul serves the health endpoint of a Consul agent, holding
+// blocking queries until the instances change.
+type fakeConsul struct {
+	t       *testing.T
+	mutex   sync.Mutex
+	index   uint64
+	entries []consulEntry
+	changed chan struct{}
+	down    bool
+}
+
+func newFakeConsul(t *testing.T) *fakeConsul {
+	return &fakeConsul{t: t, index: 1, changed: make(chan struct{})}
+}
+
+func (c *fakeConsul) set(entries ...consulEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = entries
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.URL.Path != "/v1/health/service/apache" || q.Get("passing") != "1" || q.Get("tag") != "prod" || q.Get("dc") != "ams" {
+		c.t.Errorf("unexpected Consul query %s", r.URL)
+	}
+	if token := r.Header.Get("X-Consul-Token"); token != "s3cret" {
+		c.t.Errorf("expected ACL token s3cret, got %q", token)
+	}
+
+	c.mutex.Lock()
+	if c.down {
+		c.mutex.Unlock()
+		http.Error(w, "No cluster leader", http.StatusInternalServerError)
+		return
+	}
+	changed := c.changed
+	if q.Get("index") == "" || q.Get("index") != formatIndex(c.index) {
+		changed = nil
+	}
+	c.mutex.Unlock()
+	if changed != nil {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	w.Header().Set("X-Consul-Index", formatIndex(c.index))
+	json.NewEncoder(w).Encode(c.entries)
+}
+
+func formatIndex(i uint64) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+func consulInstance(node, id, address string, port int, tags ...string) consulEntry {
+	var e consulEntry
+	e.Node.Node = node
+	e.Node.Address = "10.0.0.1"
+	e.Node.Datacenter = "ams"
+	e.Service.ID = id
+	e.Service.Address = address
+	e.Service.Port = port
+	e.Service.Tags = tags
+	e.Service.Meta = map[string]string{"version": "2.4"}
+	return e
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	fake := newFakeConsul(t)
+	fake.set(
+		consulInstance("node1", "apache-1", "", 80, "prod", "status-path=/status"),
+		consulInstance("node2", "apache-2", "10.0.0.2", 8080, "prod"),
+	)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newConsulDiscovery(server.Listener.Addr().String(), "apache", "prod", "ams", tokenFile)
+	if ok, err := d.refresh(context.Background(), 0); err != nil || !ok {
+		t.Fatalf("expected the first refresh to find targets, got %t, %v", ok, err)
+	}
+	targets := d.targets()
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %+v", targets)
+	}
+	if targets[0].uri != "10.0.0.1:80/status" || targets[1].uri != "10.0.0.2:8080" {
+		t.Errorf("unexpected target URIs %s and %s", targets[0].uri, targets[1].uri)
+	}
+	want := map[string]string{
+		"consul_node":                 "node1",
+		"consul_datacenter":           "ams",
+		"consul_service_id":           "apache-1",
+		"consul_service_meta_version": "2.4",
+	}
+	for name, value := range want {
+		if got := targets[0].conf.Labels[name]; got != value {
+			t.Errorf("expected label %s=%q, got %q", name, value, got)
+		}
+	}
+	if v := gaugeValue(t, d.discovered); v != 2 {
+		t.Errorf("expected 2 discovered targets, got %v", v)
+	}
+
+	// The blocking query returns as soon as the instances change.
+	changes := make(chan struct{}, 10)
+	done := make(chan struct{})
+	defer close(done)
+	go d.run(time.Second, func() { changes <- struct{}{} }, done)
+	time.Sleep(50 * time.Millisecond)
+	fake.set(consulInstance("node2", "apache-2", "10.0.0.2", 8080, "prod"))
+	select {
+	case <-changes:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("change in Consul not noticed")
+	}
+	if targets := d.targets(); len(targets) != 1 || targets[0].uri != "10.0.0.2:8080" {
+		t.Errorf("expected only node2 to be left, got %+v", targets)
+	}
+
+	// An outage keeps the last known instances.
+	fake.mutex.Lock()
+	fake.down = true
+	fake.mutex.Unlock()
+	if _, err := d.refresh(context.Background(), 0); err == nil {
+		t.Error("expected refresh to fail while Consul is down")
+	}
+	if v := counterValue(t, d.failures); v == 0 {
+		t.Error("expected the failed query to be counted")
+	}
+	if len(d.targets()) != 1 {
+		t.Errorf("expected the last known targets to be kept, got %+v", d.targets())
+	}
+}
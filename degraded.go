@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	degradedEnterRatio = flag.Float64("scrape.degraded.enter-ratio", 0, "Share of busy workers, of -collector.workers.limit if set, of the busy and idle ones otherwise, from which a target is scraped for its status page only, without the collectors fetching more pages of apache such as -collector.processes, until it is back under -scrape.degraded.exit-ratio. A 503 for the status page enters it too. 0 always scrapes everything.")
+	degradedExitRatio  = flag.Float64("scrape.degraded.exit-ratio", 0.8, "Share of busy workers under which a target scraped for its status page only, because of -scrape.degraded.enter-ratio, is scraped for everything again.")
+)
+
+func validateDegradedRatios(enter, exit float64) error {
+	if enter == 0 {
+		return nil
+	}
+	if enter < 0 || enter > 1 || exit < 0 || exit >= enter {
+		return fmt.Errorf("invalid -scrape.degraded.enter-ratio %v and -scrape.degraded.exit-ratio %v: expected 0 <= exit < enter <= 1", enter, exit)
+	}
+	return nil
+}
+
+// newDegradedModeDesc describes apache_exporter_degraded_mode.
+func newDegradedModeDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("exporter_degraded_mode", "Whether the target is overloaded, per -scrape.degraded.enter-ratio, and scraped for its status page only.", nil, labels)
+}
+
+// degradedMode is whether a target is overloaded, for
+// -scrape.degraded.enter-ratio. It is kept across reloads.
+type degradedMode struct {
+	mutex  sync.Mutex
+	active bool
+}
+
+// busyRatio returns the share of busy workers of values, if it shows them.
+func busyRatio(values map[string]float64) (float64, bool) {
+	busy, hasBusy := values["BusyWorkers"]
+	idle, hasIdle := values["IdleWorkers"]
+	switch {
+	case hasBusy && *workersLimit > 0:
+		return busy / float64(*workersLimit), true
+	case hasBusy && hasIdle && busy+idle > 0:
+		return busy / (busy + idle), true
+	}
+	return 0, false
+}
+
+// observe sets the mode after the status page showed ratio, entering it
+// from -scrape.degraded.enter-ratio and leaving it under
+// -scrape.degraded.exit-ratio, so that a target around one of them
+// doesn't flap.
+func (d *degradedMode) observe(logger *slog.Logger, ratio float64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	switch {
+	case !d.active && ratio >= *degradedEnterRatio:
+		d.active = true
+		logger.Warn("Apache is overloaded, scraping its status page only", "busy_ratio", ratio)
+	case d.active && ratio < *degradedExitRatio:
+		d.active = false
+		logger.Info("Apache is no longer overloaded, scraping everything again", "busy_ratio", ratio)
+	}
+}
+
+// overloaded enters the mode, for a 503 of the status page.
+func (d *degradedMode) overloaded(logger *slog.Logger) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.active {
+		d.active = true
+		logger.Warn("Apache answered 503, scraping its status page only")
+	}
+}
+
+func (d *degradedMode) isActive() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.active
+}
+
+// collect sends apache_exporter_degraded_mode to ch, with
+// -scrape.degraded.enter-ratio.
+func (d *degradedMode) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	if *degradedEnterRatio == 0 {
+		return
+	}
+	active := 0.0
+	if d.isActive() {
+		active = 1
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, active)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDegradedMode(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"workers": true, "heartbeat": true, "processes": true}
+	defer func(enter, exit float64) { *degradedEnterRatio, *degradedExitRatio = enter, exit }(*degradedEnterRatio, *degradedExitRatio)
+	*degradedEnterRatio, *degradedExitRatio = 0.9, 0.6
+	// Every page is asked for, whatever it had last time.
+	defer func(old time.Duration) { *capabilitiesRecheck = old }(*capabilitiesRecheck)
+	*capabilitiesRecheck = 0
+
+	var mutex sync.Mutex
+	var requests []string
+	busy, status := 0, http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		page := r.URL.Path
+		if r.URL.RawQuery == "auto" {
+			page = "auto"
+		}
+		requests = append(requests, page)
+		switch {
+		case page != "auto":
+			http.NotFound(w, r)
+		case status != http.StatusOK:
+			http.Error(w, "overloaded", status)
+		default:
+			fmt.Fprintf(w, "BusyWorkers: %d\nIdleWorkers: %d\n", busy, 100-busy)
+		}
+	}))
+	defer server.Close()
+	es := Exporters{NewExporter(server.URL + "/server-status?auto")}
+
+	for _, c := range []struct {
+		busy, status int
+		degraded     float64
+		requests     []string
+	}{
+		{50, 200, 0, []string{"auto", "/hb.dat", "/server-status"}},
+		// Over the entry ratio, the pages of the collectors are left out.
+		{95, 200, 1, []string{"auto"}},
+		// Under it but not under the exit ratio yet.
+		{70, 200, 1, []string{"auto"}},
+		{59, 200, 0, []string{"auto", "/hb.dat", "/server-status"}},
+		{70, 200, 0, []string{"auto", "/hb.dat", "/server-status"}},
+		{70, http.StatusServiceUnavailable, 1, []string{"auto"}},
+		{70, 200, 1, []string{"auto"}},
+		{10, 200, 0, []string{"auto", "/hb.dat", "/server-status"}},
+	} {
+		mutex.Lock()
+		busy, status, requests = c.busy, c.status, nil
+		mutex.Unlock()
+		values := scrapeValues(t, es)
+		if got := values["apache_exporter_degraded_mode"]; got != c.degraded {
+			t.Errorf("%d busy, %d: expected apache_exporter_degraded_mode %v, got %v", c.busy, c.status, c.degraded, got)
+		}
+		mutex.Lock()
+		got := map[string]bool{}
+		for _, page := range requests {
+			got[page] = true
+		}
+		if len(got) != len(c.requests) || len(requests) != len(c.requests) {
+			t.Errorf("%d busy, %d: expected requests for %v, got %v", c.busy, c.status, c.requests, requests)
+		}
+		for _, page := range c.requests {
+			if !got[page] {
+				t.Errorf("%d busy, %d: expected a request for %s, got %v", c.busy, c.status, page, requests)
+			}
+		}
+		mutex.Unlock()
+	}
+}
+
+func TestDegradedModeOff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("BusyWorkers: 100\nIdleWorkers: 0\n"))
+	}))
+	defer server.Close()
+	if _, ok := scrapeValues(t, Exporters{NewExporter(server.URL)})["apache_exporter_degraded_mode"]; ok {
+		t.Error("unexpected apache_exporter_degraded_mode without -scrape.degraded.enter-ratio")
+	}
+}
+
+func TestValidateDegradedRatios(t *testing.T) {
+	for _, c := range []struct {
+		enter, exit float64
+		ok          bool
+	}{
+		{0, 0.8, true},
+		{0.9, 0.8, true},
+		{1, 0, true},
+		{0.8, 0.8, false},
+		{0.8, 0.9, false},
+		{1.5, 0.8, false},
+		{-0.5, 0.8, false},
+		{0.9, -0.1, false},
+	} {
+		if err := validateDegradedRatios(c.enter, c.exit); (err == nil) != c.ok {
+			t.Errorf("%v, %v: expected ok %v, got %v", c.enter, c.exit, c.ok, err)
+		}
+	}
+}
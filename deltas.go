@@ -0,0 +1,167 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// deltaMetrics are the counters the outputs send the increase of since
+// their previous send of instead of the value.
+var deltaMetrics = &targetsFlag{}
+
+// outputDeltas are the deltas of the outputs running, by output, for the
+// state file.
+var (
+	outputDeltasMutex sync.Mutex
+	outputDeltas      = map[string]*deltas{}
+)
+
+func init() {
+	flag.Var(deltaMetrics, "output.delta-metrics", "Counter, such as apache_accesses_total, that -push.gateway-url, -influx.url, -otlp.endpoint and -graphite.address get the increase of since their previous send instead of its value, as a gauge, for backends that want deltas. May be repeated or comma separated. /metrics is left alone.")
+	stateDeltas = func() map[string]map[string]float64 {
+		outputDeltasMutex.Lock()
+		defer outputDeltasMutex.Unlock()
+		saved := map[string]map[string]float64{}
+		for output, d := range outputDeltas {
+			saved[output] = d.lastValues()
+		}
+		return saved
+	}
+}
+
+// deltas turns the counters of families into the increase since the
+// previous time. A counter that went down, as apache restarted, gets an
+// increase of 0 and a 1 in the reset marker. Each output has deltas of
+// its own, as what was sent before differs between them.
+type deltas struct {
+	families map[string]bool
+	mutex    sync.Mutex
+	last     map[string]float64 // Of the series at the previous time.
+}
+
+// newDeltas returns the deltas of families, nil if none.
+func newDeltas(families []string) *deltas {
+	if len(families) == 0 {
+		return nil
+	}
+	d := &deltas{families: map[string]bool{}}
+	for _, f := range families {
+		d.families[f] = true
+	}
+	return d
+}
+
+// deltasFromFlags returns the deltas -output.delta-metrics asks of output,
+// nil if none, starting from the values the state file restored for it.
+func deltasFromFlags(output string) *deltas {
+	d := newDeltas(deltaMetrics.values)
+	if d == nil {
+		return nil
+	}
+	if restoredState != nil {
+		d.last = restoredState.Deltas[output]
+	}
+	outputDeltasMutex.Lock()
+	defer outputDeltasMutex.Unlock()
+	outputDeltas[output] = d
+	return d
+}
+
+func (d *deltas) lastValues() map[string]float64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.last // Replaced, never changed, by apply.
+}
+
+// apply replaces the counters of d's families in mfs, which it takes
+// ownership of, with their increase. The series with no earlier value to
+// tell the increase from are left out.
+func (d *deltas) apply(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	if d == nil {
+		return mfs
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	last := map[string]float64{}
+	resets := &dto.MetricFamily{
+		Name: proto.String(namespace + "_exporter_counter_reset"),
+		Help: proto.String("1 for the series of -output.delta-metrics that went down since the previous send, their increase being sent as 0."),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if !d.families[mf.GetName()] || mf.GetType() != dto.MetricType_COUNTER {
+			kept = append(kept, mf)
+			continue
+		}
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			key := seriesKey(mf.GetName(), m.Label)
+			value := m.GetCounter().GetValue()
+			last[key] = value
+			previous, ok := d.last[key]
+			if !ok {
+				continue
+			}
+			increase := value - previous
+			if increase < 0 {
+				increase = 0
+				resets.Metric = append(resets.Metric, &dto.Metric{
+					Label: append([]*dto.LabelPair{{Name: proto.String("metric"), Value: mf.Name}}, m.Label...),
+					Gauge: &dto.Gauge{Value: proto.Float64(1)},
+				})
+			}
+			m.Counter = nil
+			m.Gauge = &dto.Gauge{Value: proto.Float64(increase)}
+			metrics = append(metrics, m)
+		}
+		if len(metrics) > 0 {
+			mf.Metric = metrics
+			mf.Help = proto.String(mf.GetHelp() + " Increase since the previous send.")
+			mf.Type = dto.MetricType_GAUGE.Enum()
+			kept = append(kept, mf)
+		}
+	}
+	d.last = last
+	if len(resets.Metric) > 0 {
+		for _, m := range resets.Metric {
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+		kept = append(kept, resets)
+	}
+	return kept
+}
+
+// seriesKey identifies the series of name with labels, which are sorted
+// by name as gathered.
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, l := range labels {
+		b.WriteByte(0)
+		b.WriteString(l.GetName())
+		b.WriteByte('=')
+		b.WriteString(l.GetValue())
+	}
+	return b.String()
+}
+
+// deltasGatherer applies d to what g gathers.
+type deltasGatherer struct {
+	g prometheus.Gatherer
+	d *deltas
+}
+
+func (g deltasGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.g.Gather()
+	return g.d.apply(mfs), err
+}
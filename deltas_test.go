@@ -0,0 +1,173 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// restartingApache serves the total accesses and duration in turn, apache
+// restarting after the second, and stays at the last.
+func restartingApache() *httptest.Server {
+	var mutex sync.Mutex
+	accesses := []string{"100", "150", "20", "30"}
+	durations := []string{"4000", "6000", "1000", "1500"}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Write([]byte("Total Accesses: " + accesses[0] + "\nTotal kBytes: 1\nTotal Duration: " + durations[0] + "\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+		if len(accesses) > 1 {
+			accesses, durations = accesses[1:], durations[1:]
+		}
+	}))
+}
+
+func TestDeltas(t *testing.T) {
+	ts := restartingApache()
+	defer ts.Close()
+	es := Exporters{NewExporter(ts.URL)}
+	var buf bytes.Buffer
+	w := &influxWriter{out: &buf, deltas: newDeltas([]string{"apache_accesses_total", "apache_duration_seconds_total"})}
+	for i, c := range []struct {
+		accesses string // Line protocol of the accesses sent, if any.
+		duration string // Of the duration.
+		reset    bool
+	}{
+		{"", "", false},
+		{"apache_accesses_total value=50 ", "apache_duration_seconds_total value=2 ", false},
+		{"apache_accesses_total value=0 ", "apache_duration_seconds_total value=0 ", true},
+		{"apache_accesses_total value=10 ", "apache_duration_seconds_total value=0.5 ", false},
+	} {
+		buf.Reset()
+		if err := w.write(es); err != nil {
+			t.Fatal(err)
+		}
+		out := "\n" + buf.String()
+		if c.accesses == "" && strings.Contains(out, "apache_accesses_total") {
+			t.Errorf("send %d: expected no accesses without an earlier send in\n%s", i+1, out)
+		}
+		if c.accesses != "" && !strings.Contains(out, "\n"+c.accesses) {
+			t.Errorf("send %d: expected %q in\n%s", i+1, c.accesses, out)
+		}
+		if c.duration == "" && strings.Contains(out, "apache_duration_seconds_total") {
+			t.Errorf("send %d: expected no duration without an earlier send in\n%s", i+1, out)
+		}
+		if c.duration != "" && !strings.Contains(out, "\n"+c.duration) {
+			t.Errorf("send %d: expected %q in\n%s", i+1, c.duration, out)
+		}
+		for _, metric := range []string{"apache_accesses_total", "apache_duration_seconds_total"} {
+			if reset := strings.Contains(out, `apache_exporter_counter_reset,metric=`+metric+` value=1 `); reset != c.reset {
+				t.Errorf("send %d: expected reset marker of %s %v in\n%s", i+1, metric, c.reset, out)
+			}
+		}
+		// Counters not asked for keep their value.
+		if i > 0 && !strings.Contains(out, "\napache_sent_kilobytes_total value=1 ") {
+			t.Errorf("send %d: expected the traffic as it is in\n%s", i+1, out)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	metricsHandler(newTargetSet(es, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rr.Body.String(); !strings.Contains(body, "\napache_accesses_total 30\n") || !strings.Contains(body, "\napache_duration_seconds_total 1.5\n") || strings.Contains(body, "counter_reset") {
+		t.Errorf("expected /metrics to be left alone in\n%s", body)
+	}
+}
+
+func TestPushDeltas(t *testing.T) {
+	ts := restartingApache()
+	defer ts.Close()
+	gw := &fakeGateway{}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	p, err := newPusher(srv.URL, "apache", nil, config.HTTPConfig{}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deltas = newDeltas([]string{"apache_accesses_total"})
+	s := newTargetSet(Exporters{NewExporter(ts.URL)}, nil)
+	for i := 0; i < 3; i++ {
+		if err := p.push(pushGatherer(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The accesses pushed and the reset marker, as text.
+	pushed := make([]string, 3)
+	for i, r := range gw.recorded() {
+		dec := expfmt.NewDecoder(strings.NewReader(r.body), expfmt.FmtProtoDelim)
+		var buf bytes.Buffer
+		for {
+			var mf dto.MetricFamily
+			if err := dec.Decode(&mf); err != nil {
+				break
+			}
+			if mf.GetName() == "apache_accesses_total" || mf.GetName() == "apache_exporter_counter_reset" {
+				expfmt.MetricFamilyToText(&buf, &mf)
+			}
+		}
+		pushed[i] = buf.String()
+	}
+	if pushed[0] != "" {
+		t.Errorf("expected no accesses in the first push, got\n%s", pushed[0])
+	}
+	if !strings.Contains(pushed[1], "# TYPE apache_accesses_total gauge\napache_accesses_total 50\n") {
+		t.Errorf("expected the increase as a gauge in\n%s", pushed[1])
+	}
+	if !strings.Contains(pushed[2], "apache_accesses_total 0\n") || !strings.Contains(pushed[2], `apache_exporter_counter_reset{metric="apache_accesses_total"} 1`) {
+		t.Errorf("expected the restart to be marked in\n%s", pushed[2])
+	}
+}
+
+func TestDeltasState(t *testing.T) {
+	defer func(values []string) {
+		deltaMetrics.values, restoredState = values, nil
+		outputDeltas = map[string]*deltas{}
+	}(deltaMetrics.values)
+	deltaMetrics.values = []string{"apache_accesses_total"}
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	apache := &statusServer{}
+	ts := httptest.NewServer(apache)
+	defer ts.Close()
+	es := Exporters{NewExporter(ts.URL)}
+	send := func(w *influxWriter) string {
+		var buf bytes.Buffer
+		w.out = &buf
+		if err := w.write(es); err != nil {
+			t.Fatal(err)
+		}
+		return "\n" + buf.String()
+	}
+
+	apache.set("100", "300")
+	send(&influxWriter{deltas: deltasFromFlags("influx")})
+	if err := saveState(path, es, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The exporter restarts.
+	outputDeltas = map[string]*deltas{}
+	restoredState = loadState(path, time.Hour, time.Now())
+	apache.set("130", "330")
+	if out := send(&influxWriter{deltas: deltasFromFlags("influx")}); !strings.Contains(out, "\napache_accesses_total value=30 ") {
+		t.Errorf("expected the increase since before the restart in\n%s", out)
+	}
+}
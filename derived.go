@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// derivedMetrics holds the derived metrics of the -config.file loaded
+// last, as a map[string]*config.Expr.
+var derivedMetrics atomic.Value
+
+var derivedErrors *prometheus.CounterVec
+
+func init() {
+	selfMetric(func() {
+		derivedErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_derived_metric_errors_total",
+			Help:        "Number of samples of derived metrics left out, because a field of their expression was missing from the status page or it divided by zero.",
+			ConstLabels: withConstLabels(nil),
+		}, []string{"metric"})
+		registry.MustRegister(derivedErrors)
+	})
+}
+
+func currentDerivedMetrics() map[string]*config.Expr {
+	metrics, _ := derivedMetrics.Load().(map[string]*config.Expr)
+	return metrics
+}
+
+// derivedFieldKey is how fields are matched to those of expressions:
+// lowercased with anything but letters and digits left out, so that
+// TotalKBytes is Total kBytes and ConnsTotal is conns_total of the fields
+// with no metric of their own.
+func derivedFieldKey(name string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c - 'A' + 'a'
+		}
+		return -1
+	}, name)
+}
+
+// collectDerived sends the derived metrics evaluated over status to ch, in
+// order of their names. Those that fail to evaluate are left out and
+// counted in apache_exporter_derived_metric_errors_total.
+func (e *Exporter) collectDerived(status parsedStatus, ch chan<- prometheus.Metric) {
+	metrics := currentDerivedMetrics()
+	if len(metrics) == 0 {
+		return
+	}
+	fields := map[string]float64{}
+	for name, v := range status.unknown {
+		fields[derivedFieldKey(name)] = v
+	}
+	for name, v := range status.values {
+		fields[derivedFieldKey(name)] = v
+	}
+	field := func(name string) (float64, bool) {
+		if state := strings.TrimPrefix(name, "Scoreboard."); state != name {
+			return float64(strings.Count(status.scoreboard, state)), status.scoreboard != ""
+		}
+		v, ok := fields[derivedFieldKey(name)]
+		return v, ok
+	}
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := withConstLabels(e.labels)
+	for _, name := range names {
+		expr := metrics[name]
+		v, err := expr.Eval(field)
+		if err != nil {
+			derivedErrors.WithLabelValues(name).Inc()
+			e.logger.Debug("Leaving out a derived metric", "metric", name, "err", err)
+			continue
+		}
+		desc := prometheus.NewDesc(name, "Derived from the status page as "+expr.String()+".", nil, labels)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+	}
+}
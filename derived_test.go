@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestDerivedMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	cfg, err := config.Load([]byte(`
+targets:
+  - uri: ` + backend.URL + `
+derived_metrics:
+  apache_bytes_per_access: TotalKBytes*1024/TotalAccesses
+  apache_effective_busy: BusyWorkers - Scoreboard.K
+  apache_idle_ratio: Scoreboard._ / (BusyWorkers + IdleWorkers)
+  apache_load_percent: Load1 * 100
+  apache_async_connections: ConnsTotal
+  apache_idle_spare: BusyWorkers / (IdleWorkers - 4)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer derivedMetrics.Store(currentDerivedMetrics())
+	derivedMetrics.Store(cfg.DerivedMetrics)
+	defer func(old bool) { *exportUnknownFields = old }(*exportUnknownFields)
+	*exportUnknownFields = false
+
+	before := map[string]float64{}
+	for _, name := range []string{"apache_async_connections", "apache_idle_spare"} {
+		before[name] = counterValue(t, derivedErrors.WithLabelValues(name))
+	}
+	values := scrapeValues(t, Exporters{NewExporter(backend.URL)})
+	for name, want := range map[string]float64{
+		"apache_bytes_per_access": 2048,
+		"apache_effective_busy":   1,
+		"apache_idle_ratio":       0.8,
+		"apache_load_percent":     323,
+	} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("expected %s %v, got %v", name, want, got)
+		}
+	}
+	// The missing field and the division by zero leave theirs out.
+	for name, n := range before {
+		if _, ok := values[name]; ok {
+			t.Errorf("unexpected %s", name)
+		}
+		if got := counterValue(t, derivedErrors.WithLabelValues(name)); got != n+1 {
+			t.Errorf("expected an evaluation error of %s counted, got %v", name, got-n)
+		}
+	}
+	// The fields with no metric of their own are still not exported.
+	if _, ok := values["apache_status_field"]; ok {
+		t.Error("unexpected apache_status_field without -status.export-unknown-fields")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+)
+
+var refreshInterval = flag.Duration("discovery.refresh-interval", 30*time.Second, "How often to refresh discovered targets.")
+
+// discoverer is a source of targets that change over time.
+type discoverer interface {
+	targets() []target
+}
+
+// discoverers are the enabled discovery mechanisms.
+var discoverers []discoverer
+
+// discoveryMechanism is a way of discovering targets. Each registers
+// itself from the file it is in, which builds tagged minimal leave out.
+type discoveryMechanism struct {
+	name    string
+	enabled func() bool // Whether the flags ask for it.
+	// setup does the first discovery, returning the discoverer and the
+	// watcher keeping it up to date until done is closed.
+	setup func(newContext func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error)
+}
+
+// discoveryMechanisms are the mechanisms compiled in.
+var discoveryMechanisms []discoveryMechanism
+
+func registerDiscovery(m discoveryMechanism) {
+	discoveryMechanisms = append(discoveryMechanisms, m)
+}
+
+// watcher keeps watching a source of targets, calling changed whenever
+// they change.
+type watcher func(changed func())
+
+// setupDiscovery enables the discovery mechanisms and target files asked
+// for by the flags, doing their first discovery so the initial targets can
+// be set up. The returned watchers keep them up to date until done is
+// closed.
+func setupDiscovery(done <-chan struct{}) ([]watcher, error) {
+	var watchers []watcher
+	newContext := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), *refreshInterval)
+	}
+
+	if *targetsFile != "" {
+		registry.MustRegister(targetsFileLoaded)
+		watchers = append(watchers, func(changed func()) {
+			watchFile(*targetsFile, *targetsPollInterval, changed, done)
+		})
+	}
+
+	for _, m := range discoveryMechanisms {
+		if !m.enabled() {
+			continue
+		}
+		d, watch, err := m.setup(newContext, done)
+		if err != nil {
+			return nil, err
+		}
+		discoverers = append(discoverers, d)
+		watchers = append(watchers, watch)
+	}
+
+	return watchers, nil
+}
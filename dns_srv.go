@@ -0,0 +1,182 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	srvNames       = &targetsFlag{}
+	srvGracePeriod = flag.Duration("discovery.dns-srv.grace-period", 5*time.Minute, "How long a target missing from its SRV records is kept.")
+)
+
+func init() {
+	flag.Var(srvNames, "discovery.dns-srv", "DNS SRV name, such as _apache-status._tcp.example.com, whose records are scraped. May be repeated or comma separated.")
+	registerDiscovery(discoveryMechanism{
+		name:    "dns-srv",
+		enabled: func() bool { return len(srvNames.values) > 0 },
+		setup: func(newContext func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error) {
+			srv := newSRVDiscovery(srvNames.values, *srvGracePeriod)
+			ctx, cancel := newContext()
+			srv.refresh(ctx)
+			cancel()
+			registry.MustRegister(srv)
+			return srv, func(changed func()) {
+				srv.run(*refreshInterval, changed, done)
+			}, nil
+		},
+	})
+}
+
+// srvDiscovery finds targets from DNS SRV records. Hosts that disappear are
+// kept for a grace period, and names that fail to resolve keep the hosts
+// they had, so a DNS hiccup doesn't drop targets.
+type srvDiscovery struct {
+	names  []string
+	grace  time.Duration
+	lookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	now    func() time.Time
+
+	mutex sync.Mutex
+	// seen maps each name to its host:port addresses and since when they
+	// have been missing from its records, zero if they are there.
+	seen map[string]map[string]time.Time
+
+	discovered  prometheus.Gauge
+	lastRefresh prometheus.Gauge
+}
+
+func newSRVDiscovery(names []string, grace time.Duration) *srvDiscovery {
+	return &srvDiscovery{
+		names:  names,
+		grace:  grace,
+		lookup: net.DefaultResolver.LookupSRV,
+		now:    time.Now,
+		seen:   map[string]map[string]time.Time{},
+		discovered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_dns_srv_targets",
+			Help:        "Number of targets discovered through DNS SRV records.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_dns_srv_last_refresh_success_timestamp_seconds",
+			Help:        "Timestamp of the last refresh that resolved every DNS SRV name.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+}
+
+// refresh resolves all names, reporting whether the set of discovered
+// targets changed.
+func (d *srvDiscovery) refresh(ctx context.Context) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	before := d.addrs()
+	ok := true
+	for _, name := range d.names {
+		_, records, err := d.lookup(ctx, "", "", name)
+		if err != nil {
+			logger.Error("Error resolving SRV records, keeping the previous targets", "name", name, "err", err)
+			ok = false
+			continue
+		}
+		now := d.now()
+		addrs := d.seen[name]
+		if addrs == nil {
+			addrs = map[string]time.Time{}
+			d.seen[name] = addrs
+		}
+		present := map[string]bool{}
+		for _, srv := range records {
+			addr := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+			present[addr] = true
+			addrs[addr] = time.Time{}
+		}
+		for addr, missing := range addrs {
+			switch {
+			case present[addr]:
+			case missing.IsZero():
+				addrs[addr] = now
+			case now.Sub(missing) > d.grace:
+				delete(addrs, addr)
+			}
+		}
+	}
+	after := d.addrs()
+	d.discovered.Set(float64(len(after)))
+	if ok {
+		d.lastRefresh.Set(float64(d.now().Unix()))
+	}
+	return strings.Join(before, ",") != strings.Join(after, ",")
+}
+
+// addrs returns the discovered addresses, sorted. d.mutex must be held.
+func (d *srvDiscovery) addrs() []string {
+	unique := map[string]bool{}
+	for _, addrs := range d.seen {
+		for addr := range addrs {
+			unique[addr] = true
+		}
+	}
+	var sorted []string
+	for addr := range unique {
+		sorted = append(sorted, addr)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// targets returns the discovered hosts, to be completed with the
+// -scrape.default-* flags.
+func (d *srvDiscovery) targets() []target {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var targets []target
+	for _, addr := range d.addrs() {
+		targets = append(targets, target{uri: addr})
+	}
+	return targets
+}
+
+// run refreshes d every interval until done is closed, calling changed
+// when the targets changed.
+func (d *srvDiscovery) run(interval time.Duration, changed func(), done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if d.refresh(ctx) {
+				changed()
+			}
+			cancel()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (d *srvDiscovery) Describe(ch chan<- *prometheus.Desc) {
+	d.discovered.Describe(ch)
+	d.lastRefresh.Describe(ch)
+}
+
+func (d *srvDiscovery) Collect(ch chan<- prometheus.Metric) {
+	d.discovered.Collect(ch)
+	d.lastRefresh.Collect(ch)
+}
@@ -0,0 +1,129 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// stubSRV answers SRV lookups from records, failing for names not in it.
+type stubSRV struct {
+	records map[string][]*net.SRV
+}
+
+func (s *stubSRV) lookup(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := s.records[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+	return name, records, nil
+}
+
+func discoveredAddrs(d *srvDiscovery) []string {
+	var addrs []string
+	for _, t := range d.targets() {
+		addrs = append(addrs, t.uri)
+	}
+	return addrs
+}
+
+func TestSRVDiscovery(t *testing.T) {
+	stub := &stubSRV{records: map[string][]*net.SRV{
+		"_apache-status._tcp.a.example.com": {
+			{Target: "web01.example.com.", Port: 80},
+			{Target: "web02.example.com.", Port: 8080},
+		},
+		"_apache-status._tcp.b.example.com": {
+			{Target: "web02.example.com.", Port: 8080},
+		},
+	}}
+	now := time.Unix(1000, 0)
+	d := newSRVDiscovery([]string{"_apache-status._tcp.a.example.com", "_apache-status._tcp.b.example.com"}, time.Minute)
+	d.lookup = stub.lookup
+	d.now = func() time.Time { return now }
+
+	if !d.refresh(context.Background()) {
+		t.Error("expected the first refresh to find targets")
+	}
+	want := []string{"web01.example.com:80", "web02.example.com:8080"}
+	if got := discoveredAddrs(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if v := gaugeValue(t, d.discovered); v != 2 {
+		t.Errorf("expected 2 discovered targets, got %v", v)
+	}
+	if v := gaugeValue(t, d.lastRefresh); v != 1000 {
+		t.Errorf("expected last refresh at 1000, got %v", v)
+	}
+	if d.refresh(context.Background()) {
+		t.Error("expected unchanged records not to change the targets")
+	}
+
+	// Failing names keep their targets.
+	delete(stub.records, "_apache-status._tcp.a.example.com")
+	now = now.Add(time.Hour)
+	d.refresh(context.Background())
+	if got := discoveredAddrs(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected failed resolution to keep %v, got %v", want, got)
+	}
+	if v := gaugeValue(t, d.lastRefresh); v != 1000 {
+		t.Errorf("expected failed refresh not to be recorded, got %v", v)
+	}
+
+	// Hosts gone from the records are dropped after the grace period.
+	stub.records["_apache-status._tcp.a.example.com"] = []*net.SRV{{Target: "web02.example.com.", Port: 8080}}
+	if d.refresh(context.Background()) {
+		t.Error("expected web01 to be kept during the grace period")
+	}
+	now = now.Add(30 * time.Second)
+	if d.refresh(context.Background()) {
+		t.Error("expected web01 to be kept during the grace period")
+	}
+	now = now.Add(time.Minute)
+	if !d.refresh(context.Background()) {
+		t.Error("expected web01 to be dropped after the grace period")
+	}
+	if got := discoveredAddrs(d); !reflect.DeepEqual(got, []string{"web02.example.com:8080"}) {
+		t.Errorf("expected only web02, got %v", got)
+	}
+}
+
+func TestSRVDiscoveryExporters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	d := newSRVDiscovery([]string{"_apache-status._tcp.example.com"}, time.Minute)
+	d.lookup = (&stubSRV{records: map[string][]*net.SRV{
+		"_apache-status._tcp.example.com": {{Target: host, Port: uint16(p)}},
+	}}).lookup
+	d.refresh(context.Background())
+
+	defer func(old []discoverer) { discoverers = old }(discoverers)
+	discoverers = []discoverer{d}
+	es, err := exportersFromFlags(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 {
+		t.Fatalf("expected only the discovered target, got %d", len(es))
+	}
+	if want := fmt.Sprintf("http://%s:%d/server-status?auto", host, p); es[0].URI != want {
+		t.Errorf("expected discovered target completed to %s, got %s", want, es[0].URI)
+	}
+	if got := targetValues(t, es, "apache_up"); got[""] != 1 {
+		t.Errorf("expected the discovered target to be up, got %v", got)
+	}
+}
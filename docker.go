@@ -0,0 +1,263 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	dockerEnabled = flag.Bool("discovery.docker", false, "Discover apache containers through the Docker API.")
+	dockerHost    = flag.String("discovery.docker.host", "unix:///var/run/docker.sock", "Address of the Docker API, as unix:///path or tcp://host:port.")
+)
+
+func init() {
+	registerDiscovery(discoveryMechanism{
+		name:    "docker",
+		enabled: func() bool { return *dockerEnabled },
+		setup: func(newContext func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error) {
+			docker, err := newDockerDiscovery(*dockerHost)
+			if err != nil {
+				return nil, nil, err
+			}
+			ctx, cancel := newContext()
+			if _, err := docker.refresh(ctx); err != nil {
+				logger.Error("Error listing Docker containers", "err", err)
+			}
+			cancel()
+			registry.MustRegister(docker)
+			return docker, func(changed func()) {
+				docker.run(*refreshInterval, changed, done)
+			}, nil
+		},
+	})
+}
+
+// Container labels selecting and describing the containers to scrape.
+const (
+	dockerLabelEnable = "apache-exporter.enable"
+	dockerLabelPort   = "apache-exporter.port"
+	dockerLabelPath   = "apache-exporter.path"
+	dockerLabelScheme = "apache-exporter.scheme"
+)
+
+// dockerContainer is an element of the response of Docker's container list.
+type dockerContainer struct {
+	ID     string `json:"Id"`
+	Names  []string
+	Labels map[string]string
+	Ports  []struct {
+		IP          string
+		PrivatePort int
+		PublicPort  int
+		Type        string
+	}
+}
+
+// dockerDiscovery finds targets among the running containers labeled
+// apache-exporter.enable=true, listing them every refresh.
+type dockerDiscovery struct {
+	base   string
+	client *http.Client
+
+	mutex   sync.Mutex
+	current []target
+
+	discovered  prometheus.Gauge
+	lastRefresh prometheus.Gauge
+	failures    prometheus.Counter
+}
+
+func newDockerDiscovery(host string) (*dockerDiscovery, error) {
+	d := &dockerDiscovery{
+		client: &http.Client{Timeout: 30 * time.Second},
+		discovered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_docker_targets",
+			Help:        "Number of targets discovered through Docker.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_docker_last_refresh_success_timestamp_seconds",
+			Help:        "Timestamp of the last successful listing of Docker containers.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_docker_failures_total",
+			Help:        "Number of failed listings of Docker containers.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		path := strings.TrimPrefix(host, "unix://")
+		d.base = "http://docker"
+		d.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+	case strings.HasPrefix(host, "tcp://"):
+		d.base = "http://" + strings.TrimPrefix(host, "tcp://")
+	default:
+		return nil, fmt.Errorf("unsupported Docker host %q, expected unix:// or tcp://", host)
+	}
+	return d, nil
+}
+
+// refresh lists the containers, reporting whether the discovered targets
+// changed. On failure the previous targets are kept.
+func (d *dockerDiscovery) refresh(ctx context.Context) (bool, error) {
+	containers, err := d.list(ctx)
+	if err != nil {
+		d.failures.Inc()
+		return false, err
+	}
+	var targets []target
+	for _, c := range containers {
+		if t, ok := containerTarget(c); ok {
+			targets = append(targets, t)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.lastRefresh.Set(float64(time.Now().Unix()))
+	d.discovered.Set(float64(len(targets)))
+	if reflect.DeepEqual(targets, d.current) {
+		return false, nil
+	}
+	d.current = targets
+	return true, nil
+}
+
+func (d *dockerDiscovery) list(ctx context.Context) ([]dockerContainer, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {dockerLabelEnable + "=true"}})
+	req, err := http.NewRequestWithContext(ctx, "GET", d.base+"/containers/json?filters="+url.QueryEscape(string(filters)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Docker returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("decoding Docker response: %v", err)
+	}
+	return containers, nil
+}
+
+// containerTarget builds the target for c from its published port, the one
+// for the private port in its apache-exporter.port label if it has several.
+func containerTarget(c dockerContainer) (target, bool) {
+	want, _ := strconv.Atoi(c.Labels[dockerLabelPort])
+	host, port := "", 0
+	for _, p := range c.Ports {
+		if p.Type != "tcp" || p.PublicPort == 0 || (want != 0 && p.PrivatePort != want) {
+			continue
+		}
+		if port == 0 || p.PrivatePort < port {
+			host, port = p.IP, p.PublicPort
+		}
+	}
+	if port == 0 {
+		logger.Debug("Skipping container without a published port", "container", c.ID)
+		return target{}, false
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	uri := net.JoinHostPort(host, strconv.Itoa(port))
+	if scheme := c.Labels[dockerLabelScheme]; scheme != "" {
+		uri = scheme + "://" + uri
+	}
+	if path := c.Labels[dockerLabelPath]; path != "" {
+		uri += "/" + strings.TrimPrefix(path, "/")
+	}
+
+	id := c.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	name := id
+	if len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	return target{
+		name: name,
+		uri:  uri,
+		conf: config.Target{Labels: map[string]string{
+			"container_name": name,
+			"container_id":   id,
+		}},
+	}, true
+}
+
+func (d *dockerDiscovery) targets() []target {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.current
+}
+
+// run refreshes d every interval until done is closed, calling changed
+// when the targets changed.
+func (d *dockerDiscovery) run(interval time.Duration, changed func(), done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			ok, err := d.refresh(ctx)
+			cancel()
+			if err != nil {
+				logger.Error("Error listing Docker containers, keeping the previous targets", "err", err)
+			}
+			if ok {
+				changed()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (d *dockerDiscovery) Describe(ch chan<- *prometheus.Desc) {
+	d.discovered.Describe(ch)
+	d.lastRefresh.Describe(ch)
+	d.failures.Describe(ch)
+}
+
+func (d *dockerDiscovery) Collect(ch chan<- prometheus.Metric) {
+	d.discovered.Collect(ch)
+	d.lastRefresh.Collect(ch)
+	d.failures.Collect(ch)
+}
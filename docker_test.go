@@ -0,0 +1,132 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDocker serves the container list of the Docker API.
+type fakeDocker struct {
+	t          *testing.T
+	mutex      sync.Mutex
+	containers string
+}
+
+func (d *fakeDocker) set(containers string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.containers = containers
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil || r.URL.Path != "/containers/json" ||
+		len(filters["label"]) != 1 || filters["label"][0] != "apache-exporter.enable=true" {
+		d.t.Errorf("unexpected Docker request %s", r.URL)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.containers == "" {
+		http.Error(w, "Cannot connect to the Docker daemon", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(d.containers))
+}
+
+func TestDockerDiscovery(t *testing.T) {
+	fake := &fakeDocker{t: t}
+	fake.set(`[
+		{"Id": "0123456789abcdef", "Names": ["/web"], "Labels": {"apache-exporter.enable": "true", "apache-exporter.path": "/status"},
+		 "Ports": [{"PrivatePort": 443, "Type": "tcp"}, {"IP": "0.0.0.0", "PrivatePort": 80, "PublicPort": 8080, "Type": "tcp"}]},
+		{"Id": "fedcba9876543210", "Names": ["/tls"], "Labels": {"apache-exporter.enable": "true", "apache-exporter.scheme": "https", "apache-exporter.port": "443"},
+		 "Ports": [{"IP": "192.0.2.1", "PrivatePort": 80, "PublicPort": 8081, "Type": "tcp"}, {"IP": "192.0.2.1", "PrivatePort": 443, "PublicPort": 8443, "Type": "tcp"}]},
+		{"Id": "unpublished", "Names": ["/internal"], "Labels": {"apache-exporter.enable": "true"}, "Ports": [{"PrivatePort": 80, "Type": "tcp"}]}
+	]`)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	if _, err := newDockerDiscovery("ssh://docker"); err == nil {
+		t.Error("expected unsupported Docker host to be rejected")
+	}
+	d, err := newDockerDiscovery("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d.refresh(context.Background()); err != nil || !ok {
+		t.Fatalf("expected the first refresh to find containers, got %t, %v", ok, err)
+	}
+	targets := d.targets()
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %+v", targets)
+	}
+	if targets[0].name != "tls" || targets[0].uri != "https://192.0.2.1:8443" {
+		t.Errorf("unexpected target %+v", targets[0])
+	}
+	if targets[1].name != "web" || targets[1].uri != "127.0.0.1:8080/status" {
+		t.Errorf("unexpected target %+v", targets[1])
+	}
+	if l := targets[1].conf.Labels; l["container_name"] != "web" || l["container_id"] != "0123456789ab" {
+		t.Errorf("unexpected labels %v", l)
+	}
+
+	// A stopped container is dropped.
+	fake.set(`[{"Id": "fedcba9876543210", "Names": ["/tls"], "Labels": {"apache-exporter.enable": "true"},
+		"Ports": [{"IP": "192.0.2.1", "PrivatePort": 443, "PublicPort": 8443, "Type": "tcp"}]}]`)
+	if ok, err := d.refresh(context.Background()); err != nil || !ok {
+		t.Fatalf("expected the stopped container to change the targets, got %t, %v", ok, err)
+	}
+	if ok, _ := d.refresh(context.Background()); ok {
+		t.Error("expected an unchanged container list not to change the targets")
+	}
+
+	// The last known containers are kept while Docker is unreachable.
+	fake.set("")
+	if _, err := d.refresh(context.Background()); err == nil {
+		t.Error("expected refresh to fail")
+	}
+	if len(d.targets()) != 1 || counterValue(t, d.failures) != 1 {
+		t.Errorf("expected the last targets to be kept and the failure counted, got %+v", d.targets())
+	}
+}
+
+func TestDockerDiscoverySocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %s", err)
+	}
+	fake := &fakeDocker{t: t}
+	fake.set(`[{"Id": "0123456789abcdef", "Names": ["/web"], "Ports": [{"PrivatePort": 80, "PublicPort": 8080, "Type": "tcp"}]}]`)
+	server := httptest.NewUnstartedServer(fake)
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	d, err := newDockerDiscovery("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if targets := d.targets(); len(targets) != 1 || targets[0].uri != "127.0.0.1:8080" {
+		t.Errorf("unexpected targets %+v", targets)
+	}
+}
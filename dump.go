@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	dumpDir      = flag.String("debug.dump-dir", "", "Directory to write the bodies of status pages that fail to parse to, for debugging. Nothing is written if empty.")
+	dumpMaxFiles = flag.Int("debug.dump-max-files", 20, "Most dumps kept in -debug.dump-dir; the oldest are removed first.")
+	dumpMaxBytes = flag.Int64("debug.dump-max-bytes", 10<<20, "Most bytes of dumps kept in -debug.dump-dir; the oldest are removed first.")
+)
+
+// dumpSuffix ends the names of dumps, which start with the time they were
+// taken so that they sort oldest first.
+const dumpSuffix = ".dump"
+
+// dumpMutex keeps concurrent dumps from removing each other's files.
+var dumpMutex sync.Mutex
+
+// dumpBody writes the body of resp, which failed to parse with err, to a
+// file in dir for target, the URI if empty, then removes the oldest dumps
+// beyond maxFiles or maxBytes. Bodies reached through a redirect with
+// credentials in its URL aren't written, as the redirect may have handed
+// the body out for them.
+func dumpBody(dir, target string, resp *http.Response, body []byte, err error, maxFiles int, maxBytes int64) error {
+	if redirectedWithCredentials(resp) {
+		logger.Debug("Not dumping a status page reached through a redirect with credentials", "target", target)
+		return nil
+	}
+	if target == "" {
+		target = sanitizeURI(resp.Request.URL.String())
+	}
+	now := time.Now().UTC()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Target: %s\n", target)
+	fmt.Fprintf(&buf, "# URI: %s\n", sanitizeURI(resp.Request.URL.String()))
+	fmt.Fprintf(&buf, "# Time: %s\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&buf, "# Status: %s\n", resp.Status)
+	fmt.Fprintf(&buf, "# Error: %s\n\n", err)
+	buf.Write(body)
+
+	name := now.Format("20060102T150405.000000000Z") + "-" + dumpName(target) + dumpSuffix
+	dumpMutex.Lock()
+	defer dumpMutex.Unlock()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return pruneDumps(dir, maxFiles, maxBytes)
+}
+
+// redirectedWithCredentials tells whether resp came through a redirect to
+// a URL with userinfo or sensitive query parameters.
+func redirectedWithCredentials(resp *http.Response) bool {
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		if req.URL.User != nil || sanitizeURI(req.URL.String()) != req.URL.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpName turns target into something safe in a file name.
+func dumpName(target string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' {
+			return c
+		}
+		return '_'
+	}, sanitizeURI(target))
+}
+
+// pruneDumps removes the oldest dumps in dir until at most maxFiles of
+// them, taking up at most maxBytes, are left.
+func pruneDumps(dir string, maxFiles int, maxBytes int64) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var dumps []os.FileInfo
+	var total int64
+	for _, fi := range files {
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), dumpSuffix) {
+			dumps = append(dumps, fi)
+			total += fi.Size()
+		}
+	}
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].Name() < dumps[j].Name() })
+	for len(dumps) > 0 && (len(dumps) > maxFiles || total > maxBytes) {
+		if err := os.Remove(filepath.Join(dir, dumps[0].Name())); err != nil {
+			return err
+		}
+		total -= dumps[0].Size()
+		dumps = dumps[1:]
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpOnParseFailure(t *testing.T) {
+	defer func(old string) { *dumpDir = old }(*dumpDir)
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Total Accesses: lots\nBusyWorkers: 1\n"))
+	}))
+	defer ts.Close()
+	es, err := setupExporters([]string{"web01=" + ts.URL + "/server-status?auto&token=t0ken"}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the flag nothing is written.
+	*dumpDir = ""
+	targetValues(t, es, "apache_up")
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no dumps without -debug.dump-dir, got %d", len(files))
+	}
+
+	*dumpDir = dir
+	targetValues(t, es, "apache_up")
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-web01.dump") {
+		t.Fatalf("expected a dump for web01, got %v", files)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := string(data)
+	for _, want := range []string{
+		"# Target: web01\n",
+		"# URI: " + ts.URL + "/server-status?auto&token=xxxxx\n",
+		"# Status: 200 OK\n",
+		"# Error: strconv.ParseFloat: parsing \"lots\": invalid syntax\n\nTotal Accesses: lots\nBusyWorkers: 1\n",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("expected %q in\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "t0ken") {
+		t.Errorf("credentials in the dump:\n%s", dump)
+	}
+}
+
+func TestDumpRedirectWithCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/server-status" {
+			http.Redirect(w, r, "/sso?sig=s1gned", http.StatusFound)
+			return
+		}
+		w.Write([]byte("Total Accesses: lots\n"))
+	}))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/server-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := dumpBody(dir, "web01", resp, []byte("Total Accesses: lots\n"), os.ErrInvalid, 10, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no dump after a redirect with credentials, got %d", len(files))
+	}
+}
+
+func TestPruneDumps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"20260101T000001-a.dump", "20260101T000002-a.dump", "20260101T000003-a.dump", "20260101T000004-a.dump", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func() []string {
+		files, _ := ioutil.ReadDir(dir)
+		var names []string
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+
+	if err := pruneDumps(dir, 3, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(), " "); got != "20260101T000002-a.dump 20260101T000003-a.dump 20260101T000004-a.dump notes.txt" {
+		t.Errorf("expected the oldest dump removed past the file cap, got %s", got)
+	}
+	if err := pruneDumps(dir, 3, 150); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(), " "); got != "20260101T000004-a.dump notes.txt" {
+		t.Errorf("expected the oldest dumps removed past the byte cap, got %s", got)
+	}
+}
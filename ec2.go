@@ -0,0 +1,288 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	ec2Region    = flag.String("discovery.ec2.region", "", "AWS region to discover running apache EC2 instances in, such as eu-west-1. Disabled if empty.")
+	ec2Filters   = &targetsFlag{}
+	ec2Port      = flag.Int("discovery.ec2.port", 0, "Port of the status page on the EC2 instances (default -scrape.default-port).")
+	ec2Path      = flag.String("discovery.ec2.path", "", "Path of the status page on the EC2 instances (default -scrape.default-path).")
+	ec2RoleARN   = flag.String("discovery.ec2.role-arn", "", "ARN of an IAM role to assume for listing EC2 instances, instead of using the default credentials directly.")
+	ec2LabelTags = &targetsFlag{}
+)
+
+// ec2MaxBackoff caps how long listing EC2 instances backs off while the
+// API throttles, in refresh intervals.
+const ec2MaxBackoff = 8
+
+func init() {
+	flag.Var(ec2Filters, "discovery.ec2.filter", "Tag the EC2 instances to scrape have, as role=apache. May be repeated or comma separated, instances must have all of them.")
+	flag.Var(ec2LabelTags, "discovery.ec2.label-tags", "Tag of the EC2 instances added to their targets as an ec2_tag_<tag> label. May be repeated or comma separated.")
+	registerDiscovery(discoveryMechanism{
+		name:    "ec2",
+		enabled: func() bool { return *ec2Region != "" },
+		setup: func(newContext func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error) {
+			filters, err := ec2TagFilters(ec2Filters.values)
+			if err != nil {
+				return nil, nil, err
+			}
+			ctx, cancel := newContext()
+			defer cancel()
+			client, err := newEC2Client(ctx, *ec2Region, *ec2RoleARN)
+			if err != nil {
+				return nil, nil, fmt.Errorf("configuring the AWS SDK: %v", err)
+			}
+			d := newEC2Discovery(client, filters, *ec2Port, *ec2Path, ec2LabelTags.values)
+			if _, err := d.refresh(ctx); err != nil {
+				logger.Error("Error listing EC2 instances", "err", err)
+			}
+			registry.MustRegister(d)
+			return d, func(changed func()) {
+				d.run(*refreshInterval, changed, done)
+			}, nil
+		},
+	})
+}
+
+// newEC2Client creates an EC2 client for region with the SDK's default
+// credential chain, assuming roleARN with those credentials if it is set.
+func newEC2Client(ctx context.Context, region, roleARN string) (*ec2.Client, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(region),
+		// The standard retryer already backs off from throttled calls.
+		awsconfig.WithRetryer(func() aws.Retryer {
+			return retry.AddWithMaxBackoffDelay(retry.NewStandard(), 30*time.Second)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if roleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN))
+	}
+	return ec2.NewFromConfig(cfg), nil
+}
+
+// ec2TagFilters turns key=value tags into the filters of a listing of
+// EC2 instances.
+func ec2TagFilters(tags []string) ([]ec2Filter, error) {
+	var filters []ec2Filter
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid -discovery.ec2.filter %q, expected key=value", tag)
+		}
+		filters = append(filters, ec2Filter{"tag:" + key, value})
+	}
+	return filters, nil
+}
+
+// ec2Filter is a filter of a listing of EC2 instances.
+type ec2Filter struct {
+	name, value string
+}
+
+// ec2Discovery finds targets among the running EC2 instances with some
+// tags, listing them every refresh and keeping the last known instances
+// whenever the listing fails.
+type ec2Discovery struct {
+	client    ec2.DescribeInstancesAPIClient
+	filters   []ec2Filter
+	port      int
+	path      string
+	labelTags []string
+
+	mutex   sync.Mutex
+	current []target
+
+	discovered  prometheus.Gauge
+	lastRefresh prometheus.Gauge
+	failures    prometheus.Counter
+}
+
+func newEC2Discovery(client ec2.DescribeInstancesAPIClient, filters []ec2Filter, port int, path string, labelTags []string) *ec2Discovery {
+	return &ec2Discovery{
+		client:    client,
+		filters:   filters,
+		port:      port,
+		path:      path,
+		labelTags: labelTags,
+		discovered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_ec2_targets",
+			Help:        "Number of targets discovered through the EC2 API.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_ec2_last_refresh_success_timestamp_seconds",
+			Help:        "Timestamp of the last successful listing of EC2 instances.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_ec2_failures_total",
+			Help:        "Number of failed listings of EC2 instances.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+}
+
+// refresh lists the instances, reporting whether the discovered targets
+// changed. On failure the previous targets are kept.
+func (d *ec2Discovery) refresh(ctx context.Context) (bool, error) {
+	targets, err := d.list(ctx)
+	if err != nil {
+		d.failures.Inc()
+		return false, err
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.lastRefresh.Set(float64(time.Now().Unix()))
+	d.discovered.Set(float64(len(targets)))
+	if reflect.DeepEqual(targets, d.current) {
+		return false, nil
+	}
+	d.current = targets
+	return true, nil
+}
+
+func (d *ec2Discovery) list(ctx context.Context) ([]target, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"running"}}},
+	}
+	for _, f := range d.filters {
+		input.Filters = append(input.Filters, ec2types.Filter{Name: aws.String(f.name), Values: []string{f.value}})
+	}
+	var targets []target
+	pages := ec2.NewDescribeInstancesPaginator(d.client, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				if t, ok := d.instanceTarget(i); ok {
+					targets = append(targets, t)
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+// instanceTarget builds the target for i from its private IP, labeled with
+// its ID and the tags of d.labelTags it has. Missing parts of the URI are
+// completed from the -scrape.default-* flags.
+func (d *ec2Discovery) instanceTarget(i ec2types.Instance) (target, bool) {
+	id := aws.ToString(i.InstanceId)
+	uri := aws.ToString(i.PrivateIpAddress)
+	if uri == "" {
+		logger.Debug("Skipping EC2 instance without a private IP", "instance", id)
+		return target{}, false
+	}
+	if d.port != 0 {
+		uri = net.JoinHostPort(uri, strconv.Itoa(d.port))
+	}
+	if d.path != "" {
+		uri += "/" + strings.TrimPrefix(d.path, "/")
+	}
+	labels := map[string]string{"ec2_instance_id": id}
+	for _, tag := range i.Tags {
+		for _, key := range d.labelTags {
+			if aws.ToString(tag.Key) == key {
+				labels["ec2_tag_"+invalidLabelChars.ReplaceAllString(key, "_")] = aws.ToString(tag.Value)
+			}
+		}
+	}
+	return target{name: id, uri: uri, conf: config.Target{Labels: labels}}, true
+}
+
+func (d *ec2Discovery) targets() []target {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.current
+}
+
+// ec2Throttled tells whether err is the EC2 API throttling its callers.
+func ec2Throttled(err error) bool {
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	_, ok := retry.DefaultThrottleErrorCodes[ae.ErrorCode()]
+	return ok
+}
+
+// run refreshes d every interval until done is closed, calling changed
+// when the targets changed. While the API throttles, the refreshes back
+// off, up to ec2MaxBackoff intervals apart.
+func (d *ec2Discovery) run(interval time.Duration, changed func(), done <-chan struct{}) {
+	wait := interval
+	for {
+		select {
+		case <-time.After(wait):
+		case <-done:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		ok, err := d.refresh(ctx)
+		cancel()
+		switch {
+		case err == nil:
+			wait = interval
+		case ec2Throttled(err):
+			if wait *= 2; wait > ec2MaxBackoff*interval {
+				wait = ec2MaxBackoff * interval
+			}
+			logger.Warn("EC2 API throttling, backing off and keeping the previous targets", "backoff", wait, "err", err)
+		default:
+			wait = interval
+			logger.Error("Error listing EC2 instances, keeping the previous targets", "err", err)
+		}
+		if ok {
+			changed()
+		}
+	}
+}
+
+func (d *ec2Discovery) Describe(ch chan<- *prometheus.Desc) {
+	d.discovered.Describe(ch)
+	d.lastRefresh.Describe(ch)
+	d.failures.Describe(ch)
+}
+
+func (d *ec2Discovery) Collect(ch chan<- prometheus.Metric) {
+	d.discovered.Collect(ch)
+	d.lastRefresh.Collect(ch)
+	d.failures.Collect(ch)
+}
@@ -0,0 +1,218 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// fakeEC2Instance is an instance listed by fakeEC2.
+type fakeEC2Instance struct {
+	id, ip, state string
+	tags          map[string]string
+}
+
+// fakeEC2 serves DescribeInstances of the EC2 query API, filtering the
+// instances by state and tags like EC2 does, two per page.
+type fakeEC2 struct {
+	t         *testing.T
+	mutex     sync.Mutex
+	instances []fakeEC2Instance
+	throttle  int // Number of requests to throttle.
+	requests  int
+}
+
+func (f *fakeEC2) set(instances ...fakeEC2Instance) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.instances = instances
+}
+
+func (f *fakeEC2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "DescribeInstances" {
+		f.t.Errorf("unexpected EC2 request %v", r.Form)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests++
+	if f.throttle > 0 {
+		f.throttle--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors><RequestID>1</RequestID></Response>`)
+		return
+	}
+
+	filters := map[string]string{}
+	for i := 1; r.Form.Get(fmt.Sprintf("Filter.%d.Name", i)) != ""; i++ {
+		filters[r.Form.Get(fmt.Sprintf("Filter.%d.Name", i))] = r.Form.Get(fmt.Sprintf("Filter.%d.Value.1", i))
+	}
+	var matching []fakeEC2Instance
+	for _, i := range f.instances {
+		ok := filters["instance-state-name"] == "" || filters["instance-state-name"] == i.state
+		for name, value := range filters {
+			if key := strings.TrimPrefix(name, "tag:"); key != name && i.tags[key] != value {
+				ok = false
+			}
+		}
+		if ok {
+			matching = append(matching, i)
+		}
+	}
+	start, _ := strconv.Atoi(r.Form.Get("NextToken"))
+	end := start + 2
+	if end > len(matching) {
+		end = len(matching)
+	}
+
+	var b strings.Builder
+	b.WriteString(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><reservationSet>`)
+	for _, i := range matching[start:end] {
+		fmt.Fprintf(&b, `<item><reservationId>r-%s</reservationId><instancesSet><item><instanceId>%s</instanceId>`, i.id, i.id)
+		if i.ip != "" {
+			fmt.Fprintf(&b, `<privateIpAddress>%s</privateIpAddress>`, i.ip)
+		}
+		fmt.Fprintf(&b, `<instanceState><code>16</code><name>%s</name></instanceState><tagSet>`, i.state)
+		for k, v := range i.tags {
+			fmt.Fprintf(&b, `<item><key>%s</key><value>%s</value></item>`, k, v)
+		}
+		b.WriteString(`</tagSet></item></instancesSet></item>`)
+	}
+	b.WriteString(`</reservationSet>`)
+	if end < len(matching) {
+		fmt.Fprintf(&b, `<nextToken>%d</nextToken>`, end)
+	}
+	b.WriteString(`</DescribeInstancesResponse>`)
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(b.String()))
+}
+
+func newFakeEC2Client(url string) *ec2.Client {
+	return ec2.New(ec2.Options{
+		Region:       "eu-west-1",
+		BaseEndpoint: aws.String(url),
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		Retryer:      aws.NopRetryer{},
+	})
+}
+
+func ec2URIs(d *ec2Discovery) map[string]string {
+	uris := map[string]string{}
+	for _, t := range d.targets() {
+		uris[t.name] = t.uri
+	}
+	return uris
+}
+
+func TestEC2Discovery(t *testing.T) {
+	apache := map[string]string{"role": "apache", "env": "prod"}
+	fake := &fakeEC2{t: t}
+	fake.set(
+		fakeEC2Instance{"i-1", "10.0.0.1", "running", apache},
+		fakeEC2Instance{"i-2", "10.0.0.2", "running", apache},
+		fakeEC2Instance{"i-3", "10.0.0.3", "running", map[string]string{"role": "db"}},
+		fakeEC2Instance{"i-4", "10.0.0.4", "stopped", apache},
+		fakeEC2Instance{"i-5", "", "running", apache},
+		fakeEC2Instance{"i-6", "10.0.0.6", "running", apache},
+	)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	filters, err := ec2TagFilters([]string{"role=apache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newEC2Discovery(newFakeEC2Client(server.URL), filters, 8080, "status", []string{"env", "missing"})
+	if changed, err := d.refresh(context.Background()); err != nil || !changed {
+		t.Fatalf("expected the first listing to find targets, got %v, %v", changed, err)
+	}
+	want := map[string]string{"i-1": "10.0.0.1:8080/status", "i-2": "10.0.0.2:8080/status", "i-6": "10.0.0.6:8080/status"}
+	if got := ec2URIs(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v over several pages, got %v", want, got)
+	}
+	if labels := d.targets()[0].conf.Labels; !reflect.DeepEqual(labels, map[string]string{"ec2_instance_id": "i-1", "ec2_tag_env": "prod"}) {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	// Churn: one instance is terminated, another launched.
+	fake.set(
+		fakeEC2Instance{"i-2", "10.0.0.2", "running", apache},
+		fakeEC2Instance{"i-6", "10.0.0.6", "running", apache},
+		fakeEC2Instance{"i-7", "10.0.0.7", "running", apache},
+		fakeEC2Instance{"i-1", "10.0.0.1", "terminated", apache},
+	)
+	changes := int32(0)
+	done := make(chan struct{})
+	defer close(done)
+	go d.run(10*time.Millisecond, func() { atomic.AddInt32(&changes, 1) }, done)
+	waitFor(t, "the churned instances", func() bool {
+		_, ok := ec2URIs(d)["i-7"]
+		return ok && atomic.LoadInt32(&changes) > 0
+	})
+	want = map[string]string{"i-2": "10.0.0.2:8080/status", "i-6": "10.0.0.6:8080/status", "i-7": "10.0.0.7:8080/status"}
+	if got := ec2URIs(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after the churn, got %v", want, got)
+	}
+}
+
+func TestEC2DiscoveryThrottled(t *testing.T) {
+	fake := &fakeEC2{t: t}
+	fake.set(fakeEC2Instance{"i-1", "10.0.0.1", "running", nil})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	d := newEC2Discovery(newFakeEC2Client(server.URL), nil, 0, "", nil)
+	if _, err := d.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	fake.mutex.Lock()
+	fake.throttle = 1000
+	fake.mutex.Unlock()
+	_, err := d.refresh(context.Background())
+	if !ec2Throttled(err) {
+		t.Fatalf("expected a throttling error, got %v", err)
+	}
+	if got := ec2URIs(d); !reflect.DeepEqual(got, map[string]string{"i-1": "10.0.0.1"}) {
+		t.Errorf("expected the last known targets to be kept, got %v", got)
+	}
+
+	// Backing off, refreshes get further and further apart.
+	fake.mutex.Lock()
+	fake.requests = 0
+	fake.mutex.Unlock()
+	done := make(chan struct{})
+	go d.run(20*time.Millisecond, func() {}, done)
+	time.Sleep(300 * time.Millisecond)
+	close(done)
+	fake.mutex.Lock()
+	requests := fake.requests
+	fake.mutex.Unlock()
+	// Without backing off it would be 15, with it 20+40+80+160ms.
+	if requests < 1 || requests > 5 {
+		t.Errorf("expected the throttled refreshes to back off, got %d requests in 300ms", requests)
+	}
+}
+
+func TestEC2TagFilters(t *testing.T) {
+	filters, err := ec2TagFilters([]string{"role=apache", "env="})
+	if err != nil || !reflect.DeepEqual(filters, []ec2Filter{{"tag:role", "apache"}, {"tag:env", ""}}) {
+		t.Errorf("unexpected filters %v, %v", filters, err)
+	}
+	if _, err := ec2TagFilters([]string{"apache"}); err == nil {
+		t.Error("expected an error for a filter without a value")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newStatusEndpointDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("status_endpoint_available", "Whether the status page was there on the last scrape, 0 if it was answered with a 404 or 403, as when mod_status isn't enabled or its Location denies the exporter. Absent if no answer came.", nil, labels)
+}
+
+// statusUnavailable tells whether code means the status page isn't there
+// or is denied, rather than apache failing.
+func statusUnavailable(code int) bool {
+	return code == http.StatusNotFound || code == http.StatusForbidden
+}
+
+// servedByApache tells whether resp, with the body data, looks like one of
+// apache's own error pages.
+func servedByApache(resp *http.Response, data []byte) bool {
+	return strings.HasPrefix(resp.Header.Get("Server"), "Apache") || bytes.Contains(data, []byte("<address>Apache"))
+}
+
+// unavailableHint is the hint for a status page answered with a 404 or
+// 403, fetched from the exporter's address local.
+func unavailableHint(resp *http.Response, data []byte, local net.Addr) string {
+	if !servedByApache(resp, data) {
+		return "the server answering doesn't look like apache, does the uri point at it?"
+	}
+	from := "the exporter"
+	if addr, ok := local.(*net.TCPAddr); ok {
+		from = addr.IP.String()
+	}
+	return fmt.Sprintf("apache is up, is mod_status enabled and the Location reachable from %s?", from)
+}
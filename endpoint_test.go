@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apache404 is apache's own 404 page, with ServerSignature on.
+const apache404 = `<!DOCTYPE HTML PUBLIC "-//IETF//DTD HTML 2.0//EN">
+<html><head>
+<title>404 Not Found</title>
+</head><body>
+<h1>Not Found</h1>
+<p>The requested URL was not found on this server.</p>
+<hr>
+<address>Apache/2.4.62 (Debian) Server at web01 Port 80</address>
+</body></html>
+`
+
+func TestStatusEndpointUnavailable(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		server string
+		code   int
+		body   string
+		hint   string
+	}{
+		{"apache 404", "", http.StatusNotFound, apache404, "is mod_status enabled and the Location reachable from 127.0.0.1?"},
+		{"apache 403", "Apache", http.StatusForbidden, "<title>403 Forbidden</title>", "is mod_status enabled and the Location reachable from 127.0.0.1?"},
+		{"other server 404", "nginx", http.StatusNotFound, "<html><body><center><h1>404 Not Found</h1></center><hr><center>nginx</center></body></html>", "doesn't look like apache"},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.server != "" {
+				w.Header().Set("Server", tc.server)
+			}
+			w.WriteHeader(tc.code)
+			w.Write([]byte(tc.body))
+		}))
+		e := NewExporter(server.URL)
+		values := scrapeValues(t, Exporters{e})
+		err := e.last.err
+		server.Close()
+		if values["apache_up"] != 0 || values["apache_status_endpoint_available"] != 0 {
+			t.Errorf("%s: expected a failed scrape of an unavailable status page, got %v", tc.name, values)
+		}
+		if v := counterValue(t, e.scrapeFailures.WithLabelValues(reasonStatusUnavailable)); v != 1 {
+			t.Errorf("%s: expected a %s failure, got %v", tc.name, reasonStatusUnavailable, v)
+		}
+		var statusErr *HTTPStatusError
+		if !errors.As(err, &statusErr) || statusErr.Code != tc.code || !strings.Contains(err.Error(), tc.hint) {
+			t.Errorf("%s: expected the hint %q, got %v", tc.name, tc.hint, err)
+		}
+		if strings.Contains(err.Error(), "<html") {
+			t.Errorf("%s: expected the hint in place of the page, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestStatusEndpointAvailable(t *testing.T) {
+	for _, code := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+			w.Write([]byte(apache24Status))
+		}))
+		e := NewExporter(server.URL)
+		values := scrapeValues(t, Exporters{e})
+		server.Close()
+		if v, ok := values["apache_status_endpoint_available"]; !ok || v != 1 {
+			t.Errorf("%d: expected the status page available, got %v", code, v)
+		}
+		if v := counterValue(t, e.scrapeFailures.WithLabelValues(reasonStatusUnavailable)); v != 0 {
+			t.Errorf("%d: unexpected %s failures %v", code, reasonStatusUnavailable, v)
+		}
+	}
+
+	// Without an answer, whether there is a status page is unknown.
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	if _, ok := scrapeValues(t, Exporters{NewExporter(server.URL)})["apache_status_endpoint_available"]; ok {
+		t.Error("expected no apache_status_endpoint_available of an unreachable target")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// envPrefix starts the environment variables flags can be set with.
+const envPrefix = "APACHE_EXPORTER_"
+
+// envName returns the environment variable setting the flag called name:
+// APACHE_EXPORTER_ followed by the name in upper case, with dots and
+// dashes turned into underscores.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// setFlagsFromEnv sets the flags of fs that weren't given on the command
+// line from their variables in environ, as from os.Environ, parsing them
+// as the flags would. It returns the variables starting with envPrefix
+// that set no flag, sorted, for typos to be warned about.
+func setFlagsFromEnv(fs *flag.FlagSet, environ []string) ([]string, error) {
+	// A flag given under its deprecated alias or its current name is given
+	// under both.
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[currentName(fs, f.Name)] = true })
+	flags := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		// An alias whose variable is its flag's, such as scrape_uri's,
+		// leaves it to the flag.
+		if _, taken := flags[envName(f.Name)]; !taken || currentName(fs, f.Name) == f.Name {
+			flags[envName(f.Name)] = f.Name
+		}
+	})
+
+	var unknown []string
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		key, value := kv, ""
+		if i := strings.Index(kv, "="); i >= 0 {
+			key, value = kv[:i], kv[i+1:]
+		}
+		name, ok := flags[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if given[currentName(fs, name)] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value of %s for -%s: %v", key, name, err)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"scrape_uri":        "APACHE_EXPORTER_SCRAPE_URI",
+		"telemetry.address": "APACHE_EXPORTER_TELEMETRY_ADDRESS",
+		"scrape.user-agent": "APACHE_EXPORTER_SCRAPE_USER_AGENT",
+	} {
+		if got := envName(name); got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+	// Every flag has a variable of its own.
+	seen := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if other, ok := seen[envName(f.Name)]; ok {
+			t.Errorf("-%s and -%s share %s", other, f.Name, envName(f.Name))
+		}
+		seen[envName(f.Name)] = f.Name
+	})
+}
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *string, *bool, *time.Duration, *targetsFlag) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		address := fs.String("telemetry.address", ":9117", "")
+		insecure := fs.Bool("insecure", false, "")
+		timeout := fs.Duration("scrape.timeout", 0, "")
+		targets := &targetsFlag{}
+		fs.Var(targets, "scrape_uri", "")
+		return fs, address, insecure, timeout, targets
+	}
+	environ := []string{
+		"PATH=/usr/bin",
+		"APACHE_EXPORTER_TELEMETRY_ADDRESS=:9999",
+		"APACHE_EXPORTER_INSECURE=1",
+		"APACHE_EXPORTER_SCRAPE_TIMEOUT=1m30s",
+		"APACHE_EXPORTER_SCRAPE_URI=web01=http://web01/server-status?auto,web02=http://web02/server-status?auto",
+		"APACHE_EXPORTER_SCRAPE_URL=http://typo/",
+		"APACHE_EXPORTER_LISTEN=:1",
+	}
+
+	fs, address, insecure, timeout, targets := newFlags()
+	if err := fs.Parse([]string{"-telemetry.address", ":8080"}); err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := setFlagsFromEnv(fs, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *address != ":8080" {
+		t.Errorf("expected the flag to take precedence, got %s", *address)
+	}
+	if !*insecure || *timeout != 90*time.Second {
+		t.Errorf("expected the variables to be parsed as flags, got %t and %s", *insecure, *timeout)
+	}
+	if got := len(targets.values); got != 2 {
+		t.Errorf("expected 2 targets, got %v", targets.values)
+	}
+	if got := strings.Join(unknown, " "); got != "APACHE_EXPORTER_LISTEN APACHE_EXPORTER_SCRAPE_URL" {
+		t.Errorf("unexpected unknown variables %s", got)
+	}
+
+	for _, bad := range []string{"APACHE_EXPORTER_INSECURE=maybe", "APACHE_EXPORTER_SCRAPE_TIMEOUT=90"} {
+		fs, _, _, _, _ := newFlags()
+		fs.Parse(nil)
+		_, err := setFlagsFromEnv(fs, []string{bad})
+		if err == nil || !strings.Contains(err.Error(), strings.SplitN(bad, "=", 2)[0]) {
+			t.Errorf("%s: expected an error naming the variable, got %v", bad, err)
+		}
+	}
+}
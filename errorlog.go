@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errorLogPath    = flag.String("errorlog.path", "", "Error log of the apache running on the same host, such as /var/log/apache2/error.log, tailed for apache_errorlog_messages_total by level and module. Rotation and truncation are followed. Empty to not tail any.")
+	errorLogModules = flag.Int("errorlog.modules", 20, "Modules apache_errorlog_messages_total is labeled by, in the order they log, after which the messages of others are labeled other.")
+)
+
+// errorLogLevels are the levels of LogLevel.
+var errorLogLevels = map[string]bool{
+	"emerg": true, "alert": true, "crit": true, "error": true, "warn": true, "notice": true, "info": true, "debug": true,
+	"trace1": true, "trace2": true, "trace3": true, "trace4": true, "trace5": true, "trace6": true, "trace7": true, "trace8": true,
+}
+
+// errorLogMessage is what the exporter takes of the first line of a
+// message in the error log.
+type errorLogMessage struct {
+	time          time.Time // Zero if it didn't parse.
+	module, level string
+}
+
+// parseErrorLogLine parses the start of a message in the error log of
+// apache 2.4, as in
+//
+//	[Wed Oct 14 09:12:01.482133 2026] [proxy_fcgi:error] [pid 4121:tid 4242] [client 192.0.2.10:51234] AH01071: Got error 'PHP message: ...'
+//
+// Lines that don't start so, such as those a module wrote itself, continue
+// the message before them.
+func parseErrorLogLine(line string) (errorLogMessage, bool) {
+	if !strings.HasPrefix(line, "[") {
+		return errorLogMessage{}, false
+	}
+	end := strings.Index(line, "] [")
+	if end < 0 {
+		return errorLogMessage{}, false
+	}
+	stamp, rest := line[1:end], line[end+3:]
+	end = strings.IndexByte(rest, ']')
+	if end < 0 {
+		return errorLogMessage{}, false
+	}
+	module, level := "", rest[:end]
+	if i := strings.LastIndexByte(level, ':'); i >= 0 {
+		module, level = level[:i], level[i+1:]
+	}
+	if !errorLogLevels[level] {
+		return errorLogMessage{}, false
+	}
+	m := errorLogMessage{module: module, level: level}
+	// With or without the microseconds, in local time.
+	if t, err := time.ParseInLocation("Mon Jan 02 15:04:05 2006", stamp, time.Local); err == nil {
+		m.time = t
+	}
+	return m, true
+}
+
+// apacheErrorLog tails the error log of apache into counts of its messages.
+type apacheErrorLog struct {
+	logTail
+	maxModules int
+	modules    map[string]bool // Those labeled so far, only by observe.
+
+	messages     *prometheus.CounterVec
+	lastCritical prometheus.Gauge
+}
+
+func newApacheErrorLog(path string, maxModules int) (*apacheErrorLog, error) {
+	if maxModules <= 0 {
+		return nil, fmt.Errorf("-errorlog.modules must be positive, got %d", maxModules)
+	}
+	return &apacheErrorLog{
+		logTail: newLogTail(path, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_errorlog_lines_dropped_total",
+			Help:        "Lines of the error log of apache left out as they came faster than they could be parsed.",
+			ConstLabels: withConstLabels(nil),
+		})),
+		maxModules: maxModules,
+		modules:    map[string]bool{},
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "errorlog_messages_total",
+			Help:        "Messages in the error log of apache, by level and by the module that logged them, other beyond -errorlog.modules.",
+			ConstLabels: withConstLabels(nil),
+		}, []string{"level", "module"}),
+		lastCritical: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "errorlog_last_critical_timestamp_seconds",
+			Help:        "When apache last logged an emerg, alert or crit message in its error log, 0 if it hasn't since the exporter started.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}, nil
+}
+
+func (l *apacheErrorLog) Describe(ch chan<- *prometheus.Desc) {
+	l.messages.Describe(ch)
+	l.lastCritical.Describe(ch)
+	l.dropped.Describe(ch)
+}
+
+func (l *apacheErrorLog) Collect(ch chan<- prometheus.Metric) {
+	l.messages.Collect(ch)
+	l.lastCritical.Collect(ch)
+	l.dropped.Collect(ch)
+}
+
+// observe takes a line of the log into the metrics.
+func (l *apacheErrorLog) observe(line string) {
+	m, ok := parseErrorLogLine(line)
+	if !ok {
+		return
+	}
+	module := m.module
+	if !l.modules[module] {
+		if len(l.modules) < l.maxModules {
+			l.modules[module] = true
+		} else {
+			module = "other"
+		}
+	}
+	l.messages.WithLabelValues(m.level, module).Inc()
+	switch m.level {
+	case "emerg", "alert", "crit":
+		if m.time.IsZero() {
+			m.time = time.Now()
+		}
+		l.lastCritical.Set(float64(m.time.UnixNano()) / 1e9)
+	}
+}
+
+// run tails the log until done is closed.
+func (l *apacheErrorLog) run(done <-chan struct{}) {
+	l.tail(done, l.observe)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseErrorLogLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want errorLogMessage
+		ok   bool
+	}{
+		{"[Wed Oct 14 09:12:01.482133 2026] [proxy_fcgi:error] [pid 4121:tid 4242] AH01071: Got error", errorLogMessage{time.Date(2026, 10, 14, 9, 12, 1, 482133000, time.Local), "proxy_fcgi", "error"}, true},
+		{"[Wed Oct 14 09:12:01 2026] [core:trace3] [pid 4121] request.c(325): ...", errorLogMessage{time.Date(2026, 10, 14, 9, 12, 1, 0, time.Local), "core", "trace3"}, true},
+		{"[Wed Oct 14 09:12:01.5 2026] [:crit] [pid 1] x", errorLogMessage{time.Date(2026, 10, 14, 9, 12, 1, 5e8, time.Local), "", "crit"}, true},
+		// A custom ErrorLogFormat with another time.
+		{"[2026-10-14 09:12:01] [ssl:warn] x", errorLogMessage{module: "ssl", level: "warn"}, true},
+		// Continuing the message before.
+		{"Stack trace:", errorLogMessage{}, false},
+		{"#0 /var/www/html/index.php(12): connect()", errorLogMessage{}, false},
+		{"[Wed Oct 14 09:12:01 2026] [core:fatal] x", errorLogMessage{}, false},
+		{"[Wed Oct 14 09:12:01 2026] [core:error x", errorLogMessage{}, false},
+		{"[client 192.0.2.10] x", errorLogMessage{}, false},
+	} {
+		got, ok := parseErrorLogLine(tc.line)
+		if ok != tc.ok || !got.time.Equal(tc.want.time) || got.module != tc.want.module || got.level != tc.want.level {
+			t.Errorf("%q: expected %+v, %v, got %+v, %v", tc.line, tc.want, tc.ok, got, ok)
+		}
+	}
+}
+
+// errorLogMessages returns how many messages l counted.
+func errorLogMessages(t *testing.T, l *apacheErrorLog) float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 100)
+	l.messages.Collect(ch)
+	close(ch)
+	total := 0.0
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		total += m.GetCounter().GetValue()
+	}
+	return total
+}
+
+// checkErrorLogExposition compares the metrics of l with the golden ones
+// of the captured error log, but for the time of the last critical
+// message, which is in local time.
+func checkErrorLogExposition(t *testing.T, l *apacheErrorLog) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(l)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stable []*dto.MetricFamily
+	for _, mf := range mfs {
+		if !strings.Contains(mf.GetName(), "timestamp") {
+			stable = append(stable, mf)
+		}
+	}
+	var buf strings.Builder
+	if err := writeText(&buf, stable); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "errorlog", "error.prom")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, []byte(buf.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compareExpositions([]byte(buf.String()), want); err != nil {
+		t.Error(err)
+	}
+	// The alert of mpm_event.
+	var m dto.Metric
+	if err := l.lastCritical.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 14, 9, 12, 7, 216190000, time.Local); m.GetGauge().GetValue() != float64(want.UnixNano())/1e9 {
+		t.Errorf("expected the last critical message at %s, got %v", want, m.GetGauge().GetValue())
+	}
+}
+
+// capturedErrorLog returns the lines of the captured error log.
+func capturedErrorLog(t *testing.T) []string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "errorlog", "error.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	return lines[:len(lines)-1]
+}
+
+func TestErrorLogReplay(t *testing.T) {
+	// Beyond the first five modules to log, others are labeled other.
+	l, err := newApacheErrorLog("error.log", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range capturedErrorLog(t) {
+		l.observe(strings.TrimSuffix(line, "\n"))
+	}
+	checkErrorLogExposition(t, l)
+}
+
+func TestErrorLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.log")
+	// Already there, so left out.
+	appendFile(t, path, "[Wed Oct 14 08:00:00.000000 2026] [core:emerg] [pid 1] before the exporter\n")
+	l, err := newApacheErrorLog(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.poll = 10 * time.Millisecond
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		l.run(done)
+	}()
+	// Until it has opened the log at its end.
+	time.Sleep(50 * time.Millisecond)
+
+	// Rotated by apache, through rotatelogs, halfway through the stack trace.
+	lines := capturedErrorLog(t)
+	appendFile(t, path, lines[:5]...)
+	time.Sleep(30 * time.Millisecond)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, lines[5:]...)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for errorLogMessages(t, l) != 12 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 12 messages, got %v", errorLogMessages(t, l))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(done)
+	<-stopped
+	checkErrorLogExposition(t, l)
+}
+
+func TestNewApacheErrorLog(t *testing.T) {
+	if _, err := newApacheErrorLog("error.log", 0); err == nil {
+		t.Error("expected no modules to fail")
+	}
+}
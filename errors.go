@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of failed scrapes, for errors.Is on the errors Warmup returns
+// and the exporter logs. They keep the messages of what they mark.
+var (
+	// ErrUnreachable is a status page request that got no response, even
+	// one that timed out before it.
+	ErrUnreachable = errors.New("apache unreachable")
+	// ErrTimeout is a scrape that ran out of time, in whichever phase.
+	ErrTimeout = errors.New("scrape timed out")
+	// ErrAuth is a scrape whose credentials couldn't be read, or which
+	// apache refused with a 401 or 403.
+	ErrAuth = errors.New("not authorized")
+)
+
+// markedError is err, also matching mark with errors.Is.
+type markedError struct {
+	err, mark error
+}
+
+func (e *markedError) Error() string   { return e.err.Error() }
+func (e *markedError) Unwrap() []error { return []error{e.err, e.mark} }
+
+// markError returns err marked as mark.
+func markError(err, mark error) error {
+	return &markedError{err, mark}
+}
+
+// HTTPStatusError is a page apache served with a status other than 200.
+type HTTPStatusError struct {
+	Code   int
+	Status string
+	Path   string // Of a page other than the status page.
+	Body   string // Of the status page, or the error reading it.
+	Hint   string // At what is wrong, in place of the body.
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("Status %s (%d) fetching %s", e.Status, e.Code, e.Path)
+	}
+	if e.Hint != "" {
+		return fmt.Sprintf("Status %s (%d): %s", e.Status, e.Code, e.Hint)
+	}
+	return fmt.Sprintf("Status %s (%d): %s", e.Status, e.Code, e.Body)
+}
+
+// Is makes 401 and 403 ErrAuth.
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == ErrAuth && (e.Code == 401 || e.Code == 403)
+}
+
+// ParseError is a field of the status page that failed to parse.
+type ParseError struct {
+	Line  int // Of the status page, from 1.
+	Field string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("field %s on line %d: %v", e.Field, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestWarmupErrors(t *testing.T) {
+	var status int
+	body := "BusyWorkers: 1\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	status, body = http.StatusServiceUnavailable, "down\n"
+	err := NewExporter(ts.URL).Warmup()
+	var se *HTTPStatusError
+	if !errors.As(err, &se) || se.Code != 503 || se.Body != "down\n" || se.Path != "" {
+		t.Errorf("expected a 503 HTTPStatusError, got %#v", err)
+	} else if want := "Status 503 Service Unavailable (503): down\n"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+	if errors.Is(err, ErrAuth) || errors.Is(err, ErrUnreachable) || errors.Is(err, ErrTimeout) {
+		t.Errorf("expected a 503 to be neither ErrAuth, ErrUnreachable nor ErrTimeout: %v", err)
+	}
+	for _, code := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		status = code
+		if err := NewExporter(ts.URL).Warmup(); !errors.Is(err, ErrAuth) {
+			t.Errorf("%d: expected ErrAuth, got %v", code, err)
+		}
+	}
+
+	status, body = 0, "Total Accesses: 10\nBusyWorkers: lots\n"
+	defer func(strict bool) { *strictParse = strict }(*strictParse)
+	*strictParse = true
+	err = NewExporter(ts.URL).Warmup()
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 2 || pe.Field != "BusyWorkers" || !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("expected a BusyWorkers ParseError on line 2, got %#v", err)
+	} else if want := `field BusyWorkers on line 2: strconv.ParseFloat: parsing "lots": invalid syntax`; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+	if failureReason(err) != reasonParse {
+		t.Errorf("expected reason %s, got %s", reasonParse, failureReason(err))
+	}
+
+	// Credentials that can't be read.
+	e := NewExporter(ts.URL)
+	e.conf = config.Target{HTTPConfig: config.HTTPConfig{BasicAuth: &config.BasicAuth{Username: "u", PasswordFile: filepath.Join(t.TempDir(), "missing")}}}
+	err = e.Warmup()
+	if !errors.Is(err, ErrAuth) || !errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrAuth for a missing password file, got %v", err)
+	}
+}
+
+func TestWarmupUnreachable(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	err := NewExporter(down.URL).Warmup()
+	var ue *url.Error
+	if !errors.Is(err, ErrUnreachable) || errors.Is(err, ErrTimeout) || !errors.As(err, &ue) {
+		t.Errorf("expected ErrUnreachable wrapping a *url.Error, got %#v", err)
+	}
+
+	hang := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer slow.Close()
+	defer close(hang)
+	e := NewExporter(slow.URL)
+	e.client = newHTTPClient(clientConfig{timeout: 50 * time.Millisecond})
+	err = e.Warmup()
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrTimeout and ErrUnreachable, got %v", err)
+	}
+}
+
+func TestFetchPageErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL + "/server-status")
+	_, _, err := NewExporter(ts.URL).fetchPage(context.Background(), u)
+	var se *HTTPStatusError
+	if !errors.As(err, &se) || se.Code != 403 || se.Path != "/server-status" || !errors.Is(err, ErrAuth) {
+		t.Errorf("expected a 403 HTTPStatusError of /server-status, got %#v", err)
+	} else if want := "Status 403 Forbidden (403) fetching /server-status"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestFailureReasonWrapped(t *testing.T) {
+	err := fmt.Errorf("scraping web01: %w", &scrapeError{reasonDialTimeout, errors.New("i/o timeout")})
+	if got := failureReason(err); got != reasonDialTimeout {
+		t.Errorf("expected %s, got %s", reasonDialTimeout, got)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected a dial timeout to be ErrTimeout")
+	}
+	if errors.Is(&scrapeError{reasonBodyTooLarge, errors.New("too large")}, ErrTimeout) {
+		t.Errorf("expected a body too large not to be ErrTimeout")
+	}
+	if got := failureReason(errors.New("other")); got != reasonRequest {
+		t.Errorf("expected %s, got %s", reasonRequest, got)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var failureSummaryInterval = flag.Duration("log.failure-summary-interval", time.Hour, "How often to log a summary of a target that keeps failing, instead of every failed scrape. The first failure and the recovery are always logged. 0 logs every failure.")
+
+// failureLog throttles the log of a target's failed scrapes: the first
+// failure of a streak is logged, repeats only in a summary every interval,
+// and the end of the streak again.
+type failureLog struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	since    time.Time // Of the first failure of the streak, zero if none.
+	total    int       // Failures in the streak.
+	lastLine time.Time
+	repeats  int // Failures not logged since lastLine.
+}
+
+// failed logs err, the failure of the scrape started at, to logger along
+// with attrs, if it's the first of a streak or a summary is due.
+func (l *failureLog) failed(logger *slog.Logger, at time.Time, err error, attrs ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.total++
+	switch {
+	case l.since.IsZero() || l.interval <= 0:
+		if l.since.IsZero() {
+			l.since = at
+		}
+		l.lastLine = at
+		logger.Error("Error scraping apache", append(attrs, "err", err)...)
+	case at.Sub(l.lastLine) >= l.interval:
+		logger.Error("Target still failing", append(attrs, "failures", l.repeats+1, "interval", at.Sub(l.lastLine).Round(time.Second), "since", l.since, "err", err)...)
+		l.lastLine, l.repeats = at, 0
+	default:
+		l.repeats++
+	}
+}
+
+// succeeded logs the end of the streak of failures, if any, once the
+// scrape started at went well.
+func (l *failureLog) succeeded(logger *slog.Logger, at time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.since.IsZero() {
+		return
+	}
+	logger.Info("Target recovered", "failures", l.total, "down", at.Sub(l.since).Round(time.Second))
+	l.since, l.total, l.repeats = time.Time{}, 0, 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailureLog(t *testing.T) {
+	buf := captureLogs(t, "json")
+	l := &failureLog{interval: time.Hour}
+	start := time.Date(2026, 10, 10, 18, 0, 0, 0, time.UTC)
+	err := errors.New("connection refused")
+	// A weekend of failures, every 15 seconds.
+	at := start
+	for ; at.Before(start.Add(60 * time.Hour)); at = at.Add(15 * time.Second) {
+		l.failed(logger, at, err, "reason", "request")
+	}
+	l.succeeded(logger, at)
+	l.succeeded(logger, at.Add(15*time.Second))
+
+	records := logRecords(t, buf)
+	// The first failure, a summary every hour after it and the recovery.
+	if len(records) != 1+59+1 {
+		t.Fatalf("expected %d log lines, got %d", 1+59+1, len(records))
+	}
+	if records[0]["msg"] != "Error scraping apache" || records[0]["err"] != "connection refused" || records[0]["reason"] != "request" {
+		t.Errorf("unexpected first line %v", records[0])
+	}
+	for _, r := range records[1:60] {
+		if r["msg"] != "Target still failing" || r["failures"] != 240.0 || r["interval"] != float64(time.Hour) || r["err"] != "connection refused" {
+			t.Errorf("unexpected summary %v", r)
+			break
+		}
+	}
+	if r := records[60]; r["msg"] != "Target recovered" || r["failures"] != 14400.0 || r["down"] != float64(60*time.Hour) {
+		t.Errorf("unexpected recovery %v", r)
+	}
+
+	// A new streak is logged from its first failure again.
+	buf.Reset()
+	l.failed(logger, at.Add(time.Minute), err)
+	if records := logRecords(t, buf); len(records) != 1 || records[0]["msg"] != "Error scraping apache" {
+		t.Errorf("expected the first failure of a new streak, got %v", records)
+	}
+}
+
+func TestFailureLogUnthrottled(t *testing.T) {
+	buf := captureLogs(t, "logfmt")
+	l := &failureLog{}
+	for i := 0; i < 10; i++ {
+		l.failed(logger, time.Now(), errors.New("timeout"))
+	}
+	if n := strings.Count(buf.String(), `msg="Error scraping apache"`); n != 10 {
+		t.Errorf("expected every failure logged, got %d", n)
+	}
+}
+
+func TestScrapeFailuresThrottled(t *testing.T) {
+	buf := captureLogs(t, "logfmt")
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+	e := NewExporter(backend.URL)
+	for i := 0; i < 5; i++ {
+		gatherOnce(Exporters{e})
+	}
+	if n := strings.Count(buf.String(), `msg="Error scraping apache"`); n != 1 {
+		t.Errorf("expected one failure logged, got %d:\n%s", n, buf)
+	}
+	if got := counterValue(t, e.scrapeFailures.WithLabelValues("status")); got != 5 {
+		t.Errorf("expected 5 failures counted, got %v", got)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newURIIndexDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("exporter_scrape_uri_index", "Index of the URI the last successful scrape of apache got the status page from, 0 for the target's uri and from 1 on for its fallback_uris. Only exported for targets with fallback_uris.", nil, labels)
+}
+
+// activeURI is the index of the URI of a target scraped last, kept across
+// reloads like its counters.
+type activeURI struct {
+	mutex sync.Mutex
+	index int
+}
+
+// set records that the URI at index i was scraped, telling whether it is
+// another than the previous one.
+func (a *activeURI) set(i int) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	changed := a.index != i
+	a.index = i
+	return changed
+}
+
+// scrapeURIs returns the URIs to get the status page from, in order.
+func (e *Exporter) scrapeURIs() []string {
+	return append([]string{e.URI}, e.fallbackURIs...)
+}
+
+// fallbackContext bounds ctx to the scrape timeout of a scrape started at
+// start, so that the fallback URIs share the budget of the primary one.
+func (e *Exporter) fallbackContext(ctx context.Context, start time.Time) (context.Context, context.CancelFunc) {
+	if deadline := e.groupDeadline(ctx, start); len(e.fallbackURIs) > 0 && !deadline.IsZero() {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// usedURI records that the status page was got from the URI at index i,
+// logging when it is another than the previous scrape's.
+func (e *Exporter) usedURI(i int, ch chan<- prometheus.Metric) {
+	if len(e.fallbackURIs) == 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.uriIndexDesc, prometheus.GaugeValue, float64(i))
+	if !e.activeURI.set(i) {
+		return
+	}
+	if i == 0 {
+		e.logger.Info("Scraping apache from its uri again")
+	} else {
+		e.logger.Warn("Scraping apache from a fallback uri", "uri", sanitizeURI(e.scrapeURIs()[i]), "index", i)
+	}
+}
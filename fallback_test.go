@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// scrapeValues scrapes the single target of es, returning its values by
+// metric name, the last one of each.
+func scrapeValues(t *testing.T, es Exporters) map[string]float64 {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(es)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()] = metricValue(m)
+		}
+	}
+	return values
+}
+
+// backendOn serves apache24Status on addr, any free port if empty,
+// counting its requests in hits.
+func backendOn(t *testing.T, addr string, hits *int64) *httptest.Server {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(hits, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	s.Listener.Close()
+	s.Listener = l
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestFallbackURIs(t *testing.T) {
+	logs := captureLogs(t, "json")
+	var primaryHits, fallbackHits int64
+	primary := backendOn(t, "", &primaryHits)
+	fallback := backendOn(t, "", &fallbackHits)
+	es, err := newExporters(targetsFromConfig([]config.Target{{
+		Name:         "web01",
+		URI:          primary.URL,
+		FallbackURIs: []string{"http://127.0.0.1:1", fallback.URL},
+	}}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		name              string
+		do                func()
+		index             float64
+		primary, fallback int64
+		switches          int
+	}{
+		{"primary up", func() {}, 0, 1, 0, 0},
+		// The first fallback refuses connections too.
+		{"primary killed", primary.Close, 2, 1, 1, 1},
+		{"primary still down", func() {}, 2, 1, 2, 1},
+		{"primary back", func() { primary = backendOn(t, primary.Listener.Addr().String(), &primaryHits) }, 0, 2, 2, 2},
+	} {
+		step.do()
+		values := scrapeValues(t, es)
+		if values["apache_up"] != 1 {
+			t.Errorf("%s: expected the scrape to succeed, got apache_up %v", step.name, values["apache_up"])
+		}
+		if v, ok := values["apache_exporter_scrape_uri_index"]; !ok || v != step.index {
+			t.Errorf("%s: expected uri index %v, got %v", step.name, step.index, v)
+		}
+		if p, f := atomic.LoadInt64(&primaryHits), atomic.LoadInt64(&fallbackHits); p != step.primary || f != step.fallback {
+			t.Errorf("%s: expected %d and %d requests to the primary and the fallback, got %d and %d", step.name, step.primary, step.fallback, p, f)
+		}
+		if n := strings.Count(logs.String(), "apache from"); n != step.switches {
+			t.Errorf("%s: expected %d switches logged, got %d", step.name, step.switches, n)
+		}
+	}
+	if !strings.Contains(logs.String(), "Scraping apache from a fallback uri") || !strings.Contains(logs.String(), "Scraping apache from its uri again") {
+		t.Errorf("expected the switches logged, got %s", logs)
+	}
+}
+
+func TestFallbackURIsHTTPError(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	var fallbackHits int64
+	fallback := backendOn(t, "", &fallbackHits)
+	es, err := newExporters(targetsFromConfig([]config.Target{{URI: primary.URL, FallbackURIs: []string{fallback.URL}}}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := scrapeValues(t, es)
+	if values["apache_up"] != 0 || fallbackHits != 0 {
+		t.Errorf("expected the answer of the primary to stand, got apache_up %v and %d requests to the fallback", values["apache_up"], fallbackHits)
+	}
+	if _, ok := values["apache_exporter_scrape_uri_index"]; ok {
+		t.Error("expected no uri index without a successful scrape")
+	}
+}
+
+func TestNoFallbackURIs(t *testing.T) {
+	var hits int64
+	server := backendOn(t, "", &hits)
+	es, err := setupExporters([]string{server.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := scrapeValues(t, es)["apache_exporter_scrape_uri_index"]; ok {
+		t.Error("expected no uri index for a target without fallback_uris")
+	}
+}
+
+func TestFallbackURIsPhases(t *testing.T) {
+	var hits int64
+	fallback := backendOn(t, "", &hits)
+	es, err := newExporters(targetsFromConfig([]config.Target{{
+		Name:         "web01",
+		URI:          "http://localhost:1",
+		FallbackURIs: []string{fallback.URL},
+	}}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(es)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	phases := map[string]bool{}
+	for _, mf := range mfs {
+		if mf.GetName() == "apache_exporter_scrape_phase_duration_seconds" {
+			for _, m := range mf.GetMetric() {
+				phases[labelValue(m, "phase")] = true
+			}
+		}
+	}
+	// The lookup of localhost was for the attempt refused, not for the
+	// IP literal of the one answered.
+	if phases["dns"] || !phases["connect"] || !phases["first_byte"] || !phases["body"] {
+		t.Errorf("expected the phases of the fallback alone, got %v", phases)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// statusFixtures are status pages of apache in different setups, served by
+// the mock server and parsed by the tests and -selftest, along with the
+// metrics each should scrape to.
+//
+//go:embed testdata/status/*.txt testdata/status/*.prom
+var statusFixtures embed.FS
+
+// statusFixture returns the status page of the setup named profile.
+func statusFixture(profile string) (string, error) {
+	data, err := statusFixtures.ReadFile(path.Join("testdata/status", profile+".txt"))
+	if err != nil {
+		return "", fmt.Errorf("unknown profile %q, valid profiles are %s", profile, strings.Join(statusProfiles(), ", "))
+	}
+	return string(data), nil
+}
+
+// statusProfiles returns the names of the status fixtures, sorted.
+func statusProfiles() []string {
+	entries, _ := statusFixtures.ReadDir("testdata/status")
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".txt") {
+			names = append(names, strings.TrimSuffix(e.Name(), ".txt"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
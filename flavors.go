@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+var flavorHint = flag.String("apache.flavor-hint", "", "Flavor of apache the targets run, for the fields of the status page to expect: 2.2, 2.4, windows or ohs (Oracle HTTP Server). Missing fields the flavor always shows are logged as warnings, and those it never shows aren't logged. Without a hint every missing field is logged at debug level. The fields parsed are the same whatever the hint.")
+
+// statusPageFields are the fields of the ?auto page the collectors take.
+var statusPageFields = []string{"Total Accesses", "Total kBytes", "Uptime", "BusyWorkers", "IdleWorkers", "Scoreboard"}
+
+// apacheFlavors are the fields of statusPageFields the status page of each
+// flavor always shows. 2.2 leaves ExtendedStatus off by default, and its
+// page is then down to the workers; 2.4 turns it on whenever mod_status is
+// loaded, as do its Windows builds and Oracle HTTP Server 12c.
+var apacheFlavors = map[string][]string{
+	"2.2":     {"BusyWorkers", "IdleWorkers", "Scoreboard"},
+	"2.4":     statusPageFields,
+	"windows": statusPageFields,
+	"ohs":     statusPageFields,
+}
+
+func validateFlavorHint(hint string) error {
+	if _, ok := apacheFlavors[hint]; hint != "" && !ok {
+		var names []string
+		for name := range apacheFlavors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("-apache.flavor-hint must be one of %s, got %q", strings.Join(names, ", "), hint)
+	}
+	return nil
+}
+
+// logMissingFields logs the fields of statusPageFields missing from a
+// parsed status page, by hint: at debug level without one, as a warning if
+// the flavor always shows them and not at all otherwise.
+func logMissingFields(logger *slog.Logger, hint string, values map[string]float64, hasScoreboard bool) {
+	expected := map[string]bool{}
+	for _, f := range apacheFlavors[hint] {
+		expected[f] = true
+	}
+	for _, f := range statusPageFields {
+		if _, ok := values[f]; ok || f == "Scoreboard" && hasScoreboard {
+			continue
+		}
+		switch {
+		case hint == "":
+			logger.Debug("Field missing from the status page", "field", f)
+		case expected[f]:
+			logger.Warn("Field missing from the status page, which the apache flavor always shows", "field", f, "flavor", hint)
+		}
+	}
+}
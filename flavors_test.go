@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+func TestValidateFlavorHint(t *testing.T) {
+	for _, hint := range []string{"", "2.2", "2.4", "windows", "ohs"} {
+		if err := validateFlavorHint(hint); err != nil {
+			t.Errorf("%q: %v", hint, err)
+		}
+	}
+	if err := validateFlavorHint("2.0"); err == nil {
+		t.Error("expected an error for an unknown flavor")
+	}
+}
+
+// TestFlavorHintCorpus scrapes every status fixture with every hint, for
+// the same metrics whatever the hint.
+func TestFlavorHintCorpus(t *testing.T) {
+	defer func(hint string) { *flavorHint = hint }(*flavorHint)
+	for _, profile := range statusProfiles() {
+		*flavorHint = ""
+		want, err := fixtureExposition(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for hint := range apacheFlavors {
+			*flavorHint = hint
+			got, err := fixtureExposition(profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s with hint %s: %s", profile, hint, compareExpositions(got, want))
+			}
+		}
+	}
+}
+
+func TestLogMissingFields(t *testing.T) {
+	defer func(hint string) { *flavorHint = hint }(*flavorHint)
+	for _, tc := range []struct {
+		hint, profile string
+		level         string
+		fields        []string
+	}{
+		// 2.2 with ExtendedStatus off shows only the workers.
+		{"", "prefork-2.2-extended-off", "DEBUG", []string{"Total Accesses", "Total kBytes", "Uptime"}},
+		{"2.2", "prefork-2.2-extended-off", "", nil},
+		{"2.4", "prefork-2.2-extended-off", "WARN", []string{"Total Accesses", "Total kBytes", "Uptime"}},
+		// 2.4 with ExtendedStatus off shows no counters either.
+		{"2.4", "extended-off", "WARN", []string{"Total Accesses", "Total kBytes", "Uptime"}},
+		{"", "event-2.4", "", nil},
+		{"windows", "winnt-2.4", "", nil},
+		{"ohs", "ohs-12c", "", nil},
+	} {
+		buf := captureLogs(t, "json")
+		*flavorHint = tc.hint
+		if _, err := fixtureExposition(tc.profile); err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, r := range logRecords(t, buf) {
+			if r["msg"] != "Field missing from the status page" && r["msg"] != "Field missing from the status page, which the apache flavor always shows" {
+				continue
+			}
+			if r["level"] != tc.level {
+				t.Errorf("%s with hint %q: expected %s, got %v", tc.profile, tc.hint, tc.level, r)
+			}
+			fields = append(fields, r["field"].(string))
+		}
+		sort.Strings(fields)
+		if len(fields) != len(tc.fields) {
+			t.Errorf("%s with hint %q: expected %q missing, got %q", tc.profile, tc.hint, tc.fields, fields)
+			continue
+		}
+		for i := range fields {
+			if fields[i] != tc.fields[i] {
+				t.Errorf("%s with hint %q: expected %q missing, got %q", tc.profile, tc.hint, tc.fields, fields)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// formLogin logs a target in with its form_auth, keeping the session
+// cookie in a jar of its own for the scrapes after.
+type formLogin struct {
+	conf      config.FormAuth
+	loginURL  *url.URL
+	loginPage *url.URL
+	// client posts the form, without following its redirects.
+	client *http.Client
+}
+
+// newFormLogin returns the form login of a target at uri with conf, and
+// a copy of client keeping its session and not following redirects to
+// the login page.
+func newFormLogin(conf config.FormAuth, uri string, client *http.Client) (*formLogin, *http.Client, error) {
+	base, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	loginURL, err := base.Parse(conf.LoginURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	loginPage := loginURL
+	if conf.LoginPage != "" {
+		if loginPage, err = base.Parse(conf.LoginPage); err != nil {
+			return nil, nil, err
+		}
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	f := &formLogin{conf: conf, loginURL: loginURL, loginPage: loginPage}
+	scrape := *client
+	scrape.Jar = jar
+	next := client.CheckRedirect
+	scrape.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if f.isLoginPage(req.URL) {
+			return http.ErrUseLastResponse
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	login := scrape
+	login.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	f.client = &login
+	return f, &scrape, nil
+}
+
+func (f *formLogin) isLoginPage(u *url.URL) bool {
+	return u.Host == f.loginPage.Host && u.Path == f.loginPage.Path
+}
+
+// loginRequired tells whether resp asks to log in: a 401 or 403, or a
+// redirect to the login page.
+func (f *formLogin) loginRequired(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false
+	}
+	location, err := resp.Location()
+	return err == nil && f.isLoginPage(location)
+}
+
+// maxLogins returns the most logins in a scrape.
+func (f *formLogin) maxLogins() int {
+	if f.conf.MaxLogins == 0 {
+		return 1
+	}
+	return f.conf.MaxLogins
+}
+
+// login posts the credentials to the login page, with the headers of e.
+// A 401 or 403, or a redirect back to the login page, is a rejection.
+func (f *formLogin) login(ctx context.Context, e *Exporter) (err error) {
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		e.formLogins.WithLabelValues(result).Inc()
+	}()
+	password, err := f.conf.ReadPassword()
+	if err != nil {
+		return markError(fmt.Errorf("Error reading credentials: %w", err), ErrAuth)
+	}
+	form := url.Values{}
+	form.Set(fieldOr(f.conf.UsernameField, "httpd_username"), f.conf.Username)
+	form.Set(fieldOr(f.conf.PasswordField, "httpd_password"), password)
+	req, err := http.NewRequestWithContext(ctx, "POST", f.loginURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return sanitizeError(err)
+	}
+	e.setHeaders(req)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return markError(fmt.Errorf("Error logging in: %w", sanitizeError(err)), ErrUnreachable)
+	}
+	resp.Body.Close()
+	if f.loginRequired(resp) || resp.StatusCode >= 400 {
+		return markError(fmt.Errorf("Error logging in to %s: %s", sanitizeURI(f.loginURL.String()), resp.Status), ErrAuth)
+	}
+	e.logger.Debug("Logged in", "url", sanitizeURI(f.loginURL.String()))
+	return nil
+}
+
+func fieldOr(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// relogin logs in while resp to req asks to, up to the most logins of a
+// scrape, logins counting those of the scrape so far, and returns the
+// response to req once logged in.
+func (e *Exporter) relogin(ctx context.Context, req *http.Request, resp *http.Response, logins *int) (*http.Response, error) {
+	for e.formLogin.loginRequired(resp) {
+		resp.Body.Close()
+		if *logins >= e.formLogin.maxLogins() {
+			return nil, &scrapeError{reasonLogin, markError(fmt.Errorf("Error scraping apache: asked to log in again after %d logins", *logins), ErrAuth)}
+		}
+		*logins++
+		if err := e.formLogin.login(ctx, e); err != nil {
+			return nil, &scrapeError{reasonLogin, err}
+		}
+		var err error
+		if resp, err = e.client.Do(req.Clone(req.Context())); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
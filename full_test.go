@@ -0,0 +1,40 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// outputFamilies are the families of the outputs compiled in,
+// without the namespace.
+var outputFamilies = []string{
+	"exporter_graphite_failures_total",
+	"exporter_influx_failures_total",
+	"exporter_otlp_failures_total",
+	"exporter_push_failures_total",
+	"exporter_remote_write_dropped_batches_total",
+	"exporter_remote_write_failures_total",
+	"exporter_remote_write_pending_batches",
+	"exporter_statsd_failures_total",
+	"exporter_trace_export_failures_total",
+	"exporter_trace_spans_dropped_total",
+}
+
+func TestFullBuild(t *testing.T) {
+	var discovery, outs []string
+	for _, m := range discoveryMechanisms {
+		discovery = append(discovery, m.name)
+	}
+	for _, o := range outputs {
+		outs = append(outs, o.name)
+	}
+	if want := []string{"apache-config", "consul", "dns-srv", "docker", "ec2", "http", "kubernetes"}; !reflect.DeepEqual(discovery, want) {
+		t.Errorf("expected the discovery mechanisms %v, got %v", want, discovery)
+	}
+	if want := []string{"graphite", "influx", "otlp", "push", "remote-write", "statsd", "tracing"}; !reflect.DeepEqual(outs, want) {
+		t.Errorf("expected the outputs %v, got %v", want, outs)
+	}
+}
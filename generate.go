@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+// descName returns the name of the metric d describes, which the client
+// library only shows in d's String.
+func descName(d *prometheus.Desc) string {
+	const prefix = `fqName: "`
+	s := d.String()
+	i := strings.Index(s, prefix)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(prefix):]
+	return s[:strings.IndexByte(s, '"')]
+}
+
+// collectorName returns the name of the metric c describes first.
+func collectorName(c prometheus.Collector) string {
+	ch := make(chan *prometheus.Desc, 16)
+	c.Describe(ch)
+	close(ch)
+	return descName(<-ch)
+}
+
+// trafficQuery and uptimeQuery query the bytes sent per second and the
+// uptime by the metrics of e that -metrics.compat-mode exports, the
+// corrected ones if both are.
+func trafficQuery(e *Exporter, sel string) string {
+	if compatMode.corrected() {
+		return fmt.Sprintf("rate(%s%s[$__rate_interval])", descName(e.renamedDesc("traffic")), sel)
+	}
+	return fmt.Sprintf("rate(%s%s[$__rate_interval]) * 1024", descName(e.kBytesDesc), sel)
+}
+
+func uptimeQuery(e *Exporter, sel string) string {
+	if compatMode.corrected() {
+		return descName(e.renamedDesc("uptime")) + sel
+	}
+	return descName(e.uptimeDesc) + sel
+}
+
+// renamedDesc returns the description of the corrected metric of the group
+// collector.
+func (e *Exporter) renamedDesc(collector string) *prometheus.Desc {
+	for i, r := range renamedMetrics {
+		if r.collector == collector {
+			return e.renamedDescs[i]
+		}
+	}
+	return nil
+}
+
+// dashboardPanel is a panel of the generated dashboard.
+type dashboardPanel struct {
+	collector string // Whose metrics it shows, "" for the exporter's own.
+	kind      string // Of Grafana panel.
+	title     string
+	unit      string
+	expr      string
+	legend    string
+}
+
+// dashboardPanels are the panels of the dashboard of the metrics of e.
+func dashboardPanels(e *Exporter) []dashboardPanel {
+	sel := `{job=~"$job", instance=~"$instance"}`
+	return []dashboardPanel{
+		{"", "stat", "Up", "short", descName(e.upDesc) + sel, "{{instance}}"},
+		{"uptime", "stat", "Uptime", "s", uptimeQuery(e, sel), "{{instance}}"},
+		{"accesses", "timeseries", "Requests", "reqps", fmt.Sprintf("rate(%s%s[$__rate_interval])", descName(e.accessesDesc), sel), "{{instance}}"},
+		{"traffic", "timeseries", "Traffic", "Bps", trafficQuery(e, sel), "{{instance}}"},
+		{"workers", "timeseries", "Workers", "short", descName(e.workersDesc) + sel, "{{instance}} {{state}}"},
+		{"workers", "timeseries", "Worker utilization", "percentunit", descName(e.utilizationDesc) + sel, "{{instance}}"},
+		{"processes", "timeseries", "Process connections", "short", descName(e.processesDesc) + sel, "{{instance}} {{pid}} {{state}}"},
+		{"", "timeseries", "Scrape duration", "s", descName(e.durationDesc) + sel, "{{instance}}"},
+		{"", "timeseries", "Scrape failures", "short", fmt.Sprintf("sum by (instance, reason) (rate(%s%s[$__rate_interval]))", collectorName(e.scrapeFailures), sel), "{{instance}} {{reason}}"},
+	}
+}
+
+// generateDashboard returns a Grafana dashboard of the metrics of e, with
+// the panels of the groups of apache metrics exported.
+func generateDashboard(e *Exporter) ([]byte, error) {
+	type ref struct {
+		Type string `json:"type"`
+		UID  string `json:"uid"`
+	}
+	datasource := ref{"prometheus", "${datasource}"}
+	type target struct {
+		RefID        string `json:"refId"`
+		Expr         string `json:"expr"`
+		LegendFormat string `json:"legendFormat"`
+	}
+	type panel struct {
+		ID          int                    `json:"id"`
+		Type        string                 `json:"type"`
+		Title       string                 `json:"title"`
+		Datasource  ref                    `json:"datasource"`
+		GridPos     map[string]int         `json:"gridPos"`
+		FieldConfig map[string]interface{} `json:"fieldConfig"`
+		Targets     []target               `json:"targets"`
+	}
+	type variable struct {
+		Name       string `json:"name"`
+		Label      string `json:"label"`
+		Type       string `json:"type"`
+		Query      string `json:"query"`
+		Datasource *ref   `json:"datasource,omitempty"`
+		Refresh    int    `json:"refresh,omitempty"`
+		Multi      bool   `json:"multi,omitempty"`
+		IncludeAll bool   `json:"includeAll,omitempty"`
+	}
+	up := descName(e.upDesc)
+	dashboard := struct {
+		Title         string                `json:"title"`
+		UID           string                `json:"uid"`
+		Tags          []string              `json:"tags"`
+		SchemaVersion int                   `json:"schemaVersion"`
+		Refresh       string                `json:"refresh"`
+		Time          map[string]string     `json:"time"`
+		Templating    map[string][]variable `json:"templating"`
+		Panels        []panel               `json:"panels"`
+	}{
+		Title:         "Apache",
+		UID:           namespace + "-exporter",
+		Tags:          []string{namespace},
+		SchemaVersion: 39,
+		Refresh:       "1m",
+		Time:          map[string]string{"from": "now-6h", "to": "now"},
+		Templating: map[string][]variable{"list": {
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{Name: "job", Label: "Job", Type: "query", Query: fmt.Sprintf("label_values(%s, job)", up), Datasource: &datasource, Refresh: 2, Multi: true, IncludeAll: true},
+			{Name: "instance", Label: "Instance", Type: "query", Query: fmt.Sprintf(`label_values(%s{job=~"$job"}, instance)`, up), Datasource: &datasource, Refresh: 2, Multi: true, IncludeAll: true},
+		}},
+	}
+	y, rowHeight := 0, 0
+	for _, p := range dashboardPanels(e) {
+		if p.collector != "" && !collectorEnabled[p.collector] {
+			continue
+		}
+		// Two panels a row, stats half as high.
+		height := 8
+		if p.kind == "stat" {
+			height = 4
+		}
+		n := len(dashboard.Panels)
+		if n%2 == 0 {
+			y, rowHeight = y+rowHeight, 0
+		}
+		if height > rowHeight {
+			rowHeight = height
+		}
+		dashboard.Panels = append(dashboard.Panels, panel{
+			ID:          n + 1,
+			Type:        p.kind,
+			Title:       p.title,
+			Datasource:  datasource,
+			GridPos:     map[string]int{"x": 12 * (n % 2), "y": y, "w": 12, "h": height},
+			FieldConfig: map[string]interface{}{"defaults": map[string]string{"unit": p.unit}},
+			Targets:     []target{{"A", p.expr, p.legend}},
+		})
+	}
+	data, err := json.MarshalIndent(dashboard, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// alertRule is a Prometheus alerting rule.
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	collector string // Whose metrics it alerts on, "" for the exporter's own.
+}
+
+// alertRules are the alerting rules on the metrics of e.
+func alertRules(e *Exporter) []alertRule {
+	rule := func(collector, name, expr, duration, severity, summary, description string) alertRule {
+		return alertRule{
+			Alert:       name,
+			Expr:        expr,
+			For:         duration,
+			Labels:      map[string]string{"severity": severity},
+			Annotations: map[string]string{"summary": summary, "description": description},
+			collector:   collector,
+		}
+	}
+	return []alertRule{
+		rule("", "ApacheDown", descName(e.upDesc)+" == 0", "5m", "critical",
+			"Apache {{ $labels.instance }} is down",
+			"The status page of {{ $labels.instance }} couldn't be scraped for 5 minutes."),
+		rule("workers", "ApacheWorkersBusy", descName(e.utilizationDesc)+" > 0.9", "10m", "warning",
+			"Apache {{ $labels.instance }} is running out of workers",
+			"{{ $value | humanizePercentage }} of the workers of {{ $labels.instance }} have been busy for 10 minutes."),
+		rule("workers", "ApacheNoIdleWorkers", descName(e.workersDesc)+`{state="idle"} == 0`, "5m", "critical",
+			"Apache {{ $labels.instance }} has no idle workers",
+			"Every worker of {{ $labels.instance }} has been busy for 5 minutes, so new requests wait."),
+		rule("", "ApacheRestarted", fmt.Sprintf("increase(%s[15m]) > 0", collectorName(e.restarts)), "", "info",
+			"Apache {{ $labels.instance }} restarted",
+			"Apache {{ $labels.instance }} restarted in the last 15 minutes."),
+		rule("", "ApacheScrapeFailures", fmt.Sprintf("sum by (job, instance) (rate(%s[5m])) > 0", collectorName(e.scrapeFailures)), "15m", "warning",
+			"Scrapes of apache {{ $labels.instance }} are failing",
+			"Scrapes of the status page of {{ $labels.instance }} have been failing for 15 minutes."),
+	}
+}
+
+// generateAlerts returns Prometheus alerting rules on the metrics of e,
+// those of the groups of apache metrics exported.
+func generateAlerts(e *Exporter) ([]byte, error) {
+	var rules []alertRule
+	for _, r := range alertRules(e) {
+		if r.collector == "" || collectorEnabled[r.collector] {
+			rules = append(rules, r)
+		}
+	}
+	type group struct {
+		Name  string      `yaml:"name"`
+		Rules []alertRule `yaml:"rules"`
+	}
+	return yaml.Marshal(struct {
+		Groups []group `yaml:"groups"`
+	}{[]group{{namespace, rules}}})
+}
+
+// runGenerate runs the generate subcommand with args: dashboard or alerts,
+// then -o and the flags of the exporter, whose metric names, compatibility
+// mode and collectors the output follows.
+func runGenerate(args []string, stdout io.Writer) error {
+	generators := map[string]func(*Exporter) ([]byte, error){
+		"dashboard": generateDashboard,
+		"alerts":    generateAlerts,
+	}
+	if len(args) == 0 || generators[args[0]] == nil {
+		return errors.New("usage: apache_exporter generate dashboard|alerts [-o file] [flags]")
+	}
+	fs := flag.NewFlagSet("generate "+args[0], flag.ContinueOnError)
+	out := fs.String("o", "", "File to write to, instead of stdout.")
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.SetOutput(os.Stderr)
+	if _, err := parseFlags(fs, args[1:], os.Environ()); err != nil {
+		return err
+	}
+	if err := setNamespace(namespace); err != nil {
+		return err
+	}
+	data, err := generators[args[0]](NewExporter("http://localhost/server-status?auto"))
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return ioutil.WriteFile(*out, data, 0644)
+	}
+	_, err = stdout.Write(data)
+	return err
+}
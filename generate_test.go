@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDescName(t *testing.T) {
+	d := prometheus.NewDesc("apache_workers", "Apache worker statuses", []string{"state"}, prometheus.Labels{"dc": "fra"})
+	if got := descName(d); got != "apache_workers" {
+		t.Errorf("expected apache_workers, got %q", got)
+	}
+}
+
+// TestGenerateGolden pins the dashboard and the alerts of the default
+// flags.
+func TestGenerateGolden(t *testing.T) {
+	for _, tc := range []struct {
+		file     string
+		generate func(*Exporter) ([]byte, error)
+	}{
+		{"dashboard.json", generateDashboard},
+		{"alerts.yml", generateAlerts},
+	} {
+		got, err := tc.generate(NewExporter("http://localhost/server-status?auto"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join("testdata", "generate", tc.file)
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := compareExpositions(got, want); err != nil {
+			t.Errorf("%s: %s", tc.file, err)
+		}
+	}
+}
+
+// metricNameRE finds the metric names in the queries of the generated
+// files, all in the namespace.
+var metricNameRE = regexp.MustCompile(`\b[a-z]+_[a-z_]+\b`)
+
+// TestGenerateNames checks every metric the generated files query is one
+// an exporter describes, whatever the flags.
+func TestGenerateNames(t *testing.T) {
+	defer setNamespace("apache")
+	defer func(mode compatModeFlag) { compatMode = mode }(compatMode)
+	defer func(on bool) { collectorEnabled["processes"] = on }(collectorEnabled["processes"])
+	collectorEnabled["processes"] = true
+	for _, ns := range []string{"apache", "ohs"} {
+		for _, mode := range []compatModeFlag{"legacy", "both", "new"} {
+			if err := setNamespace(ns); err != nil {
+				t.Fatal(err)
+			}
+			compatMode = mode
+			e := NewExporter("http://localhost/server-status?auto")
+			described := map[string]bool{}
+			ch := make(chan *prometheus.Desc, 100)
+			e.Describe(ch)
+			close(ch)
+			for d := range ch {
+				described[descName(d)] = true
+			}
+			var queries []string
+			for _, p := range dashboardPanels(e) {
+				queries = append(queries, p.expr)
+			}
+			for _, r := range alertRules(e) {
+				queries = append(queries, r.Expr)
+			}
+			for _, q := range queries {
+				for _, name := range metricNameRE.FindAllString(q, -1) {
+					if strings.HasPrefix(name, ns+"_") && !described[name] {
+						t.Errorf("%s, %s: %q queries %s, which isn't described", ns, mode, q, name)
+					}
+				}
+				if !strings.Contains(q, ns+"_") {
+					t.Errorf("%s, %s: expected %q to query a metric of the namespace", ns, mode, q)
+				}
+			}
+		}
+	}
+}
+
+func TestGenerateFlags(t *testing.T) {
+	defer setNamespace("apache")
+	defer func(mode compatModeFlag) { compatMode = mode }(compatMode)
+	defer func(on bool) { collectorEnabled["workers"] = on }(collectorEnabled["workers"])
+
+	var out bytes.Buffer
+	if err := runGenerate([]string{"dashboard", "-metrics.namespace", "ohs", "-metrics.compat-mode", "new", "-no-collector.workers"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	dashboard := out.String()
+	for _, want := range []string{`ohs_up{`, `rate(ohs_sent_bytes_total{`, `ohs_uptime_seconds{`} {
+		if !strings.Contains(dashboard, want) {
+			t.Errorf("expected the dashboard to query %s", want)
+		}
+	}
+	for _, unwanted := range []string{"apache_", "sent_kilobytes_total", "ohs_workers"} {
+		if strings.Contains(dashboard, unwanted) {
+			t.Errorf("expected the dashboard not to query %s", unwanted)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "alerts.yml")
+	if err := runGenerate([]string{"alerts", "-o", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	alerts, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(alerts), "alert: ApacheDown") || strings.Contains(string(alerts), "ApacheWorkersBusy") {
+		t.Errorf("expected the alerts without the workers ones, got:\n%s", alerts)
+	}
+
+	if err := runGenerate([]string{"grafana"}, &out); err == nil {
+		t.Error("expected an error for an unknown artifact")
+	}
+}
@@ -0,0 +1,99 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/graphite"
+)
+
+var (
+	graphiteAddress  = flag.String("graphite.address", "", "host:port of a Graphite server to send the targets' and the exporter's metrics to every -graphite.interval, in the plaintext protocol.")
+	graphiteInterval = flag.Duration("graphite.interval", time.Minute, "How often to scrape the targets and send them to -graphite.address.")
+	graphitePrefix   = flag.String("graphite.prefix", "", "Prefix of the Graphite paths of the metrics, such as apache.web01.")
+
+	graphiteFailures prometheus.Counter
+)
+
+// graphiteTimeout bounds connecting to Graphite, the default of the
+// graphite bridge.
+const graphiteTimeout = 15 * time.Second
+
+func init() {
+	registerOutput(output{name: "graphite", setup: func() (func(s *targetSet, done <-chan struct{}), error) {
+		if err := validateGraphite(*graphiteAddress, *graphiteInterval); err != nil {
+			return nil, err
+		}
+		if *graphiteAddress == "" {
+			return nil, nil
+		}
+		return func(s *targetSet, done <-chan struct{}) {
+			runGraphite(*graphiteAddress, *graphitePrefix, s, *graphiteInterval, done)
+		}, nil
+	}})
+	selfMetric(func() {
+		graphiteFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "graphite_failures_total",
+			Help:        "Number of failed sends to -graphite.address.",
+			ConstLabels: withConstLabels(nil),
+		})
+		registry.MustRegister(graphiteFailures)
+	})
+}
+
+func validateGraphite(address string, interval time.Duration) error {
+	if address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid -graphite.address: %v", err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("-graphite.interval must be positive, got %s", interval)
+	}
+	return nil
+}
+
+// sendGraphite sends what g gathers to the Graphite server at address
+// with the graphite bridge of client_golang.
+func sendGraphite(address, prefix string, g prometheus.Gatherer) error {
+	b, err := graphite.NewBridge(&graphite.Config{
+		URL:           address,
+		Prefix:        prefix,
+		Timeout:       graphiteTimeout,
+		Gatherer:      g,
+		ErrorHandling: graphite.AbortOnError,
+	})
+	if err != nil {
+		return err
+	}
+	return b.Push()
+}
+
+// runGraphite sends the metrics of the current targets of s to address
+// right away and then every interval, until done is closed. Failures are
+// logged and counted, and the next interval tries again.
+func runGraphite(address, prefix string, s *targetSet, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	d := deltasFromFlags("graphite")
+	for {
+		if err := sendGraphite(address, prefix, deltasGatherer{pushGatherer(s), d}); err != nil {
+			graphiteFailures.Inc()
+			logger.Error("Error sending metrics to Graphite", "address", address, "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
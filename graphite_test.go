@@ -0,0 +1,113 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSendGraphite(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	workers := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "apache_workers", Help: "Workers."}, []string{"target", "state"})
+	workers.WithLabelValues("web01", "busy").Set(3)
+	workers.WithLabelValues("https://web02:8443/server-status", "idle").Set(0.5)
+	reg.MustRegister(workers)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			// Leave out the timestamp.
+			line := scanner.Text()
+			lines = append(lines, line[:strings.LastIndex(line, " ")])
+		}
+		received <- lines
+	}()
+	if err := sendGraphite(l.Addr().String(), "dc1.apache", reg); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"dc1.apache.apache_workers.state.busy.target.web01 3",
+		"dc1.apache.apache_workers.state.idle.target.https:_web02:8443_server-status 0.5",
+	}
+	if got := <-received; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRunGraphite(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	// Nothing listens at first; the next interval tries again.
+	failures := counterValue(t, graphiteFailures)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		runGraphite(address, "apache", newTargetSet(Exporters{NewExporter(ts.URL)}, nil), 50*time.Millisecond, done)
+		close(stopped)
+	}()
+	waitFor(t, "a failed send", func() bool { return counterValue(t, graphiteFailures) > failures })
+
+	l, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	conn.Close()
+	close(done)
+	<-stopped
+
+	var up, busy bool
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Errorf("malformed line %q", line)
+			continue
+		}
+		up = up || fields[0] == "apache.apache_up" && fields[1] == "1"
+		busy = busy || fields[0] == "apache.apache_workers.state.busy"
+	}
+	if !up || !busy {
+		t.Errorf("expected apache_up and the workers in\n%s", strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Readiness states.
+const (
+	stateStarting int32 = iota
+	stateReady
+	stateStopping
+)
+
+// readiness tracks whether the exporter is ready to be scraped.
+type readiness struct {
+	state int32
+}
+
+func (r *readiness) set(state int32) {
+	atomic.StoreInt32(&r.state, state)
+}
+
+// readyHandler is 200 once setup is done and until shutdown begins, and
+// 503 otherwise. It doesn't scrape anything.
+func readyHandler(r *readiness) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch atomic.LoadInt32(&r.state) {
+		case stateReady:
+			w.Write([]byte("Ready\n"))
+		case stateStarting:
+			http.Error(w, "Starting", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// healthzHandler is 200 as long as the server is serving at all.
+func healthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK\n"))
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	r := &readiness{}
+	h := readyHandler(r)
+	for _, tc := range []struct {
+		state int32
+		code  int
+	}{
+		{stateStarting, http.StatusServiceUnavailable},
+		{stateReady, http.StatusOK},
+		{stateStopping, http.StatusServiceUnavailable},
+	} {
+		r.set(tc.state)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/-/ready", nil))
+		if rr.Code != tc.code {
+			t.Errorf("state %d: expected %d, got %d: %s", tc.state, tc.code, rr.Code, rr.Body)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	healthzHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected /healthz to be 200, got %d", rr.Code)
+	}
+}
+
+func TestHealthWithoutAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &readiness{}
+	r.set(stateReady)
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthzHandler())
+	mux.Handle("/-/ready", readyHandler(r))
+	mux.Handle("/metrics", healthzHandler())
+	h, err := newBasicAuth("prometheus", path, webAuthExempt.values, mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, code := range map[string]int{"/healthz": http.StatusOK, "/-/ready": http.StatusOK, "/metrics": http.StatusUnauthorized} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	healthcheck        = flag.Bool("healthcheck", false, "Check whether the exporter run with the same -web.listen-address and -web.tls.cert-file is ready, then exit 0 if it is and 1 otherwise. For container health checks.")
+	healthcheckTimeout = flag.Duration("healthcheck.timeout", 5*time.Second, "How long -healthcheck waits for the exporter to answer.")
+)
+
+// checkHealth asks the exporter listening on address under prefix, with
+// HTTPS if useTLS, whether it is ready.
+func checkHealth(address, prefix string, useTLS bool, timeout time.Duration) error {
+	transport := &http.Transport{}
+	base := ""
+	if strings.HasPrefix(address, "unix://") {
+		path := strings.TrimPrefix(address, "unix://")
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}
+		base = "localhost"
+	} else {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			host = "localhost"
+		}
+		base = net.JoinHostPort(host, port)
+	}
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+		// The certificate is for the name clients know the exporter by,
+		// which needn't be the one it is checked on.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	defer transport.CloseIdleConnections()
+
+	resp, err := client.Get(scheme + "://" + base + prefix + "/-/ready")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s/-/ready answered %s", base, prefix, resp.Status)
+	}
+	return nil
+}
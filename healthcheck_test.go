@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveReadiness(t *testing.T, l net.Listener, r *readiness, tc *tls.Config) {
+	mux := http.NewServeMux()
+	mux.Handle("/-/ready", readyHandler(r))
+	server := &http.Server{Handler: mux, TLSConfig: tc}
+	t.Cleanup(func() { server.Close() })
+	if tc != nil {
+		go server.ServeTLS(l, "", "")
+	} else {
+		go server.Serve(l)
+	}
+}
+
+func TestHealthcheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &readiness{}
+	serveReadiness(t, l, r, nil)
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	for _, address := range []string{l.Addr().String(), ":" + port} {
+		if err := checkHealth(address, "", false, time.Second); err == nil || !strings.Contains(err.Error(), "503") {
+			t.Errorf("%s: expected a starting exporter to be unhealthy, got %v", address, err)
+		}
+	}
+	r.set(stateReady)
+	for _, address := range []string{l.Addr().String(), ":" + port, "0.0.0.0:" + port} {
+		if err := checkHealth(address, "", false, time.Second); err != nil {
+			t.Errorf("%s: expected a ready exporter to be healthy, got %s", address, err)
+		}
+	}
+	r.set(stateStopping)
+	if err := checkHealth(l.Addr().String(), "", false, time.Second); err == nil {
+		t.Error("expected a stopping exporter to be unhealthy")
+	}
+}
+
+func TestHealthcheckTLSAndUnixSocket(t *testing.T) {
+	r := &readiness{}
+	r.set(stateReady)
+
+	cert := newTestCert(t, "exporter", nil, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveReadiness(t, l, r, &tls.Config{Certificates: []tls.Certificate{cert.tlsCertificate()}})
+	if err := checkHealth(l.Addr().String(), "", true, time.Second); err != nil {
+		t.Errorf("expected the check over HTTPS to pass, got %s", err)
+	}
+	if err := checkHealth(l.Addr().String(), "", false, time.Second); err == nil {
+		t.Error("expected plain HTTP to an HTTPS exporter to fail")
+	}
+
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apache_exporter.sock")
+	ul, err := listenAddress("unix://"+path, "0600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveReadiness(t, ul, r, nil)
+	if err := checkHealth("unix://"+path, "", false, time.Second); err != nil {
+		t.Errorf("expected the check over the socket to pass, got %s", err)
+	}
+}
+
+func TestHealthcheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})}
+	defer server.Close()
+	go server.Serve(l)
+
+	start := time.Now()
+	if err := checkHealth(l.Addr().String(), "", false, 100*time.Millisecond); err == nil {
+		t.Error("expected a wedged exporter to be unhealthy")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the check to give up after its timeout, took %s", d)
+	}
+}
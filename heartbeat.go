@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var heartbeatPath = flag.String("collector.heartbeat.path", "/hb.dat", "Path, on the host of each target, serving the mod_heartmonitor HeartbeatStorage file the heartbeat group of apache metrics is read from, for instance with an Alias to it.")
+
+// heartbeatDescs describe the metrics of the origin servers heard from by
+// mod_heartmonitor.
+type heartbeatDescs struct {
+	ready    *prometheus.Desc
+	busy     *prometheus.Desc
+	lastSeen *prometheus.Desc
+}
+
+func newHeartbeatDescs(labels prometheus.Labels) heartbeatDescs {
+	return heartbeatDescs{
+		ready:    newDesc("heartbeat_ready", "Ready workers of the origin server as of its latest heartbeat.", []string{"server"}, labels),
+		busy:     newDesc("heartbeat_busy", "Busy workers of the origin server as of its latest heartbeat.", []string{"server"}, labels),
+		lastSeen: newDesc("heartbeat_last_seen_timestamp_seconds", "When the latest heartbeat of the origin server was received.", []string{"server"}, labels),
+	}
+}
+
+// heartbeat is an origin server as listed by mod_heartmonitor.
+type heartbeat struct {
+	server   string // Its address, with the port if given.
+	ready    float64
+	busy     float64
+	lastSeen time.Time
+}
+
+// parseHeartbeats parses the lines of a HeartbeatStorage file, as in
+//
+//	10.0.0.2 &ready=75&busy=0&lastseen=2&port=80
+//
+// where lastseen counts the seconds before written. Lines that don't parse
+// are skipped, their number returned, as are servers listed again.
+func parseHeartbeats(data string, written time.Time) ([]heartbeat, int) {
+	var heartbeats []heartbeat
+	listed := map[string]bool{}
+	skipped := 0
+	for _, l := range strings.Split(data, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		hb, ok := parseHeartbeat(l, written)
+		if !ok || listed[hb.server] {
+			skipped++
+			continue
+		}
+		listed[hb.server] = true
+		heartbeats = append(heartbeats, hb)
+	}
+	return heartbeats, skipped
+}
+
+func parseHeartbeat(l string, written time.Time) (heartbeat, bool) {
+	fields := strings.Fields(l)
+	if len(fields) != 2 || net.ParseIP(fields[0]) == nil {
+		return heartbeat{}, false
+	}
+	q, err := url.ParseQuery(strings.TrimPrefix(fields[1], "&"))
+	if err != nil {
+		return heartbeat{}, false
+	}
+	hb := heartbeat{server: fields[0]}
+	for _, f := range []struct {
+		name  string
+		value *float64
+	}{{"ready", &hb.ready}, {"busy", &hb.busy}} {
+		v, err := strconv.ParseUint(q.Get(f.name), 10, 32)
+		if err != nil {
+			return heartbeat{}, false
+		}
+		*f.value = float64(v)
+	}
+	ago, err := strconv.ParseInt(q.Get("lastseen"), 10, 64)
+	if err != nil || ago < 0 {
+		return heartbeat{}, false
+	}
+	hb.lastSeen = written.Add(-time.Duration(ago) * time.Second)
+	if port := q.Get("port"); port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return heartbeat{}, false
+		}
+		hb.server = net.JoinHostPort(hb.server, port)
+	}
+	return hb, true
+}
+
+// collectHeartbeat fetches the HeartbeatStorage file of e from
+// -collector.heartbeat.path and sends the metrics of every origin server in
+// it to ch, those not heard from in a long time too. Without the file,
+// answered with 404, there is nothing to send, and it isn't asked for again
+// until -capabilities.recheck-interval is over.
+func (e *Exporter) collectHeartbeat(ctx context.Context, ch chan<- prometheus.Metric) error {
+	now := time.Now()
+	if e.capabilities.absent(capHeartbeat, now) {
+		return nil
+	}
+	u, err := url.Parse(e.URI)
+	if err != nil {
+		return err
+	}
+	resp, data, err := e.fetchPage(ctx, u.ResolveReference(&url.URL{Path: *heartbeatPath}))
+	if err == nil {
+		e.capabilities.record(capHeartbeat, resp != nil, now)
+	}
+	if resp == nil {
+		return err
+	}
+
+	written := time.Now()
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && t.Before(written) {
+		written = t
+	}
+	heartbeats, skipped := parseHeartbeats(string(data), written)
+	if skipped > 0 {
+		e.logger.Debug("Skipped lines of the heartbeat file that failed to parse", "lines", skipped)
+	}
+	for _, hb := range heartbeats {
+		ch <- prometheus.MustNewConstMetric(e.heartbeat.ready, prometheus.GaugeValue, hb.ready, hb.server)
+		ch <- prometheus.MustNewConstMetric(e.heartbeat.busy, prometheus.GaugeValue, hb.busy, hb.server)
+		ch <- prometheus.MustNewConstMetric(e.heartbeat.lastSeen, prometheus.GaugeValue, float64(hb.lastSeen.UnixNano())/1e9, hb.server)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseHeartbeats(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "heartbeat", "hb.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	heartbeats, skipped := parseHeartbeats(string(data)+`
+# comment
+10.0.0.5 &ready=x&busy=0&lastseen=1&port=80
+10.0.0.6 ready=1
+not-an-ip &ready=1&busy=0&lastseen=1&port=80
+10.0.0.7 &ready=1&busy=0&lastseen=1&port=99999
+10.0.0.2 &ready=1&busy=1&lastseen=0&port=80
+10.0.0.8 &ready=3&busy=1&lastseen=0
+`, written)
+	if skipped != 5 {
+		t.Errorf("expected 5 lines skipped, got %d", skipped)
+	}
+	want := []heartbeat{
+		{"10.0.0.2:80", 75, 5, written.Add(-time.Second)},
+		{"10.0.0.3:8080", 0, 80, written.Add(-3 * time.Second)},
+		// Stale, but still listed.
+		{"10.0.0.4:80", 64, 0, written.Add(-time.Hour)},
+		{"10.0.0.8", 3, 1, written},
+	}
+	if len(heartbeats) != len(want) {
+		t.Fatalf("expected %v, got %v", want, heartbeats)
+	}
+	for i, hb := range heartbeats {
+		if hb != want[i] {
+			t.Errorf("expected %v, got %v", want[i], hb)
+		}
+	}
+}
+
+func TestCollectHeartbeat(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"heartbeat": true}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "heartbeat", "hb.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != *heartbeatPath {
+			w.Write([]byte(apache24Status))
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, "nope", status)
+			return
+		}
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	gather := func() string {
+		rr := httptest.NewRecorder()
+		metricsHandler(newTargetSet(Exporters{NewExporter(server.URL + "/server-status?auto")}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	body := gather()
+	for _, want := range []string{
+		`apache_heartbeat_ready{server="10.0.0.2:80"} 75`,
+		`apache_heartbeat_busy{server="10.0.0.3:8080"} 80`,
+		`apache_exporter_collector_success{collector="heartbeat"} 1`,
+		"apache_up 1",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in\n%s", want, body)
+		}
+	}
+	// Taken back from when the file was written.
+	var lastSeen float64
+	for _, l := range strings.Split(body, "\n") {
+		if v := strings.TrimPrefix(l, `apache_heartbeat_last_seen_timestamp_seconds{server="10.0.0.4:80"} `); v != l {
+			lastSeen, _ = strconv.ParseFloat(v, 64)
+		}
+	}
+	if want := float64(modified.Add(-time.Hour).Unix()); lastSeen != want {
+		t.Errorf("expected 10.0.0.4:80 last seen at %v, got %v", want, lastSeen)
+	}
+
+	// Without the handler, there is nothing to export.
+	status = http.StatusNotFound
+	body = gather()
+	if strings.Contains(body, "apache_heartbeat_") || !strings.Contains(body, `apache_exporter_collector_success{collector="heartbeat"} 1`) {
+		t.Errorf("expected no heartbeats and no failure without the handler in\n%s", body)
+	}
+
+	// Failing to fetch it fails the group only.
+	status = http.StatusForbidden
+	body = gather()
+	if !strings.Contains(body, `apache_exporter_collector_success{collector="heartbeat"} 0`) || !strings.Contains(body, "apache_up 1") {
+		t.Errorf("expected the heartbeat group to fail but not the scrape in\n%s", body)
+	}
+}
+
+func TestHeartbeatFetchContext(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"heartbeat": true}
+	hang := false
+	cancelled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != *heartbeatPath {
+			w.Write([]byte(apache24Status))
+			return
+		}
+		if hang {
+			<-r.Context().Done()
+			cancelled <- struct{}{}
+			return
+		}
+		w.Write([]byte("v=1&ready=75&busy=0\n"))
+	}))
+	defer server.Close()
+
+	// Traced under the span of the collector.
+	r := recordSpans(t, 1)
+	metricsHandler(newTargetSet(Exporters{NewExporter(server.URL)}, nil)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	var requests []*span
+	for _, root := range r.children(nil, "collect") {
+		for _, scrape := range r.children(root, "scrape") {
+			for _, c := range r.children(scrape, "collector") {
+				if c.attribute("apache.collector") == "heartbeat" {
+					requests = append(requests, r.children(c, "GET")...)
+				}
+			}
+		}
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected a request span under the heartbeat collector, got %d", len(requests))
+	}
+	if s := requests[0]; s.kind != spanKindClient || s.attribute("http.response.status_code") != "200" || !strings.HasSuffix(s.attribute("url.full"), *heartbeatPath) {
+		t.Errorf("unexpected request span %+v", s)
+	}
+
+	// Cut off by the deadline of the scrape, the fetch is cancelled.
+	hang = true
+	e := NewExporter(server.URL)
+	e.client.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for range ch {
+		}
+	}()
+	e.collectTarget(ctx, ch)
+	close(ch)
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the heartbeat fetch to be cancelled with the scrape")
+	}
+}
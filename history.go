@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var scrapeHistorySize = flag.Int("debug.scrape-history", 50, "Scrapes of each target to keep a summary of for /debug/scrapes. 0 keeps none and turns /debug/scrapes off.")
+
+func validateScrapeHistory(size int) error {
+	if size < 0 {
+		return fmt.Errorf("-debug.scrape-history can't be negative, got %d", size)
+	}
+	return nil
+}
+
+// scrapeSummary is a scrape of a target in /debug/scrapes. The values of
+// the status page are 0 if the scrape failed.
+type scrapeSummary struct {
+	Time        time.Time `json:"time"`
+	Duration    float64   `json:"duration"`
+	Up          bool      `json:"up"`
+	BusyWorkers float64   `json:"busyWorkers"`
+	IdleWorkers float64   `json:"idleWorkers"`
+	// AccessesDelta is the increase of the total accesses since the
+	// previous successful scrape, all of them if apache restarted.
+	AccessesDelta float64 `json:"accessesDelta"`
+	Error         string  `json:"error"`
+}
+
+// scrapeHistory keeps the summaries of a target's latest scrapes in a ring
+// of fixed size, overwriting the oldest.
+type scrapeHistory struct {
+	mutex    sync.Mutex
+	ring     []scrapeSummary
+	next     int  // Where the next summary goes.
+	full     bool // Whether the ring has wrapped around.
+	accesses float64
+	seen     bool // Whether accesses is of an earlier scrape.
+}
+
+// newScrapeHistory returns a history of size scrapes, nil if 0.
+func newScrapeHistory(size int) *scrapeHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &scrapeHistory{ring: make([]scrapeSummary, size)}
+}
+
+// add takes in the scrape started at, with status being what the status
+// page said if it succeeded.
+func (h *scrapeHistory) add(at time.Time, duration time.Duration, err error, status *serverStatus) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := scrapeSummary{Time: at, Duration: duration.Seconds(), Up: err == nil}
+	if err != nil {
+		s.Error = err.Error()
+	} else if status != nil {
+		s.BusyWorkers, s.IdleWorkers = status.BusyWorkers, status.IdleWorkers
+		if h.seen {
+			if s.AccessesDelta = status.TotalAccesses - h.accesses; s.AccessesDelta < 0 {
+				s.AccessesDelta = status.TotalAccesses
+			}
+		}
+		h.accesses, h.seen = status.TotalAccesses, true
+	}
+	h.ring[h.next] = s
+	if h.next++; h.next == len(h.ring) {
+		h.next, h.full = 0, true
+	}
+}
+
+// summaries returns the scrapes kept, oldest first.
+func (h *scrapeHistory) summaries() []scrapeSummary {
+	if h == nil {
+		return []scrapeSummary{}
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.full {
+		return append([]scrapeSummary{}, h.ring[:h.next]...)
+	}
+	return append(append([]scrapeSummary{}, h.ring[h.next:]...), h.ring[:h.next]...)
+}
+
+// targetHistory is a target in /debug/scrapes.
+type targetHistory struct {
+	Name    string          `json:"name"`
+	Scrapes []scrapeSummary `json:"scrapes"`
+}
+
+// scrapeHistoryHandler serves the latest scrapes of the targets of s as
+// JSON, oldest first.
+func scrapeHistoryHandler(s *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets := []targetHistory{}
+		for _, e := range s.current() {
+			targets = append(targets, targetHistory{Name: e.name, Scrapes: e.history.summaries()})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"targets": targets},
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScrapeHistory(t *testing.T) {
+	h := newScrapeHistory(3)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, accesses := range []float64{100, 110, 0, 130, 5} {
+		at := start.Add(time.Duration(i) * time.Minute)
+		if accesses == 0 {
+			h.add(at, time.Second, errors.New("connection refused"), nil)
+			continue
+		}
+		h.add(at, time.Second, nil, &serverStatus{TotalAccesses: accesses, BusyWorkers: float64(i)})
+	}
+	got := h.summaries()
+	want := []scrapeSummary{
+		{Time: start.Add(2 * time.Minute), Duration: 1, Error: "connection refused"},
+		{Time: start.Add(3 * time.Minute), Duration: 1, Up: true, BusyWorkers: 3, AccessesDelta: 20},
+		// Apache restarted.
+		{Time: start.Add(4 * time.Minute), Duration: 1, Up: true, BusyWorkers: 4, AccessesDelta: 5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected the latest %d scrapes, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("scrape %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestScrapeHistoryDisabled(t *testing.T) {
+	h := newScrapeHistory(0)
+	if h != nil {
+		t.Fatal("expected no history with -debug.scrape-history 0")
+	}
+	h.add(time.Now(), time.Second, nil, &serverStatus{})
+	if s := h.summaries(); len(s) != 0 {
+		t.Errorf("expected no scrapes, got %v", s)
+	}
+	if err := validateScrapeHistory(-1); err == nil {
+		t.Error("expected a negative size to be rejected")
+	}
+}
+
+func TestScrapeHistoryHandler(t *testing.T) {
+	h := newScrapeHistory(2)
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.add(at, 250*time.Millisecond, nil, &serverStatus{TotalAccesses: 100, BusyWorkers: 3, IdleWorkers: 7})
+	h.add(at.Add(time.Minute), 2*time.Second, errors.New("Status 503"), nil)
+	e := NewExporter("http://localhost/server-status?auto")
+	e.name = "web01"
+	e.history = h
+
+	rr := httptest.NewRecorder()
+	scrapeHistoryHandler(newTargetSet(Exporters{e}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/debug/scrapes", nil))
+	want := `{"data":{"targets":[{"name":"web01","scrapes":[` +
+		`{"time":"2026-01-01T12:00:00Z","duration":0.25,"up":true,"busyWorkers":3,"idleWorkers":7,"accessesDelta":0,"error":""},` +
+		`{"time":"2026-01-01T12:01:00Z","duration":2,"up":false,"busyWorkers":0,"idleWorkers":0,"accessesDelta":0,"error":"Status 503"}` +
+		`]}]},"status":"success"}`
+	if got := strings.TrimSpace(rr.Body.String()); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %s", ct)
+	}
+}
+
+func TestExporterHistory(t *testing.T) {
+	apache := &statusServer{}
+	ts := httptest.NewServer(apache)
+	defer ts.Close()
+	e := NewExporter(ts.URL)
+	e.history = newScrapeHistory(5)
+	for _, accesses := range []string{"100", "135"} {
+		apache.set(accesses, "300")
+		if err := e.Warmup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := e.history.summaries()
+	if len(s) != 2 || !s[1].Up || s[1].AccessesDelta != 35 {
+		t.Errorf("expected both scrapes with the accesses in between, got %+v", s)
+	}
+}
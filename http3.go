@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+var (
+	httpVersion   = flag.String("scrape.http-version", "auto", "HTTP version to scrape apache with: auto negotiates HTTP/2 or HTTP/1.1, 1.1 is as -scrape.force-http1 and 3 scrapes https targets over HTTP/3 (QUIC). Targets given as h3://host/... are scraped over HTTP/3 whatever the version.")
+	http3Fallback = flag.Bool("scrape.http3-fallback", false, "Scrape HTTP/3 targets over TCP, with HTTP/2 or HTTP/1.1, when a QUIC connection to them can't be established, instead of failing the scrape.")
+)
+
+// newQUICTransport returns the HTTP/3 transport of a client dialing with
+// d, nil in builds without HTTP/3.
+var newQUICTransport func(tlsConfig *tls.Config, d *dialer, cfg clientConfig) http.RoundTripper
+
+func validateHTTPVersion(version string) error {
+	switch version {
+	case "", "auto", "1.1":
+		return nil
+	case "3":
+		if newQUICTransport == nil {
+			return errors.New("-scrape.http-version=3 isn't supported by this build of the exporter")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid -scrape.http-version %q, must be auto, 1.1 or 3", version)
+}
+
+// quicDialError is a QUIC connection that couldn't be established.
+type quicDialError struct {
+	err error
+}
+
+func (e *quicDialError) Error() string {
+	return fmt.Sprintf("QUIC connection failed: %v", e.err)
+}
+
+func (e *quicDialError) Unwrap() error {
+	return e.err
+}
+
+// http3Transport sends requests for h3 URLs, as https ones, over quic, and
+// with all, those for https ones too. Others go over tcp, as do those
+// quic can't connect for with fallback.
+type http3Transport struct {
+	quic, tcp http.RoundTripper
+	all       bool
+	fallback  bool
+}
+
+func (t *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch {
+	case req.URL.Scheme == "h3":
+		// RoundTrippers must not modify the caller's request.
+		u := *req.URL
+		u.Scheme = "https"
+		req = req.Clone(req.Context())
+		req.URL = &u
+	case req.URL.Scheme != "https" || !t.all:
+		return t.tcp.RoundTrip(req)
+	}
+	resp, err := t.quic.RoundTrip(req)
+	var dialErr *quicDialError
+	if err != nil && t.fallback && errors.As(err, &dialErr) {
+		logger.Debug("Scraping over TCP, as QUIC failed", "target", sanitizeURI(req.URL.String()), "err", err)
+		return t.tcp.RoundTrip(req)
+	}
+	return resp, err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateHTTPVersion(t *testing.T) {
+	for _, version := range []string{"auto", "1.1"} {
+		if err := validateHTTPVersion(version); err != nil {
+			t.Errorf("%s: %s", version, err)
+		}
+	}
+	if err := validateHTTPVersion("3"); (err == nil) != (newQUICTransport != nil) {
+		t.Errorf("expected HTTP/3 to be valid only with it compiled in, got %v", err)
+	}
+	if err := validateHTTPVersion("2"); err == nil {
+		t.Error("expected an error for HTTP/2")
+	}
+}
+
+// recordingTransport records the URLs of its requests, failing them with
+// err if set.
+type recordingTransport struct {
+	urls []string
+	err  error
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.urls = append(t.urls, req.URL.String())
+	if t.err != nil {
+		return nil, t.err
+	}
+	return &http.Response{StatusCode: 200, Body: http.NoBody, Request: req}, nil
+}
+
+func TestHTTP3Transport(t *testing.T) {
+	for _, tc := range []struct {
+		all, fallback bool
+		quicErr       error
+		uri           string
+		quic, tcp     string
+	}{
+		{false, false, nil, "h3://web01/server-status?auto", "https://web01/server-status?auto", ""},
+		{false, false, nil, "https://web01/server-status?auto", "", "https://web01/server-status?auto"},
+		{true, false, nil, "https://web01/server-status?auto", "https://web01/server-status?auto", ""},
+		{true, false, nil, "http://web01/server-status?auto", "", "http://web01/server-status?auto"},
+		// Falling back only if asked to, and only for QUIC that didn't
+		// connect.
+		{false, true, &quicDialError{errors.New("timeout: no recent network activity")}, "h3://web01/", "https://web01/", "https://web01/"},
+		{false, false, &quicDialError{errors.New("timeout: no recent network activity")}, "h3://web01/", "https://web01/", ""},
+		{false, true, errors.New("stream reset"), "h3://web01/", "https://web01/", ""},
+	} {
+		quic, tcp := &recordingTransport{err: tc.quicErr}, &recordingTransport{}
+		transport := &http3Transport{quic: quic, tcp: tcp, all: tc.all, fallback: tc.fallback}
+		req, err := http.NewRequest("GET", tc.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.RoundTrip(req)
+		if got := strings.Join(quic.urls, " "); got != tc.quic {
+			t.Errorf("%s: expected %q over QUIC, got %q", tc.uri, tc.quic, got)
+		}
+		if got := strings.Join(tcp.urls, " "); got != tc.tcp {
+			t.Errorf("%s: expected %q over TCP, got %q", tc.uri, tc.tcp, got)
+		}
+		if req.URL.Scheme != strings.SplitN(tc.uri, ":", 2)[0] {
+			t.Errorf("%s: the request was modified", tc.uri)
+		}
+	}
+}
+
+func TestQUICFailureReason(t *testing.T) {
+	err := &url.Error{Op: "Get", URL: "h3://web01/", Err: &quicDialError{errors.New("CRYPTO_ERROR 0x12a (remote): tls: bad certificate")}}
+	if reason := requestFailureReason(err, phaseTimes{}); reason != reasonQUICHandshake {
+		t.Errorf("expected %s, got %s", reasonQUICHandshake, reason)
+	}
+}
+
+func TestValidateH3URI(t *testing.T) {
+	err := validateScrapeURI("h3://web01/server-status?auto")
+	if (err == nil) != (newQUICTransport != nil) {
+		t.Errorf("expected h3 URIs to be valid only with HTTP/3 compiled in, got %v", err)
+	}
+}
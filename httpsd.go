@@ -0,0 +1,239 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	httpSDURL             = flag.String("discovery.http-url", "", "URL serving the targets to scrape as JSON in the Prometheus http_sd format, such as a CMDB endpoint. Disabled if empty.")
+	httpSDUsername        = flag.String("discovery.http.username", "", "Username to authenticate to -discovery.http-url with, with HTTP basic auth.")
+	httpSDPasswordFile    = flag.String("discovery.http.password-file", "", "File containing the password of -discovery.http.username.")
+	httpSDBearerTokenFile = flag.String("discovery.http.bearer-token-file", "", "File containing a bearer token to authenticate to -discovery.http-url with.")
+	httpSDCAFile          = flag.String("discovery.http.tls.ca-file", "", "CA certificate to verify -discovery.http-url with.")
+	httpSDCertFile        = flag.String("discovery.http.tls.cert-file", "", "Client certificate to present to -discovery.http-url.")
+	httpSDKeyFile         = flag.String("discovery.http.tls.key-file", "", "Key of -discovery.http.tls.cert-file.")
+)
+
+func init() {
+	registerDiscovery(discoveryMechanism{
+		name:    "http",
+		enabled: func() bool { return *httpSDURL != "" },
+		setup: func(newContext func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error) {
+			d, err := newHTTPSDDiscovery(*httpSDURL, httpSDConfigFromFlags())
+			if err != nil {
+				return nil, nil, fmt.Errorf("-discovery.http-url: %v", err)
+			}
+			ctx, cancel := newContext()
+			if _, err := d.refresh(ctx); err != nil {
+				logger.Error("Error fetching the targets", "url", sanitizeURI(d.url), "err", err)
+			}
+			cancel()
+			registry.MustRegister(d)
+			return d, func(changed func()) {
+				d.run(*refreshInterval, changed, done)
+			}, nil
+		},
+	})
+}
+
+// httpSDConfigFromFlags returns how to connect to -discovery.http-url, in
+// the terms of the scrape client.
+func httpSDConfigFromFlags() config.HTTPConfig {
+	h := config.HTTPConfig{BearerTokenFile: *httpSDBearerTokenFile}
+	if *httpSDUsername != "" {
+		h.BasicAuth = &config.BasicAuth{Username: *httpSDUsername, PasswordFile: *httpSDPasswordFile}
+	}
+	if *httpSDCAFile != "" || *httpSDCertFile != "" || *httpSDKeyFile != "" {
+		h.TLSConfig = &config.TLSConfig{CAFile: *httpSDCAFile, CertFile: *httpSDCertFile, KeyFile: *httpSDKeyFile}
+	}
+	return h
+}
+
+// httpSDDiscovery finds targets in a list served over HTTP in the
+// Prometheus http_sd format, fetching it every refresh unless unchanged by
+// its ETag, and keeping the last known targets whenever that fails.
+type httpSDDiscovery struct {
+	url    string
+	user   *url.Userinfo
+	conf   config.HTTPConfig
+	client *http.Client
+
+	mutex   sync.Mutex
+	etag    string
+	current []target
+
+	discovered  prometheus.Gauge
+	lastRefresh prometheus.Gauge
+	failures    prometheus.Counter
+}
+
+func newHTTPSDDiscovery(rawURL string, conf config.HTTPConfig) (*httpSDDiscovery, error) {
+	rawURL, user := splitUserinfo(rawURL)
+	if user != nil && (conf.BasicAuth != nil || conf.BearerTokenFile != "") {
+		return nil, fmt.Errorf("credentials given both in the URL and by flags")
+	}
+	cfg := clientConfigFromFlags()
+	// The context of each refresh bounds how long it takes instead.
+	cfg.timeout = 0
+	if conf.TLSConfig != nil {
+		tc, err := conf.TLSConfig.ClientConfig(*insecure)
+		if err != nil {
+			return nil, err
+		}
+		cfg.tls = tc
+	}
+	d := &httpSDDiscovery{
+		url:    rawURL,
+		user:   user,
+		conf:   conf,
+		client: newHTTPClient(cfg),
+		discovered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_http_targets",
+			Help:        "Number of targets discovered through -discovery.http-url.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_http_last_refresh_success_timestamp_seconds",
+			Help:        "Timestamp of the last successful fetch of -discovery.http-url, unchanged or not.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_http_failures_total",
+			Help:        "Number of failed fetches of -discovery.http-url.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+	// Fail on unreadable credential files now rather than on every refresh.
+	if err := authorize(&http.Request{Header: http.Header{}}, user, conf); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// refresh fetches the targets, reporting whether they changed. On failure
+// the previous targets are kept.
+func (d *httpSDDiscovery) refresh(ctx context.Context) (bool, error) {
+	targets, modified, err := d.fetch(ctx)
+	if err != nil {
+		d.failures.Inc()
+		return false, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.lastRefresh.Set(float64(time.Now().Unix()))
+	if !modified {
+		return false, nil
+	}
+	d.discovered.Set(float64(len(targets)))
+	if reflect.DeepEqual(targets, d.current) {
+		return false, nil
+	}
+	d.current = targets
+	return true, nil
+}
+
+// fetch gets the targets, asking for them only if their ETag changed. It
+// reports whether they did.
+func (d *httpSDDiscovery) fetch(ctx context.Context) ([]target, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		return nil, false, sanitizeError(err)
+	}
+	if err := authorize(req, d.user, d.conf); err != nil {
+		return nil, false, fmt.Errorf("reading credentials: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	d.mutex.Lock()
+	if d.etag != "" {
+		req.Header.Set("If-None-Match", d.etag)
+	}
+	d.mutex.Unlock()
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, false, sanitizeError(err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, false, nil
+	default:
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, false, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	ts, err := config.LoadHTTPSD(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing the targets: %v", err)
+	}
+
+	d.mutex.Lock()
+	d.etag = resp.Header.Get("ETag")
+	d.mutex.Unlock()
+	return targetsFromConfig(ts), true, nil
+}
+
+func (d *httpSDDiscovery) targets() []target {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.current
+}
+
+// run refreshes d every interval until done is closed, calling changed
+// when the targets changed.
+func (d *httpSDDiscovery) run(interval time.Duration, changed func(), done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			ok, err := d.refresh(ctx)
+			cancel()
+			if err != nil {
+				logger.Error("Error fetching the targets, keeping the previous ones", "url", sanitizeURI(d.url), "err", err)
+			}
+			if ok {
+				changed()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (d *httpSDDiscovery) Describe(ch chan<- *prometheus.Desc) {
+	d.discovered.Describe(ch)
+	d.lastRefresh.Describe(ch)
+	d.failures.Describe(ch)
+}
+
+func (d *httpSDDiscovery) Collect(ch chan<- prometheus.Metric) {
+	d.discovered.Collect(ch)
+	d.lastRefresh.Collect(ch)
+	d.failures.Collect(ch)
+}
@@ -0,0 +1,226 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	influxURL       = flag.String("influx.url", "", "URL of an InfluxDB server to write the targets' metrics to every -influx.interval, in line protocol through /api/v2/write.")
+	influxOrg       = flag.String("influx.org", "", "Organization of -influx.bucket.")
+	influxBucket    = flag.String("influx.bucket", "", "Bucket to write to on -influx.url.")
+	influxTokenFile = flag.String("influx.token-file", "", "File holding the API token for -influx.url, read on every write.")
+	influxStdout    = flag.Bool("influx.stdout", false, "Write the targets' metrics to stdout in line protocol every -influx.interval instead, for Telegraf's execd input.")
+	influxInterval  = flag.Duration("influx.interval", time.Minute, "How often to scrape the targets and write them in line protocol.")
+
+	influxFailures prometheus.Counter
+)
+
+func init() {
+	registerOutput(output{name: "influx", setup: func() (func(s *targetSet, done <-chan struct{}), error) {
+		if err := validateInflux(*influxURL, *influxBucket, *influxStdout, *influxInterval); err != nil {
+			return nil, err
+		}
+		w := influxWriterFromFlags()
+		if w == nil {
+			return nil, nil
+		}
+		return func(s *targetSet, done <-chan struct{}) {
+			runInflux(w, s, *influxInterval, done)
+		}, nil
+	}})
+	selfMetric(func() {
+		influxFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "influx_failures_total",
+			Help:        "Number of failed writes of line protocol.",
+			ConstLabels: withConstLabels(nil),
+		})
+		registry.MustRegister(influxFailures)
+	})
+}
+
+func validateInflux(rawurl, bucket string, stdout bool, interval time.Duration) error {
+	if rawurl == "" && !stdout {
+		return nil
+	}
+	if rawurl != "" && stdout {
+		return fmt.Errorf("-influx.url and -influx.stdout can't be used together")
+	}
+	if rawurl != "" {
+		u, err := url.Parse(rawurl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-influx.url %q must be an http or https URL", rawurl)
+		}
+		if bucket == "" {
+			return fmt.Errorf("-influx.url requires -influx.bucket")
+		}
+	}
+	if interval <= 0 {
+		return fmt.Errorf("-influx.interval must be positive, got %s", interval)
+	}
+	return nil
+}
+
+// writeLineProtocol writes mfs to w in InfluxDB line protocol, stamped
+// with now: each sample is a point of the measurement named after the
+// metric, with its labels as tags and its value as the value field. Samples
+// that aren't numbers Influx can store, NaN and infinities, are left out.
+func writeLineProtocol(w io.Writer, mfs []*dto.MetricFamily, now time.Time) error {
+	buf := bufio.NewWriter(w)
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			var value float64
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			buf.WriteString(influxMeasurementEscaper.Replace(mf.GetName()))
+			labels := append([]*dto.LabelPair(nil), m.Label...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			for _, l := range labels {
+				if l.GetValue() == "" {
+					continue // Influx has no empty tags.
+				}
+				buf.WriteByte(',')
+				buf.WriteString(influxTagEscaper.Replace(l.GetName()))
+				buf.WriteByte('=')
+				buf.WriteString(influxTagEscaper.Replace(l.GetValue()))
+			}
+			buf.WriteString(" value=")
+			buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+			buf.WriteByte(' ')
+			buf.WriteString(ts)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Flush()
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// influxWriter hands batches of line protocol to InfluxDB or to a writer.
+type influxWriter struct {
+	out       io.Writer // Written to when url is empty.
+	url       string    // Of the write endpoint, org and bucket included.
+	tokenFile string
+	client    *http.Client
+	deltas    *deltas
+}
+
+func influxWriterFromFlags() *influxWriter {
+	var w *influxWriter
+	switch {
+	case *influxStdout:
+		w = &influxWriter{out: os.Stdout}
+	case *influxURL != "":
+		w = newInfluxWriter(*influxURL, *influxOrg, *influxBucket, *influxTokenFile, newHTTPClient(clientConfigFromFlags()))
+	default:
+		return nil
+	}
+	w.deltas = deltasFromFlags("influx")
+	return w
+}
+
+func newInfluxWriter(rawurl, org, bucket, tokenFile string, client *http.Client) *influxWriter {
+	query := url.Values{"bucket": {bucket}, "precision": {"ns"}}
+	if org != "" {
+		query.Set("org", org)
+	}
+	return &influxWriter{
+		url:       strings.TrimSuffix(rawurl, "/") + "/api/v2/write?" + query.Encode(),
+		tokenFile: tokenFile,
+		client:    client,
+	}
+}
+
+// write scrapes es and writes their metrics.
+func (w *influxWriter) write(es Exporters) error {
+	mfs, _, err := gatherOnce(es)
+	if err != nil {
+		return err
+	}
+	mfs = w.deltas.apply(mfs)
+	var buf bytes.Buffer
+	if err := writeLineProtocol(&buf, mfs, time.Now()); err != nil {
+		return err
+	}
+	if w.url == "" {
+		_, err := w.out.Write(buf.Bytes())
+		return err
+	}
+	req, err := http.NewRequest("POST", w.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.tokenFile != "" {
+		token, err := config.ReadFile(w.tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Token "+strings.TrimSpace(string(token)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// runInflux writes the metrics of the current targets of s right away and
+// then every interval, until done is closed. Failures are logged and
+// counted, and the next interval tries again.
+func runInflux(w *influxWriter, s *targetSet, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.write(s.current()); err != nil {
+			influxFailures.Inc()
+			logger.Error("Error writing line protocol", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// fixtureFamilies scrapes apache24Status as the targets web01 and web02,
+// leaving out the metrics that differ from run to run.
+func fixtureFamilies(t *testing.T) []*dto.MetricFamily {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	es, err := newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: ts.URL, Labels: map[string]string{"env": "prod"}},
+		{Name: "web 02", URI: ts.URL},
+	}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, _, err := gatherOnce(es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stable []*dto.MetricFamily
+	for _, mf := range mfs {
+		if !strings.Contains(mf.GetName(), "duration") {
+			stable = append(stable, mf)
+		}
+	}
+	return stable
+}
+
+func TestWriteLineProtocol(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeLineProtocol(&buf, fixtureFamilies(t), time.Unix(1500000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "influx", "apache24.txt")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestInfluxWrite(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	var got *http.Request
+	var body string
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("t0ken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newInfluxWriter(influx.URL, "ops", "apache", tokenFile, http.DefaultClient)
+	if err := w.write(Exporters{NewExporter(ts.URL)}); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/api/v2/write" || got.URL.Query().Get("org") != "ops" || got.URL.Query().Get("bucket") != "apache" || got.URL.Query().Get("precision") != "ns" {
+		t.Errorf("unexpected write URL %s", got.URL)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Token t0ken" {
+		t.Errorf("expected the token from the file, got %q", auth)
+	}
+	if !strings.Contains(body, "\napache_up value=1 ") {
+		t.Errorf("expected apache_up in\n%s", body)
+	}
+
+	influx.Close()
+	if err := w.write(Exporters{NewExporter(ts.URL)}); err == nil {
+		t.Error("expected an error with InfluxDB gone")
+	}
+
+	var out bytes.Buffer
+	if err := (&influxWriter{out: &out}).write(Exporters{NewExporter(ts.URL)}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "apache_workers,state=busy value=1 ") {
+		t.Errorf("expected the workers on the writer, got\n%s", out.String())
+	}
+}
+
+func TestValidateInflux(t *testing.T) {
+	for _, tc := range []struct {
+		url, bucket string
+		stdout      bool
+		interval    time.Duration
+		ok          bool
+	}{
+		{"", "", false, 0, true},
+		{"", "", true, time.Minute, true},
+		{"http://influx:8086", "apache", false, time.Minute, true},
+		{"http://influx:8086", "", false, time.Minute, false},
+		{"influx:8086", "apache", false, time.Minute, false},
+		{"http://influx:8086", "apache", true, time.Minute, false},
+		{"", "", true, 0, false},
+	} {
+		if err := validateInflux(tc.url, tc.bucket, tc.stdout, tc.interval); (err == nil) != tc.ok {
+			t.Errorf("%+v: unexpected result %v", tc, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics of the exporter's own handlers, named as promhttp names
+// them.
+var (
+	httpInFlight     *prometheus.GaugeVec
+	httpDuration     *prometheus.HistogramVec
+	httpResponseSize *prometheus.HistogramVec
+)
+
+func init() {
+	selfMetric(func() {
+		httpInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "http_requests_in_flight",
+			Help:        "Number of requests being served, by handler.",
+			ConstLabels: withConstLabels(nil),
+		}, []string{"handler"})
+		httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "http_request_duration_seconds",
+			Help:        "Duration of requests, by handler, status code and method.",
+			Buckets:     prometheus.DefBuckets,
+			ConstLabels: withConstLabels(nil),
+		}, []string{"handler", "code", "method"})
+		httpResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "http_response_size_bytes",
+			Help:        "Size of responses, by handler.",
+			Buckets:     prometheus.ExponentialBuckets(256, 4, 8),
+			ConstLabels: withConstLabels(nil),
+		}, []string{"handler"})
+		registry.MustRegister(httpInFlight, httpDuration, httpResponseSize)
+	})
+}
+
+// instrumentHandler records the requests served by h in the http_* metrics,
+// as the handler called name, with the instrumentation of promhttp.
+func instrumentHandler(name string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	// Show the handler's response sizes before its first response.
+	httpResponseSize.With(labels)
+	return promhttp.InstrumentHandlerInFlight(httpInFlight.With(labels),
+		promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerResponseSize(httpResponseSize.MustCurryWith(labels), h)))
+}
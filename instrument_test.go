@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	h := instrumentHandler("metrics", metricsHandler(NewExporter(backend.URL)))
+	missing := instrumentHandler("missing", http.NotFoundHandler())
+
+	get := func(h http.Handler) string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	get(missing)
+	first := get(h)
+	// The request asking for the metrics is itself in flight.
+	for _, want := range []string{
+		`http_requests_in_flight{handler="metrics"} 1`,
+		`http_request_duration_seconds_count{code="404",handler="missing",method="get"} 1`,
+		`http_response_size_bytes_sum{handler="missing"} 19`,
+		`http_response_size_bytes_count{handler="missing"} 1`,
+	} {
+		if !strings.Contains(first, want) {
+			t.Errorf("expected %s in\n%s", want, first)
+		}
+	}
+
+	second := get(h)
+	if want := `http_request_duration_seconds_count{code="200",handler="metrics",method="get"} `; !strings.Contains(second, want) {
+		t.Fatalf("expected %s in\n%s", want, second)
+	}
+	count := func(body string) string {
+		i := strings.Index(body, `http_response_size_bytes_count{handler="metrics"} `)
+		if i < 0 {
+			return ""
+		}
+		return strings.Fields(body[i:])[1]
+	}
+	if a, b := count(first), count(second); a == "" || a == b {
+		t.Errorf("expected the metrics handler's response count to increase, got %q then %q", a, b)
+	}
+}
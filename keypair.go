@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// keyPairCheckInterval is how often a keyPair looks for a new certificate,
+// at most.
+var keyPairCheckInterval = 5 * time.Second
+
+// keyPair is the certificate served over HTTPS. It is loaded again from its
+// files when they change, so that certificates rotated in place are picked
+// up by new connections without restarting the exporter.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	sum     [sha256.Size]byte // Of the files as last read.
+	checked time.Time
+}
+
+func newKeyPair(certFile, keyFile string) (*keyPair, error) {
+	k := &keyPair{certFile: certFile, keyFile: keyFile}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// load reads the files and, if they changed since last read, parses the
+// certificate in them. Until one parses, the one loaded before is kept.
+func (k *keyPair) load() error {
+	certPEM, err := config.ReadFile(k.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := config.ReadFile(k.keyFile)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append(append(certPEM, 0), keyPEM...))
+	if k.cert != nil && sum == k.sum {
+		return nil
+	}
+	k.sum = sum
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if k.cert != nil {
+		logger.Info("Loaded the new web certificate", "file", k.certFile)
+	}
+	k.cert = &cert
+	return nil
+}
+
+// getCertificate is the tls.Config GetCertificate serving k.
+func (k *keyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if time.Since(k.checked) >= keyPairCheckInterval {
+		k.checked = time.Now()
+		if err := k.load(); err != nil {
+			logger.Error("Error loading the web certificate, still serving the previous one", "err", err)
+		}
+	}
+	return k.cert, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeyPairRotation(t *testing.T) {
+	defer func(old time.Duration) { keyPairCheckInterval = old }(keyPairCheckInterval)
+	keyPairCheckInterval = 0
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := newTestCert(t, "server", nil, false)
+	certFile, keyFile := old.write(t, dir)
+
+	tc, err := webTLSConfig(webTLSOptions{certFile: certFile, keyFile: keyFile, minVersion: "TLS12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serveTLS(t, tc)
+	if err := getTLS(t, url, old, nil, 0); err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+
+	rotated := newTestCert(t, "server", nil, false)
+	rotated.write(t, dir)
+	if err := getTLS(t, url, rotated, nil, 0); err != nil {
+		t.Errorf("expected new connections to get the rotated certificate: %v", err)
+	}
+
+	// A broken certificate keeps the last good one served.
+	if err := ioutil.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := getTLS(t, url, rotated, nil, 0); err != nil {
+		t.Errorf("expected the rotated certificate to still be served: %v", err)
+	}
+}
+
+func TestKeyPairCheckInterval(t *testing.T) {
+	defer func(old time.Duration) { keyPairCheckInterval = old }(keyPairCheckInterval)
+	keyPairCheckInterval = time.Hour
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := newTestCert(t, "server", nil, false)
+	k, err := newKeyPair(old.write(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.getCertificate(nil)
+	newTestCert(t, "server", nil, false).write(t, dir)
+	cert, _ := k.getCertificate(nil)
+	if string(cert.Certificate[0]) != string(old.cert.Raw) {
+		t.Error("expected the files not to be checked again within the interval")
+	}
+}
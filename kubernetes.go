@@ -0,0 +1,204 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	kubernetesEnabled    = flag.Bool("discovery.kubernetes", false, "Discover apache pods through the Kubernetes API.")
+	kubeconfig           = flag.String("discovery.kubernetes.kubeconfig", "", "Path to a kubeconfig file, the in-cluster configuration is used if empty.")
+	kubernetesNamespaces = &targetsFlag{}
+	kubernetesSelector   = flag.String("discovery.kubernetes.selector", "", "Label selector of the pods to scrape, such as app=apache.")
+)
+
+func init() {
+	flag.Var(kubernetesNamespaces, "discovery.kubernetes.namespaces", "Namespaces to discover pods in (default all). May be repeated or comma separated.")
+	registerDiscovery(discoveryMechanism{
+		name:    "kubernetes",
+		enabled: func() bool { return *kubernetesEnabled },
+		setup: func(_ func() (context.Context, context.CancelFunc), done <-chan struct{}) (discoverer, watcher, error) {
+			client, err := newKubernetesClient(*kubeconfig)
+			if err != nil {
+				return nil, nil, fmt.Errorf("connecting to Kubernetes: %v", err)
+			}
+			kube := newKubernetesDiscovery(client, kubernetesNamespaces.values, *kubernetesSelector)
+			if err := kube.start(done, *refreshInterval); err != nil {
+				return nil, nil, fmt.Errorf("discovering Kubernetes pods: %v", err)
+			}
+			registry.MustRegister(kube)
+			return kube, func(changed func()) {
+				kube.run(changed, done)
+			}, nil
+		},
+	})
+}
+
+// Pod annotations describing where the status page is.
+const (
+	annotationPort   = "apache-exporter/port"
+	annotationPath   = "apache-exporter/status-path"
+	annotationScheme = "apache-exporter/scheme"
+)
+
+// kubernetesDiscovery finds targets among the running pods matching a
+// label selector, keeping track of them with informers.
+type kubernetesDiscovery struct {
+	factories []informers.SharedInformerFactory
+	informers []cache.SharedIndexInformer
+	// events gets a value whenever pods change, bursts are coalesced.
+	events chan struct{}
+
+	discovered prometheus.Gauge
+}
+
+// newKubernetesClient connects with kubeconfigPath, or from within the
+// cluster if it's empty.
+func newKubernetesClient(kubeconfigPath string) (kubernetes.Interface, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeconfigPath == "" {
+		cfg, err = rest.InClusterConfig()
+	} else {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+func newKubernetesDiscovery(client kubernetes.Interface, namespaces []string, selector string) *kubernetesDiscovery {
+	d := &kubernetesDiscovery{
+		events: make(chan struct{}, 1),
+		discovered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_discovery_kubernetes_targets",
+			Help:        "Number of targets discovered through the Kubernetes API.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	for _, ns := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(client, 10*time.Minute,
+			informers.WithNamespace(ns),
+			informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.LabelSelector = selector }),
+		)
+		informer := factory.Core().V1().Pods().Informer()
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { d.notify() },
+			UpdateFunc: func(interface{}, interface{}) { d.notify() },
+			DeleteFunc: func(interface{}) { d.notify() },
+		})
+		d.factories = append(d.factories, factory)
+		d.informers = append(d.informers, informer)
+	}
+	return d
+}
+
+func (d *kubernetesDiscovery) notify() {
+	select {
+	case d.events <- struct{}{}:
+	default:
+	}
+}
+
+// start runs the informers until done is closed, returning once they have
+// listed the existing pods or timeout has passed.
+func (d *kubernetesDiscovery) start(done <-chan struct{}, timeout time.Duration) error {
+	var synced []cache.InformerSynced
+	for i, factory := range d.factories {
+		factory.Start(done)
+		synced = append(synced, d.informers[i].HasSynced)
+	}
+	wait := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(wait) })
+	defer timer.Stop()
+	if !cache.WaitForCacheSync(wait, synced...) {
+		return fmt.Errorf("timed out listing pods")
+	}
+	return nil
+}
+
+// run calls changed whenever pods change until done is closed.
+func (d *kubernetesDiscovery) run(changed func(), done <-chan struct{}) {
+	for {
+		select {
+		case <-d.events:
+			changed()
+		case <-done:
+			return
+		}
+	}
+}
+
+// targets returns a target for each running pod, labeled with its
+// namespace and name.
+func (d *kubernetesDiscovery) targets() []target {
+	var targets []target
+	for _, informer := range d.informers {
+		for _, obj := range informer.GetStore().List() {
+			pod, ok := obj.(*corev1.Pod)
+			if !ok || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+				continue
+			}
+			targets = append(targets, podTarget(pod))
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	d.discovered.Set(float64(len(targets)))
+	return targets
+}
+
+// podTarget builds the target for pod from its IP and annotations. Missing
+// parts are completed from the -scrape.default-* flags.
+func podTarget(pod *corev1.Pod) target {
+	uri := pod.Status.PodIP
+	if port := pod.Annotations[annotationPort]; port != "" {
+		uri = net.JoinHostPort(uri, port)
+	} else if strings.Contains(uri, ":") {
+		uri = "[" + uri + "]"
+	}
+	if scheme := pod.Annotations[annotationScheme]; scheme != "" {
+		uri = scheme + "://" + uri
+	}
+	if path := pod.Annotations[annotationPath]; path != "" {
+		uri += "/" + strings.TrimPrefix(path, "/")
+	}
+	return target{
+		name: pod.Namespace + "/" + pod.Name,
+		uri:  uri,
+		conf: config.Target{Labels: map[string]string{
+			"kubernetes_namespace": pod.Namespace,
+			"kubernetes_pod_name":  pod.Name,
+		}},
+	}
+}
+
+func (d *kubernetesDiscovery) Describe(ch chan<- *prometheus.Desc) {
+	d.discovered.Describe(ch)
+}
+
+func (d *kubernetesDiscovery) Collect(ch chan<- prometheus.Metric) {
+	d.discovered.Collect(ch)
+}
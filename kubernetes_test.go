@@ -0,0 +1,91 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(namespace, name, ip string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Labels:      map[string]string{"app": "apache"},
+			Annotations: annotations,
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip},
+	}
+}
+
+func kubernetesURIs(d *kubernetesDiscovery) map[string]string {
+	uris := map[string]string{}
+	for _, t := range d.targets() {
+		uris[t.name] = t.uri
+	}
+	return uris
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testPod("web", "apache-0", "10.1.0.1", map[string]string{annotationPort: "8080", annotationPath: "/status"}),
+		testPod("web", "pending", "", nil),
+		testPod("other", "apache-0", "10.2.0.1", nil),
+	)
+	done := make(chan struct{})
+	defer close(done)
+	d := newKubernetesDiscovery(client, []string{"web"}, "app=apache")
+	if err := d.start(done, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var changes int32
+	go d.run(func() { atomic.AddInt32(&changes, 1) }, done)
+
+	want := map[string]string{"web/apache-0": "10.1.0.1:8080/status"}
+	if got := kubernetesURIs(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	labels := d.targets()[0].conf.Labels
+	if labels["kubernetes_namespace"] != "web" || labels["kubernetes_pod_name"] != "apache-0" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	pods := client.CoreV1().Pods("web")
+	ctx := context.Background()
+	waitForPods := func(what string, want map[string]string) {
+		before := atomic.LoadInt32(&changes)
+		waitFor(t, what, func() bool {
+			return reflect.DeepEqual(kubernetesURIs(d), want) && atomic.LoadInt32(&changes) > before
+		})
+	}
+
+	// Add.
+	if _, err := pods.Create(ctx, testPod("web", "apache-1", "10.1.0.2", map[string]string{annotationScheme: "https"}), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForPods("the new pod", map[string]string{"web/apache-0": "10.1.0.1:8080/status", "web/apache-1": "https://10.1.0.2"})
+
+	// Update, the pending pod got an IP.
+	if _, err := pods.UpdateStatus(ctx, testPod("web", "pending", "10.1.0.3", nil), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForPods("the started pod", map[string]string{"web/apache-0": "10.1.0.1:8080/status", "web/apache-1": "https://10.1.0.2", "web/pending": "10.1.0.3"})
+
+	// Delete.
+	if err := pods.Delete(ctx, "apache-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForPods("the deleted pod to go", map[string]string{"web/apache-1": "https://10.1.0.2", "web/pending": "10.1.0.3"})
+	if v := gaugeValue(t, d.discovered); v != 2 {
+		t.Errorf("expected 2 discovered targets, got %v", v)
+	}
+}
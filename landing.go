@@ -0,0 +1,56 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yosefy/apache_exporter/version"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Apache Exporter</title></head>
+<body>
+<h1>Apache Exporter</h1>
+<p>Version {{.Version}}</p>
+<p><a href="{{.MetricsPath}}">Metrics</a></p>
+{{- if gt (len .Targets) 1}}
+<table>
+<tr><th>Target</th><th>URI</th><th>State</th><th>Last error</th></tr>
+{{- range .Targets}}
+<tr><td>{{.Name}}</td><td>{{.URI}}</td><td>{{if .MaintenanceUntil}}maintenance until {{.MaintenanceUntil.Format "2006-01-02 15:04:05 MST"}}{{else}}{{.Health}}{{end}}</td><td>{{.LastError}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// landingHandler serves a page at / linking to metricsPath and, when
+// scraping several targets, listing them with the outcome of their last
+// scrape. Links are relative so the page also works behind a path prefix,
+// unless absolute ones under external are asked for.
+func landingHandler(s *targetSet, metricsPath string, external *url.URL) http.Handler {
+	metricsURL := "./" + strings.TrimPrefix(metricsPath, "/")
+	if external != nil {
+		metricsURL = external.String() + metricsPath
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var targets []targetStatus
+		for _, e := range s.current() {
+			targets = append(targets, e.status())
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		landingTemplate.Execute(w, struct {
+			Version     string
+			MetricsPath string
+			Targets     []targetStatus
+		}{version.Version, metricsURL, targets})
+	})
+}
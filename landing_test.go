@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yosefy/apache_exporter/version"
+)
+
+func TestLandingPage(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<b>down</b>", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	host := strings.TrimPrefix(failing.URL, "http://")
+
+	es, err := setupExporters([]string{"web01=http://monitor:s3cret@" + host + "/server-status?auto&token=t0ken", "web02=" + failing.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetValues(t, es, "apache_up")
+	h := landingHandler(newTargetSet(es, nil), "/custom/metrics", nil)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	body := rr.Body.String()
+	for _, want := range []string{`<a href="./custom/metrics">`, "Version " + version.Version, "<td>web01</td>", "token=xxxxx", "<td>down</td>", "&lt;b&gt;down&lt;/b&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in\n%s", want, body)
+		}
+	}
+	for _, secret := range []string{"s3cret", "t0ken", "monitor", "<b>"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("unexpected %q in\n%s", secret, body)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for other paths, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	landingHandler(newTargetSet(Exporters{NewExporter(failing.URL)}, nil), "/metrics", nil).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if body := rr.Body.String(); strings.Contains(body, "<table>") || !strings.Contains(body, `href="./metrics"`) {
+		t.Errorf("expected a single target page without a table, got\n%s", body)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	maxRequests = flag.Int("web.max-requests", 0, "Maximum number of requests served at the same time, beyond which they get a 503. /healthz, /healthz/apache and /-/ready aren't limited. 0 means no limit.")
+
+	rejectedRequests prometheus.Counter
+)
+
+func init() {
+	selfMetric(func() {
+		rejectedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_http_requests_rejected_total",
+			Help:        "Number of requests refused for exceeding -web.max-requests.",
+			ConstLabels: withConstLabels(nil),
+		})
+	})
+}
+
+// unlimitedPaths are left out of -web.max-requests, so that an overloaded
+// exporter isn't taken for a dead one.
+var unlimitedPaths = map[string]bool{"/healthz": true, "/healthz/apache": true, "/-/ready": true}
+
+// limitRequests serves at most n requests to h at a time, turning away
+// those beyond with a 503. With n 0, it returns h.
+func limitRequests(n int, h http.Handler) http.Handler {
+	if n <= 0 {
+		return h
+	}
+	inFlight := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case inFlight <- struct{}{}:
+			defer func() { <-inFlight }()
+			h.ServeHTTP(w, r)
+		default:
+			rejectedRequests.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("Too many requests in flight, at most %d are served at a time", n), http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func validateMaxRequests(n int) error {
+	if n < 0 {
+		return fmt.Errorf("-web.max-requests must not be negative, got %d", n)
+	}
+	return nil
+}
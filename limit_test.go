@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLimitRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	mux.Handle("/healthz", healthzHandler())
+	h := limitRequests(2, mux)
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rr := get("/metrics"); rr.Code != http.StatusOK {
+				t.Errorf("expected requests within the limit to be served, got %d", rr.Code)
+			}
+		}()
+		<-started
+	}
+
+	before := counterValue(t, rejectedRequests)
+	for i := 0; i < 3; i++ {
+		rr := get("/metrics")
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 beyond the limit, got %d", rr.Code)
+		}
+		if rr.Header().Get("Retry-After") == "" {
+			t.Error("expected a Retry-After header")
+		}
+	}
+	if v := counterValue(t, rejectedRequests) - before; v != 3 {
+		t.Errorf("expected 3 rejected requests counted, got %v", v)
+	}
+	if rr := get("/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("expected /healthz not to be limited, got %d", rr.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	go func() { <-started }()
+	if rr := get("/metrics"); rr.Code != http.StatusOK {
+		t.Errorf("expected requests to be served again once the others finished, got %d", rr.Code)
+	}
+}
+
+func TestLimitRequestsUnlimited(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := limitRequests(0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected no limit, got %d", rr.Code)
+			}
+		}()
+		<-started
+	}
+	close(release)
+	wg.Wait()
+
+	if err := validateMaxRequests(-1); err == nil {
+		t.Error("expected an error for a negative limit")
+	}
+}
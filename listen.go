@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	listeningAddresses = &targetsFlag{values: []string{":9117"}}
+	socketMode         = flag.String("web.socket-mode", "0660", "Permissions of the socket file when -web.listen-address is a unix:// path, in octal.")
+)
+
+func init() {
+	flag.Var(listeningAddresses, "web.listen-address", "Address on which to expose metrics, or unix:// followed by the path of a Unix socket. May be repeated or comma separated to listen on several.")
+}
+
+// listen returns the sockets passed on by systemd socket activation if
+// there are any, and otherwise listens on every -web.listen-address.
+func listen() ([]net.Listener, error) {
+	listeners, err := activationListeners(listenFDsStart)
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		if listeningAddresses.set {
+			logger.Info("Using the sockets passed on by systemd, ignoring -web.listen-address")
+		}
+		for _, l := range listeners {
+			logger.Info("Listening from systemd", "address", l.Addr())
+		}
+		return listeners, nil
+	}
+	return listenAddresses(listeningAddresses.values, *socketMode)
+}
+
+// listenAddresses listens on all of addresses, or none if it can't listen
+// on one of them.
+func listenAddresses(addresses []string, mode string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		l, err := listenAddress(address, mode)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("listening on %s: %v", address, err)
+		}
+		logger.Info("Listening", "address", l.Addr())
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenAddress listens on a TCP address, or on the Unix socket path of a
+// unix:// address, given mode as its permissions. A socket left behind at
+// the path by an earlier run is removed first, and closing the listener
+// removes the socket.
+func listenAddress(address, mode string) (net.Listener, error) {
+	if !strings.HasPrefix(address, "unix://") {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, "unix://")
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return nil, fmt.Errorf("invalid -web.socket-mode %q", mode)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("can't listen on %s: exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apache_exporter.sock")
+
+	// A socket left behind by an exporter that didn't shut down cleanly.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listenAddress("unix://"+path, "0600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected mode 0600, got %o", perm)
+	}
+
+	server := &http.Server{Handler: healthzHandler()}
+	go server.Serve(l)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://localhost/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 over the socket, got %d", resp.StatusCode)
+	}
+
+	server.Shutdown(context.Background())
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestListenUnixSocketErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "not-a-socket")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listenAddress("unix://"+path, "0660"); err == nil {
+		t.Error("expected an error for a path that isn't a socket")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the file to be left alone, got %v", err)
+	}
+	for _, mode := range []string{"rw-rw----", "1000"} {
+		if _, err := listenAddress("unix://"+filepath.Join(dir, "sock"), mode); err == nil {
+			t.Errorf("expected an error for mode %q", mode)
+		}
+	}
+}
+
+func TestListenAddresses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apache_exporter.sock")
+
+	listeners, err := listenAddresses([]string{"127.0.0.1:0", "127.0.0.1:0", "unix://" + path}, "0660")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: healthzHandler()}
+	for _, l := range listeners {
+		go server.Serve(l)
+	}
+	for _, l := range listeners[:2] {
+		resp, err := http.Get("http://" + l.Addr().String() + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", l.Addr(), resp.StatusCode)
+		}
+	}
+
+	server.Shutdown(context.Background())
+	for _, l := range listeners[:2] {
+		if _, err := http.Get("http://" + l.Addr().String() + "/healthz"); err == nil {
+			t.Errorf("expected %s to be closed on shutdown", l.Addr())
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestListenAddressesInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := listenAddresses([]string{"127.0.0.1:0", l.Addr().String()}, "0660"); err == nil {
+		t.Error("expected an error for an address in use")
+	}
+}
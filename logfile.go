@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var (
+	logFilePath       = flag.String("log.file", "", "File to log to instead of stderr. Reopened on SIGHUP, for logrotate.")
+	logFileMaxSize    = flag.Int64("log.file-max-size", 100, "Size in megabytes -log.file is rotated at, 0 to never rotate it.")
+	logFileMaxBackups = flag.Int("log.file-max-backups", 5, "Rotated -log.file files kept, as .1 for the newest and so on; the oldest are removed first.")
+)
+
+// logFile is where the exporter logs with -log.file, nil without.
+var logFile *rotatingFile
+
+// rotatingFile is a log file that is rotated once it grows past maxSize
+// bytes, keeping maxBackups of the previous ones. If rotating fails, it
+// writes to fallback instead until reopened, rather than holding up
+// whoever logs.
+type rotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	fallback   io.Writer
+	file       *os.File // Nil while writing to fallback.
+	size       int64
+}
+
+// openRotatingFile opens the log file at path, appending to it.
+func openRotatingFile(path string, maxSize int64, maxBackups int, fallback io.Writer) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, fallback: fallback}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, fi.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file != nil && f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(f.fallback, "Error rotating %s, logging here instead: %s\n", f.path, err)
+		}
+	}
+	if f.file == nil {
+		return f.fallback.Write(p)
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file to .1, the previous .1 to .2 and so on, and
+// starts a new one. Without backups, it starts the file over.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	if f.maxBackups == 0 {
+		if err := os.Truncate(f.path, 0); err != nil {
+			return err
+		}
+		return f.open()
+	}
+	if err := os.Remove(f.backup(f.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.backup(1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// backup returns the path of the nth newest rotated file.
+func (f *rotatingFile) backup(n int) string {
+	return f.path + "." + strconv.Itoa(n)
+}
+
+// reopen closes the file and opens it again, writing to a new one if it
+// was moved away, such as by logrotate.
+func (f *rotatingFile) reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "apache_exporter.log")
+	var fallback bytes.Buffer
+	f, err := openRotatingFile(path, 100, 2, &fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lines of 40 bytes, two to a file, the last one left in the file.
+	line := strings.Repeat("x", 38) + "\n"
+	for i := 0; i < 9; i++ {
+		if _, err := f.Write([]byte(string(rune('0'+i)) + line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{
+		"apache_exporter.log":   "8",
+		"apache_exporter.log.1": "67",
+		"apache_exporter.log.2": "45",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		for _, l := range strings.SplitAfter(string(data), "\n") {
+			if l != "" {
+				got += l[:1]
+			}
+		}
+		if got != want {
+			t.Errorf("%s: expected lines %s, got %s", name, want, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, got %v", err)
+	}
+	if fallback.Len() != 0 {
+		t.Errorf("unexpected fallback writes %q", fallback.String())
+	}
+}
+
+func TestRotatingFileNoBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apache_exporter.log")
+	f, err := openRotatingFile(path, 10, 0, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("first\n"))
+	f.Write([]byte("second\n"))
+	if data, _ := ioutil.ReadFile(path); string(data) != "second\n" {
+		t.Errorf("expected the file started over, got %q", data)
+	}
+}
+
+func TestRotatingFileFallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "apache_exporter.log")
+	var fallback bytes.Buffer
+	f, err := openRotatingFile(path, 10, 1, &fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("first\n"))
+	// A directory where the backup goes makes the rotation fail.
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("third\n"))
+	if got := fallback.String(); !strings.Contains(got, "Error rotating") || !strings.HasSuffix(got, "second\nthird\n") {
+		t.Errorf("expected the log on the fallback, got %q", got)
+	}
+
+	os.RemoveAll(path + ".1")
+	os.Rename(path, path+".1")
+	if err := f.reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("fourth\n"))
+	if data, _ := ioutil.ReadFile(path); string(data) != "fourth\n" {
+		t.Errorf("expected a new file once reopened, got %q", data)
+	}
+}
+
+func TestLogFileOpenError(t *testing.T) {
+	defer func(path string) { *logFilePath = path }(*logFilePath)
+	*logFilePath = filepath.Join(t.TempDir(), "missing", "apache_exporter.log")
+	defer func(l, f string) { *logLevel, *logFormat = l, f }(*logLevel, *logFormat)
+	old := logger
+	defer func() { logger = old }()
+	if err := setupLogging(); err == nil || !strings.Contains(err.Error(), "-log.file") {
+		t.Errorf("expected an error about -log.file, got %v", err)
+	}
+}
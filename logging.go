@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	logLevel  = flag.String("log.level", "info", "Only log messages with the given severity or above: debug, info, warn or error.")
+	logFormat = flag.String("log.format", "logfmt", "Format of the log: logfmt or json.")
+)
+
+// logger is what the exporter logs to, set up from -log.level and
+// -log.format once the flags are parsed. Messages about a target go to the
+// exporter's logger instead, which carries the target.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// logLevelVar holds the level the exporter logs at, -log.level at first and
+// then as changed by PUT /-/log-level or logLevelSignals.
+var logLevelVar = new(slog.LevelVar)
+
+// parseLevel returns the level called level.
+func parseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown -log.level %q, valid are debug, info, warn and error", level)
+}
+
+// levelName returns the name of l as -log.level takes it.
+func levelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// newLogger returns a logger writing to w in format, leaving out messages
+// below level.
+func newLogger(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "logfmt":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown -log.format %q, valid are logfmt and json", format)
+	}
+}
+
+// setupLogging points logger at stderr, or -log.file, as -log.level and
+// -log.format say.
+func setupLogging() error {
+	level, err := parseLevel(*logLevel)
+	if err != nil {
+		return err
+	}
+	logLevelVar.Set(level)
+	var w io.Writer = os.Stderr
+	if *logFilePath != "" {
+		f, err := openRotatingFile(*logFilePath, *logFileMaxSize<<20, *logFileMaxBackups, os.Stderr)
+		if err != nil {
+			return fmt.Errorf("Error opening -log.file: %v", err)
+		}
+		logFile, w = f, f
+	}
+	l, err := newLogger(w, logLevelVar, *logFormat)
+	if err != nil {
+		return err
+	}
+	logger = l
+	return nil
+}
+
+// fatal logs err, which the exporter can't go on after, and exits.
+func fatal(msg string, err error) {
+	logger.Error(msg, "err", err)
+	os.Exit(1)
+}
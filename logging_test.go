@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// captureLogs points logger at the returned buffer, in format and at debug
+// level, until the test ends.
+func captureLogs(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, slog.LevelDebug, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := logger
+	logger = l
+	t.Cleanup(func() { logger = old })
+	return &buf
+}
+
+// logRecords decodes the JSON log in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("invalid log line %q: %s", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, slog.LevelWarn, "logfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hidden")
+	l.Warn("shown", "target", "web01")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "level=WARN msg=shown target=web01") {
+		t.Errorf("unexpected log %q", got)
+	}
+
+	buf.Reset()
+	level, err := parseLevel("DEBUG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err = newLogger(&buf, level, "json"); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("shown", "fields", 5)
+	if got := buf.String(); !strings.Contains(got, `"level":"DEBUG","msg":"shown","fields":5`) {
+		t.Errorf("unexpected log %q", got)
+	}
+
+	if _, err := parseLevel("fatal"); err == nil || !strings.Contains(err.Error(), "-log.level") {
+		t.Errorf("expected an error about -log.level, got %v", err)
+	}
+	if _, err := newLogger(&buf, slog.LevelInfo, "text"); err == nil || !strings.Contains(err.Error(), "-log.format") {
+		t.Errorf("expected an error about -log.format, got %v", err)
+	}
+}
+
+func TestScrapeLogFields(t *testing.T) {
+	buf := captureLogs(t, "json")
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ok.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Total Accesses: lots\n"))
+	}))
+	defer broken.Close()
+	es, err := newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: ok.URL},
+		{Name: "web02", URI: broken.URL},
+	}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	gatherOnce(es)
+
+	var failed, parsed, collected []map[string]interface{}
+	for _, r := range logRecords(t, buf) {
+		switch r["msg"] {
+		case "Error scraping apache":
+			failed = append(failed, r)
+		case "Parsed the status page":
+			parsed = append(parsed, r)
+		case "Collected apache metrics":
+			collected = append(collected, r)
+		}
+	}
+	if len(failed) != 1 {
+		t.Fatalf("expected one failed scrape, got:\n%s", buf)
+	}
+	if r := failed[0]; r["level"] != "ERROR" || r["target"] != "web02" || r["reason"] != reasonParse ||
+		r["duration"] == nil || !strings.Contains(r["err"].(string), "lots") {
+		t.Errorf("unexpected fields of the failure %v", r)
+	}
+	if len(parsed) != 1 || parsed[0]["target"] != "web01" || parsed[0]["fields"] != float64(5) {
+		t.Errorf("expected web01's status page to be parsed into 5 fields, got %v", parsed)
+	}
+	enabled := 0
+	for _, c := range groupCollectors {
+		if collectorEnabled[c.name] {
+			enabled++
+		}
+	}
+	if len(collected) != enabled {
+		t.Fatalf("expected a timing per collector, got %v", collected)
+	}
+	// The collectors run concurrently, logging in any order.
+	timed := map[interface{}]bool{}
+	for _, r := range collected {
+		if r["target"] != "web01" || r["duration"] == nil {
+			t.Errorf("unexpected fields of the collector timing %v", r)
+		}
+		timed[r["collector"]] = true
+	}
+	for _, c := range groupCollectors {
+		if collectorEnabled[c.name] && !timed[c.name] {
+			t.Errorf("expected a timing of collector %s, got %v", c.name, collected)
+		}
+	}
+}
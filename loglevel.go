@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	logLevelMutex sync.Mutex
+	// levelBeforeDebug is what logLevelSignals switch back to from debug.
+	levelBeforeDebug = slog.LevelInfo
+)
+
+func init() {
+	selfMetric(func() {
+		registry.MustRegister(logLevelCollector{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "log_level"),
+			"The level the exporter logs at, always 1.",
+			[]string{"level"}, withConstLabels(nil),
+		)})
+	})
+}
+
+// setLogLevel makes the exporter log at level from now on, logging the
+// change at whichever of the old and new levels is lower so that it shows.
+func setLogLevel(level slog.Level) {
+	logLevelMutex.Lock()
+	defer logLevelMutex.Unlock()
+	old := logLevelVar.Level()
+	if old == level {
+		return
+	}
+	if level > old {
+		logger.Log(context.Background(), old, "Changing the log level", "from", levelName(old), "to", levelName(level))
+		logLevelVar.Set(level)
+	} else {
+		logLevelVar.Set(level)
+		logger.Log(context.Background(), level, "Changed the log level", "from", levelName(old), "to", levelName(level))
+	}
+}
+
+// toggleDebug switches the log level to debug, or back to what it was if
+// it's debug already.
+func toggleDebug() {
+	logLevelMutex.Lock()
+	level := logLevelVar.Level()
+	if level != slog.LevelDebug {
+		levelBeforeDebug, level = level, slog.LevelDebug
+	} else {
+		level = levelBeforeDebug
+	}
+	logLevelMutex.Unlock()
+	setLogLevel(level)
+}
+
+// toggleDebugOnSignal calls toggleDebug on every one of logLevelSignals.
+func toggleDebugOnSignal() {
+	if len(logLevelSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, logLevelSignals...)
+	go func() {
+		for range signals {
+			toggleDebug()
+		}
+	}()
+}
+
+// logLevelRequest is what GET /-/log-level answers and PUT takes.
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// logLevelHandler serves the log level on GET and, if changeable, sets it
+// on PUT.
+func logLevelHandler(changeable bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD":
+		case "PUT":
+			if !changeable {
+				http.Error(w, "changing the log level needs -web.enable-lifecycle", http.StatusForbidden)
+				return
+			}
+			var req logLevelRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+				return
+			}
+			level, err := parseLevel(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			setLogLevel(level)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "only GET and PUT requests are served", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logLevelRequest{levelName(logLevelVar.Level())})
+	})
+}
+
+// logLevelCollector exports the current log level.
+type logLevelCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c logLevelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c logLevelCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, levelName(logLevelVar.Level()))
+}
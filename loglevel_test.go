@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLevelLogs points logger at the returned buffer, at logLevelVar
+// starting from info, until the test ends.
+func captureLevelLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, logLevelVar, "logfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldLogger, oldLevel, oldBefore := logger, logLevelVar.Level(), levelBeforeDebug
+	logger = l
+	logLevelVar.Set(slog.LevelInfo)
+	t.Cleanup(func() {
+		logger = oldLogger
+		logLevelVar.Set(oldLevel)
+		levelBeforeDebug = oldBefore
+	})
+	return &buf
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	buf := captureLevelLogs(t)
+	h := logLevelHandler(true)
+	request := func(method, body string) (int, string) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, "/-/log-level", strings.NewReader(body)))
+		return rr.Code, rr.Body.String()
+	}
+
+	logger.Debug("before")
+	if code, body := request("GET", ""); code != 200 || body != "{\"level\":\"info\"}\n" {
+		t.Errorf("GET: got %d %q", code, body)
+	}
+	if code, body := request("PUT", `{"level":"debug"}`); code != 200 || body != "{\"level\":\"debug\"}\n" {
+		t.Errorf("PUT: got %d %q", code, body)
+	}
+	logger.Debug("after")
+	if got := buf.String(); strings.Contains(got, "before") || !strings.Contains(got, "msg=after") || !strings.Contains(got, `msg="Changed the log level" from=info to=debug`) {
+		t.Errorf("expected debug lines only after the change, got:\n%s", got)
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var levels []string
+	for _, mf := range mfs {
+		if mf.GetName() == "apache_exporter_log_level" {
+			for _, m := range mf.GetMetric() {
+				levels = append(levels, m.GetLabel()[0].GetValue())
+			}
+		}
+	}
+	if len(levels) != 1 || levels[0] != "debug" {
+		t.Errorf("expected apache_exporter_log_level{level=\"debug\"}, got levels %v", levels)
+	}
+
+	for _, c := range []struct {
+		method, body string
+		code         int
+	}{
+		{"PUT", `{"level":"trace"}`, 400},
+		{"PUT", `debug`, 400},
+		{"POST", `{"level":"info"}`, 405},
+	} {
+		if code, body := request(c.method, c.body); code != c.code {
+			t.Errorf("%s %s: expected %d, got %d %q", c.method, c.body, c.code, code, body)
+		}
+	}
+	if logLevelVar.Level() != slog.LevelDebug {
+		t.Errorf("expected failed requests to leave the level, got %s", logLevelVar.Level())
+	}
+
+	rr := httptest.NewRecorder()
+	logLevelHandler(false).ServeHTTP(rr, httptest.NewRequest("PUT", "/-/log-level", strings.NewReader(`{"level":"error"}`)))
+	if rr.Code != http.StatusForbidden || logLevelVar.Level() != slog.LevelDebug {
+		t.Errorf("expected PUT without -web.enable-lifecycle to be refused, got %d", rr.Code)
+	}
+}
+
+func TestToggleDebug(t *testing.T) {
+	buf := captureLevelLogs(t)
+	logLevelVar.Set(slog.LevelWarn)
+	logger.Debug("hidden")
+	toggleDebug()
+	logger.Debug("shown")
+	toggleDebug()
+	logger.Debug("hidden again")
+	if got := logLevelVar.Level(); got != slog.LevelWarn {
+		t.Errorf("expected warn after toggling twice, got %s", got)
+	}
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "msg=shown") {
+		t.Errorf("expected debug lines only while toggled, got:\n%s", got)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// logTailQueue is how many lines read may wait to be parsed before
+	// lines are dropped.
+	logTailQueue = 8192
+	// logTailPoll is how often a log is checked for lines, rotation and
+	// truncation.
+	logTailPoll = 250 * time.Millisecond
+)
+
+// logTail follows a log of apache, through rotation and truncation.
+type logTail struct {
+	path    string
+	poll    time.Duration
+	lines   chan string
+	dropped prometheus.Counter // Lines left out as the queue was full.
+}
+
+func newLogTail(path string, dropped prometheus.Counter) logTail {
+	return logTail{
+		path:    path,
+		poll:    logTailPoll,
+		lines:   make(chan string, logTailQueue),
+		dropped: dropped,
+	}
+}
+
+// tail follows the log until done is closed, passing its lines to observe
+// on the side so that a burst of them doesn't hold up reading. Lines
+// already in the log when it starts are left out.
+func (t *logTail) tail(done <-chan struct{}, observe func(string)) {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case line := <-t.lines:
+				observe(line)
+			case <-done:
+				return
+			}
+		}
+	}()
+	t.follow(done)
+	<-stopped
+}
+
+// follow reads the lines of the log into t.lines until done is closed.
+// A log renamed away is read to its end before the new one is opened, read
+// from its start, as is one truncated.
+func (t *logTail) follow(done <-chan struct{}) {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	var partial string
+	buf := make([]byte, 64<<10)
+	failing := false
+	open := func(whence int) {
+		var err error
+		if f, err = os.Open(t.path); err != nil {
+			f = nil
+			if !failing {
+				logger.Warn("Error opening the log, trying again", "file", t.path, "err", err)
+			}
+			failing = true
+			return
+		}
+		if _, err := f.Seek(0, whence); err != nil {
+			logger.Warn("Error seeking in the log", "file", t.path, "err", err)
+		}
+		failing, partial = false, ""
+	}
+	open(io.SeekEnd)
+
+	ticker := time.NewTicker(t.poll)
+	defer ticker.Stop()
+	for {
+		if f != nil {
+			partial = t.read(f, buf, partial)
+			info, err := os.Stat(t.path)
+			current, _ := f.Stat()
+			switch {
+			case err != nil:
+				// Renamed away and not created again yet.
+			case current == nil || !os.SameFile(info, current):
+				// What was written before the rotation.
+				t.read(f, buf, partial)
+				f.Close()
+				open(io.SeekStart)
+			default:
+				if offset, err := f.Seek(0, io.SeekCurrent); err == nil && info.Size() < offset {
+					logger.Info("The log was truncated, reading it from the start", "file", t.path)
+					f.Seek(0, io.SeekStart)
+					partial = ""
+				}
+			}
+		} else {
+			open(io.SeekStart)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// read queues the lines of f up to its end, prefixed by partial, the end
+// of the log without a newline yet, returning what is left of it.
+func (t *logTail) read(f *os.File, buf []byte, partial string) string {
+	for {
+		n, err := f.Read(buf)
+		data := partial + string(buf[:n])
+		for {
+			i := strings.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			select {
+			case t.lines <- strings.TrimSuffix(data[:i], "\r"):
+			default:
+				t.dropped.Inc()
+			}
+			data = data[i+1:]
+		}
+		partial = data
+		if err != nil || n == 0 {
+			return partial
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// appendFile appends lines to the file at path.
+func appendFile(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, l := range lines {
+		if _, err := f.WriteString(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLogTailDropped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	line := `192.0.2.10 - - [14/Oct/2026:09:12:01 +0000] "GET / HTTP/1.1" 200 100` + "\n"
+	appendFile(t, path, strings.Repeat(line, 5))
+	l := newLogTail(path, prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"}))
+	// Nothing parses them, so lines beyond the queue are dropped.
+	l.lines = make(chan string, 3)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if partial := l.read(f, make([]byte, 16), ""); partial != "" {
+		t.Errorf("expected no partial line, got %q", partial)
+	}
+	var m dto.Metric
+	if err := l.dropped.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.lines) != 3 || m.GetCounter().GetValue() != 2 {
+		t.Errorf("expected 3 lines queued and 2 dropped, got %d and %v", len(l.lines), m.GetCounter().GetValue())
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var maintenanceServeCached = flag.Bool("maintenance.serve-cached", true, "Serve the metrics of the last successful scrape of a target in maintenance along with apache_maintenance 1, instead of apache_maintenance alone.")
+
+// errMaintenance is returned for scrapes skipped during maintenance.
+var errMaintenance = errors.New("target in maintenance")
+
+// maintenance is the maintenance window of a target set through
+// /-/maintenance, kept across reloads like its counters.
+type maintenance struct {
+	mutex  sync.Mutex
+	until  time.Time
+	cached []prometheus.Metric // Of the last successful scrape.
+}
+
+func (m *maintenance) set(until time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.until = until
+}
+
+// keep holds on to the frozen metrics of a successful scrape, for serving
+// during maintenance.
+func (m *maintenance) keep(metrics []prometheus.Metric) {
+	if !*maintenanceServeCached {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.cached = metrics
+}
+
+// maintenanceUntil returns when the maintenance of e going on at now ends,
+// as set through /-/maintenance or by maintenance_until in the config
+// file, whichever is later. It is zero if e isn't in maintenance.
+func (e *Exporter) maintenanceUntil(now time.Time) time.Time {
+	until := e.conf.MaintenanceUntil
+	e.maintenance.mutex.Lock()
+	if e.maintenance.until.After(until) {
+		until = e.maintenance.until
+	}
+	e.maintenance.mutex.Unlock()
+	if !until.After(now) {
+		return time.Time{}
+	}
+	return until
+}
+
+// collectMaintenance sends what e serves instead of scraping apache during
+// maintenance.
+func (e *Exporter) collectMaintenance(ch chan<- prometheus.Metric) {
+	e.maintenance.mutex.Lock()
+	cached := e.maintenance.cached
+	e.maintenance.mutex.Unlock()
+	// The scrape was exported as out of maintenance, by an exporter
+	// reloaded since maybe.
+	desc := e.maintenanceDesc.String()
+	for _, m := range cached {
+		if m.Desc().String() != desc {
+			ch <- m
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(e.maintenanceDesc, prometheus.GaugeValue, 1)
+}
+
+// maintenanceStatus describes a target in maintenance on /-/maintenance.
+type maintenanceStatus struct {
+	Name  string    `json:"name"`
+	Until time.Time `json:"until"`
+}
+
+// maintenanceHandler serves the targets of s in maintenance as JSON. With
+// changeable, a POST with a duration puts the target named by the target
+// parameter, or every target, in maintenance for that long, and a DELETE
+// ends their maintenance early.
+func maintenanceHandler(s *targetSet, changeable bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD":
+		case "POST", "DELETE":
+			if !changeable {
+				http.Error(w, "changing maintenance windows needs -web.enable-lifecycle", http.StatusForbidden)
+				return
+			}
+			es := s.current()
+			if name := r.FormValue("target"); name != "" {
+				es = nil
+				for _, e := range s.current() {
+					if e.name == name {
+						es = Exporters{e}
+					}
+				}
+				if es == nil {
+					http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+					return
+				}
+			}
+			var until time.Time
+			if r.Method == "POST" {
+				d, err := time.ParseDuration(r.FormValue("duration"))
+				if err != nil || d <= 0 {
+					http.Error(w, fmt.Sprintf("invalid duration %q, must be positive such as 30m", r.FormValue("duration")), http.StatusBadRequest)
+					return
+				}
+				until = time.Now().Add(d)
+			}
+			for _, e := range es {
+				e.maintenance.set(until)
+				if until.IsZero() {
+					e.logger.Info("Maintenance ended")
+				} else {
+					e.logger.Info("Maintenance started, not scraping the target", "until", until)
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "only GET, POST and DELETE requests are served", http.StatusMethodNotAllowed)
+			return
+		}
+		targets := []maintenanceStatus{}
+		now := time.Now()
+		for _, e := range s.current() {
+			if until := e.maintenanceUntil(now); !until.IsZero() {
+				targets = append(targets, maintenanceStatus{e.name, until})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"targets": targets},
+		})
+	})
+}
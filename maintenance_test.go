@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestMaintenance(t *testing.T) {
+	var scrapes int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&scrapes, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	es, err := setupExporters([]string{"web01=" + backend.URL, "web02=" + backend.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, nil)
+	metrics := metricsHandler(s)
+	gather := func() string {
+		rr := httptest.NewRecorder()
+		metrics.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	maintenance := maintenanceHandler(s, true)
+	request := func(method, query string) (int, []maintenanceStatus) {
+		rr := httptest.NewRecorder()
+		maintenance.ServeHTTP(rr, httptest.NewRequest(method, "/-/maintenance"+query, nil))
+		var resp struct {
+			Data struct{ Targets []maintenanceStatus }
+		}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, resp.Data.Targets
+	}
+
+	if body := gather(); !strings.Contains(body, `apache_maintenance{target="web01"} 0`) {
+		t.Errorf("expected web01 out of maintenance in\n%s", body)
+	}
+
+	// Entering it.
+	code, targets := request("POST", "?target=web01&duration=30m")
+	if code != http.StatusOK || len(targets) != 1 || targets[0].Name != "web01" || time.Until(targets[0].Until) < 29*time.Minute {
+		t.Fatalf("expected web01 in maintenance for 30m, got %d, %+v", code, targets)
+	}
+	before := atomic.LoadInt32(&scrapes)
+	body := gather()
+	if n := atomic.LoadInt32(&scrapes) - before; n != 1 {
+		t.Errorf("expected web02 alone scraped, got %d scrapes", n)
+	}
+	for _, want := range []string{
+		`apache_maintenance{target="web01"} 1`,
+		`apache_maintenance{target="web02"} 0`,
+		// From the scrape before.
+		`apache_up{target="web01"} 1`,
+		`apache_accesses_total{target="web01"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("expected %s in\n%s", want, body)
+		}
+	}
+
+	// Querying it.
+	if code, got := request("GET", ""); code != http.StatusOK || len(got) != 1 || !got[0].Until.Equal(targets[0].Until) {
+		t.Errorf("expected web01 listed, got %d, %+v", code, got)
+	}
+	rr := httptest.NewRecorder()
+	targetsAPIHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/targets", nil))
+	if !strings.Contains(rr.Body.String(), `"name":"web01"`) || strings.Count(rr.Body.String(), `"maintenanceUntil":null`) != 1 {
+		t.Errorf("expected web01 alone in maintenance in %s", rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	landingHandler(s, "/metrics", nil).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rr.Body.String(), "<td>maintenance until "+targets[0].Until.Format("2006-01-02 15:04:05 MST")+"</td>") {
+		t.Errorf("expected the maintenance on the landing page in\n%s", rr.Body.String())
+	}
+
+	// Cancelling it early.
+	if code, got := request("DELETE", "?target=web01"); code != http.StatusOK || len(got) != 0 {
+		t.Errorf("expected no target left in maintenance, got %d, %+v", code, got)
+	}
+	before = atomic.LoadInt32(&scrapes)
+	if body := gather(); !strings.Contains(body, `apache_maintenance{target="web01"} 0`) || atomic.LoadInt32(&scrapes)-before != 2 {
+		t.Errorf("expected both scraped again in\n%s", body)
+	}
+
+	// Expiring.
+	if code, got := request("POST", "?duration=100ms"); code != http.StatusOK || len(got) != 2 {
+		t.Fatalf("expected every target in maintenance, got %d, %+v", code, got)
+	}
+	if body := gather(); !strings.Contains(body, `apache_maintenance{target="web02"} 1`) {
+		t.Errorf("expected web02 in maintenance in\n%s", body)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if code, got := request("GET", ""); code != http.StatusOK || len(got) != 0 {
+		t.Errorf("expected the maintenance over, got %d, %+v", code, got)
+	}
+	if body := gather(); strings.Contains(body, "apache_maintenance{target=\"web01\"} 1") || strings.Contains(body, "apache_maintenance{target=\"web02\"} 1") {
+		t.Errorf("expected no target in maintenance in\n%s", body)
+	}
+
+	for _, tc := range []struct {
+		method, query string
+		code          int
+	}{
+		{"POST", "?target=web03&duration=1h", http.StatusNotFound},
+		{"POST", "?target=web01", http.StatusBadRequest},
+		{"POST", "?target=web01&duration=-1h", http.StatusBadRequest},
+		{"PUT", "", http.StatusMethodNotAllowed},
+	} {
+		if code, _ := request(tc.method, tc.query); code != tc.code {
+			t.Errorf("%s %s: expected %d, got %d", tc.method, tc.query, tc.code, code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	maintenanceHandler(s, false).ServeHTTP(rr, httptest.NewRequest("POST", "/-/maintenance?duration=1h", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 without -web.enable-lifecycle, got %d", rr.Code)
+	}
+}
+
+func TestMaintenanceWithoutCache(t *testing.T) {
+	defer func(old bool) { *maintenanceServeCached = old }(*maintenanceServeCached)
+	*maintenanceServeCached = false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	e := NewExporter(backend.URL)
+	s := newTargetSet(Exporters{e}, nil)
+	gather := func() string {
+		rr := httptest.NewRecorder()
+		metricsHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+	gather()
+	e.maintenance.set(time.Now().Add(time.Hour))
+	body := gather()
+	if !strings.Contains(body, "apache_maintenance 1\n") || strings.Contains(body, "apache_up") {
+		t.Errorf("expected apache_maintenance alone in\n%s", body)
+	}
+}
+
+func TestMaintenanceConfig(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected scrape")
+	}))
+	defer backend.Close()
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	es, err := newExporters([]target{{name: "web01", uri: backend.URL, conf: config.Target{MaintenanceUntil: until}}}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, func() (Exporters, error) {
+		return setupExporters([]string{"web01=" + backend.URL}, testDefaults, false)
+	})
+	rr := httptest.NewRecorder()
+	metricsHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	// Nothing cached to serve, never scraped.
+	if body := rr.Body.String(); !strings.Contains(body, "apache_maintenance 1\n") || strings.Contains(body, "apache_up") {
+		t.Errorf("expected the target in maintenance in\n%s", body)
+	}
+	if got := s.current()[0].maintenanceUntil(time.Now()); !got.Equal(until) {
+		t.Errorf("expected maintenance until %s, got %s", until, got)
+	}
+
+	// Set through /-/maintenance, it survives reloads.
+	s.current()[0].maintenance.set(until)
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.current()[0].maintenanceUntil(time.Now()); !got.Equal(until) {
+		t.Errorf("expected maintenance until %s after reloading, got %s", until, got)
+	}
+}
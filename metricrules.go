@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// metricRules holds the metric rules of the -config.file loaded last, as a
+// []config.MetricRule.
+var metricRules atomic.Value
+
+func currentMetricRules() []config.MetricRule {
+	rules, _ := metricRules.Load().([]config.MetricRule)
+	return rules
+}
+
+// rulesGatherer applies the current metric rules to what g gathers.
+type rulesGatherer struct {
+	g prometheus.Gatherer
+}
+
+func (r rulesGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := r.g.Gather()
+	return applyMetricRules(mfs, currentMetricRules()), err
+}
+
+// applyMetricRules applies rules, in order, to the series of the apache
+// metrics in mfs, leaving out the families with no series left. The other
+// metrics, such as go_*, are left alone.
+func applyMetricRules(mfs []*dto.MetricFamily, rules []config.MetricRule) []*dto.MetricFamily {
+	if len(rules) == 0 {
+		return mfs
+	}
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), namespace+"_") {
+			kept = append(kept, mf)
+			continue
+		}
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			if applyMetricRulesTo(mf.GetName(), m, rules) {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			mf.Metric = metrics
+			kept = append(kept, mf)
+		}
+	}
+	return kept
+}
+
+// applyMetricRulesTo applies rules to m of the family name, telling
+// whether it is kept.
+func applyMetricRulesTo(name string, m *dto.Metric, rules []config.MetricRule) bool {
+	labels := map[string]string{}
+	for _, lp := range m.Label {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	for i := range rules {
+		r := &rules[i]
+		if !r.AppliesTo(name) {
+			continue
+		}
+		matches := r.MatchesLabels(labels)
+		switch r.Action {
+		case "drop":
+			if matches {
+				return false
+			}
+		case "keep":
+			if !matches {
+				return false
+			}
+		case "rename_label":
+			if value, ok := labels[r.From]; ok && matches {
+				delete(labels, r.From)
+				labels[r.To] = value
+				m.Label = renameLabel(m.Label, r.From, r.To)
+			}
+		}
+	}
+	return true
+}
+
+// renameLabel renames the label from of pairs to, replacing any label
+// called to, and sorts them by name again.
+func renameLabel(pairs []*dto.LabelPair, from, to string) []*dto.LabelPair {
+	renamed := pairs[:0]
+	for _, lp := range pairs {
+		switch lp.GetName() {
+		case to:
+			continue
+		case from:
+			name := to
+			lp = &dto.LabelPair{Name: &name, Value: lp.Value}
+		}
+		renamed = append(renamed, lp)
+	}
+	sort.Slice(renamed, func(i, j int) bool { return renamed[i].GetName() < renamed[j].GetName() })
+	return renamed
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// ruleFixture gathers a synthetic collection of per-vhost series.
+func ruleFixture(t *testing.T) []*dto.MetricFamily {
+	reg := prometheus.NewRegistry()
+	accesses := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "apache_vhost_accesses_total", Help: "Accesses."}, []string{"target", "vhost"})
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "apache_up", Help: "Up."}, []string{"target"})
+	goroutines := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "go_goroutines", Help: "Goroutines."}, []string{"vhost"})
+	reg.MustRegister(accesses, up, goroutines)
+	for _, vhost := range []string{"www.example.com", "shop.example.com", "test.example.com"} {
+		accesses.WithLabelValues("web01", vhost).Add(1)
+	}
+	up.WithLabelValues("web01").Set(1)
+	goroutines.WithLabelValues("test.example.com").Set(10)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+// seriesOf renders the series of mfs, one per line, sorted as gathered.
+func seriesOf(mfs []*dto.MetricFamily) string {
+	var lines []string
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, lp := range m.GetLabel() {
+				labels = append(labels, lp.GetName()+"="+lp.GetValue())
+			}
+			lines = append(lines, mf.GetName()+"{"+strings.Join(labels, ",")+"}")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestApplyMetricRules(t *testing.T) {
+	for _, c := range []struct {
+		name, rules string
+		want        []string
+	}{
+		{"drop by name", `[{action: drop, name: apache_vhost_.*}]`, []string{
+			"apache_up{target=web01}",
+			"go_goroutines{vhost=test.example.com}",
+		}},
+		{"drop by label", `[{action: drop, labels: {vhost: "test\\..*"}}]`, []string{
+			"apache_up{target=web01}",
+			"apache_vhost_accesses_total{target=web01,vhost=shop.example.com}",
+			"apache_vhost_accesses_total{target=web01,vhost=www.example.com}",
+			"go_goroutines{vhost=test.example.com}",
+		}},
+		{"keep by label", `[{action: keep, name: apache_vhost_.*, labels: {vhost: "www\\..*"}}, {action: keep, labels: {target: web01}}]`, []string{
+			"apache_up{target=web01}",
+			"apache_vhost_accesses_total{target=web01,vhost=www.example.com}",
+			"go_goroutines{vhost=test.example.com}",
+		}},
+		{"rename label", `[{action: rename_label, from: vhost, to: site}, {action: drop, labels: {site: "shop\\..*"}}, {action: rename_label, from: target, to: a_host}]`, []string{
+			"apache_up{a_host=web01}",
+			"apache_vhost_accesses_total{a_host=web01,site=test.example.com}",
+			"apache_vhost_accesses_total{a_host=web01,site=www.example.com}",
+			"go_goroutines{vhost=test.example.com}",
+		}},
+	} {
+		cfg, err := config.Load([]byte("targets: [{uri: http://web01/}]\nmetric_rules: " + c.rules))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got := seriesOf(applyMetricRules(ruleFixture(t), cfg.MetricRules))
+		if want := strings.Join(c.want, "\n"); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, got, want)
+		}
+	}
+}
+
+func TestMetricRulesOnEndpoint(t *testing.T) {
+	cfg, err := config.Load([]byte("targets: [{uri: http://web01/}]\nmetric_rules: [{action: drop, name: apache_up}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricRules.Store(cfg.MetricRules)
+	defer metricRules.Store([]config.MetricRule(nil))
+	mfs, _, err := gatherOnce(Exporters{NewExporter("http://127.0.0.1:1/")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "apache_up" {
+			t.Error("expected apache_up to be dropped")
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace starts the names of all the metrics of the exporter, apache's
+// and its own, as set by -metrics.namespace.
+var namespace = "apache"
+
+func init() {
+	flag.StringVar(&namespace, "metrics.namespace", namespace, "Prefix of the names of all the metrics, such as ohs for Oracle HTTP Server or ihs for IBM HTTP Server.")
+}
+
+var namespaceRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// selfMetrics make the package-level metrics and descriptions, and
+// register those served on every request with registry.
+var selfMetrics []func()
+
+// selfMetric runs build now, with the default namespace, and again when
+// the namespace is set, which also brings in -metrics.const-label.
+func selfMetric(build func()) {
+	build()
+	selfMetrics = append(selfMetrics, build)
+}
+
+// setNamespace names the metrics after ns: the package-level metrics are
+// made anew, in a new registry and with the constant labels, and exporters
+// created from now on take it. It has to be called before anything is
+// served, and after the flags are parsed.
+func setNamespace(ns string) error {
+	if !namespaceRE.MatchString(ns) {
+		return fmt.Errorf("invalid -metrics.namespace %q: must start with a letter or underscore followed by letters, digits and underscores", ns)
+	}
+	namespace = ns
+	registry = prometheus.NewRegistry()
+	for _, build := range selfMetrics {
+		build()
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestSetNamespace(t *testing.T) {
+	if err := setNamespace("ohs"); err != nil {
+		t.Fatal(err)
+	}
+	defer setNamespace("apache")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	es, err := newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: ts.URL, Group: "frontend"},
+		{Name: "web02", URI: ts.URL},
+	}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newTargetSet(es, nil))
+	panics.WithLabelValues("status")
+	mfs, err := prometheus.Gatherers{registry, reg}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, mf := range mfs {
+		// Named as promhttp names them, like the go_ and process_ metrics.
+		if !strings.HasPrefix(mf.GetName(), "http_") {
+			names = append(names, mf.GetName())
+		}
+	}
+	sort.Strings(names)
+	want := []string{
+		"ohs_accesses_total",
+		"ohs_exporter_apache_restarts_total",
+		"ohs_exporter_build_info",
+		"ohs_exporter_coalesced_scrapes_total",
+		"ohs_exporter_collector_duration_seconds",
+		"ohs_exporter_collector_enabled",
+		"ohs_exporter_collector_success",
+		"ohs_exporter_collector_timeout_total",
+		"ohs_exporter_config_hash",
+		"ohs_exporter_config_last_reload_success_timestamp_seconds",
+		"ohs_exporter_config_last_reload_successful",
+		"ohs_exporter_config_reload_failures_total",
+		"ohs_exporter_field_present",
+		"ohs_exporter_log_level",
+		"ohs_exporter_panics_total",
+		"ohs_exporter_scrape_connections_total",
+		"ohs_exporter_scrape_failures_total",
+		"ohs_exporter_scrape_phase_duration_seconds",
+		"ohs_exporter_scrape_targets_unfinished",
+		"ohs_exporter_target_scrape_duration_seconds",
+		"ohs_exporter_target_scrape_failures_total",
+		"ohs_group_targets",
+		"ohs_group_targets_up",
+		"ohs_info",
+		"ohs_maintenance",
+		"ohs_sent_kilobytes_total",
+		"ohs_status_endpoint_available",
+		"ohs_up",
+		"ohs_uptime_seconds_total",
+		"ohs_workers",
+		"ohs_workers_utilization",
+	}
+	for _, name := range outputFamilies {
+		want = append(want, "ohs_"+name)
+	}
+	sort.Strings(want)
+	if got := strings.Join(names, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("expected the families\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+
+	for _, ns := range []string{"", "0hs", "ohs-http", "ohs:http", "ohs http"} {
+		if err := setNamespace(ns); err == nil || !strings.Contains(err.Error(), "-metrics.namespace") {
+			t.Errorf("%q: expected an invalid namespace, got %v", ns, err)
+		}
+	}
+	if namespace != "ohs" {
+		t.Errorf("expected an invalid namespace to be ignored, got %s", namespace)
+	}
+}
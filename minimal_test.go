@@ -0,0 +1,30 @@
+//go:build minimal
+// +build minimal
+
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+// outputFamilies are the families of the outputs compiled in,
+// without the namespace.
+var outputFamilies []string
+
+func TestMinimalBuild(t *testing.T) {
+	if len(discoveryMechanisms) != 0 || len(outputs) != 0 {
+		t.Errorf("expected no discovery mechanisms or outputs, got %d and %d", len(discoveryMechanisms), len(outputs))
+	}
+	for _, name := range []string{"discovery.consul.server", "discovery.dns-srv", "discovery.docker", "discovery.kubernetes", "push.gateway-url", "influx.url", "otlp.endpoint", "graphite.address", "remote-write.url", "statsd.address", "tracing.otlp-endpoint"} {
+		if flag.Lookup(name) != nil {
+			t.Errorf("expected no -%s in a minimal build", name)
+		}
+	}
+	// What every build has.
+	for _, name := range []string{"scrape.uri", "config.file", "targets.file", "discovery.refresh-interval", "web.listen-address"} {
+		if flag.Lookup(name) == nil {
+			t.Errorf("expected -%s in a minimal build", name)
+		}
+	}
+}
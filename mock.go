@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mockFaults are the ways the mock server misbehaves on every request.
+type mockFaults struct {
+	status   int           // Answered instead of 200 if set.
+	stall    time.Duration // Waited before answering.
+	truncate int           // Bytes the body is cut down to if set.
+}
+
+// runMockServer is the mock-server subcommand: it serves a status page
+// from the fixtures whose numbers drift over time, for trying the exporter
+// out without apache.
+func runMockServer(args []string) error {
+	fs := flag.NewFlagSet("mock-server", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "Address to serve the status page on.")
+	profile := fs.String("profile", "event-2.4", "Status page to serve: "+strings.Join(statusProfiles(), ", ")+".")
+	var faults mockFaults
+	fs.IntVar(&faults.status, "fault.status", 0, "Answer every request with this status code, such as 503, instead of the status page.")
+	fs.DurationVar(&faults.stall, "fault.stall", 0, "Wait this long before answering.")
+	fs.IntVar(&faults.truncate, "fault.truncate", 0, "Cut the body down to this many bytes.")
+	fs.Parse(args)
+	status, err := statusFixture(*profile)
+	if err != nil {
+		return err
+	}
+	logger.Info("Serving a status page", "profile", *profile, "address", *listen)
+	return http.ListenAndServe(*listen, mockHandler(status, time.Now(), time.Now, faults))
+}
+
+// mockHandler serves status, in the ?auto format if asked for and as
+// HTML otherwise. From start on, accesses, traffic and uptime grow at the
+// rates the status page gives, and busy and idle workers sway, going by
+// now.
+func mockHandler(status string, start time.Time, now func() time.Time, faults mockFaults) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if faults.stall > 0 {
+			select {
+			case <-time.After(faults.stall):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if faults.status != 0 {
+			http.Error(w, http.StatusText(faults.status), faults.status)
+			return
+		}
+		page := driftStatus(status, now().Sub(start))
+		contentType := "text/plain; charset=ISO-8859-1"
+		if _, auto := r.URL.Query()["auto"]; !auto {
+			page = mockHTML(page)
+			contentType = "text/html; charset=ISO-8859-1"
+		}
+		if faults.truncate > 0 && faults.truncate < len(page) {
+			page = page[:faults.truncate]
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(page))
+	})
+}
+
+// driftStatus returns status as it would read elapsed later.
+func driftStatus(status string, elapsed time.Duration) string {
+	values := map[string]float64{}
+	lines := strings.Split(status, "\n")
+	for _, l := range lines {
+		key, v := splitkv(l)
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			values[key] = f
+		}
+	}
+	secs := elapsed.Seconds()
+	reqPerSec := values["ReqPerSec"]
+	if reqPerSec == 0 {
+		reqPerSec = 1
+	}
+	workers := values["BusyWorkers"] + values["IdleWorkers"]
+	// Busy workers swing by up to half of them around their share, over
+	// ten minutes.
+	busy := math.Round(values["BusyWorkers"] * (1 + 0.5*math.Sin(2*math.Pi*secs/600)))
+	if busy > workers {
+		busy = workers
+	}
+	drifted := map[string]string{
+		"Total Accesses":      strconv.FormatFloat(math.Floor(values["Total Accesses"]+reqPerSec*secs), 'f', -1, 64),
+		"Total kBytes":        strconv.FormatFloat(math.Floor(values["Total kBytes"]+values["BytesPerSec"]/1024*secs), 'f', -1, 64),
+		"Uptime":              strconv.FormatFloat(math.Floor(values["Uptime"]+secs), 'f', -1, 64),
+		"ServerUptimeSeconds": strconv.FormatFloat(math.Floor(values["ServerUptimeSeconds"]+secs), 'f', -1, 64),
+		"BusyWorkers":         strconv.FormatFloat(busy, 'f', -1, 64),
+		"IdleWorkers":         strconv.FormatFloat(workers-busy, 'f', -1, 64),
+	}
+	for i, l := range lines {
+		key, v := splitkv(l)
+		if d, ok := drifted[key]; ok {
+			if _, known := values[key]; known {
+				lines[i] = key + ": " + d
+			}
+		} else if key == "Scoreboard" {
+			lines[i] = key + ": " + driftScoreboard(v, int(busy), int(workers-busy))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// driftScoreboard redraws scoreboard with busy slots sending and idle ones
+// waiting, leaving the open slots after them.
+func driftScoreboard(scoreboard string, busy, idle int) string {
+	if busy+idle > len(scoreboard) {
+		return scoreboard
+	}
+	return strings.Repeat("W", busy) + strings.Repeat("_", idle) + strings.Repeat(".", len(scoreboard)-busy-idle)
+}
+
+var mockTemplate = template.Must(template.New("mock").Parse(`<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
+<html><head>
+<title>Apache Status</title>
+</head><body>
+<h1>Apache Server Status for {{.Host}}</h1>
+<dl>
+{{- range .Lines}}
+<dt>{{.}}</dt>
+{{- end}}
+</dl>
+<pre>{{.Scoreboard}}</pre>
+</body></html>
+`))
+
+// mockHTML renders the ?auto status page page the way it would show
+// without ?auto, roughly.
+func mockHTML(page string) string {
+	var data struct {
+		Host       string
+		Lines      []string
+		Scoreboard string
+	}
+	for i, l := range strings.Split(page, "\n") {
+		key, v := splitkv(l)
+		switch {
+		case key == "Scoreboard":
+			data.Scoreboard = v
+		case v != "":
+			data.Lines = append(data.Lines, key+": "+v)
+		case i == 0 && key != "":
+			data.Host = key
+		}
+	}
+	var b strings.Builder
+	mockTemplate.Execute(&b, data)
+	return b.String()
+}
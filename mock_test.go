@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusFixtures(t *testing.T) {
+	profiles := statusProfiles()
+	// Fixtures are found by dropping them in testdata/status.
+	if got := strings.Join(profiles, " "); !strings.Contains(got, "event-2.4 extended-off huge-vhosts ohs-12c prefork-2.2 ") {
+		t.Errorf("unexpected profiles %s", got)
+	}
+	for _, profile := range profiles {
+		ts := httptest.NewServer(mockHandler(mustStatusFixture(profile), time.Now(), time.Now, mockFaults{}))
+		up := targetValues(t, Exporters{NewExporter(ts.URL + "/server-status?auto")}, "apache_up")
+		ts.Close()
+		if up[""] != 1 { // A single target has no target label.
+			t.Errorf("%s: expected the fixture to parse, got %v", profile, up)
+		}
+	}
+	if _, err := statusFixture("nginx"); err == nil || !strings.Contains(err.Error(), "event-2.4") {
+		t.Errorf("expected an error listing the profiles, got %v", err)
+	}
+}
+
+func TestMockDrift(t *testing.T) {
+	start := time.Now()
+	now := start
+	h := mockHandler(mustStatusFixture("prefork-2.2"), start, func() time.Time { return now }, mockFaults{})
+	get := func(uri string) string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", uri, nil))
+		return rr.Body.String()
+	}
+
+	for _, want := range []string{"Total Accesses: 302311\n", "Uptime: 45683\n", "BusyWorkers: 2\n", "CPULoad: 27.4052\n"} {
+		if page := get("/server-status?auto"); !strings.Contains(page, want) {
+			t.Errorf("expected the fixture's %q at the start in\n%s", want, page)
+		}
+	}
+	now = start.Add(150 * time.Second) // A quarter of the workers' swing.
+	page := get("/server-status?auto")
+	for _, want := range []string{"Total Accesses: 303303\n", "Uptime: 45833\n", "BusyWorkers: 3\n", "IdleWorkers: 7\n", "Scoreboard: WWW_______...."} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q in\n%s", want, page)
+		}
+	}
+	if html := get("/server-status"); !strings.Contains(html, "<dt>Total Accesses: 303303</dt>") || !strings.Contains(html, "<pre>WWW___") {
+		t.Errorf("expected the drifted values as HTML, got\n%s", html)
+	}
+}
+
+func TestMockFaults(t *testing.T) {
+	status := mustStatusFixture("event-2.4")
+	ts := httptest.NewServer(mockHandler(status, time.Now(), time.Now, mockFaults{status: http.StatusServiceUnavailable}))
+	resp, err := http.Get(ts.URL + "/server-status?auto")
+	ts.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", resp.StatusCode)
+	}
+
+	ts = httptest.NewServer(mockHandler(status, time.Now(), time.Now, mockFaults{truncate: 40}))
+	resp, err = http.Get(ts.URL + "/server-status?auto")
+	ts.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != status[:40] {
+		t.Errorf("expected the body cut to 40 bytes, got %q", body)
+	}
+
+	ts = httptest.NewServer(mockHandler(status, time.Now(), time.Now, mockFaults{stall: 200 * time.Millisecond}))
+	defer ts.Close()
+	start := time.Now()
+	resp, err = http.Get(ts.URL + "/server-status?auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected the answer to stall, took %s", elapsed)
+	}
+}
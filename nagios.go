@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// The exit codes, and states, of Nagios plugins.
+const (
+	nagiosOK = iota
+	nagiosWarning
+	nagiosCritical
+	nagiosUnknown
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosThresholds are what the check subcommand holds a target's workers
+// to. Ratios of 0 aren't checked.
+type nagiosThresholds struct {
+	warnBusyRatio, critBusyRatio float64
+	warnMinIdle, critMinIdle     int
+}
+
+func validateNagiosThresholds(t nagiosThresholds) error {
+	for _, r := range []struct {
+		name  string
+		value float64
+	}{{"-warn-busy-ratio", t.warnBusyRatio}, {"-crit-busy-ratio", t.critBusyRatio}} {
+		if r.value < 0 || r.value > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, got %v", r.name, r.value)
+		}
+	}
+	if t.warnBusyRatio > 0 && t.critBusyRatio > 0 && t.warnBusyRatio > t.critBusyRatio {
+		return fmt.Errorf("-warn-busy-ratio %v is above -crit-busy-ratio %v", t.warnBusyRatio, t.critBusyRatio)
+	}
+	if t.warnMinIdle < 0 || t.critMinIdle < 0 {
+		return fmt.Errorf("-warn-min-idle and -crit-min-idle can't be negative")
+	}
+	if t.warnMinIdle > 0 && t.critMinIdle > t.warnMinIdle {
+		return fmt.Errorf("-crit-min-idle %d is above -warn-min-idle %d", t.critMinIdle, t.warnMinIdle)
+	}
+	return nil
+}
+
+// nagiosEvaluate returns the state s is in by t, and the thresholds it
+// crossed. A busy ratio above a threshold crosses it, as do idle workers
+// fewer than a minimum; the worst crossed decides. Without worker counts
+// on the status page, a busy ratio to check is UNKNOWN.
+func nagiosEvaluate(s *serverStatus, t nagiosThresholds) (int, []string) {
+	state := nagiosOK
+	var crossed []string
+	cross := func(st int, format string, args ...interface{}) {
+		if st > state {
+			state = st
+		}
+		crossed = append(crossed, fmt.Sprintf(format, args...))
+	}
+	busy, idle := s.BusyWorkers, s.IdleWorkers
+	if t.warnBusyRatio > 0 || t.critBusyRatio > 0 {
+		if busy+idle == 0 {
+			return nagiosUnknown, []string{"no worker counts on the status page"}
+		}
+		ratio := busy / (busy + idle)
+		switch {
+		case t.critBusyRatio > 0 && ratio > t.critBusyRatio:
+			cross(nagiosCritical, "busy ratio %.2f above %g", ratio, t.critBusyRatio)
+		case t.warnBusyRatio > 0 && ratio > t.warnBusyRatio:
+			cross(nagiosWarning, "busy ratio %.2f above %g", ratio, t.warnBusyRatio)
+		}
+	}
+	switch {
+	case idle < float64(t.critMinIdle):
+		cross(nagiosCritical, "%g idle workers, fewer than %d", idle, t.critMinIdle)
+	case idle < float64(t.warnMinIdle):
+		cross(nagiosWarning, "%g idle workers, fewer than %d", idle, t.warnMinIdle)
+	}
+	return state, crossed
+}
+
+// nagiosPerfdata returns the performance data of s, with the thresholds
+// of t as Nagios ranges, and the accesses per second if rate isn't
+// negative.
+func nagiosPerfdata(s *serverStatus, t nagiosThresholds, rate float64) string {
+	workers := s.BusyWorkers + s.IdleWorkers
+	threshold := func(v float64, set bool, format string) string {
+		if !set {
+			return ""
+		}
+		return fmt.Sprintf(format, v)
+	}
+	perf := []string{
+		fmt.Sprintf("busy=%g;;;0;%g", s.BusyWorkers, workers),
+		fmt.Sprintf("idle=%g;%s;%s;0;%g", s.IdleWorkers,
+			threshold(float64(t.warnMinIdle), t.warnMinIdle > 0, "%g:"),
+			threshold(float64(t.critMinIdle), t.critMinIdle > 0, "%g:"), workers),
+	}
+	if workers > 0 {
+		perf = append(perf, fmt.Sprintf("busy_ratio=%.4g;%s;%s;0;1", s.BusyWorkers/workers,
+			threshold(t.warnBusyRatio, t.warnBusyRatio > 0, "%g"),
+			threshold(t.critBusyRatio, t.critBusyRatio > 0, "%g")))
+	}
+	if rate >= 0 {
+		perf = append(perf, fmt.Sprintf("accesses_per_second=%.4g;;;0", rate))
+	}
+	return strings.Join(perf, " ")
+}
+
+// accessesRate returns the accesses per second of the target named name
+// between st and its scrape at now, -1 if there is no telling: without
+// st, or if apache restarted in between.
+func accessesRate(st *savedState, name string, s *serverStatus, now time.Time) float64 {
+	if st == nil {
+		return -1
+	}
+	previous, ok := st.Targets[name]
+	elapsed := now.Sub(st.Saved).Seconds()
+	if !ok || elapsed <= 0 {
+		return -1
+	}
+	accesses, seen := previous["Total Accesses"]
+	if !seen || s.TotalAccesses < accesses {
+		return -1
+	}
+	if uptime, ok := previous["Uptime"]; ok && s.UptimeSeconds < uptime {
+		return -1
+	}
+	return (s.TotalAccesses - accesses) / elapsed
+}
+
+// nagiosCheck scrapes the target of es once, writes a Nagios status line
+// with performance data for it to w and returns the exit code. With a
+// statePath, the accesses rate is taken since the state saved there,
+// unless older than maxAge, and the state is saved for the next check.
+// Failing to scrape is UNKNOWN.
+func nagiosCheck(w io.Writer, es Exporters, t nagiosThresholds, statePath string, maxAge time.Duration) int {
+	unknown := func(format string, args ...interface{}) int {
+		fmt.Fprintf(w, "APACHE UNKNOWN - "+format+"\n", args...)
+		return nagiosUnknown
+	}
+	if len(es) != 1 {
+		return unknown("check takes a single target, got %d", len(es))
+	}
+	e := es[0]
+	var st *savedState
+	if statePath != "" {
+		st = loadState(statePath, maxAge, time.Now())
+		es.restore(st)
+	}
+	if _, _, err := gatherOnce(es); err != nil {
+		return unknown("%s", err)
+	}
+	now := time.Now()
+	e.last.mutex.Lock()
+	err, s := e.last.err, e.last.status
+	e.last.mutex.Unlock()
+	if err != nil {
+		return unknown("%s: %s", e.name, err)
+	}
+	if s == nil {
+		return unknown("%s: no status page scraped", e.name)
+	}
+	if statePath != "" {
+		if err := saveState(statePath, es, now); err != nil {
+			logger.Error("Error writing -state.file", "file", statePath, "err", err)
+		}
+	}
+
+	state, crossed := nagiosEvaluate(s, t)
+	summary := fmt.Sprintf("%g of %g workers busy, %g idle", s.BusyWorkers, s.BusyWorkers+s.IdleWorkers, s.IdleWorkers)
+	if len(crossed) > 0 {
+		summary = strings.Join(crossed, ", ") + "; " + summary
+	}
+	fmt.Fprintf(w, "APACHE %s - %s | %s\n", nagiosStates[state], summary, nagiosPerfdata(s, t, accessesRate(st, e.name, s, now)))
+	return state
+}
+
+// runNagiosCheck runs the check subcommand with args, which take the
+// thresholds besides the flags of the exporter, returning the exit code.
+func runNagiosCheck(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	var t nagiosThresholds
+	fs.Float64Var(&t.warnBusyRatio, "warn-busy-ratio", 0, "Share of the workers busy above which the check is WARNING, such as 0.8. 0 doesn't check.")
+	fs.Float64Var(&t.critBusyRatio, "crit-busy-ratio", 0, "Share of the workers busy above which the check is CRITICAL, such as 0.95. 0 doesn't check.")
+	fs.IntVar(&t.warnMinIdle, "warn-min-idle", 0, "Idle workers below which the check is WARNING.")
+	fs.IntVar(&t.critMinIdle, "crit-min-idle", 0, "Idle workers below which the check is CRITICAL.")
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.SetOutput(os.Stderr)
+	warnings, err := parseFlags(fs, args, os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return nagiosUnknown
+	}
+	if err == nil {
+		err = setupLogging()
+	}
+	if err == nil {
+		err = setNamespace(namespace)
+	}
+	if err == nil {
+		err = validateNagiosThresholds(t)
+	}
+	var es Exporters
+	if err == nil {
+		es, err = exportersFromFlags(false)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "APACHE UNKNOWN - %s\n", err)
+		return nagiosUnknown
+	}
+	for _, warning := range warnings {
+		logger.Warn(warning)
+	}
+	return nagiosCheck(w, es, t, *stateFile, *stateMaxAge)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNagiosEvaluate(t *testing.T) {
+	thresholds := nagiosThresholds{warnBusyRatio: 0.8, critBusyRatio: 0.95, warnMinIdle: 5, critMinIdle: 1}
+	for _, tc := range []struct {
+		busy, idle float64
+		t          nagiosThresholds
+		state      int
+		crossed    []string
+	}{
+		{10, 90, thresholds, nagiosOK, nil},
+		// At a threshold isn't above it, nor fewer than it.
+		{80, 20, thresholds, nagiosOK, nil},
+		{95, 5, thresholds, nagiosWarning, []string{"busy ratio 0.95 above 0.8"}},
+		{81, 19, thresholds, nagiosWarning, []string{"busy ratio 0.81 above 0.8"}},
+		{96, 4, thresholds, nagiosCritical, []string{"busy ratio 0.96 above 0.95", "4 idle workers, fewer than 5"}},
+		{10, 4, nagiosThresholds{warnMinIdle: 5, critMinIdle: 1}, nagiosWarning, []string{"4 idle workers, fewer than 5"}},
+		{10, 0, nagiosThresholds{warnMinIdle: 5, critMinIdle: 1}, nagiosCritical, []string{"0 idle workers, fewer than 1"}},
+		{10, 1, nagiosThresholds{critMinIdle: 1}, nagiosOK, nil},
+		// Only a critical busy ratio.
+		{90, 10, nagiosThresholds{critBusyRatio: 0.95}, nagiosOK, nil},
+		{99, 1, nagiosThresholds{critBusyRatio: 0.95}, nagiosCritical, []string{"busy ratio 0.99 above 0.95"}},
+		// No thresholds: OK whatever the workers.
+		{100, 0, nagiosThresholds{}, nagiosOK, nil},
+		{0, 0, nagiosThresholds{}, nagiosOK, nil},
+		// No worker counts to tell the busy ratio by.
+		{0, 0, thresholds, nagiosUnknown, []string{"no worker counts on the status page"}},
+		{0, 0, nagiosThresholds{critMinIdle: 1}, nagiosCritical, []string{"0 idle workers, fewer than 1"}},
+	} {
+		state, crossed := nagiosEvaluate(&serverStatus{BusyWorkers: tc.busy, IdleWorkers: tc.idle}, tc.t)
+		if state != tc.state || !reflect.DeepEqual(crossed, tc.crossed) {
+			t.Errorf("%g busy, %g idle, %+v: expected %s %q, got %s %q", tc.busy, tc.idle, tc.t, nagiosStates[tc.state], tc.crossed, nagiosStates[state], crossed)
+		}
+	}
+}
+
+func TestValidateNagiosThresholds(t *testing.T) {
+	for _, tc := range []struct {
+		t  nagiosThresholds
+		ok bool
+	}{
+		{nagiosThresholds{}, true},
+		{nagiosThresholds{warnBusyRatio: 0.8, critBusyRatio: 0.95, warnMinIdle: 5, critMinIdle: 1}, true},
+		{nagiosThresholds{warnBusyRatio: 0.9, critBusyRatio: 0.9}, true},
+		{nagiosThresholds{warnBusyRatio: 0.96}, true},
+		{nagiosThresholds{critMinIdle: 3}, true},
+		{nagiosThresholds{warnBusyRatio: 0.96, critBusyRatio: 0.95}, false},
+		{nagiosThresholds{critBusyRatio: 1.5}, false},
+		{nagiosThresholds{warnBusyRatio: -0.1}, false},
+		{nagiosThresholds{warnMinIdle: 1, critMinIdle: 2}, false},
+		{nagiosThresholds{critMinIdle: -1}, false},
+	} {
+		if err := validateNagiosThresholds(tc.t); (err == nil) != tc.ok {
+			t.Errorf("%+v: got %v", tc.t, err)
+		}
+	}
+}
+
+func TestNagiosPerfdata(t *testing.T) {
+	s := &serverStatus{BusyWorkers: 12, IdleWorkers: 38}
+	thresholds := nagiosThresholds{warnBusyRatio: 0.8, critBusyRatio: 0.95, critMinIdle: 1}
+	want := "busy=12;;;0;50 idle=38;;1:;0;50 busy_ratio=0.24;0.8;0.95;0;1 accesses_per_second=3.5;;;0"
+	if got := nagiosPerfdata(s, thresholds, 3.5); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	// Without workers there is no ratio, and without a rate no accesses.
+	want = "busy=0;;;0;0 idle=0;;;0;0"
+	if got := nagiosPerfdata(&serverStatus{}, nagiosThresholds{}, -1); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAccessesRate(t *testing.T) {
+	saved := time.Unix(1500000000, 0)
+	st := &savedState{Saved: saved, Targets: map[string]map[string]float64{
+		"web01": {"Total Accesses": 1000, "Uptime": 500},
+		"web02": {"Total Accesses": 1000},
+	}}
+	now := saved.Add(100 * time.Second)
+	for _, tc := range []struct {
+		st   *savedState
+		name string
+		s    serverStatus
+		want float64
+	}{
+		{st, "web01", serverStatus{TotalAccesses: 1500, UptimeSeconds: 600}, 5},
+		{st, "web02", serverStatus{TotalAccesses: 1200}, 2},
+		// Restarted: the counters or the uptime went down.
+		{st, "web01", serverStatus{TotalAccesses: 1500, UptimeSeconds: 60}, -1},
+		{st, "web02", serverStatus{TotalAccesses: 10}, -1},
+		{st, "web03", serverStatus{TotalAccesses: 1500}, -1},
+		{nil, "web01", serverStatus{TotalAccesses: 1500}, -1},
+	} {
+		if got := accessesRate(tc.st, tc.name, &tc.s, now); got != tc.want {
+			t.Errorf("%s %+v: expected %v, got %v", tc.name, tc.s, tc.want, got)
+		}
+	}
+}
+
+// web01 is an exporter for uri named web01, as the flags name targets.
+func web01(uri string) *Exporter {
+	e := NewExporter(uri)
+	e.name = "web01"
+	return e
+}
+
+func TestNagiosCheck(t *testing.T) {
+	accesses := 1000
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Total Accesses: %d\nUptime: 3600\nBusyWorkers: 44\nIdleWorkers: 6\n", accesses)
+	}))
+	defer ts.Close()
+	state := filepath.Join(t.TempDir(), "check.json")
+	thresholds := nagiosThresholds{warnBusyRatio: 0.8, critBusyRatio: 0.95, critMinIdle: 1}
+
+	check := func(es Exporters, t0 nagiosThresholds, wantCode int, wantLine string) {
+		t.Helper()
+		var buf bytes.Buffer
+		if code := nagiosCheck(&buf, es, t0, state, time.Hour); code != wantCode {
+			t.Errorf("expected exit code %d, got %d: %s", wantCode, code, buf.String())
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); !strings.HasPrefix(got, wantLine) {
+			t.Errorf("expected %q, got %q", wantLine, got)
+		}
+	}
+	check(Exporters{web01(ts.URL)}, thresholds, nagiosWarning,
+		"APACHE WARNING - busy ratio 0.88 above 0.8; 44 of 50 workers busy, 6 idle | busy=44;;;0;50 idle=6;;1:;0;50 busy_ratio=0.88;0.8;0.95;0;1")
+	// The second check has the accesses rate since the first.
+	accesses = 1100
+	var buf bytes.Buffer
+	nagiosCheck(&buf, Exporters{web01(ts.URL)}, nagiosThresholds{}, state, time.Hour)
+	if !strings.Contains(buf.String(), " accesses_per_second=") {
+		t.Errorf("expected the accesses rate, got %q", buf.String())
+	}
+	check(Exporters{web01(ts.URL)}, nagiosThresholds{critMinIdle: 10}, nagiosCritical, "APACHE CRITICAL - 6 idle workers, fewer than 10; ")
+
+	// Unreachable targets, and anything but one target, are UNKNOWN.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	check(Exporters{web01(down.URL)}, thresholds, nagiosUnknown, "APACHE UNKNOWN - web01: ")
+	check(Exporters{web01(ts.URL), web01(ts.URL)}, thresholds, nagiosUnknown, "APACHE UNKNOWN - check takes a single target, got 2")
+	check(nil, thresholds, nagiosUnknown, "APACHE UNKNOWN - check takes a single target, got 0")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// notifier sends systemd service notifications, as asked for by a unit of
+// Type=notify. It does nothing when systemd didn't ask for them.
+type notifier struct {
+	conn net.Conn
+}
+
+func newNotifier() *notifier {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return &notifier{}
+	}
+	if name[0] == '@' {
+		name = "\x00" + name[1:] // Abstract socket.
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		logger.Error("Can't notify systemd", "err", err)
+		return &notifier{}
+	}
+	return &notifier{conn: conn}
+}
+
+func (n *notifier) notify(state string) {
+	if n.conn == nil {
+		return
+	}
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		logger.Error("Error notifying systemd", "state", state, "err", err)
+	}
+}
+
+// watchdogInterval returns how often to ping systemd's watchdog, or 0 if it
+// isn't watching this process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// Pinging at half the timeout leaves room for a late ping.
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// watchdog pings systemd's watchdog every interval until done is closed,
+// skipping the pings while healthy returns false so that systemd restarts
+// an exporter that got stuck.
+func (n *notifier) watchdog(interval time.Duration, healthy func(time.Duration) bool, done <-chan struct{}) {
+	if n.conn == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if healthy(interval) {
+				n.notify("WATCHDOG=1")
+			} else {
+				logger.Error("Background scraping is stuck, not pinging the systemd watchdog")
+			}
+		case <-done:
+			return
+		}
+	}
+}
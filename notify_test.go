@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// notifySocket listens where NOTIFY_SOCKET points to for as long as the
+// test runs.
+func notifySocket(t *testing.T) *net.UnixConn {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("NOTIFY_SOCKET", path)
+	t.Cleanup(func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	})
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotifier(t *testing.T) {
+	conn := notifySocket(t)
+	n := newNotifier()
+	for _, state := range []string{"READY=1", "STOPPING=1"} {
+		n.notify(state)
+		if got := readNotification(t, conn); got != state {
+			t.Errorf("expected %s, got %s", state, got)
+		}
+	}
+}
+
+func TestNotifierWithoutSystemd(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	n := newNotifier()
+	n.notify("READY=1")
+	done := make(chan struct{})
+	close(done)
+	n.watchdog(time.Millisecond, func(time.Duration) bool { return true }, done)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	if got := watchdogInterval(); got != 15*time.Second {
+		t.Errorf("expected to ping every 15s, got %s", got)
+	}
+	os.Setenv("WATCHDOG_PID", "1")
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("expected no pings for another process' watchdog, got %s", got)
+	}
+	os.Unsetenv("WATCHDOG_PID")
+	os.Unsetenv("WATCHDOG_USEC")
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("expected no pings without a watchdog, got %s", got)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	conn := notifySocket(t)
+	n := newNotifier()
+	var healthy int32 = 1
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		n.watchdog(10*time.Millisecond, func(time.Duration) bool { return atomic.LoadInt32(&healthy) == 1 }, done)
+		close(stopped)
+	}()
+	if got := readNotification(t, conn); got != "WATCHDOG=1" {
+		t.Errorf("expected a watchdog ping, got %s", got)
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	// Drain the pings that may have raced with turning unhealthy.
+	for {
+		conn.SetReadDeadline(time.Now().Add(30 * time.Millisecond))
+		if _, err := conn.Read(make([]byte, 64)); err != nil {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Error("expected no pings while unhealthy")
+	}
+	close(done)
+	<-stopped
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	scrapeOnceOnly   = flag.Bool("once", false, "Scrape the targets once, print their metrics to stdout and exit, without serving anything. Exits 1 if a target failed.")
+	onceAllowPartial = flag.Bool("once.allow-partial", false, "With -once, exit 0 as long as at least one target was scraped successfully.")
+)
+
+// scrapeOnce scrapes es once and writes their metrics to w in the text
+// format. It fails if a target failed, or with allowPartial if all did.
+func scrapeOnce(w io.Writer, es Exporters, allowPartial bool) error {
+	mfs, failed, err := gatherOnce(es)
+	if err != nil {
+		return err
+	}
+	if err := writeText(w, mfs); err != nil {
+		return err
+	}
+	if len(failed) == 0 || allowPartial && len(failed) < len(es) {
+		return nil
+	}
+	return fmt.Errorf("%d of %d targets failed: %s", len(failed), len(es), strings.Join(failed, ", "))
+}
+
+// gatherOnce scrapes es, returning their metrics, after the metric rules,
+// and the names of the targets that failed.
+func gatherOnce(es Exporters) ([]*dto.MetricFamily, []string, error) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(es)
+	mfs, err := rulesGatherer{reg}.Gather()
+	if err != nil {
+		return nil, nil, err
+	}
+	var failed []string
+	for _, e := range es {
+		if !e.last.up() {
+			failed = append(failed, e.name)
+		}
+	}
+	return mfs, failed, nil
+}
+
+func writeText(w io.Writer, mfs []*dto.MetricFamily) error {
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScrapeOnce(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+
+	for _, tc := range []struct {
+		targets      []string
+		allowPartial bool
+		fails        bool
+	}{
+		{[]string{"good=" + good.URL}, false, false},
+		{[]string{"good=" + good.URL, "bad=" + bad.URL}, false, true},
+		{[]string{"good=" + good.URL, "bad=" + bad.URL}, true, false},
+		{[]string{"bad=" + bad.URL}, true, true},
+	} {
+		es, err := setupExporters(tc.targets, testDefaults, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = scrapeOnce(&out, es, tc.allowPartial)
+		if tc.fails != (err != nil) {
+			t.Errorf("%v, allow partial %t: expected failure %t, got %v", tc.targets, tc.allowPartial, tc.fails, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "bad") {
+			t.Errorf("expected the failed target named in %q", err)
+		}
+		for _, target := range tc.targets {
+			// A single target has no target label.
+			up := "apache_up "
+			if len(tc.targets) > 1 {
+				up = `apache_up{target="` + target[:strings.Index(target, "=")] + `"}`
+			}
+			if !strings.Contains(out.String(), up) {
+				t.Errorf("expected %s in\n%s", up, out.String())
+			}
+		}
+		if len(tc.targets) > 1 && !strings.Contains(out.String(), "# TYPE apache_workers gauge") {
+			t.Errorf("expected the text format in\n%s", out.String())
+		}
+	}
+}
@@ -0,0 +1,340 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+	"github.com/yosefy/apache_exporter/version"
+)
+
+var (
+	otlpEndpoint     = flag.String("otlp.endpoint", "", "Base URL of an OTLP receiver, such as http://collector:4318, or http://collector:4317 for grpc, to send the targets' metrics to every -otlp.interval, on /v1/metrics over http.")
+	otlpProtocol     = flag.String("otlp.protocol", "http", "OTLP transport to -otlp.endpoint, http with JSON encoding or grpc.")
+	otlpInterval     = flag.Duration("otlp.interval", time.Minute, "How often to scrape the targets and send them to -otlp.endpoint.")
+	otlpHeaders      = headerFlag{}
+	otlpCAFile       = flag.String("otlp.tls.ca-file", "", "CA certificate to verify -otlp.endpoint with.")
+	otlpCertFile     = flag.String("otlp.tls.cert-file", "", "Client certificate to present to -otlp.endpoint.")
+	otlpKeyFile      = flag.String("otlp.tls.key-file", "", "Key of -otlp.tls.cert-file.")
+	otlpInsecureSkip = flag.Bool("otlp.tls.insecure-skip-verify", false, "Don't verify the certificate of -otlp.endpoint.")
+
+	otlpFailures prometheus.Counter
+)
+
+func init() {
+	flag.Var(otlpHeaders, "otlp.header", "Header to send to -otlp.endpoint, as name=value. May be repeated.")
+	registerOutput(output{name: "otlp", setup: func() (func(s *targetSet, done <-chan struct{}), error) {
+		o, err := otlpExporterFromFlags()
+		if o == nil || err != nil {
+			return nil, err
+		}
+		return func(s *targetSet, done <-chan struct{}) {
+			runOTLP(o, s, *otlpInterval, done)
+		}, nil
+	}})
+	selfMetric(func() {
+		otlpFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "otlp_failures_total",
+			Help:        "Number of failed exports to -otlp.endpoint.",
+			ConstLabels: withConstLabels(nil),
+		})
+		registry.MustRegister(otlpFailures)
+	})
+}
+
+// headerFlag maps the names of headers to their values.
+type headerFlag map[string]string
+
+func (f headerFlag) String() string {
+	var s []string
+	for name := range f {
+		s = append(s, name+"="+redacted)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (f headerFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || strings.ContainsAny(kv[0], " \t\r\n:") {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	f[http.CanonicalHeaderKey(kv[0])] = kv[1]
+	return nil
+}
+
+// OTLP aggregation temporality of cumulative sums.
+const otlpCumulative = 2
+
+// The parts of the OTLP metrics data model, in its JSON encoding, that
+// counters and gauges map to.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpMetric struct {
+		Name        string     `json:"name"`
+		Description string     `json:"description,omitempty"`
+		Sum         *otlpSum   `json:"sum,omitempty"`
+		Gauge       *otlpGauge `json:"gauge,omitempty"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality"`
+		IsMonotonic            bool            `json:"isMonotonic"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpDataPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		AsDouble          float64         `json:"asDouble"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+// otlpExporter sends metrics to an OTLP receiver over HTTP, or over gRPC
+// if grpc is set.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+	grpc   *otlpGRPC
+	conf   config.HTTPConfig
+	start  time.Time // Of the cumulative sums.
+	deltas *deltas
+}
+
+func otlpExporterFromFlags() (*otlpExporter, error) {
+	if *otlpEndpoint == "" {
+		return nil, nil
+	}
+	if *otlpProtocol != "http" && *otlpProtocol != "grpc" {
+		return nil, fmt.Errorf("-otlp.protocol must be http or grpc, got %q", *otlpProtocol)
+	}
+	if *otlpInterval <= 0 {
+		return nil, fmt.Errorf("-otlp.interval must be positive, got %s", *otlpInterval)
+	}
+	conf := config.HTTPConfig{Headers: otlpHeaders}
+	if *otlpCAFile != "" || *otlpCertFile != "" || *otlpKeyFile != "" || *otlpInsecureSkip {
+		conf.TLSConfig = &config.TLSConfig{CAFile: *otlpCAFile, CertFile: *otlpCertFile, KeyFile: *otlpKeyFile}
+		if *otlpInsecureSkip {
+			conf.TLSConfig.InsecureSkipVerify = otlpInsecureSkip
+		}
+	}
+	var o *otlpExporter
+	var err error
+	if *otlpProtocol == "grpc" {
+		o, err = newOTLPGRPCExporter(*otlpEndpoint, conf, *scrapeTimeout)
+	} else {
+		o, err = newOTLPExporter(*otlpEndpoint, conf, newHTTPClient(clientConfigFromFlags()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	o.deltas = deltasFromFlags("otlp")
+	return o, nil
+}
+
+// newOTLPExporter returns an exporter to the OTLP receiver at endpoint. It
+// connects with client, unless conf has TLS settings, and sends the
+// headers of conf.
+func newOTLPExporter(endpoint string, conf config.HTTPConfig, client *http.Client) (*otlpExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-otlp.endpoint %q must be an http or https URL", endpoint)
+	}
+	if tc := conf.TLSConfig; tc != nil && (tc.CertFile == "") != (tc.KeyFile == "") {
+		return nil, fmt.Errorf("-otlp.tls.cert-file and -otlp.tls.key-file must be set together")
+	}
+	c, err := tlsClient(conf, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		client = c
+	}
+	return &otlpExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/metrics",
+		client: client,
+		conf:   conf,
+		start:  time.Now(),
+	}, nil
+}
+
+// export scrapes es and sends their metrics.
+func (o *otlpExporter) export(es Exporters) error {
+	mfs, _, err := gatherOnce(es)
+	if err != nil {
+		return err
+	}
+	mfs = o.deltas.apply(mfs)
+	single := ""
+	if len(es) == 1 {
+		single = es[0].name
+	}
+	req := otlpMetrics(mfs, single, o.start, time.Now())
+	if o.grpc != nil {
+		return o.grpc.send(req)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest("POST", o.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range o.conf.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// otlpMetrics converts mfs into OTLP metrics, one resource per target
+// carrying the target as the apache.target attribute instead of the target
+// label. single is the target when there is only one, whose metrics have
+// no target label. Counters become monotonic cumulative sums starting at
+// start, gauges and untyped metrics gauges; other types aren't exported by
+// the targets and are left out.
+func otlpMetrics(mfs []*dto.MetricFamily, single string, start, now time.Time) otlpRequest {
+	type family struct {
+		mf     *dto.MetricFamily
+		points []otlpDataPoint
+	}
+	targets := map[string][]*family{}
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+	for _, mf := range mfs {
+		byTarget := map[string]*family{}
+		for _, m := range mf.Metric {
+			var value float64
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			target := single
+			p := otlpDataPoint{TimeUnixNano: nowNano, AsDouble: value}
+			if mf.GetType() == dto.MetricType_COUNTER {
+				p.StartTimeUnixNano = startNano
+			}
+			for _, l := range m.Label {
+				if l.GetName() == "target" {
+					target = l.GetValue()
+					continue
+				}
+				p.Attributes = append(p.Attributes, otlpString(l.GetName(), l.GetValue()))
+			}
+			f := byTarget[target]
+			if f == nil {
+				f = &family{mf: mf}
+				byTarget[target] = f
+				targets[target] = append(targets[target], f)
+			}
+			f.points = append(f.points, p)
+		}
+	}
+
+	var names []string
+	for target := range targets {
+		names = append(names, target)
+	}
+	sort.Strings(names)
+	req := otlpRequest{ResourceMetrics: []otlpResourceMetrics{}}
+	for _, target := range names {
+		resource := otlpResource{Attributes: []otlpAttribute{otlpString("service.name", "apache")}}
+		if target != "" {
+			resource.Attributes = append(resource.Attributes, otlpString("apache.target", target))
+		}
+		scope := otlpScopeMetrics{Scope: otlpScope{Name: "apache_exporter", Version: version.Version}}
+		for _, f := range targets[target] {
+			m := otlpMetric{Name: f.mf.GetName(), Description: f.mf.GetHelp()}
+			if f.mf.GetType() == dto.MetricType_COUNTER {
+				m.Sum = &otlpSum{DataPoints: f.points, AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			} else {
+				m.Gauge = &otlpGauge{DataPoints: f.points}
+			}
+			scope.Metrics = append(scope.Metrics, m)
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, otlpResourceMetrics{Resource: resource, ScopeMetrics: []otlpScopeMetrics{scope}})
+	}
+	return req
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: value}}
+}
+
+// runOTLP exports the metrics of the current targets of s right away and
+// then every interval, until done is closed. Failures are logged and
+// counted, and the next interval tries again.
+func runOTLP(o *otlpExporter, s *targetSet, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := o.export(s.current()); err != nil {
+			otlpFailures.Inc()
+			logger.Error("Error exporting over OTLP", "url", sanitizeURI(o.url), "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpcinsecure "google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// otlpGRPC sends metrics to an OTLP receiver over gRPC.
+type otlpGRPC struct {
+	conn    *grpc.ClientConn
+	client  colmetricspb.MetricsServiceClient
+	headers metadata.MD
+	timeout time.Duration
+}
+
+// newOTLPGRPCExporter returns an exporter to the OTLP/gRPC receiver at
+// endpoint, such as http://collector:4317, over TLS for https, and sending
+// the headers of conf as metadata.
+func newOTLPGRPCExporter(endpoint string, conf config.HTTPConfig, timeout time.Duration) (*otlpExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-otlp.endpoint %q must be an http or https URL", endpoint)
+	}
+	if tc := conf.TLSConfig; tc != nil && (tc.CertFile == "") != (tc.KeyFile == "") {
+		return nil, fmt.Errorf("-otlp.tls.cert-file and -otlp.tls.key-file must be set together")
+	}
+	creds := grpcinsecure.NewCredentials()
+	if u.Scheme == "https" {
+		tc, err := conf.TLSConfig.ClientConfig(*insecure)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tc)
+	}
+	conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	g := &otlpGRPC{
+		conn:    conn,
+		client:  colmetricspb.NewMetricsServiceClient(conn),
+		headers: metadata.MD{},
+		timeout: timeout,
+	}
+	for name, value := range conf.Headers {
+		g.headers.Set(name, value)
+	}
+	return &otlpExporter{
+		url:   endpoint,
+		grpc:  g,
+		conf:  conf,
+		start: time.Now(),
+	}, nil
+}
+
+// send exports req, failing if the receiver rejects any of its data points.
+func (g *otlpGRPC) send(req otlpRequest) error {
+	ctx := metadata.NewOutgoingContext(context.Background(), g.headers)
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+	resp, err := g.client.Export(ctx, otlpProto(req))
+	if err != nil {
+		return err
+	}
+	if ps := resp.GetPartialSuccess(); ps.GetRejectedDataPoints() > 0 {
+		return fmt.Errorf("%d data points rejected: %s", ps.GetRejectedDataPoints(), ps.GetErrorMessage())
+	}
+	return nil
+}
+
+// otlpProto converts req from the JSON encoding to protobuf.
+func otlpProto(req otlpRequest) *colmetricspb.ExportMetricsServiceRequest {
+	out := &colmetricspb.ExportMetricsServiceRequest{}
+	for _, rm := range req.ResourceMetrics {
+		prm := &metricspb.ResourceMetrics{Resource: &resourcepb.Resource{Attributes: otlpProtoAttributes(rm.Resource.Attributes)}}
+		for _, sm := range rm.ScopeMetrics {
+			psm := &metricspb.ScopeMetrics{Scope: &commonpb.InstrumentationScope{Name: sm.Scope.Name, Version: sm.Scope.Version}}
+			for _, m := range sm.Metrics {
+				pm := &metricspb.Metric{Name: m.Name, Description: m.Description}
+				switch {
+				case m.Sum != nil:
+					pm.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						DataPoints:             otlpProtoPoints(m.Sum.DataPoints),
+						AggregationTemporality: metricspb.AggregationTemporality(m.Sum.AggregationTemporality),
+						IsMonotonic:            m.Sum.IsMonotonic,
+					}}
+				case m.Gauge != nil:
+					pm.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: otlpProtoPoints(m.Gauge.DataPoints)}}
+				}
+				psm.Metrics = append(psm.Metrics, pm)
+			}
+			prm.ScopeMetrics = append(prm.ScopeMetrics, psm)
+		}
+		out.ResourceMetrics = append(out.ResourceMetrics, prm)
+	}
+	return out
+}
+
+func otlpProtoPoints(points []otlpDataPoint) []*metricspb.NumberDataPoint {
+	var out []*metricspb.NumberDataPoint
+	for _, p := range points {
+		// The times were formatted from integers, so they parse back.
+		start, _ := strconv.ParseUint(p.StartTimeUnixNano, 10, 64)
+		now, _ := strconv.ParseUint(p.TimeUnixNano, 10, 64)
+		out = append(out, &metricspb.NumberDataPoint{
+			Attributes:        otlpProtoAttributes(p.Attributes),
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: p.AsDouble},
+		})
+	}
+	return out
+}
+
+func otlpProtoAttributes(attrs []otlpAttribute) []*commonpb.KeyValue {
+	var out []*commonpb.KeyValue
+	for _, a := range attrs {
+		out = append(out, &commonpb.KeyValue{Key: a.Key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: a.Value.StringValue}}})
+	}
+	return out
+}
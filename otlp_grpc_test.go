@@ -0,0 +1,173 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeGRPCCollector is an OTLP/gRPC receiver keeping the requests sent to
+// it.
+type fakeGRPCCollector struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	mu       sync.Mutex
+	requests []*colmetricspb.ExportMetricsServiceRequest
+	metadata []metadata.MD
+	rejected int64
+}
+
+func (c *fakeGRPCCollector) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.requests = append(c.requests, req)
+	c.metadata = append(c.metadata, md)
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if c.rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{RejectedDataPoints: c.rejected, ErrorMessage: "too old"}
+	}
+	return resp, nil
+}
+
+// serveGRPCCollector serves c on a local port until the test ends,
+// returning its URL.
+func serveGRPCCollector(t *testing.T, c *fakeGRPCCollector) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(s, c)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return "http://" + l.Addr().String()
+}
+
+func otlpProtoFind(rm *metricspb.ResourceMetrics, name string) *metricspb.Metric {
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+func otlpProtoStrings(attrs []*commonpb.KeyValue) map[string]string {
+	m := map[string]string{}
+	for _, a := range attrs {
+		m[a.Key] = a.Value.GetStringValue()
+	}
+	return m
+}
+
+func TestOTLPGRPCExport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	collector := &fakeGRPCCollector{}
+	endpoint := serveGRPCCollector(t, collector)
+
+	o, err := newOTLPGRPCExporter(endpoint, config.HTTPConfig{Headers: map[string]string{"X-Scope-Orgid": "apache"}}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.grpc.conn.Close()
+	es, err := newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: ts.URL},
+		{Name: "web02", URI: ts.URL},
+	}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.export(es); err != nil {
+		t.Fatal(err)
+	}
+	if len(collector.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(collector.requests))
+	}
+	if got := collector.metadata[0].Get("x-scope-orgid"); len(got) != 1 || got[0] != "apache" {
+		t.Errorf("expected the configured header as metadata, got %v", got)
+	}
+
+	byTarget := map[string]*metricspb.ResourceMetrics{}
+	for _, rm := range collector.requests[0].ResourceMetrics {
+		attrs := otlpProtoStrings(rm.Resource.Attributes)
+		if attrs["service.name"] != "apache" {
+			t.Errorf("expected service.name apache, got %v", attrs)
+		}
+		byTarget[attrs["apache.target"]] = rm
+	}
+	for _, target := range []string{"web01", "web02"} {
+		rm, ok := byTarget[target]
+		if !ok {
+			t.Errorf("no resource for %s", target)
+			continue
+		}
+		accesses := otlpProtoFind(rm, "apache_accesses_total")
+		sum := accesses.GetSum()
+		if sum == nil {
+			t.Fatalf("%s: expected apache_accesses_total as a sum, got %v", target, accesses)
+		}
+		if !sum.IsMonotonic || sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			t.Errorf("%s: expected a monotonic cumulative sum, got %v", target, sum)
+		}
+		p := sum.DataPoints[0]
+		if p.StartTimeUnixNano == 0 || p.StartTimeUnixNano > p.TimeUnixNano {
+			t.Errorf("%s: unexpected times %v", target, p)
+		}
+		if p.GetAsDouble() != 1 {
+			t.Errorf("%s: expected 1 access, got %v", target, p)
+		}
+		if _, ok := otlpProtoStrings(p.Attributes)["target"]; ok {
+			t.Errorf("%s: expected the target label to move to the resource, got %v", target, p.Attributes)
+		}
+
+		gauge := otlpProtoFind(rm, "apache_workers").GetGauge()
+		if gauge == nil {
+			t.Fatalf("%s: expected apache_workers as a gauge", target)
+		}
+		states := map[string]bool{}
+		for _, p := range gauge.DataPoints {
+			states[otlpProtoStrings(p.Attributes)["state"]] = true
+			if p.StartTimeUnixNano != 0 {
+				t.Errorf("%s: expected no start time on a gauge, got %v", target, p)
+			}
+		}
+		if !states["busy"] || !states["idle"] {
+			t.Errorf("%s: expected the state label as an attribute, got %v", target, states)
+		}
+	}
+
+	collector.rejected = 3
+	if err := o.export(es); err == nil {
+		t.Error("expected an error for rejected data points")
+	}
+}
+
+func TestOTLPGRPCExporterErrors(t *testing.T) {
+	for _, endpoint := range []string{"collector:4317", "ftp://collector"} {
+		if _, err := newOTLPGRPCExporter(endpoint, config.HTTPConfig{}, time.Second); err == nil {
+			t.Errorf("%s: expected an error", endpoint)
+		}
+	}
+	if _, err := newOTLPGRPCExporter("https://collector", config.HTTPConfig{TLSConfig: &config.TLSConfig{CertFile: "cert.pem"}}, time.Second); err == nil {
+		t.Error("expected an error for a certificate without key")
+	}
+}
@@ -0,0 +1,189 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// fakeCollector is an OTLP/HTTP receiver keeping the requests sent to it.
+type fakeCollector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+	headers  []http.Header
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unsupported", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+	w.Write([]byte("{}"))
+}
+
+// otlpFind returns the metric called name in rm, nil if there is none.
+func otlpFind(rm otlpResourceMetrics, name string) *otlpMetric {
+	for _, sm := range rm.ScopeMetrics {
+		for i, m := range sm.Metrics {
+			if m.Name == name {
+				return &sm.Metrics[i]
+			}
+		}
+	}
+	return nil
+}
+
+func otlpAttributes(attrs []otlpAttribute) map[string]string {
+	m := map[string]string{}
+	for _, a := range attrs {
+		m[a.Key] = a.Value.StringValue
+	}
+	return m
+}
+
+func TestOTLPExport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	collector := &fakeCollector{}
+	cs := httptest.NewServer(collector)
+	defer cs.Close()
+
+	o, err := newOTLPExporter(cs.URL+"/", config.HTTPConfig{Headers: map[string]string{"X-Scope-Orgid": "apache"}}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: ts.URL},
+		{Name: "web02", URI: ts.URL},
+	}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.export(es); err != nil {
+		t.Fatal(err)
+	}
+	if len(collector.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(collector.requests))
+	}
+	if got := collector.headers[0].Get("X-Scope-Orgid"); got != "apache" {
+		t.Errorf("expected the configured header, got %q", got)
+	}
+
+	byTarget := map[string]otlpResourceMetrics{}
+	for _, rm := range collector.requests[0].ResourceMetrics {
+		attrs := otlpAttributes(rm.Resource.Attributes)
+		if attrs["service.name"] != "apache" {
+			t.Errorf("expected service.name apache, got %v", attrs)
+		}
+		byTarget[attrs["apache.target"]] = rm
+	}
+	for _, target := range []string{"web01", "web02"} {
+		rm, ok := byTarget[target]
+		if !ok {
+			t.Errorf("no resource for %s in %+v", target, collector.requests[0])
+			continue
+		}
+		accesses := otlpFind(rm, "apache_accesses_total")
+		if accesses == nil || accesses.Sum == nil || accesses.Gauge != nil {
+			t.Fatalf("%s: expected apache_accesses_total as a sum, got %+v", target, accesses)
+		}
+		if !accesses.Sum.IsMonotonic || accesses.Sum.AggregationTemporality != otlpCumulative {
+			t.Errorf("%s: expected a monotonic cumulative sum, got %+v", target, accesses.Sum)
+		}
+		p := accesses.Sum.DataPoints[0]
+		start, _ := strconv.ParseInt(p.StartTimeUnixNano, 10, 64)
+		now, _ := strconv.ParseInt(p.TimeUnixNano, 10, 64)
+		if start == 0 || start > now {
+			t.Errorf("%s: unexpected times %+v", target, p)
+		}
+		if _, ok := otlpAttributes(p.Attributes)["target"]; ok {
+			t.Errorf("%s: expected the target label to move to the resource, got %+v", target, p.Attributes)
+		}
+
+		workers := otlpFind(rm, "apache_workers")
+		if workers == nil || workers.Gauge == nil || workers.Sum != nil {
+			t.Fatalf("%s: expected apache_workers as a gauge, got %+v", target, workers)
+		}
+		states := map[string]bool{}
+		for _, p := range workers.Gauge.DataPoints {
+			states[otlpAttributes(p.Attributes)["state"]] = true
+			if p.StartTimeUnixNano != "" {
+				t.Errorf("%s: expected no start time on a gauge, got %+v", target, p)
+			}
+		}
+		if !states["busy"] || !states["idle"] {
+			t.Errorf("%s: expected the state label as an attribute, got %v", target, states)
+		}
+	}
+}
+
+func TestOTLPSingleTarget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	e := NewExporter(ts.URL)
+	mfs, _, err := gatherOnce(Exporters{e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := otlpMetrics(mfs, e.name, time.Unix(1, 0), time.Unix(2, 0))
+	if len(req.ResourceMetrics) != 1 {
+		t.Fatalf("expected 1 resource, got %+v", req)
+	}
+	rm := req.ResourceMetrics[0]
+	if got := otlpAttributes(rm.Resource.Attributes)["apache.target"]; got != e.name {
+		t.Errorf("expected the target %s, got %q", e.name, got)
+	}
+	up := otlpFind(rm, "apache_up")
+	if up == nil || up.Gauge == nil || up.Gauge.DataPoints[0].AsDouble != 1 || up.Gauge.DataPoints[0].TimeUnixNano != "2000000000" {
+		t.Errorf("unexpected apache_up %+v", up)
+	}
+	if accesses := otlpFind(rm, "apache_accesses_total"); accesses == nil || accesses.Sum.DataPoints[0].StartTimeUnixNano != "1000000000" {
+		t.Errorf("unexpected apache_accesses_total %+v", accesses)
+	}
+}
+
+func TestOTLPExporterErrors(t *testing.T) {
+	for _, endpoint := range []string{"collector:4318", "ftp://collector"} {
+		if _, err := newOTLPExporter(endpoint, config.HTTPConfig{}, http.DefaultClient); err == nil {
+			t.Errorf("%s: expected an error", endpoint)
+		}
+	}
+	if _, err := newOTLPExporter("https://collector", config.HTTPConfig{TLSConfig: &config.TLSConfig{CertFile: "cert.pem"}}, http.DefaultClient); err == nil || !strings.Contains(err.Error(), "together") {
+		t.Errorf("expected an error for a certificate without key, got %v", err)
+	}
+	f := headerFlag{}
+	if err := f.Set("x-scope-orgid=a=b"); err != nil || f["X-Scope-Orgid"] != "a=b" {
+		t.Errorf("unexpected headers %v, %v", f, err)
+	}
+	if f.String() != "X-Scope-Orgid="+redacted {
+		t.Errorf("expected header values hidden, got %s", f)
+	}
+	for _, v := range []string{"X-Scope-Orgid", "bad header=x", "=x"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
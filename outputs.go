@@ -0,0 +1,18 @@
+package main
+
+// output sends the metrics of the targets somewhere on a schedule of its
+// own. Each registers itself from the file it is in, which builds tagged
+// minimal leave out.
+type output struct {
+	name string
+	// setup checks the flags of the output, returning how to run it until
+	// done is closed, or nil if the flags don't ask for it.
+	setup func() (func(s *targetSet, done <-chan struct{}), error)
+}
+
+// outputs are the outputs compiled in.
+var outputs []output
+
+func registerOutput(o output) {
+	outputs = append(outputs, o)
+}
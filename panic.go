@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	panics *prometheus.CounterVec
+
+	// panicked are the collectors whose stack was logged already.
+	panicked sync.Map
+)
+
+func init() {
+	selfMetric(func() {
+		panics = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_panics_total",
+			Help:        "Number of panics recovered from while scraping apache, by the collector that panicked: status for fetching and parsing the status page, or a group of apache metrics.",
+			ConstLabels: withConstLabels(nil),
+		}, []string{"collector"})
+		registry.MustRegister(panics)
+	})
+}
+
+// recoverPanic runs f, turning a panic in it into a scrape error counted
+// under collector. The stack is logged the first time collector panics,
+// and afterwards only the panic.
+func recoverPanic(collector string, f func()) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panics.WithLabelValues(collector).Inc()
+		if _, logged := panicked.LoadOrStore(collector, true); logged {
+			logger.Error("Panic in collector", "collector", collector, "panic", r)
+		} else {
+			logger.Error("Panic in collector", "collector", collector, "panic", r, "stack", string(debug.Stack()))
+		}
+		err = &scrapeError{reasonPanic, fmt.Errorf("panic in collector %s: %v", collector, r)}
+	}()
+	f()
+	return nil
+}
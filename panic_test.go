@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectorPanic(t *testing.T) {
+	defer func(old []groupCollector) { groupCollectors = old }(groupCollectors)
+	collectorEnabled["test_panics"] = true
+	defer delete(collectorEnabled, "test_panics")
+	groupCollectors = append(groupCollectors, groupCollector{name: "test_panics", collect: func(*Exporter, map[string]float64, chan<- prometheus.Metric) {
+		var rows []string
+		_ = rows[3]
+	}})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	e := NewExporter(backend.URL)
+	server := httptest.NewServer(metricsHandler(newTargetSet(Exporters{e}, nil)))
+	defer server.Close()
+
+	for i := 1; i <= 2; i++ {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("expected the exporter to survive the panic: %v", err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		body := string(b)
+		for _, want := range []string{"apache_up 0", "apache_workers", `apache_exporter_scrape_failures_total{reason="panic"}`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected %q in\n%s", want, body)
+			}
+		}
+		if v := counterValue(t, panics.WithLabelValues("test_panics")); v != float64(i) {
+			t.Errorf("expected %d panics counted, got %v", i, v)
+		}
+	}
+	if v := counterValue(t, e.scrapeFailures.WithLabelValues(reasonPanic)); v != 2 {
+		t.Errorf("expected 2 failed scrapes, got %v", v)
+	}
+}
+
+func TestStatusPanic(t *testing.T) {
+	before := counterValue(t, panics.WithLabelValues("status"))
+	e := NewExporter("http://127.0.0.1:1/server-status?auto")
+	e.client = nil
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		e.Collect(ch)
+	}()
+	drain(ch)
+	if v := counterValue(t, panics.WithLabelValues("status")); v != before+1 {
+		t.Errorf("expected the panic counted under status, got %v", v-before)
+	}
+	if v := counterValue(t, e.scrapeFailures.WithLabelValues(reasonPanic)); v != 1 {
+		t.Errorf("expected a failed scrape, got %v", v)
+	}
+}
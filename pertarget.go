@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var perTargetPaths = flag.Bool("web.per-target-paths", false, "Also serve each named target at <web.telemetry-path>/<name>, with only its metrics and those of the exporter itself. Targets named after a light collector path are shadowed by it.")
+
+// targetScraper is a scraper that can also collect a single target.
+type targetScraper interface {
+	scraper
+	collectTarget(ctx context.Context, e *Exporter, ch chan<- prometheus.Metric)
+}
+
+// collectTarget scrapes e, leaving out the metrics of the groups and the
+// reloads.
+func (s *targetSet) collectTarget(ctx context.Context, e *Exporter, ch chan<- prometheus.Metric) {
+	Exporters{e}.collectTargets(ctx, ch)
+}
+
+// collectTarget sends the latest background scrape of e, if any.
+func (b *backgroundScraper) collectTarget(_ context.Context, e *Exporter, ch chan<- prometheus.Metric) {
+	b.mutex.Lock()
+	r := b.results[e.name]
+	b.mutex.Unlock()
+	if r != nil && reflect.DeepEqual(r.labels, e.labels) {
+		r.send(time.Now(), ch)
+	}
+}
+
+// singleTarget collects e through s.
+type singleTarget struct {
+	s   targetScraper
+	e   *Exporter
+	ctx context.Context
+}
+
+func (t singleTarget) Describe(ch chan<- *prometheus.Desc) {
+	t.e.Describe(ch)
+}
+
+func (t singleTarget) Collect(ch chan<- prometheus.Metric) {
+	t.s.collectTarget(t.ctx, t.e, ch)
+}
+
+// perTargetHandler serves what e says of the named target of targets that
+// the path names after prefix, scraped through s. The target is looked up
+// on every request, so reloads add and remove pages.
+func perTargetHandler(s targetScraper, targets *targetSet, prefix string, e endpoint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		var target *Exporter
+		if targetNameRE.MatchString(name) {
+			for _, t := range targets.current() {
+				if t.name == name {
+					target = t
+					break
+				}
+			}
+		}
+		if target == nil {
+			http.Error(w, fmt.Sprintf("Unknown target %q", name), http.StatusNotFound)
+			return
+		}
+		e.serve(w, r, func(ctx context.Context) prometheus.Collector { return singleTarget{s, target, ctx} })
+	})
+}
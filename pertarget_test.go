@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// perTargetGet gets the page of url, failing t if it isn't code.
+func perTargetGet(t *testing.T, url string, code int) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != code {
+		t.Errorf("%s: expected %d, got %d: %s", url, code, resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestPerTargetHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+
+	args := []string{"a=" + backend.URL, "b=" + backend.URL, backend.URL}
+	load := func() (Exporters, error) {
+		return setupExporters(args, testDefaults, false)
+	}
+	es, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := newTargetSet(es, load)
+	background := newBackgroundScraper(targets, time.Hour, 0)
+	for name, s := range map[string]targetScraper{"on scrape": targets, "background": background} {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", endpointHandler(s, endpoint{}))
+			mux.Handle("/metrics/", perTargetHandler(s, targets, "/metrics/", endpoint{}))
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			if s == background {
+				for _, e := range targets.current() {
+					background.wg.Add(1)
+					background.scrapeTarget(e, make(chan struct{}, 1), nil)
+				}
+			}
+
+			for _, tc := range []struct{ name, other string }{{"a", "b"}, {"b", "a"}} {
+				body := perTargetGet(t, server.URL+"/metrics/"+tc.name, http.StatusOK)
+				if !strings.Contains(body, `apache_up{target="`+tc.name+`"} 1`) {
+					t.Errorf("%s: expected its apache_up, got:\n%s", tc.name, body)
+				}
+				if strings.Contains(body, `target="`+tc.other+`"`) || strings.Contains(body, `target="http`) {
+					t.Errorf("%s: expected only its own metrics, got:\n%s", tc.name, body)
+				}
+				if !strings.Contains(body, "apache_exporter_build_info") {
+					t.Errorf("%s: expected the metrics of the exporter, got:\n%s", tc.name, body)
+				}
+				if strings.Contains(body, "apache_group_targets") {
+					t.Errorf("%s: expected no metrics of the groups, got:\n%s", tc.name, body)
+				}
+			}
+			body := perTargetGet(t, server.URL+"/metrics", http.StatusOK)
+			for _, name := range []string{"a", "b"} {
+				if !strings.Contains(body, `apache_up{target="`+name+`"} 1`) {
+					t.Errorf("expected /metrics to keep every target, got:\n%s", body)
+				}
+			}
+			// Unknown and unnamed targets, and paths below a target.
+			for _, path := range []string{"c", "", "a/b", strings.TrimPrefix(backend.URL, "http://")} {
+				perTargetGet(t, server.URL+"/metrics/"+path, http.StatusNotFound)
+			}
+		})
+	}
+
+	// Reloads add and remove pages.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics/", perTargetHandler(targets, targets, "/metrics/", endpoint{}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	args = []string{"a=" + backend.URL, "c=" + backend.URL}
+	if err := targets.reload(); err != nil {
+		t.Fatal(err)
+	}
+	perTargetGet(t, server.URL+"/metrics/b", http.StatusNotFound)
+	if body := perTargetGet(t, server.URL+"/metrics/c", http.StatusOK); !strings.Contains(body, `apache_up{target="c"} 1`) {
+		t.Errorf("expected the reloaded target, got:\n%s", body)
+	}
+}
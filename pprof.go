@@ -0,0 +1,18 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/pprof"
+)
+
+var enablePprof = flag.Bool("web.enable-pprof", false, "Serve Go profiling data under /debug/pprof/, for debugging the exporter itself.")
+
+// handlePprof adds the net/http/pprof handlers to mux.
+func handlePprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
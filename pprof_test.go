@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPprof(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", landingHandler(newTargetSet(nil, nil), "/metrics", nil))
+	get := func(h http.Handler, path string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Code
+	}
+	if code := get(mux, "/debug/pprof/heap"); code != http.StatusNotFound {
+		t.Errorf("expected 404 with pprof disabled, got %d", code)
+	}
+
+	handlePprof(mux)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/goroutine", "/debug/pprof/cmdline"} {
+		if code := get(mux, path); code != http.StatusOK {
+			t.Errorf("%s: expected 200 with pprof enabled, got %d", path, code)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := newBasicAuth("prometheus", path, webAuthExempt.values, mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := get(h, "/debug/pprof/heap"); code != http.StatusUnauthorized {
+		t.Errorf("expected profiles to require auth, got %d", code)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	webExternalURL = flag.String("web.external-url", "", "URL the exporter is reachable under for its users, such as through a reverse proxy, for the links it serves. Its path is the default -web.route-prefix.")
+	webRoutePrefix = flag.String("web.route-prefix", "", "Path prefix all endpoints are served under. Defaults to the path of -web.external-url.")
+)
+
+// routing parses -web.external-url and -web.route-prefix the way
+// Prometheus does, returning the external URL, nil if not given, and the
+// route prefix without its trailing slash, "" for none.
+func routing(externalURL, routePrefix string) (*url.URL, string, error) {
+	var external *url.URL
+	if externalURL != "" {
+		u, err := url.Parse(externalURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid -web.external-url: %v", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, "", fmt.Errorf("-web.external-url %q must be an absolute URL", externalURL)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		external = u
+		if routePrefix == "" {
+			routePrefix = u.Path
+		}
+	}
+	routePrefix = strings.Trim(routePrefix, "/")
+	if routePrefix == "" {
+		return external, "", nil
+	}
+	return external, "/" + routePrefix, nil
+}
+
+// withPrefix serves h under prefix, stripped from the paths h sees, and
+// 404s requests outside it. The prefix itself is redirected to the landing
+// page under it, at external if given.
+func withPrefix(prefix string, external *url.URL, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	root := prefix + "/"
+	if external != nil {
+		root = external.String() + "/"
+	}
+	stripped := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == prefix:
+			http.Redirect(w, r, root, http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, prefix+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
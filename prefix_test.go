@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouting(t *testing.T) {
+	for _, c := range []struct {
+		externalURL, routePrefix string
+		external, prefix         string
+	}{
+		{"", "", "", ""},
+		{"", "/", "", ""},
+		{"", "apache/", "", "/apache"},
+		{"https://ops.example.com/exporters/apache-web01/", "", "https://ops.example.com/exporters/apache-web01", "/exporters/apache-web01"},
+		{"https://ops.example.com/exporters/apache-web01", "/", "https://ops.example.com/exporters/apache-web01", ""},
+		{"https://ops.example.com", "/apache", "https://ops.example.com", "/apache"},
+	} {
+		external, prefix, err := routing(c.externalURL, c.routePrefix)
+		if err != nil {
+			t.Errorf("%q, %q: %v", c.externalURL, c.routePrefix, err)
+			continue
+		}
+		got := ""
+		if external != nil {
+			got = external.String()
+		}
+		if got != c.external || prefix != c.prefix {
+			t.Errorf("%q, %q: expected %q and %q, got %q and %q", c.externalURL, c.routePrefix, c.external, c.prefix, got, prefix)
+		}
+	}
+	for _, externalURL := range []string{"/apache", "ops.example.com/apache", "http://%zz"} {
+		if _, _, err := routing(externalURL, ""); err == nil {
+			t.Errorf("expected an error for -web.external-url %q", externalURL)
+		}
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	for _, externalURL := range []string{"", "https://ops.example.com/apache/"} {
+		external, prefix, err := routing(externalURL, "/apache/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/", landingHandler(newTargetSet(nil, nil), "/metrics", external))
+		mux.Handle("/metrics", healthzHandler())
+		mux.Handle("/healthz", healthzHandler())
+		h := withPrefix(prefix, external, mux)
+		get := func(path string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			return rr
+		}
+
+		for _, path := range []string{"/apache/metrics", "/apache/healthz", "/apache/"} {
+			if rr := get(path); rr.Code != http.StatusOK {
+				t.Errorf("%s: expected 200, got %d", path, rr.Code)
+			}
+		}
+		for _, path := range []string{"/metrics", "/", "/apachemetrics", "/other/apache/metrics"} {
+			if rr := get(path); rr.Code != http.StatusNotFound {
+				t.Errorf("%s: expected 404 outside the prefix, got %d", path, rr.Code)
+			}
+		}
+
+		link, root := `href="./metrics"`, "/apache/"
+		if external != nil {
+			link, root = `href="https://ops.example.com/apache/metrics"`, "https://ops.example.com/apache/"
+		}
+		if body := get("/apache/").Body.String(); !strings.Contains(body, link) {
+			t.Errorf("expected %s in\n%s", link, body)
+		}
+		if rr := get("/apache"); rr.Code != http.StatusFound || rr.Header().Get("Location") != root {
+			t.Errorf("expected a redirect to %s, got %d to %q", root, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	probeAllowedTargets = &targetsFlag{}
+	probeDenyPrivate    = flag.Bool("probe.deny-private-networks", false, "Refuse /probe targets at loopback, private, link-local and unspecified addresses, such as 127.0.0.1, 10.0.0.0/8 or 169.254.169.254, unless a network of -probe.allowed-targets holds them.")
+
+	deniedProbes prometheus.Counter
+)
+
+func init() {
+	flag.Var(probeAllowedTargets, "probe.allowed-targets", "Target /probe may scrape: a network such as 10.0.0.0/8, an address, a host name or a glob of them such as *.web.example.com. Others get a 403. Addresses are checked as resolved when connecting, on every redirect too. May be repeated or comma separated. All are allowed if none is given.")
+	selfMetric(func() {
+		deniedProbes = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_probes_denied_total",
+			Help:        "Number of /probe requests refused by -probe.allowed-targets or -probe.deny-private-networks.",
+			ConstLabels: withConstLabels(nil),
+		})
+	})
+}
+
+// targetPolicy is what /probe targets may be, by address and by name.
+type targetPolicy struct {
+	networks    []*net.IPNet
+	hosts       []string // Names and globs, in lowercase.
+	denyPrivate bool
+
+	overrides map[string]string // As with -scrape.resolve.
+	lookup    func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// newTargetPolicy returns the policy of the allowed targets and of
+// denyPrivate, nil if it allows any target.
+func newTargetPolicy(allowed []string, denyPrivate bool, overrides map[string]string) (*targetPolicy, error) {
+	if len(allowed) == 0 && !denyPrivate {
+		return nil, nil
+	}
+	p := &targetPolicy{
+		denyPrivate: denyPrivate,
+		overrides:   overrides,
+		lookup:      net.DefaultResolver.LookupIPAddr,
+	}
+	for _, target := range allowed {
+		if ip := net.ParseIP(target); ip != nil {
+			p.networks = append(p.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+			continue
+		}
+		if strings.Contains(target, "/") {
+			_, network, err := net.ParseCIDR(target)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -probe.allowed-targets %q: %v", target, err)
+			}
+			p.networks = append(p.networks, network)
+			continue
+		}
+		if _, err := path.Match(target, ""); err != nil {
+			return nil, fmt.Errorf("invalid -probe.allowed-targets %q: %v", target, err)
+		}
+		p.hosts = append(p.hosts, strings.ToLower(target))
+	}
+	return p, nil
+}
+
+// targetDeniedError is a target a targetPolicy refuses.
+type targetDeniedError struct {
+	host string
+	ip   net.IP // Nil if refused by its name.
+}
+
+func (e *targetDeniedError) Error() string {
+	if e.ip == nil || e.ip.String() == e.host {
+		return fmt.Sprintf("target %s is not allowed", e.host)
+	}
+	return fmt.Sprintf("target %s is not allowed at %s", e.host, e.ip)
+}
+
+// isPrivateIP tells whether ip is one -probe.deny-private-networks refuses.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+func (p *targetPolicy) allowsName(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *targetPolicy) inNetworks(ip net.IP) bool {
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost refuses host if no address it may resolve to could be allowed.
+// Those it resolves to are left to checkIP.
+func (p *targetPolicy) checkHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(host, ip)
+	}
+	// A name may resolve into one of the networks.
+	if len(p.networks) > 0 || len(p.hosts) == 0 || p.allowsName(host) {
+		return nil
+	}
+	return &targetDeniedError{host: host}
+}
+
+// checkIP refuses host at ip. Networks allow any address in them, and
+// names any address but, with denyPrivate, the private ones.
+func (p *targetPolicy) checkIP(host string, ip net.IP) error {
+	switch {
+	case p.inNetworks(ip):
+		return nil
+	case p.denyPrivate && isPrivateIP(ip):
+	case len(p.hosts) == 0 && len(p.networks) == 0, p.allowsName(host):
+		return nil
+	}
+	return &targetDeniedError{host: host, ip: ip}
+}
+
+// checkAddrs refuses host if any address it resolved to is refused.
+func (p *targetPolicy) checkAddrs(host string, ips []net.IPAddr) error {
+	for _, ip := range ips {
+		if err := p.checkIP(host, ip.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check refuses the target at uri before it is scraped. The addresses are
+// checked again when connecting, as the name may resolve elsewhere by then.
+func (p *targetPolicy) check(ctx context.Context, uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if err := p.checkHost(host); err != nil {
+		return err
+	}
+	if net.ParseIP(host) != nil || !p.denyPrivate && p.allowsName(host) {
+		return nil
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443", "h3": "443"}[u.Scheme]
+	}
+	lookup := host
+	if ip, ok := p.overrides[strings.ToLower(net.JoinHostPort(host, port))]; ok {
+		lookup = ip
+	}
+	ips, err := p.lookup(ctx, lookup)
+	if err != nil {
+		// Left to the scrape to fail on.
+		return nil
+	}
+	return p.checkAddrs(host, ips)
+}
+
+// probeDenial is where the scrape of a probe records that the policy
+// refused it, as when it redirected elsewhere.
+type probeDenial struct {
+	mutex sync.Mutex
+	err   error
+}
+
+type probeDenialKey struct{}
+
+// denyProbe records err in the probeDenial of ctx, if it has one.
+func denyProbe(ctx context.Context, err error) {
+	if d, ok := ctx.Value(probeDenialKey{}).(*probeDenial); ok {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if d.err == nil {
+			d.err = err
+		}
+	}
+}
+
+func (d *probeDenial) get() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.err
+}
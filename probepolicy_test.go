@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// fakeLookup resolves the hosts of addrs, nothing else.
+func fakeLookup(addrs map[string][]string) func(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IPAddr{{IP: ip}}, nil
+		}
+		ips, ok := addrs[host]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		var resolved []net.IPAddr
+		for _, ip := range ips {
+			resolved = append(resolved, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return resolved, nil
+	}
+}
+
+func TestTargetPolicy(t *testing.T) {
+	lookup := fakeLookup(map[string][]string{
+		"web.example.com":      {"203.0.113.5"},
+		"rebound.example.com":  {"10.0.0.5"},
+		"intranet.example.com": {"192.0.2.44"},
+		"mixed.example.com":    {"192.0.2.44", "10.0.0.1"},
+		"internal.example.com": {"203.0.113.50"},
+		"public.example.com":   {"203.0.113.7", "2001:db8::7"},
+		"local.example.com":    {"127.0.0.1"},
+	})
+	for _, tc := range []struct {
+		allowed     []string
+		denyPrivate bool
+		uri         string
+		ok          bool
+	}{
+		{[]string{"web.example.com"}, false, "http://web.example.com/server-status?auto", true},
+		{[]string{"web.example.com"}, false, "https://WEB.example.com.:8443/server-status?auto", true},
+		{[]string{"web.example.com"}, false, "http://other.example.com/server-status?auto", false},
+		{[]string{"web.example.com"}, false, "http://127.0.0.1/server-status?auto", false},
+		{[]string{"*.apache.example.com"}, false, "http://a.b.apache.example.com/server-status?auto", true},
+		{[]string{"*.apache.example.com"}, false, "http://apache.example.com/server-status?auto", false},
+
+		// Allowed names, but not at private addresses.
+		{[]string{"*.example.com", "192.0.2.0/24"}, true, "http://web.example.com/server-status?auto", true},
+		{[]string{"*.example.com", "192.0.2.0/24"}, true, "http://rebound.example.com/server-status?auto", false},
+		// Unless in an allowed network.
+		{[]string{"10.0.0.0/8"}, true, "http://rebound.example.com/server-status?auto", true},
+
+		// IP literals.
+		{[]string{"192.0.2.0/24", "198.51.100.7"}, false, "http://192.0.2.10/server-status?auto", true},
+		{[]string{"192.0.2.0/24", "198.51.100.7"}, false, "http://198.51.100.7/server-status?auto", true},
+		{[]string{"192.0.2.0/24", "198.51.100.7"}, false, "http://198.51.100.8/server-status?auto", false},
+		{[]string{"192.0.2.0/24"}, false, "http://[2001:db8::1]/server-status?auto", false},
+		{nil, true, "http://169.254.169.254/latest/meta-data", false},
+		{nil, true, "http://[::1]:8080/server-status?auto", false},
+		{nil, true, "http://0.0.0.0/server-status?auto", false},
+		{nil, true, "http://203.0.113.9/server-status?auto", true},
+
+		// Names by the addresses they resolve to.
+		{[]string{"192.0.2.0/24"}, false, "http://intranet.example.com/server-status?auto", true},
+		{[]string{"192.0.2.0/24"}, false, "http://internal.example.com/server-status?auto", false},
+		{[]string{"192.0.2.0/24"}, false, "http://mixed.example.com/server-status?auto", false},
+		{nil, true, "http://public.example.com/server-status?auto", true},
+		{nil, true, "http://local.example.com/server-status?auto", false},
+		// Left to the scrape to fail.
+		{[]string{"192.0.2.0/24"}, false, "http://missing.example.com/server-status?auto", true},
+	} {
+		p, err := newTargetPolicy(tc.allowed, tc.denyPrivate, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.lookup = lookup
+		err = p.check(context.Background(), tc.uri)
+		var denied *targetDeniedError
+		if err != nil && !errors.As(err, &denied) {
+			t.Errorf("%s: unexpected error %v", tc.uri, err)
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("%v, %v, %s: expected allowed %v, got %v", tc.allowed, tc.denyPrivate, tc.uri, tc.ok, err)
+		}
+	}
+}
+
+func TestTargetPolicyOverrides(t *testing.T) {
+	p, err := newTargetPolicy([]string{"192.0.2.0/24"}, false, map[string]string{"web.example.com:8080": "192.0.2.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.lookup = fakeLookup(map[string][]string{"web.example.com": {"203.0.113.5"}})
+	if err := p.check(context.Background(), "http://web.example.com:8080/server-status?auto"); err != nil {
+		t.Errorf("expected -scrape.resolve to be followed, got %v", err)
+	}
+	if err := p.check(context.Background(), "http://web.example.com/server-status?auto"); err == nil {
+		t.Error("expected another port not to be overridden")
+	}
+}
+
+func TestNewTargetPolicy(t *testing.T) {
+	if p, err := newTargetPolicy(nil, false, nil); p != nil || err != nil {
+		t.Errorf("expected no policy, got %v, %v", p, err)
+	}
+	for _, allowed := range []string{"10.0.0.0/33", "web[.example.com", "web/example"} {
+		if _, err := newTargetPolicy([]string{allowed}, false, nil); err == nil || !strings.Contains(err.Error(), "-probe.allowed-targets") {
+			t.Errorf("%s: expected an error naming the flag, got %v", allowed, err)
+		}
+	}
+}
+
+func TestDialerPolicy(t *testing.T) {
+	p, err := newTargetPolicy([]string{"web.example.com"}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Resolved to what the check allowed, then to loopback when dialing.
+	d := &dialer{
+		policy: p,
+		lookup: fakeLookup(map[string][]string{"web.example.com": {"127.0.0.1"}}),
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			t.Errorf("unexpected dial of %s", addr)
+			return nil, errors.New("dialed")
+		},
+	}
+	denial := &probeDenial{}
+	ctx := context.WithValue(context.Background(), probeDenialKey{}, denial)
+	var denied *targetDeniedError
+	if _, err := d.DialContext(ctx, "tcp", "web.example.com:80"); !errors.As(err, &denied) {
+		t.Errorf("expected the target refused, got %v", err)
+	}
+	if denial.get() == nil {
+		t.Error("expected the denial recorded")
+	}
+}
+
+func TestDialerPolicyProxy(t *testing.T) {
+	var proxied int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&proxied, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The proxy is allowed, the private address the target resolves to
+	// isn't.
+	overrides := map[string]string{"web.example.com:80": "10.1.2.3"}
+	p, err := newTargetPolicy([]string{"127.0.0.1/32", "web.example.com"}, true, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newHTTPClient(clientConfig{timeout: time.Second, resolve: overrides, policy: p, proxy: http.ProxyURL(proxyURL)})
+	resp, err := client.Get("http://web.example.com/server-status?auto")
+	if err == nil {
+		resp.Body.Close()
+	}
+	var denied *targetDeniedError
+	if !errors.As(err, &denied) {
+		t.Errorf("expected the target refused, got %v", err)
+	}
+	if n := atomic.LoadInt64(&proxied); n != 0 {
+		t.Errorf("expected the proxy bypassed, got %d requests through it", n)
+	}
+
+	// Without a policy, the proxy is used.
+	client = newHTTPClient(clientConfig{timeout: time.Second, proxy: http.ProxyURL(proxyURL)})
+	resp, err = client.Get("http://web.example.com/server-status?auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := atomic.LoadInt64(&proxied); n != 1 {
+		t.Errorf("expected the request through the proxy, got %d", n)
+	}
+}
+
+func TestProbeAllowedTargets(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, backend.URL+r.URL.RequestURI(), http.StatusFound)
+	}))
+	defer redirect.Close()
+	port := func(s *httptest.Server) string {
+		_, port, _ := net.SplitHostPort(strings.TrimPrefix(s.URL, "http://"))
+		return port
+	}
+	overrides := map[string]string{
+		"web.example.com:" + port(backend):       "127.0.0.1",
+		"redirect.example.com:" + port(redirect): "127.0.0.1",
+		"internal.example.com:" + port(backend):  "127.0.0.1",
+	}
+	p, err := newTargetPolicy([]string{"web.example.com", "redirect.example.com", "192.0.2.0/24"}, false, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newHTTPClient(clientConfig{timeout: time.Second, resolve: overrides, policy: p})
+	mux := http.NewServeMux()
+	mux.Handle("/probe", probeHandler(client, p, testDefaults, func() map[string]config.Module { return nil }))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	code, body := probe(t, server, "web.example.com:"+port(backend))
+	if code != http.StatusOK || !strings.Contains(body, "apache_up 1") {
+		t.Errorf("allowed host: expected 200 with apache_up 1, got %d: %s", code, body)
+	}
+
+	for _, tc := range []struct{ name, target string }{
+		{"IP literal", backend.URL},
+		{"name resolving outside the networks", "internal.example.com:" + port(backend)},
+		{"redirect to an IP literal", "redirect.example.com:" + port(redirect)},
+	} {
+		before := counterValue(t, deniedProbes)
+		if code, body := probe(t, server, tc.target); code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d: %s", tc.name, code, body)
+		}
+		if v := counterValue(t, deniedProbes); v != before+1 {
+			t.Errorf("%s: expected the denial counted, got %v after %v", tc.name, v, before)
+		}
+	}
+}
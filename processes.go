@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"html"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// processConnectionColumns are the columns of the process table of the
+// event MPM exported, by the state of apache_process_connections.
+var processConnectionColumns = []struct {
+	state  string
+	column string
+}{
+	{"total", "connections total"},
+	{"writing", "async connections writing"},
+	{"keepalive", "async connections keep-alive"},
+	{"closing", "async connections closing"},
+}
+
+func newProcessConnectionsDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("process_connections", "Connections of the apache child process, in total and the asynchronous ones by state, from the event MPM's process table.", []string{"pid", "state"}, labels)
+}
+
+// indexTag returns the index in s of the first tag opening with prefix,
+// such as "<td", regardless of case, or -1.
+func indexTag(s, prefix string) int {
+	for i := 0; ; {
+		j := strings.IndexByte(s[i:], '<')
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if len(s)-i >= len(prefix) && strings.EqualFold(s[i:i+len(prefix)], prefix) {
+			return i
+		}
+		i++
+	}
+}
+
+// nextElement finds the first element of s opening with the tag prefix
+// open and closing with the tag close, returning its content and what
+// follows it. Pages are scanned in place, rather than copied.
+func nextElement(s, open, close string) (content, rest string, ok bool) {
+	i := indexTag(s, open)
+	if i < 0 {
+		return "", "", false
+	}
+	s = s[i+len(open):]
+	i = strings.IndexByte(s, '>')
+	if i < 0 {
+		return "", "", false
+	}
+	s = s[i+1:]
+	if i = indexTag(s, close); i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+len(close):], true
+}
+
+// tableCell is a cell of an HTML table.
+type tableCell struct {
+	header  bool
+	text    string // Without tags, unescaped and lowercased.
+	rowspan int
+	colspan int
+}
+
+// cellText returns the text of the cell content s, allocating only for
+// cells with tags, entities or capitals.
+func cellText(s string) string {
+	if strings.IndexByte(s, '<') >= 0 {
+		var b strings.Builder
+		for {
+			i := strings.IndexByte(s, '<')
+			if i < 0 {
+				break
+			}
+			b.WriteString(s[:i])
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				s = s[i:]
+				break
+			}
+			s = s[i+end+1:]
+		}
+		b.WriteString(s)
+		s = b.String()
+	}
+	return strings.ToLower(strings.TrimSpace(html.UnescapeString(s)))
+}
+
+// cellSpan returns the rowspan or colspan, by name, of the cell
+// attributes attrs, 1 without one.
+func cellSpan(attrs, name string) int {
+	for i := 0; i+len(name) <= len(attrs); i++ {
+		if !strings.EqualFold(attrs[i:i+len(name)], name) || i > 0 && isWordByte(attrs[i-1]) {
+			continue
+		}
+		v := strings.TrimLeft(attrs[i+len(name):], " \t\r\n")
+		if !strings.HasPrefix(v, "=") {
+			continue
+		}
+		v = strings.TrimLeft(v[1:], " \t\r\n")
+		v = strings.TrimPrefix(v, `"`)
+		end := 0
+		for end < len(v) && v[end] >= '0' && v[end] <= '9' {
+			end++
+		}
+		if n, err := strconv.Atoi(v[:end]); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 1
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// cellEnd returns the index in s of the first </td> or </th>, or -1.
+func cellEnd(s string) int {
+	td, th := indexTag(s, "</td>"), indexTag(s, "</th>")
+	if td < 0 || th >= 0 && th < td {
+		return th
+	}
+	return td
+}
+
+// parseTableRow appends the cells of row to cells.
+func parseTableRow(cells []tableCell, row string) []tableCell {
+	for {
+		i := indexTag(row, "<t")
+		if i < 0 || i+2 == len(row) {
+			return cells
+		}
+		row = row[i+2:]
+		kind := row[0] | 0x20 // Lowercased.
+		if kind != 'h' && kind != 'd' {
+			continue
+		}
+		end := strings.IndexByte(row, '>')
+		if end < 0 {
+			return cells
+		}
+		attrs, content := row[1:end], row[end+1:]
+		if end = cellEnd(content); end < 0 {
+			return cells
+		}
+		cells = append(cells, tableCell{
+			header:  kind == 'h',
+			text:    cellText(content[:end]),
+			rowspan: cellSpan(attrs, "rowspan"),
+			colspan: cellSpan(attrs, "colspan"),
+		})
+		row = content[end+len("</td>"):]
+	}
+}
+
+// tableColumns names the columns of a table headed by a row of headers
+// spanning either both rows, named as they are, or several columns named
+// by the second row, as "connections" over "total" makes
+// "connections total".
+func tableColumns(first, second []tableCell) []string {
+	var columns []string
+	var grouped []int // The columns named by second.
+	var groups []string
+	for _, c := range first {
+		if c.rowspan > 1 || second == nil {
+			for i := 0; i < c.colspan; i++ {
+				columns = append(columns, c.text)
+			}
+			continue
+		}
+		for i := 0; i < c.colspan; i++ {
+			grouped = append(grouped, len(columns))
+			groups = append(groups, c.text)
+			columns = append(columns, c.text)
+		}
+	}
+	for i, c := range second {
+		if i < len(grouped) {
+			columns[grouped[i]] = groups[i] + " " + c.text
+		}
+	}
+	return columns
+}
+
+// processConnections are the connections of a child process by state.
+type processConnections struct {
+	pid    string
+	counts []float64 // By processConnectionColumns, NaN if not shown.
+}
+
+// processColumns indexes the columns of a table by their names in
+// headers, nil unless it is the process table.
+func processColumns(headers [][]tableCell) map[string]int {
+	if len(headers) == 0 {
+		return nil
+	}
+	var second []tableCell
+	if len(headers) > 1 {
+		second = headers[1]
+	}
+	index := map[string]int{}
+	for i, name := range tableColumns(headers[0], second) {
+		if _, ok := index[name]; !ok {
+			index[name] = i
+		}
+	}
+	_, hasPID := index["pid"]
+	if _, hasTotal := index["connections total"]; !hasPID || !hasTotal {
+		return nil
+	}
+	return index
+}
+
+// processRow returns the process of a row of the process table with the
+// columns of index, false for the Sum row and rows without a PID.
+func processRow(cells []tableCell, index map[string]int) (processConnections, bool) {
+	pidColumn := index["pid"]
+	if len(cells) <= pidColumn || cells[0].text == "sum" {
+		return processConnections{}, false
+	}
+	pid := cells[pidColumn].text
+	if _, err := strconv.ParseUint(pid, 10, 64); err != nil {
+		return processConnections{}, false
+	}
+	p := processConnections{pid: pid, counts: make([]float64, 0, len(processConnectionColumns))}
+	for _, c := range processConnectionColumns {
+		v := math.NaN()
+		if i, ok := index[c.column]; ok && i < len(cells) {
+			if n, err := strconv.ParseFloat(cells[i].text, 64); err == nil {
+				v = n
+			}
+		}
+		p.counts = append(p.counts, v)
+	}
+	return p, true
+}
+
+// parseProcessTable finds the process table of the event MPM in the HTML
+// status page page and returns its processes, nil without one. The columns
+// are found by their headers, which differ between apache versions. The
+// Sum row is left out, for the processes to add up to it. Tables are
+// skipped as soon as their headers tell they aren't the process table, and
+// the page after it isn't looked at.
+func parseProcessTable(page string) []processConnections {
+	var cells []tableCell // Of the row at hand, reused by the next.
+tables:
+	for {
+		table, rest, ok := nextElement(page, "<table", "</table>")
+		if !ok {
+			return nil
+		}
+		page = rest
+		var headers [][]tableCell
+		var index map[string]int // Once past the headers.
+		processes := []processConnections{}
+		for {
+			row, rest, ok := nextElement(table, "<tr", "</tr>")
+			if !ok {
+				break
+			}
+			table = rest
+			cells = parseTableRow(cells[:0], row)
+			if len(cells) == 0 {
+				continue
+			}
+			if index == nil && cells[0].header {
+				// The headers keep their cells.
+				headers = append(headers, cells)
+				cells = nil
+				continue
+			}
+			if index == nil {
+				if index = processColumns(headers); index == nil {
+					continue tables
+				}
+			}
+			if p, ok := processRow(cells, index); ok {
+				processes = append(processes, p)
+			}
+		}
+		if index == nil && processColumns(headers) == nil {
+			continue
+		}
+		return processes
+	}
+}
+
+// htmlStatusURI returns the URI of the HTML status page of a target
+// scraped at uri, the one without auto in its query.
+func htmlStatusURI(uri string) (*url.URL, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Del("auto")
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// collectProcesses fetches the HTML status page of e and sends the
+// connections of each child process in its process table to ch. Only the
+// event MPM shows one, so there is nothing to send with other MPMs, and
+// the page isn't fetched again until -capabilities.recheck-interval is
+// over.
+func (e *Exporter) collectProcesses(ctx context.Context, ch chan<- prometheus.Metric) error {
+	now := time.Now()
+	if e.capabilities.absent(capProcessTable, now) {
+		return nil
+	}
+	u, err := htmlStatusURI(e.URI)
+	if err != nil {
+		return err
+	}
+	resp, data, err := e.fetchPage(ctx, u)
+	if resp == nil {
+		if err == nil {
+			e.capabilities.record(capProcessTable, false, now)
+		}
+		return err
+	}
+	processes := parseProcessTable(string(data))
+	e.capabilities.record(capProcessTable, len(processes) > 0, now)
+	for _, p := range processes {
+		for i, c := range processConnectionColumns {
+			if v := p.counts[i]; !math.IsNaN(v) {
+				ch <- prometheus.MustNewConstMetric(e.processesDesc, prometheus.GaugeValue, v, p.pid, c.state)
+			}
+		}
+	}
+	return nil
+}
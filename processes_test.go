@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProcessTable(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want map[string][]float64 // By pid, counts by processConnectionColumns.
+	}{
+		{"2.4.18.html", map[string][]float64{
+			"2301": {7, 0, 5, 1},
+			"2302": {2, 0, 0, 0},
+		}},
+		// With the Slot and Stopping columns, the PID isn't first.
+		{"2.4.58.html", map[string][]float64{
+			"4120": {61, 2, 44, 2},
+			"4121": {245, 35, 190, 8},
+			"3980": {3, 0, 0, 2},
+		}},
+	} {
+		page, err := ioutil.ReadFile(filepath.Join("testdata", "processes", tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		processes := parseProcessTable(string(page))
+		if len(processes) != len(tc.want) {
+			t.Errorf("%s: expected %d processes, got %v", tc.file, len(tc.want), processes)
+			continue
+		}
+		for _, p := range processes {
+			if got, want := fmt.Sprint(p.counts), fmt.Sprint(tc.want[p.pid]); got != want {
+				t.Errorf("%s: pid %s: expected %s, got %s", tc.file, p.pid, want, got)
+			}
+		}
+	}
+}
+
+func TestParseProcessTableMissing(t *testing.T) {
+	// The worker table of prefork has a PID column, but no connections.
+	prefork := `<table border="0"><tr><th>Srv</th><th>PID</th><th>Acc</th><th>M</th></tr>
+<tr><td><b>0-0</b></td><td>1201</td><td>0/1/1</td><td><b>W</b></td></tr>
+</table>`
+	if processes := parseProcessTable(prefork); processes != nil {
+		t.Errorf("expected no process table, got %v", processes)
+	}
+	// Columns left out of the table are left out of the processes.
+	partial := `<table><tr><th rowspan="2">PID</th><th colspan="1">Connections</th></tr>
+<tr><th>total</th></tr>
+<tr><td>7</td><td>3</td></tr></table>`
+	processes := parseProcessTable(partial)
+	if len(processes) != 1 || processes[0].counts[0] != 3 || !math.IsNaN(processes[0].counts[1]) {
+		t.Errorf("expected only the total connections, got %v", processes)
+	}
+}
+
+func TestCollectProcesses(t *testing.T) {
+	defer func(old map[string]bool) { collectorEnabled = old }(collectorEnabled)
+	collectorEnabled = map[string]bool{"processes": true}
+	page, err := ioutil.ReadFile(filepath.Join("testdata", "processes", "2.4.58.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["auto"]; ok {
+			w.Write([]byte(apache24Status))
+			return
+		}
+		query = r.URL.RawQuery
+		w.Write(page)
+	}))
+	defer server.Close()
+
+	rr := httptest.NewRecorder()
+	e := NewExporter(server.URL + "/server-status?auto&token=x")
+	metricsHandler(newTargetSet(Exporters{e}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	if query != "token=x" {
+		t.Errorf("expected the HTML page to be fetched with the rest of the query, got %q", query)
+	}
+	for _, want := range []string{
+		`apache_process_connections{pid="4121",state="keepalive"} 190`,
+		`apache_process_connections{pid="4121",state="total"} 245`,
+		`apache_process_connections{pid="3980",state="closing"} 2`,
+		`apache_exporter_collector_success{collector="processes"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in\n%s", want, body)
+		}
+	}
+	if n := strings.Count(body, "apache_process_connections{"); n != 12 {
+		t.Errorf("expected 4 states of 3 processes, leaving out the sum, got %d in\n%s", n, body)
+	}
+}
+
+// largeHTMLStatus is the HTML status page of 2.4.58.html with
+// ExtendedStatus on and 20000 worker slots, whose table follows the
+// process table as in apache, making it about 2MB.
+func largeHTMLStatus(t testing.TB) string {
+	page, err := ioutil.ReadFile(filepath.Join("testdata", "processes", "2.4.58.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var workers strings.Builder
+	workers.WriteString("<table border=\"0\"><tr><th>Srv</th><th>PID</th><th>Acc</th><th>M</th><th>CPU\n</th><th>SS</th><th>Req</th><th>Dur</th><th>Conn</th><th>Child</th><th>Slot</th><th>Client</th><th>Protocol</th><th>VHost</th><th>Request</th></tr>\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&workers, "<tr><td><b>%d-0</b></td><td>%d</td><td>0/12/3456</td><td><b>W</b>\n</td><td>0.25</td><td>3</td><td>0</td><td>1234</td><td>0.0</td><td>0.02</td><td>15.76</td><td>10.0.0.1</td><td>http/1.1</td><td nowrap>www.example.com:443</td><td nowrap>GET /index.html HTTP/1.1</td></tr>\n", i, 4120+i%3)
+	}
+	workers.WriteString("</table>\n")
+	return strings.Replace(string(page), "<p>Scoreboard Key:", workers.String()+"<p>Scoreboard Key:", 1)
+}
+
+func benchmarkParseProcessTable(b *testing.B, page string) {
+	if len(parseProcessTable(page)) != 3 {
+		b.Fatal("expected 3 processes")
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(page)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseProcessTable(page)
+	}
+}
+
+func BenchmarkParseProcessTable(b *testing.B) {
+	page, err := ioutil.ReadFile(filepath.Join("testdata", "processes", "2.4.58.html"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("small", func(b *testing.B) { benchmarkParseProcessTable(b, string(page)) })
+	b.Run("large", func(b *testing.B) { benchmarkParseProcessTable(b, largeHTMLStatus(b)) })
+}
+
+// TestParseProcessTableAllocs holds the large page to the allocations it
+// took once scanned in place, 46 rather than the 334 of the regexes.
+func TestParseProcessTableAllocs(t *testing.T) {
+	page := largeHTMLStatus(t)
+	if allocs := testing.AllocsPerRun(20, func() { parseProcessTable(page) }); allocs > 80 {
+		t.Errorf("expected at most 80 allocations to parse the process table, got %v", allocs)
+	}
+}
+
+func TestParseTableRow(t *testing.T) {
+	row := `<TH ROWSPAN="2">PID</TH><th colspan=3 nowrap>Async <b>connections</b></th><td>&nbsp;4120 </Td><td>not closed`
+	want := []tableCell{
+		{header: true, text: "pid", rowspan: 2, colspan: 1},
+		{header: true, text: "async connections", rowspan: 1, colspan: 3},
+		{text: "4120", rowspan: 1, colspan: 1},
+	}
+	if got := parseTableRow(nil, row); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
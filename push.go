@@ -0,0 +1,228 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	pushGatewayURL       = flag.String("push.gateway-url", "", "URL of a Pushgateway to push the targets' and the exporter's metrics to every -push.interval. Credentials and TLS settings for it go in the URI or in the push section of -config.file.")
+	pushJob              = flag.String("push.job", "apache", "Job label to push the metrics under.")
+	pushInterval         = flag.Duration("push.interval", time.Minute, "How often to scrape the targets and push to -push.gateway-url.")
+	pushGrouping         = groupingFlag{}
+	pushDeleteOnShutdown = flag.Bool("push.delete-on-shutdown", true, "Delete the pushed group from -push.gateway-url on shutdown.")
+
+	pushFailures prometheus.Counter
+)
+
+// pushBackoff is how long to wait before retrying a failed push. It
+// doubles with every failure in a row, up to -push.interval.
+var pushBackoff = time.Second
+
+func init() {
+	flag.Var(pushGrouping, "push.grouping", "Grouping label to push the metrics under besides the job, as name=value. May be repeated.")
+	registerOutput(output{name: "push", setup: func() (func(s *targetSet, done <-chan struct{}), error) {
+		p, err := pusherFromFlags()
+		if p == nil || err != nil {
+			return nil, err
+		}
+		return func(s *targetSet, done <-chan struct{}) {
+			runPush(p, s, *pushInterval, *pushDeleteOnShutdown, done)
+		}, nil
+	}})
+	selfMetric(func() {
+		pushFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "push_failures_total",
+			Help:        "Number of failed pushes to -push.gateway-url.",
+			ConstLabels: withConstLabels(nil),
+		})
+		registry.MustRegister(pushFailures)
+	})
+}
+
+// groupingFlag maps the names of grouping labels to their values.
+type groupingFlag map[string]string
+
+func (f groupingFlag) String() string {
+	var s []string
+	for name, value := range f {
+		s = append(s, name+"="+value)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (f groupingFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	if !labelNameRE.MatchString(kv[0]) || strings.HasPrefix(kv[0], "__") || kv[0] == "job" {
+		return fmt.Errorf("invalid grouping label name %q", kv[0])
+	}
+	f[kv[0]] = kv[1]
+	return nil
+}
+
+// pusher pushes metrics to a Pushgateway with the push package of
+// client_golang.
+type pusher struct {
+	url      string // Of the Pushgateway, without credentials.
+	job      string
+	grouping map[string]string
+	client   authorizingClient
+	deltas   *deltas
+}
+
+// authorizingClient sends requests with the credentials of user, taken
+// from a URI, or else those of conf, reading credential files each time so
+// rotated secrets are picked up.
+type authorizingClient struct {
+	client *http.Client
+	user   *url.Userinfo
+	conf   config.HTTPConfig
+}
+
+func (c authorizingClient) Do(req *http.Request) (*http.Response, error) {
+	if err := authorize(req, c.user, c.conf); err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// newPusher returns a pusher for the group of job and grouping on the
+// Pushgateway at gateway. It connects with client, unless conf has TLS
+// settings, and authenticates with the credentials in gateway or conf.
+func newPusher(gateway, job string, grouping map[string]string, conf config.HTTPConfig, client *http.Client) (*pusher, error) {
+	gateway, user := splitUserinfo(gateway)
+	if user != nil && (conf.BasicAuth != nil || conf.BearerToken != "" || conf.BearerTokenFile != "") {
+		return nil, fmt.Errorf("credentials given both in -push.gateway-url and in the config file")
+	}
+	u, err := url.Parse(gateway)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -push.gateway-url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-push.gateway-url %q must be an http or https URL", gateway)
+	}
+	c, err := tlsClient(conf, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		client = c
+	}
+	p := &pusher{url: gateway, job: job, grouping: grouping, client: authorizingClient{client, user, conf}}
+	if err := p.group().Error(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// pusherFromFlags returns the pusher -push.gateway-url asks for, nil if
+// none. It connects the way scrapes do.
+func pusherFromFlags() (*pusher, error) {
+	if *pushGatewayURL == "" {
+		return nil, nil
+	}
+	if *pushInterval <= 0 {
+		return nil, fmt.Errorf("-push.interval must be positive, got %s", *pushInterval)
+	}
+	var conf config.HTTPConfig
+	if *configFile != "" {
+		cfg, err := config.LoadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Push != nil {
+			conf = *cfg.Push
+		}
+	}
+	p, err := newPusher(*pushGatewayURL, *pushJob, pushGrouping, conf, newHTTPClient(clientConfigFromFlags()))
+	if err != nil {
+		return nil, err
+	}
+	p.deltas = deltasFromFlags("push")
+	return p, nil
+}
+
+// group returns a push.Pusher for the group of p. A new one is needed for
+// every push, as they keep the gatherers given to them.
+func (p *pusher) group() *push.Pusher {
+	g := push.New(p.url, p.job).Client(p.client)
+	for name, value := range p.grouping {
+		g.Grouping(name, value)
+	}
+	return g
+}
+
+// push replaces the metrics of the group with those g gathers.
+func (p *pusher) push(g prometheus.Gatherer) error {
+	return p.group().Gatherer(deltasGatherer{g, p.deltas}).Push()
+}
+
+// delete removes the group with all its metrics.
+func (p *pusher) delete() error {
+	return p.group().Delete()
+}
+
+// pushGatherer gathers what pushes to a Pushgateway or to Graphite consist
+// of: the metrics of the current targets of s, those of registry and,
+// unless -web.disable-exporter-metrics is set, those of the default
+// registry, after the metric rules.
+func pushGatherer(s *targetSet) prometheus.Gatherer {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s.current())
+	gatherers := prometheus.Gatherers{reg, registry}
+	if !*disableExporterMetrics {
+		gatherers = append(gatherers, prometheus.DefaultGatherer)
+	}
+	return rulesGatherer{gatherers}
+}
+
+// runPush pushes the metrics of the current targets of s right away and
+// then every interval, until done is closed. Failed pushes are retried
+// with backoff, and counted in a metric the next push carries. With
+// deleteOnShutdown, the group is deleted once done is closed.
+func runPush(p *pusher, s *targetSet, interval time.Duration, deleteOnShutdown bool, done <-chan struct{}) {
+	backoff := pushBackoff
+	for {
+		wait := interval
+		if err := p.push(pushGatherer(s)); err != nil {
+			pushFailures.Inc()
+			logger.Error("Error pushing to the Pushgateway", "url", sanitizeURI(p.url), "retry_in", backoff, "err", err)
+			wait = backoff
+			if backoff *= 2; backoff > interval {
+				backoff = interval
+			}
+		} else {
+			backoff = pushBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			if deleteOnShutdown {
+				if err := p.delete(); err != nil {
+					logger.Error("Error deleting the group from the Pushgateway", "url", sanitizeURI(p.url), "err", err)
+				}
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+// fakeGateway records the requests pushed to it, failing the first fail.
+type fakeGateway struct {
+	mu       sync.Mutex
+	fail     int
+	requests []gatewayRequest
+}
+
+type gatewayRequest struct {
+	method, path, auth, body string
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests = append(g.requests, gatewayRequest{r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"), string(body)})
+	if g.fail > 0 {
+		g.fail--
+		http.Error(w, "gateway down", http.StatusServiceUnavailable)
+		return
+	}
+	// As the Pushgateway, accept deletes with a 202.
+	if r.Method == "DELETE" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *fakeGateway) recorded() []gatewayRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]gatewayRequest(nil), g.requests...)
+}
+
+func TestNewPusher(t *testing.T) {
+	p, err := newPusher("http://u:p@gateway:9091/", "apache", map[string]string{"zone": "eu west", "dc": "ams"}, config.HTTPConfig{}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://gateway:9091/"; p.url != want {
+		t.Errorf("expected %s, got %s", want, p.url)
+	}
+	if p.client.user == nil || p.client.user.Username() != "u" {
+		t.Errorf("expected the credentials of the URI, got %v", p.client.user)
+	}
+	// The push package encodes slashes in base64.
+	if _, err := newPusher("http://gateway:9091", "a/b", map[string]string{"zone": "eu/west"}, config.HTTPConfig{}, http.DefaultClient); err != nil {
+		t.Errorf("expected slashes to be accepted, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		gateway, job string
+		grouping     map[string]string
+		conf         config.HTTPConfig
+	}{
+		{"gateway:9091", "apache", nil, config.HTTPConfig{}},
+		{"http://gateway:9091", "", nil, config.HTTPConfig{}},
+		{"http://u:p@gateway:9091", "apache", nil, config.HTTPConfig{BearerToken: "t0ken"}},
+	} {
+		if _, err := newPusher(tc.gateway, tc.job, tc.grouping, tc.conf, http.DefaultClient); err == nil {
+			t.Errorf("%s, job %q, %v: expected an error", tc.gateway, tc.job, tc.grouping)
+		}
+	}
+}
+
+func TestGroupingFlag(t *testing.T) {
+	f := groupingFlag{}
+	for _, v := range []string{"zone=eu", "dc=ams=1"} {
+		if err := f.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := f.String(); got != "dc=ams=1,zone=eu" {
+		t.Errorf("unexpected grouping %s", got)
+	}
+	for _, v := range []string{"zone", "job=x", "__name__=x", "0zone=x"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
+
+func TestRunPush(t *testing.T) {
+	defer func(old time.Duration) { pushBackoff = old }(pushBackoff)
+	pushBackoff = 10 * time.Millisecond
+	failures := counterValue(t, pushFailures)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	gateway := &fakeGateway{fail: 2}
+	gs := httptest.NewServer(gateway)
+	defer gs.Close()
+
+	p, err := newPusher(gs.URL, "apache", map[string]string{"dc": "ams"}, config.HTTPConfig{BearerToken: "t0ken"}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		runPush(p, newTargetSet(Exporters{NewExporter(ts.URL)}, nil), time.Hour, true, done)
+		close(stopped)
+	}()
+	waitFor(t, "a successful push", func() bool { return len(gateway.recorded()) == 3 })
+	close(done)
+	<-stopped
+
+	requests := gateway.recorded()
+	if len(requests) != 4 {
+		t.Fatalf("expected 3 pushes and a delete, got %+v", requests)
+	}
+	for _, r := range requests {
+		if r.path != "/metrics/job/apache/dc/ams" || r.auth != "Bearer t0ken" {
+			t.Errorf("unexpected request %+v", r)
+		}
+	}
+	if v := counterValue(t, pushFailures) - failures; v != 2 {
+		t.Errorf("expected 2 failed pushes, got %v", v)
+	}
+	pushed := requests[2]
+	if pushed.method != "PUT" {
+		t.Errorf("expected a PUT, got %s", pushed.method)
+	}
+	dec := expfmt.NewDecoder(strings.NewReader(pushed.body), expfmt.FmtProtoDelim)
+	names := map[string]float64{}
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err != nil {
+			break
+		}
+		if len(mf.Metric) > 0 && mf.Metric[0].Counter != nil {
+			names[mf.GetName()] = mf.Metric[0].Counter.GetValue()
+		} else {
+			names[mf.GetName()] = 0
+		}
+	}
+	if _, ok := names["apache_up"]; !ok {
+		t.Errorf("expected the targets' metrics to be pushed, got %v", names)
+	}
+	if v, ok := names["apache_exporter_push_failures_total"]; !ok || v-failures != 2 {
+		t.Errorf("expected the failed pushes to be pushed, got %v", names)
+	}
+	if requests[3].method != "DELETE" {
+		t.Errorf("expected the group to be deleted on shutdown, got %s", requests[3].method)
+	}
+}
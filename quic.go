@@ -0,0 +1,92 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func init() {
+	newQUICTransport = func(tlsConfig *tls.Config, d *dialer, cfg clientConfig) http.RoundTripper {
+		return &http3.RoundTripper{
+			TLSClientConfig: tlsConfig.Clone(),
+			QuicConfig:      &quic.Config{HandshakeIdleTimeout: cfg.dialTimeout},
+			Dial: func(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+				return dialQUIC(ctx, d, addr, tlsConfig, config)
+			},
+		}
+	}
+}
+
+// dialQUIC connects to addr over QUIC, at the addresses d would connect to
+// over TCP. It returns once the handshake is over, so that failing it is a
+// quicDialError rather than an error of the request.
+func dialQUIC(ctx context.Context, d *dialer, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	addrs, err := d.addresses(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		var conn quic.EarlyConnection
+		if d.source != nil {
+			conn, err = dialQUICFrom(ctx, d.source, a, tlsConfig, config)
+		} else {
+			conn, err = quic.DialAddrEarly(ctx, a, tlsConfig, config)
+		}
+		if err != nil {
+			continue
+		}
+		select {
+		case <-conn.HandshakeComplete():
+			return conn, nil
+		case <-conn.Context().Done():
+			err = context.Cause(conn.Context())
+		case <-ctx.Done():
+			conn.CloseWithError(0, "")
+			err = context.Cause(ctx)
+		}
+	}
+	return nil, &quicDialError{err}
+}
+
+// dialQUICFrom connects to addr over QUIC from a socket bound to source,
+// closed along with the connection.
+func dialQUICFrom(ctx context.Context, source net.IP, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	network := "udp6"
+	if source.To4() != nil {
+		network = "udp4"
+	}
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenUDP(network, &net.UDPAddr{IP: source})
+	if err != nil {
+		return nil, err
+	}
+	t := &quic.Transport{Conn: udp}
+	conn, err := t.DialEarly(ctx, raddr, tlsConfig, config)
+	if err != nil {
+		t.Close()
+		udp.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		t.Close()
+		udp.Close()
+	}()
+	return conn, nil
+}
@@ -0,0 +1,101 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// serveHTTP3 serves the status page of apache 2.4 over HTTP/3 with cert
+// until the test is done, returning its address and the protocols of the
+// requests it got.
+func serveHTTP3(t *testing.T, cert *testCert) (string, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var protos []string
+	server := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			protos = append(protos, r.Proto)
+			mutex.Unlock()
+			w.Write([]byte(apache24Status))
+		}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert.tlsCertificate()}}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+	return conn.LocalAddr().String(), func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), protos...)
+	}
+}
+
+// scrapeBody returns what /metrics serves of e.
+func scrapeBody(e *Exporter) string {
+	rr := httptest.NewRecorder()
+	metricsHandler(newTargetSet(Exporters{e}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	return rr.Body.String()
+}
+
+func TestHTTP3Scrape(t *testing.T) {
+	cert := newTestCert(t, "apache", nil, true)
+	addr, protos := serveHTTP3(t, cert)
+	for _, tc := range []struct {
+		uri, version string
+		source       net.IP
+	}{
+		{"h3://" + addr + "/server-status?auto", "auto", nil},
+		{"https://" + addr + "/server-status?auto", "3", nil},
+		{"h3://" + addr + "/server-status?auto", "auto", net.ParseIP("127.0.0.1")},
+	} {
+		e := NewExporter(tc.uri)
+		e.client = newHTTPClient(clientConfig{timeout: 5 * time.Second, dialTimeout: 2 * time.Second, tls: trusting(cert), httpVersion: tc.version, source: tc.source})
+		if body := scrapeBody(e); !strings.Contains(body, "apache_up 1\n") {
+			t.Errorf("%s: expected apache_up 1, got\n%s", tc.uri, body)
+		}
+	}
+	if got := strings.Join(protos(), " "); got != "HTTP/3.0 HTTP/3.0 HTTP/3.0" {
+		t.Errorf("expected three HTTP/3 requests, got %q", got)
+	}
+}
+
+func TestHTTP3Fallback(t *testing.T) {
+	cert := newTestCert(t, "apache", nil, true)
+	// Over TCP alone, nothing listening on its UDP port.
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert.tlsCertificate()}}
+	server.StartTLS()
+	defer server.Close()
+	uri := "h3://" + strings.TrimPrefix(server.URL, "https://") + "/server-status?auto"
+
+	for _, fallback := range []bool{true, false} {
+		e := NewExporter(uri)
+		e.client = newHTTPClient(clientConfig{timeout: 5 * time.Second, dialTimeout: 300 * time.Millisecond, tls: trusting(cert), http3Fallback: fallback})
+		body := scrapeBody(e)
+		if fallback && !strings.Contains(body, "apache_up 1\n") {
+			t.Errorf("expected the scrape to fall back to TCP, got\n%s", body)
+		}
+		if !fallback && (!strings.Contains(body, "apache_up 0\n") || !strings.Contains(body, `apache_exporter_scrape_failures_total{reason="quic_handshake"} 1`)) {
+			t.Errorf("expected the scrape to fail for QUIC, got\n%s", body)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestRates = flag.Bool("scrape.request-rates", false, "Export apache_request_rate_1m and apache_request_rate_5m, request rates the exporter works out from its own background scrapes, and apache_request_duration_seconds_1m and _5m, the average durations of those requests, for Prometheus servers scraping too seldom for rate(). Requires -scrape.interval.")
+
+// rateWindows are the windows request rates are exported over, by the
+// suffix of their metric.
+var rateWindows = []struct {
+	suffix string
+	window time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+}
+
+func newRequestRateDescs(labels prometheus.Labels) []*prometheus.Desc {
+	var descs []*prometheus.Desc
+	for _, w := range rateWindows {
+		descs = append(descs, newDesc("request_rate_"+w.suffix, "Requests per second over the last "+w.window.String()+", derived by the exporter from its own scrapes of apache.", nil, labels))
+	}
+	return descs
+}
+
+func newRequestDurationDescs(labels prometheus.Labels) []*prometheus.Desc {
+	var descs []*prometheus.Desc
+	for _, w := range rateWindows {
+		descs = append(descs, newDesc("request_duration_seconds_"+w.suffix, "Average duration in seconds of the requests over the last "+w.window.String()+", derived by the exporter from the Total Duration of its own scrapes of apache.", nil, labels))
+	}
+	return descs
+}
+
+func validateRequestRates() error {
+	if *requestRates && *scrapeInterval <= 0 {
+		return fmt.Errorf("-scrape.request-rates requires -scrape.interval")
+	}
+	return nil
+}
+
+// rateSample is a value of a counter taken at a time.
+type rateSample struct {
+	at    time.Time
+	value float64
+}
+
+// rateWindow keeps the samples of a counter taken over the longest of
+// rateWindows, for working out its rate.
+type rateWindow struct {
+	mutex   sync.Mutex
+	samples []rateSample // Oldest first.
+}
+
+// add takes in the value of the counter at at, dropping the samples no
+// window needs anymore.
+func (w *rateWindow) add(at time.Time, value float64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.samples = append(w.samples, rateSample{at, value})
+	longest := rateWindows[len(rateWindows)-1].window
+	i := 0
+	for i < len(w.samples) && at.Sub(w.samples[i].at) > longest {
+		i++
+	}
+	w.samples = w.samples[i:]
+}
+
+// rate returns the per second rate of the counter over the window up to
+// now, taking a drop for a reset of the counter to 0. It is false with
+// fewer than two samples in the window.
+func (w *rateWindow) rate(now time.Time, window time.Duration) (float64, bool) {
+	increase, elapsed, ok := w.increase(now, window)
+	if !ok {
+		return 0, false
+	}
+	return increase / elapsed.Seconds(), true
+}
+
+// increase returns how much the counter went up over the window up to now,
+// as rate takes it, and the time between the first and last samples in
+// the window.
+func (w *rateWindow) increase(now time.Time, window time.Duration) (float64, time.Duration, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var first, last *rateSample
+	var increase float64
+	for i := range w.samples {
+		s := &w.samples[i]
+		if now.Sub(s.at) > window {
+			continue
+		}
+		if last != nil {
+			if s.value >= last.value {
+				increase += s.value - last.value
+			} else {
+				increase += s.value
+			}
+		} else {
+			first = s
+		}
+		last = s
+	}
+	if first == nil || first == last || !last.at.After(first.at) {
+		return 0, 0, false
+	}
+	return increase, last.at.Sub(first.at), true
+}
+
+// collectRequestRates samples the total accesses of e and, where the status
+// page shows it, the total duration, and sends the request rates and
+// average request durations over rateWindows to ch.
+func (e *Exporter) collectRequestRates(values map[string]float64, ch chan<- prometheus.Metric) {
+	now := time.Now()
+	e.accessRates.add(now, values["Total Accesses"])
+	duration, hasDuration := values["Total Duration"]
+	if hasDuration {
+		e.durationRates.add(now, duration)
+	}
+	for i, w := range rateWindows {
+		accesses, elapsed, ok := e.accessRates.increase(now, w.window)
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(e.requestRateDescs[i], prometheus.GaugeValue, accesses/elapsed.Seconds())
+		if !hasDuration || accesses == 0 {
+			continue
+		}
+		// Total Duration is in milliseconds.
+		if ms, _, ok := e.durationRates.increase(now, w.window); ok {
+			ch <- prometheus.MustNewConstMetric(e.durationDescs[i], prometheus.GaugeValue, ms/accesses/1000)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateWindow(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name    string
+		values  []float64 // Sampled every 15s from start.
+		window  time.Duration
+		want    float64
+		defined bool
+	}{
+		{"steady", []float64{100, 115, 130, 145, 160}, time.Minute, 1, true},
+		{"burst", []float64{100, 100, 100, 400, 400}, time.Minute, 5, true},
+		// Apache restarted between the third and fourth sample, counting
+		// 30 requests since.
+		{"reset", []float64{100, 115, 130, 30, 45}, time.Minute, 75.0 / 60, true},
+		{"reset to zero", []float64{100, 115, 0, 15}, time.Minute, 30.0 / 45, true},
+		// Samples older than the window don't count.
+		{"window", []float64{0, 0, 0, 0, 0, 60, 120, 180, 240}, time.Minute, 4, true},
+		{"five minutes", []float64{0, 15, 30, 45, 60, 75, 90, 105, 120}, 5 * time.Minute, 1, true},
+		{"one sample", []float64{100}, time.Minute, 0, false},
+		{"none", nil, time.Minute, 0, false},
+	} {
+		w := &rateWindow{}
+		var now time.Time
+		for i, v := range c.values {
+			now = start.Add(time.Duration(i) * 15 * time.Second)
+			w.add(now, v)
+		}
+		got, ok := w.rate(now, c.window)
+		if ok != c.defined || math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", c.name, c.want, c.defined, got, ok)
+		}
+	}
+}
+
+func TestRateWindowPrunes(t *testing.T) {
+	w := &rateWindow{}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		w.add(start.Add(time.Duration(i)*15*time.Second), float64(i))
+	}
+	// 5 minutes of samples every 15s, both ends included.
+	if n := len(w.samples); n != 21 {
+		t.Errorf("expected the samples of the last 5m to be kept, got %d", n)
+	}
+}
+
+func TestRequestRates(t *testing.T) {
+	defer func(old bool) { *requestRates = old }(*requestRates)
+	accesses := []string{"100", "160"}
+	durations := []string{"5000", "8000"}
+	var n int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Total Accesses: " + accesses[n] + "\nTotal Duration: " + durations[n] + "\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+		n++
+	}))
+	defer ts.Close()
+	e := NewExporter(ts.URL)
+	get := func() string {
+		rr := httptest.NewRecorder()
+		metricsHandler(newTargetSet(Exporters{e}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+
+	if body := get(); strings.Contains(body, "apache_request_rate_") {
+		t.Errorf("expected no request rates without -scrape.request-rates in\n%s", body)
+	}
+	*requestRates = true
+	e.accessRates.add(time.Now().Add(-30*time.Second), 100)
+	e.durationRates.add(time.Now().Add(-30*time.Second), 5000)
+	body := get()
+	for _, name := range []string{"apache_request_rate_1m ", "apache_request_rate_5m "} {
+		if !strings.Contains(body, name) {
+			t.Errorf("expected %s in\n%s", name, body)
+		}
+	}
+	// 60 requests took 3000ms between the two samples.
+	for _, want := range []string{"apache_request_duration_seconds_1m 0.05\n", "apache_request_duration_seconds_5m 0.05\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in\n%s", want, body)
+		}
+	}
+}
+
+func TestValidateRequestRates(t *testing.T) {
+	defer func(rates bool, interval time.Duration) {
+		*requestRates, *scrapeInterval = rates, interval
+	}(*requestRates, *scrapeInterval)
+	*requestRates, *scrapeInterval = true, 0
+	if err := validateRequestRates(); err == nil {
+		t.Error("expected -scrape.request-rates without -scrape.interval to be rejected")
+	}
+	*scrapeInterval = 15 * time.Second
+	if err := validateRequestRates(); err != nil {
+		t.Errorf("expected no error with -scrape.interval, got %s", err)
+	}
+}
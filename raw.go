@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var enableRaw = flag.Bool("web.enable-raw", false, "Serve on /raw?target=<name> the status page of a target as apache returns it, fetched with the credentials, headers and TLS settings of the target and cut off at -scrape.max-body-size, for debugging what the exporter parses.")
+
+// rawHandler fetches the status page of the target of s named by the
+// target parameter, which can be left out if s has a single one, and
+// passes it on decoded but otherwise as apache served it: with its status
+// and Content-Type, but none of its other headers.
+func rawHandler(s *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("target")
+		es := s.current()
+		var e *Exporter
+		switch {
+		case name == "" && len(es) == 1:
+			e = es[0]
+		case name == "":
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		default:
+			for _, t := range es {
+				if t.name == name {
+					e = t
+				}
+			}
+			if e == nil {
+				http.Error(w, fmt.Sprintf("Unknown target %q", name), http.StatusNotFound)
+				return
+			}
+		}
+		req, err := e.newStatusRequest(r.Context(), e.URI)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			http.Error(w, "Error fetching the status page: "+sanitizeError(err).Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		body, err := decodeBody(resp)
+		if err != nil {
+			http.Error(w, "Error decoding the status page: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.WriteHeader(resp.StatusCode)
+		if _, err := io.Copy(w, io.LimitReader(body, e.maxBodySize)); err != nil {
+			e.logger.Debug("Error passing on the status page", "err", err)
+		}
+	})
+}
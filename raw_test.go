@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestRaw(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "monitor" || password != "s3cret" || r.Header.Get("X-Api-Key") != "k3y" {
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=ISO-8859-1")
+		w.Header().Set("Set-Cookie", "session=abc")
+		if r.URL.Path == "/missing" {
+			http.Error(w, "no status here", http.StatusNotFound)
+			return
+		}
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(apache24Status))
+		gz.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	}))
+	defer backend.Close()
+	conf := config.HTTPConfig{BasicAuth: &config.BasicAuth{Username: "monitor", Password: "s3cret"}, Headers: map[string]string{"X-Api-Key": "k3y"}}
+	es, err := newExporters(targetsFromConfig([]config.Target{
+		{Name: "web01", URI: backend.URL + "/server-status?auto", HTTPConfig: conf},
+		{Name: "web02", URI: backend.URL + "/missing", HTTPConfig: conf},
+		{Name: "down", URI: "http://127.0.0.1:1/server-status?auto&token=t0ken", HTTPConfig: conf},
+	}), testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es[0].maxBodySize = 100
+	h := rawHandler(newTargetSet(es, nil))
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/raw"+query, nil))
+		for _, secret := range []string{"s3cret", "k3y", "t0ken", "session="} {
+			if strings.Contains(rr.Body.String(), secret) || strings.Contains(strings.Join(rr.Header().Values("Set-Cookie"), ""), secret) {
+				t.Errorf("%s: %q reflected in %v %q", query, secret, rr.Header(), rr.Body)
+			}
+		}
+		return rr
+	}
+
+	rr := get("?target=web01")
+	if rr.Code != 200 || rr.Header().Get("Content-Type") != "text/plain; charset=ISO-8859-1" || rr.Header().Get("Content-Encoding") != "" {
+		t.Errorf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+	// Decoded and cut off at the body limit of the target.
+	if rr.Body.String() != apache24Status[:100] {
+		t.Errorf("expected the first 100 bytes of the status page, got %q", rr.Body)
+	}
+	if rr := get("?target=web02"); rr.Code != 404 || rr.Body.String() != "no status here\n" {
+		t.Errorf("expected the 404 of apache passed on, got %d %q", rr.Code, rr.Body)
+	}
+	if rr := get("?target=down"); rr.Code != http.StatusBadGateway || !strings.Contains(rr.Body.String(), "token=xxxxx") {
+		t.Errorf("expected a 502 with the sanitized error, got %d %q", rr.Code, rr.Body)
+	}
+	if rr := get(""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected the target required of several, got %d", rr.Code)
+	}
+	if rr := get("?target=web03"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown target to 404, got %d", rr.Code)
+	}
+
+	// A single target is the default.
+	h = rawHandler(newTargetSet(es[:1], nil))
+	if rr := get(""); rr.Code != 200 || !strings.HasPrefix(rr.Body.String(), "localhost") {
+		t.Errorf("expected the status page of the single target, got %d %q", rr.Code, rr.Body)
+	}
+}
+
+func TestRawGuards(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	s := newTargetSet(Exporters{NewExporter(backend.URL)}, nil)
+	get := func(h http.Handler) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/raw", nil))
+		return rr.Code
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", landingHandler(s, "/metrics", nil))
+	if code := get(mux); code != http.StatusNotFound {
+		t.Errorf("expected 404 without -web.enable-raw, got %d", code)
+	}
+	mux.Handle("/raw", rawHandler(s))
+	if code := get(mux); code != http.StatusOK {
+		t.Errorf("expected 200 with -web.enable-raw, got %d", code)
+	}
+	if code := get(servePaths(mux, false)); code != http.StatusNotFound {
+		t.Errorf("expected 404 off -web.admin-address, got %d", code)
+	}
+	if code := get(servePaths(mux, true)); code != http.StatusOK {
+		t.Errorf("expected 200 on -web.admin-address, got %d", code)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// targetSet holds the exporters currently being scraped. A reload swaps in
+// a whole new set, while scrapes already running finish with the old one.
+type targetSet struct {
+	mutex     sync.Mutex // Serializes reloads.
+	exporters atomic.Value
+	load      func() (Exporters, error)
+
+	lastReloadSuccessful prometheus.Gauge
+	lastReloadTimestamp  prometheus.Gauge
+	reloadFailures       prometheus.Counter
+	configHash           prometheus.Gauge
+
+	cluster cluster
+}
+
+// newTargetSet serves es until reloaded with the exporters returned by load.
+func newTargetSet(es Exporters, load func() (Exporters, error)) *targetSet {
+	s := &targetSet{
+		load: load,
+		lastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_config_last_reload_successful",
+			Help:        "Whether the last configuration reload attempt was successful.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		lastReloadTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_config_last_reload_success_timestamp_seconds",
+			Help:        "Timestamp of the last successful configuration reload.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		reloadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_config_reload_failures_total",
+			Help:        "Number of configuration reloads that failed, leaving the previous configuration in place.",
+			ConstLabels: withConstLabels(nil),
+		}),
+		configHash: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_config_hash",
+			Help:        "Hash of the effective configuration, as served on /-/config, for telling whether exporters run the same one.",
+			ConstLabels: withConstLabels(nil),
+		}),
+	}
+	s.exporters.Store(es)
+	s.lastReloadSuccessful.Set(1)
+	s.lastReloadTimestamp.Set(float64(time.Now().Unix()))
+	s.configHash.Set(float64(s.hash()))
+	return s
+}
+
+// hash returns a hash of the effective configuration of s, in the 53 bits
+// a float64 holds exactly. It goes by the configuration as parsed, so the
+// same settings hash the same however their files order them. Secrets are
+// left out, redacted as on /-/config.
+func (s *targetSet) hash() uint64 {
+	h := sha256.New()
+	if err := writeConfig(h, s); err != nil {
+		logger.Error("Error hashing the configuration", "err", err)
+		return 0
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil)) >> 11
+}
+
+func (s *targetSet) current() Exporters {
+	return s.exporters.Load().(Exporters)
+}
+
+// reload replaces the exporters with freshly loaded ones, keeping the
+// current ones if loading fails.
+func (s *targetSet) reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	es, err := s.load()
+	if err != nil {
+		logger.Error("Error reloading configuration, keeping the previous one", "err", err)
+		s.lastReloadSuccessful.Set(0)
+		s.reloadFailures.Inc()
+		return err
+	}
+	old := s.hash()
+	es.keepState(s.current())
+	s.cluster.retire(s.current(), es)
+	s.exporters.Store(es)
+	hash := s.hash()
+	s.lastReloadSuccessful.Set(1)
+	s.lastReloadTimestamp.Set(float64(time.Now().Unix()))
+	s.configHash.Set(float64(hash))
+	logger.Info("Reloaded configuration", "targets", len(es), "old_hash", fmt.Sprintf("%x", old), "new_hash", fmt.Sprintf("%x", hash))
+	return nil
+}
+
+func (s *targetSet) Describe(ch chan<- *prometheus.Desc) {
+	s.current().Describe(ch)
+	s.cluster.describe(s.current(), ch)
+	s.lastReloadSuccessful.Describe(ch)
+	s.lastReloadTimestamp.Describe(ch)
+	s.reloadFailures.Describe(ch)
+	s.configHash.Describe(ch)
+}
+
+func (s *targetSet) Collect(ch chan<- prometheus.Metric) {
+	s.collectContext(context.Background(), ch)
+}
+
+func (s *targetSet) collectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	es := s.current()
+	es.collectContext(ctx, ch)
+	s.cluster.collect(es, ch)
+	s.collectReloads(ch)
+}
+
+// collectReloads sends the metrics about reloading the set.
+func (s *targetSet) collectReloads(ch chan<- prometheus.Metric) {
+	s.lastReloadSuccessful.Collect(ch)
+	s.lastReloadTimestamp.Collect(ch)
+	s.reloadFailures.Collect(ch)
+	s.configHash.Collect(ch)
+}
+
+// keepState hands the metrics of exporters in old over to the exporters
+// in es with the same labels, so reloading doesn't reset the counters of
+// targets that are still there. Old exporters still scraping keep
+// updating them until they are done.
+func (es Exporters) keepState(old Exporters) {
+	for _, e := range es {
+		for _, o := range old {
+			if reflect.DeepEqual(e.labels, o.labels) {
+				e.scrapeFailures = o.scrapeFailures
+				e.targetFailures = o.targetFailures
+				e.parseErrors = o.parseErrors
+				e.connections = o.connections
+				e.formLogins = o.formLogins
+				e.coalesced = o.coalesced
+				e.cacheHits = o.cacheHits
+				e.restarts = o.restarts
+				e.timeouts = o.timeouts
+				e.seen = o.seen
+				e.clusterAccesses = o.clusterAccesses
+				e.activeURI = o.activeURI
+				e.degraded = o.degraded
+				if e.URI == o.URI {
+					e.capabilities = o.capabilities
+				}
+				e.last = o.last
+				e.breaker = o.breaker
+				e.maintenance = o.maintenance
+				e.history = o.history
+				e.availability = o.availability
+				e.accessRates = o.accessRates
+				e.durationRates = o.durationRates
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReload(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	writeConfig := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(old string) { *configFile = old }(*configFile)
+	*configFile = path
+
+	const twoTargets = "targets:\n  - {name: a, uri: %s}\n  - {name: b, uri: %s}\n"
+	writeConfig(fmt.Sprintf(twoTargets, up.URL, down.URL))
+	es, err := exportersFromFlags(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, func() (Exporters, error) { return exportersFromFlags(false) })
+	if got := targetValues(t, s.current(), "apache_up"); got["a"] != 1 || got["b"] != 0 {
+		t.Fatalf("expected a up and b down, got %v", got)
+	}
+
+	writeConfig(fmt.Sprintf(twoTargets+"  - {name: c, uri: %s}\n", up.URL, down.URL, up.URL))
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if v := gaugeValue(t, s.lastReloadSuccessful); v != 1 {
+		t.Errorf("expected successful reload, got %v", v)
+	}
+	if got := targetValues(t, s.current(), "apache_up"); len(got) != 3 || got["c"] != 1 {
+		t.Errorf("expected target c to be added, got %v", got)
+	}
+	// b's failure before the reload is still counted.
+	if v := counterValue(t, s.current()[1].scrapeFailures.WithLabelValues(reasonStatus)); v != 2 {
+		t.Errorf("expected b's failures to survive the reload, got %v", v)
+	}
+
+	before := s.current()
+	timestamp := gaugeValue(t, s.lastReloadTimestamp)
+	writeConfig("targets: [{name: a, uri: ")
+	if err := s.reload(); err == nil {
+		t.Fatal("expected reload of a broken config to fail")
+	}
+	if v := gaugeValue(t, s.lastReloadSuccessful); v != 0 {
+		t.Errorf("expected failed reload, got %v", v)
+	}
+	if v := gaugeValue(t, s.lastReloadTimestamp); v != timestamp {
+		t.Errorf("expected reload timestamp to stay %v, got %v", timestamp, v)
+	}
+	if v := counterValue(t, s.reloadFailures); v != 1 {
+		t.Errorf("expected 1 reload failure, got %v", v)
+	}
+	if got := s.current(); len(got) != 3 || got[0] != before[0] {
+		t.Errorf("expected the previous targets to be kept, got %d", len(got))
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	defer func(old string) { *configFile = old }(*configFile)
+	*configFile = path
+	load := func(config string) *targetSet {
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		es, err := exportersFromFlags(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newTargetSet(es, func() (Exporters, error) { return exportersFromFlags(false) })
+	}
+	const config = "targets:\n  - name: a\n    uri: http://a/server-status\n    group: web\n"
+	s := load(config)
+	hash := gaugeValue(t, s.configHash)
+	if hash == 0 {
+		t.Fatal("expected a config hash")
+	}
+	if v := gaugeValue(t, load(config).configHash); v != hash {
+		t.Errorf("expected the same config to hash to %v, got %v", hash, v)
+	}
+	reordered := "targets:\n  - group: web\n    uri: http://a/server-status\n    name: a\n"
+	if v := gaugeValue(t, load(reordered).configHash); v != hash {
+		t.Errorf("expected the reordered config to hash to %v, got %v", hash, v)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("targets:\n  - name: a\n    uri: http://a/server-status\n    group: db\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if v := gaugeValue(t, s.configHash); v == hash {
+		t.Errorf("expected the changed config to hash other than %v", hash)
+	}
+	if err := ioutil.WriteFile(path, []byte(reordered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if v := gaugeValue(t, s.configHash); v != hash {
+		t.Errorf("expected the config reloaded back to hash to %v, got %v", hash, v)
+	}
+}
+
+func TestTargetsFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets.json")
+	writeTargets := func(targets string) {
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, []byte(targets), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(old string) { *targetsFile = old }(*targetsFile)
+	*targetsFile = path
+
+	writeTargets(fmt.Sprintf(`[{"name": "a", "uri": %q}, {"name": "b", "uri": %q}]`, server.URL, server.URL))
+	es, err := exportersFromFlags(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 {
+		t.Fatalf("expected only the targets from the file, got %d", len(es))
+	}
+	if v := gaugeValue(t, targetsFileLoaded); v == 0 {
+		t.Error("expected the targets file load to be recorded")
+	}
+	s := newTargetSet(es, func() (Exporters, error) { return exportersFromFlags(false) })
+	// The reloads read the flags, so the watcher is waited for before they
+	// are restored.
+	done, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		watchFile(path, 20*time.Millisecond, func() { s.reload() }, done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	writeTargets(`[{"name": "a", "uri": `)
+	waitFor(t, "the broken targets file to be rejected", func() bool {
+		return gaugeValue(t, s.lastReloadSuccessful) == 0
+	})
+	if len(s.current()) != 2 {
+		t.Errorf("expected the previous targets to be kept, got %d", len(s.current()))
+	}
+
+	writeTargets(fmt.Sprintf(`[{"name": "a", "uri": %q}]`, server.URL))
+	waitFor(t, "target b to be removed", func() bool { return len(s.current()) == 1 })
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			if labelValue(m, "target") == "b" {
+				t.Errorf("unexpected %s series for removed target b", mf.GetName())
+			}
+		}
+	}
+
+	writeTargets("[]")
+	waitFor(t, "all targets to be removed", func() bool { return len(s.current()) == 0 })
+}
+
+// seriesTargets returns the target labels of all series c exports.
+func seriesTargets(t *testing.T, c prometheus.Collector) map[string]bool {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := map[string]bool{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			if name := labelValue(m, "target"); name != "" {
+				targets[name] = true
+			}
+		}
+	}
+	return targets
+}
+
+func TestRemovedTargetSeries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	for _, background := range []bool{false, true} {
+		args := []string{"a=" + server.URL, "b=" + server.URL, "c=" + server.URL}
+		load := func() (Exporters, error) { return setupExporters(args, testDefaults, false) }
+		es, err := load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newTargetSet(es, load)
+		var c prometheus.Collector = s
+		if background {
+			b := newBackgroundScraper(s, 50*time.Millisecond, 0)
+			b.start()
+			defer b.stop()
+			waitFor(t, "all targets to be scraped", func() bool { return cachedTargets(b) == 3 })
+			c = b
+		}
+		if got := seriesTargets(t, c); len(got) != 3 {
+			t.Fatalf("background %v: expected series of 3 targets, got %v", background, got)
+		}
+
+		args = args[:2]
+		if err := s.reload(); err != nil {
+			t.Fatal(err)
+		}
+		if got := seriesTargets(t, c); len(got) != 2 || got["c"] {
+			t.Errorf("background %v: expected the series of removed target c to be gone, got %v", background, got)
+		}
+		if b, ok := c.(*backgroundScraper); ok {
+			waitFor(t, "the results of c to be dropped", func() bool { return cachedTargets(b) == 2 })
+		}
+	}
+}
@@ -0,0 +1,365 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	remoteWriteURL             = flag.String("remote-write.url", "", "URL of a Prometheus remote-write receiver, such as Mimir's /api/v1/push, to send the targets' and the exporter's metrics to every -remote-write.interval.")
+	remoteWriteInterval        = flag.Duration("remote-write.interval", time.Minute, "How often to scrape the targets and send them to -remote-write.url.")
+	remoteWriteUsername        = flag.String("remote-write.username", "", "Username to authenticate to -remote-write.url with, with HTTP basic auth.")
+	remoteWritePasswordFile    = flag.String("remote-write.password-file", "", "File containing the password of -remote-write.username.")
+	remoteWriteBearerTokenFile = flag.String("remote-write.bearer-token-file", "", "File containing a bearer token to authenticate to -remote-write.url with.")
+	remoteWriteCAFile          = flag.String("remote-write.tls.ca-file", "", "CA certificate to verify -remote-write.url with.")
+	remoteWriteCertFile        = flag.String("remote-write.tls.cert-file", "", "Client certificate to present to -remote-write.url.")
+	remoteWriteKeyFile         = flag.String("remote-write.tls.key-file", "", "Key of -remote-write.tls.cert-file.")
+	remoteWriteInsecureSkip    = flag.Bool("remote-write.tls.insecure-skip-verify", false, "Don't verify the certificate of -remote-write.url.")
+	remoteWriteMaxPending      = flag.Int("remote-write.max-pending-batches", 10, "Number of scrapes that failed to send to -remote-write.url to keep retrying, the oldest being dropped beyond it.")
+	remoteWriteExternalLabels  = constLabelsFlag{}
+
+	remoteWriteFailures prometheus.Counter
+	remoteWriteDropped  prometheus.Counter
+	remoteWritePending  prometheus.Gauge
+)
+
+// remoteWriteBackoff is how long to wait before retrying failed sends. It
+// doubles with every failure in a row, up to -remote-write.interval.
+var remoteWriteBackoff = time.Second
+
+func init() {
+	flag.Var(remoteWriteExternalLabels, "remote-write.external-label", "Label to add to the series sent to -remote-write.url that don't have it, as name=value, such as site=edge01. May be repeated.")
+	registerOutput(output{name: "remote-write", setup: func() (func(s *targetSet, done <-chan struct{}), error) {
+		w, err := remoteWriterFromFlags()
+		if w == nil || err != nil {
+			return nil, err
+		}
+		return func(s *targetSet, done <-chan struct{}) {
+			runRemoteWrite(w, s, *remoteWriteInterval, done)
+		}, nil
+	}})
+	selfMetric(func() {
+		remoteWriteFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "remote_write_failures_total",
+			Help:        "Number of failed sends to -remote-write.url.",
+			ConstLabels: withConstLabels(nil),
+		})
+		remoteWriteDropped = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "remote_write_dropped_batches_total",
+			Help:        "Number of scrapes never sent to -remote-write.url, as it rejected them or too many were pending.",
+			ConstLabels: withConstLabels(nil),
+		})
+		remoteWritePending = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "remote_write_pending_batches",
+			Help:        "Number of scrapes waiting to be sent to -remote-write.url.",
+			ConstLabels: withConstLabels(nil),
+		})
+		registry.MustRegister(remoteWriteFailures, remoteWriteDropped, remoteWritePending)
+	})
+}
+
+// remoteWriteLabel and remoteWriteSeries are the Label and TimeSeries of
+// the remote-write protocol, with a single sample.
+type (
+	remoteWriteLabel struct {
+		name, value string
+	}
+	remoteWriteSeries struct {
+		labels    []remoteWriteLabel // Sorted by name.
+		value     float64
+		timestamp int64 // In milliseconds.
+	}
+)
+
+// remoteWriter sends metrics to a remote-write receiver, keeping those it
+// failed to send to retry them first.
+type remoteWriter struct {
+	url        string
+	user       *url.Userinfo
+	conf       config.HTTPConfig
+	client     *http.Client
+	external   map[string]string
+	maxPending int
+
+	pending [][]byte // Compressed write requests, oldest first.
+}
+
+// newRemoteWriter returns a writer to the receiver at rawURL, keeping up
+// to maxPending requests it failed to send. It connects with client,
+// unless conf has TLS settings, and authenticates with the credentials in
+// rawURL or conf.
+func newRemoteWriter(rawURL string, conf config.HTTPConfig, external map[string]string, maxPending int, client *http.Client) (*remoteWriter, error) {
+	rawURL, user := splitUserinfo(rawURL)
+	if user != nil && (conf.BasicAuth != nil || conf.BearerToken != "" || conf.BearerTokenFile != "") {
+		return nil, fmt.Errorf("credentials given both in -remote-write.url and by flags")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-remote-write.url %q must be an http or https URL", rawURL)
+	}
+	if maxPending < 1 {
+		return nil, fmt.Errorf("-remote-write.max-pending-batches must be at least 1, got %d", maxPending)
+	}
+	if tc := conf.TLSConfig; tc != nil && (tc.CertFile == "") != (tc.KeyFile == "") {
+		return nil, fmt.Errorf("-remote-write.tls.cert-file and -remote-write.tls.key-file must be set together")
+	}
+	c, err := tlsClient(conf, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		client = c
+	}
+	// Fail on unreadable credential files now rather than on every send.
+	if err := authorize(&http.Request{Header: http.Header{}}, user, conf); err != nil {
+		return nil, err
+	}
+	return &remoteWriter{url: rawURL, user: user, conf: conf, client: client, external: external, maxPending: maxPending}, nil
+}
+
+// remoteWriterFromFlags returns the writer -remote-write.url asks for, nil
+// if none. It connects the way scrapes do.
+func remoteWriterFromFlags() (*remoteWriter, error) {
+	if *remoteWriteURL == "" {
+		return nil, nil
+	}
+	if *remoteWriteInterval <= 0 {
+		return nil, fmt.Errorf("-remote-write.interval must be positive, got %s", *remoteWriteInterval)
+	}
+	conf := config.HTTPConfig{BearerTokenFile: *remoteWriteBearerTokenFile}
+	if *remoteWriteUsername != "" {
+		conf.BasicAuth = &config.BasicAuth{Username: *remoteWriteUsername, PasswordFile: *remoteWritePasswordFile}
+	}
+	if *remoteWriteCAFile != "" || *remoteWriteCertFile != "" || *remoteWriteKeyFile != "" || *remoteWriteInsecureSkip {
+		conf.TLSConfig = &config.TLSConfig{CAFile: *remoteWriteCAFile, CertFile: *remoteWriteCertFile, KeyFile: *remoteWriteKeyFile}
+		if *remoteWriteInsecureSkip {
+			conf.TLSConfig.InsecureSkipVerify = remoteWriteInsecureSkip
+		}
+	}
+	return newRemoteWriter(*remoteWriteURL, conf, remoteWriteExternalLabels, *remoteWriteMaxPending, newHTTPClient(clientConfigFromFlags()))
+}
+
+// enqueue adds what g gathers, stamped with now, to the requests to send,
+// dropping the oldest if too many are pending already.
+func (w *remoteWriter) enqueue(g prometheus.Gatherer, now time.Time) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(remoteWriteTimeSeries(mfs, w.external, now)))
+	if len(w.pending) == w.maxPending {
+		logger.Warn("Dropping the oldest scrape not sent to the remote-write receiver", "url", sanitizeURI(w.url), "pending", len(w.pending))
+		remoteWriteDropped.Inc()
+		w.pending = w.pending[1:]
+	}
+	w.pending = append(w.pending, body)
+	remoteWritePending.Set(float64(len(w.pending)))
+	return nil
+}
+
+// flush sends the pending requests, oldest first, until one fails in a
+// way worth retrying. Those the receiver rejects as invalid are dropped.
+func (w *remoteWriter) flush() error {
+	defer func() { remoteWritePending.Set(float64(len(w.pending))) }()
+	for len(w.pending) > 0 {
+		retry, err := w.send(w.pending[0])
+		if err != nil {
+			remoteWriteFailures.Inc()
+			if retry {
+				return err
+			}
+			// Sending it again would fail the same way.
+			logger.Error("Dropping a scrape rejected by the remote-write receiver", "url", sanitizeURI(w.url), "err", err)
+			remoteWriteDropped.Inc()
+		}
+		w.pending = w.pending[1:]
+	}
+	return nil
+}
+
+// send posts the compressed write request body, reporting whether to
+// retry it if that failed: unless the receiver answered with a 4xx other
+// than 429, as the remote-write specification has it.
+func (w *remoteWriter) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	if err := authorize(req, w.user, w.conf); err != nil {
+		return true, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, sanitizeError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		retry := resp.StatusCode/100 != 4 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return false, nil
+}
+
+// remoteWriteTimeSeries converts mfs into remote-write series, stamped
+// with now unless they have a timestamp of their own. Summaries and
+// histograms are split into the series the text format has for them. The
+// external labels are added to the series without them.
+func remoteWriteTimeSeries(mfs []*dto.MetricFamily, external map[string]string, now time.Time) []remoteWriteSeries {
+	var series []remoteWriteSeries
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			timestamp := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...remoteWriteLabel) {
+				labels := []remoteWriteLabel{{"__name__", mf.GetName() + suffix}}
+				seen := map[string]bool{}
+				for _, l := range m.Label {
+					labels = append(labels, remoteWriteLabel{l.GetName(), l.GetValue()})
+					seen[l.GetName()] = true
+				}
+				for _, l := range extra {
+					labels = append(labels, l)
+					seen[l.name] = true
+				}
+				for name, value := range external {
+					if !seen[name] {
+						labels = append(labels, remoteWriteLabel{name, value})
+					}
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				series = append(series, remoteWriteSeries{labels: labels, value: value, timestamp: timestamp})
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add("", q.GetValue(), remoteWriteLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.Bucket {
+					add("_bucket", float64(b.GetCumulativeCount()), remoteWriteLabel{"le", formatFloat(b.GetUpperBound())})
+					inf = inf || math.IsInf(b.GetUpperBound(), 1)
+				}
+				if !inf {
+					add("_bucket", float64(h.GetSampleCount()), remoteWriteLabel{"le", "+Inf"})
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// formatFloat formats the quantile and le labels as the text format does.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes series as a remote-write WriteRequest
+// protobuf message.
+func encodeWriteRequest(series []remoteWriteSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// runRemoteWrite sends the metrics of the current targets of s right away
+// and then every interval, until done is closed. The scrapes that failed
+// to send are retried with backoff in the meantime, and along with the
+// next ones.
+func runRemoteWrite(w *remoteWriter, s *targetSet, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	backoff := remoteWriteBackoff
+	scrape := true
+	for {
+		if scrape {
+			if err := w.enqueue(pushGatherer(s), time.Now()); err != nil {
+				remoteWriteFailures.Inc()
+				logger.Error("Error gathering the metrics to remote-write", "err", err)
+			}
+		}
+		var retry <-chan time.Time
+		if err := w.flush(); err != nil {
+			logger.Error("Error sending to the remote-write receiver", "url", sanitizeURI(w.url), "pending", len(w.pending), "retry_in", backoff, "err", err)
+			retry = time.After(backoff)
+			if backoff *= 2; backoff > interval {
+				backoff = interval
+			}
+		} else {
+			backoff = remoteWriteBackoff
+		}
+		select {
+		case <-ticker.C:
+			scrape = true
+		case <-retry:
+			scrape = false
+		case <-done:
+			return
+		}
+	}
+}
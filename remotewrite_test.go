@@ -0,0 +1,307 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeReceiver decodes the write requests sent to it, answering with the
+// statuses in fail first.
+type fakeReceiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	fail     []int
+	requests [][]remoteWriteSeries
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		f.t.Errorf("unexpected headers %v", r.Header)
+	}
+	if r.Header.Get("Authorization") != "Bearer mimir-token" {
+		f.t.Errorf("expected the bearer token, got %q", r.Header.Get("Authorization"))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.fail) > 0 {
+		status := f.fail[0]
+		f.fail = f.fail[1:]
+		http.Error(w, "ingester unavailable", status)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		f.t.Errorf("decompressing: %v", err)
+		return
+	}
+	f.requests = append(f.requests, decodeWriteRequest(f.t, data))
+}
+
+func (f *fakeReceiver) received() [][]remoteWriteSeries {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]remoteWriteSeries(nil), f.requests...)
+}
+
+// decodeWriteRequest decodes the series of a WriteRequest, failing t on
+// anything else in it.
+func decodeWriteRequest(t *testing.T, data []byte) []remoteWriteSeries {
+	fields := func(b []byte, each func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			if n = each(num, typ, b); n < 0 {
+				t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	var series []remoteWriteSeries
+	fields(data, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		if num != 1 {
+			t.Errorf("unexpected field %d in the write request", num)
+		}
+		var s remoteWriteSeries
+		fields(ts, func(num protowire.Number, typ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				var l remoteWriteLabel
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					v, n := protowire.ConsumeString(b)
+					if num == 1 {
+						l.name = v
+					} else {
+						l.value = v
+					}
+					return n
+				})
+				s.labels = append(s.labels, l)
+			case 2:
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						v, n := protowire.ConsumeFixed64(b)
+						s.value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					s.timestamp = int64(v)
+					return n
+				})
+			default:
+				t.Errorf("unexpected field %d in a time series", num)
+			}
+			return n
+		})
+		series = append(series, s)
+		return n
+	})
+	return series
+}
+
+func seriesLabels(kv ...string) []remoteWriteLabel {
+	var ls []remoteWriteLabel
+	for i := 0; i < len(kv); i += 2 {
+		ls = append(ls, remoteWriteLabel{kv[i], kv[i+1]})
+	}
+	return ls
+}
+
+func TestRemoteWriteTimeSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	accesses := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "apache_accesses_total", Help: "h"}, []string{"target", "job"})
+	accesses.WithLabelValues("web01", "apache").Add(3)
+	workers := prometheus.NewGauge(prometheus.GaugeOpts{Name: "apache_workers", Help: "h"})
+	workers.Set(7)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "duration_seconds", Help: "h", Objectives: map[float64]float64{0.5: 0.05}})
+	summary.Observe(2)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size_bytes", Help: "h", Buckets: []float64{0.5, 10}})
+	histogram.Observe(1)
+	reg.MustRegister(accesses, workers, summary, histogram)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A timestamp of its own is kept.
+	mfs[0].Metric[0].TimestampMs = proto.Int64(1000)
+
+	now := time.Unix(1700000000, 0)
+	ms := now.UnixNano() / int64(time.Millisecond)
+	got := remoteWriteTimeSeries(mfs, map[string]string{"site": "edge01", "job": "edge"}, now)
+	want := []remoteWriteSeries{
+		{seriesLabels("__name__", "apache_accesses_total", "job", "apache", "site", "edge01", "target", "web01"), 3, 1000},
+		{seriesLabels("__name__", "apache_workers", "job", "edge", "site", "edge01"), 7, ms},
+		{seriesLabels("__name__", "duration_seconds", "job", "edge", "quantile", "0.5", "site", "edge01"), 2, ms},
+		{seriesLabels("__name__", "duration_seconds_sum", "job", "edge", "site", "edge01"), 2, ms},
+		{seriesLabels("__name__", "duration_seconds_count", "job", "edge", "site", "edge01"), 1, ms},
+		{seriesLabels("__name__", "size_bytes_bucket", "job", "edge", "le", "0.5", "site", "edge01"), 0, ms},
+		{seriesLabels("__name__", "size_bytes_bucket", "job", "edge", "le", "10", "site", "edge01"), 1, ms},
+		{seriesLabels("__name__", "size_bytes_bucket", "job", "edge", "le", "+Inf", "site", "edge01"), 1, ms},
+		{seriesLabels("__name__", "size_bytes_sum", "job", "edge", "site", "edge01"), 1, ms},
+		{seriesLabels("__name__", "size_bytes_count", "job", "edge", "site", "edge01"), 1, ms},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%v\ngot\n%v", want, got)
+	}
+	if decoded := decodeWriteRequest(t, encodeWriteRequest(got)); !reflect.DeepEqual(decoded, want) {
+		t.Errorf("expected the encoding to decode to\n%v\ngot\n%v", want, decoded)
+	}
+}
+
+// gathererAt gathers a single gauge of value.
+func gathererAt(value float64) prometheus.Gatherer {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "apache_up", Help: "h"})
+	g.Set(value)
+	reg.MustRegister(g)
+	return reg
+}
+
+func writtenValues(requests [][]remoteWriteSeries) []float64 {
+	var values []float64
+	for _, r := range requests {
+		for _, s := range r {
+			values = append(values, s.value)
+		}
+	}
+	return values
+}
+
+func TestRemoteWriteRetry(t *testing.T) {
+	failures, dropped := counterValue(t, remoteWriteFailures), counterValue(t, remoteWriteDropped)
+	receiver := &fakeReceiver{t: t, fail: []int{503, 503, 429}}
+	rs := httptest.NewServer(receiver)
+	defer rs.Close()
+	w, err := newRemoteWriter(rs.URL+"/api/v1/push", config.HTTPConfig{BearerToken: "mimir-token"}, nil, 2, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While the receiver fails, the scrapes are kept, the oldest dropped
+	// beyond two.
+	for i := 1; i <= 3; i++ {
+		if err := w.enqueue(gathererAt(float64(i)), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.flush(); err == nil {
+			t.Errorf("scrape %d: expected an error while the receiver fails", i)
+		}
+	}
+	if n := gaugeValue(t, remoteWritePending); n != 2 {
+		t.Errorf("expected 2 pending scrapes, got %v", n)
+	}
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := writtenValues(receiver.received()), []float64{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the kept scrapes in order %v, got %v", want, got)
+	}
+
+	// A scrape the receiver rejects is dropped rather than retried.
+	receiver.mu.Lock()
+	receiver.fail = []int{400}
+	receiver.mu.Unlock()
+	w.enqueue(gathererAt(4), time.Now())
+	w.enqueue(gathererAt(5), time.Now())
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := writtenValues(receiver.received()), []float64{2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if n := gaugeValue(t, remoteWritePending); n != 0 {
+		t.Errorf("expected no pending scrapes, got %v", n)
+	}
+	if v := counterValue(t, remoteWriteFailures) - failures; v != 4 {
+		t.Errorf("expected 4 failed sends, got %v", v)
+	}
+	if v := counterValue(t, remoteWriteDropped) - dropped; v != 2 {
+		t.Errorf("expected 2 dropped scrapes, got %v", v)
+	}
+}
+
+func TestRunRemoteWrite(t *testing.T) {
+	defer func(old time.Duration) { remoteWriteBackoff = old }(remoteWriteBackoff)
+	remoteWriteBackoff = 10 * time.Millisecond
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	receiver := &fakeReceiver{t: t, fail: []int{500, 500}}
+	rs := httptest.NewServer(receiver)
+	defer rs.Close()
+	w, err := newRemoteWriter(rs.URL, config.HTTPConfig{BearerToken: "mimir-token"}, map[string]string{"site": "edge01"}, 10, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		runRemoteWrite(w, newTargetSet(Exporters{NewExporter(ts.URL)}, nil), time.Hour, done)
+		close(stopped)
+	}()
+	// Retried with backoff rather than at the next scrape an hour later.
+	waitFor(t, "a successful send", func() bool { return len(receiver.received()) == 1 })
+	close(done)
+	<-stopped
+
+	var names []string
+	for _, s := range receiver.received()[0] {
+		if s.labels[0].name != "__name__" {
+			t.Fatalf("expected the name first, got %v", s.labels)
+		}
+		for _, l := range s.labels {
+			if l.name == "site" && l.value != "edge01" {
+				t.Errorf("expected the external label, got %v", s.labels)
+			}
+		}
+		names = append(names, s.labels[0].value)
+	}
+	sort.Strings(names)
+	all := strings.Join(names, " ")
+	for _, name := range []string{"apache_up", "apache_exporter_remote_write_failures_total"} {
+		if !strings.Contains(all, name) {
+			t.Errorf("expected %s to be sent, got %v", name, names)
+		}
+	}
+}
+
+func TestNewRemoteWriterErrors(t *testing.T) {
+	for _, tc := range []struct {
+		url        string
+		conf       config.HTTPConfig
+		maxPending int
+	}{
+		{"mimir:9009/api/v1/push", config.HTTPConfig{}, 1},
+		{"http://mimir/api/v1/push", config.HTTPConfig{}, 0},
+		{"http://u:p@mimir/api/v1/push", config.HTTPConfig{BearerToken: "t"}, 1},
+		{"http://mimir/api/v1/push", config.HTTPConfig{TLSConfig: &config.TLSConfig{CertFile: "cert.pem"}}, 1},
+	} {
+		if _, err := newRemoteWriter(tc.url, tc.conf, nil, tc.maxPending, http.DefaultClient); err == nil {
+			t.Errorf("%s %+v: expected an error", tc.url, tc.conf)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	resourcesProcfs = flag.String("collector.resources.procfs", "/proc", "Mount point of procfs the resources group of apache metrics is read from.")
+	resourcesNames  = &targetsFlag{values: []string{"httpd", "apache2"}}
+	resourcesTop    = flag.Int("collector.resources.top", 10, "Children with the most resident memory exported by their pid by the resources group of apache metrics, besides the totals of all of them. 0 exports the totals only.")
+)
+
+func init() {
+	flag.Var(resourcesNames, "collector.resources.names", "Process name of the apache parent, whose children the resources group of apache metrics is of. May be repeated or comma separated.")
+}
+
+func validateResourcesTop(top int) error {
+	if top < 0 {
+		return fmt.Errorf("-collector.resources.top can't be negative, got %d", top)
+	}
+	return nil
+}
+
+// userHZ is the unit of the CPU times in /proc/<pid>/stat, 100 on every
+// Linux platform.
+const userHZ = 100
+
+// resourceDescs describe the resource usage of the apache children.
+type resourceDescs struct {
+	rss, fds, cpu                *prometheus.Desc // Of a child, by its pid.
+	totalRSS, totalFDs, totalCPU *prometheus.Desc // Of all of them.
+}
+
+func newResourceDescs(labels prometheus.Labels) resourceDescs {
+	pid := []string{"pid"}
+	return resourceDescs{
+		rss:      newDesc("process_resident_memory_bytes", "Resident memory of the apache child process, for those with the most.", pid, labels),
+		fds:      newDesc("process_open_fds", "Open file descriptors of the apache child process, for those with the most resident memory.", pid, labels),
+		cpu:      newDesc("process_cpu_seconds_total", "CPU time of the apache child process, for those with the most resident memory.", pid, labels),
+		totalRSS: newDesc("processes_resident_memory_bytes", "Resident memory of all the apache child processes.", nil, labels),
+		totalFDs: newDesc("processes_open_fds", "Open file descriptors of all the apache child processes whose descriptors can be counted.", nil, labels),
+		totalCPU: newDesc("processes_cpu_seconds_total", "CPU time of the apache child processes running. Drops as children exit.", nil, labels),
+	}
+}
+
+// procStat is what the exporter takes of a process in procfs.
+type procStat struct {
+	pid, ppid int
+	comm      string
+	cpu       float64 // In seconds, user and system.
+	rss       float64 // In bytes.
+	fds       int     // -1 if they couldn't be counted.
+}
+
+// parseProcStat parses /proc/<pid>/stat, as in
+//
+//	1234 (httpd) S 1 1234 1234 0 -1 4194560 1059 0 0 0 12 3 0 0 20 0 1 0 2810 241307648 1930 ...
+//
+// where the name in parentheses may itself hold spaces and parentheses.
+func parseProcStat(data string) (procStat, bool) {
+	open, close := strings.IndexByte(data, '('), strings.LastIndexByte(data, ')')
+	if open < 0 || close < open {
+		return procStat{}, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(data[:open]))
+	if err != nil {
+		return procStat{}, false
+	}
+	// From the state on, the third field of the page.
+	fields := strings.Fields(data[close+1:])
+	if len(fields) < 22 {
+		return procStat{}, false
+	}
+	// ppid, utime, stime and rss, in pages.
+	var values [4]int64
+	for i, index := range []int{1, 11, 12, 21} {
+		if values[i], err = strconv.ParseInt(fields[index], 10, 64); err != nil {
+			return procStat{}, false
+		}
+	}
+	return procStat{
+		pid:  pid,
+		ppid: int(values[0]),
+		comm: data[open+1 : close],
+		cpu:  float64(values[1]+values[2]) / userHZ,
+		rss:  float64(values[3] * int64(os.Getpagesize())),
+	}, true
+}
+
+// apacheChildren returns the children of the apache parents in the procfs
+// mounted at root, the processes named one of names whose parent isn't.
+// Processes that go away while read are left out.
+func apacheChildren(root string, names []string) ([]procStat, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	isApache := map[string]bool{}
+	for _, n := range names {
+		isApache[n] = true
+	}
+	byPID := map[int]procStat{}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(root, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		if s, ok := parseProcStat(string(data)); ok && isApache[s.comm] {
+			byPID[s.pid] = s
+		}
+	}
+	var children []procStat
+	for _, s := range byPID {
+		parent, ok := byPID[s.ppid]
+		if !ok {
+			continue
+		}
+		if _, ok := byPID[parent.ppid]; ok {
+			// A grandchild, such as of a piped logger.
+			continue
+		}
+		s.fds = -1
+		if fds, err := ioutil.ReadDir(filepath.Join(root, strconv.Itoa(s.pid), "fd")); err == nil {
+			s.fds = len(fds)
+		}
+		children = append(children, s)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].rss != children[j].rss {
+			return children[i].rss > children[j].rss
+		}
+		return children[i].pid < children[j].pid
+	})
+	return children, nil
+}
+
+// isLocalURI tells whether the host of uri is this one, by name or by an
+// address of its own.
+func isLocalURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	if hostname, err := os.Hostname(); err == nil && strings.EqualFold(host, hostname) {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectResources exports the resource usage of the apache children on
+// this host, and nothing for targets elsewhere or without procfs.
+func (e *Exporter) collectResources(_ context.Context, ch chan<- prometheus.Metric) error {
+	if !isLocalURI(e.URI) {
+		return nil
+	}
+	children, err := apacheChildren(*resourcesProcfs, resourcesNames.values)
+	if err != nil {
+		e.logger.Debug("Not exporting the resources of the apache processes", "err", err)
+		return nil
+	}
+	var rss, cpu float64
+	fds := 0
+	for i, c := range children {
+		rss += c.rss
+		cpu += c.cpu
+		if c.fds >= 0 {
+			fds += c.fds
+		}
+		if i >= *resourcesTop {
+			continue
+		}
+		pid := strconv.Itoa(c.pid)
+		ch <- prometheus.MustNewConstMetric(e.resources.rss, prometheus.GaugeValue, c.rss, pid)
+		ch <- prometheus.MustNewConstMetric(e.resources.cpu, prometheus.CounterValue, c.cpu, pid)
+		if c.fds >= 0 {
+			ch <- prometheus.MustNewConstMetric(e.resources.fds, prometheus.GaugeValue, float64(c.fds), pid)
+		}
+	}
+	if len(children) == 0 {
+		return nil
+	}
+	ch <- prometheus.MustNewConstMetric(e.resources.totalRSS, prometheus.GaugeValue, rss)
+	ch <- prometheus.MustNewConstMetric(e.resources.totalFDs, prometheus.GaugeValue, float64(fds))
+	ch <- prometheus.MustNewConstMetric(e.resources.totalCPU, prometheus.CounterValue, cpu)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yosefy/apache_exporter/config"
+	"gopkg.in/yaml.v2"
+)
+
+var printConfig = flag.Bool("print-config", false, "Print the effective configuration at startup, as served on /-/config, with secrets redacted.")
+
+const redactedSecret = "<redacted>"
+
+// runtimeConfig is the effective configuration of the exporter: its flags
+// and the targets and modules they, and the files they name, set up.
+type runtimeConfig struct {
+	Flags   map[string]string        `yaml:"flags"`
+	Targets []runtimeTarget          `yaml:"targets"`
+	Modules map[string]config.Module `yaml:"modules,omitempty"`
+}
+
+type runtimeTarget struct {
+	config.Target `yaml:",inline"`
+	Collectors    []string `yaml:"collectors"`
+}
+
+func currentConfig(s *targetSet) runtimeConfig {
+	c := runtimeConfig{Flags: map[string]string{}, Modules: currentModules()}
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := f.Value.(*aliasFlag); ok {
+			return
+		}
+		// Flags hold no secrets but the credentials of URIs.
+		c.Flags[f.Name] = sanitizeURI(f.Value.String())
+	})
+	for _, e := range s.current() {
+		t := e.conf
+		t.Name, t.URI = e.name, e.URI
+		c.Targets = append(c.Targets, runtimeTarget{t, e.collectorNames()})
+	}
+	return c
+}
+
+// writeConfig writes the current configuration of s to w as YAML.
+func writeConfig(w io.Writer, s *targetSet) error {
+	out, err := yaml.Marshal(redact(reflect.ValueOf(currentConfig(s))))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// redact returns v for marshalling to YAML as its yaml tags say, with the
+// fields tagged secret redacted. It goes by the tags rather than by what
+// values look like, so that secrets can't slip through unrecognised.
+func redact(v reflect.Value) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem())
+	case reflect.Struct:
+		var out yaml.MapSlice
+		redactFields(v, &out)
+		return out
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		out := yaml.MapSlice{}
+		for _, k := range keys {
+			out = append(out, yaml.MapItem{Key: k.Interface(), Value: redact(v.MapIndex(k))})
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = redact(v.Index(i))
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// redactFields appends the fields of the struct v to out.
+func redactFields(v reflect.Value, out *yaml.MapSlice) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue // Unexported.
+		}
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		name, opts := tag[0], tag[1:]
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if hasOption(opts, "inline") {
+			redactFields(reflect.Indirect(fv), out)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if isEmpty(fv) && hasOption(opts, "omitempty") {
+			continue
+		}
+		var value interface{}
+		switch secret := f.Tag.Get("secret"); {
+		case secret == "" || isEmpty(fv):
+			value = redact(fv)
+		case secret == "uri" && fv.Kind() == reflect.Slice:
+			var uris []interface{}
+			for i := 0; i < fv.Len(); i++ {
+				uris = append(uris, sanitizeURI(fv.Index(i).String()))
+			}
+			value = uris
+		case secret == "uri":
+			value = sanitizeURI(fv.String())
+		case secret == "query":
+			value = sanitizeQuery(fv.String())
+		case fv.Kind() == reflect.Map:
+			values := yaml.MapSlice{}
+			for _, item := range redact(fv).(yaml.MapSlice) {
+				values = append(values, yaml.MapItem{Key: item.Key, Value: redactedSecret})
+			}
+			value = values
+		default:
+			value = redactedSecret
+		}
+		*out = append(*out, yaml.MapItem{Key: name, Value: value})
+	}
+}
+
+func hasOption(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty tells whether omitempty leaves v out.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// configHandler serves the current configuration of s, as written by
+// -print-config.
+func configHandler(s *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := writeConfig(w, s); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// sensitiveParams are query parameters whose values are redacted by
+// sanitizeURI.
+var sensitiveParams = map[string]bool{
+	"access_token": true,
+	"api_key":      true,
+	"apikey":       true,
+	"auth":         true,
+	"key":          true,
+	"pass":         true,
+	"passwd":       true,
+	"password":     true,
+	"pwd":          true,
+	"secret":       true,
+	"sig":          true,
+	"signature":    true,
+	"token":        true,
+}
+
+const redacted = "xxxxx"
+
+// sanitizeURI strips userinfo and the values of sensitive query parameters
+// from uri so it can be logged or exported. It works on the raw string so
+// that URIs which fail to parse are sanitized too.
+func sanitizeURI(uri string) string {
+	var scheme string
+	rest := uri
+	if i := strings.Index(rest, "://"); i >= 0 {
+		scheme, rest = rest[:i+3], rest[i+3:]
+	}
+
+	authority, tail := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		authority, tail = rest[:i], rest[i:]
+	}
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		authority = authority[i+1:]
+	}
+
+	var fragment string
+	if i := strings.Index(tail, "#"); i >= 0 {
+		tail, fragment = tail[:i], tail[i:]
+	}
+	if i := strings.Index(tail, "?"); i >= 0 {
+		tail = tail[:i+1] + sanitizeQuery(tail[i+1:])
+	}
+
+	return scheme + authority + tail + fragment
+}
+
+func sanitizeQuery(query string) string {
+	params := strings.Split(query, "&")
+	for i, p := range params {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			key = kv[0]
+		}
+		if sensitiveParams[strings.ToLower(key)] {
+			params[i] = kv[0] + "=" + redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// splitUserinfo removes the userinfo from uri, returning it separately so
+// it can be sent as basic auth instead. URIs without userinfo are returned
+// unchanged.
+func splitUserinfo(uri string) (string, *url.Userinfo) {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return uri, nil
+	}
+	// Cut the userinfo out of the raw string rather than re-serializing
+	// u, so the rest of the URI stays exactly as configured.
+	i := strings.Index(uri, "://") + 3
+	at := strings.LastIndex(uri[:i+strings.IndexAny(uri[i:]+"/", "/?#")], "@")
+	return uri[:i] + uri[at+1:], u.User
+}
+
+// sanitizeError replaces the URL embedded in errors from net/url and
+// net/http with its sanitized form.
+func sanitizeError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		ue.URL = sanitizeURI(ue.URL)
+		if inner, ok := ue.Err.(*url.Error); ok {
+			inner.URL = sanitizeURI(inner.URL)
+		}
+	}
+	return err
+}
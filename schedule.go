@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+var scrapeJitter = flag.Duration("scrape.jitter", 0, "Random delay of up to this much added to each scheduled target scrape.")
+
+// scrapeOffset is where in each interval the target labeled name gets
+// scraped. Hashing the name spreads targets over the interval while keeping
+// each one's offset the same across restarts and reloads.
+func scrapeOffset(name string, interval time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// nextScrape returns when a target at offset is due next after now.
+// Intervals are aligned to the wall clock, so the schedule is the same
+// every run, plus up to jitter of random delay.
+func nextScrape(now time.Time, interval, offset, jitter time.Duration) time.Time {
+	next := now.Truncate(interval).Add(offset)
+	if !next.After(now) {
+		next = next.Add(interval)
+	}
+	if jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(jitter))))
+	}
+	return next
+}
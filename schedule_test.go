@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScrapeOffsets(t *testing.T) {
+	const interval = 15 * time.Second
+	var names []string
+	for i := 0; i < 100; i++ {
+		names = append(names, fmt.Sprintf("web%02d", i))
+	}
+
+	offsets := map[string]time.Duration{}
+	buckets := make([]int, 5)
+	for _, name := range names {
+		offset := scrapeOffset(name, interval)
+		if offset < 0 || offset >= interval {
+			t.Fatalf("offset %s of %s outside the interval", offset, name)
+		}
+		offsets[name] = offset
+		buckets[int(offset*time.Duration(len(buckets))/interval)]++
+	}
+	for i, n := range buckets {
+		if n < 10 {
+			t.Errorf("expected offsets spread over the interval, only %d of 100 in bucket %d: %v", n, i, buckets)
+		}
+	}
+
+	// Offsets don't depend on the other targets or on when they're asked for.
+	for _, name := range names[50:] {
+		if offset := scrapeOffset(name, interval); offset != offsets[name] {
+			t.Errorf("offset of %s changed from %s to %s", name, offsets[name], offset)
+		}
+	}
+}
+
+func TestNextScrape(t *testing.T) {
+	const interval = 15 * time.Second
+	now := time.Date(2026, 1, 1, 12, 0, 7, 0, time.UTC)
+
+	for _, tc := range []struct {
+		offset time.Duration
+		want   time.Time
+	}{
+		{10 * time.Second, time.Date(2026, 1, 1, 12, 0, 10, 0, time.UTC)},
+		{7 * time.Second, time.Date(2026, 1, 1, 12, 0, 22, 0, time.UTC)},
+		{2 * time.Second, time.Date(2026, 1, 1, 12, 0, 17, 0, time.UTC)},
+	} {
+		if got := nextScrape(now, interval, tc.offset, 0); !got.Equal(tc.want) {
+			t.Errorf("offset %s: expected %s, got %s", tc.offset, tc.want, got)
+		}
+	}
+
+	want := time.Date(2026, 1, 1, 12, 0, 10, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		got := nextScrape(now, interval, 10*time.Second, time.Second)
+		if got.Before(want) || !got.Before(want.Add(time.Second)) {
+			t.Fatalf("expected jittered scrape within a second of %s, got %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var selfMetricsPath = flag.String("web.self-metrics-path", "", "Path under which to expose the apache_exporter_* metrics and those of the exporter process, such as go_*, instead of along with the apache_* metrics on the other paths. Empty to serve them together.")
+
+// isSelfMetric tells whether the family named name goes on
+// -web.self-metrics-path: everything but the apache_* metrics, which
+// apache_exporter_* aren't.
+func isSelfMetric(name string) bool {
+	return strings.HasPrefix(name, namespace+"_exporter_") || !strings.HasPrefix(name, namespace+"_")
+}
+
+// selfFilter keeps the families of what g gathers that isSelfMetric says
+// are self, or the others.
+type selfFilter struct {
+	g    prometheus.Gatherer
+	self bool
+}
+
+func (f selfFilter) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := f.g.Gather()
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if isSelfMetric(mf.GetName()) == f.self {
+			kept = append(kept, mf)
+		}
+	}
+	return kept, err
+}
+
+// selfSnapshot holds the self families of the latest scrape of the
+// telemetry path, those scrapes export about themselves, for the self
+// metrics path to serve without scraping.
+type selfSnapshot struct {
+	mutex sync.Mutex
+	mfs   []*dto.MetricFamily
+}
+
+// keep returns g, keeping what it gathers of the self families in s.
+func (s *selfSnapshot) keep(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		var self []*dto.MetricFamily
+		for _, mf := range mfs {
+			if isSelfMetric(mf.GetName()) {
+				self = append(self, proto.Clone(mf).(*dto.MetricFamily))
+			}
+		}
+		s.mutex.Lock()
+		s.mfs = self
+		s.mutex.Unlock()
+		return mfs, err
+	})
+}
+
+// Gather returns copies, as metric rules change what they are given.
+func (s *selfSnapshot) Gather() ([]*dto.MetricFamily, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mfs := make([]*dto.MetricFamily, len(s.mfs))
+	for i, mf := range s.mfs {
+		mfs[i] = proto.Clone(mf).(*dto.MetricFamily)
+	}
+	return mfs, nil
+}
+
+// selfMetricsHandler serves the self families of registry, of the default
+// registry unless -web.disable-exporter-metrics is set and of snapshot.
+func selfMetricsHandler(snapshot *selfSnapshot) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatherers := prometheus.Gatherers{registry, snapshot}
+		if !*disableExporterMetrics {
+			gatherers = append(gatherers, prometheus.DefaultGatherer)
+		}
+		promhttp.HandlerFor(selfFilter{rulesGatherer{gatherers}, true}, handlerOpts()).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+func TestIsSelfMetric(t *testing.T) {
+	for name, want := range map[string]bool{
+		"apache_up":                             false,
+		"apache_workers":                        false,
+		"apache_group_targets":                  false,
+		"apache_exporter_scrape_failures_total": true,
+		"apache_exporter_build_info":            true,
+		"go_goroutines":                         true,
+		"process_open_fds":                      true,
+	} {
+		if got := isSelfMetric(name); got != want {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+// exposedFamilies gets url, returning the names of the families served.
+func exposedFamilies(t *testing.T, url string) map[string]bool {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d", url, resp.StatusCode)
+	}
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	mfs, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	families := map[string]bool{}
+	for name := range mfs {
+		families[name] = true
+	}
+	return families
+}
+
+// TestSelfMetricsSplit checks that -web.self-metrics-path and the
+// telemetry path serve between them what the latter serves alone, each
+// family on one of them.
+func TestSelfMetricsSplit(t *testing.T) {
+	defer func(path string) { *selfMetricsPath = path }(*selfMetricsPath)
+	var scrapes int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&scrapes, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	es, err := setupExporters([]string{"a=" + backend.URL, "b=" + backend.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := newTargetSet(es, nil)
+
+	*selfMetricsPath = ""
+	combined := httptest.NewServer(endpointHandler(targets, endpoint{}))
+	defer combined.Close()
+	want := exposedFamilies(t, combined.URL)
+
+	*selfMetricsPath = "/exporter-metrics"
+	snapshot := &selfSnapshot{}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", endpointHandler(targets, endpoint{self: snapshot}))
+	mux.Handle("/exporter-metrics", selfMetricsHandler(snapshot))
+	split := httptest.NewServer(mux)
+	defer split.Close()
+	apache := exposedFamilies(t, split.URL+"/metrics")
+	before := atomic.LoadInt32(&scrapes)
+	self := exposedFamilies(t, split.URL+"/exporter-metrics")
+	if n := atomic.LoadInt32(&scrapes); n != before {
+		t.Errorf("expected the self metrics not to scrape apache, got %d scrapes", n-before)
+	}
+
+	for name := range apache {
+		if isSelfMetric(name) {
+			t.Errorf("%s: expected it only on the self metrics path", name)
+		}
+		if self[name] {
+			t.Errorf("%s: served on both paths", name)
+		}
+		if !want[name] {
+			t.Errorf("%s: not served without the self metrics path", name)
+		}
+	}
+	for name := range self {
+		if !isSelfMetric(name) {
+			t.Errorf("%s: expected it only on the telemetry path", name)
+		}
+		if !want[name] {
+			t.Errorf("%s: not served without the self metrics path", name)
+		}
+	}
+	for name := range want {
+		if !apache[name] && !self[name] {
+			t.Errorf("%s: served on neither path", name)
+		}
+	}
+	if !self["apache_exporter_target_scrape_duration_seconds"] {
+		t.Errorf("expected the self metrics of the scrapes, got %v", self)
+	}
+	if !apache["apache_up"] {
+		t.Errorf("expected apache_up on the telemetry path, got %v", apache)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var selfTestOnly = flag.Bool("selftest", false, "Scrape the status fixtures built into the binary, compare the metrics with the expected ones, print whether each fixture passed and exit, 1 if any failed. Needs neither the network nor apache.")
+
+// fixtureTransport answers every request with a status fixture, so that
+// fixtures go through the same requests and parsing as real status pages.
+type fixtureTransport string
+
+func (t fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=ISO-8859-1"}},
+		Body:          ioutil.NopCloser(strings.NewReader(string(t))),
+		ContentLength: int64(len(t)),
+		Request:       req,
+	}, nil
+}
+
+// fixtureExposition scrapes the status fixture profile and returns its
+// metrics in the text format, without the durations, which differ from run
+// to run.
+func fixtureExposition(profile string) ([]byte, error) {
+	status, err := statusFixture(profile)
+	if err != nil {
+		return nil, err
+	}
+	e := NewExporter("http://localhost/server-status?auto")
+	e.client = &http.Client{Transport: fixtureTransport(status)}
+	mfs, _, err := gatherOnce(Exporters{e})
+	if err != nil {
+		return nil, err
+	}
+	var stable []*dto.MetricFamily
+	for _, mf := range mfs {
+		if !strings.Contains(mf.GetName(), "duration") {
+			stable = append(stable, mf)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeText(&buf, stable); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// expectedExposition returns the metrics the status fixture profile should
+// scrape to.
+func expectedExposition(profile string) ([]byte, error) {
+	return statusFixtures.ReadFile(path.Join("testdata/status", profile+".prom"))
+}
+
+// selfTest scrapes every status fixture and compares its metrics with the
+// expected ones, writing a line per fixture to w. It fails if any fixture
+// didn't match.
+func selfTest(w io.Writer) error {
+	// The expected metrics have the legacy names.
+	defer func(mode compatModeFlag) { compatMode = mode }(compatMode)
+	compatMode = "legacy"
+	var failed []string
+	for _, profile := range statusProfiles() {
+		err := checkFixture(profile)
+		if err != nil {
+			failed = append(failed, profile)
+			fmt.Fprintf(w, "FAIL %s: %s\n", profile, err)
+		} else {
+			fmt.Fprintf(w, "ok   %s\n", profile)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d fixtures failed: %s", len(failed), len(statusProfiles()), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func checkFixture(profile string) error {
+	want, err := expectedExposition(profile)
+	if err != nil {
+		return err
+	}
+	got, err := fixtureExposition(profile)
+	if err != nil {
+		return err
+	}
+	return compareExpositions(got, want)
+}
+
+// compareExpositions fails with the first line that differs between got
+// and want.
+func compareExpositions(got, want []byte) error {
+	if bytes.Equal(got, want) {
+		return nil
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; ; i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			return fmt.Errorf("line %d: got %q, want %q", i+1, g, w)
+		}
+	}
+}
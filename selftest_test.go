@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixtureExpositions(t *testing.T) {
+	for _, profile := range statusProfiles() {
+		got, err := fixtureExposition(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *updateGolden {
+			if err := ioutil.WriteFile(filepath.Join("testdata", "status", profile+".prom"), got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if !bytes.Contains(got, []byte("\napache_up 1\n")) {
+			t.Errorf("%s: expected the fixture to parse, got:\n%s", profile, got)
+		}
+		if err := checkFixture(profile); err != nil {
+			t.Errorf("%s: %s", profile, err)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	var buf bytes.Buffer
+	if err := selfTest(&buf); err != nil {
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+	if got := strings.Count(buf.String(), "ok   "); got != len(statusProfiles()) {
+		t.Errorf("expected a passing line per fixture, got:\n%s", buf.String())
+	}
+}
+
+func TestCompareExpositions(t *testing.T) {
+	want := []byte("apache_up 1\napache_workers{state=\"busy\"} 4\n")
+	if err := compareExpositions(want, want); err != nil {
+		t.Errorf("expected equal expositions to match, got %s", err)
+	}
+	err := compareExpositions([]byte("apache_up 1\napache_workers{state=\"busy\"} 5\n"), want)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected the second line to differ, got %v", err)
+	}
+	err = compareExpositions([]byte("apache_up 1\n"), want)
+	if err == nil || !strings.Contains(err.Error(), `got "", want "apache_workers`) {
+		t.Errorf("expected a missing line, got %v", err)
+	}
+	if err := checkFixture("nginx"); err == nil {
+		t.Error("expected an unknown fixture to fail")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	stdlog "log"
+	"net/http"
+	"time"
+)
+
+var (
+	readTimeout    = flag.Duration("web.read-timeout", 10*time.Second, "How long clients may take to send a request, headers included. 0 waits forever.")
+	writeTimeout   = flag.Duration("web.write-timeout", 0, "How long the exporter may take to answer a request once its headers are read. 0 takes -scrape.timeout plus a minute.")
+	idleTimeout    = flag.Duration("web.idle-timeout", time.Minute, "How long idle keep-alive connections are kept open.")
+	maxHeaderBytes = flag.Int("web.max-header-bytes", http.DefaultMaxHeaderBytes, "Largest request headers accepted, in bytes.")
+)
+
+// newServer returns a server for h with the -web.*-timeout and
+// -web.max-header-bytes limits, so that clients sending slowly or not at
+// all can't hold connections open.
+func newServer(h http.Handler, tlsConfig *tls.Config, errorLog *stdlog.Logger) *http.Server {
+	write := *writeTimeout
+	if write == 0 {
+		// Long enough for the slowest scrape, and for a default 30s pprof profile.
+		write = *scrapeTimeout + time.Minute
+	}
+	return &http.Server{
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ErrorLog:          errorLog,
+		ReadHeaderTimeout: *readTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      write,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveTest(t *testing.T, server *http.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	return l.Addr().String()
+}
+
+func TestServerReadTimeout(t *testing.T) {
+	defer func(old time.Duration) { *readTimeout = old }(*readTimeout)
+	*readTimeout = 200 * time.Millisecond
+	server := newServer(healthzHandler(), nil, nil)
+	defer server.Close()
+	conn, err := net.Dial("tcp", serveTest(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Send the headers a byte at a time, never finishing them.
+	go func() {
+		request := "GET /healthz HTTP/1.1\r\nHost: localhost\r\nX-Slow: " + strings.Repeat("a", 1000)
+		for i := range request {
+			if _, err := conn.Write([]byte{request[i]}); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("expected the slow client to be cut off")
+	}
+	if strings.Contains(string(buf[:n]), "200 OK") {
+		t.Errorf("expected no answer to the unfinished request, got %q", buf[:n])
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the connection closed after the read timeout, took %s", elapsed)
+	}
+}
+
+func TestServerMaxHeaderBytes(t *testing.T) {
+	defer func(old int) { *maxHeaderBytes = old }(*maxHeaderBytes)
+	*maxHeaderBytes = 1024
+	server := newServer(healthzHandler(), nil, nil)
+	defer server.Close()
+	conn, err := net.Dial("tcp", serveTest(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 8192) + "\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected 431, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerWriteTimeout(t *testing.T) {
+	defer func(old time.Duration) { *writeTimeout = old }(*writeTimeout)
+	if got, want := newServer(nil, nil, nil).WriteTimeout, *scrapeTimeout+time.Minute; got != want {
+		t.Errorf("expected the write timeout to follow -scrape.timeout, %s, got %s", want, got)
+	}
+	*writeTimeout = time.Second
+	if got := newServer(nil, nil, nil).WriteTimeout; got != time.Second {
+		t.Errorf("expected -web.write-timeout, got %s", got)
+	}
+}
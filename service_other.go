@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// runService reports that the exporter didn't run as a service, which it
+// only does on Windows.
+func runService() bool {
+	return false
+}
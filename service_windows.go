@@ -0,0 +1,174 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "apache_exporter"
+
+var (
+	serviceInstall   = flag.Bool("service.install", false, "Register the exporter as a Windows service started with the other flags given, then exit.")
+	serviceUninstall = flag.Bool("service.uninstall", false, "Remove the Windows service registered with -service.install, then exit.")
+)
+
+// runService handles -service.install and -service.uninstall, and runs
+// the exporter under the Service Control Manager when started by it. It
+// returns whether it did any of that, leaving main nothing to do.
+func runService() bool {
+	switch {
+	case *serviceInstall:
+		if err := installService(serviceArgs(os.Args[1:])); err != nil {
+			fatal("Error installing the service", err)
+		}
+		fmt.Printf("Installed the %s service\n", serviceName)
+		return true
+	case *serviceUninstall:
+		if err := uninstallService(); err != nil {
+			fatal("Error removing the service", err)
+		}
+		fmt.Printf("Removed the %s service\n", serviceName)
+		return true
+	}
+
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		fatal("Error telling whether running as a service", err)
+	}
+	if !isService {
+		return false
+	}
+	if *logFilePath == "" {
+		if elog, err := eventlog.Open(serviceName); err == nil {
+			defer elog.Close()
+			// -log.level and -log.format were checked by setupLogging.
+			logger, _ = newLogger(eventLogWriter{elog}, logLevelVar, *logFormat)
+		}
+	}
+	if err := svc.Run(serviceName, &service{run: run}); err != nil {
+		fatal("Error running the service", err)
+	}
+	return true
+}
+
+// eventLogWriter writes the log to the Windows event log.
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	var err error
+	if strings.Contains(msg, "level=ERROR") || strings.Contains(msg, `"level":"ERROR"`) {
+		err = w.elog.Error(1, msg)
+	} else {
+		err = w.elog.Info(1, msg)
+	}
+	return len(p), err
+}
+
+// service runs the exporter for the Service Control Manager, shutting it
+// down gracefully when asked to stop.
+type service struct {
+	run func(term <-chan os.Signal)
+}
+
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	term := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.run(term)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				term <- syscall.SIGTERM
+				<-stopped
+				return false, 0
+			}
+		case <-stopped:
+			return false, 0
+		}
+	}
+}
+
+// serviceArgs returns the flags the service is to start with: those given
+// to -service.install, without it.
+func serviceArgs(args []string) []string {
+	var kept []string
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			name = name[:i]
+		}
+		if name != "service.install" {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Apache Exporter",
+		Description: "Exports apache server-status metrics for Prometheus.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("setting up the event log: %v", err)
+	}
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s isn't installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	return eventlog.Remove(serviceName)
+}
@@ -0,0 +1,57 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+func TestServiceExecute(t *testing.T) {
+	started := make(chan struct{})
+	var stoppedBy os.Signal
+	s := &service{run: func(term <-chan os.Signal) {
+		close(started)
+		stoppedBy = <-term
+	}}
+	requests := make(chan svc.ChangeRequest)
+	status := make(chan svc.Status, 10)
+	returned := make(chan struct{})
+	go func() {
+		s.Execute(nil, requests, status)
+		close(returned)
+	}()
+	<-started
+
+	requests <- svc.ChangeRequest{Cmd: svc.Interrogate, CurrentStatus: svc.Status{State: svc.Running}}
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the service didn't stop")
+	}
+	if stoppedBy == nil {
+		t.Error("expected the exporter to be asked to shut down")
+	}
+	close(status)
+	var states []svc.State
+	for st := range status {
+		states = append(states, st.State)
+	}
+	if want := []svc.State{svc.StartPending, svc.Running, svc.Running, svc.StopPending}; !reflect.DeepEqual(states, want) {
+		t.Errorf("expected states %v, got %v", want, states)
+	}
+}
+
+func TestServiceArgs(t *testing.T) {
+	got := serviceArgs([]string{"-service.install", "--scrape_uri=http://web01/server-status?auto", "--service.install=true", "-telemetry.address", ":9117"})
+	want := []string{"--scrape_uri=http://web01/server-status?auto", "-telemetry.address", ":9117"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
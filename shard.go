@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shardsTotal = flag.Int("sharding.total", 0, "Number of exporter replicas splitting the configured and discovered targets between them, each scraping those of its -sharding.index. Disabled if 0 or 1.")
+	shardIndex  = flag.Int("sharding.index", 0, "Shard of the targets this replica scrapes, from 0 to -sharding.total - 1.")
+
+	shardTargetsGauge prometheus.Gauge
+)
+
+func init() {
+	selfMetric(func() {
+		shardTargetsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_shard_targets",
+			Help:        "Number of targets in the shard of this replica, as set by -sharding.total and -sharding.index.",
+			ConstLabels: withConstLabels(nil),
+		})
+	})
+}
+
+func validateSharding(total, index int) error {
+	if total < 0 {
+		return fmt.Errorf("negative -sharding.total %d", total)
+	}
+	if total > 1 && (index < 0 || index >= total) {
+		return fmt.Errorf("-sharding.index %d is out of range for -sharding.total %d, expected 0 to %d", index, total, total-1)
+	}
+	if total <= 1 && index != 0 {
+		return fmt.Errorf("-sharding.index requires a -sharding.total above 1")
+	}
+	return nil
+}
+
+// shardHash is the hash of a target name its shard is taken from: the
+// 64-bit FNV-1a hash of its bytes. It must never change, or targets move
+// between replicas when upgrading.
+func shardHash(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum64()
+}
+
+// shardOf returns the shard out of total the target named name is in.
+func shardOf(name string, total int) int {
+	return int(shardHash(name) % uint64(total))
+}
+
+// keepShard returns the targets in shard index out of total, by their
+// target label once their URIs are completed with d, all of them if total
+// is 0 or 1.
+func keepShard(targets []target, d uriDefaults, total, index int) []target {
+	if total <= 1 {
+		return targets
+	}
+	var kept []target
+	for _, t := range targets {
+		completed := t
+		completed.uri = completeURI(t.uri, d)
+		if shardOf(completed.label(), total) == index {
+			kept = append(kept, t)
+		}
+	}
+	shardTargetsGauge.Set(float64(len(kept)))
+	return kept
+}
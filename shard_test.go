@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestShardHash(t *testing.T) {
+	// The FNV-1a test vectors: a change here moves targets between
+	// replicas on upgrades.
+	for name, want := range map[string]uint64{
+		"":      0xcbf29ce484222325,
+		"a":     0xaf63dc4c8601ec8c,
+		"web01": 0x6613be2e090dbca6,
+	} {
+		if got := shardHash(name); got != want {
+			t.Errorf("%q: expected %#x, got %#x", name, want, got)
+		}
+	}
+}
+
+func TestKeepShard(t *testing.T) {
+	var targets []target
+	for i := 1; i <= 12; i++ {
+		targets = append(targets, target{name: fmt.Sprintf("web%02d", i), uri: fmt.Sprintf("web%02d", i)})
+	}
+	shards := func(total int) []int {
+		got := make([]int, len(targets))
+		for index := 0; index < total; index++ {
+			for _, t := range keepShard(targets, testDefaults, total, index) {
+				var i int
+				fmt.Sscanf(t.name, "web%d", &i)
+				got[i-1] += index + 1
+			}
+		}
+		// Each target is in exactly one shard, its index + 1 here.
+		for i := range got {
+			got[i]--
+		}
+		return got
+	}
+	for _, tc := range []struct {
+		total int
+		want  []int // Shard of web01 to web12.
+	}{
+		{3, []int{1, 0, 2, 0, 2, 1, 0, 1, 0, 2, 0, 1}},
+		// Growing to four replicas moves the targets as the hash says.
+		{4, []int{2, 3, 0, 1, 2, 3, 0, 1, 2, 0, 3, 2}},
+	} {
+		if got := shards(tc.total); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d shards: expected %v, got %v", tc.total, tc.want, got)
+		}
+	}
+
+	if got := keepShard(targets, testDefaults, 1, 0); len(got) != len(targets) {
+		t.Errorf("expected every target without sharding, got %d", len(got))
+	}
+	// Unnamed targets are sharded by their completed URI, their target label.
+	unnamed := []target{{uri: "web01"}}
+	if shardOf("http://web01/server-status?auto", 3) == 0 {
+		t.Fatal("expected the test target not to be in shard 0")
+	}
+	if got := keepShard(unnamed, testDefaults, 3, shardOf("http://web01/server-status?auto", 3)); len(got) != 1 || got[0].uri != "web01" {
+		t.Errorf("expected the unnamed target to be kept as it was, got %v", got)
+	}
+}
+
+func TestValidateSharding(t *testing.T) {
+	for _, tc := range []struct {
+		total, index int
+		ok           bool
+	}{
+		{0, 0, true},
+		{1, 0, true},
+		{3, 2, true},
+		{3, 3, false},
+		{3, -1, false},
+		{-1, 0, false},
+		{0, 1, false},
+	} {
+		if err := validateSharding(tc.total, tc.index); (err == nil) != tc.ok {
+			t.Errorf("total %d, index %d: got %v", tc.total, tc.index, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var shutdownTimeout = flag.Duration("web.shutdown-timeout", 30*time.Second, "How long to wait on SIGTERM or SIGINT for in-flight requests and scrapes to finish before exiting.")
+
+// shutdown turns the exporter unready and has servers stop accepting
+// connections, then waits until ctx is done for in-flight requests and
+// background scrapes to finish, cutting short those that don't. Last it
+// closes done and waits for the discovery watchers in watchers to stop.
+func shutdown(ctx context.Context, servers []*http.Server, ready *readiness, background *backgroundScraper, done chan struct{}, watchers *sync.WaitGroup) error {
+	ready.set(stateStopping)
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			err := server.Shutdown(ctx)
+			if err != nil {
+				server.Close()
+			}
+			errs <- err
+		}(server)
+	}
+	var err error
+	for range servers {
+		if serr := <-errs; err == nil {
+			err = serr
+		}
+	}
+	if background != nil {
+		if berr := background.shutdown(ctx); err == nil {
+			err = berr
+		}
+	}
+	close(done)
+	watchers.Wait()
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowApache serves the status page after a delay, sending on started as
+// each request comes in.
+func slowApache(delay time.Duration, started chan<- struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-time.After(delay):
+			w.Write([]byte(apache24Status))
+		case <-r.Context().Done():
+		}
+	}))
+}
+
+func serveMetrics(t *testing.T, s scraper) (*http.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: metricsHandler(s)}
+	go server.Serve(l)
+	return server, "http://" + l.Addr().String() + "/metrics"
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{}, 2)
+	ts := slowApache(300*time.Millisecond, started)
+	defer ts.Close()
+
+	targets := newTargetSet(Exporters{NewExporter(ts.URL)}, nil)
+	server, url := serveMetrics(t, targets)
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		responses <- response{string(body), err}
+	}()
+	<-started
+
+	background := newBackgroundScraper(newTargetSet(Exporters{NewExporter(ts.URL)}, nil), time.Hour, 0)
+	background.start()
+	background.startScrape(background.targets.current()[0], make(chan struct{}, 1))
+	<-started
+
+	done := make(chan struct{})
+	var watchers sync.WaitGroup
+	watchers.Add(1)
+	go func() {
+		defer watchers.Done()
+		<-done
+	}()
+	ready := &readiness{}
+	ready.set(stateReady)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx, []*http.Server{server}, ready, background, done, &watchers); err != nil {
+		t.Fatal(err)
+	}
+
+	if ready.state != stateStopping {
+		t.Errorf("expected to be unready, got state %d", ready.state)
+	}
+	r := <-responses
+	if r.err != nil {
+		t.Fatalf("expected the request in flight to complete, got %s", r.err)
+	}
+	if !strings.Contains(r.body, "apache_up 1") {
+		t.Errorf("expected the scrape in flight to succeed, got\n%s", r.body)
+	}
+	if n := cachedTargets(background); n != 1 {
+		t.Errorf("expected the background scrape in flight to be cached, got %d results", n)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	ts := slowApache(time.Hour, started)
+	defer ts.Close()
+
+	background := newBackgroundScraper(newTargetSet(Exporters{NewExporter(ts.URL)}, nil), time.Hour, 0)
+	background.startScrape(background.targets.current()[0], make(chan struct{}, 1))
+	<-started
+
+	server, _ := serveMetrics(t, background)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- shutdown(ctx, []*http.Server{server}, &readiness{}, background, make(chan struct{}), &sync.WaitGroup{})
+	}()
+	select {
+	case err := <-stopped:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected the deadline to be exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't cut short the scrape after the timeout")
+	}
+	if n := cachedTargets(background); n != 0 {
+		t.Errorf("expected the cut short scrape not to be cached, got %d results", n)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// forceSignals make the background scraper scrape all targets right away.
+var forceSignals = []os.Signal{syscall.SIGUSR1}
+
+// logLevelSignals switch the log level to debug and back.
+var logLevelSignals = []os.Signal{syscall.SIGUSR2}
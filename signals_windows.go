@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// forceSignals make the background scraper scrape all targets right away.
+// Windows has no SIGUSR1, leaving /-/scrape.
+var forceSignals []os.Signal
+
+// logLevelSignals switch the log level to debug and back. Windows has no
+// SIGUSR2, leaving /-/log-level.
+var logLevelSignals []os.Signal
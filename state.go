@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	stateFile     = flag.String("state.file", "", "File to keep the last seen accesses, traffic and uptime of the targets in, and what the outputs sent of -output.delta-metrics, so that restarting the exporter isn't taken for apache restarting.")
+	stateInterval = flag.Duration("state.interval", time.Minute, "How often to write -state.file, which is also written on shutdown.")
+	stateMaxAge   = flag.Duration("state.max-age", time.Hour, "Age past which -state.file is ignored on startup.")
+)
+
+// seenFields are the fields of the status page kept in the state file,
+// which only grow while apache runs.
+var seenFields = []string{"Total Accesses", "Total kBytes", "Uptime"}
+
+// savedState is what the state file holds.
+type savedState struct {
+	Saved   time.Time                     `json:"saved"`
+	Targets map[string]map[string]float64 `json:"targets"`          // Seen fields, by target.
+	Deltas  map[string]map[string]float64 `json:"deltas,omitempty"` // Last values, by output and series.
+}
+
+var (
+	// restoredState is the state file read on startup, nil if there was
+	// none to use.
+	restoredState *savedState
+	// stateDeltas returns the last values of the deltas of the outputs
+	// compiled in, for the state file. Nil without outputs.
+	stateDeltas func() map[string]map[string]float64
+)
+
+func validateState(path string, interval time.Duration) error {
+	if path != "" && interval <= 0 {
+		return fmt.Errorf("-state.interval must be positive, got %s", interval)
+	}
+	return nil
+}
+
+// seenCounters are the seen fields of a target at its latest scrape, for
+// telling when apache restarted.
+type seenCounters struct {
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+// observe takes in the values of a scrape, telling whether apache
+// restarted since the previous one: its uptime or, without one, a counter
+// went down.
+func (s *seenCounters) observe(values map[string]float64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	restarted := false
+	for _, field := range seenFields {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		if previous, ok := s.values[field]; ok && value < previous {
+			restarted = true
+		}
+		if s.values == nil {
+			s.values = map[string]float64{}
+		}
+		s.values[field] = value
+	}
+	return restarted
+}
+
+func (s *seenCounters) get() map[string]float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values := make(map[string]float64, len(s.values))
+	for field, value := range s.values {
+		values[field] = value
+	}
+	return values
+}
+
+func (s *seenCounters) set(values map[string]float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values = values
+}
+
+// loadState reads the state file at path, returning nil if there is none,
+// or it is older than maxAge at now or can't be read, which is logged.
+func loadState(path string, maxAge time.Duration, now time.Time) *savedState {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		logger.Warn("Ignoring -state.file", "file", path, "err", err)
+		return nil
+	}
+	var st savedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		logger.Warn("Ignoring -state.file", "file", path, "err", err)
+		return nil
+	}
+	if st.Saved.IsZero() {
+		logger.Warn("Ignoring -state.file", "file", path, "err", "no time saved")
+		return nil
+	}
+	if age := now.Sub(st.Saved); age > maxAge {
+		logger.Warn("Ignoring -state.file older than -state.max-age", "file", path, "age", age.Round(time.Second))
+		return nil
+	}
+	logger.Info("Restored the state", "file", path, "targets", len(st.Targets))
+	return &st
+}
+
+// restore hands the seen fields of st over to the exporters in es of the
+// same target.
+func (es Exporters) restore(st *savedState) {
+	if st == nil {
+		return
+	}
+	for _, e := range es {
+		if values, ok := st.Targets[e.name]; ok {
+			e.seen.set(values)
+		}
+	}
+}
+
+// saveState writes the seen fields of es and the deltas of the outputs to
+// the state file at path, stamped with now.
+func saveState(path string, es Exporters, now time.Time) error {
+	st := savedState{Saved: now, Targets: map[string]map[string]float64{}}
+	for _, e := range es {
+		if values := e.seen.get(); len(values) > 0 {
+			st.Targets[e.name] = values
+		}
+	}
+	if stateDeltas != nil {
+		st.Deltas = stateDeltas()
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("encoding the state: %v", err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// runState writes the state file for the current targets of s every
+// interval and once more when done is closed.
+func runState(path string, s *targetSet, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for stop := false; !stop; {
+		select {
+		case <-ticker.C:
+		case <-done:
+			stop = true
+		}
+		if err := saveState(path, s.current(), time.Now()); err != nil {
+			logger.Error("Error writing -state.file", "file", path, "err", err)
+		}
+	}
+}
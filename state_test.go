@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSeenCounters(t *testing.T) {
+	s := &seenCounters{}
+	for i, c := range []struct {
+		values    map[string]float64
+		restarted bool
+	}{
+		{map[string]float64{"Total Accesses": 100, "Uptime": 300}, false},
+		{map[string]float64{"Total Accesses": 150, "Uptime": 360}, false},
+		{map[string]float64{"Total Accesses": 20, "Uptime": 5}, true},
+		// A field left out, such as one failing to parse, isn't a restart.
+		{map[string]float64{"Uptime": 65}, false},
+		{map[string]float64{"Total Accesses": 30, "Uptime": 70}, false},
+		{map[string]float64{"Total Accesses": 10}, true},
+	} {
+		if restarted := s.observe(c.values); restarted != c.restarted {
+			t.Errorf("scrape %d: expected restarted %v, got %v", i+1, c.restarted, restarted)
+		}
+	}
+}
+
+// statusServer serves a status page with the accesses and uptime last set.
+type statusServer struct {
+	mutex            sync.Mutex
+	accesses, uptime string
+}
+
+func (s *statusServer) set(accesses, uptime string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accesses, s.uptime = accesses, uptime
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w.Write([]byte("Total Accesses: " + s.accesses + "\nTotal kBytes: 10\nUptime: " + s.uptime + "\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+}
+
+func TestStateRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	apache := &statusServer{}
+	ts := httptest.NewServer(apache)
+	defer ts.Close()
+	scrape := func(e *Exporter) {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			defer close(ch)
+			e.Collect(ch)
+		}()
+		drain(ch)
+	}
+	start := func() *Exporter {
+		e := NewExporter(ts.URL)
+		e.name = "web01"
+		Exporters{e}.restore(loadState(path, time.Hour, time.Now()))
+		return e
+	}
+
+	apache.set("100", "300")
+	e := start()
+	scrape(e)
+	if err := saveState(path, Exporters{e}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The exporter restarts while apache keeps running.
+	apache.set("120", "360")
+	e = start()
+	scrape(e)
+	if v := counterValue(t, e.restarts); v != 0 {
+		t.Errorf("expected no apache restart after restarting the exporter, got %v", v)
+	}
+	if err := saveState(path, Exporters{e}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Apache restarts while the exporter is down.
+	apache.set("5", "10")
+	e = start()
+	scrape(e)
+	if v := counterValue(t, e.restarts); v != 1 {
+		t.Errorf("expected the apache restart to be seen from the state, got %v", v)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if st := loadState(path, time.Hour, now); st != nil {
+		t.Errorf("expected nothing without a state file, got %v", st)
+	}
+	for _, c := range []struct {
+		name, data string
+	}{
+		{"corrupt", `{"saved": "2026-01-01T11:59:00Z", "targets": {"web01": `},
+		{"not json", "\x00\x01"},
+		{"no time", `{"targets": {"web01": {"Uptime": 300}}}`},
+		{"stale", `{"saved": "2026-01-01T10:00:00Z", "targets": {"web01": {"Uptime": 300}}}`},
+	} {
+		if err := ioutil.WriteFile(path, []byte(c.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if st := loadState(path, time.Hour, now); st != nil {
+			t.Errorf("%s: expected the state file to be ignored, got %v", c.name, st)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"saved": "2026-01-01T11:30:00Z", "targets": {"web01": {"Uptime": 300}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st := loadState(path, time.Hour, now)
+	if st == nil || st.Targets["web01"]["Uptime"] != 300 {
+		t.Errorf("expected the state to be loaded, got %v", st)
+	}
+}
+
+func TestValidateState(t *testing.T) {
+	if err := validateState("state.json", 0); err == nil {
+		t.Error("expected a -state.interval of 0 to be rejected")
+	}
+	if err := validateState("", 0); err != nil {
+		t.Errorf("expected no error without -state.file, got %s", err)
+	}
+}
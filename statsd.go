@@ -0,0 +1,299 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	statsdAddress   = flag.String("statsd.address", "", "host:port of a StatsD server, such as a Datadog agent, to send the targets' and the exporter's gauges and counters to every -statsd.interval.")
+	statsdProtocol  = flag.String("statsd.protocol", "udp", "Transport to -statsd.address: udp or tcp.")
+	statsdInterval  = flag.Duration("statsd.interval", time.Minute, "How often to scrape the targets and send them to -statsd.address.")
+	statsdPrefix    = flag.String("statsd.prefix", "", "Prefix of the StatsD names of the metrics, such as apache.")
+	statsdTagFormat = flag.String("statsd.tag-format", "datadog", "How labels are sent to -statsd.address: as datadog tags (|#name:value), influx tags (name,name=value:...), or none, appended to the metric name as in Graphite.")
+	statsdMaxPacket = flag.Int("statsd.max-packet-size", 1432, "Largest UDP packet to send to -statsd.address, in bytes. Metrics are packed into as few packets as fit.")
+
+	statsdFailures prometheus.Counter
+)
+
+var statsdTagFormats = map[string]bool{"datadog": true, "influx": true, "none": true}
+
+// statsdTimeout bounds connecting to and writing to StatsD.
+const statsdTimeout = 15 * time.Second
+
+func init() {
+	registerOutput(output{name: "statsd", setup: func() (func(s *targetSet, done <-chan struct{}), error) {
+		if err := validateStatsd(*statsdAddress, *statsdProtocol, *statsdTagFormat, *statsdInterval, *statsdMaxPacket); err != nil {
+			return nil, err
+		}
+		if *statsdAddress == "" {
+			return nil, nil
+		}
+		e := newStatsdEmitter(*statsdProtocol, *statsdAddress, *statsdPrefix, *statsdTagFormat, *statsdMaxPacket)
+		return func(s *targetSet, done <-chan struct{}) {
+			runStatsd(e, s, *statsdInterval, done)
+		}, nil
+	}})
+	selfMetric(func() {
+		statsdFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "statsd_failures_total",
+			Help:        "Number of failed sends to -statsd.address.",
+			ConstLabels: withConstLabels(nil),
+		})
+		registry.MustRegister(statsdFailures)
+	})
+}
+
+func validateStatsd(address, protocol, tagFormat string, interval time.Duration, maxPacket int) error {
+	if address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid -statsd.address: %v", err)
+	}
+	if protocol != "udp" && protocol != "tcp" {
+		return fmt.Errorf("-statsd.protocol must be udp or tcp, got %q", protocol)
+	}
+	if !statsdTagFormats[tagFormat] {
+		return fmt.Errorf("-statsd.tag-format must be datadog, influx or none, got %q", tagFormat)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("-statsd.interval must be positive, got %s", interval)
+	}
+	if maxPacket < 64 {
+		return fmt.Errorf("-statsd.max-packet-size must be at least 64, got %d", maxPacket)
+	}
+	return nil
+}
+
+// statsdEmitter sends gauges and counters to StatsD, the counters as their
+// increase since the previous send.
+type statsdEmitter struct {
+	protocol, address string
+	prefix, tagFormat string
+	maxPacket         int
+	last              map[string]float64 // Of the counters at the previous send.
+}
+
+func newStatsdEmitter(protocol, address, prefix, tagFormat string, maxPacket int) *statsdEmitter {
+	return &statsdEmitter{protocol: protocol, address: address, prefix: prefix, tagFormat: tagFormat, maxPacket: maxPacket}
+}
+
+// send connects to StatsD and sends it what g gathers.
+func (e *statsdEmitter) send(g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	lines := e.lines(mfs)
+	if len(lines) == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout(e.protocol, e.address, statsdTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(statsdTimeout))
+	if e.protocol == "tcp" {
+		_, err := conn.Write([]byte(strings.Join(lines, "\n") + "\n"))
+		return err
+	}
+	for _, p := range statsdPackets(lines, e.maxPacket) {
+		if _, err := conn.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lines returns the StatsD lines of the gauges, counters and untyped
+// metrics of mfs, counters as a count of their increase since the previous
+// call. Counters without a previous value are left out, and those that
+// went down, as apache restarted, count their value since the reset.
+// Other types, and values StatsD can't take, are left out.
+func (e *statsdEmitter) lines(mfs []*dto.MetricFamily) []string {
+	var lines []string
+	last := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			var value float64
+			kind := "g"
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			case dto.MetricType_COUNTER:
+				kind = "c"
+				key := seriesKey(mf.GetName(), m.Label)
+				current := m.GetCounter().GetValue()
+				last[key] = current
+				previous, ok := e.last[key]
+				if !ok {
+					continue
+				}
+				if value = current - previous; value < 0 {
+					value = current
+				}
+			default:
+				continue
+			}
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			lines = append(lines, e.line(mf.GetName(), m.Label, value, kind))
+		}
+	}
+	e.last = last
+	return lines
+}
+
+// statsdName replaces the colons StatsD separates names from values with.
+var statsdName = strings.NewReplacer(":", "_")
+
+// statsdDatadogValue and statsdInfluxValue replace what separates tags
+// from each other and from the rest of the line in the tag values of
+// each format.
+var (
+	statsdDatadogValue = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+	statsdInfluxValue  = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_", "=", "_", ":", "_", " ", "_")
+)
+
+// line formats a sample of name with labels as a StatsD line of kind, g
+// or c, with its labels in the tag format of e.
+func (e *statsdEmitter) line(name string, labels []*dto.LabelPair, value float64, kind string) string {
+	var b strings.Builder
+	if e.prefix != "" {
+		b.WriteString(strings.TrimSuffix(e.prefix, "."))
+		b.WriteByte('.')
+	}
+	v := strconv.FormatFloat(value, 'f', -1, 64)
+	switch e.tagFormat {
+	case "datadog":
+		b.WriteString(statsdName.Replace(name))
+		b.WriteString(":" + v + "|" + kind)
+		for i, l := range labels {
+			if i == 0 {
+				b.WriteString("|#")
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.GetName() + ":" + statsdDatadogValue.Replace(l.GetValue()))
+		}
+	case "influx":
+		b.WriteString(statsdName.Replace(name))
+		for _, l := range labels {
+			b.WriteString("," + l.GetName() + "=" + statsdInfluxValue.Replace(l.GetValue()))
+		}
+		b.WriteString(":" + v + "|" + kind)
+	default:
+		metric := model.Metric{model.MetricNameLabel: model.LabelValue(name)}
+		for _, l := range labels {
+			metric[model.LabelName(l.GetName())] = model.LabelValue(l.GetValue())
+		}
+		var path bytes.Buffer
+		w := bufio.NewWriter(&path)
+		writeGraphitePath(w, metric)
+		w.Flush()
+		b.WriteString(statsdName.Replace(path.String()))
+		b.WriteString(":" + v + "|" + kind)
+	}
+	return b.String()
+}
+
+// writeGraphitePath writes the Graphite path of m the way the graphite
+// bridge of client_golang builds them, which it doesn't export: the name,
+// then each label name and value in order.
+func writeGraphitePath(buf *bufio.Writer, m model.Metric) {
+	var labels []string
+	for name, value := range m {
+		if name != model.MetricNameLabel {
+			labels = append(labels, string(name)+" "+string(value))
+		}
+	}
+	sort.Strings(labels)
+	writeGraphiteSanitized(buf, string(m[model.MetricNameLabel]))
+	for _, l := range labels {
+		buf.WriteByte('.')
+		writeGraphiteSanitized(buf, l)
+	}
+}
+
+// writeGraphiteSanitized writes s with spaces, which separate label names
+// from values, turned into dots and runs of other characters not allowed
+// in a path into a single _.
+func writeGraphiteSanitized(buf *bufio.Writer, s string) {
+	underscore := false
+	for _, c := range s {
+		switch {
+		case c == ' ':
+			c = '.'
+		case !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':' || c == '-'):
+			c = '_'
+		}
+		if c == '_' && underscore {
+			continue
+		}
+		underscore = c == '_'
+		buf.WriteRune(c)
+	}
+}
+
+// statsdPackets packs lines into as few packets of up to max bytes as
+// they fit in, in order, separated by newlines. A line longer than max is
+// sent in a packet of its own rather than dropped.
+func statsdPackets(lines []string, max int) [][]byte {
+	var packets [][]byte
+	var p []byte
+	for _, l := range lines {
+		if len(p) > 0 && len(p)+1+len(l) > max {
+			packets = append(packets, p)
+			p = nil
+		}
+		if len(p) > 0 {
+			p = append(p, '\n')
+		}
+		p = append(p, l...)
+	}
+	if len(p) > 0 {
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+// runStatsd sends the metrics of the current targets of s through e right
+// away and then every interval, until done is closed. Failures are logged
+// and counted, and the next interval tries again.
+func runStatsd(e *statsdEmitter, s *targetSet, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.send(pushGatherer(s)); err != nil {
+			statsdFailures.Inc()
+			logger.Error("Error sending metrics to StatsD", "address", e.address, "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
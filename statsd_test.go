@@ -0,0 +1,192 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdFamilies gathers a gauge and a counter with labels, accesses
+// accesses so far, and a summary StatsD gets none of.
+func statsdFamilies(t *testing.T, accesses float64) []*dto.MetricFamily {
+	reg := prometheus.NewRegistry()
+	workers := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "apache_workers", Help: "h"}, []string{"state", "target"})
+	workers.WithLabelValues("busy", "web01:80,web02").Set(3)
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "apache_accesses_total", Help: "h"}, []string{"target"})
+	counter.WithLabelValues("web01").Add(accesses)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "apache_duration_seconds", Help: "h"})
+	summary.Observe(1)
+	reg.MustRegister(workers, counter, summary)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+func TestStatsdLines(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		want   []string // At the second send.
+	}{
+		{"datadog", []string{
+			"apache.apache_accesses_total:5|c|#target:web01",
+			"apache.apache_workers:3|g|#state:busy,target:web01:80_web02",
+		}},
+		{"influx", []string{
+			"apache.apache_accesses_total,target=web01:5|c",
+			"apache.apache_workers,state=busy,target=web01_80_web02:3|g",
+		}},
+		{"none", []string{
+			"apache.apache_accesses_total.target.web01:5|c",
+			"apache.apache_workers.state.busy.target.web01_80_web02:3|g",
+		}},
+	} {
+		e := newStatsdEmitter("udp", "", "apache.", tc.format, 1432)
+		// Counters have nothing to count the increase from at first.
+		if got := e.lines(statsdFamilies(t, 10)); len(got) != 1 || !strings.Contains(got[0], "apache_workers") {
+			t.Errorf("%s: expected only the gauge at first, got %v", tc.format, got)
+		}
+		if got := e.lines(statsdFamilies(t, 15)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected\n%v\ngot\n%v", tc.format, tc.want, got)
+		}
+	}
+
+	// A counter that went down, as apache restarted, counts from 0.
+	e := newStatsdEmitter("udp", "", "", "datadog", 1432)
+	e.lines(statsdFamilies(t, 10))
+	if got := e.lines(statsdFamilies(t, 4)); got[0] != "apache_accesses_total:4|c|#target:web01" {
+		t.Errorf("expected the count since the reset, got %v", got)
+	}
+}
+
+func TestStatsdPackets(t *testing.T) {
+	lines := []string{strings.Repeat("a", 30), strings.Repeat("b", 30), strings.Repeat("c", 80), "d", "e"}
+	got := statsdPackets(lines, 64)
+	want := []string{
+		strings.Repeat("a", 30) + "\n" + strings.Repeat("b", 30),
+		// Too long for any packet: sent as is.
+		strings.Repeat("c", 80),
+		"d\ne",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d packets, got %q", len(want), got)
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Errorf("packet %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+	if got := statsdPackets(nil, 64); len(got) != 0 {
+		t.Errorf("expected no packets, got %q", got)
+	}
+}
+
+// manyGauges gathers n gauges, each with a target label.
+func manyGauges(n int) prometheus.Gatherer {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "apache_workers", Help: "h"}, []string{"target"})
+	for i := 0; i < n; i++ {
+		g.WithLabelValues(fmt.Sprintf("web%03d", i)).Set(float64(i))
+	}
+	reg.MustRegister(g)
+	return reg
+}
+
+func TestStatsdUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	e := newStatsdEmitter("udp", conn.LocalAddr().String(), "", "datadog", 200)
+	if err := e.send(manyGauges(50)); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) < 50 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("after %d lines: %v", len(got), err)
+		}
+		if n > 200 {
+			t.Errorf("expected packets of up to 200 bytes, got %d", n)
+		}
+		got = append(got, strings.Split(string(buf[:n]), "\n")...)
+	}
+	var want []string
+	for i := 0; i < 50; i++ {
+		want = append(want, fmt.Sprintf("apache_workers:%d|g|#target:web%03d", i, i))
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestStatsdTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	e := newStatsdEmitter("tcp", l.Addr().String(), "", "influx", 64)
+	if err := e.send(manyGauges(3)); err != nil {
+		t.Fatal(err)
+	}
+	want := "apache_workers,target=web000:0|g\napache_workers,target=web001:1|g\napache_workers,target=web002:2|g\n"
+	if got := <-received; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestValidateStatsd(t *testing.T) {
+	if err := validateStatsd("", "bogus", "bogus", 0, 0); err != nil {
+		t.Errorf("expected the flags to be ignored without an address, got %v", err)
+	}
+	if err := validateStatsd("localhost:8125", "udp", "datadog", time.Minute, 1432); err != nil {
+		t.Error(err)
+	}
+	for _, tc := range []struct {
+		address, protocol, format string
+		interval                  time.Duration
+		maxPacket                 int
+	}{
+		{"localhost", "udp", "datadog", time.Minute, 1432},
+		{"localhost:8125", "unix", "datadog", time.Minute, 1432},
+		{"localhost:8125", "udp", "graphite", time.Minute, 1432},
+		{"localhost:8125", "udp", "datadog", 0, 1432},
+		{"localhost:8125", "udp", "datadog", time.Minute, 10},
+	} {
+		if err := validateStatsd(tc.address, tc.protocol, tc.format, tc.interval, tc.maxPacket); err == nil {
+			t.Errorf("%+v: expected an error", tc)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var exportUnknownFields = flag.Bool("status.export-unknown-fields", false, "Export the numeric fields of the status page the exporter has no metric of, such as those of newer apache versions or third-party MPMs, as apache_status_field{field=...}.")
+
+// newStatusFieldDesc describes apache_status_field.
+func newStatusFieldDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("status_field", "Numeric field of the status page with no metric of its own, by its name lowercased with other characters than letters and digits turned into _.", []string{"field"}, labels)
+}
+
+// statusFieldName normalizes the key of a field of the status page into
+// the field label of apache_status_field: lowercased, with other
+// characters than letters and digits turned into _, as in
+// busyworkers_graceful for "BusyWorkers Graceful".
+func statusFieldName(key string) string {
+	return strings.Trim(strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c - 'A' + 'a'
+		}
+		return '_'
+	}, key), "_")
+}
+
+// parseUnknownField takes in a field of the status page with no metric of
+// its own into fields, by its normalized name, if its value is a number.
+// The first of fields whose names normalize the same wins.
+func parseUnknownField(fields map[string]float64, key, value string) {
+	name := statusFieldName(key)
+	if name == "" {
+		return
+	}
+	if _, ok := fields[name]; ok {
+		return
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	fields[name] = v
+}
+
+var fieldPresence = flag.Bool("status.field-presence", true, "Export whether the status page shows each of a list of notable fields, such as Total Duration or ConnsAsyncKeepAlive, as apache_exporter_field_present{field=...}, for auditing which servers run with which status features.")
+
+// presenceFields are the fields of the status page whose presence
+// apache_exporter_field_present tells: those that come and go with the
+// version, MPM and ExtendedStatus of apache.
+var presenceFields = []string{
+	"ServerMPM",
+	"Load1",
+	"CPULoad",
+	"Total Accesses",
+	"Total kBytes",
+	"Total Duration",
+	"Uptime",
+	"ReqPerSec",
+	"BusyWorkers",
+	"IdleWorkers",
+	"ConnsTotal",
+	"ConnsAsyncKeepAlive",
+	"Scoreboard",
+}
+
+// presenceIndex is the index of each of presenceFields.
+var presenceIndex = func() map[string]uint {
+	index := map[string]uint{}
+	for i, f := range presenceFields {
+		index[f] = uint(i)
+	}
+	return index
+}()
+
+// fieldSet is a set of presenceFields, by their index.
+type fieldSet uint64
+
+// add adds the field key to s, if it is one of presenceFields.
+func (s *fieldSet) add(key string) {
+	if i, ok := presenceIndex[key]; ok {
+		*s |= 1 << i
+	}
+}
+
+func (s fieldSet) has(key string) bool {
+	i, ok := presenceIndex[key]
+	return ok && s&(1<<i) != 0
+}
+
+// newFieldPresentDesc describes apache_exporter_field_present.
+func newFieldPresentDesc(labels prometheus.Labels) *prometheus.Desc {
+	return newDesc("exporter_field_present", "Whether the status page shows the field, by its name as in apache_status_field.", []string{"field"}, labels)
+}
+
+// collect sends whether s has each of presenceFields to ch.
+func (s fieldSet) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	for _, f := range presenceFields {
+		present := 0.0
+		if s.has(f) {
+			present = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, present, statusFieldName(f))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatusFieldName(t *testing.T) {
+	for key, want := range map[string]string{
+		"BusyWorkers Graceful":   "busyworkers_graceful",
+		"HyperMPM-Fibers":        "hypermpm_fibers",
+		"Load1":                  "load1",
+		" Cache: hits ":          "cache__hits",
+		"Frobnicated.Requests/s": "frobnicated_requests_s",
+		"__":                     "",
+	} {
+		if got := statusFieldName(key); got != want {
+			t.Errorf("%q: expected %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestExportUnknownFields(t *testing.T) {
+	defer func(old bool) { *exportUnknownFields = old }(*exportUnknownFields)
+	status, err := ioutil.ReadFile(filepath.Join("testdata", "fields", "future.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(status)
+	}))
+	defer ts.Close()
+	get := func() string {
+		rr := httptest.NewRecorder()
+		metricsHandler(newTargetSet(Exporters{NewExporter(ts.URL)}, nil)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+
+	if body := get(); strings.Contains(body, "apache_status_field{") {
+		t.Errorf("expected no unknown fields without -status.export-unknown-fields in\n%s", body)
+	}
+
+	*exportUnknownFields = true
+	body := get()
+	var fields []string
+	for _, l := range strings.Split(body, "\n") {
+		if strings.HasPrefix(l, "apache_status_field{") {
+			fields = append(fields, l)
+		}
+	}
+	want := []string{
+		`apache_status_field{field="busyworkers_graceful"} 1`,
+		`apache_status_field{field="cpuload"} 0.000415512`,
+		// The first of the fields named alike wins.
+		`apache_status_field{field="hypermpm_fibers"} 512`,
+		`apache_status_field{field="load1"} 3.23`,
+		`apache_status_field{field="parentserverconfiggeneration"} 3`,
+		`apache_status_field{field="reqpersec"} 6.38407e-05`,
+		`apache_status_field{field="serveruptimeseconds"} 7220`,
+	}
+	if strings.Join(fields, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected the unknown fields\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(fields, "\n"))
+	}
+	// Known fields keep their metrics only.
+	for _, s := range []string{"apache_accesses_total 120", `apache_workers{state="busy"} 2`, "apache_uptime_seconds_total 7220"} {
+		if !strings.Contains(body, "\n"+s+"\n") {
+			t.Errorf("expected %s in\n%s", s, body)
+		}
+	}
+}
+
+func TestFieldPresence(t *testing.T) {
+	defer func(old bool) { *fieldPresence = old }(*fieldPresence)
+	*fieldPresence = true
+	all := strings.Split("servermpm load1 cpuload total_accesses total_kbytes total_duration uptime reqpersec busyworkers idleworkers connstotal connsasynckeepalive scoreboard", " ")
+	absent := map[string][]string{
+		"huge-vhosts":              nil,
+		"event-2.4":                {"total_duration", "connstotal", "connsasynckeepalive"},
+		"extended-off":             {"cpuload", "total_accesses", "total_kbytes", "total_duration", "uptime", "reqpersec"},
+		"winnt-2.4":                {"load1", "cpuload", "total_duration", "connstotal", "connsasynckeepalive"},
+		"prefork-2.2":              {"servermpm", "load1", "total_duration", "connstotal", "connsasynckeepalive"},
+		"prefork-2.2-extended-off": {"servermpm", "load1", "cpuload", "total_accesses", "total_kbytes", "total_duration", "uptime", "reqpersec", "connstotal", "connsasynckeepalive"},
+	}
+	for profile, missing := range absent {
+		exposition, err := fixtureExposition(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]bool{}
+		for _, f := range missing {
+			out[f] = true
+		}
+		for _, f := range all {
+			want := `apache_exporter_field_present{field="` + f + `"} 1`
+			if out[f] {
+				want = `apache_exporter_field_present{field="` + f + `"} 0`
+			}
+			if !strings.Contains(string(exposition), "\n"+want+"\n") {
+				t.Errorf("%s: expected %s in\n%s", profile, want, exposition)
+			}
+		}
+	}
+
+	*fieldPresence = false
+	exposition, err := fixtureExposition("huge-vhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(exposition), "apache_exporter_field_present") {
+		t.Errorf("expected no field presence without -status.field-presence in\n%s", exposition)
+	}
+}
+
+func TestFieldSet(t *testing.T) {
+	var s fieldSet
+	for _, key := range []string{"Total Duration", "Scoreboard", "ServerVersion", ""} {
+		s.add(key)
+	}
+	for key, want := range map[string]bool{"Total Duration": true, "Scoreboard": true, "ServerVersion": false, "Uptime": false} {
+		if s.has(key) != want {
+			t.Errorf("%s: expected %v, got %v", key, want, !want)
+		}
+	}
+	if len(presenceFields) > 64 {
+		t.Errorf("expected at most 64 presence fields for a fieldSet, got %d", len(presenceFields))
+	}
+}
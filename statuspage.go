@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yosefy/apache_exporter/version"
+)
+
+var statusRefresh = flag.Duration("web.status-refresh", 30*time.Second, "How often /status reloads itself in the browser. 0 to not reload.")
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string { return time.Since(t).Truncate(time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{- if .Refresh}}
+<meta http-equiv="refresh" content="{{.Refresh}}">
+{{- end}}
+<title>Apache Exporter status</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: .3em .6em; text-align: left; }
+td.num { text-align: right; }
+.up { background: #dfd; }
+.down { background: #fdd; }
+.unknown { background: #eee; }
+</style>
+</head>
+<body>
+<h1>Apache Exporter status</h1>
+<p>Version {{.Version}}. <a href="{{.MetricsPath}}">Metrics</a>,
+<a href="{{.APIPath}}">as JSON</a>.</p>
+<table>
+<tr><th>Target</th><th>URI</th><th>State</th><th>Last scrape</th><th>Duration</th><th>Busy</th><th>Idle</th><th>Uptime</th><th>Last error</th></tr>
+{{- range .Targets}}
+<tr class="{{.Health}}">
+<td>{{.Name}}</td><td>{{.URI}}</td><td>{{.Health}}</td>
+{{- if .LastScrape}}
+<td>{{ago .LastScrape}} ago</td><td class="num">{{printf "%.3fs" .LastScrapeDuration}}</td>
+{{- else}}
+<td>never</td><td></td>
+{{- end}}
+{{- with .Status}}
+<td class="num">{{.BusyWorkers}}</td><td class="num">{{.IdleWorkers}}</td><td class="num">{{.UptimeSeconds}}s</td>
+{{- else}}
+<td></td><td></td><td></td>
+{{- end}}
+<td>{{.LastError}}</td>
+</tr>
+{{- else}}
+<tr><td colspan="9">No targets.</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// statusPageHandler serves the outcome of the last scrapes of the targets
+// of s, and what their status pages said, as a page for people, reloading
+// itself every refresh. It shows what /api/v1/status serves, and like the
+// landing page links to metricsPath relatively unless under external.
+func statusPageHandler(s *targetSet, metricsPath string, external *url.URL, refresh time.Duration) http.Handler {
+	metricsURL := "./" + strings.TrimPrefix(metricsPath, "/")
+	apiURL := "./api/v1/status"
+	if external != nil {
+		metricsURL = external.String() + metricsPath
+		apiURL = external.String() + "/api/v1/status"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var targets []targetServerStatus
+		for _, e := range s.current() {
+			targets = append(targets, e.serverStatus())
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusTemplate.Execute(w, struct {
+			Version     string
+			MetricsPath string
+			APIPath     string
+			Refresh     int
+			Targets     []targetServerStatus
+		}{version.Version, metricsURL, apiURL, int(refresh.Seconds()), targets})
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yosefy/apache_exporter/version"
+)
+
+func TestStatusPage(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<b>down</b>", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	es, err := setupExporters([]string{"web01=http://monitor:s3cret@" + strings.TrimPrefix(healthy.URL, "http://"), "web02=" + failing.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, nil)
+	get := func(h http.Handler) string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/status", nil))
+		if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("expected HTML, got %s", ct)
+		}
+		return rr.Body.String()
+	}
+
+	body := get(statusPageHandler(s, "/metrics", nil, 30*time.Second))
+	for _, want := range []string{`<meta http-equiv="refresh" content="30">`, `<tr class="unknown">`, "<td>never</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q before the first scrape in\n%s", want, body)
+		}
+	}
+
+	targetValues(t, es, "apache_up")
+	body = get(statusPageHandler(s, "/metrics", nil, 30*time.Second))
+	for _, want := range []string{
+		`<a href="./metrics">`, `<a href="./api/v1/status">`, "Version " + version.Version,
+		// The healthy target's workers and uptime.
+		`<tr class="up">`, "<td>web01</td>", `<td class="num">1</td><td class="num">4</td><td class="num">15664s</td>`,
+		// The failing one's error, escaped.
+		`<tr class="down">`, "<td>web02</td>", "(503): &lt;b&gt;down&lt;/b&gt;", " ago</td>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in\n%s", want, body)
+		}
+	}
+	for _, unwanted := range []string{"s3cret", "monitor", "<b>", "<link", "<script", "src="} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("unexpected %q in\n%s", unwanted, body)
+		}
+	}
+
+	external, _ := url.Parse("https://example.com/apache")
+	body = get(statusPageHandler(s, "/metrics", external, 0))
+	if strings.Contains(body, "http-equiv") || !strings.Contains(body, `href="https://example.com/apache/metrics"`) {
+		t.Errorf("expected no reload and absolute links, got\n%s", body)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	scrapeURIs          = &targetsFlag{values: []string{"http://localhost/server-status/?auto"}}
+	maxConcurrency      = flag.Int("scrape.max-concurrency", 10, "Maximum number of targets scraped at the same time.")
+	targetsFile         = flag.String("targets.file", "", "YAML or JSON file with a list of targets to scrape, in addition to -config.file or -scrape.uri. Reloaded when it changes.")
+	targetsPollInterval = flag.Duration("targets.poll-interval", 5*time.Second, "How often to check -targets.file for changes fsnotify missed.")
+
+	targetsFileLoaded prometheus.Gauge
+)
+
+func init() {
+	flag.Var(scrapeURIs, "scrape.uri", "URI to apache stub status page, optionally as name=uri. May be repeated or comma separated to scrape several targets.")
+	selfMetric(func() {
+		targetsFileLoaded = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_targets_file_last_load_success_timestamp_seconds",
+			Help:        "Timestamp of the last successful load of -targets.file.",
+			ConstLabels: withConstLabels(nil),
+		})
+		unfinishedDesc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "scrape_targets_unfinished"),
+			"Number of targets whose scrape didn't finish before the collection was cut short, by a deadline or the client going away.",
+			nil, withConstLabels(nil),
+		)
+		groupTargetsDesc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "group", "targets"),
+			"Number of targets in the group.",
+			[]string{"group"}, withConstLabels(nil),
+		)
+		groupTargetsUpDesc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "group", "targets_up"),
+			"Number of targets in the group whose last scrape was successful.",
+			[]string{"group"}, withConstLabels(nil),
+		)
+	})
+}
+
+// targetsFlag collects repeated or comma separated values, such as those
+// of -scrape.uri, replacing the default on first use.
+type targetsFlag struct {
+	values []string
+	set    bool
+}
+
+func (f *targetsFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *targetsFlag) Set(value string) error {
+	if !f.set {
+		f.values = nil
+		f.set = true
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			f.values = append(f.values, v)
+		}
+	}
+	return nil
+}
+
+var targetNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// target is a scrape target given on the command line or in the config
+// file, along with its settings from the latter.
+type target struct {
+	name, uri string
+	conf      config.Target
+}
+
+// parseTarget splits a "name=uri" argument. Anything that doesn't start
+// with a plain name followed by "=" is taken to be just a URI.
+func parseTarget(s string) target {
+	if i := strings.Index(s, "="); i > 0 && targetNameRE.MatchString(s[:i]) {
+		return target{name: s[:i], uri: s[i+1:]}
+	}
+	return target{uri: s}
+}
+
+// label is the value of the target label for t.
+func (t target) label() string {
+	if t.name != "" {
+		return t.name
+	}
+	return sanitizeURI(t.uri)
+}
+
+var unfinishedDesc *prometheus.Desc
+
+// defaultGroup is the group of targets that don't set one.
+const defaultGroup = "default"
+
+var groupTargetsDesc, groupTargetsUpDesc *prometheus.Desc
+
+// Exporters scrapes several apache targets as a single collector.
+type Exporters []*Exporter
+
+func (es Exporters) Describe(ch chan<- *prometheus.Desc) {
+	for _, e := range es {
+		e.Describe(ch)
+	}
+	ch <- unfinishedDesc
+	ch <- groupTargetsDesc
+	ch <- groupTargetsUpDesc
+}
+
+// collectGroups sends the number of targets and of targets up per group,
+// if the targets are grouped.
+func (es Exporters) collectGroups(ch chan<- prometheus.Metric) {
+	targets, up := map[string]float64{}, map[string]float64{}
+	for _, e := range es {
+		group, ok := e.labels["group"]
+		if !ok {
+			return
+		}
+		targets[group]++
+		if e.last.up() {
+			up[group]++
+		}
+	}
+	for group, n := range targets {
+		ch <- prometheus.MustNewConstMetric(groupTargetsDesc, prometheus.GaugeValue, n, group)
+		ch <- prometheus.MustNewConstMetric(groupTargetsUpDesc, prometheus.GaugeValue, up[group], group)
+	}
+}
+
+func (es Exporters) Collect(ch chan<- prometheus.Metric) {
+	es.collectContext(context.Background(), ch)
+}
+
+// collectContext scrapes up to -scrape.max-concurrency targets at a time.
+// Targets not started by the time ctx is done are skipped.
+func (es Exporters) collectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	ctx, span := startSpan(ctx, "collect")
+	span.setAttribute("apache.targets", strconv.Itoa(len(es)))
+	defer span.end(nil)
+	es.collectTargets(ctx, ch)
+	es.collectGroups(ch)
+}
+
+// collectTargets is collectContext without the metrics of the groups.
+func (es Exporters) collectTargets(ctx context.Context, ch chan<- prometheus.Metric) {
+	limit := make(chan struct{}, *maxConcurrency)
+	var unfinished int32
+	var wg sync.WaitGroup
+	for _, e := range es {
+		wg.Add(1)
+		go func(e *Exporter) {
+			defer wg.Done()
+			select {
+			case limit <- struct{}{}:
+				defer func() { <-limit }()
+			case <-ctx.Done():
+				atomic.AddInt32(&unfinished, 1)
+				return
+			}
+			defer func() {
+				// Keep a bug in one scrape from taking the others down.
+				if r := recover(); r != nil {
+					e.logger.Error("Panic scraping apache", "panic", r)
+				}
+			}()
+
+			if err := e.sharedCollect(ctx, ch); err != nil && ctx.Err() != nil {
+				atomic.AddInt32(&unfinished, 1)
+			}
+		}(e)
+	}
+	wg.Wait()
+	ch <- prometheus.MustNewConstMetric(unfinishedDesc, prometheus.GaugeValue, float64(unfinished))
+}
+
+func validateMaxConcurrency(n int) error {
+	if n < 1 {
+		return fmt.Errorf("-scrape.max-concurrency must be at least 1, got %d", n)
+	}
+	return nil
+}
+
+// probeModules holds the modules of the -config.file loaded last, as a
+// map[string]config.Module.
+var probeModules atomic.Value
+
+func currentModules() map[string]config.Module {
+	modules, _ := probeModules.Load().(map[string]config.Module)
+	return modules
+}
+
+// exportersFromFlags sets up the exporters for the targets in -config.file,
+// or those given by -scrape.uri without one, and those in -targets.file
+// or found by discoverers, keeping those in the shard of -sharding.index.
+func exportersFromFlags(failOnStartup bool) (Exporters, error) {
+	// With targets coming and going, there may be none at times.
+	dynamic := *targetsFile != "" || len(discoverers) > 0
+
+	var targets []target
+	var modules map[string]config.Module
+	var rules []config.MetricRule
+	var derived map[string]*config.Expr
+	switch {
+	case *configFile != "":
+		if scrapeURIs.set {
+			return nil, fmt.Errorf("-scrape.uri can't be used together with -config.file")
+		}
+		cfg, err := config.LoadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Loaded configuration", "file", *configFile, "config", cfg.String())
+		targets = targetsFromConfig(cfg.Targets)
+		modules = cfg.Modules
+		rules = cfg.MetricRules
+		derived = cfg.DerivedMetrics
+		// A config just defining modules only serves probes.
+		dynamic = dynamic || len(targets) == 0
+	case scrapeURIs.set || !dynamic:
+		for _, arg := range scrapeURIs.values {
+			targets = append(targets, parseTarget(arg))
+		}
+	}
+
+	if !dynamic {
+		es, err := newExporters(keepShard(targets, uriDefaultsFromFlags(), *shardsTotal, *shardIndex), uriDefaultsFromFlags(), failOnStartup)
+		if err == nil {
+			probeModules.Store(modules)
+			metricRules.Store(rules)
+			derivedMetrics.Store(derived)
+		}
+		return es, err
+	}
+	if *targetsFile != "" {
+		fileTargets, err := config.LoadTargetsFile(*targetsFile)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, targetsFromConfig(fileTargets)...)
+	}
+	for _, d := range discoverers {
+		targets = append(targets, d.targets()...)
+	}
+	targets = keepShard(targets, uriDefaultsFromFlags(), *shardsTotal, *shardIndex)
+	var es Exporters
+	if len(targets) > 0 {
+		var err error
+		if es, err = newExporters(targets, uriDefaultsFromFlags(), failOnStartup); err != nil {
+			return nil, err
+		}
+	}
+	if *targetsFile != "" {
+		targetsFileLoaded.Set(float64(time.Now().Unix()))
+	}
+	probeModules.Store(modules)
+	metricRules.Store(rules)
+	derivedMetrics.Store(derived)
+	return es, nil
+}
+
+func targetsFromConfig(ts []config.Target) []target {
+	var targets []target
+	for _, t := range ts {
+		targets = append(targets, target{name: t.Name, uri: t.URI, conf: t})
+	}
+	return targets
+}
+
+// setupExporters creates the exporters for the command line arguments args.
+func setupExporters(args []string, d uriDefaults, failOnStartup bool) (Exporters, error) {
+	var targets []target
+	for _, arg := range args {
+		targets = append(targets, parseTarget(arg))
+	}
+	return newExporters(targets, d, failOnStartup)
+}
+
+// newExporters validates targets and creates an exporter for each. With
+// more than one target, every metric is labeled with the target it came
+// from so values can't mix. With failOnStartup, an initial scrape of
+// every target has to succeed.
+func newExporters(targets []target, d uriDefaults, failOnStartup bool) (Exporters, error) {
+	seen := map[string]bool{}
+	labelNames := map[string]bool{}
+	grouped := false
+	for i := range targets {
+		t := &targets[i]
+		t.uri = completeURI(t.uri, d)
+		if err := validateScrapeURI(t.uri); err != nil {
+			return nil, err
+		}
+		var fallbacks []string
+		for _, uri := range t.conf.FallbackURIs {
+			uri = completeURI(uri, d)
+			if err := validateScrapeURI(uri); err != nil {
+				return nil, fmt.Errorf("target %s: fallback_uris: %v", t.label(), err)
+			}
+			fallbacks = append(fallbacks, uri)
+		}
+		t.conf.FallbackURIs = fallbacks
+		if seen[t.label()] {
+			return nil, fmt.Errorf("duplicate scrape target %q", t.label())
+		}
+		seen[t.label()] = true
+		for name := range t.conf.Labels {
+			if _, ok := metricsConstLabels[name]; ok {
+				return nil, fmt.Errorf("target %s: label %s is set by -metrics.const-label too", t.label(), name)
+			}
+			labelNames[name] = true
+		}
+		grouped = grouped || t.conf.Group != ""
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no scrape targets given")
+	}
+
+	var es Exporters
+	for _, t := range targets {
+		var labels prometheus.Labels
+		if len(targets) > 1 || len(labelNames) > 0 || grouped {
+			labels = prometheus.Labels{}
+			// Targets without one of the static labels get it empty,
+			// a metric's label names have to match across targets.
+			for name := range labelNames {
+				labels[name] = t.conf.Labels[name]
+			}
+			if len(targets) > 1 {
+				labels["target"] = t.label()
+			}
+			if grouped {
+				labels["group"] = t.conf.Group
+				if t.conf.Group == "" {
+					labels["group"] = defaultGroup
+				}
+			}
+		}
+		e := newExporter(t.uri, labels)
+		e.name = t.label()
+		e.logger = logger.With("target", e.name)
+		e.fallbackURIs = t.conf.FallbackURIs
+		if err := e.configure(t.conf); err != nil {
+			return nil, fmt.Errorf("target %s: %v", t.label(), err)
+		}
+		if failOnStartup {
+			if err := e.Warmup(); err != nil {
+				return nil, fmt.Errorf("initial scrape of %s failed: %v", t.label(), err)
+			}
+		}
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+// configure applies the settings a target has in the config file.
+func (e *Exporter) configure(t config.Target) error {
+	if e.user != nil && (t.BasicAuth != nil || t.BearerToken != "" || t.BearerTokenFile != "" || t.FormAuth != nil) {
+		return fmt.Errorf("credentials given both in the uri and in the config file")
+	}
+	client, err := tlsClient(t.HTTPConfig, e.policy)
+	if err != nil {
+		return err
+	}
+	if client != nil {
+		e.client = client
+	}
+	if t.FormAuth != nil {
+		if e.formLogin, e.client, err = newFormLogin(*t.FormAuth, e.URI, e.client); err != nil {
+			return err
+		}
+		if _, err := t.FormAuth.ReadPassword(); err != nil {
+			return err
+		}
+	}
+	e.conf = t
+	// Fail on unreadable credential files now rather than on every scrape.
+	return e.authorize(&http.Request{Header: http.Header{}})
+}
+
+// tlsClient returns a client with the TLS settings and source address of t
+// and policy, nil if it has neither and the client set up by the flags
+// will do.
+func tlsClient(t config.HTTPConfig, policy *targetPolicy) (*http.Client, error) {
+	if t.TLSConfig == nil && t.SourceAddress == "" {
+		return nil, nil
+	}
+	tc, err := t.TLSConfig.ClientConfig(*insecure)
+	if err != nil {
+		return nil, err
+	}
+	cfg := clientConfigFromFlags()
+	cfg.tls = tc
+	cfg.policy = policy
+	if t.SourceAddress != "" {
+		if cfg.source, err = parseSourceAddress(t.SourceAddress); err != nil {
+			return nil, err
+		}
+	}
+	return newHTTPClient(cfg), nil
+}
+
+// authorize adds the target's credentials to req, reading credential files
+// each time so rotated secrets are picked up.
+func (e *Exporter) authorize(req *http.Request) error {
+	return authorize(req, e.user, e.conf.HTTPConfig)
+}
+
+// authorize adds the credentials of user, taken from a URI, or else those
+// of t to req.
+func authorize(req *http.Request, user *url.Userinfo, t config.HTTPConfig) error {
+	switch {
+	case user != nil:
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	case t.BasicAuth != nil:
+		password, err := t.BasicAuth.ReadPassword()
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(t.BasicAuth.Username, password)
+	default:
+		token, err := t.ReadBearerToken()
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return nil
+}
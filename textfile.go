@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	textfileDirectory = flag.String("textfile.directory", "", "Directory of the node_exporter textfile collector to write the targets' metrics to, as apache.prom, every -textfile.interval.")
+	textfileInterval  = flag.Duration("textfile.interval", time.Minute, "How often to scrape the targets and write -textfile.directory.")
+	textfileOnFailure = flag.String("textfile.on-failure", "up", "What to write to -textfile.directory when every target failed to scrape: up writes only apache_up, remove removes the file.")
+	textfileOnly      = flag.Bool("textfile.only", false, "Only write -textfile.directory, without serving HTTP.")
+)
+
+// textfileName is the file written to -textfile.directory.
+const textfileName = "apache.prom"
+
+func validateTextfile(dir, onFailure string, only bool) error {
+	if onFailure != "up" && onFailure != "remove" {
+		return fmt.Errorf("-textfile.on-failure must be up or remove, got %q", onFailure)
+	}
+	if only && dir == "" {
+		return fmt.Errorf("-textfile.only requires -textfile.directory")
+	}
+	return nil
+}
+
+// writeTextfile scrapes es and replaces the textfile in dir with their
+// metrics. When all targets failed, the file only holds apache_up, or is
+// removed if remove is set, so that node_exporter doesn't go on serving
+// numbers from before.
+func writeTextfile(dir string, es Exporters, remove bool) error {
+	path := filepath.Join(dir, textfileName)
+	mfs, failed, err := gatherOnce(es)
+	if err != nil {
+		return err
+	}
+	if len(failed) == len(es) {
+		if remove {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		var up []*dto.MetricFamily
+		for _, mf := range mfs {
+			if mf.GetName() == namespace+"_up" {
+				up = append(up, mf)
+			}
+		}
+		mfs = up
+	}
+	var buf bytes.Buffer
+	if err := writeText(&buf, mfs); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// writeFileAtomic replaces the file at path with data by renaming a file
+// written next to it, so that readers never see it half written. The
+// temporary file doesn't end in .prom, which node_exporter would read.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // Gone after the rename unless it failed.
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// runTextfile writes the textfile for the current targets of s right away
+// and then every interval, until done is closed.
+func runTextfile(dir string, s *targetSet, interval time.Duration, remove bool, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := writeTextfile(dir, s.current(), remove); err != nil {
+			logger.Error("Error writing the textfile", "file", filepath.Join(dir, textfileName), "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
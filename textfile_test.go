@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, textfileName)
+
+	var down int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	es := Exporters{NewExporter(backend.URL)}
+
+	if err := writeTextfile(dir, es, false); err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	// Replaced by a rename, so readers of the old file aren't cut short.
+	atomic.StoreInt32(&down, 1)
+	if err := writeTextfile(dir, es, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(old); !strings.Contains(string(data), "apache_workers") {
+		t.Errorf("expected the old file to stay whole for its reader, got\n%s", data)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# HELP apache_up Whether the last scrape of apache was successful.\n# TYPE apache_up gauge\napache_up 0\n"; string(data) != want {
+		t.Errorf("expected only apache_up after a failed scrape, got\n%s", data)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("expected a world readable file, got %v, %v", fi.Mode(), err)
+	}
+
+	if err := writeTextfile(dir, es, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the file removed after a failed scrape, got %v", err)
+	}
+	if err := writeTextfile(dir, es, true); err != nil {
+		t.Errorf("expected no error removing the file again, got %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no temporary files left, got %v", files[0].Name())
+	}
+}
+
+func TestRunTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var requests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		runTextfile(dir, newTargetSet(Exporters{NewExporter(backend.URL)}, nil), 20*time.Millisecond, false, done)
+	}()
+	waitFor(t, "the textfile to be written a few times", func() bool { return atomic.LoadInt32(&requests) >= 3 })
+	close(done)
+	<-stopped
+	data, err := ioutil.ReadFile(filepath.Join(dir, textfileName))
+	if err != nil || !strings.Contains(string(data), "apache_up 1") {
+		t.Errorf("expected the metrics in the textfile, got %v\n%s", err, data)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"strings"
+
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	webTLSCertFile   = flag.String("web.tls.cert-file", "", "Certificate file to serve HTTPS with, together with -web.tls.key-file.")
+	webTLSKeyFile    = flag.String("web.tls.key-file", "", "Key file of -web.tls.cert-file.")
+	webTLSMinVersion = flag.String("web.tls.min-version", "TLS12", "Minimum TLS version served: TLS10, TLS11, TLS12 or TLS13.")
+	webTLSClientCA   = flag.String("web.tls.client-ca-file", "", "CA certificates that client certificates must be signed by. Requires -web.tls.cert-file.")
+	webTLSClientAuth = flag.String("web.tls.client-auth", "require", "With -web.tls.client-ca-file, whether clients must present a certificate (require) or only have it verified if they do (verify-if-given).")
+)
+
+// webTLSOptions are the flags webTLSConfig is built from.
+type webTLSOptions struct {
+	certFile, keyFile, minVersion string
+	clientCAFile, clientAuth      string
+}
+
+func webTLSOptionsFromFlags() webTLSOptions {
+	return webTLSOptions{
+		certFile:     *webTLSCertFile,
+		keyFile:      *webTLSKeyFile,
+		minVersion:   *webTLSMinVersion,
+		clientCAFile: *webTLSClientCA,
+		clientAuth:   *webTLSClientAuth,
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"require":         tls.RequireAndVerifyClientCert,
+	"verify-if-given": tls.VerifyClientCertIfGiven,
+}
+
+// webTLSConfig returns the TLS configuration the server listens with, nil
+// for plain HTTP. The certificate is loaded right away so that a bad one
+// fails startup, and again whenever its files change.
+func webTLSConfig(o webTLSOptions) (*tls.Config, error) {
+	if o.certFile == "" && o.keyFile == "" {
+		if o.clientCAFile != "" {
+			return nil, fmt.Errorf("-web.tls.client-ca-file requires -web.tls.cert-file and -web.tls.key-file")
+		}
+		return nil, nil
+	}
+	if o.certFile == "" || o.keyFile == "" {
+		return nil, fmt.Errorf("-web.tls.cert-file and -web.tls.key-file must be given together")
+	}
+	version, ok := tlsVersions[o.minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown -web.tls.min-version %q, valid are TLS10, TLS11, TLS12 and TLS13", o.minVersion)
+	}
+	cert, err := newKeyPair(o.certFile, o.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the web certificate: %v", err)
+	}
+	tc := &tls.Config{GetCertificate: cert.getCertificate, MinVersion: version}
+
+	if o.clientCAFile == "" {
+		return tc, nil
+	}
+	if tc.ClientAuth, ok = clientAuthTypes[o.clientAuth]; !ok {
+		return nil, fmt.Errorf("unknown -web.tls.client-auth %q, valid are require and verify-if-given", o.clientAuth)
+	}
+	pem, err := config.ReadFile(o.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading -web.tls.client-ca-file: %v", err)
+	}
+	tc.ClientCAs = x509.NewCertPool()
+	if !tc.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", o.clientCAFile)
+	}
+	return tc, nil
+}
+
+// serverErrorLog is where the HTTP server logs its errors. Failed TLS
+// handshakes are only logged at debug level, as scanners would flood the
+// log with them.
+var serverErrorLog = stdlog.New(serverErrorWriter{}, "", 0)
+
+type serverErrorWriter struct{}
+
+func (serverErrorWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if strings.Contains(msg, "TLS handshake error") {
+		logger.Debug(msg)
+	} else {
+		logger.Error(msg)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key made up for a test.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert makes a certificate for 127.0.0.1 signed by parent, or
+// self-signed if parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer := &testCert{template, key}
+	if parent != nil {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key}
+}
+
+// write writes the certificate and key to dir, returning their paths.
+func (c *testCert) write(t *testing.T, dir string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := c.cert.Subject.CommonName
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// serveTLS serves ok over HTTPS with tc until the test is done.
+func serveTLS(t *testing.T, tc *tls.Config) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+		TLSConfig: tc,
+		ErrorLog:  serverErrorLog,
+	}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+	return "https://" + ln.Addr().String() + "/metrics"
+}
+
+// trusting returns a TLS config trusting cert alone.
+func trusting(cert *testCert) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.cert)
+	return &tls.Config{RootCAs: pool}
+}
+
+// getTLS requests url trusting server, with client's certificate if set.
+func getTLS(t *testing.T, url string, server, client *testCert, maxVersion uint16) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(server.cert)
+	tc := &tls.Config{RootCAs: roots, MaxVersion: maxVersion}
+	if client != nil {
+		// Send it even if not signed by a CA the server asks for.
+		tc.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert := client.tlsCertificate()
+			return &cert, nil
+		}
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: tc}}
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "ok" {
+		t.Errorf("expected ok, got %q", body)
+	}
+	return nil
+}
+
+func TestWebTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestCert(t, "server", nil, false)
+	certFile, keyFile := server.write(t, dir)
+
+	tc, err := webTLSConfig(webTLSOptions{certFile: certFile, keyFile: keyFile, minVersion: "TLS13"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serveTLS(t, tc)
+	if err := getTLS(t, url, server, nil, 0); err != nil {
+		t.Errorf("HTTPS request failed: %v", err)
+	}
+	if err := getTLS(t, url, server, nil, tls.VersionTLS12); err == nil {
+		t.Error("expected a TLS 1.2 client to be refused with -web.tls.min-version TLS13")
+	}
+}
+
+func TestWebTLSClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestCert(t, "server", nil, false)
+	certFile, keyFile := server.write(t, dir)
+	ca := newTestCert(t, "ca", nil, true)
+	caFile, _ := ca.write(t, dir)
+	valid := newTestCert(t, "prometheus", ca, false)
+	invalid := newTestCert(t, "intruder", nil, false)
+
+	for _, tc := range []struct {
+		mode                 string
+		none, valid, invalid bool
+	}{
+		{"require", false, true, false},
+		{"verify-if-given", true, true, false},
+	} {
+		config, err := webTLSConfig(webTLSOptions{certFile: certFile, keyFile: keyFile, minVersion: "TLS12", clientCAFile: caFile, clientAuth: tc.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := serveTLS(t, config)
+		for _, client := range []struct {
+			name string
+			cert *testCert
+			ok   bool
+		}{
+			{"no", nil, tc.none},
+			{"valid", valid, tc.valid},
+			{"invalid", invalid, tc.invalid},
+		} {
+			if err := getTLS(t, url, server, client.cert, 0); (err == nil) != client.ok {
+				t.Errorf("%s: %s client certificate: expected success %v, got %v", tc.mode, client.name, client.ok, err)
+			}
+		}
+	}
+}
+
+func TestWebTLSConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := newTestCert(t, "server", nil, false).write(t, dir)
+	garbage := filepath.Join(dir, "garbage.crt")
+	if err := ioutil.WriteFile(garbage, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if tc, err := webTLSConfig(webTLSOptions{minVersion: "TLS12", clientAuth: "require"}); tc != nil || err != nil {
+		t.Errorf("expected plain HTTP without the flags, got %v, %v", tc, err)
+	}
+	for _, tc := range []struct {
+		o   webTLSOptions
+		err string
+	}{
+		{webTLSOptions{certFile: certFile}, "must be given together"},
+		{webTLSOptions{keyFile: keyFile}, "must be given together"},
+		{webTLSOptions{certFile: certFile, keyFile: keyFile, minVersion: "SSL3"}, "unknown -web.tls.min-version"},
+		{webTLSOptions{certFile: filepath.Join(dir, "missing.crt"), keyFile: keyFile, minVersion: "TLS12"}, "no such file"},
+		{webTLSOptions{certFile: garbage, keyFile: keyFile, minVersion: "TLS12"}, "failed to find any PEM data"},
+		{webTLSOptions{certFile: certFile, keyFile: certFile, minVersion: "TLS12"}, "loading the web certificate"},
+		{webTLSOptions{clientCAFile: certFile}, "requires -web.tls.cert-file"},
+		{webTLSOptions{certFile: certFile, keyFile: keyFile, minVersion: "TLS12", clientCAFile: certFile, clientAuth: "maybe"}, "unknown -web.tls.client-auth"},
+		{webTLSOptions{certFile: certFile, keyFile: keyFile, minVersion: "TLS12", clientCAFile: garbage, clientAuth: "require"}, "no certificates found"},
+	} {
+		if _, err := webTLSConfig(tc.o); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("webTLSConfig(%+v): expected an error containing %q, got %v", tc.o, tc.err, err)
+		}
+	}
+}
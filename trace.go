@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newPhaseDurationDesc(labels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_phase_duration_seconds"),
+		"Duration of each phase of the last scrape of apache. Phases that didn't happen, such as connecting on a reused connection, are absent.",
+		[]string{"phase"}, labels,
+	)
+}
+
+// phaseTimer records when each phase of a scrape request started and
+// ended, using httptrace hooks. The hooks may run on the transport's dial
+// goroutines, even after the request returned, so the times are only
+// read and written under mutex; those arriving after readDone are
+// ignored.
+type phaseTimer struct {
+	mutex sync.Mutex
+	done  bool
+	times phaseTimes
+}
+
+type phaseTimes struct {
+	dnsStart, dnsDone       time.Time
+	connectStart, connected time.Time
+	tlsStart, tlsDone       time.Time
+	tlsErr                  error // Of the handshake, once done.
+	wroteRequest, firstByte time.Time
+	bodyDone                time.Time
+	local                   net.Addr // Of the exporter, on the connection used.
+}
+
+func (p *phaseTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { p.mark(&p.times.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { p.mark(&p.times.dnsDone) },
+		ConnectStart:         func(string, string) { p.mark(&p.times.connectStart) },
+		ConnectDone:          func(string, string, error) { p.mark(&p.times.connected) },
+		TLSHandshakeStart:    func() { p.mark(&p.times.tlsStart) },
+		TLSHandshakeDone:     func(_ tls.ConnectionState, err error) { p.handshakeDone(err) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { p.mark(&p.times.wroteRequest) },
+		GotFirstResponseByte: func() { p.mark(&p.times.firstByte) },
+	}
+}
+
+// mark sets t, one of the times of p, to now, unless p is done.
+func (p *phaseTimer) mark(t *time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.done {
+		*t = time.Now()
+	}
+}
+
+// gotConn records the local address of the connection the request got.
+func (p *phaseTimer) gotConn(local net.Addr) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.done {
+		p.times.local = local
+	}
+}
+
+// handshakeDone marks the end of the TLS handshake, which failed with err
+// if not nil.
+func (p *phaseTimer) handshakeDone(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.done {
+		p.times.tlsDone = time.Now()
+		p.times.tlsErr = err
+	}
+}
+
+// readDone marks the end of reading the response body, and of p.
+func (p *phaseTimer) readDone() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.done {
+		p.times.bodyDone = time.Now()
+		p.done = true
+	}
+}
+
+// snapshot returns the times of p so far.
+func (p *phaseTimer) snapshot() phaseTimes {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.times
+}
+
+// take makes the times of attempt those of p, ignoring the callbacks
+// attempt gets from then on.
+func (p *phaseTimer) take(attempt *phaseTimer) {
+	attempt.mutex.Lock()
+	times := attempt.times
+	attempt.done = true
+	attempt.mutex.Unlock()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.times = times
+}
+
+type phase struct {
+	name       string
+	start, end time.Time
+}
+
+// completed returns the phases that completed.
+func (p *phaseTimer) completed() []phase {
+	t := p.snapshot()
+	var phases []phase
+	for _, phase := range []phase{
+		{"dns", t.dnsStart, t.dnsDone},
+		{"connect", t.connectStart, t.connected},
+		{"tls", t.tlsStart, t.tlsDone},
+		{"first_byte", t.wroteRequest, t.firstByte},
+		{"body", t.firstByte, t.bodyDone},
+	} {
+		if !phase.start.IsZero() && !phase.end.IsZero() {
+			phases = append(phases, phase)
+		}
+	}
+	return phases
+}
+
+// collect sends the duration of every phase that completed to ch.
+func (p *phaseTimer) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	for _, phase := range p.completed() {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, phase.end.Sub(phase.start).Seconds(), phase.name)
+	}
+}
+
+// annotate adds an event to s at the end of every phase that completed,
+// with its duration in seconds.
+func (p *phaseTimer) annotate(s *span) {
+	if s == nil {
+		return
+	}
+	for _, phase := range p.completed() {
+		s.addEvent(phase.name, phase.end, spanAttribute{"duration_seconds", strconv.FormatFloat(phase.end.Sub(phase.start).Seconds(), 'f', -1, 64)})
+	}
+}
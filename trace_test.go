@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// phaseDurations scrapes e and returns the reported phase durations.
+func phaseDurations(t *testing.T, e *Exporter) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		e.Collect(ch)
+	}()
+
+	phases := map[string]float64{}
+	for m := range ch {
+		if m.Desc() != e.phaseDesc {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		phases[pb.GetLabel()[0].GetValue()] = pb.GetGauge().GetValue()
+	}
+	return phases
+}
+
+func TestPhaseDurations(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte("Total Accesses: 1\nBusyWorkers: 1\nIdleWorkers: 1\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	e.client = newHTTPClient(clientConfig{})
+
+	first := phaseDurations(t, e)
+	for _, phase := range []string{"connect", "first_byte", "body"} {
+		if _, ok := first[phase]; !ok {
+			t.Errorf("expected %s phase on first scrape, got %v", phase, first)
+		}
+	}
+	// An IP literal over plain HTTP needs neither DNS nor TLS.
+	for _, phase := range []string{"dns", "tls"} {
+		if _, ok := first[phase]; ok {
+			t.Errorf("unexpected %s phase on first scrape", phase)
+		}
+	}
+	if first["first_byte"] < delay.Seconds() || first["body"] < delay.Seconds() {
+		t.Errorf("expected first_byte and body to include the %s delays, got %v", delay, first)
+	}
+
+	second := phaseDurations(t, e)
+	if _, ok := second["connect"]; ok {
+		t.Errorf("expected no connect phase on a reused connection, got %v", second)
+	}
+	if _, ok := second["first_byte"]; !ok {
+		t.Errorf("expected first_byte phase on second scrape, got %v", second)
+	}
+}
+
+func TestPhaseDurationsTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+
+	e := NewExporter(server.URL)
+	e.client = newHTTPClient(clientConfig{insecure: true})
+	if _, ok := phaseDurations(t, e)["tls"]; !ok {
+		t.Error("expected tls phase for an https scrape")
+	}
+}
+
+func TestPhaseTimerLateCallbacks(t *testing.T) {
+	p := &phaseTimer{}
+	trace := p.trace()
+	// An abandoned dial may report in while the scrape reads the timer.
+	dialing := make(chan struct{})
+	go func() {
+		defer close(dialing)
+		for i := 0; i < 100; i++ {
+			trace.ConnectStart("tcp", "10.0.0.1:80")
+			trace.ConnectDone("tcp", "10.0.0.1:80", nil)
+		}
+	}()
+	trace.WroteRequest(httptrace.WroteRequestInfo{})
+	trace.GotFirstResponseByte()
+	p.readDone()
+	p.completed()
+	<-dialing
+
+	before := p.snapshot()
+	trace.ConnectStart("tcp", "10.0.0.1:80")
+	trace.ConnectDone("tcp", "10.0.0.1:80", nil)
+	trace.GotFirstResponseByte()
+	if after := p.snapshot(); after != before {
+		t.Errorf("expected the callbacks after readDone to be ignored, got %+v, then %+v", before, after)
+	}
+}
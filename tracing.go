@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// tracer samples collections and hands the spans of those it traces to
+// export once they end. export must not block the collection.
+type tracer struct {
+	ratio  float64 // Of the collections traced.
+	export func(s *span)
+}
+
+// activeTracer traces the scrapes, nil when tracing is off.
+var activeTracer *tracer
+
+// Kinds of span, as OTLP numbers them.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+// span is an operation of a traced collection. The methods of a nil span,
+// of a collection not traced, do nothing.
+type span struct {
+	tracer   *tracer
+	name     string
+	kind     int
+	traceID  [16]byte
+	id       [8]byte
+	parentID [8]byte // Zero for the root of the trace.
+	start    time.Time
+
+	mutex      sync.Mutex
+	finish     time.Time
+	attributes []spanAttribute
+	events     []spanEvent
+	err        error
+}
+
+type spanAttribute struct {
+	key, value string
+}
+
+type spanEvent struct {
+	name       string
+	at         time.Time
+	attributes []spanAttribute
+}
+
+type spanKey struct{}
+
+// startSpan starts the span name as a child of the span of ctx, or, if ctx
+// has none, as the root of a trace sampled by the ratio of the tracer. It
+// returns ctx carrying the span, which is nil if the collection isn't
+// traced.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	t := activeTracer
+	if t == nil {
+		return ctx, nil
+	}
+	parent, inTrace := ctx.Value(spanKey{}).(*span)
+	if inTrace && parent == nil {
+		return ctx, nil // Not sampled.
+	}
+	s := &span{tracer: t, name: name, kind: spanKindInternal, start: time.Now()}
+	if parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.id
+	} else {
+		if rand.Float64() >= t.ratio {
+			return context.WithValue(ctx, spanKey{}, (*span)(nil)), nil
+		}
+		cryptorand.Read(s.traceID[:])
+	}
+	cryptorand.Read(s.id[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) setAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, spanAttribute{key, value})
+}
+
+func (s *span) addEvent(name string, at time.Time, attributes ...spanAttribute) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, spanEvent{name, at, attributes})
+}
+
+// end ends s, failed with err if not nil, and exports it.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.finish = time.Now()
+	s.err = err
+	s.mutex.Unlock()
+	s.tracer.export(s)
+}
@@ -0,0 +1,259 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/version"
+)
+
+var (
+	tracingEndpoint    = flag.String("tracing.otlp-endpoint", "", "Base URL of an OTLP receiver, such as http://collector:4318, to send spans of the collections, the scrape of each target, its request to apache and its collectors to, on /v1/traces. Tracing is off without it.")
+	tracingSampleRatio = flag.Float64("tracing.sample-ratio", 1, "Ratio of the collections traced with -tracing.otlp-endpoint, from 0 to 1.")
+
+	droppedSpans, traceFailures prometheus.Counter
+)
+
+const (
+	// spanQueueSize is how many ended spans wait to be sent at most. Spans
+	// ending while it is full are dropped, so that a trace backend down
+	// never holds up scrapes.
+	spanQueueSize = 2048
+	// spanBatchSize is how many spans are sent at most in one request.
+	spanBatchSize = 512
+	// spanBatchInterval is how long spans wait to be sent at most.
+	spanBatchInterval = 5 * time.Second
+	// spanSendTimeout bounds each request to the receiver.
+	spanSendTimeout = 10 * time.Second
+)
+
+func init() {
+	registerOutput(output{name: "tracing", setup: func() (func(s *targetSet, done <-chan struct{}), error) {
+		x, err := spanExporterFromFlags()
+		if x == nil || err != nil {
+			return nil, err
+		}
+		activeTracer = &tracer{ratio: *tracingSampleRatio, export: x.export}
+		return func(s *targetSet, done <-chan struct{}) {
+			x.run(done)
+		}, nil
+	}})
+	selfMetric(func() {
+		droppedSpans = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "trace_spans_dropped_total",
+			Help:        "Number of spans dropped as the queue of those to send to -tracing.otlp-endpoint was full.",
+			ConstLabels: withConstLabels(nil),
+		})
+		traceFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "trace_export_failures_total",
+			Help:        "Number of failed sends of spans to -tracing.otlp-endpoint.",
+			ConstLabels: withConstLabels(nil),
+		})
+		registry.MustRegister(droppedSpans, traceFailures)
+	})
+}
+
+// OTLP status code of failed spans.
+const otlpStatusError = 2
+
+// The parts of the OTLP traces data model, in its JSON encoding, that spans
+// map to.
+type (
+	otlpTraceRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Events            []otlpEvent     `json:"events,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string          `json:"timeUnixNano"`
+		Name         string          `json:"name"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// spanExporter sends spans to an OTLP receiver over HTTP, in batches, from
+// a queue of bounded size.
+type spanExporter struct {
+	url    string
+	client *http.Client
+	queue  chan *span
+}
+
+func spanExporterFromFlags() (*spanExporter, error) {
+	if *tracingEndpoint == "" {
+		return nil, nil
+	}
+	if *tracingSampleRatio < 0 || *tracingSampleRatio > 1 {
+		return nil, fmt.Errorf("-tracing.sample-ratio must be from 0 to 1, got %v", *tracingSampleRatio)
+	}
+	return newSpanExporter(*tracingEndpoint, newHTTPClient(clientConfigFromFlags()))
+}
+
+// newSpanExporter returns an exporter to the OTLP receiver at endpoint,
+// connecting with client.
+func newSpanExporter(endpoint string, client *http.Client) (*spanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-tracing.otlp-endpoint %q must be an http or https URL", endpoint)
+	}
+	return &spanExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: client,
+		queue:  make(chan *span, spanQueueSize),
+	}, nil
+}
+
+// export queues s to be sent, or drops it if the queue is full.
+func (x *spanExporter) export(s *span) {
+	select {
+	case x.queue <- s:
+	default:
+		droppedSpans.Inc()
+	}
+}
+
+// run sends the queued spans every spanBatchInterval, or as soon as there
+// are spanBatchSize of them, until done is closed, sending what is left
+// then. Failures are logged and counted, and the spans dropped.
+func (x *spanExporter) run(done <-chan struct{}) {
+	ticker := time.NewTicker(spanBatchInterval)
+	defer ticker.Stop()
+	var batch []*span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := x.send(batch); err != nil {
+			traceFailures.Inc()
+			logger.Error("Error sending spans over OTLP", "url", sanitizeURI(x.url), "spans", len(batch), "err", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-x.queue:
+			batch = append(batch, s)
+			if len(batch) >= spanBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-done:
+			for len(x.queue) > 0 && len(batch) < spanBatchSize {
+				batch = append(batch, <-x.queue)
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// send sends spans in one request.
+func (x *spanExporter) send(spans []*span) error {
+	body, err := json.Marshal(otlpSpans(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), spanSendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", x.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// otlpSpans converts spans into OTLP spans of the exporter's resource.
+func otlpSpans(spans []*span) otlpTraceRequest {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "apache_exporter", Version: version.Version}}
+	for _, s := range spans {
+		s.mutex.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.finish.UnixNano(), 10),
+			Attributes:        otlpSpanAttributes(s.attributes),
+		}
+		if s.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, e := range s.events {
+			o.Events = append(o.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(e.at.UnixNano(), 10),
+				Name:         e.name,
+				Attributes:   otlpSpanAttributes(e.attributes),
+			})
+		}
+		if s.err != nil {
+			o.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+		}
+		s.mutex.Unlock()
+		scope.Spans = append(scope.Spans, o)
+	}
+	return otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{otlpString("service.name", "apache_exporter"), otlpString("service.version", version.Version)}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+func otlpSpanAttributes(attributes []spanAttribute) []otlpAttribute {
+	var attrs []otlpAttribute
+	for _, a := range attributes {
+		attrs = append(attrs, otlpString(a.key, a.value))
+	}
+	return attrs
+}
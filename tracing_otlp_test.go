@@ -0,0 +1,134 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpanExporter(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []otlpTraceRequest
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unsupported", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req otlpTraceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, req)
+	}))
+	defer receiver.Close()
+	x, err := newSpanExporter(receiver.URL+"/", http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		x.run(done)
+	}()
+
+	defer func(old *tracer) { activeTracer = old }(activeTracer)
+	activeTracer = &tracer{ratio: 1, export: x.export}
+	ctx, root := startSpan(context.Background(), "collect")
+	_, child := startSpan(ctx, "scrape")
+	child.setAttribute("apache.target", "web01")
+	child.addEvent("body", time.Now(), spanAttribute{"duration_seconds", "0.5"})
+	child.end(errors.New("Error scraping apache: unexpected status 503"))
+	root.end(nil)
+	close(done)
+	<-stopped
+
+	if len(requests) != 1 || len(requests[0].ResourceSpans) != 1 || len(requests[0].ResourceSpans[0].ScopeSpans[0].Spans) != 2 {
+		t.Fatalf("expected a request with both spans, got %+v", requests)
+	}
+	rs := requests[0].ResourceSpans[0]
+	if otlpAttributes(rs.Resource.Attributes)["service.name"] != "apache_exporter" {
+		t.Errorf("unexpected resource %+v", rs.Resource)
+	}
+	scrape, collect := rs.ScopeSpans[0].Spans[0], rs.ScopeSpans[0].Spans[1]
+	if len(collect.TraceID) != 32 || len(collect.SpanID) != 16 || collect.ParentSpanID != "" || collect.Status.Code != 0 {
+		t.Errorf("unexpected root span %+v", collect)
+	}
+	if scrape.TraceID != collect.TraceID || scrape.ParentSpanID != collect.SpanID || scrape.Kind != spanKindInternal {
+		t.Errorf("expected the scrape span under the collect one, got %+v", scrape)
+	}
+	if scrape.Status.Code != otlpStatusError || scrape.Status.Message != "Error scraping apache: unexpected status 503" {
+		t.Errorf("expected a failed span, got %+v", scrape.Status)
+	}
+	if otlpAttributes(scrape.Attributes)["apache.target"] != "web01" || len(scrape.Events) != 1 || otlpAttributes(scrape.Events[0].Attributes)["duration_seconds"] != "0.5" {
+		t.Errorf("unexpected attributes and events %+v", scrape)
+	}
+}
+
+func TestSpanExporterDown(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+	x, err := newSpanExporter(receiver.URL, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old *tracer) { activeTracer = old }(activeTracer)
+	activeTracer = &tracer{ratio: 1, export: x.export}
+
+	// Nothing sends the queue, which fills up without blocking.
+	dropped := counterValue(t, droppedSpans)
+	for i := 0; i < spanQueueSize+10; i++ {
+		_, s := startSpan(context.Background(), "collect")
+		s.end(nil)
+	}
+	if v := counterValue(t, droppedSpans) - dropped; v != 10 {
+		t.Errorf("expected 10 spans dropped, got %v", v)
+	}
+
+	failures := counterValue(t, traceFailures)
+	done := make(chan struct{})
+	close(done)
+	x.run(done)
+	if v := counterValue(t, traceFailures) - failures; v != 1 {
+		t.Errorf("expected a failed send, got %v", v)
+	}
+}
+
+func TestSpanExporterFlags(t *testing.T) {
+	defer func(endpoint string, ratio float64) {
+		*tracingEndpoint, *tracingSampleRatio = endpoint, ratio
+	}(*tracingEndpoint, *tracingSampleRatio)
+	for _, tc := range []struct {
+		endpoint string
+		ratio    float64
+		err      string
+	}{
+		{"", 1, ""},
+		{"http://collector:4318", 0.1, ""},
+		{"collector:4318", 1, "-tracing.otlp-endpoint"},
+		{"http://collector:4318", 1.5, "-tracing.sample-ratio"},
+	} {
+		*tracingEndpoint, *tracingSampleRatio = tc.endpoint, tc.ratio
+		x, err := spanExporterFromFlags()
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s, %v: expected error %q, got %v", tc.endpoint, tc.ratio, tc.err, err)
+		}
+		if err == nil && (x == nil) != (tc.endpoint == "") {
+			t.Errorf("%s: unexpected exporter %v", tc.endpoint, x)
+		}
+	}
+}
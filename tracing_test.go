@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// spanRecorder keeps the spans the tracer it installs exports, in memory.
+type spanRecorder struct {
+	mutex sync.Mutex
+	spans []*span
+}
+
+// recordSpans traces the scrapes of the test with ratio into the returned
+// recorder.
+func recordSpans(t *testing.T, ratio float64) *spanRecorder {
+	r := &spanRecorder{}
+	old := activeTracer
+	t.Cleanup(func() { activeTracer = old })
+	activeTracer = &tracer{ratio: ratio, export: func(s *span) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.spans = append(r.spans, s)
+	}}
+	return r
+}
+
+// children returns the recorded spans named name under parent, the roots
+// of traces if nil.
+func (r *spanRecorder) children(parent *span, name string) []*span {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var spans []*span
+	for _, s := range r.spans {
+		if s.name != name {
+			continue
+		}
+		if parent == nil && s.parentID == [8]byte{} || parent != nil && s.parentID == parent.id && s.traceID == parent.traceID {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func (s *span) attribute(key string) string {
+	for _, a := range s.attributes {
+		if a.key == key {
+			return a.value
+		}
+	}
+	return ""
+}
+
+func TestTracing(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	es, err := setupExporters([]string{"web01=" + up.URL, "web02=" + down.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := recordSpans(t, 1)
+	metricsHandler(newTargetSet(es, nil)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+
+	root := r.children(nil, "collect")
+	if len(root) != 1 {
+		t.Fatalf("expected a collect span, got %d", len(root))
+	}
+	if v := root[0].attribute("apache.targets"); v != "2" {
+		t.Errorf("expected 2 targets on the collect span, got %q", v)
+	}
+	scrapes := map[string]*span{}
+	for _, s := range r.children(root[0], "scrape") {
+		scrapes[s.attribute("apache.target")] = s
+	}
+	if len(scrapes) != 2 || scrapes["web01"] == nil || scrapes["web02"] == nil {
+		t.Fatalf("expected a scrape span of each target, got %v", scrapes)
+	}
+	if scrapes["web01"].err != nil || scrapes["web02"].err == nil {
+		t.Errorf("expected web02 alone failed, got %v and %v", scrapes["web01"].err, scrapes["web02"].err)
+	}
+
+	for name, status := range map[string]string{"web01": "200", "web02": "503"} {
+		requests := r.children(scrapes[name], "GET")
+		if len(requests) != 1 {
+			t.Fatalf("%s: expected a request span, got %d", name, len(requests))
+		}
+		request := requests[0]
+		if request.kind != spanKindClient || request.attribute("http.response.status_code") != status || request.attribute("url.full") == "" {
+			t.Errorf("%s: unexpected request span %+v", name, request)
+		}
+		events := map[string]bool{}
+		for _, e := range request.events {
+			if e.at.Before(request.start) || e.attributes[0].key != "duration_seconds" {
+				t.Errorf("%s: unexpected event %+v", name, e)
+			}
+			events[e.name] = true
+		}
+		// A new connection to each.
+		for _, phase := range []string{"connect", "first_byte", "body"} {
+			if !events[phase] {
+				t.Errorf("%s: expected a %s event, got %v", name, phase, request.events)
+			}
+		}
+	}
+
+	collectors := map[string]bool{}
+	for _, s := range r.children(scrapes["web01"], "collector") {
+		collectors[s.attribute("apache.collector")] = true
+	}
+	for _, c := range []string{"accesses", "workers"} {
+		if !collectors[c] {
+			t.Errorf("expected a span of collector %s, got %v", c, collectors)
+		}
+	}
+	if got := r.children(scrapes["web02"], "collector"); len(got) != 0 {
+		t.Errorf("expected no collector run for web02, got %d", len(got))
+	}
+}
+
+func TestTracingSampling(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer server.Close()
+	es, err := setupExporters([]string{"web01=" + server.URL}, testDefaults, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := recordSpans(t, 0)
+	metricsHandler(newTargetSet(es, nil)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	if len(r.spans) != 0 {
+		t.Errorf("expected no span of a collection not sampled, got %d", len(r.spans))
+	}
+}
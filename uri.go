@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	defaultScheme = flag.String("scrape.default-scheme", "http", "Scheme used for scrape targets given without one.")
+	defaultPort   = flag.String("scrape.default-port", "", "Port used for scrape targets given without scheme and port (default the scheme's port).")
+	defaultPath   = flag.String("scrape.default-path", "/server-status", "Path used for scrape targets given without one.")
+	defaultQuery  = flag.String("scrape.default-query", "auto", "Query used for scrape targets given without path and query.")
+)
+
+// uriDefaults are the components completeURI fills into shorthand targets.
+type uriDefaults struct {
+	scheme, port, path, query string
+}
+
+func uriDefaultsFromFlags() uriDefaults {
+	return uriDefaults{
+		scheme: *defaultScheme,
+		port:   *defaultPort,
+		path:   *defaultPath,
+		query:  *defaultQuery,
+	}
+}
+
+// completeURI expands shorthand targets such as "host", "host:port" or
+// "https://host" into full status URIs. Components that are present are
+// left untouched, so complete URIs come back unchanged.
+func completeURI(target string, d uriDefaults) string {
+	schemeDefaulted := !strings.Contains(target, "://")
+	if schemeDefaulted {
+		// Bare IPv6 literals need brackets to be parsed as a host.
+		authority := target
+		if i := strings.IndexAny(target, "/?#"); i >= 0 {
+			authority = target[:i]
+		}
+		if ip := net.ParseIP(authority); ip != nil && strings.Contains(authority, ":") {
+			target = "[" + authority + "]" + target[len(authority):]
+		}
+		target = d.scheme + "://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		// Leave it to validateScrapeURI to report.
+		return target
+	}
+	if schemeDefaulted && u.Port() == "" && d.port != "" {
+		u.Host = net.JoinHostPort(u.Hostname(), d.port)
+	}
+	if u.Path == "" {
+		u.Path = d.path
+		if u.RawQuery == "" {
+			u.RawQuery = d.query
+		}
+	}
+	return u.String()
+}
+
+// validateScrapeURI checks that uri is an absolute http(s) URL.
+func validateScrapeURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid scrape URI %q: %v", sanitizeURI(uri), sanitizeError(err))
+	}
+	switch {
+	case u.Scheme == "h3" && newQUICTransport == nil:
+		return fmt.Errorf("invalid scrape URI %q: HTTP/3 isn't supported by this build of the exporter", sanitizeURI(uri))
+	case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "h3":
+		return fmt.Errorf("invalid scrape URI %q: scheme must be http, https or h3", sanitizeURI(uri))
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid scrape URI %q: missing host", sanitizeURI(uri))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/yosefy/apache_exporter/version"
+)
+
+var showVersion = flag.Bool("version", false, "Print the version, revision, build date, Go version and platform of the exporter and exit.")
+
+func init() {
+	selfMetric(func() { registry.MustRegister(version.NewCollector(namespace+"_exporter", withConstLabels(nil))) })
+}
+
+func defaultUserAgent() string {
+	return "apache_exporter/" + version.Version
+}
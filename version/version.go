@@ -0,0 +1,45 @@
+// Package version holds the build metadata of the exporter, set at build
+// time with -ldflags "-X github.com/yosefy/apache_exporter/version.Version=...".
+package version
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set with -ldflags -X, as the Makefile does.
+var (
+	Version   = "dev"
+	Revision  = "unknown"
+	BuildDate = "unknown"
+)
+
+// GoVersion is the version of Go the exporter was built with.
+var GoVersion = runtime.Version()
+
+// Print returns the build metadata of program, as -version prints it.
+func Print(program string) string {
+	return fmt.Sprintf("%s, version %s (revision: %s)\n  build date: %s\n  go version: %s\n  platform:   %s/%s\n",
+		program, Version, Revision, BuildDate, GoVersion, runtime.GOOS, runtime.GOARCH)
+}
+
+// NewCollector returns a metric named <program>_build_info with the build
+// metadata and labels as its labels and a constant value of 1.
+func NewCollector(program string, labels prometheus.Labels) prometheus.Collector {
+	constLabels := prometheus.Labels{
+		"version":   Version,
+		"revision":  Revision,
+		"builddate": BuildDate,
+		"goversion": GoVersion,
+	}
+	for name, value := range labels {
+		constLabels[name] = value
+	}
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        program + "_build_info",
+		Help:        "A metric with a constant '1' value labeled by the version, revision, build date and Go version " + program + " was built with.",
+		ConstLabels: constLabels,
+	}, func() float64 { return 1 })
+}
@@ -0,0 +1,56 @@
+package version
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPrint(t *testing.T) {
+	defer func(v, r, d string) { Version, Revision, BuildDate = v, r, d }(Version, Revision, BuildDate)
+	// As -ldflags -X would set them.
+	Version, Revision, BuildDate = "1.2.3", "0ef5d7d", "2026-10-14T15:00:00Z"
+
+	out := Print("apache_exporter")
+	for _, want := range []string{
+		"apache_exporter, version 1.2.3 (revision: 0ef5d7d)\n",
+		"build date: 2026-10-14T15:00:00Z\n",
+		"go version: " + runtime.Version() + "\n",
+		"platform:   " + runtime.GOOS + "/" + runtime.GOARCH + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestNewCollector(t *testing.T) {
+	defer func(v, r, d string) { Version, Revision, BuildDate = v, r, d }(Version, Revision, BuildDate)
+	Version, Revision, BuildDate = "1.2.3", "0ef5d7d", "2026-10-14T15:00:00Z"
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector("apache_exporter", prometheus.Labels{"region": "eu-west"}))
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || mfs[0].GetName() != "apache_exporter_build_info" {
+		t.Fatalf("expected apache_exporter_build_info, got %v", mfs)
+	}
+	m := mfs[0].Metric[0]
+	labels := map[string]string{}
+	for _, l := range m.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	want := map[string]string{"version": "1.2.3", "revision": "0ef5d7d", "builddate": "2026-10-14T15:00:00Z", "goversion": runtime.Version(), "region": "eu-west"}
+	for name, value := range want {
+		if labels[name] != value {
+			t.Errorf("expected %s=%q, got %q", name, value, labels[name])
+		}
+	}
+	if m.GetGauge().GetValue() != 1 {
+		t.Errorf("expected 1, got %v", m.GetGauge().GetValue())
+	}
+}
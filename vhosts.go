@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var (
+	vhostsStripPort   = flag.Bool("collector.vhosts.strip-port", false, "Drop the port off vhost labels, as www.example.com:443 to www.example.com.")
+	vhostsLowercase   = flag.Bool("collector.vhosts.lowercase", false, "Lowercase vhost labels.")
+	vhostsCollapseWWW = flag.Bool("collector.vhosts.collapse-www", false, "Drop a leading www. off vhost labels, so www.example.com and example.com are one vhost.")
+	vhostRewrites     = &vhostRewriteFlag{}
+)
+
+func init() {
+	flag.Var(vhostRewrites, "collector.vhosts.rewrite", "Rewrite of vhost labels, as 'regex=>replacement' with $1 for the groups of the regex, which matches whole vhosts. Applied after -collector.vhosts.strip-port, -collector.vhosts.lowercase and -collector.vhosts.collapse-www. May be repeated, applied in order.")
+}
+
+// vhostRewrite replaces the vhosts re matches.
+type vhostRewrite struct {
+	expr, replacement string
+	re                *regexp.Regexp
+}
+
+// vhostRewriteFlag is the rewrites of vhost labels, in order.
+type vhostRewriteFlag []vhostRewrite
+
+func (f *vhostRewriteFlag) String() string {
+	var s []string
+	for _, r := range *f {
+		s = append(s, r.expr+"=>"+r.replacement)
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *vhostRewriteFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=>", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("expected regex=>replacement, got %q", value)
+	}
+	re, err := regexp.Compile("^(?:" + kv[0] + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regex %q: %v", kv[0], err)
+	}
+	*f = append(*f, vhostRewrite{kv[0], kv[1], re})
+	return nil
+}
+
+// vhostNormalizer shapes the vhost labels of every metric labeled by
+// vhost alike. Metrics of vhosts it makes one are summed.
+type vhostNormalizer struct {
+	stripPort, lowercase, collapseWWW bool
+	rewrites                          []vhostRewrite
+}
+
+func vhostNormalizerFromFlags() *vhostNormalizer {
+	return &vhostNormalizer{
+		stripPort:   *vhostsStripPort,
+		lowercase:   *vhostsLowercase,
+		collapseWWW: *vhostsCollapseWWW,
+		rewrites:    *vhostRewrites,
+	}
+}
+
+// normalize returns the label of vhost.
+func (n *vhostNormalizer) normalize(vhost string) string {
+	if n.stripPort {
+		// Bracketed IPv6 addresses keep their brackets, bare ones have
+		// no port to strip.
+		if host, _, err := net.SplitHostPort(vhost); err == nil {
+			vhost = host
+			if strings.Contains(host, ":") {
+				vhost = "[" + host + "]"
+			}
+		}
+	}
+	if n.lowercase {
+		vhost = strings.ToLower(vhost)
+	}
+	if n.collapseWWW && len(vhost) > len("www.") && strings.EqualFold(vhost[:len("www.")], "www.") {
+		vhost = vhost[len("www."):]
+	}
+	for _, r := range n.rewrites {
+		vhost = r.re.ReplaceAllString(vhost, r.replacement)
+	}
+	return vhost
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNormalizeVhost(t *testing.T) {
+	rewrites := func(rules ...string) []vhostRewrite {
+		var f vhostRewriteFlag
+		for _, rule := range rules {
+			if err := f.Set(rule); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return f
+	}
+	all := vhostNormalizer{stripPort: true, lowercase: true, collapseWWW: true}
+	for _, tc := range []struct {
+		n           vhostNormalizer
+		vhost, want string
+	}{
+		{vhostNormalizer{}, "WWW.Example.com:443", "WWW.Example.com:443"},
+		{vhostNormalizer{stripPort: true}, "www.example.com:443", "www.example.com"},
+		{vhostNormalizer{stripPort: true}, "www.example.com", "www.example.com"},
+		{vhostNormalizer{stripPort: true}, "[2001:db8::1]:8443", "[2001:db8::1]"},
+		// A bare IPv6 address has no port to strip.
+		{vhostNormalizer{stripPort: true}, "2001:db8::1", "2001:db8::1"},
+		{vhostNormalizer{stripPort: true}, "[2001:db8::1]", "[2001:db8::1]"},
+		{vhostNormalizer{stripPort: true}, "192.0.2.10:80", "192.0.2.10"},
+		{vhostNormalizer{lowercase: true}, "Example.COM", "example.com"},
+		{vhostNormalizer{lowercase: true}, "[2001:DB8::1]:443", "[2001:db8::1]:443"},
+		// IDNs are lowercased as written, punycode isn't decoded.
+		{vhostNormalizer{lowercase: true}, "BÜCHER.example", "bücher.example"},
+		{vhostNormalizer{lowercase: true}, "xn--bcher-kva.EXAMPLE", "xn--bcher-kva.example"},
+		{vhostNormalizer{collapseWWW: true}, "www.example.com", "example.com"},
+		{vhostNormalizer{collapseWWW: true}, "WWW.Example.com", "Example.com"},
+		{vhostNormalizer{collapseWWW: true}, "www.", "www."},
+		{vhostNormalizer{collapseWWW: true}, "www2.example.com", "www2.example.com"},
+		{vhostNormalizer{collapseWWW: true}, "www.bücher.example", "bücher.example"},
+		{all, "WWW.Example.com:443", "example.com"},
+		{all, "www.xn--bcher-kva.example:80", "xn--bcher-kva.example"},
+		{all, "[2001:DB8::1]:443", "[2001:db8::1]"},
+		{vhostNormalizer{rewrites: rewrites(`(.+)\.internal\.example\.com=>$1`)}, "shop.internal.example.com", "shop"},
+		// Regexes match whole vhosts.
+		{vhostNormalizer{rewrites: rewrites(`example=>other`)}, "www.example.com", "www.example.com"},
+		{vhostNormalizer{rewrites: rewrites(`shop-[0-9]+\.example\.com=>shop.example.com`, `shop\.(.*)=>store.$1`)}, "shop-12.example.com", "store.example.com"},
+		// After the other options.
+		{vhostNormalizer{stripPort: true, lowercase: true, rewrites: rewrites(`api\.example\.com=>api`)}, "API.example.com:8080", "api"},
+	} {
+		if got := tc.n.normalize(tc.vhost); got != tc.want {
+			t.Errorf("%+v: expected %q normalized to %q, got %q", tc.n, tc.vhost, tc.want, got)
+		}
+	}
+}
+
+func TestVhostRewriteFlag(t *testing.T) {
+	var f vhostRewriteFlag
+	for _, value := range []string{"example.com", "=>x", "(=>x"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+	for _, value := range []string{`www\.(.*)=>$1`, `.*\.test=>`} {
+		if err := f.Set(value); err != nil {
+			t.Errorf("%s: %v", value, err)
+		}
+	}
+	if got := f.String(); got != `www\.(.*)=>$1,.*\.test=>` {
+		t.Errorf("unexpected flag value %q", got)
+	}
+}
+
+func TestVhostCollisionsSum(t *testing.T) {
+	l, err := newApacheLog("access.log", "%v %>s %b %D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.vhosts = &vhostNormalizer{stripPort: true, lowercase: true, collapseWWW: true}
+	for _, line := range []string{
+		"www.example.com:443 200 100 1000",
+		"Example.com 200 20 1000",
+		"example.com:80 500 3 1000",
+		"shop.example.com 200 7 1000",
+	} {
+		l.observe(line)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(l)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName() + "/" + labelValue(m, "vhost")
+			if class := labelValue(m, "code_class"); class != "" {
+				key += "/" + class
+			}
+			if h := m.GetHistogram(); h != nil {
+				got[key] = float64(h.GetSampleCount())
+				continue
+			}
+			got[key] = metricValue(m)
+		}
+	}
+	for key, want := range map[string]float64{
+		"apache_http_responses_total/example.com/2xx":            2,
+		"apache_http_responses_total/example.com/5xx":            1,
+		"apache_http_response_bytes_total/example.com":           123,
+		"apache_http_response_duration_seconds/example.com":      3,
+		"apache_http_responses_total/shop.example.com/2xx":       1,
+		"apache_http_response_duration_seconds/shop.example.com": 1,
+	} {
+		if got[key] != want {
+			t.Errorf("expected %s %v, got %v", key, want, got[key])
+		}
+	}
+	for key := range got {
+		if strings.Contains(key, "www.") || strings.Contains(key, ":") || strings.Contains(key, "Example") {
+			t.Errorf("unexpected series %s of a raw vhost", key)
+		}
+	}
+}
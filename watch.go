@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile calls changed whenever the file at path is modified, created
+// or removed, until done is closed. Changes are picked up through fsnotify
+// on the file's directory, so files replaced by renames are seen too, and
+// by polling every interval in case notifications aren't available.
+func watchFile(path string, interval time.Duration, changed func(), done <-chan struct{}) {
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	if w, err := fsnotify.NewWatcher(); err != nil {
+		logger.Error("Can't watch file, polling it instead", "file", path, "err", err)
+	} else if err := w.Add(filepath.Dir(path)); err != nil {
+		logger.Error("Can't watch file, polling it instead", "file", path, "err", err)
+		w.Close()
+	} else {
+		defer w.Close()
+		events, errors = w.Events, w.Errors
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := statFile(path)
+	check := func() {
+		if st := statFile(path); st != last {
+			last = st
+			changed()
+		}
+	}
+	for {
+		select {
+		case ev := <-events:
+			if filepath.Clean(ev.Name) == filepath.Clean(path) {
+				check()
+			}
+		case err := <-errors:
+			logger.Error("Error watching file", "file", path, "err", err)
+		case <-ticker.C:
+			check()
+		case <-done:
+			return
+		}
+	}
+}
+
+// fileState is what watchFile compares to tell whether a file changed.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statFile(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{true, fi.Size(), fi.ModTime()}
+}
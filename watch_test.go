@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testWatchFile(t *testing.T, dir, path string, interval time.Duration) {
+	changes := make(chan struct{}, 100)
+	done := make(chan struct{})
+	defer close(done)
+	go watchFile(path, interval, func() { changes <- struct{}{} }, done)
+
+	expectChange := func(what string) {
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("change not noticed after %s", what)
+		}
+		// Drain notifications of the same change.
+		time.Sleep(100 * time.Millisecond)
+		for len(changes) > 0 {
+			<-changes
+		}
+	}
+
+	// Give the watcher time to start before changing anything.
+	time.Sleep(50 * time.Millisecond)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("- uri: web01\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("creating the file")
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte("- uri: web01\n- uri: web02\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("replacing the file")
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("removing the file")
+}
+
+func TestWatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A long poll interval leaves noticing changes to fsnotify.
+	testWatchFile(t, dir, filepath.Join(dir, "targets.yml"), time.Hour)
+}
+
+func TestWatchFilePolling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A directory that doesn't exist yet can't be watched.
+	dir = filepath.Join(dir, "later")
+	testWatchFile(t, dir, filepath.Join(dir, "targets.yml"), 20*time.Millisecond)
+}
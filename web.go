@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var disableExporterMetrics = flag.Bool("web.disable-exporter-metrics", false, "Leave the go_* and process_* metrics about the exporter process itself out of /metrics.")
+
+// registry holds the metrics about the exporter as a whole, such as those
+// of discovery, served along with the targets' metrics.
+var registry = prometheus.NewRegistry()
+
+var errorHandling = flag.String("web.error-handling", "http-error", "What to do when gathering a metric fails: http-error answers with a 500, continue logs the error and serves the other metrics.")
+
+// errorLogger logs the errors promhttp serves metrics despite.
+type errorLogger struct{}
+
+func (errorLogger) Println(v ...interface{}) {
+	logger.Error(fmt.Sprint(v...))
+}
+
+// handlerOpts returns how to serve metrics, as set by -web.error-handling,
+// in OpenMetrics to the clients asking for it.
+func handlerOpts() promhttp.HandlerOpts {
+	opts := promhttp.HandlerOpts{ErrorLog: errorLogger{}, EnableOpenMetrics: true}
+	if *errorHandling == "continue" {
+		opts.ErrorHandling = promhttp.ContinueOnError
+	}
+	return opts
+}
+
+func validateErrorHandling(mode string) error {
+	if mode != "http-error" && mode != "continue" {
+		return fmt.Errorf("-web.error-handling must be http-error or continue, got %q", mode)
+	}
+	return nil
+}
+
+// scraper is a collector whose scrapes can be bound to a context.
+type scraper interface {
+	prometheus.Collector
+	collectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// contextCollector ties a scraper's scrapes to a request context.
+type contextCollector struct {
+	s   scraper
+	ctx context.Context
+}
+
+func (c contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.s.Describe(ch)
+}
+
+func (c contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.collectContext(c.ctx, ch)
+}
+
+// timeoutMargin is kept free of the scrape timeout Prometheus sends along
+// for writing the response.
+const timeoutMargin = 250 * time.Millisecond
+
+// scrapeContext returns the context of r, bounded by the scrape timeout
+// Prometheus sends along if any.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 2*timeoutMargin {
+		timeout -= timeoutMargin
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// metricsHandler serves registry, and the default registry unless
+// -web.disable-exporter-metrics is set, together with s, scraping
+// apache within the lifetime of each request so that a client going away
+// cancels the backend request instead of leaving it to run to completion.
+// The scrape is also cut short by the scrape timeout Prometheus sends.
+// collect[] parameters limit the groups of apache metrics served.
+func metricsHandler(s scraper) http.Handler {
+	return endpointHandler(s, endpoint{})
+}
+
+// endpointHandler is metricsHandler serving what e says, with a light
+// endpoint leaving out registry and the default registry too.
+func endpointHandler(s scraper, e endpoint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.serve(w, r, func(ctx context.Context) prometheus.Collector { return contextCollector{s, ctx} })
+	})
+}
+
+// serve serves what e says of the collector c returns for the context of
+// a request.
+func (e endpoint) serve(w http.ResponseWriter, r *http.Request, c func(ctx context.Context) prometheus.Collector) {
+	collectors, err := requestedCollectors(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if collectors != nil {
+		e.collectors = collectors
+	}
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c(ctx))
+	var g prometheus.Gatherer = reg
+	if e.collectors != nil || e.light {
+		// The scrape may be shared with other requests, so it collects
+		// every group and the ones not asked for are dropped after.
+		g = collectorFilter{reg, e}
+	}
+	if e.self != nil {
+		g = e.self.keep(g)
+	}
+	gatherers := prometheus.Gatherers{g}
+	if !e.light {
+		gatherers = append(gatherers, registry)
+		if !*disableExporterMetrics {
+			gatherers = append(gatherers, prometheus.DefaultGatherer)
+		}
+	}
+	var served prometheus.Gatherer = rulesGatherer{gatherers}
+	if *selfMetricsPath != "" {
+		served = selfFilter{served, false}
+	}
+	promhttp.HandlerFor(served, handlerOpts()).ServeHTTP(w, r)
+}
+
+// probeHandler scrapes the target given in the request, blackbox exporter
+// style, and serves only that target's metrics. Targets may use the same
+// shorthand as -scrape.uri, completed with d. Scrapes share client, unless
+// the module asked for has its own TLS settings. collect[] parameters
+// narrow the groups of apache metrics of the module down. Targets policy
+// refuses, before or while they are scraped, get a 403.
+func probeHandler(client *http.Client, policy *targetPolicy, d uriDefaults, modules func() map[string]config.Module) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		param := r.URL.Query().Get("target")
+		if param == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		var module config.Module
+		if name := r.URL.Query().Get("module"); name != "" {
+			var ok bool
+			if module, ok = modules()[name]; !ok {
+				http.Error(w, fmt.Sprintf("unknown module %q, valid modules are: %s", name, moduleNames(modules())), http.StatusBadRequest)
+				return
+			}
+		}
+		if module.Path != "" {
+			d.path = module.Path
+		}
+		if module.Query != "" {
+			d.query = module.Query
+		}
+		requested, err := requestedCollectors(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uri := completeURI(param, d)
+		if err := validateScrapeURI(uri); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+		if policy != nil {
+			if err := policy.check(ctx, uri); err != nil {
+				refuseProbe(w, uri, err)
+				return
+			}
+		}
+		denial := &probeDenial{}
+		ctx = context.WithValue(ctx, probeDenialKey{}, denial)
+
+		e := newExporter(uri, nil)
+		e.client = client
+		e.policy = policy
+		if err := e.configure(config.Target{URI: uri, HTTPConfig: module.HTTPConfig}); err != nil {
+			http.Error(w, fmt.Sprintf("module settings: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if len(module.Collectors) > 0 {
+			e.collectors = map[string]bool{}
+			for _, name := range module.Collectors {
+				e.collectors[name] = true
+			}
+		}
+		// Within those of the module, if it has any.
+		for name := range e.collectors {
+			if requested != nil && !requested[name] {
+				delete(e.collectors, name)
+			}
+		}
+		if e.collectors == nil {
+			e.collectors = requested
+		}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(contextCollector{e, ctx})
+		// Gathered before serving, for a 403 if the target redirected
+		// where it may not.
+		mfs, err := rulesGatherer{reg}.Gather()
+		if err := denial.get(); err != nil {
+			refuseProbe(w, uri, err)
+			return
+		}
+		gathered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return mfs, err })
+		promhttp.HandlerFor(gathered, handlerOpts()).ServeHTTP(w, r)
+	})
+}
+
+func refuseProbe(w http.ResponseWriter, uri string, err error) {
+	deniedProbes.Inc()
+	logger.Debug("Refusing /probe target", "target", sanitizeURI(uri), "err", err)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
+
+func moduleNames(modules map[string]config.Module) string {
+	if len(modules) == 0 {
+		return "none"
+	}
+	var names []string
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// reloadHandler reloads the configuration of s on POST, reporting why the
+// reload failed if it did.
+func reloadHandler(s *targetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "only POST requests trigger a reload", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.reload(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		}
+	})
+}
+
+// scrapeHandler asks b to scrape all targets right away on POST.
+func scrapeHandler(b *backgroundScraper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "only POST requests trigger a scrape", http.StatusMethodNotAllowed)
+			return
+		}
+		b.force()
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+
+	server := httptest.NewServer(metricsHandler(NewExporter(backend.URL)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	for _, want := range []string{"apache_up 1", "apache_accesses_total 1", "go_goroutines"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected %q in exposition", want)
+		}
+	}
+}
+
+// brokenScraper serves apache_up along with a metric that can't be
+// gathered.
+type brokenScraper struct{}
+
+var brokenDesc = prometheus.NewDesc("apache_broken", "A metric failing to be gathered.", nil, nil)
+
+func (brokenScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- brokenDesc
+}
+
+func (s brokenScraper) Collect(ch chan<- prometheus.Metric) {
+	s.collectContext(context.Background(), ch)
+}
+
+func (brokenScraper) collectContext(_ context.Context, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("apache_up", "Up.", nil, nil), prometheus.GaugeValue, 1)
+	ch <- prometheus.NewInvalidMetric(brokenDesc, fmt.Errorf("broken"))
+}
+
+func TestMetricsHandlerErrorHandling(t *testing.T) {
+	defer func(old string) { *errorHandling = old }(*errorHandling)
+	for _, tc := range []struct {
+		mode string
+		code int
+	}{
+		{"http-error", http.StatusInternalServerError},
+		{"continue", http.StatusOK},
+	} {
+		*errorHandling = tc.mode
+		rr := httptest.NewRecorder()
+		metricsHandler(brokenScraper{}).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		if rr.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.mode, tc.code, rr.Code)
+		}
+		if tc.mode == "continue" && !strings.Contains(rr.Body.String(), "apache_up 1") {
+			t.Errorf("%s: expected the other metrics in\n%s", tc.mode, rr.Body)
+		}
+	}
+	if err := validateErrorHandling("panic"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestMetricsHandlerContentType(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	h := metricsHandler(NewExporter(backend.URL))
+
+	for _, tc := range []struct {
+		accept, contentType, accesses string
+	}{
+		{"", "text/plain; version=0.0.4", "# TYPE apache_accesses_total counter\napache_accesses_total 1\n"},
+		{"text/plain;version=0.0.4", "text/plain; version=0.0.4", "# TYPE apache_accesses_total counter\napache_accesses_total 1\n"},
+		{"application/openmetrics-text; version=1.0.0,text/plain;version=0.0.4;q=0.5", "application/openmetrics-text; version=1.0.0", "# TYPE apache_accesses counter\napache_accesses_total 1.0\n"},
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("Accept %q: expected %s, got %s", tc.accept, tc.contentType, ct)
+		}
+		if !strings.Contains(rr.Body.String(), tc.accesses) {
+			t.Errorf("Accept %q: expected %q in\n%s", tc.accept, tc.accesses, rr.Body)
+		}
+		if openMetrics := strings.HasSuffix(rr.Body.String(), "# EOF\n"); openMetrics != strings.HasPrefix(tc.contentType, "application/openmetrics-text") {
+			t.Errorf("Accept %q: unexpected end of\n%s", tc.accept, rr.Body)
+		}
+	}
+}
+
+func TestDisableExporterMetrics(t *testing.T) {
+	defer func(old bool) { *disableExporterMetrics = old }(*disableExporterMetrics)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+	h := metricsHandler(NewExporter(backend.URL))
+
+	families := func() map[string]bool {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		names := map[string]bool{}
+		for _, line := range strings.Split(rr.Body.String(), "\n") {
+			if strings.HasPrefix(line, "# TYPE ") {
+				names[strings.Fields(line)[2]] = true
+			}
+		}
+		return names
+	}
+	with := families()
+	*disableExporterMetrics = true
+	without := families()
+
+	for name := range with {
+		exporterMetric := strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_")
+		if exporterMetric && without[name] {
+			t.Errorf("expected %s to be left out", name)
+		}
+		if !exporterMetric && strings.HasPrefix(name, "apache_") && !without[name] {
+			t.Errorf("expected %s to be kept", name)
+		}
+	}
+	for _, name := range []string{"go_goroutines", "process_cpu_seconds_total"} {
+		if !with[name] {
+			t.Errorf("expected %s by default", name)
+		}
+	}
+	for name := range without {
+		if !with[name] {
+			t.Errorf("unexpected %s only with exporter metrics disabled", name)
+		}
+	}
+}
+
+func TestMetricsHandlerCancelsScrape(t *testing.T) {
+	cancelled := make(chan struct{})
+	var requests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the first scrape is answered.
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Write([]byte(apache24Status))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	e := NewExporter(backend.URL)
+	if err := e.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(metricsHandler(e))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected the metrics request to time out")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend request was not cancelled after the client went away")
+	}
+
+	// Wait for the cancelled scrape to finish.
+	e.flightMutex.Lock()
+	f := e.flight
+	e.flightMutex.Unlock()
+	<-f.done
+	if !e.last.up() {
+		t.Errorf("expected apache_up to stay 1 after a cancelled scrape")
+	}
+	for _, reason := range []string{reasonRequest, reasonTimeout} {
+		if v := counterValue(t, e.scrapeFailures.WithLabelValues(reason)); v != 0 {
+			t.Errorf("expected no %s failures after a cancelled scrape, got %v", reason, v)
+		}
+	}
+}
+
+func probe(t *testing.T, server *httptest.Server, target string) (int, string) {
+	return probeModule(t, server, target, "")
+}
+
+func probeModule(t *testing.T, server *httptest.Server, target, module string) (int, string) {
+	params := url.Values{"target": {target}}
+	if module != "" {
+		params.Set("module", module)
+	}
+	resp, err := http.Get(server.URL + "/probe?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestProbeHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache22Status))
+	}))
+	defer backend.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/probe", probeHandler(newHTTPClient(clientConfig{timeout: time.Second}), nil, testDefaults, func() map[string]config.Module { return nil }))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	code, body := probe(t, server, strings.TrimPrefix(backend.URL, "http://"))
+	if code != http.StatusOK {
+		t.Fatalf("good target: expected 200, got %d: %s", code, body)
+	}
+	for _, want := range []string{"apache_up 1", "apache_accesses_total 302311"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("good target: expected %q in exposition", want)
+		}
+	}
+	for _, unwanted := range []string{"go_goroutines", "target="} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("good target: unexpected %q in exposition", unwanted)
+		}
+	}
+
+	code, body = probe(t, server, "http://127.0.0.1:1/server-status?auto")
+	if code != http.StatusOK || !strings.Contains(body, "apache_up 0") {
+		t.Errorf("unreachable target: expected 200 with apache_up 0, got %d: %s", code, body)
+	}
+
+	for _, garbage := range []string{"", "ftp://web01/", "http://[::1/server-status", "http:///status"} {
+		if code, body := probe(t, server, garbage); code != http.StatusBadRequest {
+			t.Errorf("target %q: expected 400, got %d: %s", garbage, code, body)
+		}
+	}
+}
+
+func TestProbeModules(t *testing.T) {
+	var paths, auths []string
+	var mutex sync.Mutex
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		paths = append(paths, r.URL.RequestURI())
+		auths = append(auths, r.Header.Get("Authorization"))
+		mutex.Unlock()
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+
+	cfg, err := config.Load([]byte(`
+modules:
+  status-basic:
+    collectors: [accesses, traffic]
+  behind-sso:
+    path: /status
+    collectors: [workers]
+    bearer_token: t0ken
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/probe", probeHandler(newHTTPClient(clientConfig{timeout: time.Second}), nil, testDefaults, func() map[string]config.Module { return cfg.Modules }))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	for _, tc := range []struct {
+		module, path, auth string
+		want, unwanted     []string
+	}{
+		{"", "/server-status?auto", "", []string{"apache_accesses_total", "apache_workers"}, nil},
+		{"status-basic", "/server-status?auto", "", []string{"apache_up 1", "apache_accesses_total", "apache_sent_kilobytes_total"}, []string{"apache_workers", "apache_uptime_seconds_total"}},
+		{"behind-sso", "/status?auto", "Bearer t0ken", []string{"apache_up 1", `apache_workers{state="busy"}`}, []string{"apache_accesses_total", "apache_sent_kilobytes_total"}},
+	} {
+		code, body := probeModule(t, server, host, tc.module)
+		if code != http.StatusOK {
+			t.Fatalf("module %q: expected 200, got %d: %s", tc.module, code, body)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("module %q: expected %q in exposition", tc.module, want)
+			}
+		}
+		for _, unwanted := range tc.unwanted {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("module %q: unexpected %q in exposition", tc.module, unwanted)
+			}
+		}
+		mutex.Lock()
+		path, auth := paths[len(paths)-1], auths[len(auths)-1]
+		mutex.Unlock()
+		if path != tc.path || auth != tc.auth {
+			t.Errorf("module %q: expected request for %s with auth %q, got %s with %q", tc.module, tc.path, tc.auth, path, auth)
+		}
+	}
+
+	code, body := probeModule(t, server, host, "status-with-balancer")
+	if code != http.StatusBadRequest || !strings.Contains(body, "valid modules are: behind-sso, status-basic") {
+		t.Errorf("unknown module: expected 400 listing the modules, got %d: %s", code, body)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apache24Status))
+	}))
+	defer backend.Close()
+
+	var loads, broken int32
+	load := func() (Exporters, error) {
+		atomic.AddInt32(&loads, 1)
+		if atomic.LoadInt32(&broken) == 1 {
+			return nil, fmt.Errorf("bad config")
+		}
+		return setupExporters([]string{"a=" + backend.URL, "b=" + backend.URL}, testDefaults, false)
+	}
+	es, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTargetSet(es, load)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler(s))
+	mux.Handle("/-/reload", reloadHandler(s))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func() (int, string) {
+		resp, err := http.Post(server.URL+"/-/reload", "", nil)
+		if err != nil {
+			t.Error(err)
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	resp, err := http.Get(server.URL + "/-/reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || atomic.LoadInt32(&loads) != 1 {
+		t.Errorf("expected GET to be refused without reloading, got %d after %d loads", resp.StatusCode, loads)
+	}
+
+	// Reload while scraping.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if code, body := post(); code != http.StatusOK {
+				t.Errorf("expected reload to succeed, got %d: %s", code, body)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL + "/metrics")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if !strings.Contains(string(body), `apache_up{target="b"} 1`) {
+				t.Errorf("expected target b in exposition during reloads, got %s", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	atomic.StoreInt32(&broken, 1)
+	if code, body := post(); code != http.StatusInternalServerError || !strings.Contains(body, "bad config") {
+		t.Errorf("expected failed reload to report the error, got %d: %s", code, body)
+	}
+	if v := gaugeValue(t, s.lastReloadSuccessful); v != 0 {
+		t.Errorf("expected failed reload to be exported, got %v", v)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosefy/apache_exporter/config"
+)
+
+var (
+	webhookURL          = flag.String("notify.webhook-url", "", "URL to POST a JSON notification to when a target goes down or comes back up.")
+	webhookTemplate     = flag.String("notify.webhook-template", "", "File with a Go text/template for the body of the notifications, given the fields of the JSON ones, such as {{.Target}} and {{.Current}}. The JSON ones are sent if empty.")
+	webhookSecretHeader = flag.String("notify.webhook-secret-header", "Authorization", "Header to send the contents of -notify.webhook-secret-file in.")
+	webhookSecretFile   = flag.String("notify.webhook-secret-file", "", "File holding a secret to send to -notify.webhook-url in -notify.webhook-secret-header, read on every notification.")
+	webhookAfter        = flag.Int("notify.webhook-after", 3, "Scrapes in a row a target has to be up or down for before the change is notified, so that flapping isn't.")
+	webhookRetries      = flag.Int("notify.webhook-retries", 3, "Times a notification that failed to be delivered is tried again, after 1s and twice as long each time.")
+
+	webhookFailures prometheus.Counter
+)
+
+// targetWebhook is where changes in the availability of the targets are
+// notified, nil if -notify.webhook-url isn't set.
+var targetWebhook *webhook
+
+// webhookBackoff is how long to wait before retrying a notification.
+var webhookBackoff = time.Second
+
+func init() {
+	selfMetric(func() {
+		webhookFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_webhook_failures_total",
+			Help:        "Number of notifications that couldn't be delivered to -notify.webhook-url, retries included.",
+			ConstLabels: withConstLabels(nil),
+		})
+	})
+}
+
+// availabilityChange is a notification of a target going down or coming
+// back up.
+type availabilityChange struct {
+	Target   string    `json:"target"`
+	Previous string    `json:"previous"` // up, down or unknown.
+	Current  string    `json:"current"`
+	Error    string    `json:"error"` // Of the last scrape, if down.
+	Time     time.Time `json:"time"`
+}
+
+// availability tells when a target went down or came back up, once it has
+// been so for after scrapes in a row.
+type availability struct {
+	mutex   sync.Mutex
+	after   int
+	state   string // Settled on, unknown at first.
+	pending string // Seen instead of state in the latest scrapes.
+	count   int    // Of the scrapes in pending.
+}
+
+func newAvailability(after int) *availability {
+	return &availability{after: after, state: "unknown"}
+}
+
+// observe takes in the outcome of a scrape, returning the previous and the
+// new state if that made the target settle on a new one. Coming up for the
+// first time isn't a change worth notifying.
+func (a *availability) observe(err error) (string, string, bool) {
+	current := "up"
+	if err != nil {
+		current = "down"
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if current == a.state {
+		a.pending, a.count = "", 0
+		return "", "", false
+	}
+	if current != a.pending {
+		a.pending, a.count = current, 0
+	}
+	if a.count++; a.count < a.after {
+		return "", "", false
+	}
+	previous := a.state
+	a.state, a.pending, a.count = current, "", 0
+	return previous, current, previous != "unknown" || current == "down"
+}
+
+// webhook delivers notifications to a URL, one at a time and in order.
+type webhook struct {
+	url          string
+	client       *http.Client
+	template     *template.Template // Of the body, JSON if nil.
+	secretHeader string
+	secretFile   string
+	after        int
+	retries      int
+	queue        chan availabilityChange
+}
+
+func validateWebhook(rawurl string, after, retries int) error {
+	if rawurl == "" {
+		return nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-notify.webhook-url %q must be an http or https URL", sanitizeURI(rawurl))
+	}
+	if after < 1 {
+		return fmt.Errorf("-notify.webhook-after must be at least 1, got %d", after)
+	}
+	if retries < 0 {
+		return fmt.Errorf("-notify.webhook-retries can't be negative, got %d", retries)
+	}
+	return nil
+}
+
+// webhookFromFlags returns the webhook -notify.webhook-url asks for, nil if
+// none.
+func webhookFromFlags() (*webhook, error) {
+	if err := validateWebhook(*webhookURL, *webhookAfter, *webhookRetries); err != nil || *webhookURL == "" {
+		return nil, err
+	}
+	w := newWebhook(*webhookURL, *webhookAfter, *webhookRetries, newHTTPClient(clientConfigFromFlags()))
+	w.secretHeader, w.secretFile = *webhookSecretHeader, *webhookSecretFile
+	if *webhookTemplate != "" {
+		data, err := config.ReadFile(*webhookTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if w.template, err = template.New("webhook").Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("invalid -notify.webhook-template: %v", err)
+		}
+	}
+	return w, nil
+}
+
+func newWebhook(rawurl string, after, retries int, client *http.Client) *webhook {
+	return &webhook{url: rawurl, client: client, after: after, retries: retries, queue: make(chan availabilityChange, 100)}
+}
+
+// notify queues c for delivery, dropping it if the queue is full.
+func (w *webhook) notify(c availabilityChange) {
+	select {
+	case w.queue <- c:
+	default:
+		webhookFailures.Inc()
+		logger.Error("Dropping a notification, too many are waiting to be delivered", "target", c.Target, "current", c.Current)
+	}
+}
+
+// run delivers the notifications queued until done is closed.
+func (w *webhook) run(done <-chan struct{}) {
+	for {
+		select {
+		case c := <-w.queue:
+			w.deliver(c, done)
+		case <-done:
+			return
+		}
+	}
+}
+
+// deliver sends c, retrying with backoff, unless done is closed.
+func (w *webhook) deliver(c availabilityChange, done <-chan struct{}) {
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		err := w.send(c)
+		if err == nil {
+			return
+		}
+		webhookFailures.Inc()
+		if attempt == w.retries {
+			logger.Error("Error delivering a notification, giving up", "url", sanitizeURI(w.url), "target", c.Target, "current", c.Current, "err", err)
+			return
+		}
+		logger.Warn("Error delivering a notification", "url", sanitizeURI(w.url), "retry_in", backoff, "err", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (w *webhook) send(c availabilityChange) error {
+	var body bytes.Buffer
+	if w.template != nil {
+		if err := w.template.Execute(&body, c); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&body).Encode(c); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secretFile != "" {
+		secret, err := config.ReadFile(w.secretFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(w.secretHeader, strings.TrimSpace(string(secret)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// notifyAvailability takes in the outcome of the scrape of e started at,
+// notifying targetWebhook if e settled on being up or down.
+func (e *Exporter) notifyAvailability(at time.Time, err error) {
+	previous, current, ok := e.availability.observe(err)
+	if !ok {
+		return
+	}
+	c := availabilityChange{Target: e.name, Previous: previous, Current: current, Time: at}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	e.logger.Info("Target availability changed", "previous", previous, "current", current)
+	targetWebhook.notify(c)
+}
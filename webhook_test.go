@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestAvailability(t *testing.T) {
+	down := errors.New("connection refused")
+	for _, c := range []struct {
+		name     string
+		scrapes  []error
+		notified []string // As previous>current, by scrape.
+	}{
+		{"coming up", []error{nil, nil, nil}, []string{"", "", ""}},
+		{"down from the start", []error{down, down}, []string{"", "unknown>down"}},
+		{"flapping", []error{nil, nil, down, nil, down, nil, nil}, []string{"", "", "", "", "", "", ""}},
+		{"down and up", []error{nil, nil, down, down, down, nil, nil}, []string{"", "", "", "up>down", "", "", "down>up"}},
+	} {
+		a := newAvailability(2)
+		for i, err := range c.scrapes {
+			previous, current, ok := a.observe(err)
+			got := ""
+			if ok {
+				got = previous + ">" + current
+			}
+			if got != c.notified[i] {
+				t.Errorf("%s, scrape %d: expected %q, got %q", c.name, i+1, c.notified[i], got)
+			}
+		}
+	}
+}
+
+// webhookReceiver records the notifications posted to it, failing the
+// first fail.
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	fail     int
+	attempts int
+	received []availabilityChange
+	secret   string
+	bodies   []string
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.attempts++
+	if rc.fail > 0 {
+		rc.fail--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var c availabilityChange
+	json.Unmarshal(body, &c)
+	rc.received = append(rc.received, c)
+	rc.bodies = append(rc.bodies, string(body))
+	rc.secret = r.Header.Get("X-Secret")
+}
+
+// wait returns the notifications received once there are n, or after a
+// second.
+func (rc *webhookReceiver) wait(n int) []availabilityChange {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		rc.mutex.Lock()
+		if len(rc.received) >= n {
+			rc.mutex.Unlock()
+			break
+		}
+		rc.mutex.Unlock()
+	}
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return append([]availabilityChange(nil), rc.received...)
+}
+
+func TestWebhook(t *testing.T) {
+	defer func() { targetWebhook = nil }()
+	if e := NewExporter("http://localhost/server-status?auto"); e.availability != nil {
+		t.Error("expected nothing to be tracked without -notify.webhook-url")
+	}
+
+	dir, err := ioutil.TempDir("", "apache_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	receiver := &webhookReceiver{}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+	targetWebhook = newWebhook(hook.URL, 2, 0, http.DefaultClient)
+	targetWebhook.secretHeader, targetWebhook.secretFile = "X-Secret", secretFile
+	done := make(chan struct{})
+	defer close(done)
+	go targetWebhook.run(done)
+
+	var down int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(apache24Status))
+	}))
+	defer ts.Close()
+	e := NewExporter(ts.URL)
+	e.name = "web01"
+	for _, d := range []int32{0, 0, 1, 0, 1, 0, 1, 1, 1, 0, 0} {
+		atomic.StoreInt32(&down, d)
+		e.Warmup()
+	}
+
+	got := receiver.wait(2)
+	if len(got) != 2 {
+		t.Fatalf("expected a notification of web01 going down and one of it coming back, got %+v", got)
+	}
+	if c := got[0]; c.Target != "web01" || c.Previous != "up" || c.Current != "down" || !strings.Contains(c.Error, "503") || c.Time.IsZero() {
+		t.Errorf("expected web01 to go down with the last error, got %+v", c)
+	}
+	if c := got[1]; c.Previous != "down" || c.Current != "up" || c.Error != "" {
+		t.Errorf("expected web01 to come back up, got %+v", c)
+	}
+	if receiver.secret != "s3cret" {
+		t.Errorf("expected the secret in the header, got %q", receiver.secret)
+	}
+	for _, field := range []string{`"target"`, `"previous"`, `"current"`, `"error"`, `"time"`} {
+		if !strings.Contains(receiver.bodies[0], field) {
+			t.Errorf("expected %s in %s", field, receiver.bodies[0])
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+	receiver := &webhookReceiver{fail: 2}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+	c := availabilityChange{Target: "web01", Previous: "up", Current: "down", Time: time.Now()}
+	done := make(chan struct{})
+	defer close(done)
+
+	failures := counterValue(t, webhookFailures)
+	newWebhook(hook.URL, 1, 3, http.DefaultClient).deliver(c, done)
+	if n := len(receiver.wait(1)); n != 1 || receiver.attempts != 3 {
+		t.Errorf("expected delivery on the third attempt, got %d after %d", n, receiver.attempts)
+	}
+	if v := counterValue(t, webhookFailures) - failures; v != 2 {
+		t.Errorf("expected 2 failed deliveries, got %v", v)
+	}
+
+	receiver.fail = 5
+	newWebhook(hook.URL, 1, 1, http.DefaultClient).deliver(c, done)
+	if n := len(receiver.wait(1)); n != 1 || receiver.attempts != 5 {
+		t.Errorf("expected to give up after a retry, got %d after %d attempts", n, receiver.attempts)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	receiver := &webhookReceiver{}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+	w := newWebhook(hook.URL, 1, 0, http.DefaultClient)
+	w.template = template.Must(template.New("webhook").Parse(`{"text": "{{.Target}} is {{.Current}}"}`))
+	if err := w.send(availabilityChange{Target: "web01", Current: "down"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"text": "web01 is down"}`; receiver.bodies[0] != want {
+		t.Errorf("expected %s, got %s", want, receiver.bodies[0])
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	for _, c := range []struct {
+		url            string
+		after, retries int
+		ok             bool
+	}{
+		{"", 0, -1, true},
+		{"https://hooks.example.com/apache", 3, 3, true},
+		{"hooks.example.com", 3, 3, false},
+		{"https://hooks.example.com/apache", 0, 3, false},
+		{"https://hooks.example.com/apache", 3, -1, false},
+	} {
+		if err := validateWebhook(c.url, c.after, c.retries); (err == nil) != c.ok {
+			t.Errorf("%q, %d, %d: expected ok %v, got %v", c.url, c.after, c.retries, c.ok, err)
+		}
+	}
+}